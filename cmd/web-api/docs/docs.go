@@ -226,13 +226,13 @@ var doc = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Filter string, example: name = 'Moon Launch'",
-                        "name": "where",
+                        "description": "Filter by field with an optional operator, example: filter[name][contains]=moon&filter[status]=active. Fields: id, name, status, created_at, updated_at, archived_at. Operators: eq, ne, contains, starts_with, in, gt, gte, lt, lte, null",
+                        "name": "filter[field][operator]",
                         "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Order columns separated by comma, example: created_at desc",
+                        "description": "Order columns separated by comma, example: created_at desc. Fields: id, name, status, created_at, updated_at",
                         "name": "order",
                         "in": "query"
                     },
@@ -248,6 +248,18 @@ var doc = `{
                         "name": "offset",
                         "in": "query"
                     },
+                    {
+                        "type": "string",
+                        "description": "Cursor returned by the previous page in next_cursor, the order can't be changed when paging",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include the total number of results in the X-Total-Count header, example: false",
+                        "name": "include-total",
+                        "in": "query"
+                    },
                     {
                         "type": "boolean",
                         "description": "Included Archived, example: false",
@@ -257,12 +269,9 @@ var doc = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "data contains the list of project.ProjectResponse",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/project.ProjectResponse"
-                            }
+                            "$ref": "#/definitions/web.PageResponse"
                         }
                     },
                     "400": {
@@ -623,13 +632,13 @@ var doc = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Filter string, example: account_id = 'c4653bf9-5978-48b7-89c5-95704aebb7e2'",
-                        "name": "where",
+                        "description": "Filter by field with an optional operator, example: filter[status]=active. Fields: user_id, account_id, status, created_at, updated_at, archived_at. Operators: eq, ne, contains, starts_with, in, gt, gte, lt, lte, null",
+                        "name": "filter[field][operator]",
                         "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Order columns separated by comma, example: created_at desc",
+                        "description": "Order columns separated by comma, example: created_at desc. Fields: user_id, account_id, status, created_at, updated_at",
                         "name": "order",
                         "in": "query"
                     },
@@ -645,6 +654,18 @@ var doc = `{
                         "name": "offset",
                         "in": "query"
                     },
+                    {
+                        "type": "string",
+                        "description": "Cursor returned by the previous page in next_cursor, the order can't be changed when paging",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include the total number of results in the X-Total-Count header, example: false",
+                        "name": "include-total",
+                        "in": "query"
+                    },
                     {
                         "type": "boolean",
                         "description": "Included Archived, example: false",
@@ -654,12 +675,9 @@ var doc = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "data contains the list of user_account.UserAccountResponse",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/user_account.UserAccountResponse"
-                            }
+                            "$ref": "#/definitions/web.PageResponse"
                         }
                     },
                     "400": {
@@ -973,13 +991,13 @@ var doc = `{
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Filter string, example: name = 'Company Name' and email = 'gabi.may@geeksinthewoods.com'",
-                        "name": "where",
+                        "description": "Filter by field with an optional operator, example: filter[email][eq]=gabi.may@geeksinthewoods.com. Fields: id, first_name, last_name, email, timezone, created_at, updated_at, archived_at. Operators: eq, ne, contains, starts_with, in, gt, gte, lt, lte, null",
+                        "name": "filter[field][operator]",
                         "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Order columns separated by comma, example: created_at desc",
+                        "description": "Order columns separated by comma, example: created_at desc. Fields: id, first_name, last_name, email, created_at, updated_at",
                         "name": "order",
                         "in": "query"
                     },
@@ -995,6 +1013,18 @@ var doc = `{
                         "name": "offset",
                         "in": "query"
                     },
+                    {
+                        "type": "string",
+                        "description": "Cursor returned by the previous page in next_cursor, the order can't be changed when paging",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Include the total number of results in the X-Total-Count header, example: false",
+                        "name": "include-total",
+                        "in": "query"
+                    },
                     {
                         "type": "boolean",
                         "description": "Included Archived, example: false",
@@ -1004,12 +1034,9 @@ var doc = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "data contains the list of user.UserResponse",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/user.UserResponse"
-                            }
+                            "$ref": "#/definitions/web.PageResponse"
                         }
                     },
                     "400": {
@@ -2022,6 +2049,18 @@ var doc = `{
                 }
             }
         },
+        "web.PageResponse": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "object"
+                },
+                "next_cursor": {
+                    "type": "string",
+                    "example": "eyJvIjpbImlkIGFzYyJdLCJ2IjpbIjk4NWYxNzQ2Il19"
+                }
+            }
+        },
         "web.TimeResponse": {
             "type": "object",
             "properties": {
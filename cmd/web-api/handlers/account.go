@@ -8,6 +8,7 @@ import (
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/account"
 	accountref "geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/closure"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
@@ -20,6 +21,7 @@ import (
 // Account represents the Account API method handler set.
 type Accounts struct {
 	Repository AccountRepository
+	Closure    ClosureRepository
 
 	// ADD OTHER STATE LIKE THE LOGGER AND CONFIG HERE.
 }
@@ -34,6 +36,14 @@ type AccountRepository interface {
 	Archive(ctx context.Context, claims auth.Claims, req account.AccountArchiveRequest, now time.Time) error
 	Delete(ctx context.Context, claims auth.Claims, req account.AccountDeleteRequest) error
 }
+type ClosureRepository interface {
+	ExportAccount(ctx context.Context, claims auth.Claims, accountID string, now time.Time) ([]byte, error)
+	ExportUser(ctx context.Context, claims auth.Claims, userID string, now time.Time) ([]byte, error)
+	CloseAccount(ctx context.Context, claims auth.Claims, req closure.CloseAccountRequest, now time.Time) (string, error)
+	CloseUser(ctx context.Context, claims auth.Claims, req closure.CloseUserRequest, now time.Time) (string, error)
+	Restore(ctx context.Context, req closure.RestoreRequest, now time.Time) (*closure.RestoreHash, error)
+}
+
 type AccountPrefRepository interface {
 	Find(ctx context.Context, claims auth.Claims, req accountref.AccountPreferenceFindRequest) ([]*accountref.AccountPreference, error)
 	FindByAccountID(ctx context.Context, claims auth.Claims, req accountref.AccountPreferenceFindByAccountIDRequest) ([]*accountref.AccountPreference, error)
@@ -141,3 +151,86 @@ func (h *Accounts) Update(ctx context.Context, w http.ResponseWriter, r *http.Re
 
 	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
 }
+
+// Export godoc
+// @Summary Export the current account
+// @Description Export returns a zip file with the users, memberships, projects and preferences of the current account as JSON and CSV.
+// @Tags account
+// @Produce  application/zip
+// @Security OAuth2Password
+// @Success 200 {file} file
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /accounts/export [get]
+func (h *Accounts) Export(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	dat, err := h.Closure.ExportAccount(ctx, claims, claims.Audience, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case account.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case account.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		default:
+			return errors.Wrapf(err, "ID: %s", claims.Audience)
+		}
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=\"account-"+claims.Audience+".zip\"")
+
+	return web.Respond(ctx, w, dat, http.StatusOK, web.MIMEApplicationZip)
+}
+
+// Close godoc
+// @Summary Close the current account
+// @Description Close archives the current account and emails the owner a link to restore it before the account is permanently deleted.
+// @Tags account
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /accounts/close [post]
+func (h *Accounts) Close(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.Closure.CloseAccount(ctx, claims, closure.CloseAccountRequest{AccountID: claims.Audience}, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case closure.ErrForbidden, account.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case account.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "ID: %s", claims.Audience)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
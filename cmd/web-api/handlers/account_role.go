@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_role"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// AccountRoles represents the AccountRole API method handler set.
+type AccountRoles struct {
+	Repository AccountRoleRepository
+
+	// ADD OTHER STATE LIKE THE LOGGER IF NEEDED.
+}
+
+type AccountRoleRepository interface {
+	ReadByID(ctx context.Context, claims auth.Claims, id string) (*account_role.AccountRole, error)
+	Find(ctx context.Context, claims auth.Claims, req account_role.AccountRoleFindRequest) (account_role.AccountRoles, error)
+	Count(ctx context.Context, claims auth.Claims, req account_role.AccountRoleFindRequest) (int, error)
+	FindByAccountID(ctx context.Context, claims auth.Claims, accountID string) (account_role.AccountRoles, error)
+	Read(ctx context.Context, claims auth.Claims, req account_role.AccountRoleReadRequest) (*account_role.AccountRole, error)
+	Create(ctx context.Context, claims auth.Claims, req account_role.AccountRoleCreateRequest, now time.Time) (*account_role.AccountRole, error)
+	Update(ctx context.Context, claims auth.Claims, req account_role.AccountRoleUpdateRequest, now time.Time) error
+	Archive(ctx context.Context, claims auth.Claims, req account_role.AccountRoleArchiveRequest, now time.Time) error
+	Delete(ctx context.Context, claims auth.Claims, req account_role.AccountRoleDeleteRequest) error
+}
+
+// Find godoc
+// @Summary List account roles
+// @Description Find returns the custom roles defined for the account.
+// @Tags role
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param filter[field][operator]	query string 	false	"Filter by field with an optional operator, example: filter[name][starts_with]=bill. Fields: id, name, created_at, updated_at, archived_at. Operators: eq, ne, contains, starts_with, in, gt, gte, lt, lte, null"
+// @Param order				query string   	false 	"Order columns separated by comma, example: created_at desc. Fields: id, name, created_at, updated_at"
+// @Param limit				query integer  	false 	"Limit, example: 10"
+// @Param offset			query integer  	false 	"Offset, example: 20"
+// @Param cursor			query string  	false 	"Cursor returned by the previous page in next_cursor, the order can't be changed when paging"
+// @Param include-total	query boolean 	false 	"Include the total number of results in the X-Total-Count header, example: false"
+// @Param include-archived query boolean 	false 	"Included Archived, example: false"
+// @Success 200 {object} web.PageResponse "data contains the list of account_role.AccountRoleResponse"
+// @Header 200 {string} Link "Links to the next and first pages"
+// @Header 200 {string} X-Next-Cursor "Cursor for the next page, only set when there are more results"
+// @Header 200 {string} X-Total-Count "Total number of results, only set when include-total is true"
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /roles [get]
+func (h *AccountRoles) Find(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req account_role.AccountRoleFindRequest
+
+	// Handle filter query values if set.
+	filters, err := filter.Parse(r.URL.Query(), account_role.AccountRoleFindFields)
+	if err != nil {
+		return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+	}
+	req.Filter = filters
+
+	// Handle order query value if set.
+	if v := r.URL.Query().Get("order"); v != "" {
+		order, err := account_role.AccountRoleFindFields.Order(strings.Split(v, ","))
+		if err != nil {
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		req.Order = order
+	}
+
+	// Include the key fields in the order so the rows have a stable order for cursor pagination.
+	req.Order = account_role.AccountRoleFindFields.KeyOrder(req.Order)
+
+	// Handle limit query value if set.
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as int for limit param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		ul := uint(l)
+		req.Limit = &ul
+	}
+
+	// Handle offset query value if set.
+	if v := r.URL.Query().Get("offset"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as int for offset param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		ul := uint(l)
+		req.Offset = &ul
+	}
+
+	// Handle cursor query value if set.
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		req.Cursor = v
+	}
+
+	// Handle include-total query value if set.
+	var includeTotal bool
+	if v := r.URL.Query().Get("include-total"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for include-total param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		includeTotal = b
+	}
+
+	// Handle include-archive query value if set.
+	if v := r.URL.Query().Get("include-archived"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for include-archived param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		req.IncludeArchived = b
+	}
+
+	// Fetch one more row than the limit to determine if there is a next page.
+	limit := req.Limit
+	req.Limit = filter.FetchLimit(limit)
+
+	res, err := h.Repository.Find(ctx, claims, req)
+	if err != nil {
+		return err
+	}
+
+	var total *int
+	if includeTotal {
+		cnt, err := h.Repository.Count(ctx, claims, req)
+		if err != nil {
+			return err
+		}
+		total = &cnt
+	}
+
+	nextCursor, more, err := account_role.AccountRoleFindFields.NextPage(req.Order, limit, &res)
+	if err != nil {
+		return err
+	}
+
+	web.SetPageHeaders(w, r, more, limit, req.Offset, nextCursor, total)
+
+	return web.RespondJson(ctx, w, web.NewPageResponse(res.Response(ctx), nextCursor), http.StatusOK)
+}
+
+// Read godoc
+// @Summary Get account role by ID.
+// @Description Read returns the specified account role from the system.
+// @Tags role
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param id path string true "Role ID"
+// @Success 200 {object} account_role.AccountRoleResponse
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /roles/{id} [get]
+func (h *AccountRoles) Read(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Handle include-archived query value if set.
+	var includeArchived bool
+	if v := r.URL.Query().Get("include-archived"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for include-archived param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		includeArchived = b
+	}
+
+	res, err := h.Repository.Read(ctx, claims, account_role.AccountRoleReadRequest{
+		ID:              params["id"],
+		IncludeArchived: includeArchived,
+	})
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case account_role.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+			return errors.Wrapf(err, "ID: %s", params["id"])
+		}
+	}
+
+	return web.RespondJson(ctx, w, res.Response(ctx), http.StatusOK)
+}
+
+// Create godoc
+// @Summary Create new account role.
+// @Description Create inserts a new custom role for the account.
+// @Tags role
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body account_role.AccountRoleCreateRequest true "Role details"
+// @Success 201 {object} account_role.AccountRoleResponse
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /roles [post]
+func (h *AccountRoles) Create(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req account_role.AccountRoleCreateRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	res, err := h.Repository.Create(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case account_role.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case account_role.ErrReservedName:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+			return errors.Wrapf(err, "AccountRole: %+v", &req)
+		}
+	}
+
+	return web.RespondJson(ctx, w, res.Response(ctx), http.StatusCreated)
+}
+
+// Update godoc
+// @Summary Update account role by ID
+// @Description Update updates the specified account role in the system. Renaming a role updates the users assigned to it.
+// @Tags role
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body account_role.AccountRoleUpdateRequest true "Update fields"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /roles [patch]
+func (h *AccountRoles) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req account_role.AccountRoleUpdateRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	err = h.Repository.Update(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case account_role.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case account_role.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case account_role.ErrReservedName:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "ID: %s Update: %+v", req.ID, req)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// Archive godoc
+// @Summary Archive account role by ID
+// @Description Archive soft-deletes the specified account role and removes it from the users assigned to it.
+// @Tags role
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body account_role.AccountRoleArchiveRequest true "Update fields"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /roles/archive [patch]
+func (h *AccountRoles) Archive(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req account_role.AccountRoleArchiveRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	err = h.Repository.Archive(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case account_role.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case account_role.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "Id: %s", req.ID)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete godoc
+// @Summary Delete account role by ID
+// @Description Delete removes the specified account role and removes it from the users assigned to it.
+// @Tags role
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param id path string true "Role ID"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /roles/{id} [delete]
+func (h *AccountRoles) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = h.Repository.Delete(ctx, claims,
+		account_role.AccountRoleDeleteRequest{ID: params["id"]})
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case account_role.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case account_role.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "Id: %s", params["id"])
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/api_key"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// ApiKeys represents the ApiKey API method handler set.
+type ApiKeys struct {
+	Repository ApiKeyRepository
+
+	// ADD OTHER STATE LIKE THE LOGGER IF NEEDED.
+}
+
+type ApiKeyRepository interface {
+	ReadByID(ctx context.Context, claims auth.Claims, id string) (*api_key.ApiKey, error)
+	Find(ctx context.Context, claims auth.Claims, req api_key.ApiKeyFindRequest) (api_key.ApiKeys, error)
+	Count(ctx context.Context, claims auth.Claims, req api_key.ApiKeyFindRequest) (int, error)
+	Read(ctx context.Context, claims auth.Claims, req api_key.ApiKeyReadRequest) (*api_key.ApiKey, error)
+	Create(ctx context.Context, claims auth.Claims, req api_key.ApiKeyCreateRequest, now time.Time) (*api_key.ApiKey, error)
+	Update(ctx context.Context, claims auth.Claims, req api_key.ApiKeyUpdateRequest, now time.Time) error
+	Archive(ctx context.Context, claims auth.Claims, req api_key.ApiKeyArchiveRequest, now time.Time) error
+	Delete(ctx context.Context, claims auth.Claims, req api_key.ApiKeyDeleteRequest) error
+}
+
+// Find godoc
+// @Summary List API keys
+// @Description Find returns the personal API keys of the user, all the API keys of the account are returned for users with the api_key:write permission.
+// @Tags api_key
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param filter[field][operator]	query string 	false	"Filter by field with an optional operator, example: filter[type]=account&filter[last_used_at][null]=true. Fields: id, user_id, type, name, prefix, expires_at, last_used_at, created_at, archived_at. Operators: eq, ne, contains, starts_with, in, gt, gte, lt, lte, null"
+// @Param order				query string   	false 	"Order columns separated by comma, example: created_at desc. Fields: id, type, name, created_at"
+// @Param limit				query integer  	false 	"Limit, example: 10"
+// @Param offset			query integer  	false 	"Offset, example: 20"
+// @Param cursor			query string  	false 	"Cursor returned by the previous page in next_cursor, the order can't be changed when paging"
+// @Param include-total	query boolean 	false 	"Include the total number of results in the X-Total-Count header, example: false"
+// @Param include-archived query boolean 	false 	"Included Archived, example: false"
+// @Success 200 {object} web.PageResponse "data contains the list of api_key.ApiKeyResponse"
+// @Header 200 {string} Link "Links to the next and first pages"
+// @Header 200 {string} X-Next-Cursor "Cursor for the next page, only set when there are more results"
+// @Header 200 {string} X-Total-Count "Total number of results, only set when include-total is true"
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /api_keys [get]
+func (h *ApiKeys) Find(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req api_key.ApiKeyFindRequest
+
+	// Handle filter query values if set.
+	filters, err := filter.Parse(r.URL.Query(), api_key.ApiKeyFindFields)
+	if err != nil {
+		return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+	}
+	req.Filter = filters
+
+	// Handle order query value if set.
+	if v := r.URL.Query().Get("order"); v != "" {
+		order, err := api_key.ApiKeyFindFields.Order(strings.Split(v, ","))
+		if err != nil {
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		req.Order = order
+	}
+
+	// Include the key fields in the order so the rows have a stable order for cursor pagination.
+	req.Order = api_key.ApiKeyFindFields.KeyOrder(req.Order)
+
+	// Handle limit query value if set.
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as int for limit param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		ul := uint(l)
+		req.Limit = &ul
+	}
+
+	// Handle offset query value if set.
+	if v := r.URL.Query().Get("offset"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as int for offset param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		ul := uint(l)
+		req.Offset = &ul
+	}
+
+	// Handle cursor query value if set.
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		req.Cursor = v
+	}
+
+	// Handle include-total query value if set.
+	var includeTotal bool
+	if v := r.URL.Query().Get("include-total"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for include-total param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		includeTotal = b
+	}
+
+	// Handle include-archive query value if set.
+	if v := r.URL.Query().Get("include-archived"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for include-archived param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		req.IncludeArchived = b
+	}
+
+	// Fetch one more row than the limit to determine if there is a next page.
+	limit := req.Limit
+	req.Limit = filter.FetchLimit(limit)
+
+	res, err := h.Repository.Find(ctx, claims, req)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case api_key.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, cause, http.StatusForbidden))
+		default:
+			return err
+		}
+	}
+
+	var total *int
+	if includeTotal {
+		cnt, err := h.Repository.Count(ctx, claims, req)
+		if err != nil {
+			return err
+		}
+		total = &cnt
+	}
+
+	nextCursor, more, err := api_key.ApiKeyFindFields.NextPage(req.Order, limit, &res)
+	if err != nil {
+		return err
+	}
+
+	web.SetPageHeaders(w, r, more, limit, req.Offset, nextCursor, total)
+
+	return web.RespondJson(ctx, w, web.NewPageResponse(res.Response(ctx), nextCursor), http.StatusOK)
+}
+
+// Read godoc
+// @Summary Get API key by ID.
+// @Description Read returns the specified API key from the system.
+// @Tags api_key
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param id path string true "API Key ID"
+// @Success 200 {object} api_key.ApiKeyResponse
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /api_keys/{id} [get]
+func (h *ApiKeys) Read(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Handle include-archived query value if set.
+	var includeArchived bool
+	if v := r.URL.Query().Get("include-archived"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for include-archived param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		includeArchived = b
+	}
+
+	res, err := h.Repository.Read(ctx, claims, api_key.ApiKeyReadRequest{
+		ID:              params["id"],
+		IncludeArchived: includeArchived,
+	})
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case api_key.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case api_key.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+			return errors.Wrapf(err, "ID: %s", params["id"])
+		}
+	}
+
+	return web.RespondJson(ctx, w, res.Response(ctx), http.StatusOK)
+}
+
+// Create godoc
+// @Summary Create new API key.
+// @Description Create inserts a new API key for the account. The key is only included in the response of create.
+// @Tags api_key
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body api_key.ApiKeyCreateRequest true "API key details"
+// @Success 201 {object} api_key.ApiKeyResponse
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /api_keys [post]
+func (h *ApiKeys) Create(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req api_key.ApiKeyCreateRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	res, err := h.Repository.Create(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case api_key.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case api_key.ErrInvalidRole, api_key.ErrInvalidScope:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+			return errors.Wrapf(err, "ApiKey: %+v", &req)
+		}
+	}
+
+	return web.RespondJson(ctx, w, res.Response(ctx), http.StatusCreated)
+}
+
+// Update godoc
+// @Summary Update API key by ID
+// @Description Update renames the specified API key, the roles and scopes of a key can't be changed.
+// @Tags api_key
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body api_key.ApiKeyUpdateRequest true "Update fields"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /api_keys [patch]
+func (h *ApiKeys) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req api_key.ApiKeyUpdateRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	err = h.Repository.Update(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case api_key.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case api_key.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "ID: %s Update: %+v", req.ID, req)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// Archive godoc
+// @Summary Archive API key by ID
+// @Description Archive revokes the specified API key, it can no longer be used to authenticate.
+// @Tags api_key
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body api_key.ApiKeyArchiveRequest true "Update fields"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /api_keys/archive [patch]
+func (h *ApiKeys) Archive(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req api_key.ApiKeyArchiveRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	err = h.Repository.Archive(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case api_key.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case api_key.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "Id: %s", req.ID)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete godoc
+// @Summary Delete API key by ID
+// @Description Delete removes the specified API key.
+// @Tags api_key
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param id path string true "API Key ID"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /api_keys/{id} [delete]
+func (h *ApiKeys) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = h.Repository.Delete(ctx, claims,
+		api_key.ApiKeyDeleteRequest{ID: params["id"]})
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case api_key.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case api_key.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "Id: %s", params["id"])
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+
+	"github.com/pkg/errors"
+)
+
+// Audit represents the AuditEvent API method handler set.
+type Audit struct {
+	Repository AuditRepository
+
+	// ADD OTHER STATE LIKE THE LOGGER IF NEEDED.
+}
+
+type AuditRepository interface {
+	Find(ctx context.Context, claims auth.Claims, req audit.AuditEventFindRequest) (audit.AuditEvents, error)
+	Count(ctx context.Context, claims auth.Claims, req audit.AuditEventFindRequest) (int, error)
+}
+
+// Find godoc
+// @Summary List audit events
+// @Description Find returns the changes made to entities for the account.
+// @Tags audit
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param filter[field][operator]	query string 	false	"Filter by field with an optional operator, example: filter[entity_type]=project&filter[action]=update. Fields: id, actor_user_id, root_user_id, entity_type, entity_id, action, request_ip, created_at. Operators: eq, ne, contains, starts_with, in, gt, gte, lt, lte, null"
+// @Param order				query string   	false 	"Order columns separated by comma, example: created_at desc. Fields: entity_type, action, created_at"
+// @Param limit				query integer  	false 	"Limit, example: 10"
+// @Param offset			query integer  	false 	"Offset, example: 20"
+// @Param cursor			query string  	false 	"Cursor returned by the previous page in next_cursor, the order can't be changed when paging"
+// @Param include-total	query boolean 	false 	"Include the total number of results in the X-Total-Count header, example: false"
+// @Success 200 {object} web.PageResponse "data contains the list of audit.AuditEventResponse"
+// @Header 200 {string} Link "Links to the next and first pages"
+// @Header 200 {string} X-Next-Cursor "Cursor for the next page, only set when there are more results"
+// @Header 200 {string} X-Total-Count "Total number of results, only set when include-total is true"
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /audit [get]
+func (h *Audit) Find(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req audit.AuditEventFindRequest
+
+	// Handle filter query values if set.
+	filters, err := filter.Parse(r.URL.Query(), audit.AuditEventFindFields)
+	if err != nil {
+		return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+	}
+	req.Filter = filters
+
+	// Handle order query value if set.
+	if v := r.URL.Query().Get("order"); v != "" {
+		order, err := audit.AuditEventFindFields.Order(strings.Split(v, ","))
+		if err != nil {
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		req.Order = order
+	}
+
+	if len(req.Order) == 0 {
+		req.Order = []string{"created_at desc"}
+	}
+
+	// Include the key fields in the order so the rows have a stable order for cursor pagination.
+	req.Order = audit.AuditEventFindFields.KeyOrder(req.Order)
+
+	// Handle limit query value if set.
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as int for limit param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		ul := uint(l)
+		req.Limit = &ul
+	}
+
+	// Handle offset query value if set.
+	if v := r.URL.Query().Get("offset"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as int for offset param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		ul := uint(l)
+		req.Offset = &ul
+	}
+
+	// Handle cursor query value if set.
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		req.Cursor = v
+	}
+
+	// Handle include-total query value if set.
+	var includeTotal bool
+	if v := r.URL.Query().Get("include-total"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for include-total param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		includeTotal = b
+	}
+
+	// Fetch one more row than the limit to determine if there is a next page.
+	limit := req.Limit
+	req.Limit = filter.FetchLimit(limit)
+
+	res, err := h.Repository.Find(ctx, claims, req)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case audit.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, cause, http.StatusForbidden))
+		default:
+			return err
+		}
+	}
+
+	var total *int
+	if includeTotal {
+		cnt, err := h.Repository.Count(ctx, claims, req)
+		if err != nil {
+			return err
+		}
+		total = &cnt
+	}
+
+	nextCursor, more, err := audit.AuditEventFindFields.NextPage(req.Order, limit, &res)
+	if err != nil {
+		return err
+	}
+
+	web.SetPageHeaders(w, r, more, limit, req.Offset, nextCursor, total)
+
+	return web.RespondJson(ctx, w, web.NewPageResponse(res.Response(ctx), nextCursor), http.StatusOK)
+}
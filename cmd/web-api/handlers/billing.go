@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/billing"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// Billing represents the Billing API method handler set.
+type Billing struct {
+	Repository BillingRepository
+
+	// ADD OTHER STATE LIKE THE LOGGER IF NEEDED.
+}
+
+type BillingRepository interface {
+	FindPlans() billing.Plans
+	ReadSubscription(ctx context.Context, claims auth.Claims, accountID string) (*billing.Subscription, error)
+	CreateSubscription(ctx context.Context, claims auth.Claims, req billing.SubscriptionCreateRequest, now time.Time) (*billing.Subscription, error)
+	UpdateSubscription(ctx context.Context, claims auth.Claims, req billing.SubscriptionUpdateRequest, now time.Time) error
+	CancelSubscription(ctx context.Context, claims auth.Claims, req billing.SubscriptionCancelRequest, now time.Time) error
+	FindInvoices(ctx context.Context, claims auth.Claims, req billing.InvoiceFindRequest) (billing.Invoices, error)
+	CountInvoices(ctx context.Context, claims auth.Claims, req billing.InvoiceFindRequest) (int, error)
+	HandleEvent(ctx context.Context, payload []byte, signature string, now time.Time) error
+}
+
+// Plans godoc
+// @Summary List plans
+// @Description Plans returns the plans accounts can subscribe to.
+// @Tags billing
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Success 200 {array} billing.Plan
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /billing/plans [get]
+func (h *Billing) Plans(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	return web.RespondJson(ctx, w, h.Repository.FindPlans(), http.StatusOK)
+}
+
+// ReadSubscription godoc
+// @Summary Get the subscription of the account.
+// @Description ReadSubscription returns the current subscription of the account for the claims.
+// @Tags billing
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Success 200 {object} billing.SubscriptionResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /billing/subscription [get]
+func (h *Billing) ReadSubscription(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.Repository.ReadSubscription(ctx, claims, claims.Audience)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case billing.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case billing.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			return errors.Wrapf(err, "Account ID: %s", claims.Audience)
+		}
+	}
+
+	return web.RespondJson(ctx, w, res.Response(ctx), http.StatusOK)
+}
+
+// CreateSubscription godoc
+// @Summary Subscribe to a plan.
+// @Description CreateSubscription subscribes the account to a plan. The number of seats is the number of users of the account.
+// @Tags billing
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body billing.SubscriptionCreateRequest true "Subscription details"
+// @Success 201 {object} billing.SubscriptionResponse
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /billing/subscription [post]
+func (h *Billing) CreateSubscription(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req billing.SubscriptionCreateRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	// Default to the account of the claims.
+	if req.AccountID == "" {
+		req.AccountID = claims.Audience
+	}
+
+	res, err := h.Repository.CreateSubscription(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case billing.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case billing.ErrInvalidPlan, billing.ErrSubscriptionExists, billing.ErrSeatLimit:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+			return errors.Wrapf(err, "Subscription: %+v", &req)
+		}
+	}
+
+	return web.RespondJson(ctx, w, res.Response(ctx), http.StatusCreated)
+}
+
+// UpdateSubscription godoc
+// @Summary Change the plan of the subscription.
+// @Description UpdateSubscription changes the plan of the subscription for the account.
+// @Tags billing
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body billing.SubscriptionUpdateRequest true "Update fields"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /billing/subscription [patch]
+func (h *Billing) UpdateSubscription(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req billing.SubscriptionUpdateRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	// Default to the account of the claims.
+	if req.AccountID == "" {
+		req.AccountID = claims.Audience
+	}
+
+	err = h.Repository.UpdateSubscription(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case billing.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case billing.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case billing.ErrInvalidPlan, billing.ErrSeatLimit:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "Account ID: %s Update: %+v", req.AccountID, req)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// CancelSubscription godoc
+// @Summary Cancel the subscription.
+// @Description CancelSubscription cancels the subscription of the account immediately or at the end of the current period.
+// @Tags billing
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body billing.SubscriptionCancelRequest true "Cancel fields"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /billing/subscription/cancel [patch]
+func (h *Billing) CancelSubscription(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req billing.SubscriptionCancelRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	// Default to the account of the claims.
+	if req.AccountID == "" {
+		req.AccountID = claims.Audience
+	}
+
+	err = h.Repository.CancelSubscription(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case billing.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case billing.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "Account ID: %s", req.AccountID)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// Invoices godoc
+// @Summary List invoices
+// @Description Invoices returns the invoices of the account for the claims.
+// @Tags billing
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param filter[field][operator]	query string 	false	"Filter by field with an optional operator, example: filter[status]=paid. Fields: id, subscription_id, number, status, amount_due, period_start, period_end, created_at. Operators: eq, ne, contains, starts_with, in, gt, gte, lt, lte, null"
+// @Param order				query string   	false 	"Order columns separated by comma, example: created_at desc. Fields: id, number, status, amount_due, period_start, period_end, created_at"
+// @Param limit				query integer  	false 	"Limit, example: 10"
+// @Param offset			query integer  	false 	"Offset, example: 20"
+// @Param cursor			query string  	false 	"Cursor returned by the previous page in next_cursor, the order can't be changed when paging"
+// @Param include-total	query boolean 	false 	"Include the total number of results in the X-Total-Count header, example: false"
+// @Success 200 {object} web.PageResponse "data contains the list of billing.InvoiceResponse"
+// @Header 200 {string} Link "Links to the next and first pages"
+// @Header 200 {string} X-Next-Cursor "Cursor for the next page, only set when there are more results"
+// @Header 200 {string} X-Total-Count "Total number of results, only set when include-total is true"
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /billing/invoices [get]
+func (h *Billing) Invoices(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req billing.InvoiceFindRequest
+
+	// Handle filter query values if set.
+	filters, err := filter.Parse(r.URL.Query(), billing.InvoiceFindFields)
+	if err != nil {
+		return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+	}
+	req.Filter = filters
+
+	// Handle order query value if set.
+	req.Order = []string{"created_at desc"}
+	if v := r.URL.Query().Get("order"); v != "" {
+		order, err := billing.InvoiceFindFields.Order(strings.Split(v, ","))
+		if err != nil {
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		req.Order = order
+	}
+
+	// Include the key fields in the order so the rows have a stable order for cursor pagination.
+	req.Order = billing.InvoiceFindFields.KeyOrder(req.Order)
+
+	// Handle limit query value if set.
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as int for limit param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		ul := uint(l)
+		req.Limit = &ul
+	}
+
+	// Handle offset query value if set.
+	if v := r.URL.Query().Get("offset"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as int for offset param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		ul := uint(l)
+		req.Offset = &ul
+	}
+
+	// Handle cursor query value if set.
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		req.Cursor = v
+	}
+
+	// Handle include-total query value if set.
+	var includeTotal bool
+	if v := r.URL.Query().Get("include-total"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for include-total param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		includeTotal = b
+	}
+
+	// Fetch one more row than the limit to determine if there is a next page.
+	limit := req.Limit
+	req.Limit = filter.FetchLimit(limit)
+
+	res, err := h.Repository.FindInvoices(ctx, claims, req)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case billing.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, cause, http.StatusForbidden))
+		default:
+			return err
+		}
+	}
+
+	var total *int
+	if includeTotal {
+		cnt, err := h.Repository.CountInvoices(ctx, claims, req)
+		if err != nil {
+			return err
+		}
+		total = &cnt
+	}
+
+	nextCursor, more, err := billing.InvoiceFindFields.NextPage(req.Order, limit, &res)
+	if err != nil {
+		return err
+	}
+
+	web.SetPageHeaders(w, r, more, limit, req.Offset, nextCursor, total)
+
+	return web.RespondJson(ctx, w, web.NewPageResponse(res.Response(ctx), nextCursor), http.StatusOK)
+}
+
+// Webhook godoc
+// @Summary Receive payment provider events.
+// @Description Webhook receives the events sent by the payment provider. The signature of the event is verified before the subscription, invoice and account status are updated.
+// @Tags billing
+// @Accept  json
+// @Produce  json
+// @Param Stripe-Signature header string true "Signature of the payload"
+// @Success 200
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /billing/webhook [post]
+func (h *Billing) Webhook(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The payload must be verified exactly as it was sent.
+	payload, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+	}
+
+	err = h.Repository.HandleEvent(ctx, payload, r.Header.Get(billing.HeaderSignature), v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case billing.ErrInvalidSignature:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			return err
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusOK)
+}
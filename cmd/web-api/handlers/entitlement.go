@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+
+	"github.com/pkg/errors"
+)
+
+// Entitlements represents the Entitlement API method handler set.
+type Entitlements struct {
+	Repository EntitlementRepository
+
+	// ADD OTHER STATE LIKE THE LOGGER IF NEEDED.
+}
+
+type EntitlementRepository interface {
+	Read(ctx context.Context, claims auth.Claims, accountID string, now time.Time) (*entitlement.Entitlements, error)
+}
+
+// Read godoc
+// @Summary Get the entitlements of the account.
+// @Description Read returns the features included in the plan of the account and how much of each limit remains.
+// @Tags entitlement
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Success 200 {object} entitlement.EntitlementsResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /entitlements [get]
+func (h *Entitlements) Read(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.Repository.Read(ctx, claims, claims.Audience, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case entitlement.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			return errors.Wrapf(err, "Account ID: %s", claims.Audience)
+		}
+	}
+
+	return web.RespondJson(ctx, w, res.Response(ctx), http.StatusOK)
+}
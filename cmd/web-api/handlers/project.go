@@ -7,7 +7,9 @@ import (
 	"strings"
 	"time"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
@@ -27,6 +29,7 @@ type Projects struct {
 type ProjectRepository interface {
 	ReadByID(ctx context.Context, claims auth.Claims, id string) (*project.Project, error)
 	Find(ctx context.Context, claims auth.Claims, req project.ProjectFindRequest) (project.Projects, error)
+	Count(ctx context.Context, claims auth.Claims, req project.ProjectFindRequest) (int, error)
 	Read(ctx context.Context, claims auth.Claims, req project.ProjectReadRequest) (*project.Project, error)
 	Create(ctx context.Context, claims auth.Claims, req project.ProjectCreateRequest, now time.Time) (*project.Project, error)
 	Update(ctx context.Context, claims auth.Claims, req project.ProjectUpdateRequest, now time.Time) error
@@ -42,12 +45,17 @@ type ProjectRepository interface {
 // @Accept  json
 // @Produce  json
 // @Security OAuth2Password
-// @Param where				query string 	false	"Filter string, example: name = 'Moon Launch'"
-// @Param order				query string   	false 	"Order columns separated by comma, example: created_at desc"
+// @Param filter[field][operator]	query string 	false	"Filter by field with an optional operator, example: filter[name][contains]=moon&filter[status]=active. Fields: id, name, status, created_at, updated_at, archived_at. Operators: eq, ne, contains, starts_with, in, gt, gte, lt, lte, null"
+// @Param order				query string   	false 	"Order columns separated by comma, example: created_at desc. Fields: id, name, status, created_at, updated_at"
 // @Param limit				query integer  	false 	"Limit, example: 10"
 // @Param offset			query integer  	false 	"Offset, example: 20"
+// @Param cursor			query string  	false 	"Cursor returned by the previous page in next_cursor, the order can't be changed when paging"
+// @Param include-total	query boolean 	false 	"Include the total number of results in the X-Total-Count header, example: false"
 // @Param include-archived query boolean 	false 	"Included Archived, example: false"
-// @Success 200 {array} project.ProjectResponse
+// @Success 200 {object} web.PageResponse "data contains the list of project.ProjectResponse"
+// @Header 200 {string} Link "Links to the next and first pages"
+// @Header 200 {string} X-Next-Cursor "Cursor for the next page, only set when there are more results"
+// @Header 200 {string} X-Total-Count "Total number of results, only set when include-total is true"
 // @Failure 400 {object} weberror.ErrorResponse
 // @Failure 403 {object} weberror.ErrorResponse
 // @Failure 500 {object} weberror.ErrorResponse
@@ -60,26 +68,25 @@ func (h *Projects) Find(ctx context.Context, w http.ResponseWriter, r *http.Requ
 
 	var req project.ProjectFindRequest
 
-	// Handle where query value if set.
-	if v := r.URL.Query().Get("where"); v != "" {
-		where, args, err := web.ExtractWhereArgs(v)
-		if err != nil {
-			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
-		}
-		req.Where = where
-		req.Args = args
+	// Handle filter query values if set.
+	filters, err := filter.Parse(r.URL.Query(), project.ProjectFindFields)
+	if err != nil {
+		return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 	}
+	req.Filter = filters
 
 	// Handle order query value if set.
 	if v := r.URL.Query().Get("order"); v != "" {
-		for _, o := range strings.Split(v, ",") {
-			o = strings.TrimSpace(o)
-			if o != "" {
-				req.Order = append(req.Order, o)
-			}
+		order, err := project.ProjectFindFields.Order(strings.Split(v, ","))
+		if err != nil {
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		}
+		req.Order = order
 	}
 
+	// Include the key fields in the order so the rows have a stable order for cursor pagination.
+	req.Order = project.ProjectFindFields.KeyOrder(req.Order)
+
 	// Handle limit query value if set.
 	if v := r.URL.Query().Get("limit"); v != "" {
 		l, err := strconv.Atoi(v)
@@ -99,7 +106,23 @@ func (h *Projects) Find(ctx context.Context, w http.ResponseWriter, r *http.Requ
 			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		}
 		ul := uint(l)
-		req.Limit = &ul
+		req.Offset = &ul
+	}
+
+	// Handle cursor query value if set.
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		req.Cursor = v
+	}
+
+	// Handle include-total query value if set.
+	var includeTotal bool
+	if v := r.URL.Query().Get("include-total"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for include-total param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		includeTotal = b
 	}
 
 	// Handle include-archive query value if set.
@@ -119,17 +142,37 @@ func (h *Projects) Find(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	//	return  web.RespondJsonError(ctx, w, err)
 	//}
 
+	// Fetch one more row than the limit to determine if there is a next page.
+	limit := req.Limit
+	req.Limit = filter.FetchLimit(limit)
+
 	res, err := h.Repository.Find(ctx, claims, req)
 	if err != nil {
 		return err
 	}
 
+	var total *int
+	if includeTotal {
+		cnt, err := h.Repository.Count(ctx, claims, req)
+		if err != nil {
+			return err
+		}
+		total = &cnt
+	}
+
+	nextCursor, more, err := project.ProjectFindFields.NextPage(req.Order, limit, &res)
+	if err != nil {
+		return err
+	}
+
+	web.SetPageHeaders(w, r, more, limit, req.Offset, nextCursor, total)
+
 	var resp []*project.ProjectResponse
 	for _, m := range res {
 		resp = append(resp, m.Response(ctx))
 	}
 
-	return web.RespondJson(ctx, w, resp, http.StatusOK)
+	return web.RespondJson(ctx, w, web.NewPageResponse(resp, nextCursor), http.StatusOK)
 }
 
 // Read godoc
@@ -189,6 +232,7 @@ func (h *Projects) Read(ctx context.Context, w http.ResponseWriter, r *http.Requ
 // @Param data body project.ProjectCreateRequest true "Project details"
 // @Success 201 {object} project.ProjectResponse
 // @Failure 400 {object} weberror.ErrorResponse
+// @Failure 402 {object} weberror.ErrorResponse
 // @Failure 403 {object} weberror.ErrorResponse
 // @Failure 404 {object} weberror.ErrorResponse
 // @Failure 500 {object} weberror.ErrorResponse
@@ -218,6 +262,8 @@ func (h *Projects) Create(ctx context.Context, w http.ResponseWriter, r *http.Re
 		switch cause {
 		case project.ErrForbidden:
 			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case entitlement.ErrLimitExceeded:
+			return web.RespondJsonError(ctx, w, entitlement.WebError(ctx, err))
 		default:
 			_, ok := cause.(validator.ValidationErrors)
 			if ok {
@@ -4,7 +4,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
 	"geeks-accelerator/oss/saas-starter-kit/internal/mid"
 	saasSwagger "geeks-accelerator/oss/saas-starter-kit/internal/mid/saas-swagger"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
@@ -26,13 +28,25 @@ type AppContext struct {
 	UserAccountRepo   UserAccountRepository
 	AccountRepo       AccountRepository
 	AccountPrefRepo   AccountPrefRepository
+	AccountRoleRepo   AccountRoleRepository
 	AuthRepo          UserAuthRepository
 	SignupRepo        SignupRepository
 	InviteRepo        UserInviteRepository
+	TransferRepo      UserTransferRepository
+	ClosureRepo       ClosureRepository
 	ProjectRepo       ProjectRepository
+	AuditRepo         AuditRepository
+	WebhookRepo       WebhookRepository
+	ApiKeyRepo        ApiKeyRepository
+	BillingRepo       BillingRepository
+	ScimRepo          ScimRepository
+	EntitlementRepo   *entitlement.Repository
+	RateLimitStore    mid.RateLimitStore
 	Authenticator     *auth.Authenticator
 	PreAppMiddleware  []web.Middleware
 	PostAppMiddleware []web.Middleware
+
+	TrustedProxyHops int
 }
 
 // API returns a handler for a set of routes.
@@ -56,6 +70,22 @@ func API(shutdown chan os.Signal, appCtx *AppContext) http.Handler {
 
 	// Construct the web.App which holds all routes as well as common Middleware.
 	app := web.NewApp(shutdown, appCtx.Log, appCtx.Env, middlewares...)
+	app.TrustedProxyHops = appCtx.TrustedProxyHops
+
+	// Limit the requests made by each client, account and IP address. Requests are rate limited before they are
+	// counted as usage. Routes that are not authenticated have lower limits by IP address only, the address of the
+	// client is read from X-Forwarded-For only when it was appended by a trusted proxy.
+	rateLimit := mid.RateLimit(appCtx.RateLimitStore, "api",
+		mid.RateLimitPolicy{By: mid.RateLimitBy_Client, Limit: 600, Period: time.Minute},
+		mid.RateLimitPolicy{By: mid.RateLimitBy_Account, Limit: 1200, Period: time.Minute},
+		mid.RateLimitPolicy{By: mid.RateLimitBy_IP, Limit: 1200, Period: time.Minute})
+	publicRateLimit := mid.RateLimit(appCtx.RateLimitStore, "public",
+		mid.RateLimitPolicy{By: mid.RateLimitBy_IP, Limit: 60, Period: time.Minute})
+
+	// Authenticated requests are counted as usage for the API request limit of the plan. The meter is the last
+	// middleware of a route so only authorized requests are counted. The billing and entitlement endpoints are not
+	// metered so accounts can always upgrade their plan.
+	meter := mid.ConsumeUsage(appCtx.EntitlementRepo, entitlement.Limit_ApiRequests)
 
 	// Register health check endpoint. This route is not authenticated.
 	check := Check{
@@ -65,6 +95,14 @@ func API(shutdown chan os.Signal, appCtx *AppContext) http.Handler {
 	app.Handle("GET", "/v1/health", check.Health)
 	app.Handle("GET", "/ping", check.Ping)
 
+	// Register the public keys and OpenID Connect discovery document so other services can validate the tokens.
+	// These routes are not authenticated.
+	wk := WellKnown{
+		Authenticator: appCtx.Authenticator,
+	}
+	app.Handle("GET", "/.well-known/jwks.json", wk.Jwks, publicRateLimit)
+	app.Handle("GET", "/.well-known/openid-configuration", wk.OpenIDConfiguration, publicRateLimit)
+
 	// Register example endpoints.
 	ex := Example{
 		Project: appCtx.ProjectRepo,
@@ -75,53 +113,149 @@ func API(shutdown chan os.Signal, appCtx *AppContext) http.Handler {
 	u := Users{
 		UserRepo: appCtx.UserRepo,
 		AuthRepo: appCtx.AuthRepo,
+		Closure:  appCtx.ClosureRepo,
 	}
-	app.Handle("GET", "/v1/users", u.Find, mid.AuthenticateHeader(appCtx.Authenticator))
-	app.Handle("POST", "/v1/users", u.Create, mid.AuthenticateHeader(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/v1/users/:id", u.Read, mid.AuthenticateHeader(appCtx.Authenticator))
-	app.Handle("PATCH", "/v1/users", u.Update, mid.AuthenticateHeader(appCtx.Authenticator))
-	app.Handle("PATCH", "/v1/users/password", u.UpdatePassword, mid.AuthenticateHeader(appCtx.Authenticator))
-	app.Handle("PATCH", "/v1/users/archive", u.Archive, mid.AuthenticateHeader(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("DELETE", "/v1/users/:id", u.Delete, mid.AuthenticateHeader(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("PATCH", "/v1/users/switch-account/:account_id", u.SwitchAccount, mid.AuthenticateHeader(appCtx.Authenticator))
+	app.Handle("GET", "/v1/users", u.Find, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("POST", "/v1/users", u.Create, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionUserWrite), meter)
+	app.Handle("GET", "/v1/users/export", u.Export, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("POST", "/v1/users/close", u.Close, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("GET", "/v1/users/:id", u.Read, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("PATCH", "/v1/users", u.Update, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("PATCH", "/v1/users/password", u.UpdatePassword, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("PATCH", "/v1/users/archive", u.Archive, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionUserWrite), meter)
+	app.Handle("DELETE", "/v1/users/:id", u.Delete, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionUserWrite), meter)
+	app.Handle("PATCH", "/v1/users/switch-account/:account_id", u.SwitchAccount, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("POST", "/v1/users/mfa/enroll", u.MfaEnroll, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("POST", "/v1/users/mfa/confirm", u.MfaConfirm, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("POST", "/v1/users/mfa/disable", u.MfaDisable, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("GET", "/v1/users/sessions", u.SessionFind, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("DELETE", "/v1/users/sessions", u.SessionRevokeAll, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("DELETE", "/v1/users/sessions/:id", u.SessionRevoke, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
 
-	// This route is not authenticated
-	app.Handle("POST", "/v1/oauth/token", u.Token)
+	// These routes are not authenticated
+	app.Handle("POST", "/v1/oauth/token", u.Token, publicRateLimit)
+	app.Handle("POST", "/v1/oauth/revoke", u.Revoke, publicRateLimit)
+	app.Handle("POST", "/v1/oauth/mfa/enroll", u.MfaTokenEnroll, publicRateLimit)
+	app.Handle("POST", "/v1/users/verify-email", u.VerifyEmail, publicRateLimit)
+	app.Handle("POST", "/v1/users/verify-email/confirm", u.VerifyEmailConfirm, publicRateLimit)
 
 	// Register user account management endpoints.
 	ua := UserAccount{
-		Repository: appCtx.UserAccountRepo,
+		Repository:   appCtx.UserAccountRepo,
+		UserTransfer: appCtx.TransferRepo,
 	}
-	app.Handle("GET", "/v1/user_accounts", ua.Find, mid.AuthenticateHeader(appCtx.Authenticator))
-	app.Handle("POST", "/v1/user_accounts", ua.Create, mid.AuthenticateHeader(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/v1/user_accounts/:user_id/:account_id", ua.Read, mid.AuthenticateHeader(appCtx.Authenticator))
-	app.Handle("PATCH", "/v1/user_accounts", ua.Update, mid.AuthenticateHeader(appCtx.Authenticator))
-	app.Handle("PATCH", "/v1/user_accounts/archive", ua.Archive, mid.AuthenticateHeader(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("DELETE", "/v1/user_accounts", ua.Delete, mid.AuthenticateHeader(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle("GET", "/v1/user_accounts", ua.Find, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("POST", "/v1/user_accounts", ua.Create, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionUserWrite), meter)
+	app.Handle("GET", "/v1/user_accounts/:user_id/:account_id", ua.Read, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("PATCH", "/v1/user_accounts", ua.Update, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("PATCH", "/v1/user_accounts/archive", ua.Archive, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionUserWrite), meter)
+	app.Handle("DELETE", "/v1/user_accounts", ua.Delete, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionUserWrite), meter)
+	app.Handle("POST", "/v1/user_accounts/transfer", ua.Transfer, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasRole(auth.RoleOwner), meter)
 
 	// Register account endpoints.
 	a := Accounts{
 		Repository: appCtx.AccountRepo,
+		Closure:    appCtx.ClosureRepo,
 	}
-	app.Handle("GET", "/v1/accounts/:id", a.Read, mid.AuthenticateHeader(appCtx.Authenticator))
-	app.Handle("PATCH", "/v1/accounts", a.Update, mid.AuthenticateHeader(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle("GET", "/v1/accounts/export", a.Export, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionAccountWrite), meter)
+	app.Handle("POST", "/v1/accounts/close", a.Close, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasRole(auth.RoleOwner), meter)
+	app.Handle("GET", "/v1/accounts/:id", a.Read, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("PATCH", "/v1/accounts", a.Update, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionAccountWrite), meter)
+
+	// Register account role endpoints.
+	ar := AccountRoles{
+		Repository: appCtx.AccountRoleRepo,
+	}
+	app.Handle("GET", "/v1/roles", ar.Find, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("POST", "/v1/roles", ar.Create, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionRoleWrite), meter)
+	app.Handle("GET", "/v1/roles/:id", ar.Read, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("PATCH", "/v1/roles", ar.Update, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionRoleWrite), meter)
+	app.Handle("PATCH", "/v1/roles/archive", ar.Archive, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionRoleWrite), meter)
+	app.Handle("DELETE", "/v1/roles/:id", ar.Delete, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionRoleWrite), meter)
 
 	// Register signup endpoints.
 	s := Signup{
 		Repository: appCtx.SignupRepo,
 	}
-	app.Handle("POST", "/v1/signup", s.Signup)
+	app.Handle("POST", "/v1/signup", s.Signup, publicRateLimit)
 
 	// Register project.
 	p := Projects{
 		Repository: appCtx.ProjectRepo,
 	}
-	app.Handle("GET", "/v1/projects", p.Find, mid.AuthenticateHeader(appCtx.Authenticator))
-	app.Handle("POST", "/v1/projects", p.Create, mid.AuthenticateHeader(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/v1/projects/:id", p.Read, mid.AuthenticateHeader(appCtx.Authenticator))
-	app.Handle("PATCH", "/v1/projects", p.Update, mid.AuthenticateHeader(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("PATCH", "/v1/projects/archive", p.Archive, mid.AuthenticateHeader(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("DELETE", "/v1/projects/:id", p.Delete, mid.AuthenticateHeader(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle("GET", "/v1/projects", p.Find, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("POST", "/v1/projects", p.Create, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionProjectWrite), meter)
+	app.Handle("GET", "/v1/projects/:id", p.Read, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, meter)
+	app.Handle("PATCH", "/v1/projects", p.Update, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionProjectWrite), meter)
+	app.Handle("PATCH", "/v1/projects/archive", p.Archive, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionProjectWrite), meter)
+	app.Handle("DELETE", "/v1/projects/:id", p.Delete, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionProjectWrite), meter)
+
+	// Register audit endpoints.
+	au := Audit{
+		Repository: appCtx.AuditRepo,
+	}
+	app.Handle("GET", "/v1/audit", au.Find, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionAuditRead), mid.HasFeature(appCtx.EntitlementRepo, entitlement.Feature_AuditLog), meter)
+
+	// Register webhook endpoints.
+	wh := Webhooks{
+		Repository: appCtx.WebhookRepo,
+	}
+	app.Handle("GET", "/v1/webhooks", wh.Find, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionWebhookWrite), mid.HasFeature(appCtx.EntitlementRepo, entitlement.Feature_Webhooks), meter)
+	app.Handle("POST", "/v1/webhooks", wh.Create, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionWebhookWrite), mid.HasFeature(appCtx.EntitlementRepo, entitlement.Feature_Webhooks), meter)
+	app.Handle("GET", "/v1/webhooks/:id", wh.Read, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionWebhookWrite), mid.HasFeature(appCtx.EntitlementRepo, entitlement.Feature_Webhooks), meter)
+	app.Handle("GET", "/v1/webhooks/:id/deliveries", wh.Deliveries, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionWebhookWrite), mid.HasFeature(appCtx.EntitlementRepo, entitlement.Feature_Webhooks), meter)
+	app.Handle("PATCH", "/v1/webhooks", wh.Update, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionWebhookWrite), mid.HasFeature(appCtx.EntitlementRepo, entitlement.Feature_Webhooks), meter)
+	app.Handle("PATCH", "/v1/webhooks/archive", wh.Archive, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionWebhookWrite), mid.HasFeature(appCtx.EntitlementRepo, entitlement.Feature_Webhooks), meter)
+	app.Handle("DELETE", "/v1/webhooks/:id", wh.Delete, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionWebhookWrite), mid.HasFeature(appCtx.EntitlementRepo, entitlement.Feature_Webhooks), meter)
+
+	// Register API key endpoints.
+	ak := ApiKeys{
+		Repository: appCtx.ApiKeyRepo,
+	}
+	app.Handle("GET", "/v1/api_keys", ak.Find, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasAuth(), meter)
+	app.Handle("POST", "/v1/api_keys", ak.Create, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasAuth(), meter)
+	app.Handle("GET", "/v1/api_keys/:id", ak.Read, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasAuth(), meter)
+	app.Handle("PATCH", "/v1/api_keys", ak.Update, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasAuth(), meter)
+	app.Handle("PATCH", "/v1/api_keys/archive", ak.Archive, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasAuth(), meter)
+	app.Handle("DELETE", "/v1/api_keys/:id", ak.Delete, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasAuth(), meter)
+
+	// Register SCIM provisioning endpoints. Identity providers authenticate with an API key of the account.
+	sc := Scim{
+		Repository: appCtx.ScimRepo,
+	}
+	scimAuth := mid.AuthenticateApiKey(appCtx.Authenticator)
+	scimPerm := mid.HasPermission(auth.PermissionScimProvision)
+	app.Handle("GET", "/scim/v2/ServiceProviderConfig", sc.ServiceProviderConfig, scimAuth, rateLimit)
+	app.Handle("GET", "/scim/v2/Users", sc.UserFind, scimAuth, rateLimit, scimPerm)
+	app.Handle("POST", "/scim/v2/Users", sc.UserCreate, scimAuth, rateLimit, scimPerm)
+	app.Handle("GET", "/scim/v2/Users/:id", sc.UserRead, scimAuth, rateLimit, scimPerm)
+	app.Handle("PUT", "/scim/v2/Users/:id", sc.UserReplace, scimAuth, rateLimit, scimPerm)
+	app.Handle("PATCH", "/scim/v2/Users/:id", sc.UserPatch, scimAuth, rateLimit, scimPerm)
+	app.Handle("DELETE", "/scim/v2/Users/:id", sc.UserDelete, scimAuth, rateLimit, scimPerm)
+	app.Handle("GET", "/scim/v2/Groups", sc.GroupFind, scimAuth, rateLimit, scimPerm)
+	app.Handle("POST", "/scim/v2/Groups", sc.GroupNotSupported, scimAuth, rateLimit, scimPerm)
+	app.Handle("GET", "/scim/v2/Groups/:id", sc.GroupRead, scimAuth, rateLimit, scimPerm)
+	app.Handle("PUT", "/scim/v2/Groups/:id", sc.GroupReplace, scimAuth, rateLimit, scimPerm)
+	app.Handle("PATCH", "/scim/v2/Groups/:id", sc.GroupPatch, scimAuth, rateLimit, scimPerm)
+	app.Handle("DELETE", "/scim/v2/Groups/:id", sc.GroupNotSupported, scimAuth, rateLimit, scimPerm)
+
+	// Register billing endpoints.
+	bl := Billing{
+		Repository: appCtx.BillingRepo,
+	}
+	app.Handle("GET", "/v1/billing/plans", bl.Plans, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasAuth())
+	app.Handle("GET", "/v1/billing/subscription", bl.ReadSubscription, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionBillingRead))
+	app.Handle("POST", "/v1/billing/subscription", bl.CreateSubscription, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionBillingWrite))
+	app.Handle("PATCH", "/v1/billing/subscription", bl.UpdateSubscription, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionBillingWrite))
+	app.Handle("PATCH", "/v1/billing/subscription/cancel", bl.CancelSubscription, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionBillingWrite))
+	app.Handle("GET", "/v1/billing/invoices", bl.Invoices, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasPermission(auth.PermissionBillingRead))
+	app.Handle("POST", "/v1/billing/webhook", bl.Webhook)
+
+	// Register entitlement endpoints.
+	ent := Entitlements{
+		Repository: appCtx.EntitlementRepo,
+	}
+	app.Handle("GET", "/v1/entitlements", ent.Read, mid.AuthenticateHeader(appCtx.Authenticator), rateLimit, mid.HasAuth())
 
 	// Register swagger documentation.
 	// TODO: Add authentication. Current authenticator requires an Authorization header
@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+	"geeks-accelerator/oss/saas-starter-kit/internal/scim"
+
+	"github.com/pkg/errors"
+)
+
+// Scim represents the SCIM 2.0 provisioning API method handler set. Identity providers authenticate with an API key
+// of the account that has the scim:provision permission.
+type Scim struct {
+	Repository ScimRepository
+}
+
+type ScimRepository interface {
+	ServiceProviderConfig() map[string]interface{}
+	UserFind(ctx context.Context, claims auth.Claims, req scim.FindRequest) (*scim.ListResponse, error)
+	UserRead(ctx context.Context, claims auth.Claims, id string) (*scim.User, error)
+	UserCreate(ctx context.Context, claims auth.Claims, req scim.User, now time.Time) (*scim.User, error)
+	UserReplace(ctx context.Context, claims auth.Claims, id string, req scim.User, now time.Time) (*scim.User, error)
+	UserPatch(ctx context.Context, claims auth.Claims, id string, req scim.PatchRequest, now time.Time) (*scim.User, error)
+	UserDelete(ctx context.Context, claims auth.Claims, id string, now time.Time) error
+	GroupFind(ctx context.Context, claims auth.Claims, req scim.FindRequest) (*scim.ListResponse, error)
+	GroupRead(ctx context.Context, claims auth.Claims, id string, excludeMembers bool) (*scim.Group, error)
+	GroupReplace(ctx context.Context, claims auth.Claims, id string, req scim.Group, now time.Time) (*scim.Group, error)
+	GroupPatch(ctx context.Context, claims auth.Claims, id string, req scim.PatchRequest, now time.Time) (*scim.Group, error)
+}
+
+// ServiceProviderConfig godoc
+// @Summary SCIM service provider config
+// @Description ServiceProviderConfig returns the SCIM features supported for provisioning.
+// @Tags scim
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{}
+// @Router /scim/v2/ServiceProviderConfig [get]
+func (h *Scim) ServiceProviderConfig(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	return scimRespond(ctx, w, h.Repository.ServiceProviderConfig(), http.StatusOK)
+}
+
+// UserFind godoc
+// @Summary List SCIM users
+// @Description UserFind returns the users of the account that match the SCIM filter.
+// @Tags scim
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param filter		query string 	false	"SCIM filter, example: userName eq \"gabi@geeksinthewoods.com\""
+// @Param startIndex	query integer  	false 	"1-based index of the first result, example: 1"
+// @Param count			query integer  	false 	"Number of results per page, example: 100"
+// @Success 200 {object} scim.ListResponse
+// @Failure 400 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Router /scim/v2/Users [get]
+func (h *Scim) UserFind(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := scimFindRequest(r)
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	res, err := h.Repository.UserFind(ctx, claims, req)
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	return scimRespond(ctx, w, res, http.StatusOK)
+}
+
+// UserRead godoc
+// @Summary Get SCIM user by ID
+// @Description UserRead returns the user of the account.
+// @Tags scim
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} scim.User
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Users/{id} [get]
+func (h *Scim) UserRead(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.Repository.UserRead(ctx, claims, params["id"])
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	return scimRespond(ctx, w, res, http.StatusOK)
+}
+
+// UserCreate godoc
+// @Summary Provision SCIM user
+// @Description UserCreate adds the user to the account, the user is created when the email address is not used.
+// @Tags scim
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param data body scim.User true "User details"
+// @Success 201 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /scim/v2/Users [post]
+func (h *Scim) UserCreate(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req scim.User
+	if err := scimDecode(r, &req); err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	res, err := h.Repository.UserCreate(ctx, claims, req, v.Now)
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	w.Header().Set("Location", res.Meta.Location)
+
+	return scimRespond(ctx, w, res, http.StatusCreated)
+}
+
+// UserReplace godoc
+// @Summary Replace SCIM user
+// @Description UserReplace replaces the name, email address and active status of the user.
+// @Tags scim
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param data body scim.User true "User details"
+// @Success 200 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Users/{id} [put]
+func (h *Scim) UserReplace(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req scim.User
+	if err := scimDecode(r, &req); err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	res, err := h.Repository.UserReplace(ctx, claims, params["id"], req, v.Now)
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	return scimRespond(ctx, w, res, http.StatusOK)
+}
+
+// UserPatch godoc
+// @Summary Patch SCIM user
+// @Description UserPatch applies the patch operations to the user, ie to deactivate the user for the account.
+// @Tags scim
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param data body scim.PatchRequest true "Patch operations"
+// @Success 200 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Users/{id} [patch]
+func (h *Scim) UserPatch(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req scim.PatchRequest
+	if err := scimDecode(r, &req); err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	res, err := h.Repository.UserPatch(ctx, claims, params["id"], req, v.Now)
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	return scimRespond(ctx, w, res, http.StatusOK)
+}
+
+// UserDelete godoc
+// @Summary Deprovision SCIM user
+// @Description UserDelete removes the user from the account.
+// @Tags scim
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Users/{id} [delete]
+func (h *Scim) UserDelete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = h.Repository.UserDelete(ctx, claims, params["id"], v.Now)
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// GroupFind godoc
+// @Summary List SCIM groups
+// @Description GroupFind returns the roles of the account that match the SCIM filter as groups.
+// @Tags scim
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param filter				query string 	false	"SCIM filter, example: displayName eq \"admin\""
+// @Param startIndex			query integer  	false 	"1-based index of the first result, example: 1"
+// @Param count					query integer  	false 	"Number of results per page, example: 100"
+// @Param excludedAttributes	query string  	false 	"Set to members to not return the members of the groups"
+// @Success 200 {object} scim.ListResponse
+// @Failure 400 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Router /scim/v2/Groups [get]
+func (h *Scim) GroupFind(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := scimFindRequest(r)
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	res, err := h.Repository.GroupFind(ctx, claims, req)
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	return scimRespond(ctx, w, res, http.StatusOK)
+}
+
+// GroupRead godoc
+// @Summary Get SCIM group by ID
+// @Description GroupRead returns the role of the account as a group, the ID is the name of the role.
+// @Tags scim
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "Role name"
+// @Param excludedAttributes	query string  	false 	"Set to members to not return the members of the group"
+// @Success 200 {object} scim.Group
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Groups/{id} [get]
+func (h *Scim) GroupRead(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.Repository.GroupRead(ctx, claims, params["id"], scimExcludeMembers(r))
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	return scimRespond(ctx, w, res, http.StatusOK)
+}
+
+// GroupReplace godoc
+// @Summary Replace SCIM group members
+// @Description GroupReplace replaces the users that have the role of the account.
+// @Tags scim
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "Role name"
+// @Param data body scim.Group true "Group details"
+// @Success 200 {object} scim.Group
+// @Failure 400 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Groups/{id} [put]
+func (h *Scim) GroupReplace(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req scim.Group
+	if err := scimDecode(r, &req); err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	res, err := h.Repository.GroupReplace(ctx, claims, params["id"], req, v.Now)
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	return scimRespond(ctx, w, res, http.StatusOK)
+}
+
+// GroupPatch godoc
+// @Summary Patch SCIM group members
+// @Description GroupPatch adds or removes the users that have the role of the account.
+// @Tags scim
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param id path string true "Role name"
+// @Param data body scim.PatchRequest true "Patch operations"
+// @Success 200 {object} scim.Group
+// @Failure 400 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Groups/{id} [patch]
+func (h *Scim) GroupPatch(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req scim.PatchRequest
+	if err := scimDecode(r, &req); err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	res, err := h.Repository.GroupPatch(ctx, claims, params["id"], req, v.Now)
+	if err != nil {
+		return scimRespondError(ctx, w, err)
+	}
+
+	return scimRespond(ctx, w, res, http.StatusOK)
+}
+
+// GroupNotSupported is returned when creating or deleting groups, groups are the roles of the account and are
+// managed in the app since they define the permissions of the users.
+func (h *Scim) GroupNotSupported(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	return scimRespondError(ctx, w, scim.NewError(http.StatusNotImplemented, "",
+		"Groups are the roles of the account, roles can only be created and deleted in the app."))
+}
+
+// scimFindRequest parses the query of a list request.
+func scimFindRequest(r *http.Request) (scim.FindRequest, error) {
+	qv := r.URL.Query()
+
+	req := scim.FindRequest{
+		Filter:         qv.Get("filter"),
+		ExcludeMembers: scimExcludeMembers(r),
+	}
+
+	if v := qv.Get("startIndex"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return req, scim.NewError(http.StatusBadRequest, scim.ScimTypeInvalidValue, "startIndex must be an integer")
+		}
+		req.StartIndex = i
+	}
+	if v := qv.Get("count"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return req, scim.NewError(http.StatusBadRequest, scim.ScimTypeInvalidValue, "count must be an integer")
+		}
+		req.Count = i
+	}
+
+	return req, nil
+}
+
+// scimExcludeMembers returns true when the members are in the excluded attributes of the request.
+func scimExcludeMembers(r *http.Request) bool {
+	for _, a := range strings.Split(r.URL.Query().Get("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(a), "members") {
+			return true
+		}
+	}
+	return false
+}
+
+// scimDecode decodes the body of the request. Unknown attributes are ignored since identity providers send attributes
+// of extension schemas that are not supported.
+func scimDecode(r *http.Request, val interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(val); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ScimTypeInvalidSyntax, "The request body is not valid JSON.")
+	}
+	return nil
+}
+
+// scimRespond sends the SCIM response with the status code.
+func scimRespond(ctx context.Context, w http.ResponseWriter, data interface{}, statusCode int) error {
+	dat, err := json.Marshal(data)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return web.Respond(ctx, w, dat, statusCode, scim.ContentType)
+}
+
+// scimRespondError sends the error as a SCIM error response, unexpected errors are returned to be handled by the
+// error middleware.
+func scimRespondError(ctx context.Context, w http.ResponseWriter, err error) error {
+	cause := errors.Cause(err)
+
+	serr, ok := cause.(*scim.Error)
+	if !ok {
+		switch cause {
+		case scim.ErrNotFound:
+			serr = scim.NewError(http.StatusNotFound, "", "Resource not found.")
+		case scim.ErrForbidden:
+			serr = scim.NewError(http.StatusForbidden, "", "The API key is not allowed to provision the account.")
+		default:
+			verr, ok := weberror.NewValidationError(ctx, err)
+			if !ok {
+				return err
+			}
+
+			var details []string
+			if werr, ok := verr.(*weberror.Error); ok {
+				for _, f := range werr.Fields {
+					details = append(details, f.Display)
+				}
+			}
+			serr = scim.NewError(http.StatusBadRequest, scim.ScimTypeInvalidValue, strings.Join(details, " "))
+		}
+	}
+
+	return scimRespond(ctx, w, serr, serr.Status)
+}
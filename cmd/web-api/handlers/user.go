@@ -7,7 +7,10 @@ import (
 	"strings"
 	"time"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/closure"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/bruteforce"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
@@ -26,6 +29,7 @@ var sessionTtl = time.Hour * 24
 type Users struct {
 	AuthRepo UserAuthRepository
 	UserRepo UserRepository
+	Closure  ClosureRepository
 	// ADD OTHER STATE LIKE THE LOGGER AND CONFIG HERE.
 }
 
@@ -33,13 +37,23 @@ type UserAuthRepository interface {
 	SwitchAccount(ctx context.Context, claims auth.Claims, req user_auth.SwitchAccountRequest, expires time.Duration,
 		now time.Time, scopes ...string) (user_auth.Token, error)
 	Authenticate(ctx context.Context, req user_auth.AuthenticateRequest, expires time.Duration, now time.Time, scopes ...string) (user_auth.Token, error)
+	AuthenticateIdentity(ctx context.Context, userID, accountID string, expires time.Duration, now time.Time, scopes ...string) (user_auth.Token, error)
 	VirtualLogin(ctx context.Context, claims auth.Claims, req user_auth.VirtualLoginRequest,
 		expires time.Duration, now time.Time, scopes ...string) (user_auth.Token, error)
 	VirtualLogout(ctx context.Context, claims auth.Claims, expires time.Duration, now time.Time, scopes ...string) (user_auth.Token, error)
+	Refresh(ctx context.Context, req user_auth.RefreshRequest, expires time.Duration, now time.Time, scopes ...string) (user_auth.Token, error)
+	Revoke(ctx context.Context, req user_auth.RevokeRequest, now time.Time) error
+	RevokeSession(ctx context.Context, claims auth.Claims, now time.Time) error
+	AuthenticateMfa(ctx context.Context, req user_auth.MfaAuthenticateRequest, expires time.Duration, now time.Time) (user_auth.Token, error)
+	MfaEnroll(ctx context.Context, req user_auth.MfaEnrollRequest, now time.Time) (*user.UserMfaEnrollment, error)
+	SessionFind(ctx context.Context, claims auth.Claims, now time.Time) (user_auth.Sessions, error)
+	SessionRevoke(ctx context.Context, claims auth.Claims, req user_auth.SessionRevokeRequest, now time.Time) error
+	SessionRevokeAll(ctx context.Context, claims auth.Claims, req user_auth.SessionRevokeAllRequest, now time.Time) error
 }
 
 type UserRepository interface {
 	Find(ctx context.Context, claims auth.Claims, req user.UserFindRequest) (user.Users, error)
+	Count(ctx context.Context, claims auth.Claims, req user.UserFindRequest) (int, error)
 	//FindByAccount(ctx context.Context, claims auth.Claims, req user.UserFindByAccountRequest) (user.Users, error)
 	Read(ctx context.Context, claims auth.Claims, req user.UserReadRequest) (*user.User, error)
 	ReadByID(ctx context.Context, claims auth.Claims, id string) (*user.User, error)
@@ -51,6 +65,11 @@ type UserRepository interface {
 	Delete(ctx context.Context, claims auth.Claims, req user.UserDeleteRequest) error
 	ResetPassword(ctx context.Context, req user.UserResetPasswordRequest, now time.Time) (string, error)
 	ResetConfirm(ctx context.Context, req user.UserResetConfirmRequest, now time.Time) (*user.User, error)
+	VerifyEmail(ctx context.Context, req user.UserVerifyEmailRequest, now time.Time) (string, error)
+	VerifyEmailConfirm(ctx context.Context, req user.UserVerifyEmailConfirmRequest, now time.Time) (*user.User, error)
+	MfaEnroll(ctx context.Context, claims auth.Claims, req user.UserMfaEnrollRequest, now time.Time) (*user.UserMfaEnrollment, error)
+	MfaVerify(ctx context.Context, claims auth.Claims, req user.UserMfaVerifyRequest, now time.Time) error
+	MfaDisable(ctx context.Context, claims auth.Claims, req user.UserMfaDisableRequest, now time.Time) error
 }
 
 // Find godoc
@@ -61,12 +80,17 @@ type UserRepository interface {
 // @Accept  json
 // @Produce  json
 // @Security OAuth2Password
-// @Param where				query string 	false	"Filter string, example: name = 'Company Name' and email = 'gabi.may@geeksinthewoods.com'"
-// @Param order				query string   	false 	"Order columns separated by comma, example: created_at desc"
+// @Param filter[field][operator]	query string 	false	"Filter by field with an optional operator, example: filter[email][eq]=gabi.may@geeksinthewoods.com. Fields: id, first_name, last_name, email, timezone, created_at, updated_at, archived_at. Operators: eq, ne, contains, starts_with, in, gt, gte, lt, lte, null"
+// @Param order				query string   	false 	"Order columns separated by comma, example: created_at desc. Fields: id, first_name, last_name, email, created_at, updated_at"
 // @Param limit				query integer  	false 	"Limit, example: 10"
 // @Param offset			query integer  	false 	"Offset, example: 20"
+// @Param cursor			query string  	false 	"Cursor returned by the previous page in next_cursor, the order can't be changed when paging"
+// @Param include-total	query boolean 	false 	"Include the total number of results in the X-Total-Count header, example: false"
 // @Param include-archived query boolean 	false 	"Included Archived, example: false"
-// @Success 200 {array} user.UserResponse
+// @Success 200 {object} web.PageResponse "data contains the list of user.UserResponse"
+// @Header 200 {string} Link "Links to the next and first pages"
+// @Header 200 {string} X-Next-Cursor "Cursor for the next page, only set when there are more results"
+// @Header 200 {string} X-Total-Count "Total number of results, only set when include-total is true"
 // @Failure 400 {object} weberror.ErrorResponse
 // @Failure 500 {object} weberror.ErrorResponse
 // @Router /users [get]
@@ -78,26 +102,25 @@ func (h *Users) Find(ctx context.Context, w http.ResponseWriter, r *http.Request
 
 	var req user.UserFindRequest
 
-	// Handle where query value if set.
-	if v := r.URL.Query().Get("where"); v != "" {
-		where, args, err := web.ExtractWhereArgs(v)
-		if err != nil {
-			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
-		}
-		req.Where = where
-		req.Args = args
+	// Handle filter query values if set.
+	filters, err := filter.Parse(r.URL.Query(), user.UserFindFields)
+	if err != nil {
+		return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 	}
+	req.Filter = filters
 
 	// Handle order query value if set.
 	if v := r.URL.Query().Get("order"); v != "" {
-		for _, o := range strings.Split(v, ",") {
-			o = strings.TrimSpace(o)
-			if o != "" {
-				req.Order = append(req.Order, o)
-			}
+		order, err := user.UserFindFields.Order(strings.Split(v, ","))
+		if err != nil {
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		}
+		req.Order = order
 	}
 
+	// Include the key fields in the order so the rows have a stable order for cursor pagination.
+	req.Order = user.UserFindFields.KeyOrder(req.Order)
+
 	// Handle limit query value if set.
 	if v := r.URL.Query().Get("limit"); v != "" {
 		l, err := strconv.Atoi(v)
@@ -117,7 +140,23 @@ func (h *Users) Find(ctx context.Context, w http.ResponseWriter, r *http.Request
 			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		}
 		ul := uint(l)
-		req.Limit = &ul
+		req.Offset = &ul
+	}
+
+	// Handle cursor query value if set.
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		req.Cursor = v
+	}
+
+	// Handle include-total query value if set.
+	var includeTotal bool
+	if v := r.URL.Query().Get("include-total"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for include-total param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		includeTotal = b
 	}
 
 	// Handle include-archived query value if set.
@@ -137,17 +176,37 @@ func (h *Users) Find(ctx context.Context, w http.ResponseWriter, r *http.Request
 	//	return  web.RespondJsonError(ctx, w, err)
 	//}
 
+	// Fetch one more row than the limit to determine if there is a next page.
+	limit := req.Limit
+	req.Limit = filter.FetchLimit(limit)
+
 	res, err := h.UserRepo.Find(ctx, claims, req)
 	if err != nil {
 		return err
 	}
 
+	var total *int
+	if includeTotal {
+		cnt, err := h.UserRepo.Count(ctx, claims, req)
+		if err != nil {
+			return err
+		}
+		total = &cnt
+	}
+
+	nextCursor, more, err := user.UserFindFields.NextPage(req.Order, limit, &res)
+	if err != nil {
+		return err
+	}
+
+	web.SetPageHeaders(w, r, more, limit, req.Offset, nextCursor, total)
+
 	var resp []*user.UserResponse
 	for _, m := range res {
 		resp = append(resp, m.Response(ctx))
 	}
 
-	return web.RespondJson(ctx, w, resp, http.StatusOK)
+	return web.RespondJson(ctx, w, web.NewPageResponse(resp, nextCursor), http.StatusOK)
 }
 
 // Read godoc
@@ -250,7 +309,8 @@ func (h *Users) Create(ctx context.Context, w http.ResponseWriter, r *http.Reque
 
 // Read godoc
 // @Summary Update user by ID
-// @Description Update updates the specified user in the system.
+// @Description Update updates the specified user in the system. A new email address is only applied once it has been
+// @Description verified.
 // @Tags user
 // @Accept  json
 // @Produce  json
@@ -390,6 +450,8 @@ func (h *Users) Archive(ctx context.Context, w http.ResponseWriter, r *http.Requ
 		switch cause {
 		case user.ErrForbidden:
 			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case user.ErrAccountAdmin:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		default:
 			_, ok := cause.(validator.ValidationErrors)
 			if ok {
@@ -429,6 +491,8 @@ func (h *Users) Delete(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		switch cause {
 		case user.ErrForbidden:
 			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case user.ErrAccountAdmin:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		default:
 			_, ok := cause.(validator.ValidationErrors)
 			if ok {
@@ -489,17 +553,28 @@ func (h *Users) SwitchAccount(ctx context.Context, w http.ResponseWriter, r *htt
 
 // Token godoc
 // @Summary Token handles a request to authenticate a user.
-// @Description Token generates an oauth2 accessToken using Basic Auth with a user's email and password.
+// @Description Token generates an oauth2 accessToken using Basic Auth with a user's email and password. A refresh token
+// @Description can be exchanged for a new accessToken using the grant type refresh_token. When two-factor authentication
+// @Description is required a 403 is returned with an mfa_token that is exchanged using the grant type mfa_otp. A 403
+// @Description is also returned when the account requires the email address of the user to be verified. Repeated
+// @Description failed attempts from the same IP address or for the same email address are rejected with a 429 and a
+// @Description Retry-After header.
 // @Tags user
 // @Accept  x-www-form-urlencoded
 // @Produce  json
-// @Param username formData string true "Email"
-// @Param password formData string true "Password"
+// @Param grant_type formData string false "Grant Type" Enums(password, refresh_token, mfa_otp)
+// @Param username formData string false "Email"
+// @Param password formData string false "Password"
+// @Param refresh_token formData string false "Refresh Token"
+// @Param mfa_token formData string false "MFA Token"
+// @Param otp formData string false "Two-factor Authentication Code"
 // @Param account_id formData string false "Account ID"
 // @Param scope formData string false "Scope" Enums(user, admin)
-// @Success 200
+// @Success 200 {object} user_auth.Token
 // @Failure 400 {object} weberror.ErrorResponse
 // @Failure 401 {object} weberror.ErrorResponse
+// @Failure 403 {object} user_auth.MfaChallenge
+// @Failure 429 {object} weberror.ErrorResponse
 // @Failure 500 {object} weberror.ErrorResponse
 // @Router /oauth/token [post]
 func (h *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
@@ -522,6 +597,32 @@ func (h *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Reques
 			decoder := schema.NewDecoder()
 			decoder.IgnoreUnknownKeys(true)
 
+			// Exchange the refresh token for a new access token.
+			if r.PostForm.Get("grant_type") == "refresh_token" {
+				var req user_auth.OAuth2RefreshTokenRequest
+				if err := decoder.Decode(&req, r.PostForm); err != nil {
+					if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+						err = weberror.NewError(ctx, err, http.StatusBadRequest)
+					}
+					return web.RespondJsonError(ctx, w, err)
+				}
+
+				return h.refreshToken(ctx, w, r, req, v.Now)
+			}
+
+			// Complete the two-factor authentication challenge.
+			if r.PostForm.Get("grant_type") == "mfa_otp" {
+				var req user_auth.OAuth2MfaRequest
+				if err := decoder.Decode(&req, r.PostForm); err != nil {
+					if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+						err = weberror.NewError(ctx, err, http.StatusBadRequest)
+					}
+					return web.RespondJsonError(ctx, w, err)
+				}
+
+				return h.mfaToken(ctx, w, req, v.Now)
+			}
+
 			var req user_auth.OAuth2PasswordRequest
 			if err := decoder.Decode(&req, r.PostForm); err != nil {
 				if _, ok := errors.Cause(err).(*weberror.Error); !ok {
@@ -563,6 +664,28 @@ func (h *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		switch cause {
 		case user_auth.ErrAuthenticationFailure:
 			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusUnauthorized))
+		case user_auth.ErrEmailNotVerified:
+			return web.RespondJsonError(ctx, w, weberror.NewErrorMessage(ctx, err, http.StatusForbidden,
+				"Verify your email address before logging in."))
+		case user_auth.ErrSsoRequired:
+			return web.RespondJsonError(ctx, w, weberror.NewErrorMessage(ctx, err, http.StatusForbidden,
+				"Login through the single sign-on of your account."))
+		case bruteforce.ErrTooManyAttempts:
+			retryAfter, _ := bruteforce.RetryAfter(err)
+			return web.RespondJsonError(ctx, w, weberror.NewRetryAfterError(ctx, err, retryAfter,
+				"Too many failed login attempts. Try again later."))
+		case user_auth.ErrMfaRequired:
+			return web.RespondJson(ctx, w, user_auth.MfaChallenge{
+				Error:            "mfa_required",
+				ErrorDescription: cause.Error(),
+				MfaToken:         tkn.MfaToken,
+			}, http.StatusForbidden)
+		case user_auth.ErrMfaEnrollmentRequired:
+			return web.RespondJson(ctx, w, user_auth.MfaChallenge{
+				Error:            "mfa_enrollment_required",
+				ErrorDescription: cause.Error(),
+				MfaToken:         tkn.MfaToken,
+			}, http.StatusForbidden)
 		default:
 			_, ok := cause.(validator.ValidationErrors)
 			if ok {
@@ -575,3 +698,662 @@ func (h *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Reques
 
 	return web.RespondJson(ctx, w, tkn, http.StatusOK)
 }
+
+// refreshToken handles the refresh_token grant type for Token.
+func (h *Users) refreshToken(ctx context.Context, w http.ResponseWriter, r *http.Request, req user_auth.OAuth2RefreshTokenRequest, now time.Time) error {
+	// Validate the request.
+	err := webcontext.Validator().StructCtx(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	scopes := req.Scope
+
+	// Optional to include scope.
+	if qv := r.URL.Query().Get("scope"); qv != "" {
+		scopes = strings.Split(qv, ",")
+	}
+
+	tkn, err := h.AuthRepo.Refresh(ctx, user_auth.RefreshRequest{RefreshToken: req.RefreshToken}, sessionTtl, now, scopes...)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user_auth.ErrAuthenticationFailure, user_auth.ErrRefreshTokenReused:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusUnauthorized))
+		case user_auth.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrap(err, "refreshing token")
+		}
+	}
+
+	return web.RespondJson(ctx, w, tkn, http.StatusOK)
+}
+
+// mfaToken handles the mfa_otp grant type for Token.
+func (h *Users) mfaToken(ctx context.Context, w http.ResponseWriter, req user_auth.OAuth2MfaRequest, now time.Time) error {
+	// Validate the request.
+	err := webcontext.Validator().StructCtx(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	tkn, err := h.AuthRepo.AuthenticateMfa(ctx, user_auth.MfaAuthenticateRequest{
+		MfaToken: req.MfaToken,
+		Code:     req.Otp,
+	}, sessionTtl, now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user_auth.ErrAuthenticationFailure, user.ErrMfaExpired:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusUnauthorized))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrap(err, "authenticating two-factor code")
+		}
+	}
+
+	return web.RespondJson(ctx, w, tkn, http.StatusOK)
+}
+
+// VerifyEmail godoc
+// @Summary Send the email to verify an email address.
+// @Description VerifyEmail sends an email with a link to verify the email address. The email address can either be
+// @Description the current email address of a user that has not been verified yet or a new email address requested
+// @Description by an update. No error is returned when there is no user for the email address.
+// @Tags user
+// @Accept  json
+// @Produce  json
+// @Param data body user.UserVerifyEmailRequest true "Verify fields"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /users/verify-email [post]
+func (h *Users) VerifyEmail(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req user.UserVerifyEmailRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	// The TTL is not configurable by the client.
+	req.TTL = 0
+
+	_, err = h.UserRepo.VerifyEmail(ctx, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user.ErrNotFound:
+			// Don't disclose which email addresses belong to a user.
+			return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+		case user.ErrEmailAlreadyVerified:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "Email: %s", req.Email)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// VerifyEmailConfirm godoc
+// @Summary Verify an email address.
+// @Description VerifyEmailConfirm verifies the email address using the hash included in the link of the verification
+// @Description email. When the email address was requested by an update, it replaces the current email address.
+// @Tags user
+// @Accept  json
+// @Produce  json
+// @Param data body user.UserVerifyEmailConfirmRequest true "Verify fields"
+// @Success 200 {object} user.UserResponse
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /users/verify-email/confirm [post]
+func (h *Users) VerifyEmailConfirm(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req user.UserVerifyEmailConfirmRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	res, err := h.UserRepo.VerifyEmailConfirm(ctx, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case user.ErrVerifyExpired, user.ErrEmailUnavailable:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrap(err, "verifying email")
+		}
+	}
+
+	return web.RespondJson(ctx, w, res.Response(ctx), http.StatusOK)
+}
+
+// MfaTokenEnroll godoc
+// @Summary Start two-factor authentication enrollment during authentication.
+// @Description MfaTokenEnroll generates a new two-factor authentication secret for a user that is required to enroll
+// @Description by one of their accounts. The enrollment is completed with the grant type mfa_otp.
+// @Tags user
+// @Accept  x-www-form-urlencoded
+// @Produce  json
+// @Param mfa_token formData string true "MFA Token"
+// @Success 200 {object} user.UserMfaEnrollment
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 401 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /oauth/mfa/enroll [post]
+func (h *Users) MfaTokenEnroll(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+
+	var req user_auth.MfaEnrollRequest
+	if err := decoder.Decode(&req, r.PostForm); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	res, err := h.AuthRepo.MfaEnroll(ctx, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user_auth.ErrAuthenticationFailure, user.ErrMfaExpired:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusUnauthorized))
+		case user.ErrMfaAlreadyEnabled:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrap(err, "enrolling two-factor authentication")
+		}
+	}
+
+	return web.RespondJson(ctx, w, res, http.StatusOK)
+}
+
+// MfaEnroll godoc
+// @Summary Start two-factor authentication enrollment for a user.
+// @Description MfaEnroll generates a new two-factor authentication secret and recovery codes for the user. Enrollment
+// @Description is completed by confirming a code generated by the authenticator app.
+// @Tags user
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body user.UserMfaEnrollRequest true "Enroll fields"
+// @Success 200 {object} user.UserMfaEnrollment
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /users/mfa/enroll [post]
+func (h *Users) MfaEnroll(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req user.UserMfaEnrollRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	res, err := h.UserRepo.MfaEnroll(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case user.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case user.ErrMfaAlreadyEnabled:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "Id: %s  User: %+v", req.ID, &req)
+		}
+	}
+
+	return web.RespondJson(ctx, w, res, http.StatusOK)
+}
+
+// MfaConfirm godoc
+// @Summary Confirm two-factor authentication enrollment for a user.
+// @Description MfaConfirm verifies the first code generated by the authenticator app and enables two-factor
+// @Description authentication for the user.
+// @Tags user
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body user.UserMfaVerifyRequest true "Confirm fields"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /users/mfa/confirm [post]
+func (h *Users) MfaConfirm(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req user.UserMfaVerifyRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	err = h.UserRepo.MfaVerify(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case user.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case user.ErrMfaInvalidCode, user.ErrMfaNotEnrolled:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "Id: %s  User: %+v", req.ID, &req)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// MfaDisable godoc
+// @Summary Disable two-factor authentication for a user.
+// @Description MfaDisable removes two-factor authentication for the user after verifying a current code.
+// @Tags user
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body user.UserMfaDisableRequest true "Disable fields"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /users/mfa/disable [post]
+func (h *Users) MfaDisable(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req user.UserMfaDisableRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	err = h.UserRepo.MfaDisable(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case user.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case user.ErrMfaInvalidCode, user.ErrMfaNotEnrolled:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "Id: %s  User: %+v", req.ID, &req)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// SessionFind godoc
+// @Summary List sessions for the current user.
+// @Description SessionFind returns the active sessions of the current user with the device they were started from.
+// @Tags user
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Success 200 {array} user_auth.SessionResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /users/sessions [get]
+func (h *Users) SessionFind(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.AuthRepo.SessionFind(ctx, claims, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user_auth.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			return errors.Wrapf(err, "User: %s", claims.Subject)
+		}
+	}
+
+	return web.RespondJson(ctx, w, res.Response(ctx, claims.SessionID), http.StatusOK)
+}
+
+// SessionRevoke godoc
+// @Summary Revoke a session of the current user.
+// @Description SessionRevoke logs out a session of the current user. All tokens issued for the session are rejected afterwards.
+// @Tags user
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param id path string true "Session ID"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 404 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /users/sessions/{id} [delete]
+func (h *Users) SessionRevoke(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	req := user_auth.SessionRevokeRequest{ID: params["id"]}
+
+	err = h.AuthRepo.SessionRevoke(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user_auth.ErrSessionNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		case user_auth.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "Id: %s", req.ID)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// SessionRevokeAll godoc
+// @Summary Revoke all the sessions of the current user.
+// @Description SessionRevokeAll logs out the current user everywhere. The session of the request remains active
+// @Description when except-current is set.
+// @Tags user
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param except-current query boolean false "Keep the current session active, example: true"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /users/sessions [delete]
+func (h *Users) SessionRevokeAll(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req user_auth.SessionRevokeAllRequest
+
+	// Handle except-current query value if set.
+	if v := r.URL.Query().Get("except-current"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for except-current param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		req.ExceptCurrent = b
+	}
+
+	err = h.AuthRepo.SessionRevokeAll(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user_auth.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		default:
+			return errors.Wrapf(err, "User: %s", claims.Subject)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// Revoke godoc
+// @Summary Revoke handles a request to revoke a token.
+// @Description Revoke invalidates the session for a refresh token or access token. All tokens issued for the session
+// @Description are rejected afterwards. Invalid tokens are ignored as described in RFC 7009.
+// @Tags user
+// @Accept  x-www-form-urlencoded
+// @Produce  json
+// @Param token formData string true "Token"
+// @Param token_type_hint formData string false "Token Type Hint" Enums(access_token, refresh_token)
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /oauth/revoke [post]
+func (h *Users) Revoke(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	decoder := schema.NewDecoder()
+	decoder.IgnoreUnknownKeys(true)
+
+	var req user_auth.OAuth2RevokeRequest
+	if err := decoder.Decode(&req, r.PostForm); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	err = h.AuthRepo.Revoke(ctx, user_auth.RevokeRequest{
+		Token:         req.Token,
+		TokenTypeHint: req.TokenTypeHint,
+	}, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		_, ok := cause.(validator.ValidationErrors)
+		if ok {
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+
+		return errors.Wrap(err, "revoking token")
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// Export godoc
+// @Summary Export the current user
+// @Description Export returns a zip file with the personal data and account memberships of the current user as JSON and CSV.
+// @Tags user
+// @Produce  application/zip
+// @Security OAuth2Password
+// @Success 200 {file} file
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /users/export [get]
+func (h *Users) Export(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	dat, err := h.Closure.ExportUser(ctx, claims, claims.Subject, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case user.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		default:
+			return errors.Wrapf(err, "ID: %s", claims.Subject)
+		}
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=\"user-"+claims.Subject+".zip\"")
+
+	return web.Respond(ctx, w, dat, http.StatusOK, web.MIMEApplicationZip)
+}
+
+// Close godoc
+// @Summary Close the current user
+// @Description Close archives the current user and emails a link to restore the user before their personal data is permanently deleted.
+// @Tags user
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /users/close [post]
+func (h *Users) Close(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.Closure.CloseUser(ctx, claims, closure.CloseUserRequest{UserID: claims.Subject}, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case user.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case user.ErrAccountAdmin:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		case user.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "ID: %s", claims.Subject)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
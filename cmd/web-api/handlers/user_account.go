@@ -8,12 +8,14 @@ import (
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account/invite"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account/transfer"
 
 	"github.com/pkg/errors"
 	"gopkg.in/go-playground/validator.v9"
@@ -21,13 +23,15 @@ import (
 
 // UserAccount represents the UserAccount API method handler set.
 type UserAccount struct {
-	UserInvite UserInviteRepository
-	Repository UserAccountRepository
+	UserInvite   UserInviteRepository
+	UserTransfer UserTransferRepository
+	Repository   UserAccountRepository
 	// ADD OTHER STATE LIKE THE LOGGER AND CONFIG HERE.
 }
 
 type UserAccountRepository interface {
 	Find(ctx context.Context, claims auth.Claims, req user_account.UserAccountFindRequest) (user_account.UserAccounts, error)
+	Count(ctx context.Context, claims auth.Claims, req user_account.UserAccountFindRequest) (int, error)
 	FindByUserID(ctx context.Context, claims auth.Claims, userID string, includedArchived bool) (user_account.UserAccounts, error)
 	UserFindByAccount(ctx context.Context, claims auth.Claims, req user_account.UserFindByAccountRequest) (user_account.Users, error)
 	Create(ctx context.Context, claims auth.Claims, req user_account.UserAccountCreateRequest, now time.Time) (*user_account.UserAccount, error)
@@ -43,6 +47,11 @@ type UserInviteRepository interface {
 	AcceptInviteUser(ctx context.Context, req invite.AcceptInviteUserRequest, now time.Time) (*user_account.UserAccount, error)
 }
 
+type UserTransferRepository interface {
+	SendTransfer(ctx context.Context, claims auth.Claims, req transfer.SendTransferRequest, now time.Time) (string, error)
+	AcceptTransfer(ctx context.Context, req transfer.AcceptTransferRequest, now time.Time) (*user_account.UserAccount, error)
+}
+
 // Find godoc
 // TODO: Need to implement unittests on user_accounts/find endpoint. There are none.
 // @Summary List user accounts
@@ -51,12 +60,17 @@ type UserInviteRepository interface {
 // @Accept  json
 // @Produce  json
 // @Security OAuth2Password
-// @Param where				query string 	false	"Filter string, example: account_id = 'c4653bf9-5978-48b7-89c5-95704aebb7e2'"
-// @Param order				query string   	false 	"Order columns separated by comma, example: created_at desc"
+// @Param filter[field][operator]	query string 	false	"Filter by field with an optional operator, example: filter[status]=active. Fields: user_id, account_id, status, created_at, updated_at, archived_at. Operators: eq, ne, contains, starts_with, in, gt, gte, lt, lte, null"
+// @Param order				query string   	false 	"Order columns separated by comma, example: created_at desc. Fields: user_id, account_id, status, created_at, updated_at"
 // @Param limit				query integer  	false 	"Limit, example: 10"
 // @Param offset			query integer  	false 	"Offset, example: 20"
+// @Param cursor			query string  	false 	"Cursor returned by the previous page in next_cursor, the order can't be changed when paging"
+// @Param include-total	query boolean 	false 	"Include the total number of results in the X-Total-Count header, example: false"
 // @Param include-archived query boolean 	false 	"Included Archived, example: false"
-// @Success 200 {array} user_account.UserAccountResponse
+// @Success 200 {object} web.PageResponse "data contains the list of user_account.UserAccountResponse"
+// @Header 200 {string} Link "Links to the next and first pages"
+// @Header 200 {string} X-Next-Cursor "Cursor for the next page, only set when there are more results"
+// @Header 200 {string} X-Total-Count "Total number of results, only set when include-total is true"
 // @Failure 400 {object} weberror.ErrorResponse
 // @Failure 403 {object} weberror.ErrorResponse
 // @Failure 500 {object} weberror.ErrorResponse
@@ -69,26 +83,25 @@ func (h *UserAccount) Find(ctx context.Context, w http.ResponseWriter, r *http.R
 
 	var req user_account.UserAccountFindRequest
 
-	// Handle where query value if set.
-	if v := r.URL.Query().Get("where"); v != "" {
-		where, args, err := web.ExtractWhereArgs(v)
-		if err != nil {
-			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
-		}
-		req.Where = where
-		req.Args = args
+	// Handle filter query values if set.
+	filters, err := filter.Parse(r.URL.Query(), user_account.UserAccountFindFields)
+	if err != nil {
+		return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 	}
+	req.Filter = filters
 
 	// Handle order query value if set.
 	if v := r.URL.Query().Get("order"); v != "" {
-		for _, o := range strings.Split(v, ",") {
-			o = strings.TrimSpace(o)
-			if o != "" {
-				req.Order = append(req.Order, o)
-			}
+		order, err := user_account.UserAccountFindFields.Order(strings.Split(v, ","))
+		if err != nil {
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		}
+		req.Order = order
 	}
 
+	// Include the key fields in the order so the rows have a stable order for cursor pagination.
+	req.Order = user_account.UserAccountFindFields.KeyOrder(req.Order)
+
 	// Handle limit query value if set.
 	if v := r.URL.Query().Get("limit"); v != "" {
 		l, err := strconv.Atoi(v)
@@ -108,7 +121,23 @@ func (h *UserAccount) Find(ctx context.Context, w http.ResponseWriter, r *http.R
 			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		}
 		ul := uint(l)
-		req.Limit = &ul
+		req.Offset = &ul
+	}
+
+	// Handle cursor query value if set.
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		req.Cursor = v
+	}
+
+	// Handle include-total query value if set.
+	var includeTotal bool
+	if v := r.URL.Query().Get("include-total"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			err = errors.WithMessagef(err, "unable to parse %s as boolean for include-total param", v)
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		}
+		includeTotal = b
 	}
 
 	// Handle order query value if set.
@@ -128,17 +157,37 @@ func (h *UserAccount) Find(ctx context.Context, w http.ResponseWriter, r *http.R
 	//	return  web.RespondJsonError(ctx, w, err)
 	//}
 
+	// Fetch one more row than the limit to determine if there is a next page.
+	limit := req.Limit
+	req.Limit = filter.FetchLimit(limit)
+
 	res, err := h.Repository.Find(ctx, claims, req)
 	if err != nil {
 		return err
 	}
 
+	var total *int
+	if includeTotal {
+		cnt, err := h.Repository.Count(ctx, claims, req)
+		if err != nil {
+			return err
+		}
+		total = &cnt
+	}
+
+	nextCursor, more, err := user_account.UserAccountFindFields.NextPage(req.Order, limit, &res)
+	if err != nil {
+		return err
+	}
+
+	web.SetPageHeaders(w, r, more, limit, req.Offset, nextCursor, total)
+
 	var resp []*user_account.UserAccountResponse
 	for _, m := range res {
 		resp = append(resp, m.Response(ctx))
 	}
 
-	return web.RespondJson(ctx, w, resp, http.StatusOK)
+	return web.RespondJson(ctx, w, web.NewPageResponse(resp, nextCursor), http.StatusOK)
 }
 
 // Read godoc
@@ -228,6 +277,8 @@ func (h *UserAccount) Create(ctx context.Context, w http.ResponseWriter, r *http
 		switch cause {
 		case user_account.ErrForbidden:
 			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case user_account.ErrInvalidRole, user_account.ErrLastAdmin, user_account.ErrOwnerRole:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		default:
 			_, ok := cause.(validator.ValidationErrors)
 			if ok {
@@ -279,6 +330,8 @@ func (h *UserAccount) Update(ctx context.Context, w http.ResponseWriter, r *http
 		switch cause {
 		case user_account.ErrForbidden:
 			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case user_account.ErrInvalidRole, user_account.ErrLastAdmin, user_account.ErrOwnerRole:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		default:
 			_, ok := cause.(validator.ValidationErrors)
 			if ok {
@@ -330,6 +383,8 @@ func (h *UserAccount) Archive(ctx context.Context, w http.ResponseWriter, r *htt
 		switch cause {
 		case user_account.ErrForbidden:
 			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case user_account.ErrInvalidRole, user_account.ErrLastAdmin, user_account.ErrOwnerRole:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		default:
 			_, ok := cause.(validator.ValidationErrors)
 			if ok {
@@ -376,6 +431,64 @@ func (h *UserAccount) Delete(ctx context.Context, w http.ResponseWriter, r *http
 		switch cause {
 		case user_account.ErrForbidden:
 			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case user_account.ErrLastAdmin, user_account.ErrOwnerRole:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+		default:
+			_, ok := cause.(validator.ValidationErrors)
+			if ok {
+				return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
+			}
+
+			return errors.Wrapf(err, "UserID: %s, AccountID: %s", req.UserID, req.AccountID)
+		}
+	}
+
+	return web.RespondJson(ctx, w, nil, http.StatusNoContent)
+}
+
+// Transfer godoc
+// @Summary Transfer the ownership of an account
+// @Description Transfer sends an email to an active user of the account to confirm they accept the ownership of the account.
+// @Tags user_account
+// @Accept  json
+// @Produce  json
+// @Security OAuth2Password
+// @Param data body transfer.SendTransferRequest true "Transfer details"
+// @Success 204
+// @Failure 400 {object} weberror.ErrorResponse
+// @Failure 403 {object} weberror.ErrorResponse
+// @Failure 500 {object} weberror.ErrorResponse
+// @Router /user_accounts/transfer [post]
+func (h *UserAccount) Transfer(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	v, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var req transfer.SendTransferRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		if _, ok := errors.Cause(err).(*weberror.Error); !ok {
+			err = weberror.NewError(ctx, err, http.StatusBadRequest)
+		}
+		return web.RespondJsonError(ctx, w, err)
+	}
+
+	// The expiration of the transfer can't be set by the client.
+	req.TTL = 0
+
+	_, err = h.UserTransfer.SendTransfer(ctx, claims, req, v.Now)
+	if err != nil {
+		cause := errors.Cause(err)
+		switch cause {
+		case transfer.ErrForbidden, user_account.ErrForbidden:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+		case transfer.ErrUserNotActive, user_account.ErrNotFound:
+			return web.RespondJsonError(ctx, w, weberror.NewError(ctx, err, http.StatusBadRequest))
 		default:
 			_, ok := cause.(validator.ValidationErrors)
 			if ok {
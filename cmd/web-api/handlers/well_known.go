@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+)
+
+// WellKnown exposes the public keys and the OpenID Connect discovery document so other services can validate the
+// tokens offline.
+type WellKnown struct {
+	Authenticator *auth.Authenticator
+
+	// ADD OTHER STATE LIKE THE LOGGER IF NEEDED.
+}
+
+// OpenIDConfiguration is the OpenID Connect discovery document.
+type OpenIDConfiguration struct {
+	Issuer                           string   `json:"issuer" example:"https://api.example.saasstartupkit.com"`
+	JwksUri                          string   `json:"jwks_uri" example:"https://api.example.saasstartupkit.com/.well-known/jwks.json"`
+	TokenEndpoint                    string   `json:"token_endpoint" example:"https://api.example.saasstartupkit.com/v1/oauth/token"`
+	RevocationEndpoint               string   `json:"revocation_endpoint" example:"https://api.example.saasstartupkit.com/v1/oauth/revoke"`
+	ResponseTypesSupported           []string `json:"response_types_supported" example:"token"`
+	GrantTypesSupported              []string `json:"grant_types_supported" example:"password,refresh_token"`
+	SubjectTypesSupported            []string `json:"subject_types_supported" example:"public"`
+	IdTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported" example:"RS256"`
+	ScopesSupported                  []string `json:"scopes_supported" example:"user,admin"`
+	ClaimsSupported                  []string `json:"claims_supported" example:"sub,aud,iss,exp,iat,roles"`
+}
+
+// Jwks returns the public keys used to sign the tokens.
+func (h *WellKnown) Jwks(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	// New keys are published before they are used to sign tokens so clients
+	// can cache the keys for up to the max age.
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(auth.JwksMaxAge.Seconds())))
+
+	return web.RespondJson(ctx, w, h.Authenticator.JWKS(), http.StatusOK)
+}
+
+// OpenIDConfiguration returns the OpenID Connect discovery document.
+func (h *WellKnown) OpenIDConfiguration(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	issuer := h.Authenticator.Issuer
+	if issuer == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		issuer = scheme + "://" + r.Host
+	}
+	issuer = strings.TrimRight(issuer, "/")
+
+	var algs []string
+	for _, k := range h.Authenticator.JWKS().Keys {
+		if !containsString(algs, k.Alg) {
+			algs = append(algs, k.Alg)
+		}
+	}
+
+	data := OpenIDConfiguration{
+		Issuer:                           issuer,
+		JwksUri:                          issuer + "/.well-known/jwks.json",
+		TokenEndpoint:                    issuer + "/v1/oauth/token",
+		RevocationEndpoint:               issuer + "/v1/oauth/revoke",
+		ResponseTypesSupported:           []string{"token"},
+		GrantTypesSupported:              []string{"password", "refresh_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IdTokenSigningAlgValuesSupported: algs,
+		ScopesSupported:                  []string{auth.RoleAdmin, auth.RoleUser},
+		ClaimsSupported:                  []string{"sub", "aud", "iss", "exp", "iat", "root_user_id", "root_account_id", "accounts", "roles", "perms", "sid"},
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	return web.RespondJson(ctx, w, data, http.StatusOK)
+}
+
+// containsString returns true when the list includes the value.
+func containsString(l []string, v string) bool {
+	for _, s := range l {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -22,19 +22,31 @@ import (
 	"geeks-accelerator/oss/saas-starter-kit/cmd/web-api/handlers"
 	"geeks-accelerator/oss/saas-starter-kit/internal/account"
 	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_role"
+	"geeks-accelerator/oss/saas-starter-kit/internal/api_key"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/billing"
+	"geeks-accelerator/oss/saas-starter-kit/internal/closure"
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
+	"geeks-accelerator/oss/saas-starter-kit/internal/jobs"
 	"geeks-accelerator/oss/saas-starter-kit/internal/mid"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/bruteforce"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/devops"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/flag"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/notify"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/project"
 	"geeks-accelerator/oss/saas-starter-kit/internal/project_route"
+	"geeks-accelerator/oss/saas-starter-kit/internal/saml"
+	"geeks-accelerator/oss/saas-starter-kit/internal/scim"
 	"geeks-accelerator/oss/saas-starter-kit/internal/signup"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account/invite"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account/transfer"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/webhook"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -69,6 +81,10 @@ var service = "WEB_API"
 
 // @securityDefinitions.basic BasicAuth
 
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name Authorization
+
 // @securitydefinitions.oauth2.password OAuth2Password
 // @tokenUrl /v1/oauth/token
 // @scope.user Grants basic privileges with role of user.
@@ -105,6 +121,9 @@ func main() {
 			TemplateDir     string        `default:"./templates" envconfig:"TEMPLATE_DIR"`
 			DebugHost       string        `default:"0.0.0.0:4000" envconfig:"DEBUG_HOST"`
 			ShutdownTimeout time.Duration `default:"5s" envconfig:"SHUTDOWN_TIMEOUT"`
+			// TrustedProxyHops is the number of proxies in front of the service, ie 1 for a load balancer. The
+			// client IP is read from the address appended to X-Forwarded-For by the first of these proxies.
+			TrustedProxyHops int `default:"0" envconfig:"TRUSTED_PROXY_HOPS"`
 		}
 		Project struct {
 			Name              string `default:"" envconfig:"PROJECT_NAME"`
@@ -113,6 +132,27 @@ func main() {
 			EmailSender       string `default:"test@example.saasstartupkit.com" envconfig:"EMAIL_SENDER"`
 			WebAppBaseUrl     string `default:"http://127.0.0.1:3000" envconfig:"WEB_APP_BASE_URL" example:"www.example.saasstartupkit.com"`
 		}
+		Webhook struct {
+			DeliveryInterval time.Duration `default:"10s" envconfig:"DELIVERY_INTERVAL"`
+			MaxAttempts      int           `default:"8" envconfig:"MAX_ATTEMPTS"`
+			RetryBackoff     time.Duration `default:"1m" envconfig:"RETRY_BACKOFF"`
+		}
+		Billing struct {
+			Provider         string            `default:"fake" envconfig:"PROVIDER" example:"stripe"`
+			StripeSecretKey  string            `envconfig:"STRIPE_SECRET_KEY" json:"-"` // don't print
+			WebhookSecret    string            `envconfig:"WEBHOOK_SECRET" json:"-"`    // don't print
+			PlanPrices       map[string]string `envconfig:"PLAN_PRICES" example:"starter:price_1FDs,team:price_1FDt"`
+			SeatSyncInterval time.Duration     `default:"1h" envconfig:"SEAT_SYNC_INTERVAL"`
+		}
+		Closure struct {
+			GracePeriod time.Duration `default:"720h" envconfig:"GRACE_PERIOD"`
+		}
+		Entitlements struct {
+			DefaultPlan string `default:"unlimited" envconfig:"DEFAULT_PLAN" example:"free"`
+		}
+		Jobs struct {
+			SendEmailAsync bool `default:"true" envconfig:"SEND_EMAIL_ASYNC"`
+		}
 		Redis struct {
 			Host            string        `default:":6379" envconfig:"HOST"`
 			DB              int           `default:"1" envconfig:"DB"`
@@ -127,6 +167,9 @@ func main() {
 			Driver     string `default:"postgres" envconfig:"DRIVER"`
 			Timezone   string `default:"utc" envconfig:"TIMEZONE"`
 			DisableTLS bool   `default:"true" envconfig:"DISABLE_TLS"`
+			// RowLevelSecurity enforces the tenant isolation policies of the database in addition to the ACL
+			// applied by the repositories. The policies are created by the schema migrations.
+			RowLevelSecurity bool `default:"false" envconfig:"ROW_LEVEL_SECURITY"`
 		}
 		Trace struct {
 			Host          string  `default:"127.0.0.1" envconfig:"DD_TRACE_AGENT_HOSTNAME"`
@@ -149,6 +192,17 @@ func main() {
 		Auth struct {
 			UseAwsSecretManager bool          `default:"false" envconfig:"USE_AWS_SECRET_MANAGER"`
 			KeyExpiration       time.Duration `default:"3600s" envconfig:"KEY_EXPIRATION"`
+			Algorithm           string        `default:"RS256" envconfig:"ALGORITHM"`
+			SignerKeyFile       string        `envconfig:"SIGNER_KEY_FILE"`
+			ReloadInterval      time.Duration `default:"5m" envconfig:"RELOAD_INTERVAL"`
+		}
+		BruteForce struct {
+			FreeAttempts int           `default:"3" envconfig:"FREE_ATTEMPTS"`
+			MaxAttempts  int           `default:"10" envconfig:"MAX_ATTEMPTS"`
+			BaseDelay    time.Duration `default:"1s" envconfig:"BASE_DELAY"`
+			MaxDelay     time.Duration `default:"30s" envconfig:"MAX_DELAY"`
+			Lockout      time.Duration `default:"15m" envconfig:"LOCKOUT"`
+			Window       time.Duration `default:"1h" envconfig:"WINDOW"`
 		}
 		BuildInfo struct {
 			CiCommitRefName  string `envconfig:"CI_COMMIT_REF_NAME"`
@@ -418,19 +472,53 @@ func main() {
 		notifyEmail = notify.NewEmailDisabled()
 	}
 
+	// Emails are enqueued and sent by the worker so the requests don't wait on the
+	// email provider. The worker service needs to be running with the same shared
+	// secret key to send them, otherwise disable SEND_EMAIL_ASYNC to send them
+	// during the request.
+	if cfg.Jobs.SendEmailAsync {
+		notifyEmail = jobs.NewEmailQueue(masterDb, cfg.Project.SharedSecretKey)
+	}
+
 	// =========================================================================
 	// Init new Authenticator
+
+	// New keys are published for the reload interval plus the time clients
+	// cache the public keys before they are used, so every instance of the
+	// service and every client has them before the first token is signed.
+	publishDelay := cfg.Auth.ReloadInterval + auth.JwksMaxAge
+
 	var authenticator *auth.Authenticator
-	if cfg.Auth.UseAwsSecretManager {
+	if cfg.Auth.SignerKeyFile != "" {
+		// The key is managed outside of the service and is not rotated.
+		var signer *auth.FileSigner
+		signer, err = auth.NewFileSigner(cfg.Auth.SignerKeyFile)
+		if err == nil {
+			authenticator, err = auth.NewAuthenticatorSigner(signer, time.Now().UTC())
+		}
+	} else if cfg.Auth.UseAwsSecretManager {
 		secretName := filepath.Join(cfg.Aws.SecretsManagerConfigPrefix, "authenticator")
-		authenticator, err = auth.NewAuthenticatorAws(awsSession, secretName, time.Now().UTC(), cfg.Auth.KeyExpiration)
+		authenticator, err = auth.NewAuthenticatorAws(awsSession, secretName, time.Now().UTC(), cfg.Auth.KeyExpiration, publishDelay, cfg.Auth.Algorithm)
 	} else {
-		authenticator, err = auth.NewAuthenticatorFile("", time.Now().UTC(), cfg.Auth.KeyExpiration)
+		authenticator, err = auth.NewAuthenticatorFile("", time.Now().UTC(), cfg.Auth.KeyExpiration, publishDelay, cfg.Auth.Algorithm)
 	}
 	if err != nil {
 		log.Fatalf("main : Constructing authenticator : %+v", err)
 	}
 
+	// Tokens are issued by the web-api, which exposes the public keys so other
+	// services can validate them.
+	authenticator.Issuer = cfg.Service.BaseUrl
+
+	// Reload the keys so keys rotated by other instances of the service are
+	// used without a restart.
+	if cfg.Auth.ReloadInterval > 0 {
+		stopReload := authenticator.StartReload(cfg.Auth.ReloadInterval, func(err error) {
+			log.Printf("main : Authenticator Reload : %+v", err)
+		})
+		defer stopReload()
+	}
+
 	// =========================================================================
 	// Init repositories and AppContext
 
@@ -440,13 +528,79 @@ func main() {
 	}
 
 	usrRepo := user.NewRepository(masterDb, projectRoute.UserResetPassword, notifyEmail, cfg.Project.SharedSecretKey)
+	usrRepo.MfaIssuer = cfg.Project.Name
+	usrRepo.VerifyUrl = projectRoute.UserVerifyEmail
 	usrAccRepo := user_account.NewRepository(masterDb)
 	accRepo := account.NewRepository(masterDb)
+	accRepo.PurgeGracePeriod = cfg.Closure.GracePeriod
+	usrRepo.PurgeGracePeriod = cfg.Closure.GracePeriod
 	accPrefRepo := account_preference.NewRepository(masterDb)
+	accRoleRepo := account_role.NewRepository(masterDb)
 	authRepo := user_auth.NewRepository(masterDb, authenticator, usrRepo, usrAccRepo, accPrefRepo)
+
+	// Throttle failed logins and password reset requests by IP address and email address.
+	bruteForceLimiter := bruteforce.New(redisClient, "bruteforce", bruteforce.Config{
+		FreeAttempts: cfg.BruteForce.FreeAttempts,
+		MaxAttempts:  cfg.BruteForce.MaxAttempts,
+		BaseDelay:    cfg.BruteForce.BaseDelay,
+		MaxDelay:     cfg.BruteForce.MaxDelay,
+		Lockout:      cfg.BruteForce.Lockout,
+		Window:       cfg.BruteForce.Window,
+	})
+	usrRepo.Limiter = bruteForceLimiter
+	authRepo.Limiter = bruteForceLimiter
+
+	// Reject access tokens for sessions that have been revoked.
+	authenticator.SessionValidator = authRepo
+
+	// Password logins are disabled for users of accounts that enforce SAML single sign-on.
+	samlRepo := saml.NewRepository(masterDb, usrRepo, usrAccRepo, projectRoute.WebAppUrl)
+	authRepo.Sso = samlRepo
+
 	signupRepo := signup.NewRepository(masterDb, usrRepo, usrAccRepo, accRepo)
 	inviteRepo := invite.NewRepository(masterDb, usrRepo, usrAccRepo, accRepo, projectRoute.UserInviteAccept, notifyEmail, cfg.Project.SharedSecretKey)
+	transferRepo := transfer.NewRepository(masterDb, usrRepo, usrAccRepo, accRepo, projectRoute.AccountTransferAccept, notifyEmail, cfg.Project.SharedSecretKey)
 	prjRepo := project.NewRepository(masterDb)
+	closureRepo := closure.NewRepository(masterDb, usrRepo, usrAccRepo, accRepo, accPrefRepo, prjRepo, projectRoute.AccountRestore, notifyEmail, cfg.Project.SharedSecretKey)
+	auditRepo := audit.NewRepository(masterDb)
+	webhookRepo := webhook.NewRepository(masterDb)
+	webhookRepo.MaxAttempts = cfg.Webhook.MaxAttempts
+	webhookRepo.RetryBackoff = cfg.Webhook.RetryBackoff
+	apiKeyRepo := api_key.NewRepository(masterDb)
+	scimRepo := scim.NewRepository(masterDb, usrRepo, usrAccRepo, accRoleRepo, samlRepo, projectRoute.WebApiUrl)
+
+	// The fake provider doesn't charge accounts and should only be used for development.
+	var billingProvider billing.Provider
+	switch cfg.Billing.Provider {
+	case "stripe":
+		billingProvider = billing.NewStripeProvider(cfg.Billing.StripeSecretKey, cfg.Billing.WebhookSecret)
+	case "fake":
+		billingProvider = billing.NewFakeProvider(cfg.Billing.WebhookSecret)
+	default:
+		log.Fatalf("main : Billing provider %s not supported", cfg.Billing.Provider)
+	}
+	billingRepo := billing.NewRepository(masterDb, accRepo, billingProvider)
+
+	// Map the plans to the prices created at the provider.
+	for i, p := range billingRepo.Plans {
+		if priceID, ok := cfg.Billing.PlanPrices[p.ID]; ok {
+			billingRepo.Plans[i].PriceID = priceID
+		}
+	}
+
+	// Enforce the limits of the plans before projects are created and users are invited.
+	entRepo := entitlement.NewRepository(masterDb, billingRepo)
+	entRepo.DefaultPlanID = cfg.Entitlements.DefaultPlan
+	prjRepo.Entitlements = entRepo
+	inviteRepo.Entitlements = entRepo
+
+	// Scope the queries of the repositories for tables with row level security policies to the tenant of the claims.
+	prjRepo.RowLevelSecurity = cfg.DB.RowLevelSecurity
+	usrAccRepo.RowLevelSecurity = cfg.DB.RowLevelSecurity
+	accPrefRepo.RowLevelSecurity = cfg.DB.RowLevelSecurity
+
+	// Allow clients to authenticate with an API key instead of an access token.
+	authenticator.ApiKeyResolver = apiKeyRepo
 
 	appCtx := &handlers.AppContext{
 		Log:             log,
@@ -457,11 +611,23 @@ func main() {
 		UserAccountRepo: usrAccRepo,
 		AccountRepo:     accRepo,
 		AccountPrefRepo: accPrefRepo,
+		AccountRoleRepo: accRoleRepo,
 		AuthRepo:        authRepo,
 		SignupRepo:      signupRepo,
 		InviteRepo:      inviteRepo,
+		TransferRepo:    transferRepo,
+		ClosureRepo:     closureRepo,
 		ProjectRepo:     prjRepo,
+		AuditRepo:       auditRepo,
+		WebhookRepo:     webhookRepo,
+		ApiKeyRepo:      apiKeyRepo,
+		BillingRepo:     billingRepo,
+		ScimRepo:        scimRepo,
+		EntitlementRepo: entRepo,
+		RateLimitStore:  mid.NewRedisRateLimitStore(redisClient, "ratelimit"),
 		Authenticator:   authenticator,
+
+		TrustedProxyHops: cfg.Service.TrustedProxyHops,
 	}
 
 	// =========================================================================
@@ -509,6 +675,45 @@ func main() {
 		}()
 	}
 
+	// =========================================================================
+	// Start Webhook Delivery. Pending deliveries are reserved with a lease so
+	// multiple instances of the service can safely deliver concurrently and
+	// any deliveries interrupted by shutdown are retried once the lease expires.
+	if cfg.Webhook.DeliveryInterval > 0 {
+		go func() {
+			log.Printf("main : Webhook Delivery Started : %v", cfg.Webhook.DeliveryInterval)
+
+			ticker := time.NewTicker(cfg.Webhook.DeliveryInterval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				_, err := webhookRepo.DeliverPending(context.Background(), time.Now())
+				if err != nil {
+					log.Printf("main : Webhook Delivery : %+v", err)
+				}
+			}
+		}()
+	}
+
+	// =========================================================================
+	// Start Billing Seat Sync. The seats of the subscriptions are updated at the
+	// provider when users have been added or removed from the accounts.
+	if cfg.Billing.SeatSyncInterval > 0 {
+		go func() {
+			log.Printf("main : Billing Seat Sync Started : %v", cfg.Billing.SeatSyncInterval)
+
+			ticker := time.NewTicker(cfg.Billing.SeatSyncInterval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				_, err := billingRepo.SyncSeats(context.Background(), time.Now())
+				if err != nil {
+					log.Printf("main : Billing Seat Sync : %+v", err)
+				}
+			}
+		}()
+	}
+
 	// =========================================================================
 	// ECS Task registration for services that don't use an AWS Elastic Load Balancer.
 	err = devops.EcsServiceTaskInit(log, awsSession)
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/api_key"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+)
+
+// executeApiKeyRequest executes the request authenticated with the API key.
+func executeApiKeyRequest(t *testing.T, method, url, key string, request interface{}) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	if request != nil {
+		if err := json.NewEncoder(&body).Encode(request); err != nil {
+			t.Logf("\t\tGot err : %+v", err)
+			t.Fatalf("\t%s\tEncode request failed.", tests.Failed)
+		}
+	}
+
+	r := httptest.NewRequest(method, url, &body).WithContext(tests.Context())
+	r.Header.Set("Content-Type", web.MIMEApplicationJSONCharsetUTF8)
+	r.Header.Set("Authorization", "ApiKey "+key)
+
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+
+	return w
+}
+
+// TestApiKeyAuthenticate tests clients can authenticate with an API key and are limited to the roles of the key.
+func TestApiKeyAuthenticate(t *testing.T) {
+	defer tests.Recover(t)
+
+	tr := roleTests[auth.RoleAdmin]
+
+	// Add claims to the context for the API key.
+	ctx := context.WithValue(tests.Context(), auth.Key, tr.Claims)
+
+	// Create a personal API key with role user.
+	var created api_key.ApiKeyResponse
+	{
+		expectedStatus := http.StatusCreated
+
+		rt := requestTest{
+			fmt.Sprintf("Create %d w/role %s", expectedStatus, tr.Role),
+			http.MethodPost,
+			"/v1/api_keys",
+			api_key.ApiKeyCreateRequest{
+				Name:  "CI deploys",
+				Roles: []string{auth.RoleUser},
+			},
+			tr.Token,
+			tr.Claims,
+			expectedStatus,
+			nil,
+		}
+		t.Logf("\tTest: %s - %s %s", rt.name, rt.method, rt.url)
+
+		w, ok := executeRequestTest(t, rt, ctx)
+		if !ok {
+			t.Fatalf("\t%s\tExecute request failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tReceived valid status code of %d.", tests.Success, w.Code)
+
+		if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+			t.Logf("\t\tGot error : %+v", err)
+			t.Fatalf("\t%s\tDecode response body failed.", tests.Failed)
+		} else if created.Key == "" {
+			t.Fatalf("\t%s\tExpected key to be included in the response.", tests.Failed)
+		}
+		t.Logf("\t%s\tReceived expected result.", tests.Success)
+	}
+
+	// The API key can be used to access endpoints allowed for role user.
+	{
+		w := executeApiKeyRequest(t, http.MethodGet, "/v1/projects", created.Key, nil)
+		if w.Code != http.StatusOK {
+			t.Logf("\t\tBody : %s\n", w.Body.String())
+			t.Fatalf("\t%s\tShould receive a status code of %d for the response : %v", tests.Failed, http.StatusOK, w.Code)
+		}
+		t.Logf("\t%s\tFind projects with API key ok.", tests.Success)
+	}
+
+	// The API key can't be used for endpoints that require a permission not granted to the key.
+	{
+		req := mockProjectCreateRequest(tr.Account.ID)
+		w := executeApiKeyRequest(t, http.MethodPost, "/v1/projects", created.Key, req)
+		if w.Code != http.StatusForbidden {
+			t.Logf("\t\tBody : %s\n", w.Body.String())
+			t.Fatalf("\t%s\tShould receive a status code of %d for the response : %v", tests.Failed, http.StatusForbidden, w.Code)
+		}
+		t.Logf("\t%s\tCreate project with API key forbidden ok.", tests.Success)
+	}
+
+	// Revoke the API key.
+	{
+		expectedStatus := http.StatusNoContent
+
+		rt := requestTest{
+			fmt.Sprintf("Archive %d w/role %s", expectedStatus, tr.Role),
+			http.MethodPatch,
+			"/v1/api_keys/archive",
+			api_key.ApiKeyArchiveRequest{ID: created.ID},
+			tr.Token,
+			tr.Claims,
+			expectedStatus,
+			nil,
+		}
+		t.Logf("\tTest: %s - %s %s", rt.name, rt.method, rt.url)
+
+		_, ok := executeRequestTest(t, rt, ctx)
+		if !ok {
+			t.Fatalf("\t%s\tExecute request failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tReceived valid status code of %d.", tests.Success, expectedStatus)
+	}
+
+	// Revoked API keys are rejected.
+	{
+		w := executeApiKeyRequest(t, http.MethodGet, "/v1/projects", created.Key, nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Logf("\t\tBody : %s\n", w.Body.String())
+			t.Fatalf("\t%s\tShould receive a status code of %d for the response : %v", tests.Failed, http.StatusUnauthorized, w.Code)
+		}
+		t.Logf("\t%s\tFind projects with revoked API key ok.", tests.Success)
+	}
+}
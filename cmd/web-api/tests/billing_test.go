@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/billing"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/webhook"
+)
+
+// billingWebhookSecret is the secret used to sign the events of the fake billing provider.
+const billingWebhookSecret = "whsec_test"
+
+// TestBillingPlans tests the plans are returned for authenticated users.
+func TestBillingPlans(t *testing.T) {
+	defer tests.Recover(t)
+
+	tr := roleTests[auth.RoleUser]
+
+	// Add claims to the context for the user.
+	ctx := context.WithValue(tests.Context(), auth.Key, tr.Claims)
+
+	expectedStatus := http.StatusOK
+
+	rt := requestTest{
+		fmt.Sprintf("Plans %d w/role %s", expectedStatus, tr.Role),
+		http.MethodGet,
+		"/v1/billing/plans",
+		nil,
+		tr.Token,
+		tr.Claims,
+		expectedStatus,
+		nil,
+	}
+	t.Logf("\tTest: %s - %s %s", rt.name, rt.method, rt.url)
+
+	w, ok := executeRequestTest(t, rt, ctx)
+	if !ok {
+		t.Fatalf("\t%s\tExecute request failed.", tests.Failed)
+	}
+	t.Logf("\t%s\tReceived valid status code of %d.", tests.Success, w.Code)
+
+	var plans []billing.Plan
+	if err := json.Unmarshal(w.Body.Bytes(), &plans); err != nil {
+		t.Logf("\t\tGot error : %+v", err)
+		t.Fatalf("\t%s\tDecode response body failed.", tests.Failed)
+	} else if len(plans) != len(billing.DefaultPlans) {
+		t.Fatalf("\t%s\tExpected %d plans, got %d.", tests.Failed, len(billing.DefaultPlans), len(plans))
+	}
+	t.Logf("\t%s\tReceived expected result.", tests.Success)
+}
+
+// TestBillingWebhook tests the events sent by the provider are only accepted with a valid signature.
+func TestBillingWebhook(t *testing.T) {
+	defer tests.Recover(t)
+
+	payload := []byte(`{"id":"evt_test_webhook","object":"event","type":"customer.created","data":{"object":{}}}`)
+
+	var tt = []struct {
+		name       string
+		signature  string
+		statusCode int
+	}{
+		{"Valid signature", webhook.Sign(billingWebhookSecret, time.Now(), payload), http.StatusOK},
+		{"Invalid signature", webhook.Sign("whsec_invalid", time.Now(), payload), http.StatusBadRequest},
+		{"Missing signature", "", http.StatusBadRequest},
+	}
+
+	for i, tt := range tt {
+		t.Logf("\tTest: %d\tWhen running test: %s", i, tt.name)
+
+		r := httptest.NewRequest(http.MethodPost, "/v1/billing/webhook", bytes.NewReader(payload)).WithContext(tests.Context())
+		if tt.signature != "" {
+			r.Header.Set(billing.HeaderSignature, tt.signature)
+		}
+
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+
+		if w.Code != tt.statusCode {
+			t.Logf("\t\tBody : %s\n", w.Body.String())
+			t.Fatalf("\t%s\tShould receive a status code of %d for the response : %v", tests.Failed, tt.statusCode, w.Code)
+		}
+		t.Logf("\t%s\tReceived valid status code of %d.", tests.Success, w.Code)
+	}
+}
@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+)
+
+// TestEntitlementRead tests the entitlements are returned for the plan of the account. Accounts without a
+// subscription get the unlimited default plan.
+func TestEntitlementRead(t *testing.T) {
+	defer tests.Recover(t)
+
+	for _, tr := range roleTests {
+		// Add claims to the context for the user.
+		ctx := context.WithValue(tests.Context(), auth.Key, tr.Claims)
+
+		expectedStatus := http.StatusOK
+
+		rt := requestTest{
+			fmt.Sprintf("Read %d w/role %s", expectedStatus, tr.Role),
+			http.MethodGet,
+			"/v1/entitlements",
+			nil,
+			tr.Token,
+			tr.Claims,
+			expectedStatus,
+			nil,
+		}
+		t.Logf("\tTest: %s - %s %s", rt.name, rt.method, rt.url)
+
+		w, ok := executeRequestTest(t, rt, ctx)
+		if !ok {
+			t.Fatalf("\t%s\tExecute request failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tReceived valid status code of %d.", tests.Success, w.Code)
+
+		var actual entitlement.EntitlementsResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &actual); err != nil {
+			t.Logf("\t\tGot error : %+v", err)
+			t.Fatalf("\t%s\tDecode response body failed.", tests.Failed)
+		} else if actual.AccountID != tr.Account.ID || actual.PlanID != entitlement.PlanID_Unlimited {
+			t.Logf("\t\tGot : %+v", actual)
+			t.Fatalf("\t%s\tExpected the unlimited plan for account %s.", tests.Failed, tr.Account.ID)
+		} else if len(actual.Usage) != len(entitlement.Limit_Values) {
+			t.Fatalf("\t%s\tExpected usage for %d limits, got %d.", tests.Failed, len(entitlement.Limit_Values), len(actual.Usage))
+		}
+		t.Logf("\t%s\tReceived expected result.", tests.Success)
+	}
+}
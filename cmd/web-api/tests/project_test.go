@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -945,3 +946,86 @@ func TestProjectDelete(t *testing.T) {
 		t.Logf("\t%s\tReceived expected error.", tests.Success)
 	}
 }
+
+// TestProjectFindCursor tests paging through projects with a cursor.
+func TestProjectFindCursor(t *testing.T) {
+	defer tests.Recover(t)
+
+	tr := roleTests[auth.RoleAdmin]
+
+	// Add claims to the context for the project.
+	ctx := context.WithValue(tests.Context(), auth.Key, tr.Claims)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, newMockProject(tr.Account.ID).ID)
+	}
+
+	seen := make(map[string]bool)
+
+	url := fmt.Sprintf("/v1/projects?filter[id][in]=%s&limit=2&include-total=true", strings.Join(ids, ","))
+	for page := 0; url != ""; page++ {
+		if page > 1 {
+			t.Fatalf("\t%s\tExpected only two pages.", tests.Failed)
+		}
+
+		rt := requestTest{
+			fmt.Sprintf("Find page %d w/role %s", page, tr.Role),
+			http.MethodGet,
+			url,
+			nil,
+			tr.Token,
+			tr.Claims,
+			http.StatusOK,
+			nil,
+		}
+		t.Logf("\tTest: %s - %s %s", rt.name, rt.method, rt.url)
+
+		w, ok := executeRequestTest(t, rt, ctx)
+		if !ok {
+			t.Fatalf("\t%s\tExecute request failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tReceived valid status code of %d.", tests.Success, w.Code)
+
+		var actual struct {
+			Data       []project.ProjectResponse `json:"data"`
+			NextCursor string                    `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &actual); err != nil {
+			t.Logf("\t\tGot error : %+v", err)
+			t.Fatalf("\t%s\tDecode response body failed.", tests.Failed)
+		}
+
+		for _, p := range actual.Data {
+			if seen[p.ID] {
+				t.Fatalf("\t%s\tProject %s returned more than once.", tests.Failed, p.ID)
+			}
+			seen[p.ID] = true
+		}
+
+		if v := w.Header().Get(web.HeaderXTotalCount); v != "3" {
+			t.Fatalf("\t%s\tExpected total count of 3, got %s.", tests.Failed, v)
+		}
+
+		// The cursor is only returned when there are more results after the page.
+		if actual.NextCursor != w.Header().Get(web.HeaderXNextCursor) {
+			t.Fatalf("\t%s\tExpected next cursor to match the header.", tests.Failed)
+		} else if (page == 0) != (actual.NextCursor != "") {
+			t.Fatalf("\t%s\tExpected next cursor only for the first page.", tests.Failed)
+		}
+
+		url = ""
+		if cursor := actual.NextCursor; cursor != "" {
+			if !strings.Contains(w.Header().Get(web.HeaderLink), `rel="next"`) {
+				t.Fatalf("\t%s\tExpected link to the next page.", tests.Failed)
+			}
+			url = fmt.Sprintf("/v1/projects?filter[id][in]=%s&limit=2&include-total=true&cursor=%s", strings.Join(ids, ","), cursor)
+		}
+		t.Logf("\t%s\tReceived expected result.", tests.Success)
+	}
+
+	if len(seen) != len(ids) {
+		t.Fatalf("\t%s\tExpected %d projects, got %d.", tests.Failed, len(ids), len(seen))
+	}
+	t.Logf("\t%s\tFind with cursor ok.", tests.Success)
+}
@@ -6,10 +6,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_role"
+	"geeks-accelerator/oss/saas-starter-kit/internal/api_key"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/billing"
+	"geeks-accelerator/oss/saas-starter-kit/internal/closure"
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/notify"
 	"geeks-accelerator/oss/saas-starter-kit/internal/project"
 	"geeks-accelerator/oss/saas-starter-kit/internal/project_route"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account/invite"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account/transfer"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -30,6 +37,7 @@ import (
 	"geeks-accelerator/oss/saas-starter-kit/internal/user"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/webhook"
 	"github.com/google/go-cmp/cmp"
 	"github.com/iancoleman/strcase"
 	"github.com/pborman/uuid"
@@ -104,10 +112,22 @@ func testMain(m *testing.M) int {
 	usrAccRepo := user_account.NewRepository(test.MasterDB)
 	accRepo := account.NewRepository(test.MasterDB)
 	accPrefRepo := account_preference.NewRepository(test.MasterDB)
+	accRoleRepo := account_role.NewRepository(test.MasterDB)
 	authRepo := user_auth.NewRepository(test.MasterDB, authenticator, usrRepo, usrAccRepo, accPrefRepo)
+	authenticator.SessionValidator = authRepo
 	signupRepo := signup.NewRepository(test.MasterDB, usrRepo, usrAccRepo, accRepo)
 	inviteRepo := invite.NewRepository(test.MasterDB, usrRepo, usrAccRepo, accRepo, projectRoute.UserInviteAccept, notifyEmail, "6368616e676520746869732070613434")
+	transferRepo := transfer.NewRepository(test.MasterDB, usrRepo, usrAccRepo, accRepo, projectRoute.AccountTransferAccept, notifyEmail, "6368616e676520746869732070613434")
 	prjRepo := project.NewRepository(test.MasterDB)
+	closureRepo := closure.NewRepository(test.MasterDB, usrRepo, usrAccRepo, accRepo, accPrefRepo, prjRepo, projectRoute.AccountRestore, notifyEmail, "6368616e676520746869732070613434")
+	auditRepo := audit.NewRepository(test.MasterDB)
+	webhookRepo := webhook.NewRepository(test.MasterDB)
+	apiKeyRepo := api_key.NewRepository(test.MasterDB)
+	authenticator.ApiKeyResolver = apiKeyRepo
+	billingRepo := billing.NewRepository(test.MasterDB, accRepo, billing.NewFakeProvider(billingWebhookSecret))
+	entRepo := entitlement.NewRepository(test.MasterDB, billingRepo)
+	prjRepo.Entitlements = entRepo
+	inviteRepo.Entitlements = entRepo
 
 	appCtx = &handlers.AppContext{
 		Log:             log,
@@ -118,10 +138,18 @@ func testMain(m *testing.M) int {
 		UserAccountRepo: usrAccRepo,
 		AccountRepo:     accRepo,
 		AccountPrefRepo: accPrefRepo,
+		AccountRoleRepo: accRoleRepo,
 		AuthRepo:        authRepo,
 		SignupRepo:      signupRepo,
 		InviteRepo:      inviteRepo,
+		TransferRepo:    transferRepo,
+		ClosureRepo:     closureRepo,
 		ProjectRepo:     prjRepo,
+		AuditRepo:       auditRepo,
+		WebhookRepo:     webhookRepo,
+		ApiKeyRepo:      apiKeyRepo,
+		BillingRepo:     billingRepo,
+		EntitlementRepo: entRepo,
 		Authenticator:   authenticator,
 	}
 
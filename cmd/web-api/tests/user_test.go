@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -1586,12 +1588,13 @@ func TestUserToken(t *testing.T) {
 
 			// This is just for response format validation, will verify account from claims.
 			expected := map[string]interface{}{
-				"access_token": actual["access_token"],
-				"token_type":   actual["token_type"],
-				"expiry":       actual["expiry"],
-				"ttl":          actual["ttl"],
-				"user_id":      tr.User.ID,
-				"account_id":   tr.Account.ID,
+				"access_token":  actual["access_token"],
+				"token_type":    actual["token_type"],
+				"expiry":        actual["expiry"],
+				"ttl":           actual["ttl"],
+				"refresh_token": actual["refresh_token"],
+				"user_id":       tr.User.ID,
+				"account_id":    tr.Account.ID,
 			}
 
 			if diff := cmpDiff(t, actual, expected); diff {
@@ -1601,3 +1604,108 @@ func TestUserToken(t *testing.T) {
 		}
 	}
 }
+
+// TestUserTokenRefresh validates the refresh_token grant type and token revocation.
+func TestUserTokenRefresh(t *testing.T) {
+	defer tests.Recover(t)
+
+	tr := roleTests[auth.RoleUser]
+
+	// postForm executes a form encoded POST request against the API.
+	postForm := func(path string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+		return w
+	}
+
+	// Authenticate the user to get an initial refresh token.
+	var tkn1 user_auth.Token
+	{
+		w := postForm("/v1/oauth/token?account_id="+tr.Account.ID, url.Values{
+			"username": []string{tr.User.Email},
+			"password": []string{tr.User.password},
+		})
+		if w.Code != http.StatusOK {
+			t.Logf("\t\tBody : %s\n", w.Body.String())
+			t.Fatalf("\t%s\tAuthenticate failed.", tests.Failed)
+		}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &tkn1); err != nil {
+			t.Logf("\t\tGot error : %+v", err)
+			t.Fatalf("\t%s\tDecode response body failed.", tests.Failed)
+		} else if tkn1.RefreshToken == "" {
+			t.Fatalf("\t%s\tAuthenticate failed to return a refresh token.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticate ok.", tests.Success)
+	}
+
+	// Exchange the refresh token for a new token.
+	var tkn2 user_auth.Token
+	{
+		w := postForm("/v1/oauth/token", url.Values{
+			"grant_type":    []string{"refresh_token"},
+			"refresh_token": []string{tkn1.RefreshToken},
+		})
+		if w.Code != http.StatusOK {
+			t.Logf("\t\tBody : %s\n", w.Body.String())
+			t.Fatalf("\t%s\tRefresh failed.", tests.Failed)
+		}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &tkn2); err != nil {
+			t.Logf("\t\tGot error : %+v", err)
+			t.Fatalf("\t%s\tDecode response body failed.", tests.Failed)
+		} else if tkn2.RefreshToken == "" || tkn2.RefreshToken == tkn1.RefreshToken {
+			t.Fatalf("\t%s\tRefresh failed to rotate the refresh token.", tests.Failed)
+		} else if tkn2.UserID != tr.User.ID || tkn2.AccountID != tr.Account.ID {
+			t.Fatalf("\t%s\tRefresh returned a token for the wrong user.", tests.Failed)
+		}
+		t.Logf("\t%s\tRefresh ok.", tests.Success)
+	}
+
+	// Reusing the first refresh token should be rejected.
+	{
+		w := postForm("/v1/oauth/token", url.Values{
+			"grant_type":    []string{"refresh_token"},
+			"refresh_token": []string{tkn1.RefreshToken},
+		})
+		if w.Code != http.StatusUnauthorized {
+			t.Logf("\t\tBody : %s\n", w.Body.String())
+			t.Logf("\t\tShould receive a status code of %d for the response : %v", http.StatusUnauthorized, w.Code)
+			t.Fatalf("\t%s\tRefresh with reused token failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRefresh with reused token ok.", tests.Success)
+	}
+
+	// The access token for the session should be rejected since reuse revoked the session.
+	{
+		r := httptest.NewRequest(http.MethodGet, "/v1/users/"+tr.User.ID, nil)
+		r.Header.Set("Authorization", tkn2.AuthorizationHeader())
+		r.Header.Set("Content-Type", web.MIMEApplicationJSONCharsetUTF8)
+
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Logf("\t\tBody : %s\n", w.Body.String())
+			t.Logf("\t\tShould receive a status code of %d for the response : %v", http.StatusUnauthorized, w.Code)
+			t.Fatalf("\t%s\tRequest with revoked session failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRequest with revoked session ok.", tests.Success)
+	}
+
+	// Revoking a token should always succeed.
+	{
+		w := postForm("/v1/oauth/revoke", url.Values{
+			"token": []string{tkn2.RefreshToken},
+		})
+		if w.Code != http.StatusNoContent {
+			t.Logf("\t\tBody : %s\n", w.Body.String())
+			t.Logf("\t\tShould receive a status code of %d for the response : %v", http.StatusNoContent, w.Code)
+			t.Fatalf("\t%s\tRevoke failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRevoke ok.", tests.Success)
+	}
+}
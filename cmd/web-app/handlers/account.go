@@ -2,30 +2,54 @@ package handlers
 
 import (
 	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"geeks-accelerator/oss/saas-starter-kit/cmd/web-api/handlers"
 	"geeks-accelerator/oss/saas-starter-kit/internal/account"
 	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/api_key"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/closure"
 	"geeks-accelerator/oss/saas-starter-kit/internal/geonames"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/datatable"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
-
-	"net/http"
-	"time"
+	"geeks-accelerator/oss/saas-starter-kit/internal/saml"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account/transfer"
 
 	"github.com/gorilla/schema"
 	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/contrib/go-redis/redis"
 )
 
 // Account represents the Account API method handler set.
 type Account struct {
 	AccountRepo     handlers.AccountRepository
 	AccountPrefRepo handlers.AccountPrefRepository
+	ApiKeyRepo      handlers.ApiKeyRepository
+	AccountRoleRepo handlers.AccountRoleRepository
+	AuditRepo       handlers.AuditRepository
 	AuthRepo        handlers.UserAuthRepository
+	UserAccountRepo handlers.UserAccountRepository
+	TransferRepo    handlers.UserTransferRepository
+	ClosureRepo     handlers.ClosureRepository
 	GeoRepo         GeoRepository
+	SamlRepo        SamlRepository
 	Authenticator   *auth.Authenticator
+	Redis           *redis.Client
 	Renderer        web.Renderer
+
+	PurgeGracePeriod time.Duration
 }
 
 // View handles displaying the current account profile.
@@ -58,9 +82,11 @@ func (h *Account) View(ctx context.Context, w http.ResponseWriter, r *http.Reque
 
 type AccountUpdateRequest struct {
 	account.AccountUpdateRequest
-	PreferenceDatetimeFormat string
-	PreferenceDateFormat     string
-	PreferenceTimeFormat     string
+	PreferenceDatetimeFormat            string
+	PreferenceDateFormat                string
+	PreferenceTimeFormat                string
+	PreferenceMfaRequired               bool
+	PreferenceEmailVerificationRequired bool
 }
 
 // Update handles allowing the current user to update their account.
@@ -89,9 +115,11 @@ func (h *Account) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 		}
 
 		var (
-			preferenceDatetimeFormat string
-			preferenceDateFormat     string
-			preferenceTimeFormat     string
+			preferenceDatetimeFormat            string
+			preferenceDateFormat                string
+			preferenceTimeFormat                string
+			preferenceMfaRequired               = account_preference.AccountPreference_Mfa_Required_Default
+			preferenceEmailVerificationRequired = account_preference.AccountPreference_Email_Verification_Required_Default
 		)
 
 		for _, pref := range prefs {
@@ -102,6 +130,10 @@ func (h *Account) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 				preferenceDateFormat = pref.Value
 			case account_preference.AccountPreference_Time_Format:
 				preferenceTimeFormat = pref.Value
+			case account_preference.AccountPreference_Mfa_Required:
+				preferenceMfaRequired = pref.Value
+			case account_preference.AccountPreference_Email_Verification_Required:
+				preferenceEmailVerificationRequired = pref.Value
 			}
 		}
 
@@ -201,6 +233,38 @@ func (h *Account) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 				}
 			}
 
+			if mfaRequired := strconv.FormatBool(req.PreferenceMfaRequired); preferenceMfaRequired != mfaRequired {
+				err = h.AccountPrefRepo.Set(ctx, claims, account_preference.AccountPreferenceSetRequest{
+					AccountID: claims.Audience,
+					Name:      account_preference.AccountPreference_Mfa_Required,
+					Value:     mfaRequired,
+				}, ctxValues.Now)
+				if err != nil {
+					if verr, ok := weberror.NewValidationError(ctx, err); ok {
+						data["validationErrors"] = verr.(*weberror.Error)
+						return false, nil
+					} else {
+						return false, err
+					}
+				}
+			}
+
+			if verificationRequired := strconv.FormatBool(req.PreferenceEmailVerificationRequired); preferenceEmailVerificationRequired != verificationRequired {
+				err = h.AccountPrefRepo.Set(ctx, claims, account_preference.AccountPreferenceSetRequest{
+					AccountID: claims.Audience,
+					Name:      account_preference.AccountPreference_Email_Verification_Required,
+					Value:     verificationRequired,
+				}, ctxValues.Now)
+				if err != nil {
+					if verr, ok := weberror.NewValidationError(ctx, err); ok {
+						data["validationErrors"] = verr.(*weberror.Error)
+						return false, nil
+					} else {
+						return false, err
+					}
+				}
+			}
+
 			// Update the access token to include the updated claims.
 			if updateClaims {
 				ctx, err = updateContextClaims(ctx, h.Authenticator, claims)
@@ -244,6 +308,8 @@ func (h *Account) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 			req.PreferenceDatetimeFormat = preferenceDatetimeFormat
 			req.PreferenceDateFormat = preferenceDateFormat
 			req.PreferenceTimeFormat = preferenceTimeFormat
+			req.PreferenceMfaRequired = preferenceMfaRequired == "true"
+			req.PreferenceEmailVerificationRequired = preferenceEmailVerificationRequired == "true"
 		}
 
 		data["account"] = acc.Response(ctx)
@@ -280,3 +346,727 @@ func (h *Account) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "account-update.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
 }
+
+// Activity handles listing the changes made to the current account.
+func (h *Account) Activity(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var entityTypeFilterItems []datatable.FilterOptionItem
+	for _, et := range []audit.EntityType{
+		audit.EntityType_Account,
+		audit.EntityType_AccountPreference,
+		audit.EntityType_AccountRole,
+		audit.EntityType_Project,
+		audit.EntityType_User,
+		audit.EntityType_UserAccount,
+	} {
+		entityTypeFilterItems = append(entityTypeFilterItems, datatable.FilterOptionItem{
+			Display: web.EnumValueTitle(string(et)),
+			Value:   string(et),
+		})
+	}
+
+	fields := []datatable.DisplayField{
+		datatable.DisplayField{Field: "id", Title: "ID", Visible: false, Searchable: true, Orderable: true, Filterable: false},
+		datatable.DisplayField{Field: "created_at", Title: "When", Visible: true, Searchable: true, Orderable: true, Filterable: false},
+		datatable.DisplayField{Field: "actor", Title: "User", Visible: true, Searchable: true, Orderable: false, Filterable: true, FilterPlaceholder: "filter User"},
+		datatable.DisplayField{Field: "entity_type", Title: "Type", Visible: true, Searchable: true, Orderable: true, Filterable: true, FilterPlaceholder: "All Types", FilterItems: entityTypeFilterItems},
+		datatable.DisplayField{Field: "action", Title: "Action", Visible: true, Searchable: true, Orderable: true, Filterable: true, FilterPlaceholder: "filter Action"},
+		datatable.DisplayField{Field: "diff", Title: "Changes", Visible: true, Searchable: true, Orderable: false, Filterable: false},
+		datatable.DisplayField{Field: "request_ip", Title: "IP Address", Visible: true, Searchable: true, Orderable: true, Filterable: true, FilterPlaceholder: "filter IP"},
+	}
+
+	// Load the users for the account so the actor can be displayed by name.
+	userNames := make(map[string]string)
+	{
+		users, err := h.UserAccountRepo.UserFindByAccount(ctx, claims, user_account.UserFindByAccountRequest{
+			AccountID:       claims.Audience,
+			IncludeArchived: true,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, u := range users {
+			if strings.TrimSpace(u.Name) == "" {
+				userNames[u.ID] = u.Email
+			} else {
+				userNames[u.ID] = u.Name
+			}
+		}
+	}
+
+	userName := func(userID *string) string {
+		if userID == nil {
+			return ""
+		} else if n, ok := userNames[*userID]; ok {
+			return n
+		}
+		return *userID
+	}
+
+	mapFunc := func(q *audit.AuditEvent, cols []datatable.DisplayField) (resp []datatable.ColumnValue, err error) {
+		for i := 0; i < len(cols); i++ {
+			col := cols[i]
+			var v datatable.ColumnValue
+			switch col.Field {
+			case "id":
+				v.Value = fmt.Sprintf("%s", q.ID)
+			case "created_at":
+				dt := web.NewTimeResponse(ctx, q.CreatedAt)
+				v.Value = dt.Local
+				v.Formatted = fmt.Sprintf("<span class='cell-font-date'>%s</span>", v.Value)
+			case "actor":
+				v.Value = userName(q.ActorUserID)
+				v.Formatted = html.EscapeString(v.Value)
+				if q.RootUserID != nil {
+					v.Formatted = fmt.Sprintf("%s <small class='text-muted'>(by %s)</small>", v.Formatted, html.EscapeString(userName(q.RootUserID)))
+				}
+			case "entity_type":
+				v.Value = string(q.EntityType)
+				v.Formatted = web.EnumValueTitle(v.Value)
+			case "action":
+				v.Value = string(q.Action)
+				v.Formatted = web.EnumValueTitle(v.Value)
+			case "diff":
+				var keys []string
+				for k := range q.Diff {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+
+				var changes []string
+				for _, k := range keys {
+					c := q.Diff[k]
+					changes = append(changes, fmt.Sprintf("%s: %v &rarr; %v", html.EscapeString(k),
+						html.EscapeString(fmt.Sprintf("%v", c.Before)), html.EscapeString(fmt.Sprintf("%v", c.After))))
+				}
+				v.Value = strings.Join(keys, ", ")
+				v.Formatted = fmt.Sprintf("<small>%s</small>", strings.Join(changes, "<br/>"))
+			case "request_ip":
+				v.Value = q.RequestIP
+			default:
+				return resp, errors.Errorf("Failed to map value for %s.", col.Field)
+			}
+			resp = append(resp, v)
+		}
+
+		return resp, nil
+	}
+
+	loadFunc := func(ctx context.Context, sorting string, fields []datatable.DisplayField) (resp [][]datatable.ColumnValue, err error) {
+		req := audit.AuditEventFindRequest{}
+		if sorting != "" {
+			req.Order = strings.Split(sorting, ",")
+		}
+
+		res, err := h.AuditRepo.Find(ctx, claims, req)
+		if err != nil {
+			return resp, err
+		}
+
+		for _, a := range res {
+			l, err := mapFunc(a, fields)
+			if err != nil {
+				return resp, errors.Wrapf(err, "Failed to map audit event for display.")
+			}
+
+			resp = append(resp, l)
+		}
+
+		return resp, nil
+	}
+
+	dt, err := datatable.New(ctx, w, r, h.Redis, fields, loadFunc)
+	if err != nil {
+		return err
+	}
+
+	if dt.HasCache() {
+		return nil
+	}
+
+	if ok, err := dt.Render(); ok {
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	data := map[string]interface{}{
+		"datatable": dt.Response(),
+	}
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "account-activity.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
+
+// AccountApiKeyRequest defines the form fields used to manage API keys.
+type AccountApiKeyRequest struct {
+	Action    string
+	ID        string
+	Name      string
+	Type      string
+	Roles     []string
+	ExpiresAt string
+}
+
+// ApiKeys handles listing, creating and revoking the API keys for the current account.
+func (h *Account) ApiKeys(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	//
+	req := new(AccountApiKeyRequest)
+	data := make(map[string]interface{})
+	f := func() (bool, error) {
+		claims, err := auth.ClaimsFromContext(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		if r.Method == http.MethodPost {
+			err := r.ParseForm()
+			if err != nil {
+				return false, err
+			}
+
+			decoder := schema.NewDecoder()
+			decoder.IgnoreUnknownKeys(true)
+
+			if err := decoder.Decode(req, r.PostForm); err != nil {
+				return false, err
+			}
+
+			switch req.Action {
+			case "revoke":
+				err = h.ApiKeyRepo.Archive(ctx, claims, api_key.ApiKeyArchiveRequest{ID: req.ID}, ctxValues.Now)
+				if err != nil {
+					return false, err
+				}
+
+				// Display a success message to the user.
+				webcontext.SessionFlashSuccess(ctx,
+					"API Key Revoked",
+					"API key successfully revoked.")
+
+				return true, web.Redirect(ctx, w, r, "/account/api-keys", http.StatusFound)
+
+			case "create":
+				createReq := api_key.ApiKeyCreateRequest{
+					Name:  req.Name,
+					Roles: req.Roles,
+				}
+
+				if req.Type != "" {
+					t := api_key.ApiKeyType(req.Type)
+					createReq.Type = &t
+				}
+
+				if req.ExpiresAt != "" {
+					expires, err := time.ParseInLocation("2006-01-02", req.ExpiresAt, claims.TimeLocation())
+					if err != nil {
+						return false, weberror.NewErrorMessage(ctx, err, http.StatusBadRequest, "Expiration date is invalid.")
+					}
+					createReq.ExpiresAt = &expires
+				}
+
+				res, err := h.ApiKeyRepo.Create(ctx, claims, createReq, ctxValues.Now)
+				if err != nil {
+					switch errors.Cause(err) {
+					case api_key.ErrForbidden:
+						return false, weberror.NewErrorMessage(ctx, err, http.StatusForbidden, "You are not allowed to grant the selected roles.")
+					default:
+						if verr, ok := weberror.NewValidationError(ctx, err); ok {
+							data["validationErrors"] = verr.(*weberror.Error)
+							return false, nil
+						} else {
+							return false, err
+						}
+					}
+				}
+
+				// The key is only displayed once after it's created.
+				data["createdKey"] = res.Response(ctx)
+				req = new(AccountApiKeyRequest)
+			}
+		}
+
+		keys, err := h.ApiKeyRepo.Find(ctx, claims, api_key.ApiKeyFindRequest{
+			Order: []string{"created_at desc"},
+		})
+		if err != nil {
+			return false, err
+		}
+		data["apiKeys"] = keys.Response(ctx)
+
+		// Keys can only be granted the roles of the current user.
+		var roleOptions []interface{}
+		for _, r := range claims.Roles {
+			roleOptions = append(roleOptions, r)
+		}
+		if claims.HasRole(auth.RoleAdmin) && !claims.HasRole(auth.RoleUser) {
+			roleOptions = append(roleOptions, auth.RoleUser)
+		}
+
+		var selectedRoles []interface{}
+		for _, r := range req.Roles {
+			selectedRoles = append(selectedRoles, r)
+		}
+		data["roles"] = web.NewEnumMultiResponse(ctx, selectedRoles, roleOptions...)
+
+		data["canManageAccountKeys"] = claims.HasPermission(auth.PermissionApiKeyWrite)
+
+		return false, nil
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		return nil
+	}
+
+	data["form"] = req
+
+	if verr, ok := weberror.NewValidationError(ctx, webcontext.Validator().Struct(api_key.ApiKeyCreateRequest{})); ok {
+		data["validationDefaults"] = verr.(*weberror.Error)
+	}
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "account-api-keys.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
+
+// AccountSsoRequest defines the form used to configure the SAML identity provider for the current account.
+type AccountSsoRequest struct {
+	Action        string
+	Metadata      string
+	Domain        string
+	Enforced      bool
+	RoleAttribute string
+	RoleMappings  string
+	DefaultRole   string
+}
+
+// Sso handles configuring SAML single sign-on for the current account.
+func (h *Account) Sso(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	//
+	req := new(AccountSsoRequest)
+	data := make(map[string]interface{})
+	f := func() (bool, error) {
+		claims, err := auth.ClaimsFromContext(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		sso, err := h.SamlRepo.Read(ctx, claims, claims.Audience)
+		if err != nil && errors.Cause(err) != saml.ErrNotFound {
+			return false, err
+		}
+
+		if r.Method == http.MethodPost {
+			err := r.ParseForm()
+			if err != nil {
+				return false, err
+			}
+
+			decoder := schema.NewDecoder()
+			decoder.IgnoreUnknownKeys(true)
+
+			if err := decoder.Decode(req, r.PostForm); err != nil {
+				return false, err
+			}
+
+			switch req.Action {
+			case "delete":
+				err = h.SamlRepo.Delete(ctx, claims, saml.AccountSsoDeleteRequest{AccountID: claims.Audience}, ctxValues.Now)
+				if err != nil {
+					return false, err
+				}
+
+				// Display a success message to the user.
+				webcontext.SessionFlashSuccess(ctx,
+					"Single Sign-On Removed",
+					"Users can login with their password again.")
+
+				return true, web.Redirect(ctx, w, r, "/account/sso", http.StatusFound)
+
+			case "verify":
+				_, err = h.SamlRepo.VerifyDomain(ctx, claims, saml.AccountSsoVerifyDomainRequest{AccountID: claims.Audience}, ctxValues.Now)
+				if err != nil {
+					switch errors.Cause(err) {
+					case saml.ErrDomainNotVerified, saml.ErrDomainInUse:
+						return false, weberror.NewErrorMessage(ctx, err, http.StatusBadRequest, err.Error())
+					default:
+						return false, err
+					}
+				}
+
+				// Display a success message to the user.
+				webcontext.SessionFlashSuccess(ctx,
+					"Domain Verified",
+					"Users with an email address for the domain can now login with single sign-on.")
+
+				return true, web.Redirect(ctx, w, r, "/account/sso", http.StatusFound)
+
+			case "save":
+				mappings, err := saml.ParseRoleMappings(req.RoleMappings)
+				if err != nil {
+					return false, weberror.NewErrorMessage(ctx, err, http.StatusBadRequest, err.Error())
+				}
+
+				_, err = h.SamlRepo.Save(ctx, claims, saml.AccountSsoSaveRequest{
+					AccountID:     claims.Audience,
+					Metadata:      req.Metadata,
+					Domain:        req.Domain,
+					Enforced:      req.Enforced,
+					RoleAttribute: req.RoleAttribute,
+					RoleMappings:  mappings,
+					DefaultRole:   req.DefaultRole,
+				}, ctxValues.Now)
+				if err != nil {
+					switch errors.Cause(err) {
+					case saml.ErrInvalidMetadata, saml.ErrDomainInUse:
+						return false, weberror.NewErrorMessage(ctx, err, http.StatusBadRequest, err.Error())
+					default:
+						if verr, ok := weberror.NewValidationError(ctx, err); ok {
+							data["validationErrors"] = verr.(*weberror.Error)
+							return false, nil
+						} else {
+							return false, err
+						}
+					}
+				}
+
+				// Display a success message to the user.
+				webcontext.SessionFlashSuccess(ctx,
+					"Single Sign-On Saved",
+					"The identity provider was successfully saved. Verify the domain to enable single sign-on.")
+
+				return true, web.Redirect(ctx, w, r, "/account/sso", http.StatusFound)
+			}
+		}
+
+		if sso != nil {
+			data["sso"] = sso.Response(ctx)
+
+			if req.Action == "" {
+				req.Metadata = sso.IdpMetadata
+				req.Domain = sso.Domain
+				req.Enforced = sso.Enforced
+				req.RoleAttribute = sso.RoleAttribute
+				req.RoleMappings = sso.RoleMappings.String()
+				req.DefaultRole = sso.DefaultRole
+			}
+		}
+
+		data["spEntityID"] = h.SamlRepo.EntityID(claims.Audience)
+		data["spAcsUrl"] = h.SamlRepo.AcsUrl(claims.Audience)
+
+		// The default role can be any built-in or custom role of the account.
+		roleOptions := user_account.UserAccountRole_ValuesInterface()
+		roles, err := h.AccountRoleRepo.FindByAccountID(ctx, claims, claims.Audience)
+		if err != nil {
+			return false, err
+		}
+		for _, r := range roles {
+			roleOptions = append(roleOptions, r.Name)
+		}
+
+		defaultRole := req.DefaultRole
+		if defaultRole == "" {
+			defaultRole = auth.RoleUser
+		}
+		data["roles"] = web.NewEnumResponse(ctx, defaultRole, roleOptions...)
+
+		return false, nil
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		return nil
+	}
+
+	data["form"] = req
+
+	if verr, ok := weberror.NewValidationError(ctx, webcontext.Validator().Struct(saml.AccountSsoSaveRequest{})); ok {
+		data["validationDefaults"] = verr.(*weberror.Error)
+	}
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "account-sso.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
+
+// Transfer handles sending a request to another user of the account to accept the ownership of the account.
+func (h *Account) Transfer(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	//
+	req := new(transfer.SendTransferRequest)
+	data := make(map[string]interface{})
+	f := func() (bool, error) {
+
+		if r.Method == http.MethodPost {
+			err := r.ParseForm()
+			if err != nil {
+				return false, err
+			}
+
+			decoder := schema.NewDecoder()
+			decoder.IgnoreUnknownKeys(true)
+
+			if err := decoder.Decode(req, r.PostForm); err != nil {
+				return false, err
+			}
+			req.AccountID = claims.Audience
+			req.TTL = 0
+
+			_, err = h.TransferRepo.SendTransfer(ctx, claims, *req, ctxValues.Now)
+			if err != nil {
+				switch errors.Cause(err) {
+				case transfer.ErrUserNotActive:
+					webcontext.SessionFlashError(ctx,
+						"User Not Active",
+						"The ownership can only be transferred to an active user of the account.")
+					return false, nil
+				default:
+					if verr, ok := weberror.NewValidationError(ctx, err); ok {
+						data["validationErrors"] = verr.(*weberror.Error)
+						return false, nil
+					} else {
+						return false, err
+					}
+				}
+			}
+
+			// Display a success message to the user.
+			webcontext.SessionFlashSuccess(ctx,
+				"Transfer Sent",
+				"The user will become the owner of the account once they confirm the transfer.")
+
+			return true, web.Redirect(ctx, w, r, "/account", http.StatusFound)
+		}
+
+		return false, nil
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		return nil
+	}
+
+	// The ownership can only be transferred to the other active users of the account.
+	users, err := h.UserAccountRepo.UserFindByAccount(ctx, claims, user_account.UserFindByAccountRequest{
+		AccountID: claims.Audience,
+		Filter: filter.Filter{
+			{Field: "status", Operator: filter.Op_Eq, Values: []string{user_account.UserAccountStatus_Active.String()}},
+			{Field: "id", Operator: filter.Op_Ne, Values: []string{claims.Subject}},
+		},
+		Order: []string{"name asc", "email asc"},
+	})
+	if err != nil {
+		return err
+	}
+
+	var userOptions []map[string]string
+	for _, u := range users {
+		name := strings.TrimSpace(u.Name)
+		if name == "" {
+			name = u.Email
+		} else {
+			name = fmt.Sprintf("%s (%s)", name, u.Email)
+		}
+		userOptions = append(userOptions, map[string]string{"id": u.ID, "name": name})
+	}
+	data["users"] = userOptions
+
+	data["form"] = req
+
+	if verr, ok := weberror.NewValidationError(ctx, webcontext.Validator().Struct(transfer.SendTransferRequest{})); ok {
+		data["validationDefaults"] = verr.(*weberror.Error)
+	}
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "account-transfer.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
+
+// TransferAccept handles confirming the transfer of the ownership of an account.
+func (h *Account) TransferAccept(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	transferHash := params["hash"]
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{})
+	f := func() (bool, error) {
+
+		if r.Method == http.MethodPost {
+			_, err := h.TransferRepo.AcceptTransfer(ctx, transfer.AcceptTransferRequest{
+				TransferHash: transferHash,
+			}, ctxValues.Now)
+			if err != nil {
+				switch errors.Cause(err) {
+				case transfer.ErrTransferExpired:
+					webcontext.SessionFlashError(ctx,
+						"Transfer Expired",
+						"The transfer has expired. Ask the owner of the account to send the transfer again.")
+
+					return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+
+				case transfer.ErrTransferUsed:
+					webcontext.SessionFlashError(ctx,
+						"Transfer Used",
+						"The transfer has already been accepted. Ask the owner of the account to send the transfer again.")
+
+					return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+
+				case user_account.ErrOwnerRole, user_account.ErrNotFound:
+					webcontext.SessionFlashError(ctx,
+						"Transfer Invalid",
+						"The ownership of the account has changed since the transfer was sent.")
+
+					return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+
+				default:
+					if verr, ok := weberror.NewValidationError(ctx, err); ok {
+						data["validationErrors"] = verr.(*weberror.Error)
+						return false, nil
+					} else {
+						return false, err
+					}
+				}
+			}
+
+			// Display a success message to the user.
+			webcontext.SessionFlashSuccess(ctx,
+				"Transfer Accepted",
+				"You are now the owner of the account. Login to continue.")
+
+			return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+		}
+
+		return false, nil
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		return nil
+	}
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "account-transfer-accept.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
+
+// Close handles closing the current account. Once closed, the owner is logged out and emailed a link to restore the
+// account before it's purged.
+func (h *Account) Close(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	//
+	data := make(map[string]interface{})
+	f := func() (bool, error) {
+
+		if r.Method == http.MethodPost {
+			_, err = h.ClosureRepo.CloseAccount(ctx, claims, closure.CloseAccountRequest{
+				AccountID: claims.Audience,
+			}, ctxValues.Now)
+			if err != nil {
+				switch errors.Cause(err) {
+				case closure.ErrForbidden:
+					webcontext.SessionFlashError(ctx,
+						"Close Not Allowed",
+						"Only the owner of the account can close it.")
+					return false, nil
+				default:
+					if verr, ok := weberror.NewValidationError(ctx, err); ok {
+						data["validationErrors"] = verr.(*weberror.Error)
+						return false, nil
+					} else {
+						return false, err
+					}
+				}
+			}
+
+			// The sessions of the account were revoked, remove the access token from the session.
+			webcontext.SessionDestroy(webcontext.ContextSession(ctx))
+
+			// Display a success message to the user.
+			webcontext.SessionFlashSuccess(ctx,
+				"Account Closed",
+				"The account has been closed. Use the link emailed to you to restore the account before it's permanently deleted.")
+
+			return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+		}
+
+		return false, nil
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		return nil
+	}
+
+	data["graceDays"] = int(h.PurgeGracePeriod.Hours() / 24)
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "account-close.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
+
+// Export handles downloading a zip file with the data of the current account.
+func (h *Account) Export(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	dat, err := h.ClosureRepo.ExportAccount(ctx, claims, claims.Audience, ctxValues.Now)
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=\"account-"+claims.Audience+".zip\"")
+
+	return web.Respond(ctx, w, dat, http.StatusOK, web.MIMEApplicationZip)
+}
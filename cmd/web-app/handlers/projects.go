@@ -7,8 +7,10 @@ import (
 	"net/http"
 	"strings"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/datatable"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
@@ -112,8 +114,9 @@ func (h *Projects) Index(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 	loadFunc := func(ctx context.Context, sorting string, fields []datatable.DisplayField) (resp [][]datatable.ColumnValue, err error) {
 		res, err := h.ProjectRepo.Find(ctx, claims, project.ProjectFindRequest{
-			Where: "account_id = ?",
-			Args:  []interface{}{claims.Audience},
+			Filter: filter.Filter{
+				{Field: "account_id", Operator: filter.Op_Eq, Values: []string{claims.Audience}},
+			},
 			Order: strings.Split(sorting, ","),
 		})
 		if err != nil {
@@ -190,6 +193,12 @@ func (h *Projects) Create(ctx context.Context, w http.ResponseWriter, r *http.Re
 			usr, err := h.ProjectRepo.Create(ctx, claims, *req, ctxValues.Now)
 			if err != nil {
 				switch errors.Cause(err) {
+				case entitlement.ErrLimitExceeded:
+					webcontext.SessionFlashError(ctx,
+						"Project Limit Reached",
+						"The plan for your account does not allow more projects. Upgrade your plan to create more projects.")
+
+					return false, nil
 				default:
 					if verr, ok := weberror.NewValidationError(ctx, err); ok {
 						data["validationErrors"] = verr.(*weberror.Error)
@@ -46,11 +46,18 @@ type AppContext struct {
 	UserAccountRepo   handlers.UserAccountRepository
 	AccountRepo       handlers.AccountRepository
 	AccountPrefRepo   handlers.AccountPrefRepository
+	AccountRoleRepo   handlers.AccountRoleRepository
 	AuthRepo          handlers.UserAuthRepository
 	SignupRepo        handlers.SignupRepository
 	InviteRepo        handlers.UserInviteRepository
+	TransferRepo      handlers.UserTransferRepository
+	ClosureRepo       handlers.ClosureRepository
 	ProjectRepo       handlers.ProjectRepository
+	AuditRepo         handlers.AuditRepository
+	ApiKeyRepo        handlers.ApiKeyRepository
 	GeoRepo           GeoRepository
+	OidcRepo          OidcRepository
+	SamlRepo          SamlRepository
 	Authenticator     *auth.Authenticator
 	StaticDir         string
 	TemplateDir       string
@@ -58,6 +65,9 @@ type AppContext struct {
 	ProjectRoute      project_route.ProjectRoute
 	PreAppMiddleware  []web.Middleware
 	PostAppMiddleware []web.Middleware
+
+	PurgeGracePeriod time.Duration
+	TrustedProxyHops int
 }
 
 // API returns a handler for a set of routes.
@@ -81,6 +91,7 @@ func APP(shutdown chan os.Signal, appCtx *AppContext) http.Handler {
 
 	// Construct the web.App which holds all routes as well as common Middleware.
 	app := web.NewApp(shutdown, appCtx.Log, appCtx.Env, middlewares...)
+	app.TrustedProxyHops = appCtx.TrustedProxyHops
 
 	// Build a sitemap.
 	sm := stm.NewSitemap(1)
@@ -107,34 +118,35 @@ func APP(shutdown chan os.Signal, appCtx *AppContext) http.Handler {
 		Redis:       appCtx.Redis,
 		Renderer:    appCtx.Renderer,
 	}
-	app.Handle("POST", "/projects/:project_id/update", p.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/projects/:project_id/update", p.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("POST", "/projects/:project_id", p.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle("POST", "/projects/:project_id/update", p.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionProjectWrite))
+	app.Handle("GET", "/projects/:project_id/update", p.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionProjectWrite))
+	app.Handle("POST", "/projects/:project_id", p.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionProjectWrite))
 	app.Handle("GET", "/projects/:project_id", p.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
-	app.Handle("POST", "/projects/create", p.Create, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/projects/create", p.Create, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle("POST", "/projects/create", p.Create, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionProjectWrite))
+	app.Handle("GET", "/projects/create", p.Create, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionProjectWrite))
 	app.Handle("GET", "/projects", p.Index, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
 
 	// Register user management pages.
 	us := Users{
 		UserRepo:        appCtx.UserRepo,
 		UserAccountRepo: appCtx.UserAccountRepo,
+		AccountRoleRepo: appCtx.AccountRoleRepo,
 		AuthRepo:        appCtx.AuthRepo,
 		InviteRepo:      appCtx.InviteRepo,
 		GeoRepo:         appCtx.GeoRepo,
 		Redis:           appCtx.Redis,
 		Renderer:        appCtx.Renderer,
 	}
-	app.Handle("POST", "/users/:user_id/update", us.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/users/:user_id/update", us.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("POST", "/users/:user_id", us.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle("POST", "/users/:user_id/update", us.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionUserWrite))
+	app.Handle("GET", "/users/:user_id/update", us.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionUserWrite))
+	app.Handle("POST", "/users/:user_id", us.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionUserWrite))
 	app.Handle("GET", "/users/:user_id", us.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
 	app.Handle("POST", "/users/invite/:hash", us.InviteAccept)
 	app.Handle("GET", "/users/invite/:hash", us.InviteAccept)
-	app.Handle("POST", "/users/invite", us.Invite, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/users/invite", us.Invite, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("POST", "/users/create", us.Create, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/users/create", us.Create, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle("POST", "/users/invite", us.Invite, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionUserInvite))
+	app.Handle("GET", "/users/invite", us.Invite, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionUserInvite))
+	app.Handle("POST", "/users/create", us.Create, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionUserWrite))
+	app.Handle("GET", "/users/create", us.Create, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionUserWrite))
 	app.Handle("GET", "/users", us.Index, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
 
 	// Register user management and authentication endpoints.
@@ -144,25 +156,55 @@ func APP(shutdown chan os.Signal, appCtx *AppContext) http.Handler {
 		AccountRepo:     appCtx.AccountRepo,
 		AuthRepo:        appCtx.AuthRepo,
 		GeoRepo:         appCtx.GeoRepo,
+		OidcRepo:        appCtx.OidcRepo,
+		SamlRepo:        appCtx.SamlRepo,
+		ClosureRepo:     appCtx.ClosureRepo,
 		Renderer:        appCtx.Renderer,
+		ProjectRoute:    appCtx.ProjectRoute,
+
+		PurgeGracePeriod: appCtx.PurgeGracePeriod,
 	}
 	app.Handle("POST", "/user/login", u.Login)
 	app.Handle("GET", "/user/login", u.Login)
-	app.Handle("GET", "/user/logout", u.Logout)
+	app.Handle("POST", "/user/login/mfa", u.LoginMfa)
+	if appCtx.OidcRepo != nil {
+		app.Handle("GET", "/user/login/oidc/:provider/callback", u.LoginOidcCallback)
+		app.Handle("GET", "/user/login/oidc/:provider", u.LoginOidc)
+		app.Handle("POST", "/signup/oidc", u.SignupOidc)
+		app.Handle("GET", "/signup/oidc", u.SignupOidc)
+	}
+	if appCtx.SamlRepo != nil {
+		app.Handle("GET", "/sso/saml/:account_id/metadata", u.SamlMetadata)
+		app.Handle("GET", "/sso/saml/:account_id/login", u.SamlLogin)
+		app.Handle("POST", "/sso/saml/:account_id/acs", u.SamlAcs)
+	}
+	app.Handle("GET", "/user/logout", u.Logout, mid.AuthenticateSessionOptional(appCtx.Authenticator))
 	app.Handle("POST", "/user/reset-password/:hash", u.ResetConfirm)
 	app.Handle("GET", "/user/reset-password/:hash", u.ResetConfirm)
 	app.Handle("POST", "/user/reset-password", u.ResetPassword)
 	app.Handle("GET", "/user/reset-password", u.ResetPassword)
+	app.Handle("GET", "/user/verify-email/:hash", u.VerifyEmailConfirm, mid.AuthenticateSessionOptional(appCtx.Authenticator))
+	app.Handle("POST", "/user/verify-email", u.VerifyEmail)
+	app.Handle("GET", "/user/verify-email", u.VerifyEmail)
 	app.Handle("POST", "/user/update", u.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
 	app.Handle("GET", "/user/update", u.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
+	app.Handle("POST", "/user/mfa", u.Mfa, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
+	app.Handle("GET", "/user/mfa", u.Mfa, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
+	app.Handle("POST", "/user/sessions", u.Sessions, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
+	app.Handle("GET", "/user/sessions", u.Sessions, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
 	app.Handle("GET", "/user/account", u.Account, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
-	app.Handle("GET", "/user/virtual-login/:user_id", u.VirtualLogin, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("POST", "/user/virtual-login", u.VirtualLogin, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/user/virtual-login", u.VirtualLogin, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle("GET", "/user/virtual-login/:user_id", u.VirtualLogin, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionUserImpersonate))
+	app.Handle("POST", "/user/virtual-login", u.VirtualLogin, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionUserImpersonate))
+	app.Handle("GET", "/user/virtual-login", u.VirtualLogin, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionUserImpersonate))
 	app.Handle("GET", "/user/virtual-logout", u.VirtualLogout, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
 	app.Handle("GET", "/user/switch-account/:account_id", u.SwitchAccount, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
 	app.Handle("POST", "/user/switch-account", u.SwitchAccount, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
 	app.Handle("GET", "/user/switch-account", u.SwitchAccount, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
+	app.Handle("POST", "/user/close", u.Close, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
+	app.Handle("GET", "/user/close", u.Close, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
+	app.Handle("GET", "/user/export", u.Export, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
+	app.Handle("POST", "/restore/:hash", u.Restore)
+	app.Handle("GET", "/restore/:hash", u.Restore)
 	app.Handle("POST", "/user", u.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
 	app.Handle("GET", "/user", u.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
 
@@ -170,15 +212,40 @@ func APP(shutdown chan os.Signal, appCtx *AppContext) http.Handler {
 	acc := Account{
 		AccountRepo:     appCtx.AccountRepo,
 		AccountPrefRepo: appCtx.AccountPrefRepo,
+		AccountRoleRepo: appCtx.AccountRoleRepo,
+		ApiKeyRepo:      appCtx.ApiKeyRepo,
+		AuditRepo:       appCtx.AuditRepo,
 		AuthRepo:        appCtx.AuthRepo,
+		UserAccountRepo: appCtx.UserAccountRepo,
+		TransferRepo:    appCtx.TransferRepo,
+		ClosureRepo:     appCtx.ClosureRepo,
 		Authenticator:   appCtx.Authenticator,
 		GeoRepo:         appCtx.GeoRepo,
+		SamlRepo:        appCtx.SamlRepo,
+		Redis:           appCtx.Redis,
 		Renderer:        appCtx.Renderer,
+
+		PurgeGracePeriod: appCtx.PurgeGracePeriod,
+	}
+	if appCtx.SamlRepo != nil {
+		app.Handle("POST", "/account/sso", acc.Sso, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionAccountWrite))
+		app.Handle("GET", "/account/sso", acc.Sso, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionAccountWrite))
 	}
-	app.Handle("POST", "/account/update", acc.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/account/update", acc.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("POST", "/account", acc.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/account", acc.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle("POST", "/account/transfer/:hash", acc.TransferAccept)
+	app.Handle("GET", "/account/transfer/:hash", acc.TransferAccept)
+	app.Handle("POST", "/account/close", acc.Close, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleOwner))
+	app.Handle("GET", "/account/close", acc.Close, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleOwner))
+	app.Handle("GET", "/account/export", acc.Export, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionAccountWrite))
+	app.Handle("POST", "/account/transfer", acc.Transfer, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleOwner))
+	app.Handle("GET", "/account/transfer", acc.Transfer, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasRole(auth.RoleOwner))
+	app.Handle("POST", "/account/api-keys", acc.ApiKeys, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
+	app.Handle("GET", "/account/api-keys", acc.ApiKeys, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasAuth())
+	app.Handle("POST", "/account/activity", acc.Activity, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionAuditRead))
+	app.Handle("GET", "/account/activity", acc.Activity, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionAuditRead))
+	app.Handle("POST", "/account/update", acc.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionAccountWrite))
+	app.Handle("GET", "/account/update", acc.Update, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionAccountWrite))
+	app.Handle("POST", "/account", acc.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionAccountWrite))
+	app.Handle("GET", "/account", acc.View, mid.AuthenticateSessionRequired(appCtx.Authenticator), mid.HasPermission(auth.PermissionAccountWrite))
 
 	// Register signup endpoints.
 	s := Signup{
@@ -89,7 +89,7 @@ func (h *Signup) Step1(ctx context.Context, w http.ResponseWriter, r *http.Reque
 			// Display a welcome message to the user.
 			webcontext.SessionFlashSuccess(ctx,
 				"Thank you for Joining",
-				"You workflow will be a breeze starting today.")
+				"You workflow will be a breeze starting today. We sent you an email to verify your email address.")
 
 			// Redirect the user to the dashboard.
 			return true, web.Redirect(ctx, w, r, "/", http.StatusFound)
@@ -5,16 +5,19 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"strings"
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/cmd/web-api/handlers"
 	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/closure"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/bruteforce"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+	"geeks-accelerator/oss/saas-starter-kit/internal/project_route"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_auth"
@@ -32,9 +35,15 @@ type UserRepos struct {
 	UserAccountRepo handlers.UserAccountRepository
 	AccountRepo     handlers.AccountRepository
 	GeoRepo         GeoRepository
+	OidcRepo        OidcRepository
+	SamlRepo        SamlRepository
+	ClosureRepo     handlers.ClosureRepository
 	MasterDB        *sqlx.DB
 	Renderer        web.Renderer
+	ProjectRoute    project_route.ProjectRoute
 	SecretKey       string
+
+	PurgeGracePeriod time.Duration
 }
 
 func urlUserVirtualLogin(userID string) string {
@@ -88,6 +97,35 @@ func (h UserRepos) Login(ctx context.Context, w http.ResponseWriter, r *http.Req
 				case user_auth.ErrAuthenticationFailure:
 					data["error"] = weberror.NewErrorMessage(ctx, err, http.StatusUnauthorized, "Authentication failure. Try again.")
 					return false, nil
+				case bruteforce.ErrTooManyAttempts:
+					retryAfter, _ := bruteforce.RetryAfter(err)
+					return false, weberror.NewRetryAfterError(ctx, err, retryAfter,
+						fmt.Sprintf("Too many failed login attempts. Try again in %s.", retryAfter.Round(time.Second)))
+				case user_auth.ErrEmailNotVerified:
+					webcontext.SessionFlashError(ctx,
+						"Email Not Verified",
+						"Your email address needs to be verified before you can login. Click on the link in the verification email or request a new one.")
+					return true, web.Redirect(ctx, w, r, "/user/verify-email?email="+url.QueryEscape(req.Email), http.StatusFound)
+				case user_auth.ErrMfaRequired, user_auth.ErrMfaEnrollmentRequired:
+					// The password is valid but the user still needs to complete two-factor authentication.
+					return true, h.renderLoginMfa(ctx, w, r, token, err, req.RememberMe)
+				case user_auth.ErrSsoRequired:
+					// Users managed by the identity provider of their account are sent there to login.
+					if h.SamlRepo != nil {
+						sso, err := h.SamlRepo.ReadEnforcedForEmail(ctx, req.Email)
+						if err != nil {
+							return false, err
+						}
+
+						loginUri := urlSsoSamlLogin(sso.AccountID)
+						if qv := r.URL.Query().Get("redirect"); qv != "" {
+							loginUri += "?redirect=" + url.QueryEscape(qv)
+						}
+						return true, web.Redirect(ctx, w, r, loginUri, http.StatusFound)
+					}
+
+					data["error"] = weberror.NewErrorMessage(ctx, err, http.StatusUnauthorized, "Login through the single sign-on of your account.")
+					return false, nil
 				default:
 					if verr, ok := weberror.NewValidationError(ctx, err); ok {
 						data["validationErrors"] = verr.(*weberror.Error)
@@ -128,6 +166,10 @@ func (h UserRepos) Login(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 	data["form"] = req
 
+	if h.OidcRepo != nil {
+		data["oidcProviders"] = h.OidcRepo.ListProviders()
+	}
+
 	if verr, ok := weberror.NewValidationError(ctx, webcontext.Validator().Struct(UserLoginRequest{})); ok {
 		data["validationDefaults"] = verr.(*weberror.Error)
 	}
@@ -135,9 +177,147 @@ func (h UserRepos) Login(ctx context.Context, w http.ResponseWriter, r *http.Req
 	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "user-login.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
 }
 
+// UserLoginMfaRequest defines the information needed to complete the two-factor authentication challenge
+// during login.
+type UserLoginMfaRequest struct {
+	user_auth.MfaAuthenticateRequest
+	RememberMe bool
+}
+
+// renderLoginMfa displays the two-factor authentication challenge after the user has entered a valid password. When
+// the user is required to enroll by one of their accounts, enrollment is started so the secret can be displayed.
+func (h *UserRepos) renderLoginMfa(ctx context.Context, w http.ResponseWriter, r *http.Request, token user_auth.Token, authErr error, rememberMe bool) error {
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{})
+
+	if errors.Cause(authErr) == user_auth.ErrMfaEnrollmentRequired {
+		enrollment, err := h.AuthRepo.MfaEnroll(ctx, user_auth.MfaEnrollRequest{MfaToken: token.MfaToken}, ctxValues.Now)
+		if err != nil {
+			return err
+		}
+		data["enrollment"] = enrollment
+	}
+
+	return h.renderLoginMfaForm(ctx, w, r, &UserLoginMfaRequest{
+		MfaAuthenticateRequest: user_auth.MfaAuthenticateRequest{MfaToken: token.MfaToken},
+		RememberMe:             rememberMe,
+	}, data)
+}
+
+// renderLoginMfaForm renders the two-factor authentication challenge form.
+func (h *UserRepos) renderLoginMfaForm(ctx context.Context, w http.ResponseWriter, r *http.Request, req *UserLoginMfaRequest, data map[string]interface{}) error {
+	// The code is never displayed back to the user.
+	req.Code = ""
+	data["form"] = req
+
+	data["formAction"] = "/user/login/mfa"
+	if qv := r.URL.RawQuery; qv != "" {
+		data["formAction"] = "/user/login/mfa?" + qv
+	}
+
+	if verr, ok := weberror.NewValidationError(ctx, webcontext.Validator().Struct(UserLoginMfaRequest{})); ok {
+		data["validationDefaults"] = verr.(*weberror.Error)
+	}
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "user-login-mfa.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
+
+// LoginMfa handles completing the two-factor authentication challenge for a user.
+func (h *UserRepos) LoginMfa(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	//
+	req := new(UserLoginMfaRequest)
+	data := make(map[string]interface{})
+	f := func() (bool, error) {
+		err := r.ParseForm()
+		if err != nil {
+			return false, err
+		}
+
+		decoder := schema.NewDecoder()
+		if err := decoder.Decode(req, r.PostForm); err != nil {
+			return false, err
+		}
+
+		sessionTTL := time.Hour
+		if req.RememberMe {
+			sessionTTL = time.Hour * 36
+		}
+
+		token, err := h.AuthRepo.AuthenticateMfa(ctx, req.MfaAuthenticateRequest, sessionTTL, ctxValues.Now)
+		if err != nil {
+			switch errors.Cause(err) {
+			case user.ErrMfaExpired:
+				webcontext.SessionFlashError(ctx,
+					"Login Expired",
+					"The two-factor authentication challenge has expired. Please login again.")
+				return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+			case user_auth.ErrAuthenticationFailure:
+				data["error"] = weberror.NewErrorMessage(ctx, err, http.StatusUnauthorized, "Invalid authentication code. Try again.")
+				return false, nil
+			default:
+				if verr, ok := weberror.NewValidationError(ctx, err); ok {
+					data["validationErrors"] = verr.(*weberror.Error)
+					return false, nil
+				} else {
+					return false, err
+				}
+			}
+		}
+
+		// Add the token to the users session.
+		err = handleSessionToken(ctx, w, r, token)
+		if err != nil {
+			return false, err
+		}
+
+		redirectUri := "/"
+		if qv := r.URL.Query().Get("redirect"); qv != "" {
+			redirectUri, err = url.QueryUnescape(qv)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		// Redirect the user to the dashboard.
+		return true, web.Redirect(ctx, w, r, redirectUri, http.StatusFound)
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		return nil
+	}
+
+	return h.renderLoginMfaForm(ctx, w, r, req, data)
+}
+
 // Logout handles removing authentication for the user.
 func (h *UserRepos) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Revoke the session for the current access token so it can no longer be used.
+	if claims, err := auth.ClaimsFromContext(ctx); err == nil {
+		err = h.AuthRepo.RevokeSession(ctx, claims, ctxValues.Now)
+		if err != nil {
+			return err
+		}
+	}
+
 	sess := webcontext.ContextSession(ctx)
 
 	// Set the access token to empty to logout the user.
@@ -178,6 +358,10 @@ func (h *UserRepos) ResetPassword(ctx context.Context, w http.ResponseWriter, r
 			_, err = h.UserRepo.ResetPassword(ctx, *req, ctxValues.Now)
 			if err != nil {
 				switch errors.Cause(err) {
+				case bruteforce.ErrTooManyAttempts:
+					retryAfter, _ := bruteforce.RetryAfter(err)
+					return weberror.NewRetryAfterError(ctx, err, retryAfter,
+						fmt.Sprintf("Too many password reset requests. Try again in %s.", retryAfter.Round(time.Second)))
 				default:
 					if verr, ok := weberror.NewValidationError(ctx, err); ok {
 						data["validationErrors"] = verr.(*weberror.Error)
@@ -264,6 +448,12 @@ func (h *UserRepos) ResetConfirm(ctx context.Context, w http.ResponseWriter, r *
 				Password: req.Password,
 			}, time.Hour, ctxValues.Now)
 			if err != nil {
+				switch errors.Cause(err) {
+				case user_auth.ErrMfaRequired, user_auth.ErrMfaEnrollmentRequired:
+					// The password was reset but the user still needs to complete two-factor authentication.
+					return true, h.renderLoginMfa(ctx, w, r, token, err, false)
+				}
+
 				if verr, ok := weberror.NewValidationError(ctx, err); ok {
 					data["validationErrors"] = verr.(*weberror.Error)
 					return false, nil
@@ -319,6 +509,131 @@ func (h *UserRepos) ResetConfirm(ctx context.Context, w http.ResponseWriter, r *
 	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "user-reset-confirm.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
 }
 
+// VerifyEmail allows a user to request a new email to verify their email address.
+func (h *UserRepos) VerifyEmail(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	//
+	req := new(user.UserVerifyEmailRequest)
+	data := make(map[string]interface{})
+	f := func() error {
+
+		if r.Method == http.MethodPost {
+			err := r.ParseForm()
+			if err != nil {
+				return err
+			}
+
+			decoder := schema.NewDecoder()
+			if err := decoder.Decode(req, r.PostForm); err != nil {
+				return err
+			}
+
+			// The TTL is not configurable by the user.
+			req.TTL = 0
+
+			_, err = h.UserRepo.VerifyEmail(ctx, *req, ctxValues.Now)
+			if err != nil {
+				switch errors.Cause(err) {
+				case user.ErrNotFound:
+					// Don't disclose which email addresses belong to a user, the same message is displayed
+					// as when the email is sent.
+				case user.ErrEmailAlreadyVerified:
+					webcontext.SessionFlashSuccess(ctx,
+						"Email Verified",
+						fmt.Sprintf("The email address '%s' has already been verified.", req.Email))
+					return nil
+				default:
+					if verr, ok := weberror.NewValidationError(ctx, err); ok {
+						data["validationErrors"] = verr.(*weberror.Error)
+						return nil
+					} else {
+						return err
+					}
+				}
+			}
+
+			// Display a success message to the user to check their email.
+			webcontext.SessionFlashSuccess(ctx,
+				"Check your email",
+				fmt.Sprintf("An email was sent to '%s'. Click on the link in the email to verify your email address.", req.Email))
+
+		} else if qv := r.URL.Query().Get("email"); qv != "" {
+			req.Email = qv
+		}
+
+		return nil
+	}
+
+	if err := f(); err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	}
+
+	data["form"] = req
+
+	if verr, ok := weberror.NewValidationError(ctx, webcontext.Validator().Struct(user.UserVerifyEmailRequest{})); ok {
+		data["validationDefaults"] = verr.(*weberror.Error)
+	}
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "user-verify-email.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
+
+// VerifyEmailConfirm handles verifying the email address for a user after they have clicked on the link emailed.
+func (h *UserRepos) VerifyEmailConfirm(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.UserRepo.VerifyEmailConfirm(ctx, user.UserVerifyEmailConfirmRequest{
+		VerifyHash: params["hash"],
+	}, ctxValues.Now)
+	if err != nil {
+		switch errors.Cause(err) {
+		case user.ErrVerifyExpired:
+			webcontext.SessionFlashError(ctx,
+				"Verification Expired",
+				"The verification has expired. Request a new email to verify your email address.")
+			return web.Redirect(ctx, w, r, "/user/verify-email", http.StatusFound)
+		case user.ErrNotFound:
+			webcontext.SessionFlashError(ctx,
+				"Invalid Verification",
+				"The verification is no longer valid. Request a new email to verify your email address.")
+			return web.Redirect(ctx, w, r, "/user/verify-email", http.StatusFound)
+		case user.ErrEmailUnavailable:
+			webcontext.SessionFlashError(ctx,
+				"Email Unavailable",
+				"The email address is already used by another user.")
+			return web.Redirect(ctx, w, r, "/", http.StatusFound)
+		default:
+			if _, ok := weberror.NewValidationError(ctx, err); ok {
+				webcontext.SessionFlashError(ctx,
+					"Invalid Verification",
+					"The verification is no longer valid. Request a new email to verify your email address.")
+				return web.Redirect(ctx, w, r, "/user/verify-email", http.StatusFound)
+			}
+			return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+		}
+	}
+
+	// Display a success message to the user.
+	webcontext.SessionFlashSuccess(ctx,
+		"Email Verified",
+		"Your email address has been verified.")
+
+	// Users that are already logged in are sent back to their profile.
+	if claims, err := auth.ClaimsFromContext(ctx); err == nil && claims.HasAuth() {
+		return web.Redirect(ctx, w, r, "/user", http.StatusFound)
+	}
+
+	return web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+}
+
 // View handles displaying the current user profile.
 func (h *UserRepos) View(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 
@@ -390,6 +705,12 @@ func (h *UserRepos) Update(ctx context.Context, w http.ResponseWriter, r *http.R
 			}
 			req.ID = claims.Subject
 
+			// Load the current user to determine if the email address is being changed.
+			before, err := h.UserRepo.ReadByID(ctx, claims, claims.Subject)
+			if err != nil {
+				return false, err
+			}
+
 			err = h.UserRepo.Update(ctx, claims, *req, ctxValues.Now)
 			if err != nil {
 				switch errors.Cause(err) {
@@ -426,9 +747,15 @@ func (h *UserRepos) Update(ctx context.Context, w http.ResponseWriter, r *http.R
 			}
 
 			// Display a success message to the user.
-			webcontext.SessionFlashSuccess(ctx,
-				"Profile Updated",
-				"User profile successfully updated.")
+			if req.Email != nil && *req.Email != before.Email {
+				webcontext.SessionFlashSuccess(ctx,
+					"Profile Updated",
+					fmt.Sprintf("User profile successfully updated. An email was sent to '%s', click on the link in the email to finish changing your email address.", *req.Email))
+			} else {
+				webcontext.SessionFlashSuccess(ctx,
+					"Profile Updated",
+					"User profile successfully updated.")
+			}
 
 			return true, web.Redirect(ctx, w, r, "/user", http.StatusFound)
 		}
@@ -475,6 +802,240 @@ func (h *UserRepos) Update(ctx context.Context, w http.ResponseWriter, r *http.R
 	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "user-update.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
 }
 
+// Mfa handles allowing the current user to enroll in and disable two-factor authentication.
+func (h *UserRepos) Mfa(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	//
+	req := new(user.UserMfaVerifyRequest)
+	data := make(map[string]interface{})
+	f := func() (bool, error) {
+		if r.Method == http.MethodPost {
+			err := r.ParseForm()
+			if err != nil {
+				return false, err
+			}
+
+			decoder := schema.NewDecoder()
+			decoder.IgnoreUnknownKeys(true)
+
+			if err := decoder.Decode(req, r.PostForm); err != nil {
+				return false, err
+			}
+			req.ID = claims.Subject
+
+			switch r.PostForm.Get("action") {
+			case "enroll":
+				enrollment, err := h.UserRepo.MfaEnroll(ctx, claims, user.UserMfaEnrollRequest{ID: claims.Subject}, ctxValues.Now)
+				if err != nil {
+					switch errors.Cause(err) {
+					case user.ErrMfaAlreadyEnabled:
+						data["error"] = weberror.NewErrorMessage(ctx, err, http.StatusBadRequest, "Two-factor authentication is already enabled.")
+						return false, nil
+					default:
+						return false, err
+					}
+				}
+
+				// The recovery codes are only displayed once.
+				data["enrollment"] = enrollment
+				return false, nil
+
+			case "confirm":
+				err = h.UserRepo.MfaVerify(ctx, claims, *req, ctxValues.Now)
+				if err != nil {
+					switch errors.Cause(err) {
+					case user.ErrMfaInvalidCode, user.ErrMfaNotEnrolled:
+						data["error"] = weberror.NewErrorMessage(ctx, err, http.StatusBadRequest, "Invalid authentication code. Try again.")
+						return false, nil
+					default:
+						if verr, ok := weberror.NewValidationError(ctx, err); ok {
+							data["validationErrors"] = verr.(*weberror.Error)
+							return false, nil
+						} else {
+							return false, err
+						}
+					}
+				}
+
+				// Display a success message to the user.
+				webcontext.SessionFlashSuccess(ctx,
+					"Two-factor Authentication Enabled",
+					"Two-factor authentication successfully enabled.")
+
+			case "disable":
+				err = h.UserRepo.MfaDisable(ctx, claims, user.UserMfaDisableRequest{ID: req.ID, Code: req.Code}, ctxValues.Now)
+				if err != nil {
+					switch errors.Cause(err) {
+					case user.ErrMfaInvalidCode, user.ErrMfaNotEnrolled:
+						data["error"] = weberror.NewErrorMessage(ctx, err, http.StatusBadRequest, "Invalid authentication code. Try again.")
+						return false, nil
+					default:
+						if verr, ok := weberror.NewValidationError(ctx, err); ok {
+							data["validationErrors"] = verr.(*weberror.Error)
+							return false, nil
+						} else {
+							return false, err
+						}
+					}
+				}
+
+				// Display a success message to the user.
+				webcontext.SessionFlashSuccess(ctx,
+					"Two-factor Authentication Disabled",
+					"Two-factor authentication successfully disabled.")
+
+			default:
+				return false, weberror.NewErrorMessage(ctx, errors.New("invalid action"), http.StatusBadRequest, "Invalid action.")
+			}
+
+			return true, web.Redirect(ctx, w, r, "/user/mfa", http.StatusFound)
+		}
+
+		return false, nil
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		return nil
+	}
+
+	usr, err := h.UserRepo.ReadByID(ctx, claims, claims.Subject)
+	if err != nil {
+		return err
+	}
+	data["user"] = usr.Response(ctx)
+
+	// Never display the code back to the user.
+	req.Code = ""
+	data["form"] = req
+
+	if verr, ok := weberror.NewValidationError(ctx, webcontext.Validator().Struct(user.UserMfaVerifyRequest{})); ok {
+		data["validationDefaults"] = verr.(*weberror.Error)
+	}
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "user-mfa.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
+
+// Sessions handles listing the active sessions of the current user and revoking them.
+func (h *UserRepos) Sessions(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	//
+	data := make(map[string]interface{})
+	f := func() (bool, error) {
+		if r.Method == http.MethodPost {
+			err := r.ParseForm()
+			if err != nil {
+				return false, err
+			}
+
+			// Revoking the current session logs out the user.
+			var loggedOut bool
+
+			switch r.PostForm.Get("Action") {
+			case "revoke":
+				req := user_auth.SessionRevokeRequest{ID: r.PostForm.Get("ID")}
+
+				err = h.AuthRepo.SessionRevoke(ctx, claims, req, ctxValues.Now)
+				if err != nil {
+					switch errors.Cause(err) {
+					case user_auth.ErrSessionNotFound:
+						data["error"] = weberror.NewErrorMessage(ctx, err, http.StatusBadRequest, "Session not found.")
+						return false, nil
+					default:
+						if verr, ok := weberror.NewValidationError(ctx, err); ok {
+							data["validationErrors"] = verr.(*weberror.Error)
+							return false, nil
+						} else {
+							return false, err
+						}
+					}
+				}
+				loggedOut = req.ID == claims.SessionID
+
+				// Display a success message to the user.
+				webcontext.SessionFlashSuccess(ctx,
+					"Session Revoked",
+					"The session was successfully logged out.")
+
+			case "revoke-others", "revoke-all":
+				req := user_auth.SessionRevokeAllRequest{
+					ExceptCurrent: r.PostForm.Get("Action") == "revoke-others",
+				}
+
+				err = h.AuthRepo.SessionRevokeAll(ctx, claims, req, ctxValues.Now)
+				if err != nil {
+					return false, err
+				}
+				loggedOut = !req.ExceptCurrent
+
+				// Display a success message to the user.
+				if req.ExceptCurrent {
+					webcontext.SessionFlashSuccess(ctx,
+						"Sessions Revoked",
+						"All other sessions were successfully logged out.")
+				}
+
+			default:
+				return false, weberror.NewErrorMessage(ctx, errors.New("invalid action"), http.StatusBadRequest, "Invalid action.")
+			}
+
+			if loggedOut {
+				sess := webcontext.ContextSession(ctx)
+
+				// Set the access token to empty to logout the user.
+				sess = webcontext.SessionDestroy(sess)
+
+				if err := sess.Save(r, w); err != nil {
+					return false, err
+				}
+
+				return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+			}
+
+			return true, web.Redirect(ctx, w, r, "/user/sessions", http.StatusFound)
+		}
+
+		return false, nil
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		return nil
+	}
+
+	sessions, err := h.AuthRepo.SessionFind(ctx, claims, ctxValues.Now)
+	if err != nil {
+		return err
+	}
+	data["sessions"] = sessions.Response(ctx, claims.SessionID)
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "user-sessions.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
+
 // Account handles displaying the Account for the current user.
 func (h *UserRepos) Account(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 
@@ -591,36 +1152,33 @@ func (h *UserRepos) VirtualLogin(ctx context.Context, w http.ResponseWriter, r *
 	}
 
 	usrAccs, err := h.UserAccountRepo.Find(ctx, claims, user_account.UserAccountFindRequest{
-		Where: "account_id = ?",
-		Args:  []interface{}{claims.Audience},
+		Filter: filter.Filter{
+			{Field: "account_id", Operator: filter.Op_Eq, Values: []string{claims.Audience}},
+		},
 	})
 	if err != nil {
 		return err
 	}
 
-	var userIDs []interface{}
-	var userPhs []string
+	var userIDs []string
 	for _, usrAcc := range usrAccs {
 		if usrAcc.UserID == claims.Subject {
 			// Skip the current authenticated user.
 			continue
 		}
 		userIDs = append(userIDs, usrAcc.UserID)
-		userPhs = append(userPhs, "?")
 	}
 
-	if len(userIDs) == 0 {
-		userIDs = append(userIDs, "")
-		userPhs = append(userPhs, "?")
-	}
-
-	users, err := h.UserRepo.Find(ctx, claims, user.UserFindRequest{
-		Where: fmt.Sprintf("id IN (%s)",
-			strings.Join(userPhs, ", ")),
-		Args: userIDs,
-	})
-	if err != nil {
-		return err
+	users := user.Users{}
+	if len(userIDs) > 0 {
+		users, err = h.UserRepo.Find(ctx, claims, user.UserFindRequest{
+			Filter: filter.Filter{
+				{Field: "id", Operator: filter.Op_In, Values: userIDs},
+			},
+		})
+		if err != nil {
+			return err
+		}
 	}
 	data["users"] = users.Response(ctx)
 
@@ -854,3 +1412,148 @@ func updateContextClaims(ctx context.Context, authenticator *auth.Authenticator,
 
 	return ctx, nil
 }
+
+// Close handles closing the current user. Once closed, the user is logged out and emailed a link to restore the
+// user before it's purged.
+func (h *UserRepos) Close(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	//
+	data := make(map[string]interface{})
+	f := func() (bool, error) {
+
+		if r.Method == http.MethodPost {
+			_, err = h.ClosureRepo.CloseUser(ctx, claims, closure.CloseUserRequest{
+				UserID: claims.Subject,
+			}, ctxValues.Now)
+			if err != nil {
+				switch errors.Cause(err) {
+				case user.ErrAccountAdmin:
+					webcontext.SessionFlashError(ctx,
+						"Account Admin Required",
+						"You are the owner or the last admin of an account. Transfer the ownership or add another admin before closing your user.")
+					return false, nil
+				default:
+					if verr, ok := weberror.NewValidationError(ctx, err); ok {
+						data["validationErrors"] = verr.(*weberror.Error)
+						return false, nil
+					} else {
+						return false, err
+					}
+				}
+			}
+
+			// The sessions of the user were revoked, remove the access token from the session.
+			webcontext.SessionDestroy(webcontext.ContextSession(ctx))
+
+			// Display a success message to the user.
+			webcontext.SessionFlashSuccess(ctx,
+				"User Closed",
+				"Your user has been closed. Use the link emailed to you to restore your user before it's permanently deleted.")
+
+			return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+		}
+
+		return false, nil
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		return nil
+	}
+
+	data["graceDays"] = int(h.PurgeGracePeriod.Hours() / 24)
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "user-close.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
+
+// Export handles downloading a zip file with the personal data of the current user.
+func (h *UserRepos) Export(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	claims, err := auth.ClaimsFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	dat, err := h.ClosureRepo.ExportUser(ctx, claims, claims.Subject, ctxValues.Now)
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=\"user-"+claims.Subject+".zip\"")
+
+	return web.Respond(ctx, w, dat, http.StatusOK, web.MIMEApplicationZip)
+}
+
+// Restore handles restoring a closed account or user using the hash emailed when it was closed.
+func (h *UserRepos) Restore(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	restoreHash := params["hash"]
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{})
+	f := func() (bool, error) {
+
+		if r.Method == http.MethodPost {
+			hash, err := h.ClosureRepo.Restore(ctx, closure.RestoreRequest{
+				RestoreHash: restoreHash,
+			}, ctxValues.Now)
+			if err != nil {
+				switch errors.Cause(err) {
+				case closure.ErrRestoreExpired, account.ErrNotClosed, account.ErrNotFound, user.ErrNotFound:
+					webcontext.SessionFlashError(ctx,
+						"Restore Expired",
+						"The grace period has passed and the data has been permanently deleted.")
+
+					return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+
+				default:
+					if verr, ok := weberror.NewValidationError(ctx, err); ok {
+						data["validationErrors"] = verr.(*weberror.Error)
+						return false, nil
+					} else {
+						return false, err
+					}
+				}
+			}
+
+			// Display a success message to the user.
+			webcontext.SessionFlashSuccess(ctx,
+				"Restored",
+				fmt.Sprintf("The %s has been restored. Login to continue.", hash.Type))
+
+			return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+		}
+
+		return false, nil
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		return nil
+	}
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "restore.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
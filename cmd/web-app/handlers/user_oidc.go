@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/geonames"
+	"geeks-accelerator/oss/saas-starter-kit/internal/oidc"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+	"geeks-accelerator/oss/saas-starter-kit/internal/signup"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_auth"
+
+	"github.com/gorilla/schema"
+	"github.com/pkg/errors"
+)
+
+// Session keys used to persist the OpenID Connect login between requests.
+const (
+	sessionKeyOidcState    = "_oidc_state"
+	sessionKeyOidcIdentity = "_oidc_identity"
+)
+
+// OidcRepository defines the methods needed to login with an OpenID Connect provider.
+type OidcRepository interface {
+	ListProviders() []oidc.Provider
+	AuthCodeURL(ctx context.Context, providerName, redirectURI string, now time.Time) (*oidc.AuthState, string, error)
+	Exchange(ctx context.Context, st oidc.AuthState, req oidc.CallbackRequest, now time.Time) (*oidc.Identity, error)
+	Login(ctx context.Context, ident oidc.Identity, now time.Time) (*user.User, error)
+	Provision(ctx context.Context, ident oidc.Identity, req oidc.ProvisionRequest, now time.Time) (*signup.SignupResult, error)
+}
+
+func urlUserLoginOidcCallback(provider string) string {
+	return fmt.Sprintf("/user/login/oidc/%s/callback", provider)
+}
+
+// LoginOidc redirects the user to the provider to start the login.
+func (h *UserRepos) LoginOidc(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	redirectURI := h.ProjectRoute.WebAppUrl(urlUserLoginOidcCallback(params["provider"]))
+
+	st, authURL, err := h.OidcRepo.AuthCodeURL(ctx, params["provider"], redirectURI, ctxValues.Now)
+	if err != nil {
+		if errors.Cause(err) == oidc.ErrProviderNotFound {
+			return web.RespondError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		}
+		return err
+	}
+
+	// The state includes the PKCE code verifier and must only be known by the browser that started the login.
+	dat, err := json.Marshal(st)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sess := webcontext.ContextSession(ctx)
+	sess.Values[sessionKeyOidcState] = string(dat)
+
+	return web.Redirect(ctx, w, r, authURL, http.StatusFound)
+}
+
+// LoginOidcCallback handles the user being redirected back from the provider. The user linked to the identity is
+// logged in, when there is no user for the identity the user is redirected to signup.
+func (h *UserRepos) LoginOidcCallback(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	f := func() (bool, error) {
+		sess := webcontext.ContextSession(ctx)
+
+		// The state can only be used once.
+		var st oidc.AuthState
+		if dat, ok := sess.Values[sessionKeyOidcState].(string); ok {
+			if err := json.Unmarshal([]byte(dat), &st); err != nil {
+				return false, errors.WithStack(err)
+			}
+		}
+		delete(sess.Values, sessionKeyOidcState)
+
+		req := new(oidc.CallbackRequest)
+		decoder := schema.NewDecoder()
+		decoder.IgnoreUnknownKeys(true)
+		if err := decoder.Decode(req, r.URL.Query()); err != nil {
+			return false, err
+		}
+
+		if st.Provider != params["provider"] {
+			st = oidc.AuthState{}
+		}
+
+		ident, err := h.OidcRepo.Exchange(ctx, st, *req, ctxValues.Now)
+		if err != nil {
+			switch errors.Cause(err) {
+			case oidc.ErrInvalidState, oidc.ErrAuthorizationDenied:
+				webcontext.SessionFlashError(ctx,
+					"Login Failed",
+					"The login could not be completed. Please try again.")
+				return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+			default:
+				return false, err
+			}
+		}
+
+		u, err := h.OidcRepo.Login(ctx, *ident, ctxValues.Now)
+		if err != nil {
+			if errors.Cause(err) != oidc.ErrProvisionRequired {
+				return false, err
+			}
+
+			// Only identities with an email address verified by the provider can signup.
+			if ident.Email == "" || !ident.EmailVerified {
+				webcontext.SessionFlashError(ctx,
+					"Login Failed",
+					"Your email address has not been verified by the identity provider.")
+				return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+			}
+
+			// Keep the identity in the session so the user can complete signup without leaving the app.
+			dat, err := json.Marshal(ident)
+			if err != nil {
+				return false, errors.WithStack(err)
+			}
+			sess.Values[sessionKeyOidcIdentity] = string(dat)
+
+			return true, web.Redirect(ctx, w, r, "/signup/oidc", http.StatusFound)
+		}
+
+		// Authenticated the user.
+		token, err := h.AuthRepo.AuthenticateIdentity(ctx, u.ID, "", time.Hour, ctxValues.Now)
+		if err != nil {
+			switch errors.Cause(err) {
+			case user.ErrForbidden:
+				return false, web.RespondError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+			case user_auth.ErrAuthenticationFailure:
+				webcontext.SessionFlashError(ctx,
+					"Login Failed",
+					"Your account does not have access to any active accounts.")
+				return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+			case user_auth.ErrMfaRequired, user_auth.ErrMfaEnrollmentRequired:
+				// The provider verified the identity but the user still needs to complete two-factor authentication.
+				return true, h.renderLoginMfa(ctx, w, r, token, err, false)
+			default:
+				return false, err
+			}
+		}
+
+		// Add the token to the users session.
+		err = handleSessionToken(ctx, w, r, token)
+		if err != nil {
+			return false, err
+		}
+
+		// Redirect the user to the dashboard.
+		return true, web.Redirect(ctx, w, r, "/", http.StatusFound)
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		err = webcontext.ContextSession(ctx).Save(r, w)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	return web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+}
+
+// SignupOidc handles creating a new account for a user that logged in with a provider. The email address of the user
+// is the one verified by the provider so no password is collected.
+func (h *UserRepos) SignupOidc(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	sess := webcontext.ContextSession(ctx)
+
+	var ident oidc.Identity
+	if dat, ok := sess.Values[sessionKeyOidcIdentity].(string); ok {
+		if err := json.Unmarshal([]byte(dat), &ident); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if ident.Subject == "" {
+		return web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+	}
+
+	//
+	req := &oidc.ProvisionRequest{
+		User: oidc.ProvisionUser{
+			FirstName: ident.FirstName,
+			LastName:  ident.LastName,
+		},
+	}
+	data := make(map[string]interface{})
+	f := func() (bool, error) {
+		if r.Method == http.MethodPost {
+
+			err := r.ParseForm()
+			if err != nil {
+				return false, err
+			}
+
+			decoder := schema.NewDecoder()
+			if err := decoder.Decode(req, r.PostForm); err != nil {
+				return false, err
+			}
+
+			// Execute the account / user signup.
+			res, err := h.OidcRepo.Provision(ctx, ident, *req, ctxValues.Now)
+			if err != nil {
+				switch errors.Cause(err) {
+				case account.ErrForbidden:
+					return false, web.RespondError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+				case oidc.ErrEmailNotVerified:
+					delete(sess.Values, sessionKeyOidcIdentity)
+					webcontext.SessionFlashError(ctx,
+						"Signup Failed",
+						"Your email address has not been verified by the identity provider.")
+					return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+				default:
+					if verr, ok := weberror.NewValidationError(ctx, err); ok {
+						data["validationErrors"] = verr.(*weberror.Error)
+						return false, nil
+					} else {
+						return false, err
+					}
+				}
+			}
+			delete(sess.Values, sessionKeyOidcIdentity)
+
+			// Authenticated the new user.
+			token, err := h.AuthRepo.AuthenticateIdentity(ctx, res.User.ID, res.Account.ID, time.Hour, ctxValues.Now)
+			if err != nil {
+				if errors.Cause(err) == user_auth.ErrEmailNotVerified {
+					webcontext.SessionFlashSuccess(ctx,
+						"Thank you for Joining",
+						"We sent you an email to verify your email address. Login once your email address has been verified.")
+					return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+				}
+				return false, err
+			}
+
+			// Add the token to the users session.
+			err = handleSessionToken(ctx, w, r, token)
+			if err != nil {
+				return false, err
+			}
+
+			// Display a welcome message to the user.
+			webcontext.SessionFlashSuccess(ctx,
+				"Thank you for Joining",
+				"You workflow will be a breeze starting today.")
+
+			// Redirect the user to the dashboard.
+			return true, web.Redirect(ctx, w, r, "/", http.StatusFound)
+		}
+
+		return false, nil
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		err = webcontext.ContextSession(ctx).Save(r, w)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	data["geonameCountries"] = geonames.ValidGeonameCountries(ctx)
+
+	data["countries"], err = h.GeoRepo.FindCountries(ctx, "name", "")
+	if err != nil {
+		return err
+	}
+
+	data["form"] = req
+	data["identity"] = ident
+
+	if verr, ok := weberror.NewValidationError(ctx, webcontext.Validator().Struct(oidc.ProvisionRequest{})); ok {
+		data["validationDefaults"] = verr.(*weberror.Error)
+	}
+
+	return h.Renderer.Render(ctx, w, r, TmplLayoutBase, "signup-oidc.gohtml", web.MIMETextHTMLCharsetUTF8, http.StatusOK, data)
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+	"geeks-accelerator/oss/saas-starter-kit/internal/saml"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_auth"
+
+	"github.com/pkg/errors"
+)
+
+// Session key used to persist the SAML authentication request until the identity provider posts the response.
+const sessionKeySamlRequest = "_saml_request"
+
+// SamlRepository defines the methods needed to manage and login with the SAML identity provider of an account.
+type SamlRepository interface {
+	Read(ctx context.Context, claims auth.Claims, accountID string) (*saml.AccountSso, error)
+	ReadEnforcedForEmail(ctx context.Context, email string) (*saml.AccountSso, error)
+	Save(ctx context.Context, claims auth.Claims, req saml.AccountSsoSaveRequest, now time.Time) (*saml.AccountSso, error)
+	VerifyDomain(ctx context.Context, claims auth.Claims, req saml.AccountSsoVerifyDomainRequest, now time.Time) (*saml.AccountSso, error)
+	Delete(ctx context.Context, claims auth.Claims, req saml.AccountSsoDeleteRequest, now time.Time) error
+	EntityID(accountID string) string
+	AcsUrl(accountID string) string
+	SpMetadata(ctx context.Context, accountID string) ([]byte, error)
+	AuthnRequestUrl(ctx context.Context, accountID, relayState string, now time.Time) (*saml.AuthnRequestState, string, error)
+	ParseResponse(ctx context.Context, st saml.AuthnRequestState, samlResponse string, now time.Time) (*saml.Assertion, error)
+	Login(ctx context.Context, accountID string, a *saml.Assertion, now time.Time) (*user.User, error)
+}
+
+func urlSsoSamlLogin(accountID string) string {
+	return "/sso/saml/" + accountID + "/login"
+}
+
+// SamlMetadata returns the metadata of the service provider for the account that is uploaded to the identity provider.
+func (h *UserRepos) SamlMetadata(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	dat, err := h.SamlRepo.SpMetadata(ctx, params["account_id"])
+	if err != nil {
+		if errors.Cause(err) == saml.ErrNotFound {
+			return web.RespondError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		}
+		return err
+	}
+
+	return web.Respond(ctx, w, dat, http.StatusOK, "application/samlmetadata+xml")
+}
+
+// SamlLogin redirects the user to the identity provider of the account to start the login.
+func (h *UserRepos) SamlLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The relay state is returned as is by the identity provider and is only used for the redirect after login.
+	var relayState string
+	if qv := r.URL.Query().Get("redirect"); isLocalRedirect(qv) {
+		relayState = qv
+	}
+
+	st, ssoUrl, err := h.SamlRepo.AuthnRequestUrl(ctx, params["account_id"], relayState, ctxValues.Now)
+	if err != nil {
+		if errors.Cause(err) == saml.ErrNotFound {
+			return web.RespondError(ctx, w, weberror.NewError(ctx, err, http.StatusNotFound))
+		}
+		return err
+	}
+
+	// The response of the identity provider is only accepted for the request started by this browser.
+	dat, err := json.Marshal(st)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sess := webcontext.ContextSession(ctx)
+	sess.Values[sessionKeySamlRequest] = string(dat)
+
+	return web.Redirect(ctx, w, r, ssoUrl, http.StatusFound)
+}
+
+// SamlAcs is the assertion consumer service that handles the response posted by the identity provider. The user is
+// created or updated from the assertion and logged in to the account.
+func (h *UserRepos) SamlAcs(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+
+	ctxValues, err := webcontext.ContextValues(ctx)
+	if err != nil {
+		return err
+	}
+
+	f := func() (bool, error) {
+		sess := webcontext.ContextSession(ctx)
+
+		// The request can only be used once.
+		var st saml.AuthnRequestState
+		if dat, ok := sess.Values[sessionKeySamlRequest].(string); ok {
+			if err := json.Unmarshal([]byte(dat), &st); err != nil {
+				return false, errors.WithStack(err)
+			}
+		}
+		delete(sess.Values, sessionKeySamlRequest)
+
+		if st.AccountID != params["account_id"] {
+			st = saml.AuthnRequestState{}
+		}
+
+		if err := r.ParseForm(); err != nil {
+			return false, err
+		}
+
+		a, err := h.SamlRepo.ParseResponse(ctx, st, r.PostForm.Get("SAMLResponse"), ctxValues.Now)
+		if err != nil {
+			switch errors.Cause(err) {
+			case saml.ErrInvalidResponse, saml.ErrInvalidSignature, saml.ErrNotFound:
+				webcontext.SessionFlashError(ctx,
+					"Login Failed",
+					"The single sign-on login could not be completed. Please try again.")
+				return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+			default:
+				return false, err
+			}
+		}
+
+		u, err := h.SamlRepo.Login(ctx, params["account_id"], a, ctxValues.Now)
+		if err != nil {
+			switch errors.Cause(err) {
+			case saml.ErrDomainMismatch, saml.ErrNotMember:
+				webcontext.SessionFlashError(ctx,
+					"Login Failed",
+					"Your email address is not allowed to login to this account.")
+				return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+			case saml.ErrDomainNotVerified:
+				webcontext.SessionFlashError(ctx,
+					"Login Failed",
+					"Single sign-on is not available until the account verifies its domain.")
+				return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+			default:
+				if verr, ok := weberror.NewValidationError(ctx, err); ok {
+					return false, weberror.NewErrorMessage(ctx, verr, http.StatusBadRequest,
+						"The identity provider did not return the details needed to create your user.")
+				}
+				return false, err
+			}
+		}
+
+		// Authenticated the user for the account of the identity provider.
+		token, err := h.AuthRepo.AuthenticateIdentity(ctx, u.ID, params["account_id"], time.Hour, ctxValues.Now)
+		if err != nil {
+			switch errors.Cause(err) {
+			case user.ErrForbidden:
+				return false, web.RespondError(ctx, w, weberror.NewError(ctx, err, http.StatusForbidden))
+			case user_auth.ErrAuthenticationFailure:
+				webcontext.SessionFlashError(ctx,
+					"Login Failed",
+					"Your user does not have access to this account.")
+				return true, web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+			case user_auth.ErrMfaRequired, user_auth.ErrMfaEnrollmentRequired:
+				// The identity provider verified the user but the account still requires two-factor authentication.
+				return true, h.renderLoginMfa(ctx, w, r, token, err, false)
+			default:
+				return false, err
+			}
+		}
+
+		// Add the token to the users session.
+		err = handleSessionToken(ctx, w, r, token)
+		if err != nil {
+			return false, err
+		}
+
+		redirectUri := "/"
+		if qv := r.PostForm.Get("RelayState"); isLocalRedirect(qv) {
+			redirectUri = qv
+		}
+
+		// Redirect the user to the dashboard.
+		return true, web.Redirect(ctx, w, r, redirectUri, http.StatusFound)
+	}
+
+	end, err := f()
+	if err != nil {
+		return web.RenderError(ctx, w, r, err, h.Renderer, TmplLayoutBase, TmplContentErrorGeneric, web.MIMETextHTMLCharsetUTF8)
+	} else if end {
+		err = webcontext.ContextSession(ctx).Save(r, w)
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	return web.Redirect(ctx, w, r, "/user/login", http.StatusFound)
+}
+
+// isLocalRedirect returns true when the URL only contains a path on the web app so it can't be used to redirect the
+// user to another site.
+func isLocalRedirect(s string) bool {
+	if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "/\\") {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && u.Host == "" && u.Scheme == ""
+}
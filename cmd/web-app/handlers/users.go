@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/datatable"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
@@ -30,6 +31,7 @@ type Users struct {
 	UserRepo        handlers.UserRepository
 	AccountRepo     handlers.AccountRepository
 	UserAccountRepo handlers.UserAccountRepository
+	AccountRoleRepo handlers.AccountRoleRepository
 	AuthRepo        handlers.UserAuthRepository
 	InviteRepo      handlers.UserInviteRepository
 	GeoRepo         GeoRepository
@@ -58,16 +60,31 @@ func urlUsersUpdate(userID string) string {
 	return fmt.Sprintf("/users/%s/update", userID)
 }
 
+// roleOptions returns the built-in roles and the custom roles defined for the current account.
+func (h *Users) roleOptions(ctx context.Context, claims auth.Claims) ([]interface{}, error) {
+	opts := user_account.UserAccountRole_ValuesInterface()
+
+	roles, err := h.AccountRoleRepo.FindByAccountID(ctx, claims, claims.Audience)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range roles {
+		opts = append(opts, r.Name)
+	}
+
+	return opts, nil
+}
+
 // UserCreateRequest extends the UserCreateRequest with a list of roles.
 type UserCreateRequest struct {
 	user.UserCreateRequest
-	Roles user_account.UserAccountRoles `json:"roles" validate:"required,dive,oneof=admin user" enums:"admin,user" swaggertype:"array,string" example:"admin"`
+	Roles user_account.UserAccountRoles `json:"roles" validate:"required,dive,role" swaggertype:"array,string" example:"admin"`
 }
 
 // UserUpdateRequest extends the UserUpdateRequest with a list of roles.
 type UserUpdateRequest struct {
 	user.UserUpdateRequest
-	Roles user_account.UserAccountRoles `json:"roles" validate:"required,dive,oneof=admin user" enums:"admin,user" swaggertype:"array,string" example:"admin"`
+	Roles user_account.UserAccountRoles `json:"roles" validate:"required,dive,role" swaggertype:"array,string" example:"admin"`
 }
 
 // Index handles listing all the users for the current account.
@@ -293,7 +310,11 @@ func (h *Users) Create(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	for _, r := range req.Roles {
 		selectedRoles = append(selectedRoles, r.String())
 	}
-	data["roles"] = web.NewEnumMultiResponse(ctx, selectedRoles, user_account.UserAccountRole_ValuesInterface()...)
+	roleOptions, err := h.roleOptions(ctx, claims)
+	if err != nil {
+		return err
+	}
+	data["roles"] = web.NewEnumMultiResponse(ctx, selectedRoles, roleOptions...)
 
 	data["form"] = req
 
@@ -333,7 +354,15 @@ func (h *Users) View(ctx context.Context, w http.ResponseWriter, r *http.Request
 					ID: userID,
 				}, ctxValues.Now)
 				if err != nil {
-					return false, err
+					switch errors.Cause(err) {
+					case user.ErrAccountAdmin:
+						webcontext.SessionFlashError(ctx,
+							"User Required",
+							"The user is the owner or the last admin of an account. Transfer the ownership or add another admin first.")
+						return true, web.Redirect(ctx, w, r, urlUsersView(userID), http.StatusFound)
+					default:
+						return false, err
+					}
 				}
 
 				webcontext.SessionFlashSuccess(ctx,
@@ -448,6 +477,11 @@ func (h *Users) Update(ctx context.Context, w http.ResponseWriter, r *http.Reque
 				}, ctxValues.Now)
 				if err != nil {
 					switch errors.Cause(err) {
+					case user_account.ErrLastAdmin, user_account.ErrOwnerRole:
+						webcontext.SessionFlashError(ctx,
+							"Admin Required",
+							"The owner must remain an admin and the account requires at least one active admin.")
+						return false, nil
 					default:
 						if verr, ok := weberror.NewValidationError(ctx, err); ok {
 							data["validationErrors"] = verr.(*weberror.Error)
@@ -531,7 +565,11 @@ func (h *Users) Update(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	for _, r := range req.Roles {
 		selectedRoles = append(selectedRoles, r.String())
 	}
-	data["roles"] = web.NewEnumMultiResponse(ctx, selectedRoles, user_account.UserAccountRole_ValuesInterface()...)
+	roleOptions, err := h.roleOptions(ctx, claims)
+	if err != nil {
+		return err
+	}
+	data["roles"] = web.NewEnumMultiResponse(ctx, selectedRoles, roleOptions...)
 
 	data["form"] = req
 
@@ -582,6 +620,12 @@ func (h *Users) Invite(ctx context.Context, w http.ResponseWriter, r *http.Reque
 			res, err := h.InviteRepo.SendUserInvites(ctx, claims, *req, ctxValues.Now)
 			if err != nil {
 				switch errors.Cause(err) {
+				case entitlement.ErrLimitExceeded:
+					webcontext.SessionFlashError(ctx,
+						"Seat Limit Reached",
+						"The plan for your account does not have enough seats for the invited users. Upgrade your plan to invite more users.")
+
+					return false, nil
 				default:
 					if verr, ok := weberror.NewValidationError(ctx, err); ok {
 						data["validationErrors"] = verr.(*weberror.Error)
@@ -623,7 +667,11 @@ func (h *Users) Invite(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	for _, r := range req.Roles {
 		selectedRoles = append(selectedRoles, r.String())
 	}
-	data["roles"] = web.NewEnumMultiResponse(ctx, selectedRoles, user_account.UserAccountRole_ValuesInterface()...)
+	roleOptions, err := h.roleOptions(ctx, claims)
+	if err != nil {
+		return err
+	}
+	data["roles"] = web.NewEnumMultiResponse(ctx, selectedRoles, roleOptions...)
 
 	data["form"] = req
 
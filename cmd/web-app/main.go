@@ -7,11 +7,19 @@ import (
 	"expvar"
 	"fmt"
 	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_role"
+	"geeks-accelerator/oss/saas-starter-kit/internal/api_key"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/billing"
+	"geeks-accelerator/oss/saas-starter-kit/internal/closure"
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
 	"geeks-accelerator/oss/saas-starter-kit/internal/geonames"
+	"geeks-accelerator/oss/saas-starter-kit/internal/jobs"
 	"geeks-accelerator/oss/saas-starter-kit/internal/project"
 	"geeks-accelerator/oss/saas-starter-kit/internal/signup"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account/invite"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account/transfer"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_auth"
 	"html/template"
 	"log"
@@ -30,7 +38,9 @@ import (
 	"geeks-accelerator/oss/saas-starter-kit/cmd/web-app/handlers"
 	"geeks-accelerator/oss/saas-starter-kit/internal/account"
 	"geeks-accelerator/oss/saas-starter-kit/internal/mid"
+	"geeks-accelerator/oss/saas-starter-kit/internal/oidc"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/bruteforce"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/devops"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/flag"
 	img_resize "geeks-accelerator/oss/saas-starter-kit/internal/platform/img-resize"
@@ -40,6 +50,7 @@ import (
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
 	"geeks-accelerator/oss/saas-starter-kit/internal/project_route"
+	"geeks-accelerator/oss/saas-starter-kit/internal/saml"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -108,6 +119,9 @@ func main() {
 			SessionName     string        `default:"" envconfig:"SESSION_NAME"`
 			DebugHost       string        `default:"0.0.0.0:4000" envconfig:"DEBUG_HOST"`
 			ShutdownTimeout time.Duration `default:"5s" envconfig:"SHUTDOWN_TIMEOUT"`
+			// TrustedProxyHops is the number of proxies in front of the service, ie 1 for a load balancer. The
+			// client IP is read from the address appended to X-Forwarded-For by the first of these proxies.
+			TrustedProxyHops int `default:"0" envconfig:"TRUSTED_PROXY_HOPS"`
 		}
 		Project struct {
 			Name              string `default:"" envconfig:"PROJECT_NAME"`
@@ -116,6 +130,15 @@ func main() {
 			EmailSender       string `default:"test@example.saasstartupkit.com" envconfig:"EMAIL_SENDER"`
 			WebApiBaseUrl     string `default:"http://127.0.0.1:3001" envconfig:"WEB_API_BASE_URL"  example:"http://api.example.saasstartupkit.com"`
 		}
+		Closure struct {
+			GracePeriod time.Duration `default:"720h" envconfig:"GRACE_PERIOD"`
+		}
+		Entitlements struct {
+			DefaultPlan string `default:"unlimited" envconfig:"DEFAULT_PLAN" example:"free"`
+		}
+		Jobs struct {
+			SendEmailAsync bool `default:"true" envconfig:"SEND_EMAIL_ASYNC"`
+		}
 		Redis struct {
 			Host            string        `default:":6379" envconfig:"HOST"`
 			DB              int           `default:"1" envconfig:"DB"`
@@ -130,6 +153,9 @@ func main() {
 			Driver     string `default:"postgres" envconfig:"DRIVER"`
 			Timezone   string `default:"utc" envconfig:"TIMEZONE"`
 			DisableTLS bool   `default:"true" envconfig:"DISABLE_TLS"`
+			// RowLevelSecurity enforces the tenant isolation policies of the database in addition to the ACL
+			// applied by the repositories. The policies are created by the schema migrations.
+			RowLevelSecurity bool `default:"false" envconfig:"ROW_LEVEL_SECURITY"`
 		}
 		Trace struct {
 			Host          string  `default:"127.0.0.1" envconfig:"DD_TRACE_AGENT_HOSTNAME"`
@@ -152,6 +178,28 @@ func main() {
 		Auth struct {
 			UseAwsSecretManager bool          `default:"false" envconfig:"USE_AWS_SECRET_MANAGER"`
 			KeyExpiration       time.Duration `default:"3600s" envconfig:"KEY_EXPIRATION"`
+			Algorithm           string        `default:"RS256" envconfig:"ALGORITHM"`
+			SignerKeyFile       string        `envconfig:"SIGNER_KEY_FILE"`
+			ReloadInterval      time.Duration `default:"5m" envconfig:"RELOAD_INTERVAL"`
+		}
+		BruteForce struct {
+			FreeAttempts int           `default:"3" envconfig:"FREE_ATTEMPTS"`
+			MaxAttempts  int           `default:"10" envconfig:"MAX_ATTEMPTS"`
+			BaseDelay    time.Duration `default:"1s" envconfig:"BASE_DELAY"`
+			MaxDelay     time.Duration `default:"30s" envconfig:"MAX_DELAY"`
+			Lockout      time.Duration `default:"15m" envconfig:"LOCKOUT"`
+			Window       time.Duration `default:"1h" envconfig:"WINDOW"`
+		}
+		Oidc struct {
+			GoogleClientID     string `envconfig:"GOOGLE_CLIENT_ID"`
+			GoogleClientSecret string `envconfig:"GOOGLE_CLIENT_SECRET" json:"-"` // don't print
+			GitHubClientID     string `envconfig:"GITHUB_CLIENT_ID"`
+			GitHubClientSecret string `envconfig:"GITHUB_CLIENT_SECRET" json:"-"` // don't print
+			Name               string `default:"oidc" envconfig:"NAME"`
+			DisplayName        string `default:"Single Sign-On" envconfig:"DISPLAY_NAME"`
+			Issuer             string `envconfig:"ISSUER"`
+			ClientID           string `envconfig:"CLIENT_ID"`
+			ClientSecret       string `envconfig:"CLIENT_SECRET" json:"-"` // don't print
 		}
 		BuildInfo struct {
 			CiCommitRefName  string `envconfig:"CI_COMMIT_REF_NAME"`
@@ -421,19 +469,53 @@ func main() {
 		notifyEmail = notify.NewEmailDisabled()
 	}
 
+	// Emails are enqueued and sent by the worker so the requests don't wait on the
+	// email provider. The worker service needs to be running with the same shared
+	// secret key to send them, otherwise disable SEND_EMAIL_ASYNC to send them
+	// during the request.
+	if cfg.Jobs.SendEmailAsync {
+		notifyEmail = jobs.NewEmailQueue(masterDb, cfg.Project.SharedSecretKey)
+	}
+
 	// =========================================================================
 	// Init new Authenticator
+
+	// New keys are published for the reload interval plus the time clients
+	// cache the public keys before they are used, so every instance of the
+	// service and every client has them before the first token is signed.
+	publishDelay := cfg.Auth.ReloadInterval + auth.JwksMaxAge
+
 	var authenticator *auth.Authenticator
-	if cfg.Auth.UseAwsSecretManager {
+	if cfg.Auth.SignerKeyFile != "" {
+		// The key is managed outside of the service and is not rotated.
+		var signer *auth.FileSigner
+		signer, err = auth.NewFileSigner(cfg.Auth.SignerKeyFile)
+		if err == nil {
+			authenticator, err = auth.NewAuthenticatorSigner(signer, time.Now().UTC())
+		}
+	} else if cfg.Auth.UseAwsSecretManager {
 		secretName := filepath.Join(cfg.Aws.SecretsManagerConfigPrefix, "authenticator")
-		authenticator, err = auth.NewAuthenticatorAws(awsSession, secretName, time.Now().UTC(), cfg.Auth.KeyExpiration)
+		authenticator, err = auth.NewAuthenticatorAws(awsSession, secretName, time.Now().UTC(), cfg.Auth.KeyExpiration, publishDelay, cfg.Auth.Algorithm)
 	} else {
-		authenticator, err = auth.NewAuthenticatorFile("", time.Now().UTC(), cfg.Auth.KeyExpiration)
+		authenticator, err = auth.NewAuthenticatorFile("", time.Now().UTC(), cfg.Auth.KeyExpiration, publishDelay, cfg.Auth.Algorithm)
 	}
 	if err != nil {
 		log.Fatalf("main : Constructing authenticator : %+v", err)
 	}
 
+	// Tokens are issued by the web-api, which exposes the public keys so other
+	// services can validate them.
+	authenticator.Issuer = cfg.Project.WebApiBaseUrl
+
+	// Reload the keys so keys rotated by other instances of the service are
+	// used without a restart.
+	if cfg.Auth.ReloadInterval > 0 {
+		stopReload := authenticator.StartReload(cfg.Auth.ReloadInterval, func(err error) {
+			log.Printf("main : Authenticator Reload : %+v", err)
+		})
+		defer stopReload()
+	}
+
 	// =========================================================================
 	// Init repositories and AppContext
 
@@ -443,14 +525,69 @@ func main() {
 	}
 
 	usrRepo := user.NewRepository(masterDb, projectRoute.UserResetPassword, notifyEmail, cfg.Project.SharedSecretKey)
+	usrRepo.MfaIssuer = cfg.Project.Name
+	usrRepo.VerifyUrl = projectRoute.UserVerifyEmail
 	usrAccRepo := user_account.NewRepository(masterDb)
 	accRepo := account.NewRepository(masterDb)
+	accRepo.PurgeGracePeriod = cfg.Closure.GracePeriod
+	usrRepo.PurgeGracePeriod = cfg.Closure.GracePeriod
 	geoRepo := geonames.NewRepository(masterDb)
 	accPrefRepo := account_preference.NewRepository(masterDb)
+	accRoleRepo := account_role.NewRepository(masterDb)
 	authRepo := user_auth.NewRepository(masterDb, authenticator, usrRepo, usrAccRepo, accPrefRepo)
+
+	// Throttle failed logins and password reset requests by IP address and email address.
+	bruteForceLimiter := bruteforce.New(redisClient, "bruteforce", bruteforce.Config{
+		FreeAttempts: cfg.BruteForce.FreeAttempts,
+		MaxAttempts:  cfg.BruteForce.MaxAttempts,
+		BaseDelay:    cfg.BruteForce.BaseDelay,
+		MaxDelay:     cfg.BruteForce.MaxDelay,
+		Lockout:      cfg.BruteForce.Lockout,
+		Window:       cfg.BruteForce.Window,
+	})
+	usrRepo.Limiter = bruteForceLimiter
+	authRepo.Limiter = bruteForceLimiter
+
+	// Reject access tokens for sessions that have been revoked.
+	authenticator.SessionValidator = authRepo
+
+	// Password logins are disabled for users of accounts that enforce SAML single sign-on.
+	samlRepo := saml.NewRepository(masterDb, usrRepo, usrAccRepo, projectRoute.WebAppUrl)
+	authRepo.Sso = samlRepo
+
 	signupRepo := signup.NewRepository(masterDb, usrRepo, usrAccRepo, accRepo)
 	inviteRepo := invite.NewRepository(masterDb, usrRepo, usrAccRepo, accRepo, projectRoute.UserInviteAccept, notifyEmail, cfg.Project.SharedSecretKey)
+	transferRepo := transfer.NewRepository(masterDb, usrRepo, usrAccRepo, accRepo, projectRoute.AccountTransferAccept, notifyEmail, cfg.Project.SharedSecretKey)
 	prjRepo := project.NewRepository(masterDb)
+	closureRepo := closure.NewRepository(masterDb, usrRepo, usrAccRepo, accRepo, accPrefRepo, prjRepo, projectRoute.AccountRestore, notifyEmail, cfg.Project.SharedSecretKey)
+
+	// Enforce the limits of the plans before projects are created and users are invited. The web-app only reads the
+	// subscriptions so the payment provider is not needed.
+	billingRepo := billing.NewRepository(masterDb, accRepo, nil)
+	entRepo := entitlement.NewRepository(masterDb, billingRepo)
+	entRepo.DefaultPlanID = cfg.Entitlements.DefaultPlan
+	prjRepo.Entitlements = entRepo
+	inviteRepo.Entitlements = entRepo
+
+	// Scope the queries of the repositories for tables with row level security policies to the tenant of the claims.
+	prjRepo.RowLevelSecurity = cfg.DB.RowLevelSecurity
+	usrAccRepo.RowLevelSecurity = cfg.DB.RowLevelSecurity
+	accPrefRepo.RowLevelSecurity = cfg.DB.RowLevelSecurity
+
+	auditRepo := audit.NewRepository(masterDb)
+	apiKeyRepo := api_key.NewRepository(masterDb)
+
+	// Login with OpenID Connect is only enabled for the providers that have been configured.
+	var oidcProviders []oidc.Provider
+	if cfg.Oidc.GoogleClientID != "" {
+		oidcProviders = append(oidcProviders, oidc.GoogleProvider(cfg.Oidc.GoogleClientID, cfg.Oidc.GoogleClientSecret))
+	}
+	if cfg.Oidc.GitHubClientID != "" {
+		oidcProviders = append(oidcProviders, oidc.GitHubProvider(cfg.Oidc.GitHubClientID, cfg.Oidc.GitHubClientSecret))
+	}
+	if cfg.Oidc.Issuer != "" && cfg.Oidc.ClientID != "" {
+		oidcProviders = append(oidcProviders, oidc.GenericProvider(cfg.Oidc.Name, cfg.Oidc.DisplayName, cfg.Oidc.Issuer, cfg.Oidc.ClientID, cfg.Oidc.ClientSecret))
+	}
 
 	appCtx := &handlers.AppContext{
 		Log: log,
@@ -464,12 +601,25 @@ func main() {
 		UserAccountRepo: usrAccRepo,
 		AccountRepo:     accRepo,
 		AccountPrefRepo: accPrefRepo,
+		AccountRoleRepo: accRoleRepo,
 		AuthRepo:        authRepo,
 		GeoRepo:         geoRepo,
 		SignupRepo:      signupRepo,
 		InviteRepo:      inviteRepo,
+		TransferRepo:    transferRepo,
+		ClosureRepo:     closureRepo,
 		ProjectRepo:     prjRepo,
+		AuditRepo:       auditRepo,
+		ApiKeyRepo:      apiKeyRepo,
+		SamlRepo:        samlRepo,
 		Authenticator:   authenticator,
+
+		PurgeGracePeriod: cfg.Closure.GracePeriod,
+		TrustedProxyHops: cfg.Service.TrustedProxyHops,
+	}
+
+	if len(oidcProviders) > 0 {
+		appCtx.OidcRepo = oidc.NewRepository(masterDb, usrRepo, signupRepo, oidcProviders...)
 	}
 
 	// =========================================================================
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	_ "net/http/pprof"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/closure"
+	"geeks-accelerator/oss/saas-starter-kit/internal/jobs"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/devops"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/flag"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/notify"
+	"geeks-accelerator/oss/saas-starter-kit/internal/project"
+	"geeks-accelerator/oss/saas-starter-kit/internal/project_route"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	awstrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/aws/aws-sdk-go/aws"
+	sqltrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql"
+	sqlxtrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/jmoiron/sqlx"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// build is the git version of this program. It is set using build flags in the makefile.
+var build = "develop"
+
+// service is the name of the program used for logging, tracing and the
+// the prefix used for loading env variables
+// ie: export WORKER_ENV=dev
+var service = "WORKER"
+
+// Job types run by the worker in addition to the ones defined by the jobs package.
+const (
+	jobTypeClosurePurge = "closure.purge"
+	jobTypeJobsPrune    = "jobs.prune"
+)
+
+func main() {
+
+	// =========================================================================
+	// Logging
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+	log.SetPrefix(service + " : ")
+	log := log.New(os.Stdout, log.Prefix(), log.Flags())
+
+	// =========================================================================
+	// Configuration
+	var cfg struct {
+		Env     string `default:"dev" envconfig:"ENV"`
+		Service struct {
+			Name            string        `default:"worker" envconfig:"SERVICE_NAME"`
+			DebugHost       string        `default:"0.0.0.0:4002" envconfig:"DEBUG_HOST"`
+			ShutdownTimeout time.Duration `default:"30s" envconfig:"SHUTDOWN_TIMEOUT"`
+		}
+		Project struct {
+			Name              string `default:"" envconfig:"PROJECT_NAME"`
+			SharedTemplateDir string `default:"../../resources/templates/shared" envconfig:"SHARED_TEMPLATE_DIR"`
+			SharedSecretKey   string `default:"" envconfig:"SHARED_SECRET_KEY" json:"-"` // don't print
+			EmailSender       string `default:"test@example.saasstartupkit.com" envconfig:"EMAIL_SENDER"`
+			WebApiBaseUrl     string `default:"http://127.0.0.1:3001" envconfig:"WEB_API_BASE_URL"  example:"http://api.example.saasstartupkit.com"`
+			WebAppBaseUrl     string `default:"http://127.0.0.1:3000" envconfig:"WEB_APP_BASE_URL" example:"www.example.saasstartupkit.com"`
+		}
+		Jobs struct {
+			PollInterval  time.Duration `default:"5s" envconfig:"POLL_INTERVAL"`
+			Concurrency   int           `default:"2" envconfig:"CONCURRENCY"`
+			BatchSize     int           `default:"10" envconfig:"BATCH_SIZE"`
+			MaxAttempts   int           `default:"8" envconfig:"MAX_ATTEMPTS"`
+			RetryBackoff  time.Duration `default:"30s" envconfig:"RETRY_BACKOFF"`
+			Lease         time.Duration `default:"5m" envconfig:"LEASE"`
+			PruneSchedule string        `default:"@daily" envconfig:"PRUNE_SCHEDULE"`
+			PruneAfter    time.Duration `default:"168h" envconfig:"PRUNE_AFTER"`
+		}
+		Closure struct {
+			PurgeSchedule string `default:"@hourly" envconfig:"PURGE_SCHEDULE"`
+		}
+		DB struct {
+			Host       string `default:"127.0.0.1:5433" envconfig:"HOST"`
+			User       string `default:"postgres" envconfig:"USER"`
+			Pass       string `default:"postgres" envconfig:"PASS" json:"-"` // don't print
+			Database   string `default:"shared" envconfig:"DATABASE"`
+			Driver     string `default:"postgres" envconfig:"DRIVER"`
+			Timezone   string `default:"utc" envconfig:"TIMEZONE"`
+			DisableTLS bool   `default:"true" envconfig:"DISABLE_TLS"`
+		}
+		Trace struct {
+			Host          string  `default:"127.0.0.1" envconfig:"DD_TRACE_AGENT_HOSTNAME"`
+			Port          int     `default:"8126" envconfig:"DD_TRACE_AGENT_PORT"`
+			AnalyticsRate float64 `default:"0.10" envconfig:"ANALYTICS_RATE"`
+		}
+		Aws struct {
+			AccessKeyID                string `envconfig:"AWS_ACCESS_KEY_ID"`              // WORKER_AWS_AWS_ACCESS_KEY_ID or AWS_ACCESS_KEY_ID
+			SecretAccessKey            string `envconfig:"AWS_SECRET_ACCESS_KEY" json:"-"` // don't print
+			Region                     string `default:"us-west-2" envconfig:"AWS_REGION"`
+			SecretsManagerConfigPrefix string `default:"" envconfig:"SECRETS_MANAGER_CONFIG_PREFIX"`
+
+			// Get an AWS session from an implicit source if no explicit
+			// configuration is provided. This is useful for taking advantage of
+			// EC2/ECS instance roles.
+			UseRole bool `envconfig:"AWS_USE_ROLE"`
+		}
+		BuildInfo struct {
+			CiCommitRefName  string `envconfig:"CI_COMMIT_REF_NAME"`
+			CiCommitShortSha string `envconfig:"CI_COMMIT_SHORT_SHA"`
+			CiCommitSha      string `envconfig:"CI_COMMIT_SHA"`
+			CiCommitTag      string `envconfig:"CI_COMMIT_TAG"`
+			CiJobId          string `envconfig:"CI_JOB_ID"`
+			CiJobUrl         string `envconfig:"CI_JOB_URL"`
+			CiPipelineId     string `envconfig:"CI_PIPELINE_ID"`
+			CiPipelineUrl    string `envconfig:"CI_PIPELINE_URL"`
+		}
+	}
+
+	// For additional details refer to https://github.com/kelseyhightower/envconfig
+	if err := envconfig.Process(service, &cfg); err != nil {
+		log.Fatalf("main : Parsing Config : %+v", err)
+	}
+
+	if err := flag.Process(&cfg); err != nil {
+		if err != flag.ErrHelp {
+			log.Fatalf("main : Parsing Command Line : %+v", err)
+		}
+		return // We displayed help.
+	}
+
+	// =========================================================================
+	// Config Validation & Defaults
+
+	// AWS access keys are required, if roles are enabled, remove any placeholders.
+	if cfg.Aws.UseRole {
+		cfg.Aws.AccessKeyID = ""
+		cfg.Aws.SecretAccessKey = ""
+
+		// Get an AWS session from an implicit source if no explicit
+		// configuration is provided. This is useful for taking advantage of
+		// EC2/ECS instance roles.
+		if cfg.Aws.Region == "" {
+			sess := session.Must(session.NewSession())
+			md := ec2metadata.New(sess)
+
+			var err error
+			cfg.Aws.Region, err = md.Region()
+			if err != nil {
+				log.Fatalf("main : Load region of ecs metadata : %+v", err)
+			}
+		}
+	}
+
+	// Set the default AWS Secrets Manager prefix used for name to store config files that will be persisted across
+	// deployments and distributed to each instance of the service running.
+	if cfg.Aws.SecretsManagerConfigPrefix == "" {
+		var pts []string
+		if cfg.Project.Name != "" {
+			pts = append(pts, cfg.Project.Name)
+		}
+		pts = append(pts, cfg.Env)
+
+		cfg.Aws.SecretsManagerConfigPrefix = filepath.Join(pts...)
+	}
+
+	// At least one goroutine is required to run the jobs.
+	if cfg.Jobs.Concurrency < 1 {
+		cfg.Jobs.Concurrency = 1
+	}
+
+	// =========================================================================
+	// Log Service Info
+
+	// Print the build version for our logs. Also expose it under /debug/vars.
+	expvar.NewString("build").Set(build)
+	log.Printf("main : Started : Service Initializing version %q", build)
+	defer log.Println("main : Completed")
+
+	// Print the config for our logs. It's important to any credentials in the config
+	// that could expose a security risk are excluded from being json encoded by
+	// applying the tag `json:"-"` to the struct var.
+	{
+		cfgJSON, err := json.MarshalIndent(cfg, "", "    ")
+		if err != nil {
+			log.Fatalf("main : Marshalling Config to JSON : %+v", err)
+		}
+		log.Printf("main : Config : %v\n", string(cfgJSON))
+	}
+
+	// =========================================================================
+	// Init AWS Session
+	var awsSession *session.Session
+	if cfg.Aws.UseRole {
+		// Get an AWS session from an implicit source if no explicit
+		// configuration is provided. This is useful for taking advantage of
+		// EC2/ECS instance roles.
+		awsSession = session.Must(session.NewSession())
+		if cfg.Aws.Region != "" {
+			awsSession.Config.WithRegion(cfg.Aws.Region)
+		}
+
+		log.Printf("main : AWS : Using role.\n")
+
+	} else if cfg.Aws.AccessKeyID != "" {
+		creds := credentials.NewStaticCredentials(cfg.Aws.AccessKeyID, cfg.Aws.SecretAccessKey, "")
+		awsSession = session.New(&aws.Config{Region: aws.String(cfg.Aws.Region), Credentials: creds})
+
+		log.Printf("main : AWS : Using static credentials\n")
+	}
+
+	// Wrap the AWS session to enable tracing.
+	if awsSession != nil {
+		awsSession = awstrace.WrapSession(awsSession)
+	}
+
+	// =========================================================================
+	// Shared Secret Key used for encrypting the emails enqueued by web-app and web-api.
+
+	// Load the secret key stored by web-app and web-api if not provided in the config. The worker doesn't generate
+	// a new key as it would not be able to decrypt the emails enqueued with the existing one.
+	if cfg.Project.SharedSecretKey == "" && awsSession != nil {
+		secretID := filepath.Join(cfg.Aws.SecretsManagerConfigPrefix, "SharedSecretKey")
+
+		var err error
+		cfg.Project.SharedSecretKey, err = devops.SecretManagerGetString(awsSession, secretID)
+		if err != nil && errors.Cause(err) != devops.ErrSecreteNotFound {
+			log.Fatalf("main : Session : %+v", err)
+		}
+	}
+	if cfg.Project.SharedSecretKey == "" {
+		log.Printf("main : Session : Shared secret key is not set, emails enqueued by web-app and web-api will fail to send.")
+	}
+
+	// =========================================================================
+	// Start Database
+	var dbUrl url.URL
+	{
+		// Query parameters.
+		var q url.Values = make(map[string][]string)
+
+		// Handle SSL Mode
+		if cfg.DB.DisableTLS {
+			q.Set("sslmode", "disable")
+		} else {
+			q.Set("sslmode", "require")
+		}
+
+		q.Set("timezone", cfg.DB.Timezone)
+
+		// Construct url.
+		dbUrl = url.URL{
+			Scheme:   cfg.DB.Driver,
+			User:     url.UserPassword(cfg.DB.User, cfg.DB.Pass),
+			Host:     cfg.DB.Host,
+			Path:     cfg.DB.Database,
+			RawQuery: q.Encode(),
+		}
+	}
+	log.Println("main : Started : Initialize Database")
+
+	// Register informs the sqlxtrace package of the driver that we will be using in our program.
+	// It uses a default service name, in the below case "postgres.db". To use a custom service
+	// name use RegisterWithServiceName.
+	sqltrace.Register(cfg.DB.Driver, &pq.Driver{}, sqltrace.WithServiceName(service))
+	masterDb, err := sqlxtrace.Open(cfg.DB.Driver, dbUrl.String())
+	if err != nil {
+		log.Fatalf("main : Register DB : %s : %+v", cfg.DB.Driver, err)
+	}
+	defer masterDb.Close()
+
+	// =========================================================================
+	// Notify Email
+	var notifyEmail notify.Email
+	if awsSession != nil {
+		// Send emails with AWS SES. Alternative to use SMTP with notify.NewEmailSmtp.
+		notifyEmail, err = notify.NewEmailAws(awsSession, cfg.Project.SharedTemplateDir, cfg.Project.EmailSender)
+		if err != nil {
+			log.Fatalf("main : Notify Email : %+v", err)
+		}
+
+		err = notifyEmail.Verify()
+		if err != nil {
+			switch errors.Cause(err) {
+			case notify.ErrAwsSesIdentityNotVerified:
+				log.Printf("main : Notify Email : %s\n", err)
+			case notify.ErrAwsSesSendingDisabled:
+				log.Printf("main : Notify Email : %s\n", err)
+			default:
+				log.Fatalf("main : Notify Email Verify : %+v", err)
+			}
+		}
+	} else {
+		notifyEmail = notify.NewEmailDisabled()
+	}
+
+	// =========================================================================
+	// Init repositories
+
+	projectRoute, err := project_route.New(cfg.Project.WebApiBaseUrl, cfg.Project.WebAppBaseUrl)
+	if err != nil {
+		log.Fatalf("main : project routes : %s: %+v", cfg.Project.WebApiBaseUrl, err)
+	}
+
+	usrRepo := user.NewRepository(masterDb, projectRoute.UserResetPassword, notifyEmail, cfg.Project.SharedSecretKey)
+	usrAccRepo := user_account.NewRepository(masterDb)
+	accRepo := account.NewRepository(masterDb)
+	accPrefRepo := account_preference.NewRepository(masterDb)
+	prjRepo := project.NewRepository(masterDb)
+	closureRepo := closure.NewRepository(masterDb, usrRepo, usrAccRepo, accRepo, accPrefRepo, prjRepo, projectRoute.AccountRestore, notifyEmail, cfg.Project.SharedSecretKey)
+
+	jobRepo := jobs.NewRepository(masterDb)
+	jobRepo.MaxAttempts = cfg.Jobs.MaxAttempts
+	jobRepo.RetryBackoff = cfg.Jobs.RetryBackoff
+	jobRepo.Lease = cfg.Jobs.Lease
+	jobRepo.BatchSize = cfg.Jobs.BatchSize
+
+	// =========================================================================
+	// Register Job Handlers
+
+	// Emails enqueued by web-app and web-api are sent with the email provider.
+	jobRepo.Register(jobs.JobType_SendEmail, jobs.SendEmailHandler(notifyEmail, cfg.Project.SharedSecretKey))
+
+	// Accounts and users that were closed are permanently deleted once their
+	// grace period has passed.
+	jobRepo.Register(jobTypeClosurePurge, func(ctx context.Context, job *jobs.Job) error {
+		n, err := closureRepo.Purge(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		log.Printf("main : Closure Purge : %d purged", n)
+		return nil
+	})
+
+	// Jobs that succeeded are removed from the queue after a while.
+	jobRepo.Register(jobTypeJobsPrune, func(ctx context.Context, job *jobs.Job) error {
+		n, err := jobRepo.Prune(ctx, time.Now().Add(-cfg.Jobs.PruneAfter))
+		if err != nil {
+			return err
+		}
+		log.Printf("main : Jobs Prune : %d pruned", n)
+		return nil
+	})
+
+	// =========================================================================
+	// Register Job Schedules
+	if cfg.Closure.PurgeSchedule != "" {
+		err = jobRepo.Schedule(jobTypeClosurePurge, cfg.Closure.PurgeSchedule, jobs.JobEnqueueRequest{Type: jobTypeClosurePurge})
+		if err != nil {
+			log.Fatalf("main : Schedule %s : %+v", jobTypeClosurePurge, err)
+		}
+	}
+
+	if cfg.Jobs.PruneSchedule != "" {
+		err = jobRepo.Schedule(jobTypeJobsPrune, cfg.Jobs.PruneSchedule, jobs.JobEnqueueRequest{Type: jobTypeJobsPrune})
+		if err != nil {
+			log.Fatalf("main : Schedule %s : %+v", jobTypeJobsPrune, err)
+		}
+	}
+
+	// =========================================================================
+	// Start Tracing Support
+	th := fmt.Sprintf("%s:%d", cfg.Trace.Host, cfg.Trace.Port)
+	log.Printf("main : Tracing Started : %s", th)
+	sr := tracer.NewRateSampler(cfg.Trace.AnalyticsRate)
+	tracer.Start(tracer.WithAgentAddr(th), tracer.WithSampler(sr))
+	defer tracer.Stop()
+
+	// =========================================================================
+	// Start Debug Service. Not concerned with shutting this down when the
+	// application is being shutdown.
+	//
+	// /debug/vars - Added to the default mux by the expvars package.
+	// /debug/pprof - Added to the default mux by the net/http/pprof package.
+	if cfg.Service.DebugHost != "" {
+		go func() {
+			log.Printf("main : Debug Listening %s", cfg.Service.DebugHost)
+			log.Printf("main : Debug Listener closed : %v", http.ListenAndServe(cfg.Service.DebugHost, http.DefaultServeMux))
+		}()
+	}
+
+	// =========================================================================
+	// Start Worker
+
+	// Make a channel to listen for an interrupt or terminate signal from the OS.
+	// Use a buffered channel because the signal package requires it.
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+
+	// stop is closed once shutdown starts so no new jobs are reserved. The context
+	// of the running jobs is only cancelled once the shutdown timeout has passed,
+	// the jobs that are interrupted are released back to the queue.
+	stop := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	// Enqueue the scheduled jobs. Each worker checks the schedules, the unique key
+	// of each run ensures it's only enqueued once.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		log.Printf("main : Job Schedules Started : %v", cfg.Jobs.PollInterval)
+
+		ticker := time.NewTicker(cfg.Jobs.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			_, err := jobRepo.EnqueueScheduled(ctx, time.Now())
+			if err != nil {
+				log.Printf("main : Job Schedules : %+v", err)
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	for i := 0; i < cfg.Jobs.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(cfg.Jobs.PollInterval)
+			defer ticker.Stop()
+
+			for {
+				n, err := jobRepo.RunPending(ctx, time.Now())
+				if err != nil {
+					log.Printf("main : Run Jobs : %+v", err)
+				}
+
+				// Keep going while there are jobs, otherwise wait for the next poll.
+				if n > 0 && err == nil {
+					select {
+					case <-stop:
+						return
+					default:
+						continue
+					}
+				}
+
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	log.Printf("main : Job Runners Started : %d", cfg.Jobs.Concurrency)
+
+	// =========================================================================
+	// Shutdown
+
+	// Blocking main and waiting for shutdown.
+	sig := <-shutdown
+	log.Printf("main : %v : Start shutdown..", sig)
+	close(stop)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(cfg.Service.ShutdownTimeout):
+		log.Printf("main : Graceful shutdown did not complete in %v", cfg.Service.ShutdownTimeout)
+
+		// Cancel the running jobs so they are released back to the queue.
+		cancel()
+		<-done
+	}
+}
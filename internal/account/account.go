@@ -3,10 +3,14 @@ package account
 import (
 	"context"
 	"database/sql"
+	"log"
+	"strings"
 	"time"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/webhook"
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/jmoiron/sqlx"
 	"github.com/pborman/uuid"
@@ -21,6 +25,16 @@ const (
 	userAccountTableName = "users_accounts"
 	// The database table for AccountPreference
 	accountPreferenceTableName = "account_preferences"
+	// The database table for AccountRole
+	accountRoleTableName = "account_roles"
+	// The database table for Project
+	projectTableName = "projects"
+	// The database table for AuditEvent
+	auditEventTableName = "audit_events"
+	// The database table for Refresh Token
+	refreshTokenTableName = "refresh_tokens"
+	// The database table for User Session
+	userSessionTableName = "user_sessions"
 )
 
 var (
@@ -29,6 +43,9 @@ var (
 
 	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
 	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrNotClosed occurs when restoring an account that is not closed or has already been purged.
+	ErrNotClosed = errors.New("Account is not closed")
 )
 
 // CanReadAccount determines if claims has the authority to access the specified account ID.
@@ -75,19 +92,31 @@ func CanModifyAccount(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB,
 	// has the correct access to the account.
 	if claims.Audience != "" {
 		if claims.Audience == accountID {
-			// Admin users can update accounts they have access to.
-			if !claims.HasRole(auth.RoleAdmin) {
+			// Users with the account:write permission can update accounts they have access to.
+			if !claims.HasPermission(auth.PermissionAccountWrite) {
 				return errors.WithStack(ErrForbidden)
 			}
 		} else {
 			// When the claims Audience/AccountID does not match the requested account, the
-			// claims Audience/AccountID should have a record with an admin role.
-			// select id from users_accounts where account_id = [accountID] and user_id = [claims.Subject] and any (roles) = 'admin'
-			query := sqlbuilder.NewSelectBuilder().Select("id").From(userAccountTableName)
+			// claims Audience/AccountID should have a record with a role that grants the
+			// account:write permission, either a built-in role or a custom role for the account.
+			// select id from users_accounts where account_id = [accountID] and user_id = [claims.Subject]
+			// 	and (roles && [built-in roles] or exists (select id from account_roles where ...))
+			var builtIn []string
+			for _, r := range auth.RolesWithPermission(auth.PermissionAccountWrite) {
+				builtIn = append(builtIn, "'"+r+"'")
+			}
+
+			query := sqlbuilder.NewSelectBuilder().Select("ua.id").From(userAccountTableName + " ua")
 			query.Where(query.And(
-				query.Equal("account_id", accountID),
-				query.Equal("user_id", claims.Subject),
-				"'"+auth.RoleAdmin+"' = ANY (roles)",
+				query.Equal("ua.account_id", accountID),
+				query.Equal("ua.user_id", claims.Subject),
+				query.Or(
+					"ua.roles && ARRAY["+strings.Join(builtIn, ",")+"]::varchar[]",
+					"EXISTS (SELECT ar.id FROM "+accountRoleTableName+" ar WHERE ar.account_id = ua.account_id "+
+						"AND ar.name = ANY (ua.roles) AND ar.archived_at IS NULL "+
+						"AND "+query.Var(auth.PermissionAccountWrite)+" = ANY (ar.permissions))",
+				),
 			))
 			queryStr, args := query.Build()
 			queryStr = dbConn.Rebind(queryStr)
@@ -147,7 +176,7 @@ func applyClaimsSelect(ctx context.Context, claims auth.Claims, query *sqlbuilde
 }
 
 // accountMapColumns is the list of columns needed for find.
-var accountMapColumns = "id,name,address1,address2,city,region,country,zipcode,status,timezone,signup_user_id,billing_user_id,created_at,updated_at,archived_at"
+var accountMapColumns = "id,name,address1,address2,city,region,country,zipcode,status,timezone,signup_user_id,billing_user_id,created_at,updated_at,archived_at,purge_at"
 
 // selectQuery constructs a base select query for Account.
 func selectQuery() *sqlbuilder.SelectBuilder {
@@ -158,13 +187,13 @@ func selectQuery() *sqlbuilder.SelectBuilder {
 }
 
 // Find gets all the accounts from the database based on the request params.
-// TODO: Need to figure out why can't parse the args when appending the where
-// 			to the query.
 func (repo *Repository) Find(ctx context.Context, claims auth.Claims, req AccountFindRequest) (Accounts, error) {
 	query := selectQuery()
 
-	if req.Where != "" {
-		query.Where(query.And(req.Where))
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, AccountFindFields); err != nil {
+			return nil, err
+		}
 	}
 	if len(req.Order) > 0 {
 		query.OrderBy(req.Order...)
@@ -176,7 +205,7 @@ func (repo *Repository) Find(ctx context.Context, claims auth.Claims, req Accoun
 		query.Offset(int(*req.Offset))
 	}
 
-	return find(ctx, claims, repo.DbConn, query, req.Args, req.IncludeArchived)
+	return find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
 }
 
 // find internal method for getting all the accounts from the database using a select query.
@@ -215,7 +244,7 @@ func find(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB, query *sqlbu
 			a   Account
 			err error
 		)
-		err = rows.Scan(&a.ID, &a.Name, &a.Address1, &a.Address2, &a.City, &a.Region, &a.Country, &a.Zipcode, &a.Status, &a.Timezone, &a.SignupUserID, &a.BillingUserID, &a.CreatedAt, &a.UpdatedAt, &a.ArchivedAt)
+		err = rows.Scan(&a.ID, &a.Name, &a.Address1, &a.Address2, &a.City, &a.Region, &a.Country, &a.Zipcode, &a.Status, &a.Timezone, &a.SignupUserID, &a.BillingUserID, &a.CreatedAt, &a.UpdatedAt, &a.ArchivedAt, &a.PurgeAt)
 		if err != nil {
 			err = errors.Wrapf(err, "query - %s", query.String())
 		}
@@ -328,6 +357,17 @@ func (repo *Repository) Create(ctx context.Context, claims auth.Claims, req Acco
 		return nil, err
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  a.ID,
+		EntityType: audit.EntityType_Account,
+		EntityID:   a.ID,
+		Action:     audit.Action_Create,
+		After:      a,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
 	return &a, nil
 }
 
@@ -397,6 +437,12 @@ func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req Acco
 		return err
 	}
 
+	// Load the current account so the change can be recorded.
+	before, err := repo.Read(ctx, auth.Claims{}, AccountReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
 	// If now empty set it to the current time.
 	if now.IsZero() {
 		now = time.Now()
@@ -478,6 +524,29 @@ func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req Acco
 		return err
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  req.ID,
+		EntityType: audit.EntityType_Account,
+		EntityID:   req.ID,
+		Action:     audit.Action_Update,
+		Before:     before,
+		After:      req,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	// Load the updated account so it can be included in the event.
+	after, err := repo.Read(ctx, auth.Claims{}, AccountReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
+	err = webhook.Publish(ctx, repo.DbConn, req.ID, webhook.EventType_AccountUpdated, after, now)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -499,6 +568,12 @@ func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req Acc
 		return err
 	}
 
+	// Load the current account so the change can be recorded.
+	before, err := repo.Read(ctx, auth.Claims{}, AccountReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
 	// If now empty set it to the current time.
 	if now.IsZero() {
 		now = time.Now()
@@ -550,6 +625,18 @@ func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req Acc
 		}
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  req.ID,
+		EntityType: audit.EntityType_Account,
+		EntityID:   req.ID,
+		Action:     audit.Action_Archive,
+		Before:     before,
+		After:      map[string]interface{}{"archived_at": now},
+	}, now)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -571,6 +658,12 @@ func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req Acco
 		return err
 	}
 
+	// Load the current account so the change can be recorded.
+	before, err := repo.Read(ctx, auth.Claims{}, AccountReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
 	// Start a new transaction to handle rollbacks on error.
 	tx, err := repo.DbConn.Begin()
 	if err != nil {
@@ -645,6 +738,381 @@ func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req Acco
 		return errors.WithStack(err)
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  req.ID,
+		EntityType: audit.EntityType_Account,
+		EntityID:   req.ID,
+		Action:     audit.Action_Delete,
+		Before:     before,
+	}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Close archives the account and all of its users. The account can be restored until the grace period has passed
+// and then it will be permanently deleted by PurgeClosed.
+func (repo *Repository) Close(ctx context.Context, claims auth.Claims, req AccountCloseRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account.Close")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can modify the account specified in the request.
+	err = CanModifyAccount(ctx, claims, repo.DbConn, req.ID)
+	if err != nil {
+		return err
+	}
+
+	// Load the current account so the change can be recorded, accounts already archived can't be closed.
+	before, err := repo.Read(ctx, auth.Claims{}, AccountReadRequest{ID: req.ID})
+	if err != nil {
+		return err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	gracePeriod := repo.PurgeGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultPurgeGracePeriod
+	}
+	purgeAt := now.Add(gracePeriod)
+
+	// Start a new transaction to handle rollbacks on error.
+	tx, err := repo.DbConn.Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(accountTableName)
+	query.Set(
+		query.Assign("archived_at", now),
+		query.Assign("purge_at", purgeAt),
+	)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = tx.ExecContext(ctx, sql, args...)
+	if err != nil {
+		tx.Rollback()
+
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "close account %s failed", req.ID)
+		return err
+	}
+
+	// Archive the user accounts that are not already archived, restore relies on the matching archived_at to only
+	// restore the user accounts archived by close.
+	{
+		// Build the update SQL statement.
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(userAccountTableName)
+		query.Set(query.Assign("archived_at", now))
+		query.Where(query.And(
+			query.Equal("account_id", req.ID),
+			query.IsNull("archived_at"),
+		))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err = tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			tx.Rollback()
+
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "archive users for account %s failed", req.ID)
+			return err
+		}
+	}
+
+	// Revoke all existing sessions for the account.
+	for _, tableName := range []string{userSessionTableName, refreshTokenTableName} {
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(tableName)
+		query.Set(query.Assign("revoked_at", now))
+		query.Where(query.And(
+			query.Equal("account_id", req.ID),
+			query.IsNull("revoked_at"),
+		))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err = tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			tx.Rollback()
+
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "revoke sessions for account %s failed", req.ID)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  req.ID,
+		EntityType: audit.EntityType_Account,
+		EntityID:   req.ID,
+		Action:     audit.Action_Close,
+		Before:     before,
+		After:      map[string]interface{}{"archived_at": now, "purge_at": purgeAt},
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Restore reverts closing the account before it has been purged. Only the user accounts that were archived when the
+// account was closed are restored.
+func (repo *Repository) Restore(ctx context.Context, claims auth.Claims, req AccountRestoreRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account.Restore")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can modify the account specified in the request.
+	err = CanModifyAccount(ctx, claims, repo.DbConn, req.ID)
+	if err != nil {
+		return err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	before, err := repo.Read(ctx, auth.Claims{}, AccountReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	} else if before.ArchivedAt == nil || !before.ArchivedAt.Valid || before.PurgeAt == nil || !before.PurgeAt.Valid {
+		return errors.WithMessagef(ErrNotClosed, "account %s is not closed", req.ID)
+	} else if !before.PurgeAt.Time.After(now) {
+		return errors.WithMessagef(ErrNotClosed, "account %s was scheduled to be purged at %s", req.ID, before.PurgeAt.Time)
+	}
+
+	// Start a new transaction to handle rollbacks on error.
+	tx, err := repo.DbConn.Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(accountTableName)
+	query.Set(
+		query.Assign("archived_at", nil),
+		query.Assign("purge_at", nil),
+		query.Assign("updated_at", now),
+	)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = tx.ExecContext(ctx, sql, args...)
+	if err != nil {
+		tx.Rollback()
+
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "restore account %s failed", req.ID)
+		return err
+	}
+
+	// Restore the user accounts archived when the account was closed.
+	{
+		// Build the update SQL statement.
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(userAccountTableName)
+		query.Set(query.Assign("archived_at", nil))
+		query.Where(query.And(
+			query.Equal("account_id", req.ID),
+			query.Equal("archived_at", before.ArchivedAt.Time),
+		))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err = tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			tx.Rollback()
+
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "restore users for account %s failed", req.ID)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  req.ID,
+		EntityType: audit.EntityType_Account,
+		EntityID:   req.ID,
+		Action:     audit.Action_Restore,
+		After:      map[string]interface{}{"archived_at": nil, "purge_at": nil},
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PurgeClosed permanently deletes the accounts that were closed and not restored before the grace period passed. All
+// the data of the accounts is removed, including their audit events. The number of purged accounts is returned.
+func (repo *Repository) PurgeClosed(ctx context.Context, now time.Time) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account.PurgeClosed")
+	defer span.Finish()
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	query := sqlbuilder.NewSelectBuilder().Select("id").From(accountTableName)
+	query.Where(query.And(
+		query.IsNotNull("purge_at"),
+		query.LessEqualThan("purge_at", now),
+	))
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var ids []string
+	err := repo.DbConn.SelectContext(ctx, &ids, queryStr, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find accounts to purge failed")
+		return 0, err
+	}
+
+	// An account that fails to purge shouldn't block the others, it will be retried on the next run.
+	var purged, failed int
+	for _, id := range ids {
+		err = repo.purge(ctx, id, now)
+		if err != nil {
+			log.Printf("internal.account.PurgeClosed : purge account %s failed : %+v", id, err)
+			failed++
+			continue
+		}
+		purged++
+	}
+
+	if failed > 0 {
+		return purged, errors.Errorf("purge failed for %d of %d accounts", failed, len(ids))
+	}
+
+	return purged, nil
+}
+
+// purge deletes the account and all the data associated with it.
+func (repo *Repository) purge(ctx context.Context, accountID string, now time.Time) error {
+	// Start a new transaction to handle rollbacks on error.
+	tx, err := repo.DbConn.Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Tables with a foreign key to the account that doesn't cascade are deleted first, the audit events don't have
+	// a foreign key but they can include the personal data of users.
+	for _, tableName := range []string{userAccountTableName, accountPreferenceTableName, projectTableName, auditEventTableName} {
+		// Build the delete SQL statement.
+		query := sqlbuilder.NewDeleteBuilder()
+		query.DeleteFrom(tableName)
+		query.Where(query.Equal("account_id", accountID))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err = tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			tx.Rollback()
+
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "purge %s for account %s failed", tableName, accountID)
+			return err
+		}
+	}
+
+	// Build the delete SQL statement.
+	query := sqlbuilder.NewDeleteBuilder()
+	query.DeleteFrom(accountTableName)
+	query.Where(query.Equal("id", accountID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = tx.ExecContext(ctx, sql, args...)
+	if err != nil {
+		tx.Rollback()
+
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "purge account %s failed", accountID)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Only the fact the account was purged is recorded.
+	_, err = audit.Record(ctx, repo.DbConn, auth.Claims{}, audit.AuditEventRecordRequest{
+		AccountID:  accountID,
+		EntityType: audit.EntityType_Account,
+		EntityID:   accountID,
+		Action:     audit.Action_Purge,
+	}, now)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
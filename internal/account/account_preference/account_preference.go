@@ -5,7 +5,9 @@ import (
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tenant"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 
 	"github.com/huandu/go-sqlbuilder"
@@ -31,6 +33,25 @@ var (
 // The list of columns needed for find
 var accountPreferenceMapColumns = "account_id,name,value,created_at,updated_at,archived_at"
 
+// conn executes fn with the database connection. When row level security is enabled, fn is executed with a
+// transaction scoped to the account and user of the claims.
+func (repo *Repository) conn(ctx context.Context, claims auth.Claims, fn func(conn sqlx.ExtContext) error) error {
+	if !repo.RowLevelSecurity {
+		return fn(repo.DbConn)
+	}
+	return tenant.Do(ctx, repo.DbConn, claims, fn)
+}
+
+// beginTx starts a new transaction. When row level security is enabled, the transaction is scoped to the account and
+// user of the claims.
+func (repo *Repository) beginTx(ctx context.Context, claims auth.Claims) (*sqlx.Tx, error) {
+	if !repo.RowLevelSecurity || !tenant.IsScoped(claims) {
+		tx, err := repo.DbConn.BeginTxx(ctx, nil)
+		return tx, errors.WithStack(err)
+	}
+	return tenant.BeginTx(ctx, repo.DbConn, claims)
+}
+
 // applyClaimsSelect applies a sub-query to the provided query to enforce ACL based on
 // the claims provided.
 // 	1. All role types can access their user ID
@@ -63,11 +84,12 @@ func applyClaimsSelect(ctx context.Context, claims auth.Claims, query *sqlbuilde
 }
 
 // Find gets all the account preferences from the database based on the request params.
-// TODO: Need to figure out why can't parse the args when appending the where to the query.
 func (repo *Repository) Find(ctx context.Context, claims auth.Claims, req AccountPreferenceFindRequest) ([]*AccountPreference, error) {
 	query := sqlbuilder.NewSelectBuilder()
-	if req.Where != "" {
-		query.Where(query.And(req.Where))
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, AccountPreferenceFindFields); err != nil {
+			return nil, err
+		}
 	}
 	if len(req.Order) > 0 {
 		query.OrderBy(req.Order...)
@@ -79,7 +101,7 @@ func (repo *Repository) Find(ctx context.Context, claims auth.Claims, req Accoun
 		query.Offset(int(*req.Offset))
 	}
 
-	return find(ctx, claims, repo.DbConn, query, req.Args, req.IncludeArchived)
+	return repo.find(ctx, claims, query, []interface{}{}, req.IncludeArchived)
 }
 
 // FindByAccountID gets the specified account preferences for an account from the database.
@@ -107,11 +129,22 @@ func (repo *Repository) FindByAccountID(ctx context.Context, claims auth.Claims,
 		query.Offset(int(*req.Offset))
 	}
 
-	return find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
+	return repo.find(ctx, claims, query, []interface{}{}, req.IncludeArchived)
+}
+
+// find executes find with a transaction scoped to the claims when row level security is enabled.
+func (repo *Repository) find(ctx context.Context, claims auth.Claims, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) ([]*AccountPreference, error) {
+	var res []*AccountPreference
+	err := repo.conn(ctx, claims, func(conn sqlx.ExtContext) error {
+		var err error
+		res, err = find(ctx, claims, conn, query, args, includedArchived)
+		return err
+	})
+	return res, err
 }
 
 // find internal method for getting all the account preferences from the database using a select query.
-func find(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) ([]*AccountPreference, error) {
+func find(ctx context.Context, claims auth.Claims, dbConn sqlx.ExtContext, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) ([]*AccountPreference, error) {
 	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account_preference.Find")
 	defer span.Finish()
 
@@ -138,6 +171,7 @@ func find(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB, query *sqlbu
 		err = errors.WithMessage(err, "find account preferences failed")
 		return nil, err
 	}
+	defer rows.Close()
 
 	// iterate over each row
 	resp := []*AccountPreference{}
@@ -174,7 +208,7 @@ func (repo *Repository) Read(ctx context.Context, claims auth.Claims, req Accoun
 		query.Equal("account_id", req.AccountID)),
 		query.Equal("name", req.Name))
 
-	res, err := find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
+	res, err := repo.find(ctx, claims, query, []interface{}{}, req.IncludeArchived)
 	if err != nil {
 		return nil, err
 	} else if res == nil || len(res) == 0 {
@@ -254,6 +288,9 @@ func Validator() *validator.Validate {
 			}
 
 			return true
+
+		case AccountPreference_Mfa_Required, AccountPreference_Email_Verification_Required:
+			return val == "true" || val == "false"
 		}
 
 		return false
@@ -282,6 +319,18 @@ func (repo *Repository) Set(ctx context.Context, claims auth.Claims, req Account
 		return err
 	}
 
+	// Load the current value so the change can be recorded.
+	var before *AccountPreference
+	if cur, err := repo.Read(ctx, auth.Claims{}, AccountPreferenceReadRequest{
+		AccountID:       req.AccountID,
+		Name:            req.Name,
+		IncludeArchived: true,
+	}); err == nil {
+		before = cur
+	} else if errors.Cause(err) != ErrNotFound {
+		return err
+	}
+
 	// If now empty set it to the current time.
 	if now.IsZero() {
 		now = time.Now()
@@ -306,13 +355,32 @@ func (repo *Repository) Set(ctx context.Context, claims auth.Claims, req Account
 
 	sql = sql + " ON CONFLICT ON CONSTRAINT account_preferences_pkey DO UPDATE set value = EXCLUDED.value "
 
-	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	err = repo.conn(ctx, claims, func(conn sqlx.ExtContext) error {
+		_, err := conn.ExecContext(ctx, sql, args...)
+		return err
+	})
 	if err != nil {
 		err = errors.Wrapf(err, "query - %s", query.String())
 		err = errors.WithMessage(err, "set account preference failed")
 		return err
 	}
 
+	action := audit.Action_Update
+	if before == nil {
+		action = audit.Action_Create
+	}
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  req.AccountID,
+		EntityType: audit.EntityType_AccountPreference,
+		EntityID:   string(req.Name),
+		Action:     action,
+		Before:     before,
+		After:      map[string]interface{}{"value": req.Value},
+	}, now)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -357,13 +425,27 @@ func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req Acc
 	// Execute the query with the provided context.
 	sql, args := query.Build()
 	sql = repo.DbConn.Rebind(sql)
-	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	err = repo.conn(ctx, claims, func(conn sqlx.ExtContext) error {
+		_, err := conn.ExecContext(ctx, sql, args...)
+		return err
+	})
 	if err != nil {
 		err = errors.Wrapf(err, "query - %s", query.String())
 		err = errors.WithMessagef(err, "archive account preference %s for account %s failed", req.Name, req.AccountID)
 		return err
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  req.AccountID,
+		EntityType: audit.EntityType_AccountPreference,
+		EntityID:   string(req.Name),
+		Action:     audit.Action_Archive,
+		After:      map[string]interface{}{"archived_at": now},
+	}, now)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -386,9 +468,9 @@ func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req Acco
 	}
 
 	// Start a new transaction to handle rollbacks on error.
-	tx, err := repo.DbConn.Begin()
+	tx, err := repo.beginTx(ctx, claims)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 
 	// Build the delete SQL statement.
@@ -413,6 +495,16 @@ func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req Acco
 		return errors.WithStack(err)
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  req.AccountID,
+		EntityType: audit.EntityType_AccountPreference,
+		EntityID:   string(req.Name),
+		Action:     audit.Action_Delete,
+	}, time.Now())
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
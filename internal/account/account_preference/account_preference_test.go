@@ -9,6 +9,7 @@ import (
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/account"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
 	"github.com/dgrijalva/jwt-go"
@@ -398,14 +399,16 @@ func TestFind(t *testing.T) {
 
 	var prefTests []accountTest
 
-	createdFilter := "created_at BETWEEN ? AND ?"
+	createdFilter := filter.Filter{
+		{Field: "created_at", Operator: filter.Op_Gte, Values: []string{startTime.Format(time.RFC3339Nano)}},
+		{Field: "created_at", Operator: filter.Op_Lte, Values: []string{endTime.Format(time.RFC3339Nano)}},
+	}
 
 	// Test sort accounts.
 	prefTests = append(prefTests, accountTest{"Find all order by created_at asc",
 		AccountPreferenceFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at"},
+			Filter: createdFilter,
+			Order:  []string{"created_at"},
 		},
 		prefs,
 		nil,
@@ -418,9 +421,8 @@ func TestFind(t *testing.T) {
 	}
 	prefTests = append(prefTests, accountTest{"Find all order by created_at desc",
 		AccountPreferenceFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at desc"},
+			Filter: createdFilter,
+			Order:  []string{"created_at desc"},
 		},
 		expected,
 		nil,
@@ -430,10 +432,9 @@ func TestFind(t *testing.T) {
 	var limit uint = 2
 	prefTests = append(prefTests, accountTest{"Find limit",
 		AccountPreferenceFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at"},
-			Limit: &limit,
+			Filter: createdFilter,
+			Order:  []string{"created_at"},
+			Limit:  &limit,
 		},
 		prefs[0:2],
 		nil,
@@ -443,8 +444,7 @@ func TestFind(t *testing.T) {
 	var offset uint = 1
 	prefTests = append(prefTests, accountTest{"Find limit, offset",
 		AccountPreferenceFindRequest{
-			Where:  createdFilter,
-			Args:   []interface{}{startTime, endTime},
+			Filter: createdFilter,
 			Order:  []string{"created_at"},
 			Limit:  &limit,
 			Offset: &offset,
@@ -454,25 +454,23 @@ func TestFind(t *testing.T) {
 	})
 
 	// Test where filter.
-	whereParts := []string{}
-	whereArgs := []interface{}{startTime, endTime}
+	var values []string
 	expected = []*AccountPreference{}
 	for i := 0; i < len(prefs); i++ {
-		if rand.Intn(100) < 50 {
+		// Always include the first one so the filter has a value.
+		if i > 0 && rand.Intn(100) < 50 {
 			continue
 		}
 		u := *prefs[i]
 
-		whereParts = append(whereParts, "name = ?")
-		whereArgs = append(whereArgs, u.Name)
+		values = append(values, string(u.Name))
 		expected = append(expected, &u)
 	}
 
 	prefTests = append(prefTests, accountTest{"Find where",
 		AccountPreferenceFindRequest{
-			Where: createdFilter + " AND (" + strings.Join(whereParts, " OR ") + ")",
-			Args:  whereArgs,
-			Order: []string{"created_at"},
+			Filter: append(createdFilter, filter.Condition{Field: "name", Operator: filter.Op_In, Values: values}),
+			Order:  []string{"created_at"},
 		},
 		expected,
 		nil,
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"database/sql/driver"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
@@ -15,6 +16,10 @@ import (
 // Repository defines the required dependencies for AccountPreference.
 type Repository struct {
 	DbConn *sqlx.DB
+
+	// RowLevelSecurity scopes the queries to the account and user of the claims with a transaction so the row level
+	// security policies are enforced in addition to the ACL applied to the queries.
+	RowLevelSecurity bool
 }
 
 // NewRepository creates a new Repository that defines dependencies for AccountPreference.
@@ -27,7 +32,7 @@ func NewRepository(db *sqlx.DB) *Repository {
 // AccountPreference represents an account setting.
 type AccountPreference struct {
 	AccountID  string                `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Name       AccountPreferenceName `json:"name" validate:"required,oneof=datetime_format date_format time_format" swaggertype:"string" enums:"datetime_format,date_format,time_format" example:"datetime_format"`
+	Name       AccountPreferenceName `json:"name" validate:"required,oneof=datetime_format date_format time_format mfa_required email_verification_required" swaggertype:"string" enums:"datetime_format,date_format,time_format,mfa_required,email_verification_required" example:"datetime_format"`
 	Value      string                `json:"value" validate:"required,preference_value" example:"2006-01-02 at 3:04PM MST"`
 	CreatedAt  time.Time             `json:"created_at"`
 	UpdatedAt  time.Time             `json:"updated_at"`
@@ -70,14 +75,14 @@ func (m *AccountPreference) Response(ctx context.Context) *AccountPreferenceResp
 // AccountPreferenceReadRequest contains information needed to read an Account Preference.
 type AccountPreferenceReadRequest struct {
 	AccountID       string                `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Name            AccountPreferenceName `json:"name" validate:"required,oneof=datetime_format date_format time_format" swaggertype:"string" enums:"datetime_format,date_format,time_format" example:"datetime_format"`
+	Name            AccountPreferenceName `json:"name" validate:"required,oneof=datetime_format date_format time_format mfa_required email_verification_required" swaggertype:"string" enums:"datetime_format,date_format,time_format,mfa_required,email_verification_required" example:"datetime_format"`
 	IncludeArchived bool                  `json:"include-archived" example:"false"`
 }
 
 // AccountPreferenceSetRequest contains information needed to create a new Account Preference.
 type AccountPreferenceSetRequest struct {
 	AccountID string                `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Name      AccountPreferenceName `json:"name" validate:"required,oneof=datetime_format date_format time_format" swaggertype:"string" enums:"datetime_format,date_format,time_format" example:"datetime_format"`
+	Name      AccountPreferenceName `json:"name" validate:"required,oneof=datetime_format date_format time_format mfa_required email_verification_required" swaggertype:"string" enums:"datetime_format,date_format,time_format,mfa_required,email_verification_required" example:"datetime_format"`
 	Value     string                `json:"value" validate:"required,preference_value" example:"2006-01-02 at 3:04PM MST"`
 }
 
@@ -85,26 +90,36 @@ type AccountPreferenceSetRequest struct {
 // This will archive (soft-delete) the existing database entry.
 type AccountPreferenceArchiveRequest struct {
 	AccountID string                `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Name      AccountPreferenceName `json:"name" validate:"required,oneof=datetime_format date_format time_format" swaggertype:"string" enums:"datetime_format,date_format,time_format" example:"datetime_format"`
+	Name      AccountPreferenceName `json:"name" validate:"required,oneof=datetime_format date_format time_format mfa_required email_verification_required" swaggertype:"string" enums:"datetime_format,date_format,time_format,mfa_required,email_verification_required" example:"datetime_format"`
 }
 
 // AccountPreferenceDeleteRequest defines the information needed to delete an account preference.
 type AccountPreferenceDeleteRequest struct {
 	AccountID string                `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Name      AccountPreferenceName `json:"name" validate:"required,oneof=datetime_format date_format time_format" swaggertype:"string" enums:"datetime_format,date_format,time_format" example:"datetime_format"`
+	Name      AccountPreferenceName `json:"name" validate:"required,oneof=datetime_format date_format time_format mfa_required email_verification_required" swaggertype:"string" enums:"datetime_format,date_format,time_format,mfa_required,email_verification_required" example:"datetime_format"`
 }
 
 // AccountPreferenceFindRequest defines the possible options to search for accounts. By default
 // archived accounts will be excluded from response.
 type AccountPreferenceFindRequest struct {
-	Where           string        `json:"where" example:"name = ?"`
-	Args            []interface{} `json:"args" swaggertype:"array,string" example:"Company Name,active"`
+	Filter          filter.Filter `json:"filter,omitempty"`
 	Order           []string      `json:"order" example:"created_at desc"`
 	Limit           *uint         `json:"limit" example:"10"`
 	Offset          *uint         `json:"offset" example:"20"`
 	IncludeArchived bool          `json:"include-archived" example:"false"`
 }
 
+// AccountPreferenceFindFields defines the fields of account preference that can be used to filter and sort a find
+// request.
+var AccountPreferenceFindFields = filter.Fields{
+	{Name: "account_id", Type: filter.FieldType_UUID, Filterable: true, Sortable: true, Key: true},
+	{Name: "name", Type: filter.FieldType_Enum, Values: filter.EnumValues(AccountPreferenceName_ValuesInterface()), Filterable: true, Sortable: true, Key: true},
+	{Name: "value", Type: filter.FieldType_String, Filterable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "updated_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "archived_at", Type: filter.FieldType_Time, Filterable: true, Nullable: true},
+}
+
 // AccountPreferenceFindByAccountIDRequest defines the possible options to search for accounts. By default
 // archived account preferences will be excluded from response.
 type AccountPreferenceFindByAccountIDRequest struct {
@@ -128,11 +143,29 @@ var (
 	AccountPreference_Time_Format_Default                           = "3:04PM MST"
 )
 
+// Account Preference Multi-Factor Authentication
+var (
+	// AccountPreference_Mfa_Required when set to true requires all users of the account
+	// to use two-factor authentication when logging in.
+	AccountPreference_Mfa_Required         AccountPreferenceName = "mfa_required"
+	AccountPreference_Mfa_Required_Default                       = "false"
+)
+
+// Account Preference Email Verification
+var (
+	// AccountPreference_Email_Verification_Required when set to true blocks users of the account
+	// from logging in until they have verified their email address.
+	AccountPreference_Email_Verification_Required         AccountPreferenceName = "email_verification_required"
+	AccountPreference_Email_Verification_Required_Default                       = "false"
+)
+
 // AccountPreferenceName_Values provides list of valid AccountPreferenceName values.
 var AccountPreferenceName_Values = []AccountPreferenceName{
 	AccountPreference_Datetime_Format,
 	AccountPreference_Date_Format,
 	AccountPreference_Time_Format,
+	AccountPreference_Mfa_Required,
+	AccountPreference_Email_Verification_Required,
 }
 
 // AccountPreferenceName_ValuesInterface returns the AccountPreferenceName options as a slice interface.
@@ -158,7 +191,7 @@ func (s *AccountPreferenceName) Scan(value interface{}) error {
 func (s AccountPreferenceName) Value() (driver.Value, error) {
 	v := validator.New()
 
-	errs := v.Var(s, "required,oneof=datetime_format date_format time_format")
+	errs := v.Var(s, "required,oneof=datetime_format date_format time_format mfa_required email_verification_required")
 	if errs != nil {
 		return nil, errs
 	}
@@ -0,0 +1,729 @@
+package account_role
+
+import (
+	"context"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// The database table for AccountRole
+	accountRoleTableName = "account_roles"
+	// The database table for User Account
+	userAccountTableName = "users_accounts"
+)
+
+var (
+	// ErrNotFound abstracts the postgres not found error.
+	ErrNotFound = errors.New("Entity not found")
+
+	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrReservedName occurs when a custom role uses the name of a built-in role.
+	ErrReservedName = errors.New("Role name is reserved")
+
+	// ErrInvalidRole occurs when a role is assigned that is not a built-in role or defined for the account.
+	ErrInvalidRole = errors.New("Invalid role")
+)
+
+// accountRoleMapColumns is the list of columns needed for find.
+var accountRoleMapColumns = "id,account_id,name,description,permissions,created_at,updated_at,archived_at"
+
+// CanModifyAccountRoles determines if claims has the authority to manage the roles for the specified account ID.
+func CanModifyAccountRoles(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB, accountID string) error {
+	err := account.CanReadAccount(ctx, claims, dbConn, accountID)
+	if err != nil {
+		if errors.Cause(err) == account.ErrForbidden {
+			err = errors.WithStack(ErrForbidden)
+		}
+		return err
+	}
+
+	// Permissions are only granted for the account the claims were issued for.
+	if claims.Audience != "" {
+		if claims.Audience != accountID || !claims.HasPermission(auth.PermissionRoleWrite) {
+			return errors.WithStack(ErrForbidden)
+		}
+	}
+
+	return nil
+}
+
+// canGrantPermissions ensures the claims can only grant permissions they have themselves so a user with the
+// role:write permission can't create a role with permissions such as user:impersonate and assign it to themselves.
+func canGrantPermissions(claims auth.Claims, perms []string) error {
+	// Internal requests can grant any permission.
+	if claims.Audience == "" {
+		return nil
+	}
+
+	for _, p := range perms {
+		if !claims.HasPermission(p) {
+			return errors.WithMessagef(ErrForbidden, "permission %s can not be granted", p)
+		}
+	}
+
+	return nil
+}
+
+// applyClaimsSelect applies a sub-query to the provided query to enforce ACL based on the claims provided.
+// 	1. No claims, request is internal, no ACL applied
+// 	2. All role types can access the roles for their account ID
+func applyClaimsSelect(ctx context.Context, claims auth.Claims, query *sqlbuilder.SelectBuilder) error {
+	// Claims are empty, don't apply any ACL
+	if claims.Audience == "" {
+		return nil
+	}
+
+	query.Where(query.Equal("account_id", claims.Audience))
+	return nil
+}
+
+// selectQuery constructs a base select query for AccountRole.
+func selectQuery() *sqlbuilder.SelectBuilder {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Select(accountRoleMapColumns)
+	query.From(accountRoleTableName)
+	return query
+}
+
+// findRequestQuery generates the select query for the given find request.
+func findRequestQuery(req AccountRoleFindRequest) (*sqlbuilder.SelectBuilder, error) {
+	query := selectQuery()
+
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, AccountRoleFindFields); err != nil {
+			return nil, err
+		}
+	}
+	if req.Cursor != "" {
+		if err := AccountRoleFindFields.ApplyCursor(query, req.Order, req.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(req.Order) > 0 {
+		query.OrderBy(req.Order...)
+	}
+
+	if req.Limit != nil {
+		query.Limit(int(*req.Limit))
+	}
+
+	if req.Offset != nil {
+		query.Offset(int(*req.Offset))
+	}
+
+	return query, nil
+}
+
+// Find gets all the account roles from the database based on the request params.
+func (repo *Repository) Find(ctx context.Context, claims auth.Claims, req AccountRoleFindRequest) (AccountRoles, error) {
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
+}
+
+// Count gets the total number of account roles from the database that match the request params. The order, limit,
+// offset and cursor of the request are ignored.
+func (repo *Repository) Count(ctx context.Context, claims auth.Claims, req AccountRoleFindRequest) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account_role.Count")
+	defer span.Finish()
+
+	req.Order, req.Limit, req.Offset, req.Cursor = nil, nil, nil, ""
+
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return 0, err
+	}
+	query.Select("count(*)")
+
+	if !req.IncludeArchived {
+		query.Where(query.IsNull("archived_at"))
+	}
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err = applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return 0, err
+	}
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var total int
+	err = repo.DbConn.QueryRowContext(ctx, queryStr, args...).Scan(&total)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "count account roles failed")
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// FindByAccountID gets the roles defined for the specified account ID ordered by name.
+func (repo *Repository) FindByAccountID(ctx context.Context, claims auth.Claims, accountID string) (AccountRoles, error) {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Where(query.Equal("account_id", accountID))
+	query.OrderBy("name")
+
+	return find(ctx, claims, repo.DbConn, query, []interface{}{}, false)
+}
+
+// find internal method for getting all the account roles from the database using a select query.
+func find(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) (AccountRoles, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account_role.Find")
+	defer span.Finish()
+
+	query.Select(accountRoleMapColumns)
+	query.From(accountRoleTableName)
+	if !includedArchived {
+		query.Where(query.IsNull("archived_at"))
+	}
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err := applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return nil, err
+	}
+
+	queryStr, queryArgs := query.Build()
+	queryStr = dbConn.Rebind(queryStr)
+	args = append(args, queryArgs...)
+
+	// Fetch all entries from the db.
+	rows, err := dbConn.QueryContext(ctx, queryStr, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find account roles failed")
+		return nil, err
+	}
+
+	// Iterate over each row.
+	resp := []*AccountRole{}
+	for rows.Next() {
+		var m AccountRole
+		err = rows.Scan(&m.ID, &m.AccountID, &m.Name, &m.Description, &m.Permissions, &m.CreatedAt, &m.UpdatedAt, &m.ArchivedAt)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+
+		resp = append(resp, &m)
+	}
+
+	return resp, nil
+}
+
+// ReadByID gets the specified account role by ID from the database.
+func (repo *Repository) ReadByID(ctx context.Context, claims auth.Claims, id string) (*AccountRole, error) {
+	return repo.Read(ctx, claims, AccountRoleReadRequest{
+		ID:              id,
+		IncludeArchived: false,
+	})
+}
+
+// Read gets the specified account role from the database.
+func (repo *Repository) Read(ctx context.Context, claims auth.Claims, req AccountRoleReadRequest) (*AccountRole, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account_role.Read")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter base select query by id
+	query := sqlbuilder.NewSelectBuilder()
+	query.Where(query.Equal("id", req.ID))
+
+	res, err := find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
+	if err != nil {
+		return nil, err
+	} else if res == nil || len(res) == 0 {
+		err = errors.WithMessagef(ErrNotFound, "account role %s not found", req.ID)
+		return nil, err
+	}
+
+	u := res[0]
+	return u, nil
+}
+
+// Create inserts a new account role into the database.
+func (repo *Repository) Create(ctx context.Context, claims auth.Claims, req AccountRoleCreateRequest, now time.Time) (*AccountRole, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account_role.Create")
+	defer span.Finish()
+
+	if claims.Audience != "" && req.AccountID == "" {
+		// Set the accountId from claims.
+		req.AccountID = claims.Audience
+	}
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the claims can manage the roles for the account specified in the request.
+	err = CanModifyAccountRoles(ctx, claims, repo.DbConn, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = canGrantPermissions(claims, req.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth.IsBuiltInRole(req.Name) {
+		return nil, errors.WithMessagef(ErrReservedName, "role %s is a built-in role", req.Name)
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	m := AccountRole{
+		ID:          uuid.NewRandom().String(),
+		AccountID:   req.AccountID,
+		Name:        req.Name,
+		Description: req.Description,
+		Permissions: pq.StringArray(req.Permissions),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	// Build the insert SQL statement.
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(accountRoleTableName)
+	query.Cols(
+		"id",
+		"account_id",
+		"name",
+		"description",
+		"permissions",
+		"created_at",
+		"updated_at",
+		"archived_at",
+	)
+
+	query.Values(
+		m.ID,
+		m.AccountID,
+		m.Name,
+		m.Description,
+		m.Permissions,
+		m.CreatedAt,
+		m.UpdatedAt,
+		m.ArchivedAt,
+	)
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "create account role failed")
+		return nil, err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  m.AccountID,
+		EntityType: audit.EntityType_AccountRole,
+		EntityID:   m.ID,
+		Action:     audit.Action_Create,
+		After:      m,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Update replaces an account role in the database.
+func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req AccountRoleUpdateRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account_role.Update")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	role, err := repo.ReadByID(ctx, claims, req.ID)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can manage the roles for the account.
+	err = CanModifyAccountRoles(ctx, claims, repo.DbConn, role.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if req.Permissions != nil {
+		err = canGrantPermissions(claims, *req.Permissions)
+		if err != nil {
+			return err
+		}
+	}
+
+	if req.Name != nil && auth.IsBuiltInRole(*req.Name) {
+		return errors.WithMessagef(ErrReservedName, "role %s is a built-in role", *req.Name)
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(accountRoleTableName)
+
+	var fields []string
+	if req.Name != nil {
+		fields = append(fields, query.Assign("name", *req.Name))
+	}
+	if req.Description != nil {
+		fields = append(fields, query.Assign("description", *req.Description))
+	}
+	if req.Permissions != nil {
+		fields = append(fields, query.Assign("permissions", pq.StringArray(*req.Permissions)))
+	}
+
+	// If there's nothing to update we can quit early.
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// Append the updated_at field
+	fields = append(fields, query.Assign("updated_at", now))
+
+	query.Set(fields...)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "update account role %s failed", req.ID)
+		return err
+	}
+
+	// Keep the users assigned to the role when it's renamed.
+	if req.Name != nil && *req.Name != role.Name {
+		err = repo.replaceUserAccountRole(ctx, role.AccountID, role.Name, *req.Name, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  role.AccountID,
+		EntityType: audit.EntityType_AccountRole,
+		EntityID:   req.ID,
+		Action:     audit.Action_Update,
+		Before:     role,
+		After:      req,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Archive soft deleted the account role from the database.
+func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req AccountRoleArchiveRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account_role.Archive")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	role, err := repo.ReadByID(ctx, claims, req.ID)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can manage the roles for the account.
+	err = CanModifyAccountRoles(ctx, claims, repo.DbConn, role.AccountID)
+	if err != nil {
+		return err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(accountRoleTableName)
+	query.Set(
+		query.Assign("archived_at", now),
+	)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "archive account role %s failed", req.ID)
+		return err
+	}
+
+	// Archived roles no longer grant any permissions, remove them from users.
+	err = repo.replaceUserAccountRole(ctx, role.AccountID, role.Name, "", now)
+	if err != nil {
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  role.AccountID,
+		EntityType: audit.EntityType_AccountRole,
+		EntityID:   req.ID,
+		Action:     audit.Action_Archive,
+		Before:     role,
+		After:      map[string]interface{}{"archived_at": now},
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes an account role from the database.
+func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req AccountRoleDeleteRequest) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account_role.Delete")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	role, err := repo.Read(ctx, claims, AccountRoleReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can manage the roles for the account.
+	err = CanModifyAccountRoles(ctx, claims, repo.DbConn, role.AccountID)
+	if err != nil {
+		return err
+	}
+
+	// Build the delete SQL statement.
+	query := sqlbuilder.NewDeleteBuilder()
+	query.DeleteFrom(accountRoleTableName)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "delete account role %s failed", req.ID)
+		return err
+	}
+
+	err = repo.replaceUserAccountRole(ctx, role.AccountID, role.Name, "", time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  role.AccountID,
+		EntityType: audit.EntityType_AccountRole,
+		EntityID:   req.ID,
+		Action:     audit.Action_Delete,
+		Before:     role,
+	}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// replaceUserAccountRole renames the role for all the users of the account. When the new name is empty,
+// the role is removed instead.
+func (repo *Repository) replaceUserAccountRole(ctx context.Context, accountID, oldName, newName string, now time.Time) error {
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(userAccountTableName)
+	if newName != "" {
+		query.Set(
+			"roles = array_replace(roles, "+query.Var(oldName)+", "+query.Var(newName)+")",
+			query.Assign("updated_at", now.UTC()),
+		)
+	} else {
+		query.Set(
+			"roles = array_remove(roles, "+query.Var(oldName)+")",
+			query.Assign("updated_at", now.UTC()),
+		)
+	}
+	query.Where(query.And(
+		query.Equal("account_id", accountID),
+		query.Var(oldName)+" = ANY (roles)",
+	))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "update roles for account %s failed", accountID)
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRoles ensures each of the roles is either a built-in role or a custom role defined for the account.
+func ValidateRoles(ctx context.Context, dbConn *sqlx.DB, accountID string, roles []string) error {
+	_, err := resolve(ctx, dbConn, accountID, roles)
+	return err
+}
+
+// ResolvePermissions returns the permissions granted by the roles for the account. Roles are either built-in
+// roles that are available to every account or custom roles defined for the account.
+func ResolvePermissions(ctx context.Context, dbConn *sqlx.DB, accountID string, roles []string) ([]string, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.account_role.ResolvePermissions")
+	defer span.Finish()
+
+	granted, err := resolve(ctx, dbConn, accountID, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the permissions in the order they are defined so the result is consistent.
+	var perms []string
+	for _, p := range auth.Permissions {
+		if granted[p] {
+			perms = append(perms, p)
+		}
+	}
+
+	return perms, nil
+}
+
+// resolve looks up the permissions for each role and returns an error if a role is not defined.
+func resolve(ctx context.Context, dbConn *sqlx.DB, accountID string, roles []string) (map[string]bool, error) {
+	granted := make(map[string]bool)
+
+	var custom []interface{}
+	for _, r := range roles {
+		if perms, ok := auth.RolePermissions[r]; ok {
+			for _, p := range perms {
+				granted[p] = true
+			}
+		} else {
+			custom = append(custom, r)
+		}
+	}
+
+	if len(custom) == 0 {
+		return granted, nil
+	}
+
+	query := sqlbuilder.NewSelectBuilder().Select("name,permissions").From(accountRoleTableName)
+	query.Where(query.And(
+		query.Equal("account_id", accountID),
+		query.In("name", custom...),
+		query.IsNull("archived_at"),
+	))
+
+	queryStr, args := query.Build()
+	queryStr = dbConn.Rebind(queryStr)
+	rows, err := dbConn.QueryContext(ctx, queryStr, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool)
+	for rows.Next() {
+		var (
+			name  string
+			perms pq.StringArray
+		)
+		if err := rows.Scan(&name, &perms); err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+
+		found[name] = true
+		for _, p := range perms {
+			granted[p] = true
+		}
+	}
+
+	for _, r := range custom {
+		if !found[r.(string)] {
+			return nil, errors.WithMessagef(ErrInvalidRole, "role %s is not defined for account %s", r, accountID)
+		}
+	}
+
+	return granted, nil
+}
@@ -0,0 +1,367 @@
+package account_role
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	test *tests.Test
+	repo *Repository
+)
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+
+	repo = NewRepository(test.MasterDB)
+
+	return m.Run()
+}
+
+// TestCrud validates the full set of CRUD operations for account roles and ensures ACLs are correctly applied
+// by claims.
+func TestCrud(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	type roleTest struct {
+		name     string
+		claims   func(string) auth.Claims
+		writeErr error
+		findErr  error
+	}
+
+	var roleTests []roleTest
+
+	// Internal request, should bypass ACL.
+	roleTests = append(roleTests, roleTest{"EmptyClaims",
+		func(accountID string) auth.Claims {
+			return auth.Claims{}
+		},
+		nil,
+		nil,
+	})
+
+	// Role of user for the account, does not have the role:write permission so forbidden.
+	roleTests = append(roleTests, roleTest{"RoleUserSameAccount",
+		func(accountID string) auth.Claims {
+			return auth.Claims{
+				Roles: []string{auth.RoleUser},
+				StandardClaims: jwt.StandardClaims{
+					Audience: accountID,
+					Subject:  uuid.NewRandom().String(),
+				},
+			}
+		},
+		ErrForbidden,
+		nil,
+	})
+
+	// Custom role with the role:write permission and the permissions granted by the role for the account so OK.
+	roleTests = append(roleTests, roleTest{"PermissionRoleWriteSameAccount",
+		func(accountID string) auth.Claims {
+			return auth.Claims{
+				Roles:       []string{"manager"},
+				Permissions: []string{auth.PermissionRoleWrite, auth.PermissionAccountRead, auth.PermissionAccountWrite},
+				StandardClaims: jwt.StandardClaims{
+					Audience: accountID,
+					Subject:  uuid.NewRandom().String(),
+				},
+			}
+		},
+		nil,
+		nil,
+	})
+
+	// Role of admin for the account so OK.
+	roleTests = append(roleTests, roleTest{"RoleAdminSameAccount",
+		func(accountID string) auth.Claims {
+			return auth.Claims{
+				Roles: []string{auth.RoleAdmin},
+				StandardClaims: jwt.StandardClaims{
+					Audience: accountID,
+					Subject:  uuid.NewRandom().String(),
+				},
+			}
+		},
+		nil,
+		nil,
+	})
+
+	// Role of admin but claim account does not match the account so forbidden.
+	roleTests = append(roleTests, roleTest{"RoleAdminDiffAccount",
+		func(accountID string) auth.Claims {
+			return auth.Claims{
+				Roles: []string{auth.RoleAdmin},
+				StandardClaims: jwt.StandardClaims{
+					Audience: uuid.NewRandom().String(),
+					Subject:  uuid.NewRandom().String(),
+				},
+			}
+		},
+		ErrForbidden,
+		ErrNotFound,
+	})
+
+	t.Log("Given the need to ensure claims are applied as ACL for create account role.")
+	{
+		for i, tt := range roleTests {
+			t.Logf("\tTest: %d\tWhen running test: %s", i, tt.name)
+			{
+				ctx := tests.Context()
+
+				acc, err := account.MockAccount(ctx, test.MasterDB, now)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tCreate account failed.", tests.Failed)
+				}
+
+				req := AccountRoleCreateRequest{
+					AccountID:   acc.ID,
+					Name:        "billing",
+					Description: "Manage billing for the account.",
+					Permissions: []string{auth.PermissionAccountRead, auth.PermissionAccountWrite},
+				}
+
+				// Create a new role for the account. When the claims do not have the required
+				// permissions, create the role with empty claims so read and find can be tested.
+				role, err := repo.Create(ctx, tt.claims(acc.ID), req, now)
+				if err != nil && errors.Cause(err) != tt.writeErr {
+					t.Logf("\t\tGot : %+v", err)
+					t.Logf("\t\tWant: %+v", tt.writeErr)
+					t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+				} else if tt.writeErr != nil {
+					role, err = repo.Create(ctx, auth.Claims{}, req, now)
+					if err != nil {
+						t.Log("\t\tGot :", err)
+						t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+					}
+				}
+				t.Logf("\t%s\tCreate ok.", tests.Success)
+
+				// Read the role with the claims.
+				readRes, err := repo.ReadByID(ctx, tt.claims(acc.ID), role.ID)
+				if err != nil && errors.Cause(err) != tt.findErr {
+					t.Logf("\t\tGot : %+v", err)
+					t.Logf("\t\tWant: %+v", tt.findErr)
+					t.Fatalf("\t%s\tRead failed.", tests.Failed)
+				} else if tt.findErr == nil {
+					if diff := cmp.Diff(readRes, role); diff != "" {
+						t.Fatalf("\t%s\tExpected read result to match create. Diff:\n%s", tests.Failed, diff)
+					}
+				}
+				t.Logf("\t%s\tRead ok.", tests.Success)
+
+				// Update the role with the claims.
+				newName := "finance"
+				err = repo.Update(ctx, tt.claims(acc.ID), AccountRoleUpdateRequest{
+					ID:   role.ID,
+					Name: &newName,
+				}, now)
+				if err != nil && errors.Cause(err) != tt.writeErr && errors.Cause(err) != tt.findErr {
+					t.Logf("\t\tGot : %+v", err)
+					t.Logf("\t\tWant: %+v", tt.writeErr)
+					t.Fatalf("\t%s\tUpdate failed.", tests.Failed)
+				}
+				t.Logf("\t%s\tUpdate ok.", tests.Success)
+
+				// Archive (soft-delete) the role with the claims.
+				err = repo.Archive(ctx, tt.claims(acc.ID), AccountRoleArchiveRequest{ID: role.ID}, now)
+				if err != nil && errors.Cause(err) != tt.writeErr && errors.Cause(err) != tt.findErr {
+					t.Logf("\t\tGot : %+v", err)
+					t.Logf("\t\tWant: %+v", tt.writeErr)
+					t.Fatalf("\t%s\tArchive failed.", tests.Failed)
+				} else if err == nil {
+					// Trying to find the archived role with the includeArchived false should result in not found.
+					_, err = repo.ReadByID(ctx, auth.Claims{}, role.ID)
+					if errors.Cause(err) != ErrNotFound {
+						t.Logf("\t\tGot : %+v", err)
+						t.Logf("\t\tWant: %+v", ErrNotFound)
+						t.Fatalf("\t%s\tArchive Read failed.", tests.Failed)
+					}
+				}
+				t.Logf("\t%s\tArchive ok.", tests.Success)
+
+				// Delete the role with the claims.
+				err = repo.Delete(ctx, tt.claims(acc.ID), AccountRoleDeleteRequest{ID: role.ID})
+				if err != nil && errors.Cause(err) != tt.writeErr && errors.Cause(err) != tt.findErr {
+					t.Logf("\t\tGot : %+v", err)
+					t.Logf("\t\tWant: %+v", tt.writeErr)
+					t.Fatalf("\t%s\tDelete failed.", tests.Failed)
+				}
+				t.Logf("\t%s\tDelete ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestReservedName ensures custom roles can not use the name of a built-in role.
+func TestReservedName(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Given the need to ensure built-in roles can not be redefined.")
+	{
+		ctx := tests.Context()
+
+		acc, err := account.MockAccount(ctx, test.MasterDB, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate account failed.", tests.Failed)
+		}
+
+		_, err = repo.Create(ctx, auth.Claims{}, AccountRoleCreateRequest{
+			AccountID:   acc.ID,
+			Name:        auth.RoleAdmin,
+			Permissions: []string{auth.PermissionAccountRead},
+		}, now)
+		if errors.Cause(err) != ErrReservedName {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrReservedName)
+			t.Fatalf("\t%s\tCreate with reserved name failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate with reserved name ok.", tests.Success)
+	}
+}
+
+// TestGrantPermissions ensures roles can only be granted the permissions of the claims managing the roles.
+func TestGrantPermissions(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Given the need to ensure permissions can not be escalated with custom roles.")
+	{
+		ctx := tests.Context()
+
+		acc, err := account.MockAccount(ctx, test.MasterDB, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate account failed.", tests.Failed)
+		}
+
+		claims := auth.Claims{
+			Roles:       []string{"manager"},
+			Permissions: []string{auth.PermissionRoleWrite, auth.PermissionAccountRead},
+			StandardClaims: jwt.StandardClaims{
+				Audience: acc.ID,
+				Subject:  uuid.NewRandom().String(),
+			},
+		}
+
+		// Create a role with a permission the claims don't have.
+		_, err = repo.Create(ctx, claims, AccountRoleCreateRequest{
+			AccountID:   acc.ID,
+			Name:        "impersonator",
+			Permissions: []string{auth.PermissionAccountRead, auth.PermissionUserImpersonate},
+		}, now)
+		if errors.Cause(err) != ErrForbidden {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrForbidden)
+			t.Fatalf("\t%s\tCreate with permission not granted to claims failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate with permission not granted to claims ok.", tests.Success)
+
+		// Create a role with the permissions of the claims.
+		role, err := repo.Create(ctx, claims, AccountRoleCreateRequest{
+			AccountID:   acc.ID,
+			Name:        "reader",
+			Permissions: []string{auth.PermissionAccountRead},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate ok.", tests.Success)
+
+		// Update the role with a permission the claims don't have.
+		perms := []string{auth.PermissionAccountRead, auth.PermissionUserImpersonate}
+		err = repo.Update(ctx, claims, AccountRoleUpdateRequest{
+			ID:          role.ID,
+			Permissions: &perms,
+		}, now)
+		if errors.Cause(err) != ErrForbidden {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrForbidden)
+			t.Fatalf("\t%s\tUpdate with permission not granted to claims failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tUpdate with permission not granted to claims ok.", tests.Success)
+	}
+}
+
+// TestResolvePermissions ensures the permissions for built-in and custom roles are resolved.
+func TestResolvePermissions(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Given the need to resolve the permissions granted by roles.")
+	{
+		ctx := tests.Context()
+
+		acc, err := account.MockAccount(ctx, test.MasterDB, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate account failed.", tests.Failed)
+		}
+
+		_, err = repo.Create(ctx, auth.Claims{}, AccountRoleCreateRequest{
+			AccountID:   acc.ID,
+			Name:        "billing",
+			Permissions: []string{auth.PermissionAccountWrite},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+		}
+
+		perms, err := ResolvePermissions(ctx, test.MasterDB, acc.ID, []string{auth.RoleUser, "billing"})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tResolvePermissions failed.", tests.Failed)
+		}
+
+		expected := []string{
+			auth.PermissionAccountRead,
+			auth.PermissionAccountWrite,
+			auth.PermissionProjectRead,
+			auth.PermissionUserRead,
+		}
+		if diff := cmp.Diff(perms, expected); diff != "" {
+			t.Fatalf("\t%s\tExpected permissions to match. Diff:\n%s", tests.Failed, diff)
+		}
+		t.Logf("\t%s\tResolvePermissions ok.", tests.Success)
+
+		// Roles from another account are not valid.
+		other, err := account.MockAccount(ctx, test.MasterDB, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate account failed.", tests.Failed)
+		}
+
+		err = ValidateRoles(ctx, test.MasterDB, other.ID, []string{"billing"})
+		if errors.Cause(err) != ErrInvalidRole {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidRole)
+			t.Fatalf("\t%s\tValidateRoles failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tValidateRoles ok.", tests.Success)
+	}
+}
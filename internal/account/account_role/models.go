@@ -0,0 +1,160 @@
+package account_role
+
+import (
+	"context"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Repository defines the required dependencies for AccountRole.
+type Repository struct {
+	DbConn *sqlx.DB
+}
+
+// NewRepository creates a new Repository that defines dependencies for AccountRole.
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		DbConn: db,
+	}
+}
+
+// AccountRole defines a custom role for an account that grants a set of permissions. Users are assigned roles
+// by name for each account they have access to, see user_account.
+type AccountRole struct {
+	ID          string         `json:"id" validate:"required,uuid" example:"8b2e4c8e-2b8f-4d77-a3c2-5a3c1c3f2f6e"`
+	AccountID   string         `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	Name        string         `json:"name" validate:"required,role" example:"billing"`
+	Description string         `json:"description" validate:"omitempty,max=255" example:"Manage billing for the account."`
+	Permissions pq.StringArray `json:"permissions" validate:"required,dive,permission" swaggertype:"array,string" example:"account:read,account:write"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	ArchivedAt  *pq.NullTime   `json:"archived_at,omitempty"`
+}
+
+// AccountRoleResponse defines a custom role for an account that is returned for display.
+type AccountRoleResponse struct {
+	ID          string                `json:"id" example:"8b2e4c8e-2b8f-4d77-a3c2-5a3c1c3f2f6e"`
+	AccountID   string                `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	Name        string                `json:"name" example:"billing"`
+	Description string                `json:"description" example:"Manage billing for the account."`
+	Permissions web.EnumMultiResponse `json:"permissions" swaggertype:"array,string" example:"account:read,account:write"`
+	CreatedAt   web.TimeResponse      `json:"created_at"`            // CreatedAt contains multiple format options for display.
+	UpdatedAt   web.TimeResponse      `json:"updated_at"`            // UpdatedAt contains multiple format options for display.
+	ArchivedAt  *web.TimeResponse     `json:"archived_at,omitempty"` // ArchivedAt contains multiple format options for display.
+}
+
+// Response transforms AccountRole and AccountRoleResponse that is used for display.
+// Additional filtering by context values or translations could be applied.
+func (m *AccountRole) Response(ctx context.Context) *AccountRoleResponse {
+	if m == nil {
+		return nil
+	}
+
+	r := &AccountRoleResponse{
+		ID:          m.ID,
+		AccountID:   m.AccountID,
+		Name:        m.Name,
+		Description: m.Description,
+		CreatedAt:   web.NewTimeResponse(ctx, m.CreatedAt),
+		UpdatedAt:   web.NewTimeResponse(ctx, m.UpdatedAt),
+	}
+
+	var selectedPerms []interface{}
+	for _, p := range m.Permissions {
+		selectedPerms = append(selectedPerms, p)
+	}
+	r.Permissions = web.NewEnumMultiResponse(ctx, selectedPerms, Permission_ValuesInterface()...)
+
+	if m.ArchivedAt != nil && !m.ArchivedAt.Time.IsZero() {
+		at := web.NewTimeResponse(ctx, m.ArchivedAt.Time)
+		r.ArchivedAt = &at
+	}
+
+	return r
+}
+
+// AccountRoles a list of AccountRoles.
+type AccountRoles []*AccountRole
+
+// Response transforms a list of AccountRoles to a list of AccountRoleResponses.
+func (m *AccountRoles) Response(ctx context.Context) []*AccountRoleResponse {
+	var l []*AccountRoleResponse
+	if m != nil && len(*m) > 0 {
+		for _, n := range *m {
+			l = append(l, n.Response(ctx))
+		}
+	}
+
+	return l
+}
+
+// AccountRoleCreateRequest contains information needed to create a new AccountRole.
+type AccountRoleCreateRequest struct {
+	AccountID   string   `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	Name        string   `json:"name" validate:"required,role" example:"billing"`
+	Description string   `json:"description" validate:"omitempty,max=255" example:"Manage billing for the account."`
+	Permissions []string `json:"permissions" validate:"required,dive,permission" swaggertype:"array,string" example:"account:read,account:write"`
+}
+
+// AccountRoleReadRequest defines the information needed to read an account role.
+type AccountRoleReadRequest struct {
+	ID              string `json:"id" validate:"required,uuid" example:"8b2e4c8e-2b8f-4d77-a3c2-5a3c1c3f2f6e"`
+	IncludeArchived bool   `json:"include-archived" example:"false"`
+}
+
+// AccountRoleUpdateRequest defines what information may be provided to modify an existing
+// AccountRole. All fields are optional so clients can send just the fields they want
+// changed. It uses pointer fields so we can differentiate between a field that
+// was not provided and a field that was provided as explicitly blank. Renaming a role
+// updates the users assigned to it.
+type AccountRoleUpdateRequest struct {
+	ID          string    `json:"id" validate:"required,uuid" example:"8b2e4c8e-2b8f-4d77-a3c2-5a3c1c3f2f6e"`
+	Name        *string   `json:"name,omitempty" validate:"omitempty,role" example:"finance"`
+	Description *string   `json:"description,omitempty" validate:"omitempty,max=255" example:"Manage billing for the account."`
+	Permissions *[]string `json:"permissions,omitempty" validate:"omitempty,min=1,dive,permission" swaggertype:"array,string" example:"account:read"`
+}
+
+// AccountRoleArchiveRequest defines the information needed to archive an account role. This will archive
+// (soft-delete) the existing database entry and remove the role from users assigned to it.
+type AccountRoleArchiveRequest struct {
+	ID string `json:"id" validate:"required,uuid" example:"8b2e4c8e-2b8f-4d77-a3c2-5a3c1c3f2f6e"`
+}
+
+// AccountRoleDeleteRequest defines the information needed to delete an account role.
+type AccountRoleDeleteRequest struct {
+	ID string `json:"id" validate:"required,uuid" example:"8b2e4c8e-2b8f-4d77-a3c2-5a3c1c3f2f6e"`
+}
+
+// AccountRoleFindRequest defines the possible options to search for account roles. By default
+// archived account roles will be excluded from response.
+type AccountRoleFindRequest struct {
+	Filter          filter.Filter `json:"filter,omitempty"`
+	Order           []string      `json:"order" example:"created_at desc"`
+	Limit           *uint         `json:"limit" example:"10"`
+	Offset          *uint         `json:"offset" example:"20"`
+	Cursor          string        `json:"cursor,omitempty" example:"eyJvIjpbImlkIGFzYyJdLCJ2IjpbIjk4NWYxNzQ2Il19"`
+	IncludeArchived bool          `json:"include-archived" example:"false"`
+}
+
+// AccountRoleFindFields defines the fields of account role that can be used to filter and sort a find request.
+var AccountRoleFindFields = filter.Fields{
+	{Name: "id", Type: filter.FieldType_UUID, Filterable: true, Sortable: true, Key: true},
+	{Name: "name", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "updated_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "archived_at", Type: filter.FieldType_Time, Filterable: true, Nullable: true},
+}
+
+// Permission_ValuesInterface returns the permission options as a slice interface.
+func Permission_ValuesInterface() []interface{} {
+	var l []interface{}
+	for _, v := range auth.Permissions {
+		l = append(l, v)
+	}
+	return l
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/go-cmp/cmp"
@@ -854,14 +855,16 @@ func TestFind(t *testing.T) {
 
 	var accountTests []accountTest
 
-	createdFilter := "created_at BETWEEN ? AND ?"
+	createdFilter := filter.Filter{
+		{Field: "created_at", Operator: filter.Op_Gte, Values: []string{startTime.Format(time.RFC3339Nano)}},
+		{Field: "created_at", Operator: filter.Op_Lte, Values: []string{endTime.Format(time.RFC3339Nano)}},
+	}
 
 	// Test sort accounts.
 	accountTests = append(accountTests, accountTest{"Find all order by created_at asc",
 		AccountFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at"},
+			Filter: createdFilter,
+			Order:  []string{"created_at"},
 		},
 		accounts,
 		nil,
@@ -874,9 +877,8 @@ func TestFind(t *testing.T) {
 	}
 	accountTests = append(accountTests, accountTest{"Find all order by created_at desc",
 		AccountFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at desc"},
+			Filter: createdFilter,
+			Order:  []string{"created_at desc"},
 		},
 		expected,
 		nil,
@@ -886,10 +888,9 @@ func TestFind(t *testing.T) {
 	var limit uint = 2
 	accountTests = append(accountTests, accountTest{"Find limit",
 		AccountFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at"},
-			Limit: &limit,
+			Filter: createdFilter,
+			Order:  []string{"created_at"},
+			Limit:  &limit,
 		},
 		accounts[0:2],
 		nil,
@@ -899,8 +900,7 @@ func TestFind(t *testing.T) {
 	var offset uint = 3
 	accountTests = append(accountTests, accountTest{"Find limit, offset",
 		AccountFindRequest{
-			Where:  createdFilter,
-			Args:   []interface{}{startTime, endTime},
+			Filter: createdFilter,
 			Order:  []string{"created_at"},
 			Limit:  &limit,
 			Offset: &offset,
@@ -910,25 +910,23 @@ func TestFind(t *testing.T) {
 	})
 
 	// Test where filter.
-	whereParts := []string{}
-	whereArgs := []interface{}{startTime, endTime}
+	var values []string
 	expected = []*Account{}
-	for i := 0; i <= len(accounts); i++ {
-		if rand.Intn(100) < 50 {
+	for i := 0; i < len(accounts); i++ {
+		// Always include the first one so the filter has a value.
+		if i > 0 && rand.Intn(100) < 50 {
 			continue
 		}
 		u := *accounts[i]
 
-		whereParts = append(whereParts, "name = ?")
-		whereArgs = append(whereArgs, u.Name)
+		values = append(values, u.Name)
 		expected = append(expected, &u)
 	}
 
 	accountTests = append(accountTests, accountTest{"Find where",
 		AccountFindRequest{
-			Where: createdFilter + " AND (" + strings.Join(whereParts, " OR ") + ")",
-			Args:  whereArgs,
-			Order: []string{"created_at"},
+			Filter: append(createdFilter, filter.Condition{Field: "name", Operator: filter.Op_In, Values: values}),
+			Order:  []string{"created_at"},
 		},
 		expected,
 		nil,
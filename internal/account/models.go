@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"time"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
@@ -14,9 +15,13 @@ import (
 	"gopkg.in/go-playground/validator.v9"
 )
 
+// DefaultPurgeGracePeriod is the duration a closed account can be restored before it's purged.
+const DefaultPurgeGracePeriod = 30 * 24 * time.Hour
+
 // Repository defines the required dependencies for Account.
 type Repository struct {
-	DbConn *sqlx.DB
+	DbConn           *sqlx.DB
+	PurgeGracePeriod time.Duration
 }
 
 // NewRepository creates a new Repository that defines dependencies for Account.
@@ -43,6 +48,7 @@ type Account struct {
 	CreatedAt     time.Time       `json:"created_at"`
 	UpdatedAt     time.Time       `json:"updated_at"`
 	ArchivedAt    *pq.NullTime    `json:"archived_at,omitempty"`
+	PurgeAt       *pq.NullTime    `json:"purge_at,omitempty"`
 }
 
 // AccountResponse represents someone with access to our system that is returned for display.
@@ -62,6 +68,7 @@ type AccountResponse struct {
 	CreatedAt     web.TimeResponse  `json:"created_at"`            // CreatedAt contains multiple format options for display.
 	UpdatedAt     web.TimeResponse  `json:"updated_at"`            // UpdatedAt contains multiple format options for display.
 	ArchivedAt    *web.TimeResponse `json:"archived_at,omitempty"` // ArchivedAt contains multiple format options for display.
+	PurgeAt       *web.TimeResponse `json:"purge_at,omitempty"`    // PurgeAt is set for closed accounts that will be permanently deleted.
 }
 
 // Response transforms Account and AccountResponse that is used for display.
@@ -98,6 +105,11 @@ func (m *Account) Response(ctx context.Context) *AccountResponse {
 		r.ArchivedAt = &at
 	}
 
+	if m.PurgeAt != nil && !m.PurgeAt.Time.IsZero() {
+		at := web.NewTimeResponse(ctx, m.PurgeAt.Time)
+		r.PurgeAt = &at
+	}
+
 	return r
 }
 
@@ -175,6 +187,17 @@ type AccountArchiveRequest struct {
 	ID string `json:"id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
 }
 
+// AccountCloseRequest defines the information needed to close an account. The account is archived and then purged
+// once the grace period has passed unless it's restored.
+type AccountCloseRequest struct {
+	ID string `json:"id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+}
+
+// AccountRestoreRequest defines the information needed to restore a closed account before it's purged.
+type AccountRestoreRequest struct {
+	ID string `json:"id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+}
+
 // AccountDeleteRequest defines the information needed to delete a user.
 type AccountDeleteRequest struct {
 	ID string `json:"id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
@@ -183,14 +206,23 @@ type AccountDeleteRequest struct {
 // AccountFindRequest defines the possible options to search for accounts. By default
 // archived accounts will be excluded from response.
 type AccountFindRequest struct {
-	Where           string        `json:"where" example:"name = ? and status = ?"`
-	Args            []interface{} `json:"args" swaggertype:"array,string" example:"Company Name,active"`
+	Filter          filter.Filter `json:"filter,omitempty"`
 	Order           []string      `json:"order" example:"created_at desc"`
 	Limit           *uint         `json:"limit" example:"10"`
 	Offset          *uint         `json:"offset" example:"20"`
 	IncludeArchived bool          `json:"include-archived" example:"false"`
 }
 
+// AccountFindFields defines the fields of account that can be used to filter and sort a find request.
+var AccountFindFields = filter.Fields{
+	{Name: "id", Type: filter.FieldType_UUID, Filterable: true, Sortable: true, Key: true},
+	{Name: "name", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "status", Type: filter.FieldType_Enum, Values: filter.EnumValues(AccountStatus_ValuesInterface()), Filterable: true, Sortable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "updated_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "archived_at", Type: filter.FieldType_Time, Filterable: true, Nullable: true},
+}
+
 // AccountStatus represents the status of an account.
 type AccountStatus string
 
@@ -0,0 +1,775 @@
+package api_key
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_role"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// The database table for ApiKey
+	apiKeyTableName = "api_keys"
+	// The database table for User Account
+	userAccountTableName = "users_accounts"
+
+	// lastUsedInterval limits how often the last used timestamp is updated for an API key.
+	lastUsedInterval = time.Minute
+)
+
+var (
+	// ErrNotFound abstracts the postgres not found error.
+	ErrNotFound = errors.New("Entity not found")
+
+	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrInvalidRole occurs when a role is assigned that is not a built-in role or defined for the account.
+	ErrInvalidRole = errors.New("Invalid role")
+
+	// ErrInvalidScope occurs when a scope is not a defined permission.
+	ErrInvalidScope = errors.New("Invalid scope")
+
+	// ErrInvalidKey occurs when an API key does not exist, has been revoked or has expired.
+	ErrInvalidKey = errors.New("Invalid API key")
+)
+
+// apiKeyMapColumns is the list of columns needed for find.
+var apiKeyMapColumns = "id,account_id,user_id,type,name,prefix,key_hash,roles,scopes,expires_at,last_used_at,created_at,updated_at,archived_at"
+
+// CanModifyApiKey determines if claims has the authority to manage the API key. Users can manage their own personal
+// keys and users with the api_key:write permission can manage all the keys for the account.
+func CanModifyApiKey(ctx context.Context, claims auth.Claims, m *ApiKey) error {
+	// Claims are empty, request is internal.
+	if claims.Audience == "" && claims.Subject == "" {
+		return nil
+	}
+
+	// API keys can only be managed for the account the claims were issued for.
+	if claims.Audience != m.AccountID {
+		return errors.WithStack(ErrForbidden)
+	}
+
+	if claims.HasPermission(auth.PermissionApiKeyWrite) {
+		return nil
+	} else if m.Type == ApiKeyType_Personal && m.UserID == claims.Subject {
+		return nil
+	}
+
+	return errors.WithStack(ErrForbidden)
+}
+
+// applyClaimsSelect applies a sub-query to the provided query to enforce ACL based on the claims provided.
+// 	1. No claims, request is internal, no ACL applied
+// 	2. Users with the api_key:write permission can access all the API keys for their account ID
+// 	3. All other users can only access the personal API keys they created
+func applyClaimsSelect(ctx context.Context, claims auth.Claims, query *sqlbuilder.SelectBuilder) error {
+	// Claims are empty, don't apply any ACL
+	if claims.Audience == "" && claims.Subject == "" {
+		return nil
+	}
+
+	query.Where(query.Equal("account_id", claims.Audience))
+
+	if !claims.HasPermission(auth.PermissionApiKeyWrite) {
+		query.Where(query.And(
+			query.Equal("user_id", claims.Subject),
+			query.Equal("type", ApiKeyType_Personal),
+		))
+	}
+
+	return nil
+}
+
+// selectQuery constructs a base select query for ApiKey.
+func selectQuery() *sqlbuilder.SelectBuilder {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Select(apiKeyMapColumns)
+	query.From(apiKeyTableName)
+	return query
+}
+
+// findRequestQuery generates the select query for the given find request.
+func findRequestQuery(req ApiKeyFindRequest) (*sqlbuilder.SelectBuilder, error) {
+	query := selectQuery()
+
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, ApiKeyFindFields); err != nil {
+			return nil, err
+		}
+	}
+	if req.Cursor != "" {
+		if err := ApiKeyFindFields.ApplyCursor(query, req.Order, req.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(req.Order) > 0 {
+		query.OrderBy(req.Order...)
+	}
+
+	if req.Limit != nil {
+		query.Limit(int(*req.Limit))
+	}
+
+	if req.Offset != nil {
+		query.Offset(int(*req.Offset))
+	}
+
+	return query, nil
+}
+
+// Find gets all the API keys from the database based on the request params.
+func (repo *Repository) Find(ctx context.Context, claims auth.Claims, req ApiKeyFindRequest) (ApiKeys, error) {
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
+}
+
+// Count gets the total number of API keys from the database that match the request params. The order, limit,
+// offset and cursor of the request are ignored.
+func (repo *Repository) Count(ctx context.Context, claims auth.Claims, req ApiKeyFindRequest) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.api_key.Count")
+	defer span.Finish()
+
+	req.Order, req.Limit, req.Offset, req.Cursor = nil, nil, nil, ""
+
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return 0, err
+	}
+	query.Select("count(*)")
+
+	if !req.IncludeArchived {
+		query.Where(query.IsNull("archived_at"))
+	}
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err = applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return 0, err
+	}
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var total int
+	err = repo.DbConn.QueryRowContext(ctx, queryStr, args...).Scan(&total)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "count API keys failed")
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// find internal method for getting all the API keys from the database using a select query.
+func find(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) (ApiKeys, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.api_key.Find")
+	defer span.Finish()
+
+	query.Select(apiKeyMapColumns)
+	query.From(apiKeyTableName)
+	if !includedArchived {
+		query.Where(query.IsNull("archived_at"))
+	}
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err := applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return nil, err
+	}
+
+	queryStr, queryArgs := query.Build()
+	queryStr = dbConn.Rebind(queryStr)
+	args = append(args, queryArgs...)
+
+	// Fetch all entries from the db.
+	rows, err := dbConn.QueryContext(ctx, queryStr, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find API keys failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over each row.
+	resp := []*ApiKey{}
+	for rows.Next() {
+		var m ApiKey
+		err = rows.Scan(&m.ID, &m.AccountID, &m.UserID, &m.Type, &m.Name, &m.Prefix, &m.KeyHash, &m.Roles, &m.Scopes, &m.ExpiresAt, &m.LastUsedAt, &m.CreatedAt, &m.UpdatedAt, &m.ArchivedAt)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+
+		resp = append(resp, &m)
+	}
+
+	return resp, nil
+}
+
+// ReadByID gets the specified API key by ID from the database.
+func (repo *Repository) ReadByID(ctx context.Context, claims auth.Claims, id string) (*ApiKey, error) {
+	return repo.Read(ctx, claims, ApiKeyReadRequest{
+		ID:              id,
+		IncludeArchived: false,
+	})
+}
+
+// Read gets the specified API key from the database.
+func (repo *Repository) Read(ctx context.Context, claims auth.Claims, req ApiKeyReadRequest) (*ApiKey, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.api_key.Read")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter base select query by id
+	query := sqlbuilder.NewSelectBuilder()
+	query.Where(query.Equal("id", req.ID))
+
+	res, err := find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
+	if err != nil {
+		return nil, err
+	} else if res == nil || len(res) == 0 {
+		err = errors.WithMessagef(ErrNotFound, "API key %s not found", req.ID)
+		return nil, err
+	}
+
+	u := res[0]
+	return u, nil
+}
+
+// Create inserts a new API key into the database. The key is only returned by Create, only the hash of the key
+// is stored. The permissions granted by the roles and scopes can't exceed the permissions of the claims.
+func (repo *Repository) Create(ctx context.Context, claims auth.Claims, req ApiKeyCreateRequest, now time.Time) (*ApiKey, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.api_key.Create")
+	defer span.Finish()
+
+	if claims.Audience != "" && req.AccountID == "" {
+		// Set the accountId from claims.
+		req.AccountID = claims.Audience
+	}
+
+	if claims.Subject != "" && req.UserID == "" {
+		// Set the userId from claims.
+		req.UserID = claims.Subject
+	}
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	m := ApiKey{
+		ID:        uuid.NewRandom().String(),
+		AccountID: req.AccountID,
+		UserID:    req.UserID,
+		Type:      ApiKeyType_Personal,
+		Name:      req.Name,
+		Roles:     pq.StringArray(req.Roles),
+		Scopes:    pq.StringArray(req.Scopes),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if req.Type != nil {
+		m.Type = *req.Type
+	}
+
+	if m.Scopes == nil {
+		m.Scopes = pq.StringArray{}
+	}
+
+	if req.ExpiresAt != nil {
+		m.ExpiresAt = &pq.NullTime{Time: req.ExpiresAt.UTC().Truncate(time.Millisecond), Valid: true}
+	}
+
+	// Personal keys can only be created by the user they belong to, account keys require the api_key:write
+	// permission.
+	if claims.Audience != "" || claims.Subject != "" {
+		if claims.Audience != m.AccountID {
+			return nil, errors.WithStack(ErrForbidden)
+		} else if m.Type == ApiKeyType_Personal && m.UserID != claims.Subject {
+			return nil, errors.WithStack(ErrForbidden)
+		} else if m.Type == ApiKeyType_Account && !claims.HasPermission(auth.PermissionApiKeyWrite) {
+			return nil, errors.WithStack(ErrForbidden)
+		}
+	}
+
+	perms, err := repo.resolvePermissions(ctx, m.AccountID, m.Roles, m.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the key doesn't grant any permissions the claims don't have.
+	if claims.Audience != "" || claims.Subject != "" {
+		for _, p := range perms {
+			if !claims.HasPermission(p) {
+				return nil, errors.WithMessagef(ErrForbidden, "permission %s can't be granted", p)
+			}
+		}
+	}
+
+	// Generate the key, only the hash is stored.
+	m.Prefix, m.Key, err = newKey()
+	if err != nil {
+		return nil, err
+	}
+	m.KeyHash = hashKey(m.Key)
+
+	// Build the insert SQL statement.
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(apiKeyTableName)
+	query.Cols(
+		"id",
+		"account_id",
+		"user_id",
+		"type",
+		"name",
+		"prefix",
+		"key_hash",
+		"roles",
+		"scopes",
+		"expires_at",
+		"created_at",
+		"updated_at",
+		"archived_at",
+	)
+
+	query.Values(
+		m.ID,
+		m.AccountID,
+		m.UserID,
+		m.Type,
+		m.Name,
+		m.Prefix,
+		m.KeyHash,
+		m.Roles,
+		m.Scopes,
+		m.ExpiresAt,
+		m.CreatedAt,
+		m.UpdatedAt,
+		m.ArchivedAt,
+	)
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "create API key failed")
+		return nil, err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  m.AccountID,
+		EntityType: audit.EntityType_ApiKey,
+		EntityID:   m.ID,
+		Action:     audit.Action_Create,
+		After:      m,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Update renames an API key in the database.
+func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req ApiKeyUpdateRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.api_key.Update")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	before, err := repo.ReadByID(ctx, claims, req.ID)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can modify the API key.
+	err = CanModifyApiKey(ctx, claims, before)
+	if err != nil {
+		return err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(apiKeyTableName)
+
+	query.Set(
+		query.Assign("name", *req.Name),
+		query.Assign("updated_at", now),
+	)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "update API key %s failed", req.ID)
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  before.AccountID,
+		EntityType: audit.EntityType_ApiKey,
+		EntityID:   before.ID,
+		Action:     audit.Action_Update,
+		Before:     before,
+		After:      req,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Archive revokes the API key by soft deleting it from the database.
+func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req ApiKeyArchiveRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.api_key.Archive")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	m, err := repo.ReadByID(ctx, claims, req.ID)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can modify the API key.
+	err = CanModifyApiKey(ctx, claims, m)
+	if err != nil {
+		return err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(apiKeyTableName)
+	query.Set(
+		query.Assign("archived_at", now),
+	)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "archive API key %s failed", req.ID)
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  m.AccountID,
+		EntityType: audit.EntityType_ApiKey,
+		EntityID:   m.ID,
+		Action:     audit.Action_Archive,
+		After:      map[string]interface{}{"archived_at": now},
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes an API key from the database.
+func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req ApiKeyDeleteRequest) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.api_key.Delete")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	m, err := repo.Read(ctx, claims, ApiKeyReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can modify the API key.
+	err = CanModifyApiKey(ctx, claims, m)
+	if err != nil {
+		return err
+	}
+
+	// Build the delete SQL statement.
+	query := sqlbuilder.NewDeleteBuilder()
+	query.DeleteFrom(apiKeyTableName)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "delete API key %s failed", req.ID)
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  m.AccountID,
+		EntityType: audit.EntityType_ApiKey,
+		EntityID:   m.ID,
+		Action:     audit.Action_Delete,
+		Before:     m,
+	}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ResolveApiKey implements the auth.ApiKeyResolver interface. It returns the claims granted to the API key. The user
+// that created the key must still be active for the account. The permissions of personal keys are limited to the
+// current permissions of the user.
+func (repo *Repository) ResolveApiKey(ctx context.Context, key string) (auth.Claims, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.api_key.ResolveApiKey")
+	defer span.Finish()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	prefix := strings.SplitN(key, ".", 2)[0]
+	if prefix == "" || prefix == key {
+		return auth.Claims{}, errors.WithStack(ErrInvalidKey)
+	}
+
+	// Find the API key by the prefix.
+	query := selectQuery()
+	query.Where(query.Equal("prefix", prefix))
+
+	res, err := find(ctx, auth.Claims{}, repo.DbConn, query, []interface{}{}, false)
+	if err != nil {
+		return auth.Claims{}, err
+	} else if len(res) == 0 {
+		return auth.Claims{}, errors.WithStack(ErrInvalidKey)
+	}
+	m := res[0]
+
+	if subtle.ConstantTimeCompare([]byte(hashKey(key)), []byte(m.KeyHash)) != 1 {
+		return auth.Claims{}, errors.WithStack(ErrInvalidKey)
+	} else if m.ExpiresAt != nil && m.ExpiresAt.Valid && !m.ExpiresAt.Time.After(now) {
+		return auth.Claims{}, errors.WithMessagef(ErrInvalidKey, "API key %s expired", m.ID)
+	}
+
+	// Load the current roles of the user for the account.
+	var userRoles pq.StringArray
+	{
+		query := sqlbuilder.NewSelectBuilder().Select("roles").From(userAccountTableName)
+		query.Where(query.And(
+			query.Equal("account_id", m.AccountID),
+			query.Equal("user_id", m.UserID),
+			query.Equal("status", "active"),
+			query.IsNull("archived_at"),
+		))
+
+		queryStr, args := query.Build()
+		queryStr = repo.DbConn.Rebind(queryStr)
+		err = repo.DbConn.QueryRowContext(ctx, queryStr, args...).Scan(&userRoles)
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, errors.WithMessagef(ErrInvalidKey, "user %s is not active for account %s", m.UserID, m.AccountID)
+		} else if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return auth.Claims{}, err
+		}
+	}
+
+	perms, err := repo.resolvePermissions(ctx, m.AccountID, m.Roles, m.Scopes)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	// Personal keys can't grant more than the user currently has.
+	if m.Type == ApiKeyType_Personal {
+		userPerms, err := account_role.ResolvePermissions(ctx, repo.DbConn, m.AccountID, userRoles)
+		if err != nil {
+			return auth.Claims{}, err
+		}
+		perms = intersect(perms, userPerms)
+	}
+
+	// Claims without permissions fall back to the permissions of the roles.
+	if len(perms) == 0 {
+		return auth.Claims{}, errors.WithMessagef(ErrInvalidKey, "API key %s has no permissions", m.ID)
+	}
+
+	claims := auth.Claims{
+		RootAccountID: m.AccountID,
+		RootUserID:    m.UserID,
+		AccountIDs:    []string{m.AccountID},
+		Roles:         m.Roles,
+		Permissions:   perms,
+		ApiKeyID:      m.ID,
+		StandardClaims: jwt.StandardClaims{
+			Subject:  m.UserID,
+			Audience: m.AccountID,
+			IssuedAt: now.Unix(),
+		},
+	}
+	if m.ExpiresAt != nil && m.ExpiresAt.Valid {
+		claims.ExpiresAt = m.ExpiresAt.Time.Unix()
+	}
+
+	// Track when the key was last used, limited to once per interval to avoid a write for every request.
+	if m.LastUsedAt == nil || !m.LastUsedAt.Valid || now.Sub(m.LastUsedAt.Time) >= lastUsedInterval {
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(apiKeyTableName)
+		query.Set(query.Assign("last_used_at", now))
+		query.Where(query.Equal("id", m.ID))
+
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "update last used for API key %s failed", m.ID)
+			return auth.Claims{}, err
+		}
+	}
+
+	return claims, nil
+}
+
+// resolvePermissions returns the permissions granted by the roles limited to the scopes when provided.
+func (repo *Repository) resolvePermissions(ctx context.Context, accountID string, roles, scopes []string) ([]string, error) {
+	for _, s := range scopes {
+		if !auth.ValidPermission(s) {
+			return nil, errors.WithMessagef(ErrInvalidScope, "scope %s is not defined", s)
+		}
+	}
+
+	perms, err := account_role.ResolvePermissions(ctx, repo.DbConn, accountID, roles)
+	if err != nil {
+		if errors.Cause(err) == account_role.ErrInvalidRole {
+			err = errors.WithMessage(ErrInvalidRole, err.Error())
+		}
+		return nil, err
+	}
+
+	if len(scopes) > 0 {
+		perms = intersect(perms, scopes)
+	}
+
+	return perms, nil
+}
+
+// intersect returns the values of a that are also in b.
+func intersect(a, b []string) []string {
+	var l []string
+	for _, v := range a {
+		for _, o := range b {
+			if v == o {
+				l = append(l, v)
+				break
+			}
+		}
+	}
+	return l
+}
+
+// newKey generates a random API key. The prefix is used to look up the key.
+func newKey() (string, string, error) {
+	prefix := make([]byte, 6)
+	if _, err := rand.Read(prefix); err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	p := hex.EncodeToString(prefix)
+	return p, p + "." + hex.EncodeToString(secret), nil
+}
+
+// hashKey returns the hex encoded SHA-256 hash of the key. Keys have enough entropy that a salted hash is not needed.
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,315 @@
+package api_key
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+)
+
+var (
+	test *tests.Test
+	repo *Repository
+)
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+
+	repo = NewRepository(test.MasterDB)
+
+	return m.Run()
+}
+
+// mockClaims returns the claims for the user account with the specified roles.
+func mockClaims(ua *user_account.MockUserAccountResponse, roles ...string) auth.Claims {
+	return auth.Claims{
+		Roles: roles,
+		StandardClaims: jwt.StandardClaims{
+			Subject:  ua.UserID,
+			Audience: ua.AccountID,
+		},
+	}
+}
+
+// TestCreateValidation ensures keys can only be created with the roles and scopes the claims are allowed to grant.
+func TestCreateValidation(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	ua, err := user_account.MockUserAccount(ctx, test.MasterDB, now, user_account.UserAccountRole_Admin)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tMock user account failed.", tests.Failed)
+	}
+
+	accountType := ApiKeyType_Account
+
+	var createTests = []struct {
+		name   string
+		claims auth.Claims
+		req    ApiKeyCreateRequest
+		err    error
+	}{
+		{"Invalid role",
+			mockClaims(ua, auth.RoleAdmin),
+			ApiKeyCreateRequest{Name: "CI deploys", Roles: []string{"moon-pilot"}},
+			ErrInvalidRole,
+		},
+		{"Invalid scope",
+			mockClaims(ua, auth.RoleAdmin),
+			ApiKeyCreateRequest{Name: "CI deploys", Roles: []string{auth.RoleUser}, Scopes: []string{"moon:launch"}},
+			ErrInvalidScope,
+		},
+		{"Escalate role",
+			mockClaims(ua, auth.RoleUser),
+			ApiKeyCreateRequest{Name: "CI deploys", Roles: []string{auth.RoleAdmin}},
+			ErrForbidden,
+		},
+		{"Account key without permission",
+			mockClaims(ua, auth.RoleUser),
+			ApiKeyCreateRequest{Name: "CI deploys", Roles: []string{auth.RoleUser}, Type: &accountType},
+			ErrForbidden,
+		},
+		{"Personal key for other user",
+			mockClaims(ua, auth.RoleAdmin),
+			ApiKeyCreateRequest{Name: "CI deploys", Roles: []string{auth.RoleUser}, UserID: "d69bdef7-173f-4d29-b52c-3edc60baf6a2"},
+			ErrForbidden,
+		},
+	}
+
+	t.Log("Given the need ensure only valid API keys are created.")
+	{
+		for i, tt := range createTests {
+			t.Logf("\tTest: %d\tWhen running test: %s", i, tt.name)
+			{
+				_, err := repo.Create(ctx, tt.claims, tt.req, now)
+				if errors.Cause(err) != tt.err {
+					t.Logf("\t\tGot : %+v", err)
+					t.Logf("\t\tWant: %+v", tt.err)
+					t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+				}
+				t.Logf("\t%s\tCreate ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestUpdate ensures API keys can only be renamed by the users allowed to manage them and the change is audited.
+func TestUpdate(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	ua, err := user_account.MockUserAccount(ctx, test.MasterDB, now, user_account.UserAccountRole_Admin)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tMock user account failed.", tests.Failed)
+	}
+	claims := mockClaims(ua, auth.RoleAdmin)
+
+	// Another user of the same account without the api_key:write permission.
+	otherClaims := auth.Claims{
+		Roles: []string{auth.RoleUser},
+		StandardClaims: jwt.StandardClaims{
+			Subject:  "d69bdef7-173f-4d29-b52c-3edc60baf6a2",
+			Audience: ua.AccountID,
+		},
+	}
+
+	t.Log("Given the need to rename an API key.")
+	{
+		key, err := repo.Create(ctx, claims, ApiKeyCreateRequest{
+			Name:  "CI deploys",
+			Roles: []string{auth.RoleUser},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+		}
+
+		name := "Nightly builds"
+
+		err = repo.Update(ctx, otherClaims, ApiKeyUpdateRequest{ID: key.ID, Name: &name}, now)
+		if errors.Cause(err) != ErrNotFound {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrNotFound)
+			t.Fatalf("\t%s\tUpdate with other user failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tUpdate with other user ok.", tests.Success)
+
+		// The name is the only field that can be changed so it's required.
+		err = repo.Update(ctx, claims, ApiKeyUpdateRequest{ID: key.ID}, now)
+		if err == nil {
+			t.Fatalf("\t%s\tUpdate without name failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tUpdate without name ok.", tests.Success)
+
+		updatedAt := now.Add(time.Hour)
+		err = repo.Update(ctx, claims, ApiKeyUpdateRequest{ID: key.ID, Name: &name}, updatedAt)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tUpdate failed.", tests.Failed)
+		}
+
+		read, err := repo.ReadByID(ctx, claims, key.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead failed.", tests.Failed)
+		} else if read.Name != name || !read.UpdatedAt.Equal(updatedAt) {
+			t.Fatalf("\t%s\tExpected name to be %s, got %s.", tests.Failed, name, read.Name)
+		}
+		t.Logf("\t%s\tUpdate ok.", tests.Success)
+
+		events, err := audit.NewRepository(test.MasterDB).Find(ctx, auth.Claims{}, audit.AuditEventFindRequest{
+			Filter: filter.Filter{
+				{Field: "entity_id", Operator: filter.Op_Eq, Values: []string{key.ID}},
+				{Field: "action", Operator: filter.Op_Eq, Values: []string{string(audit.Action_Update)}},
+			},
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tFind audit events failed.", tests.Failed)
+		} else if len(events) != 1 {
+			t.Fatalf("\t%s\tExpected 1 update audit event, got %d.", tests.Failed, len(events))
+		}
+		t.Logf("\t%s\tUpdate audited ok.", tests.Success)
+	}
+}
+
+// TestResolveApiKey validates the claims resolved for an API key and ensures revoked and expired keys are rejected.
+func TestResolveApiKey(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	ctx := tests.Context()
+
+	ua, err := user_account.MockUserAccount(ctx, test.MasterDB, now, user_account.UserAccountRole_Admin)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tMock user account failed.", tests.Failed)
+	}
+	claims := mockClaims(ua, auth.RoleAdmin)
+
+	t.Log("Given the need to authenticate with an API key.")
+	{
+		key, err := repo.Create(ctx, claims, ApiKeyCreateRequest{
+			Name:  "CI deploys",
+			Roles: []string{auth.RoleAdmin},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+		} else if key.Key == "" || key.KeyHash == key.Key {
+			t.Fatalf("\t%s\tExpected key to be returned and only the hash stored.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate ok.", tests.Success)
+
+		res, err := repo.ResolveApiKey(ctx, key.Key)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tResolveApiKey failed.", tests.Failed)
+		} else if res.Subject != ua.UserID || res.Audience != ua.AccountID || res.ApiKeyID != key.ID {
+			t.Fatalf("\t%s\tExpected claims for user %s and account %s.", tests.Failed, ua.UserID, ua.AccountID)
+		} else if diff := cmp.Diff(res.Permissions, auth.RolePermissions[auth.RoleAdmin]); diff != "" {
+			t.Fatalf("\t%s\tExpected permissions of role admin. Diff:\n%s", tests.Failed, diff)
+		}
+		t.Logf("\t%s\tResolveApiKey ok.", tests.Success)
+
+		// The last used timestamp should be set.
+		read, err := repo.ReadByID(ctx, claims, key.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead failed.", tests.Failed)
+		} else if read.LastUsedAt == nil || !read.LastUsedAt.Valid {
+			t.Fatalf("\t%s\tExpected last used to be set.", tests.Failed)
+		}
+		t.Logf("\t%s\tLast used ok.", tests.Success)
+
+		// A key with a different secret should be rejected.
+		_, err = repo.ResolveApiKey(ctx, key.Prefix+".invalid")
+		if errors.Cause(err) != ErrInvalidKey {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidKey)
+			t.Fatalf("\t%s\tResolveApiKey with invalid secret failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tResolveApiKey with invalid secret ok.", tests.Success)
+
+		// Personal keys are limited to the current roles of the user.
+		roles := user_account.UserAccountRoles{user_account.UserAccountRole_User}
+		err = user_account.NewRepository(test.MasterDB).Update(ctx, auth.Claims{}, user_account.UserAccountUpdateRequest{
+			UserID:    ua.UserID,
+			AccountID: ua.AccountID,
+			Roles:     &roles,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tUpdate user account failed.", tests.Failed)
+		}
+
+		res, err = repo.ResolveApiKey(ctx, key.Key)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tResolveApiKey failed.", tests.Failed)
+		} else if diff := cmp.Diff(res.Permissions, auth.RolePermissions[auth.RoleUser]); diff != "" {
+			t.Fatalf("\t%s\tExpected permissions of role user. Diff:\n%s", tests.Failed, diff)
+		}
+		t.Logf("\t%s\tResolveApiKey with reduced roles ok.", tests.Success)
+
+		// Revoked keys should be rejected.
+		err = repo.Archive(ctx, claims, ApiKeyArchiveRequest{ID: key.ID}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tArchive failed.", tests.Failed)
+		}
+
+		_, err = repo.ResolveApiKey(ctx, key.Key)
+		if errors.Cause(err) != ErrInvalidKey {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidKey)
+			t.Fatalf("\t%s\tResolveApiKey with revoked key failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tResolveApiKey with revoked key ok.", tests.Success)
+
+		// Expired keys should be rejected.
+		expires := now.Add(-time.Hour)
+		expired, err := repo.Create(ctx, auth.Claims{}, ApiKeyCreateRequest{
+			AccountID: ua.AccountID,
+			UserID:    ua.UserID,
+			Name:      "Expired",
+			Roles:     []string{auth.RoleUser},
+			ExpiresAt: &expires,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+		}
+
+		_, err = repo.ResolveApiKey(ctx, expired.Key)
+		if errors.Cause(err) != ErrInvalidKey {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidKey)
+			t.Fatalf("\t%s\tResolveApiKey with expired key failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tResolveApiKey with expired key ok.", tests.Success)
+	}
+}
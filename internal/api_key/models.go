@@ -0,0 +1,234 @@
+package api_key
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// Repository defines the required dependencies for ApiKey.
+type Repository struct {
+	DbConn *sqlx.DB
+}
+
+// NewRepository creates a new Repository that defines dependencies for ApiKey.
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		DbConn: db,
+	}
+}
+
+// ApiKey represents a long-lived key used by machine clients to authenticate. Personal keys act on behalf of the
+// user that created them and account keys are managed by the account.
+type ApiKey struct {
+	ID         string         `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	AccountID  string         `json:"account_id" validate:"required,uuid" truss:"api-create"`
+	UserID     string         `json:"user_id" validate:"required,uuid" truss:"api-create"`
+	Type       ApiKeyType     `json:"type" validate:"required,oneof=personal account" enums:"personal,account" swaggertype:"string" example:"personal"`
+	Name       string         `json:"name" validate:"required,max=200" example:"CI deploys"`
+	Prefix     string         `json:"prefix" truss:"api-read"`
+	KeyHash    string         `json:"-" validate:"required"`
+	Roles      pq.StringArray `json:"roles" validate:"required,min=1" swaggertype:"array,string" example:"user"`
+	Scopes     pq.StringArray `json:"scopes" swaggertype:"array,string" example:"project:read"`
+	ExpiresAt  *pq.NullTime   `json:"expires_at,omitempty"`
+	LastUsedAt *pq.NullTime   `json:"last_used_at,omitempty" truss:"api-read"`
+	CreatedAt  time.Time      `json:"created_at" truss:"api-read"`
+	UpdatedAt  time.Time      `json:"updated_at" truss:"api-read"`
+	ArchivedAt *pq.NullTime   `json:"archived_at,omitempty" truss:"api-hide"`
+
+	// Key is only set when the API key is created, only the hash of the key is stored.
+	Key string `json:"-"`
+}
+
+// ApiKeyResponse represents an API key that is returned for display.
+type ApiKeyResponse struct {
+	ID         string            `json:"id" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	AccountID  string            `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	UserID     string            `json:"user_id" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	Type       web.EnumResponse  `json:"type"` // Type is enum with values [personal, account].
+	Name       string            `json:"name" example:"CI deploys"`
+	Prefix     string            `json:"prefix" example:"3f9a1c07b2e4"`
+	Key        string            `json:"key,omitempty" example:"3f9a1c07b2e4.8d3f9bf1a7e0c6a54d3b2e1f0a9c8b7d6e5f4a3b2c1d0e9f"` // Key is only included when the API key is created.
+	Roles      []string          `json:"roles" example:"user"`
+	Scopes     []string          `json:"scopes" example:"project:read"`
+	ExpiresAt  *web.TimeResponse `json:"expires_at,omitempty"`   // ExpiresAt contains multiple format options for display.
+	LastUsedAt *web.TimeResponse `json:"last_used_at,omitempty"` // LastUsedAt contains multiple format options for display.
+	CreatedAt  web.TimeResponse  `json:"created_at"`             // CreatedAt contains multiple format options for display.
+	UpdatedAt  web.TimeResponse  `json:"updated_at"`             // UpdatedAt contains multiple format options for display.
+	ArchivedAt *web.TimeResponse `json:"archived_at,omitempty"`  // ArchivedAt contains multiple format options for display.
+}
+
+// Response transforms ApiKey and ApiKeyResponse that is used for display.
+// Additional filtering by context values or translations could be applied.
+func (m *ApiKey) Response(ctx context.Context) *ApiKeyResponse {
+	if m == nil {
+		return nil
+	}
+
+	r := &ApiKeyResponse{
+		ID:        m.ID,
+		AccountID: m.AccountID,
+		UserID:    m.UserID,
+		Type:      web.NewEnumResponse(ctx, m.Type, ApiKeyType_ValuesInterface()...),
+		Name:      m.Name,
+		Prefix:    m.Prefix,
+		Key:       m.Key,
+		Roles:     m.Roles,
+		Scopes:    m.Scopes,
+		CreatedAt: web.NewTimeResponse(ctx, m.CreatedAt),
+		UpdatedAt: web.NewTimeResponse(ctx, m.UpdatedAt),
+	}
+
+	if m.ExpiresAt != nil && !m.ExpiresAt.Time.IsZero() {
+		at := web.NewTimeResponse(ctx, m.ExpiresAt.Time)
+		r.ExpiresAt = &at
+	}
+
+	if m.LastUsedAt != nil && !m.LastUsedAt.Time.IsZero() {
+		at := web.NewTimeResponse(ctx, m.LastUsedAt.Time)
+		r.LastUsedAt = &at
+	}
+
+	if m.ArchivedAt != nil && !m.ArchivedAt.Time.IsZero() {
+		at := web.NewTimeResponse(ctx, m.ArchivedAt.Time)
+		r.ArchivedAt = &at
+	}
+
+	return r
+}
+
+// ApiKeys a list of ApiKeys.
+type ApiKeys []*ApiKey
+
+// Response transforms a list of ApiKeys to a list of ApiKeyResponses.
+func (m *ApiKeys) Response(ctx context.Context) []*ApiKeyResponse {
+	var l []*ApiKeyResponse
+	if m != nil && len(*m) > 0 {
+		for _, n := range *m {
+			l = append(l, n.Response(ctx))
+		}
+	}
+
+	return l
+}
+
+// ApiKeyCreateRequest contains information needed to create a new ApiKey. The roles define the permissions granted
+// to the key and the optional scopes further limit them to the listed permissions.
+type ApiKeyCreateRequest struct {
+	AccountID string      `json:"account_id" validate:"required,uuid"  example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	UserID    string      `json:"user_id" validate:"required,uuid"  example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	Type      *ApiKeyType `json:"type,omitempty" validate:"omitempty,oneof=personal account" enums:"personal,account" swaggertype:"string" example:"personal"`
+	Name      string      `json:"name" validate:"required,max=200" example:"CI deploys"`
+	Roles     []string    `json:"roles" validate:"required,min=1" swaggertype:"array,string" example:"user"`
+	Scopes    []string    `json:"scopes,omitempty" swaggertype:"array,string" example:"project:read"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty" example:"2020-01-01T00:00:00Z"`
+}
+
+// ApiKeyReadRequest defines the information needed to read an API key.
+type ApiKeyReadRequest struct {
+	ID              string `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	IncludeArchived bool   `json:"include-archived" example:"false"`
+}
+
+// ApiKeyUpdateRequest defines what information may be provided to modify an existing
+// ApiKey. Only the name can be changed, the roles and scopes of a key are fixed once
+// it's created so a new key has to be created to change its permissions.
+type ApiKeyUpdateRequest struct {
+	ID   string  `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	Name *string `json:"name" validate:"required,max=200" example:"CI deploys"`
+}
+
+// ApiKeyArchiveRequest defines the information needed to revoke an API key. This will archive (soft-delete) the
+// existing database entry.
+type ApiKeyArchiveRequest struct {
+	ID string `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+}
+
+// ApiKeyDeleteRequest defines the information needed to delete an API key.
+type ApiKeyDeleteRequest struct {
+	ID string `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+}
+
+// ApiKeyFindRequest defines the possible options to search for API keys. By default
+// archived API keys will be excluded from response.
+type ApiKeyFindRequest struct {
+	Filter          filter.Filter `json:"filter,omitempty"`
+	Order           []string      `json:"order" example:"created_at desc"`
+	Limit           *uint         `json:"limit" example:"10"`
+	Offset          *uint         `json:"offset" example:"20"`
+	Cursor          string        `json:"cursor,omitempty" example:"eyJvIjpbImlkIGFzYyJdLCJ2IjpbIjk4NWYxNzQ2Il19"`
+	IncludeArchived bool          `json:"include-archived" example:"false"`
+}
+
+// ApiKeyFindFields defines the fields of API key that can be used to filter and sort a find request.
+var ApiKeyFindFields = filter.Fields{
+	{Name: "id", Type: filter.FieldType_UUID, Filterable: true, Sortable: true, Key: true},
+	{Name: "user_id", Type: filter.FieldType_UUID, Filterable: true},
+	{Name: "type", Type: filter.FieldType_Enum, Values: filter.EnumValues(ApiKeyType_ValuesInterface()), Filterable: true, Sortable: true},
+	{Name: "name", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "prefix", Type: filter.FieldType_String, Filterable: true},
+	{Name: "expires_at", Type: filter.FieldType_Time, Filterable: true, Nullable: true},
+	{Name: "last_used_at", Type: filter.FieldType_Time, Filterable: true, Nullable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "archived_at", Type: filter.FieldType_Time, Filterable: true, Nullable: true},
+}
+
+// ApiKeyType represents the type of API key.
+type ApiKeyType string
+
+// ApiKeyType values define the type field of API key.
+const (
+	// ApiKeyType_Personal defines the type of personal for API key.
+	ApiKeyType_Personal ApiKeyType = "personal"
+	// ApiKeyType_Account defines the type of account for API key.
+	ApiKeyType_Account ApiKeyType = "account"
+)
+
+// ApiKeyType_Values provides list of valid ApiKeyType values.
+var ApiKeyType_Values = []ApiKeyType{
+	ApiKeyType_Personal,
+	ApiKeyType_Account,
+}
+
+// ApiKeyType_ValuesInterface returns the ApiKeyType options as a slice interface.
+func ApiKeyType_ValuesInterface() []interface{} {
+	var l []interface{}
+	for _, v := range ApiKeyType_Values {
+		l = append(l, v.String())
+	}
+	return l
+}
+
+// Scan supports reading the ApiKeyType value from the database.
+func (s *ApiKeyType) Scan(value interface{}) error {
+	asBytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("Scan source is not []byte")
+	}
+
+	*s = ApiKeyType(string(asBytes))
+	return nil
+}
+
+// Value converts the ApiKeyType value to be stored in the database.
+func (s ApiKeyType) Value() (driver.Value, error) {
+	v := validator.New()
+	errs := v.Var(s, "required,oneof=personal account")
+	if errs != nil {
+		return nil, errs
+	}
+
+	return string(s), nil
+}
+
+// String converts the ApiKeyType value to a string.
+func (s ApiKeyType) String() string {
+	return string(s)
+}
@@ -0,0 +1,285 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// The database table for AuditEvent
+	auditEventTableName = "audit_events"
+)
+
+var (
+	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+)
+
+// auditEventMapColumns is the list of columns needed for find.
+var auditEventMapColumns = "id,account_id,actor_user_id,root_user_id,entity_type,entity_id,action,diff,request_ip,created_at"
+
+// Record inserts a new audit event for a change made to an entity. The actor is the user of the claims unless
+// specified in the request. When the claims are for a virtual login, the root user is also recorded.
+func Record(ctx context.Context, dbConn sqlx.ExtContext, claims auth.Claims, req AuditEventRecordRequest, now time.Time) (*AuditEvent, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.audit.Record")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := NewDiff(req.Before, req.After)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "diff for %s %s failed", req.EntityType, req.EntityID)
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	m := AuditEvent{
+		ID:         uuid.NewRandom().String(),
+		EntityType: req.EntityType,
+		EntityID:   req.EntityID,
+		Action:     req.Action,
+		Diff:       diff,
+		CreatedAt:  now,
+	}
+
+	accountID := req.AccountID
+	if accountID == "" {
+		accountID = claims.Audience
+	}
+	if accountID != "" {
+		m.AccountID = &accountID
+	}
+
+	actorUserID := req.ActorUserID
+	if actorUserID == "" {
+		actorUserID = claims.Subject
+	}
+	if actorUserID != "" {
+		m.ActorUserID = &actorUserID
+	}
+
+	// The root user is only set when the actor is using a virtual login.
+	if claims.RootUserID != "" && claims.RootUserID != actorUserID {
+		rootUserID := claims.RootUserID
+		m.RootUserID = &rootUserID
+	}
+
+	// Load the current IP makings the request.
+	if vals, _ := webcontext.ContextValues(ctx); vals != nil {
+		m.RequestIP = vals.RequestIP
+	}
+
+	// Build the insert SQL statement.
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(auditEventTableName)
+	query.Cols(
+		"id",
+		"account_id",
+		"actor_user_id",
+		"root_user_id",
+		"entity_type",
+		"entity_id",
+		"action",
+		"diff",
+		"request_ip",
+		"created_at",
+	)
+
+	query.Values(
+		m.ID,
+		m.AccountID,
+		m.ActorUserID,
+		m.RootUserID,
+		string(m.EntityType),
+		m.EntityID,
+		string(m.Action),
+		m.Diff,
+		m.RequestIP,
+		m.CreatedAt,
+	)
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = dbConn.Rebind(sql)
+	_, err = dbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "record audit event for %s %s failed", req.EntityType, req.EntityID)
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// applyClaimsSelect applies a sub-query to the provided query to enforce ACL based on the claims provided.
+// 	1. No claims, request is internal, no ACL applied
+// 	2. Users with the audit:read permission can access the events for their account ID
+func applyClaimsSelect(ctx context.Context, claims auth.Claims, query *sqlbuilder.SelectBuilder) error {
+	// Claims are empty, don't apply any ACL
+	if claims.Audience == "" && claims.Subject == "" {
+		return nil
+	}
+
+	if !claims.HasPermission(auth.PermissionAuditRead) {
+		return errors.WithStack(ErrForbidden)
+	}
+
+	query.Where(query.Equal("account_id", claims.Audience))
+	return nil
+}
+
+// selectQuery constructs a base select query for AuditEvent.
+func selectQuery() *sqlbuilder.SelectBuilder {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Select(auditEventMapColumns)
+	query.From(auditEventTableName)
+	return query
+}
+
+// findRequestQuery generates the select query for the given find request.
+func findRequestQuery(req AuditEventFindRequest) (*sqlbuilder.SelectBuilder, error) {
+	query := selectQuery()
+
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, AuditEventFindFields); err != nil {
+			return nil, err
+		}
+	}
+	if req.Cursor != "" {
+		if err := AuditEventFindFields.ApplyCursor(query, req.Order, req.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(req.Order) > 0 {
+		query.OrderBy(req.Order...)
+	}
+
+	if req.Limit != nil {
+		query.Limit(int(*req.Limit))
+	}
+
+	if req.Offset != nil {
+		query.Offset(int(*req.Offset))
+	}
+
+	return query, nil
+}
+
+// Find gets all the audit events from the database based on the request params.
+func (repo *Repository) Find(ctx context.Context, claims auth.Claims, req AuditEventFindRequest) (AuditEvents, error) {
+	// Default to the most recent events first.
+	if len(req.Order) == 0 {
+		req.Order = []string{"created_at desc"}
+	}
+
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return find(ctx, claims, repo.DbConn, query, []interface{}{})
+}
+
+// Count gets the total number of audit events from the database that match the request params. The order, limit,
+// offset and cursor of the request are ignored.
+func (repo *Repository) Count(ctx context.Context, claims auth.Claims, req AuditEventFindRequest) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.audit.Count")
+	defer span.Finish()
+
+	req.Order, req.Limit, req.Offset, req.Cursor = nil, nil, nil, ""
+
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return 0, err
+	}
+	query.Select("count(*)")
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err = applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return 0, err
+	}
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var total int
+	err = repo.DbConn.QueryRowContext(ctx, queryStr, args...).Scan(&total)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "count audit events failed")
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// find internal method for getting all the audit events from the database using a select query.
+func find(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB, query *sqlbuilder.SelectBuilder, args []interface{}) (AuditEvents, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.audit.Find")
+	defer span.Finish()
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err := applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return nil, err
+	}
+
+	queryStr, queryArgs := query.Build()
+	queryStr = dbConn.Rebind(queryStr)
+	args = append(args, queryArgs...)
+
+	// Fetch all entries from the db.
+	rows, err := dbConn.QueryContext(ctx, queryStr, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find audit events failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over each row.
+	resp := []*AuditEvent{}
+	for rows.Next() {
+		var (
+			m          AuditEvent
+			entityType string
+			action     string
+		)
+		err = rows.Scan(&m.ID, &m.AccountID, &m.ActorUserID, &m.RootUserID, &entityType, &m.EntityID, &action, &m.Diff, &m.RequestIP, &m.CreatedAt)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+		m.EntityType = EntityType(entityType)
+		m.Action = Action(action)
+
+		resp = append(resp, &m)
+	}
+
+	return resp, nil
+}
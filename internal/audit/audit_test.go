@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	test *tests.Test
+	repo *Repository
+)
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+
+	repo = NewRepository(test.MasterDB)
+
+	return m.Run()
+}
+
+// TestNewDiff validates only the changed fields are included in the diff and sensitive fields are excluded.
+func TestNewDiff(t *testing.T) {
+	type entity struct {
+		Name     string  `json:"name"`
+		Timezone *string `json:"timezone,omitempty"`
+		Password string  `json:"password,omitempty"`
+	}
+
+	tz := "America/Anchorage"
+
+	var diffTests = []struct {
+		name     string
+		before   interface{}
+		after    interface{}
+		expected AuditDiff
+	}{
+		{"Create",
+			nil,
+			entity{Name: "Moon Launch", Password: "secret"},
+			AuditDiff{"name": AuditChange{After: "Moon Launch"}},
+		},
+		{"Update",
+			entity{Name: "Moon Launch"},
+			entity{Name: "Mars Launch", Timezone: &tz},
+			AuditDiff{
+				"name":     AuditChange{Before: "Moon Launch", After: "Mars Launch"},
+				"timezone": AuditChange{After: tz},
+			},
+		},
+		{"Unchanged",
+			entity{Name: "Moon Launch"},
+			entity{Name: "Moon Launch"},
+			nil,
+		},
+		{"Delete",
+			&entity{Name: "Moon Launch"},
+			(*entity)(nil),
+			AuditDiff{"name": AuditChange{Before: "Moon Launch"}},
+		},
+	}
+
+	t.Log("Given the need to record the fields changed for an entity.")
+	{
+		for i, tt := range diffTests {
+			t.Logf("\tTest: %d\tWhen running test: %s", i, tt.name)
+			{
+				res, err := NewDiff(tt.before, tt.after)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tNewDiff failed.", tests.Failed)
+				}
+
+				if diff := cmp.Diff(res, tt.expected); diff != "" {
+					t.Fatalf("\t%s\tExpected diff to match. Diff:\n%s", tests.Failed, diff)
+				}
+				t.Logf("\t%s\tNewDiff ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestRecordFind validates events are recorded with the actor from the claims and ensures ACLs are correctly
+// applied when finding events.
+func TestRecordFind(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	accountID := uuid.NewRandom().String()
+	userID := uuid.NewRandom().String()
+	rootUserID := uuid.NewRandom().String()
+	entityID := uuid.NewRandom().String()
+
+	// Claims for a virtual login by the root user.
+	claims := auth.Claims{
+		RootUserID: rootUserID,
+		Roles:      []string{auth.RoleAdmin},
+		StandardClaims: jwt.StandardClaims{
+			Audience: accountID,
+			Subject:  userID,
+		},
+	}
+
+	t.Log("Given the need to record changes made to an entity.")
+	{
+		ev, err := Record(ctx, test.MasterDB, claims, AuditEventRecordRequest{
+			EntityType: EntityType_Project,
+			EntityID:   entityID,
+			Action:     Action_Update,
+			Before:     map[string]interface{}{"name": "Moon Launch"},
+			After:      map[string]interface{}{"name": "Mars Launch"},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRecord failed.", tests.Failed)
+		}
+
+		if ev.AccountID == nil || *ev.AccountID != accountID {
+			t.Fatalf("\t%s\tExpected account ID %s.", tests.Failed, accountID)
+		} else if ev.ActorUserID == nil || *ev.ActorUserID != userID {
+			t.Fatalf("\t%s\tExpected actor user ID %s.", tests.Failed, userID)
+		} else if ev.RootUserID == nil || *ev.RootUserID != rootUserID {
+			t.Fatalf("\t%s\tExpected root user ID %s.", tests.Failed, rootUserID)
+		}
+		t.Logf("\t%s\tRecord ok.", tests.Success)
+
+		// Find the event with the claims.
+		res, err := repo.Find(ctx, claims, AuditEventFindRequest{
+			Filter: filter.Filter{
+				{Field: "entity_id", Operator: filter.Op_Eq, Values: []string{entityID}},
+			},
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tFind failed.", tests.Failed)
+		} else if diff := cmp.Diff(res, AuditEvents{ev}); diff != "" {
+			t.Fatalf("\t%s\tExpected find result to match record. Diff:\n%s", tests.Failed, diff)
+		}
+		t.Logf("\t%s\tFind ok.", tests.Success)
+
+		// Claims without the audit:read permission are forbidden.
+		_, err = repo.Find(ctx, auth.Claims{
+			Roles: []string{auth.RoleUser},
+			StandardClaims: jwt.StandardClaims{
+				Audience: accountID,
+				Subject:  userID,
+			},
+		}, AuditEventFindRequest{})
+		if errors.Cause(err) != ErrForbidden {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrForbidden)
+			t.Fatalf("\t%s\tFind with role user failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tFind with role user ok.", tests.Success)
+
+		// Claims for a different account should not return the event.
+		res, err = repo.Find(ctx, auth.Claims{
+			Roles: []string{auth.RoleAdmin},
+			StandardClaims: jwt.StandardClaims{
+				Audience: uuid.NewRandom().String(),
+				Subject:  userID,
+			},
+		}, AuditEventFindRequest{
+			Filter: filter.Filter{
+				{Field: "entity_id", Operator: filter.Op_Eq, Values: []string{entityID}},
+			},
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tFind with different account failed.", tests.Failed)
+		} else if len(res) != 0 {
+			t.Fatalf("\t%s\tExpected no events for a different account.", tests.Failed)
+		}
+		t.Logf("\t%s\tFind with different account ok.", tests.Success)
+	}
+}
@@ -0,0 +1,286 @@
+package audit
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Repository defines the required dependencies for AuditEvent.
+type Repository struct {
+	DbConn *sqlx.DB
+}
+
+// NewRepository creates a new Repository that defines dependencies for AuditEvent.
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		DbConn: db,
+	}
+}
+
+// AuditEvent records a single change made to an entity.
+type AuditEvent struct {
+	ID          string     `json:"id" validate:"required,uuid" example:"3c4a5b2e-7a55-4f3b-8e4f-0d41c8a6ce2a"`
+	AccountID   *string    `json:"account_id,omitempty" validate:"omitempty,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	ActorUserID *string    `json:"actor_user_id,omitempty" validate:"omitempty,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	RootUserID  *string    `json:"root_user_id,omitempty" validate:"omitempty,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	EntityType  EntityType `json:"entity_type" validate:"required" example:"project"`
+	EntityID    string     `json:"entity_id" validate:"required" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	Action      Action     `json:"action" validate:"required" example:"update"`
+	Diff        AuditDiff  `json:"diff,omitempty"`
+	RequestIP   string     `json:"request_ip" example:"68.69.35.104"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// AuditEventResponse represents an audit event that is returned for display.
+type AuditEventResponse struct {
+	ID          string           `json:"id" example:"3c4a5b2e-7a55-4f3b-8e4f-0d41c8a6ce2a"`
+	AccountID   string           `json:"account_id,omitempty" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	ActorUserID string           `json:"actor_user_id,omitempty" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	RootUserID  string           `json:"root_user_id,omitempty" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	EntityType  string           `json:"entity_type" example:"project"`
+	EntityID    string           `json:"entity_id" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	Action      string           `json:"action" example:"update"`
+	Diff        AuditDiff        `json:"diff,omitempty"`
+	RequestIP   string           `json:"request_ip" example:"68.69.35.104"`
+	CreatedAt   web.TimeResponse `json:"created_at"` // CreatedAt contains multiple format options for display.
+}
+
+// Response transforms AuditEvent and AuditEventResponse that is used for display.
+// Additional filtering by context values or translations could be applied.
+func (m *AuditEvent) Response(ctx context.Context) *AuditEventResponse {
+	if m == nil {
+		return nil
+	}
+
+	r := &AuditEventResponse{
+		ID:         m.ID,
+		EntityType: string(m.EntityType),
+		EntityID:   m.EntityID,
+		Action:     string(m.Action),
+		Diff:       m.Diff,
+		RequestIP:  m.RequestIP,
+		CreatedAt:  web.NewTimeResponse(ctx, m.CreatedAt),
+	}
+
+	if m.AccountID != nil {
+		r.AccountID = *m.AccountID
+	}
+	if m.ActorUserID != nil {
+		r.ActorUserID = *m.ActorUserID
+	}
+	if m.RootUserID != nil {
+		r.RootUserID = *m.RootUserID
+	}
+
+	return r
+}
+
+// AuditEvents a list of AuditEvents.
+type AuditEvents []*AuditEvent
+
+// Response transforms a list of AuditEvents to a list of AuditEventResponses.
+func (m *AuditEvents) Response(ctx context.Context) []*AuditEventResponse {
+	var l []*AuditEventResponse
+	if m != nil && len(*m) > 0 {
+		for _, n := range *m {
+			l = append(l, n.Response(ctx))
+		}
+	}
+
+	return l
+}
+
+// AuditEventRecordRequest contains the information needed to record a change to an entity. Before and After
+// are the states of the entity, either can be nil when the entity was created or deleted. For updates, After
+// can be the update request so only the fields provided are compared.
+type AuditEventRecordRequest struct {
+	AccountID   string      `json:"account_id" validate:"omitempty,uuid"`
+	ActorUserID string      `json:"actor_user_id" validate:"omitempty,uuid"`
+	EntityType  EntityType  `json:"entity_type" validate:"required"`
+	EntityID    string      `json:"entity_id" validate:"required"`
+	Action      Action      `json:"action" validate:"required"`
+	Before      interface{} `json:"-"`
+	After       interface{} `json:"-"`
+}
+
+// AuditEventFindRequest defines the possible options to search for audit events.
+type AuditEventFindRequest struct {
+	Filter filter.Filter `json:"filter,omitempty"`
+	Order  []string      `json:"order" example:"created_at desc"`
+	Limit  *uint         `json:"limit" example:"10"`
+	Offset *uint         `json:"offset" example:"20"`
+	Cursor string        `json:"cursor,omitempty" example:"eyJvIjpbImlkIGFzYyJdLCJ2IjpbIjk4NWYxNzQ2Il19"`
+}
+
+// AuditEventFindFields defines the fields of audit event that can be used to filter and sort a find request.
+var AuditEventFindFields = filter.Fields{
+	{Name: "id", Type: filter.FieldType_UUID, Filterable: true, Key: true},
+	{Name: "actor_user_id", Type: filter.FieldType_UUID, Filterable: true},
+	{Name: "root_user_id", Type: filter.FieldType_UUID, Filterable: true},
+	{Name: "entity_type", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "entity_id", Type: filter.FieldType_String, Filterable: true},
+	{Name: "action", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "request_ip", Type: filter.FieldType_String, Filterable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+}
+
+// EntityType represents the type of entity changed.
+type EntityType string
+
+// EntityType values define the entity_type field of an audit event.
+const (
+	EntityType_Account           EntityType = "account"
+	EntityType_AccountPreference EntityType = "account_preference"
+	EntityType_AccountRole       EntityType = "account_role"
+	EntityType_AccountSso        EntityType = "account_sso"
+	EntityType_ApiKey            EntityType = "api_key"
+	EntityType_Project           EntityType = "project"
+	EntityType_Subscription      EntityType = "subscription"
+	EntityType_User              EntityType = "user"
+	EntityType_UserAccount       EntityType = "user_account"
+)
+
+// Action represents the change made to an entity.
+type Action string
+
+// Action values define the action field of an audit event.
+const (
+	Action_Create         Action = "create"
+	Action_Update         Action = "update"
+	Action_Archive        Action = "archive"
+	Action_Restore        Action = "restore"
+	Action_Delete         Action = "delete"
+	Action_Cancel         Action = "cancel"
+	Action_UpdatePassword Action = "update_password"
+	Action_Invite         Action = "invite"
+	Action_AcceptInvite   Action = "accept_invite"
+	Action_MfaEnable      Action = "mfa_enable"
+	Action_MfaDisable     Action = "mfa_disable"
+	Action_VerifyEmail    Action = "verify_email"
+	Action_LinkIdentity   Action = "link_identity"
+	Action_TransferOwner  Action = "transfer_owner"
+	Action_Close          Action = "close"
+	Action_Purge          Action = "purge"
+)
+
+// AuditChange is the before and after value of a single field.
+type AuditChange struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// AuditDiff is the set of fields changed keyed by the JSON field name.
+type AuditDiff map[string]AuditChange
+
+// sensitiveFields are never recorded in a diff.
+var sensitiveFields = map[string]bool{
+	"password":         true,
+	"password_confirm": true,
+	"password_hash":    true,
+	"password_salt":    true,
+	"mfa_secret":       true,
+	"updated_at":       true,
+}
+
+// NewDiff compares the JSON representation of before and after. When both are provided, only the fields
+// included in after are compared which allows update requests with optional fields to be passed as after.
+func NewDiff(before, after interface{}) (AuditDiff, error) {
+	b, err := diffFields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := diffFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := make(AuditDiff)
+	switch {
+	case b == nil:
+		for k, v := range a {
+			diff[k] = AuditChange{After: v}
+		}
+	case a == nil:
+		for k, v := range b {
+			diff[k] = AuditChange{Before: v}
+		}
+	default:
+		for k, v := range a {
+			if !reflect.DeepEqual(b[k], v) {
+				diff[k] = AuditChange{Before: b[k], After: v}
+			}
+		}
+	}
+
+	if len(diff) == 0 {
+		return nil, nil
+	}
+
+	return diff, nil
+}
+
+// diffFields converts the value to a map of fields using its JSON representation.
+func diffFields(v interface{}) (map[string]interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, nil
+	}
+
+	dat, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(dat, &fields); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for k := range fields {
+		if sensitiveFields[k] {
+			delete(fields, k)
+		}
+	}
+
+	return fields, nil
+}
+
+// Scan supports reading the AuditDiff value from the database.
+func (s *AuditDiff) Scan(value interface{}) error {
+	if value == nil {
+		*s = nil
+		return nil
+	}
+
+	asBytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("Scan source is not []byte")
+	}
+
+	return json.Unmarshal(asBytes, s)
+}
+
+// Value converts the AuditDiff value to be stored in the database.
+func (s AuditDiff) Value() (driver.Value, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+
+	dat, err := json.Marshal(s)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return string(dat), nil
+}
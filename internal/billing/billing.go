@@ -0,0 +1,983 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// The database table for Subscription
+	subscriptionTableName = "subscriptions"
+	// The database table for Invoice
+	invoiceTableName = "invoices"
+	// The database table for the provider events that have been applied.
+	billingEventTableName = "billing_events"
+	// The database table for the users of an account used to count the seats.
+	userAccountTableName = "users_accounts"
+)
+
+var (
+	// ErrNotFound abstracts the postgres not found error.
+	ErrNotFound = errors.New("Entity not found")
+
+	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrInvalidPlan occurs when a plan is not defined.
+	ErrInvalidPlan = errors.New("Invalid plan")
+
+	// ErrSubscriptionExists occurs when an account subscribes while it already has a subscription.
+	ErrSubscriptionExists = errors.New("Account already has a subscription")
+
+	// ErrSeatLimit occurs when the account has more users than the plan allows.
+	ErrSeatLimit = errors.New("Number of users exceeds the seats of the plan")
+)
+
+// subscriptionMapColumns is the list of columns needed for find.
+var subscriptionMapColumns = "id,account_id,plan_id,status,seats,provider_customer_id,provider_subscription_id,trial_ends_at,current_period_end,cancel_at_period_end,canceled_at,provider_event_at,created_at,updated_at"
+
+// invoiceMapColumns is the list of columns needed for find.
+var invoiceMapColumns = "id,account_id,subscription_id,provider_invoice_id,number,status,amount_due,amount_paid,currency,hosted_url,period_start,period_end,created_at,updated_at"
+
+// CanReadBilling determines if claims has the authority to access the billing for the specified account ID.
+func CanReadBilling(ctx context.Context, claims auth.Claims, accountID string) error {
+	// Claims are empty, request is internal.
+	if claims.Audience == "" && claims.Subject == "" {
+		return nil
+	}
+
+	if claims.Audience != accountID || !claims.HasPermission(auth.PermissionBillingRead) {
+		return errors.WithStack(ErrForbidden)
+	}
+
+	return nil
+}
+
+// CanModifyBilling determines if claims has the authority to change the subscription for the specified account ID.
+func CanModifyBilling(ctx context.Context, claims auth.Claims, accountID string) error {
+	// Claims are empty, request is internal.
+	if claims.Audience == "" && claims.Subject == "" {
+		return nil
+	}
+
+	if claims.Audience != accountID || !claims.HasPermission(auth.PermissionBillingWrite) {
+		return errors.WithStack(ErrForbidden)
+	}
+
+	return nil
+}
+
+// applyClaimsSelect applies a sub-query to the provided query to enforce ACL based on the claims provided.
+// 	1. No claims, request is internal, no ACL applied
+// 	2. Users with the billing:read permission can access the invoices for their account ID
+func applyClaimsSelect(ctx context.Context, claims auth.Claims, query *sqlbuilder.SelectBuilder) error {
+	// Claims are empty, don't apply any ACL
+	if claims.Audience == "" && claims.Subject == "" {
+		return nil
+	}
+
+	if !claims.HasPermission(auth.PermissionBillingRead) {
+		return errors.WithStack(ErrForbidden)
+	}
+
+	query.Where(query.Equal("account_id", claims.Audience))
+	return nil
+}
+
+// FindPlans returns the plans accounts can subscribe to.
+func (repo *Repository) FindPlans() Plans {
+	return repo.Plans
+}
+
+// Seats returns the number of seats used by the account. Every user that is active or invited uses a seat.
+func (repo *Repository) Seats(ctx context.Context, accountID string) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.billing.Seats")
+	defer span.Finish()
+
+	query := sqlbuilder.NewSelectBuilder()
+	query.Select("count(*)")
+	query.From(userAccountTableName)
+	query.Where(query.Equal("account_id", accountID))
+	query.Where(query.NotEqual("status", "disabled"))
+	query.Where(query.IsNull("archived_at"))
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var seats int
+	err := repo.DbConn.QueryRowContext(ctx, queryStr, args...).Scan(&seats)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "count seats for account %s failed", accountID)
+		return 0, err
+	}
+
+	// Subscriptions always include at least one seat.
+	if seats < 1 {
+		seats = 1
+	}
+
+	return seats, nil
+}
+
+// findSubscriptions internal method for getting all the subscriptions from the database using a select query.
+func findSubscriptions(ctx context.Context, dbConn sqlx.ExtContext, query *sqlbuilder.SelectBuilder, args []interface{}) (Subscriptions, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.billing.FindSubscriptions")
+	defer span.Finish()
+
+	query.Select(subscriptionMapColumns)
+	query.From(subscriptionTableName)
+
+	queryStr, queryArgs := query.Build()
+	queryStr = dbConn.Rebind(queryStr)
+	args = append(args, queryArgs...)
+
+	// Fetch all entries from the db.
+	rows, err := dbConn.QueryContext(ctx, queryStr, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find subscriptions failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over each row.
+	resp := []*Subscription{}
+	for rows.Next() {
+		var m Subscription
+		err = rows.Scan(&m.ID, &m.AccountID, &m.PlanID, &m.Status, &m.Seats, &m.ProviderCustomerID, &m.ProviderSubscriptionID, &m.TrialEndsAt, &m.CurrentPeriodEnd, &m.CancelAtPeriodEnd, &m.CanceledAt, &m.ProviderEventAt, &m.CreatedAt, &m.UpdatedAt)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+
+		resp = append(resp, &m)
+	}
+
+	return resp, nil
+}
+
+// ReadSubscription gets the current subscription of the account from the database. When the account has
+// subscribed more than once, the latest subscription is returned.
+func (repo *Repository) ReadSubscription(ctx context.Context, claims auth.Claims, accountID string) (*Subscription, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.billing.ReadSubscription")
+	defer span.Finish()
+
+	// Ensure the claims can access the billing of the account.
+	if err := CanReadBilling(ctx, claims, accountID); err != nil {
+		return nil, err
+	}
+
+	query := sqlbuilder.NewSelectBuilder()
+	query.Where(query.Equal("account_id", accountID))
+	query.OrderBy("created_at desc")
+	query.Limit(1)
+
+	res, err := findSubscriptions(ctx, repo.DbConn, query, []interface{}{})
+	if err != nil {
+		return nil, err
+	} else if len(res) == 0 {
+		err = errors.WithMessagef(ErrNotFound, "subscription for account %s not found", accountID)
+		return nil, err
+	}
+
+	return res[0], nil
+}
+
+// readSubscriptionByProviderID gets the subscription for the ID of the subscription at the provider. The row of the
+// subscription is locked until the transaction completes so events for the same subscription are applied in turn.
+func readSubscriptionByProviderID(ctx context.Context, tx *sqlx.Tx, providerSubscriptionID string) (*Subscription, error) {
+	lockQuery := sqlbuilder.NewSelectBuilder().Select("id").From(subscriptionTableName)
+	lockQuery.Where(lockQuery.Equal("provider_subscription_id", providerSubscriptionID))
+	lockQueryStr, args := lockQuery.Build()
+	lockQueryStr = tx.Rebind(lockQueryStr + " FOR UPDATE")
+
+	var id string
+	err := tx.QueryRowContext(ctx, lockQueryStr, args...).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.WithMessagef(ErrNotFound, "subscription %s not found", providerSubscriptionID)
+		}
+		err = errors.Wrapf(err, "query - %s FOR UPDATE", lockQuery.String())
+		err = errors.WithMessagef(err, "lock subscription %s failed", providerSubscriptionID)
+		return nil, err
+	}
+
+	query := sqlbuilder.NewSelectBuilder()
+	query.Where(query.Equal("id", id))
+
+	res, err := findSubscriptions(ctx, tx, query, []interface{}{})
+	if err != nil {
+		return nil, err
+	} else if len(res) == 0 {
+		err = errors.WithMessagef(ErrNotFound, "subscription %s not found", providerSubscriptionID)
+		return nil, err
+	}
+
+	return res[0], nil
+}
+
+// CreateSubscription subscribes the account to a plan. The number of seats is the number of users of the account.
+// The first subscription of an account starts with the trial period of the plan.
+func (repo *Repository) CreateSubscription(ctx context.Context, claims auth.Claims, req SubscriptionCreateRequest, now time.Time) (*Subscription, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.billing.CreateSubscription")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the claims can modify the billing of the account.
+	err = CanModifyBilling(ctx, claims, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, ok := repo.Plans.Lookup(req.PlanID)
+	if !ok {
+		return nil, errors.WithMessagef(ErrInvalidPlan, "plan %s not found", req.PlanID)
+	}
+
+	// Accounts can subscribe again once the previous subscription has been canceled.
+	cur, err := repo.ReadSubscription(ctx, auth.Claims{}, req.AccountID)
+	if err != nil && errors.Cause(err) != ErrNotFound {
+		return nil, err
+	} else if cur != nil && cur.Status != SubscriptionStatus_Canceled {
+		return nil, errors.WithMessagef(ErrSubscriptionExists, "account %s has subscription %s", req.AccountID, cur.ID)
+	}
+
+	seats, err := repo.Seats(ctx, req.AccountID)
+	if err != nil {
+		return nil, err
+	} else if plan.MaxSeats > 0 && seats > plan.MaxSeats {
+		return nil, errors.WithMessagef(ErrSeatLimit, "plan %s allows %d seats, account has %d users", plan.ID, plan.MaxSeats, seats)
+	}
+
+	acc, err := repo.Account.Read(ctx, auth.Claims{}, account.AccountReadRequest{ID: req.AccountID})
+	if err != nil {
+		return nil, err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// The customer is reused when the account subscribes again.
+	var customerID string
+	if cur != nil {
+		customerID = cur.ProviderCustomerID
+	} else {
+		customerID, err = repo.Provider.CreateCustomer(ctx, ProviderCustomerRequest{
+			AccountID: acc.ID,
+			Name:      acc.Name,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	preq := ProviderSubscriptionRequest{
+		AccountID:  acc.ID,
+		CustomerID: customerID,
+		PriceID:    plan.priceID(),
+		Quantity:   seats,
+	}
+	if plan.TrialDays > 0 && cur == nil {
+		trialEnd := now.AddDate(0, 0, plan.TrialDays)
+		preq.TrialEnd = &trialEnd
+	}
+
+	ps, err := repo.Provider.CreateSubscription(ctx, preq)
+	if err != nil {
+		return nil, err
+	}
+
+	m := Subscription{
+		ID:                     uuid.NewRandom().String(),
+		AccountID:              acc.ID,
+		PlanID:                 plan.ID,
+		Seats:                  seats,
+		ProviderCustomerID:     customerID,
+		ProviderSubscriptionID: ps.ID,
+		CreatedAt:              now,
+		UpdatedAt:              now,
+	}
+	m.apply(ps)
+
+	// Build the insert SQL statement.
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(subscriptionTableName)
+	query.Cols(
+		"id",
+		"account_id",
+		"plan_id",
+		"status",
+		"seats",
+		"provider_customer_id",
+		"provider_subscription_id",
+		"trial_ends_at",
+		"current_period_end",
+		"cancel_at_period_end",
+		"canceled_at",
+		"created_at",
+		"updated_at",
+	)
+
+	query.Values(
+		m.ID,
+		m.AccountID,
+		m.PlanID,
+		m.Status,
+		m.Seats,
+		m.ProviderCustomerID,
+		m.ProviderSubscriptionID,
+		m.TrialEndsAt,
+		m.CurrentPeriodEnd,
+		m.CancelAtPeriodEnd,
+		m.CanceledAt,
+		m.CreatedAt,
+		m.UpdatedAt,
+	)
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "create subscription for account %s failed", m.AccountID)
+		return nil, err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  m.AccountID,
+		EntityType: audit.EntityType_Subscription,
+		EntityID:   m.ID,
+		Action:     audit.Action_Create,
+		After:      m,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// The user that subscribed is responsible for billing unless a user has already been set.
+	var billingUserID string
+	if acc.BillingUserID == nil || !acc.BillingUserID.Valid {
+		billingUserID = claims.Subject
+	}
+
+	err = repo.updateAccount(ctx, acc, m.Status, billingUserID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// UpdateSubscription changes the plan of the subscription for the account. The number of seats is updated to the
+// current number of users of the account.
+func (repo *Repository) UpdateSubscription(ctx context.Context, claims auth.Claims, req SubscriptionUpdateRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.billing.UpdateSubscription")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can modify the billing of the account.
+	err = CanModifyBilling(ctx, claims, req.AccountID)
+	if err != nil {
+		return err
+	}
+
+	plan, ok := repo.Plans.Lookup(req.PlanID)
+	if !ok {
+		return errors.WithMessagef(ErrInvalidPlan, "plan %s not found", req.PlanID)
+	}
+
+	cur, err := repo.ReadSubscription(ctx, auth.Claims{}, req.AccountID)
+	if err != nil {
+		return err
+	} else if cur.Status == SubscriptionStatus_Canceled {
+		return errors.WithMessagef(ErrNotFound, "subscription for account %s has been canceled", req.AccountID)
+	}
+
+	seats, err := repo.Seats(ctx, req.AccountID)
+	if err != nil {
+		return err
+	} else if plan.MaxSeats > 0 && seats > plan.MaxSeats {
+		return errors.WithMessagef(ErrSeatLimit, "plan %s allows %d seats, account has %d users", plan.ID, plan.MaxSeats, seats)
+	}
+
+	ps, err := repo.Provider.UpdateSubscription(ctx, cur.ProviderSubscriptionID, ProviderSubscriptionRequest{
+		AccountID:  cur.AccountID,
+		CustomerID: cur.ProviderCustomerID,
+		PriceID:    plan.priceID(),
+		Quantity:   seats,
+	})
+	if err != nil {
+		return err
+	}
+
+	before := *cur
+	cur.PlanID = plan.ID
+	cur.Seats = seats
+	cur.apply(ps)
+
+	return repo.saveSubscription(ctx, repo.DbConn, claims, &before, cur, audit.Action_Update, now)
+}
+
+// CancelSubscription cancels the subscription of the account. When canceled at the end of the period, the account
+// stays active until the provider sends the event the subscription has ended.
+func (repo *Repository) CancelSubscription(ctx context.Context, claims auth.Claims, req SubscriptionCancelRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.billing.CancelSubscription")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can modify the billing of the account.
+	err = CanModifyBilling(ctx, claims, req.AccountID)
+	if err != nil {
+		return err
+	}
+
+	cur, err := repo.ReadSubscription(ctx, auth.Claims{}, req.AccountID)
+	if err != nil {
+		return err
+	} else if cur.Status == SubscriptionStatus_Canceled {
+		return errors.WithMessagef(ErrNotFound, "subscription for account %s has been canceled", req.AccountID)
+	}
+
+	ps, err := repo.Provider.CancelSubscription(ctx, cur.ProviderSubscriptionID, req.AtPeriodEnd)
+	if err != nil {
+		return err
+	}
+
+	before := *cur
+	cur.apply(ps)
+
+	return repo.saveSubscription(ctx, repo.DbConn, claims, &before, cur, audit.Action_Cancel, now)
+}
+
+// SyncSeats updates the number of seats of the subscriptions that have changed since users were added or removed
+// from the accounts. It returns the number of subscriptions that were updated.
+func (repo *Repository) SyncSeats(ctx context.Context, now time.Time) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.billing.SyncSeats")
+	defer span.Finish()
+
+	query := sqlbuilder.NewSelectBuilder()
+	query.Where(query.NotEqual("status", SubscriptionStatus_Canceled))
+
+	subs, err := findSubscriptions(ctx, repo.DbConn, query, []interface{}{})
+	if err != nil {
+		return 0, err
+	}
+
+	// Continue with the remaining subscriptions when one fails so a single account can't block the others.
+	var (
+		updated  int
+		firstErr error
+	)
+	for _, sub := range subs {
+		seats, err := repo.Seats(ctx, sub.AccountID)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		} else if seats == sub.Seats {
+			continue
+		}
+
+		// Keep the price of the subscription when the plan is no longer offered.
+		priceID := sub.PlanID
+		if plan, ok := repo.Plans.Lookup(sub.PlanID); ok {
+			priceID = plan.priceID()
+		}
+
+		ps, err := repo.Provider.UpdateSubscription(ctx, sub.ProviderSubscriptionID, ProviderSubscriptionRequest{
+			AccountID:  sub.AccountID,
+			CustomerID: sub.ProviderCustomerID,
+			PriceID:    priceID,
+			Quantity:   seats,
+		})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		before := *sub
+		sub.Seats = seats
+		sub.apply(ps)
+
+		err = repo.saveSubscription(ctx, repo.DbConn, auth.Claims{}, &before, sub, audit.Action_Update, now)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		updated++
+	}
+
+	return updated, firstErr
+}
+
+// HandleEvent verifies and applies an event sent by the provider to the billing webhook. Changes to the status of a
+// subscription update the status of the account. Events are retried by the provider until they are acknowledged so
+// events that have already been applied are ignored. Events are not sent in order, events older than the last event
+// applied to the subscription don't change it.
+func (repo *Repository) HandleEvent(ctx context.Context, payload []byte, signature string, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.billing.HandleEvent")
+	defer span.Finish()
+
+	e, err := repo.Provider.ParseEvent(payload, signature, now)
+	if err != nil {
+		return err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// The event is recorded in the same transaction that applies it, the insert blocks when the event is being
+	// applied by another request and is rolled back with the changes when applying the event fails.
+	tx, err := repo.DbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Record the event so it's only applied once.
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(billingEventTableName)
+	query.Cols("id", "type", "created_at")
+	query.Values(e.ID, string(e.Type), now)
+
+	sql, args := query.Build()
+	sql = tx.Rebind(sql + " ON CONFLICT (id) DO NOTHING")
+	res, err := tx.ExecContext(ctx, sql, args...)
+	if err != nil {
+		tx.Rollback()
+
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "record billing event %s failed", e.ID)
+		return err
+	}
+
+	// The event has already been applied.
+	if n, err := res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return errors.WithStack(err)
+	} else if n == 0 {
+		tx.Rollback()
+		return nil
+	}
+
+	switch e.Type {
+	case ProviderEventType_SubscriptionUpdated, ProviderEventType_SubscriptionDeleted:
+		err = repo.applySubscriptionEvent(ctx, tx, e, now)
+	case ProviderEventType_InvoiceUpdated, ProviderEventType_InvoicePaid, ProviderEventType_InvoicePaymentFailed:
+		err = repo.applyInvoiceEvent(ctx, tx, e, now)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+// applySubscriptionEvent updates the subscription to the state sent by the provider. The event is ignored when a
+// newer event has already been applied to the subscription.
+func (repo *Repository) applySubscriptionEvent(ctx context.Context, tx *sqlx.Tx, e *ProviderEvent, now time.Time) error {
+	if e.Subscription == nil {
+		return nil
+	}
+
+	sub, err := readSubscriptionByProviderID(ctx, tx, e.Subscription.ID)
+	if err != nil {
+		// Ignore subscriptions that were not created by billing.
+		if errors.Cause(err) == ErrNotFound {
+			return nil
+		}
+		return err
+	} else if sub.isNewer(e) {
+		return nil
+	}
+
+	before := *sub
+	if plan, ok := repo.Plans.LookupPrice(e.Subscription.PriceID); ok {
+		sub.PlanID = plan.ID
+	}
+	sub.apply(e.Subscription)
+	sub.ProviderEventAt = nullTime(&e.CreatedAt)
+
+	if e.Type == ProviderEventType_SubscriptionDeleted {
+		sub.Status = SubscriptionStatus_Canceled
+	}
+
+	return repo.saveSubscription(ctx, tx, auth.Claims{}, &before, sub, audit.Action_Update, now)
+}
+
+// applyInvoiceEvent stores the invoice sent by the provider. The status of the subscription is updated when a
+// payment fails or when an invoice that was past due is paid, unless a newer event has already been applied to the
+// subscription.
+func (repo *Repository) applyInvoiceEvent(ctx context.Context, tx *sqlx.Tx, e *ProviderEvent, now time.Time) error {
+	inv := e.Invoice
+	if inv == nil || inv.SubscriptionID == "" {
+		return nil
+	}
+
+	sub, err := readSubscriptionByProviderID(ctx, tx, inv.SubscriptionID)
+	if err != nil {
+		// Ignore invoices for subscriptions that were not created by billing.
+		if errors.Cause(err) == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	// Build the insert SQL statement, the invoice is updated when it already exists.
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(invoiceTableName)
+	query.Cols(
+		"id",
+		"account_id",
+		"subscription_id",
+		"provider_invoice_id",
+		"number",
+		"status",
+		"amount_due",
+		"amount_paid",
+		"currency",
+		"hosted_url",
+		"period_start",
+		"period_end",
+		"created_at",
+		"updated_at",
+	)
+
+	query.Values(
+		uuid.NewRandom().String(),
+		sub.AccountID,
+		sub.ID,
+		inv.ID,
+		inv.Number,
+		inv.Status,
+		inv.AmountDue,
+		inv.AmountPaid,
+		inv.Currency,
+		inv.HostedUrl,
+		inv.PeriodStart,
+		inv.PeriodEnd,
+		now,
+		now,
+	)
+
+	sql, args := query.Build()
+	sql += ` ON CONFLICT (provider_invoice_id) DO UPDATE SET
+		number = EXCLUDED.number,
+		status = EXCLUDED.status,
+		amount_due = EXCLUDED.amount_due,
+		amount_paid = EXCLUDED.amount_paid,
+		hosted_url = EXCLUDED.hosted_url,
+		updated_at = EXCLUDED.updated_at`
+	sql = tx.Rebind(sql)
+	_, err = tx.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "save invoice %s failed", inv.ID)
+		return err
+	}
+
+	if sub.isNewer(e) {
+		return nil
+	}
+
+	before := *sub
+	switch e.Type {
+	case ProviderEventType_InvoicePaymentFailed:
+		if sub.Status != SubscriptionStatus_Active && sub.Status != SubscriptionStatus_Trialing {
+			return nil
+		}
+		sub.Status = SubscriptionStatus_PastDue
+	case ProviderEventType_InvoicePaid:
+		if sub.Status != SubscriptionStatus_PastDue && sub.Status != SubscriptionStatus_Unpaid && sub.Status != SubscriptionStatus_Incomplete {
+			return nil
+		}
+		sub.Status = SubscriptionStatus_Active
+	default:
+		return nil
+	}
+	sub.ProviderEventAt = nullTime(&e.CreatedAt)
+
+	return repo.saveSubscription(ctx, tx, auth.Claims{}, &before, sub, audit.Action_Update, now)
+}
+
+// saveSubscription updates the subscription in the database, records the change and updates the status of the
+// account to match the status of the subscription. The time of the provider event applied is never moved back.
+func (repo *Repository) saveSubscription(ctx context.Context, dbConn sqlx.ExtContext, claims auth.Claims, before, m *Subscription, action audit.Action, now time.Time) error {
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	m.UpdatedAt = now
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(subscriptionTableName)
+	query.Set(
+		query.Assign("plan_id", m.PlanID),
+		query.Assign("status", m.Status),
+		query.Assign("seats", m.Seats),
+		query.Assign("trial_ends_at", m.TrialEndsAt),
+		query.Assign("current_period_end", m.CurrentPeriodEnd),
+		query.Assign("cancel_at_period_end", m.CancelAtPeriodEnd),
+		query.Assign("canceled_at", m.CanceledAt),
+		"provider_event_at = GREATEST(provider_event_at, "+query.Var(m.ProviderEventAt)+")",
+		query.Assign("updated_at", m.UpdatedAt),
+	)
+	query.Where(query.Equal("id", m.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = dbConn.Rebind(sql)
+	_, err := dbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "update subscription %s failed", m.ID)
+		return err
+	}
+
+	_, err = audit.Record(ctx, dbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  m.AccountID,
+		EntityType: audit.EntityType_Subscription,
+		EntityID:   m.ID,
+		Action:     action,
+		Before:     before,
+		After:      m,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	acc, err := repo.Account.Read(ctx, auth.Claims{}, account.AccountReadRequest{ID: m.AccountID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
+	return repo.updateAccount(ctx, acc, m.Status, "", now)
+}
+
+// updateAccount sets the status of the account for the status of the subscription and the user responsible for
+// billing when provided. The account is only updated when a value has changed.
+func (repo *Repository) updateAccount(ctx context.Context, acc *account.Account, status SubscriptionStatus, billingUserID string, now time.Time) error {
+	req := account.AccountUpdateRequest{
+		ID: acc.ID,
+	}
+
+	var changed bool
+	if s := status.AccountStatus(); acc.Status != s {
+		req.Status = &s
+		changed = true
+	}
+	if billingUserID != "" {
+		req.BillingUserID = &billingUserID
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return repo.Account.Update(ctx, auth.Claims{}, req, now)
+}
+
+// apply copies the state of the subscription at the provider.
+func (m *Subscription) apply(ps *ProviderSubscription) {
+	m.Status = ps.Status
+	if ps.Quantity > 0 {
+		m.Seats = ps.Quantity
+	}
+	m.TrialEndsAt = nullTime(ps.TrialEnd)
+	m.CurrentPeriodEnd = nullTime(ps.CurrentPeriodEnd)
+	m.CancelAtPeriodEnd = ps.CancelAtPeriodEnd
+	m.CanceledAt = nullTime(ps.CanceledAt)
+}
+
+// isNewer determines if a provider event sent after the event has already been applied to the subscription.
+func (m *Subscription) isNewer(e *ProviderEvent) bool {
+	return m.ProviderEventAt != nil && m.ProviderEventAt.Valid && e.CreatedAt.Before(m.ProviderEventAt.Time)
+}
+
+// nullTime converts the optional time to a nullable time for the database.
+func nullTime(t *time.Time) *pq.NullTime {
+	if t == nil {
+		return nil
+	}
+	return &pq.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// invoiceFindRequestQuery generates the select query for the given find request.
+func invoiceFindRequestQuery(req InvoiceFindRequest) (*sqlbuilder.SelectBuilder, error) {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Select(invoiceMapColumns)
+	query.From(invoiceTableName)
+
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, InvoiceFindFields); err != nil {
+			return nil, err
+		}
+	}
+	if req.Cursor != "" {
+		if err := InvoiceFindFields.ApplyCursor(query, req.Order, req.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(req.Order) > 0 {
+		query.OrderBy(req.Order...)
+	}
+
+	if req.Limit != nil {
+		query.Limit(int(*req.Limit))
+	}
+
+	if req.Offset != nil {
+		query.Offset(int(*req.Offset))
+	}
+
+	return query, nil
+}
+
+// FindInvoices gets all the invoices from the database based on the request params.
+func (repo *Repository) FindInvoices(ctx context.Context, claims auth.Claims, req InvoiceFindRequest) (Invoices, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.billing.FindInvoices")
+	defer span.Finish()
+
+	if len(req.Order) == 0 {
+		req.Order = []string{"created_at desc"}
+	}
+
+	query, err := invoiceFindRequestQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err = applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return nil, err
+	}
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	// Fetch all entries from the db.
+	rows, err := repo.DbConn.QueryContext(ctx, queryStr, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find invoices failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over each row.
+	resp := []*Invoice{}
+	for rows.Next() {
+		var m Invoice
+		err = rows.Scan(&m.ID, &m.AccountID, &m.SubscriptionID, &m.ProviderInvoiceID, &m.Number, &m.Status, &m.AmountDue, &m.AmountPaid, &m.Currency, &m.HostedUrl, &m.PeriodStart, &m.PeriodEnd, &m.CreatedAt, &m.UpdatedAt)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+
+		resp = append(resp, &m)
+	}
+
+	return resp, nil
+}
+
+// CountInvoices gets the total number of invoices from the database that match the request params. The order,
+// limit, offset and cursor of the request are ignored.
+func (repo *Repository) CountInvoices(ctx context.Context, claims auth.Claims, req InvoiceFindRequest) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.billing.CountInvoices")
+	defer span.Finish()
+
+	req.Order, req.Limit, req.Offset, req.Cursor = nil, nil, nil, ""
+
+	query, err := invoiceFindRequestQuery(req)
+	if err != nil {
+		return 0, err
+	}
+	query.Select("count(*)")
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err = applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return 0, err
+	}
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var total int
+	err = repo.DbConn.QueryRowContext(ctx, queryStr, args...).Scan(&total)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "count invoices failed")
+		return 0, err
+	}
+
+	return total, nil
+}
@@ -0,0 +1,271 @@
+package billing
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+var (
+	test     *tests.Test
+	repo     *Repository
+	provider *FakeProvider
+)
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+
+	provider = NewFakeProvider("whsec_test")
+	repo = NewRepository(test.MasterDB, account.NewRepository(test.MasterDB), provider)
+
+	return m.Run()
+}
+
+// mockSubscription creates a new account with an admin user that is subscribed to the plan.
+func mockSubscription(t *testing.T, planID string, now time.Time) (*Subscription, auth.Claims) {
+	ctx := tests.Context()
+
+	ua, err := user_account.MockUserAccount(ctx, test.MasterDB, now, user_account.UserAccountRole_Admin)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tCreate user account failed.", tests.Failed)
+	}
+
+	claims := auth.Claims{
+		Roles: []string{auth.RoleAdmin},
+		StandardClaims: jwt.StandardClaims{
+			Subject:  ua.UserID,
+			Audience: ua.AccountID,
+		},
+	}
+
+	sub, err := repo.CreateSubscription(ctx, claims, SubscriptionCreateRequest{
+		AccountID: ua.AccountID,
+		PlanID:    planID,
+	}, now)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tCreate subscription failed.", tests.Failed)
+	}
+
+	return sub, claims
+}
+
+// readAccountStatus returns the status of the account.
+func readAccountStatus(t *testing.T, accountID string) account.AccountStatus {
+	acc, err := repo.Account.Read(tests.Context(), auth.Claims{}, account.AccountReadRequest{ID: accountID})
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tRead account failed.", tests.Failed)
+	}
+	return acc.Status
+}
+
+// TestCreateSubscription validates a subscription starts with a trial and the seats are the users of the account.
+func TestCreateSubscription(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2019, time.August, 18, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	sub, claims := mockSubscription(t, "team", now)
+
+	if sub.Status != SubscriptionStatus_Trialing {
+		t.Fatalf("\t%s\tExpected status %s, got %s.", tests.Failed, SubscriptionStatus_Trialing, sub.Status)
+	} else if sub.Seats != 1 {
+		t.Fatalf("\t%s\tExpected 1 seat, got %d.", tests.Failed, sub.Seats)
+	} else if sub.TrialEndsAt == nil || !sub.TrialEndsAt.Time.Equal(now.AddDate(0, 0, 14)) {
+		t.Fatalf("\t%s\tExpected trial to end in 14 days, got %v.", tests.Failed, sub.TrialEndsAt)
+	}
+	t.Logf("\t%s\tCreate subscription ok.", tests.Success)
+
+	acc, err := repo.Account.Read(ctx, auth.Claims{}, account.AccountReadRequest{ID: sub.AccountID})
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tRead account failed.", tests.Failed)
+	} else if acc.BillingUserID == nil || acc.BillingUserID.String != claims.Subject {
+		t.Fatalf("\t%s\tExpected billing user to be set to %s.", tests.Failed, claims.Subject)
+	}
+	t.Logf("\t%s\tBilling user set ok.", tests.Success)
+
+	// A second subscription for the account is rejected.
+	_, err = repo.CreateSubscription(ctx, claims, SubscriptionCreateRequest{
+		AccountID: sub.AccountID,
+		PlanID:    "starter",
+	}, now)
+	if errors.Cause(err) != ErrSubscriptionExists {
+		t.Logf("\t\tGot : %+v", err)
+		t.Fatalf("\t%s\tExpected ErrSubscriptionExists.", tests.Failed)
+	}
+	t.Logf("\t%s\tDuplicate subscription rejected ok.", tests.Success)
+
+	// Other accounts can't read the subscription.
+	otherClaims := claims
+	otherClaims.Audience = "c4653bf9-5978-48b7-89c5-95704aebb7e2"
+	_, err = repo.ReadSubscription(ctx, otherClaims, sub.AccountID)
+	if errors.Cause(err) != ErrForbidden {
+		t.Logf("\t\tGot : %+v", err)
+		t.Fatalf("\t%s\tExpected ErrForbidden.", tests.Failed)
+	}
+	t.Logf("\t%s\tRead subscription forbidden ok.", tests.Success)
+
+	// Changing the plan is applied at the provider.
+	err = repo.UpdateSubscription(ctx, claims, SubscriptionUpdateRequest{
+		AccountID: sub.AccountID,
+		PlanID:    "business",
+	}, now)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tUpdate subscription failed.", tests.Failed)
+	}
+
+	ps, _ := provider.Subscription(sub.ProviderSubscriptionID)
+	if ps.PriceID != "business" {
+		t.Fatalf("\t%s\tExpected provider price business, got %s.", tests.Failed, ps.PriceID)
+	}
+	t.Logf("\t%s\tUpdate subscription ok.", tests.Success)
+}
+
+// TestHandleEvent validates provider events update the subscription and the status of the account.
+func TestHandleEvent(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2019, time.August, 18, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	sub, _ := mockSubscription(t, "starter", now)
+
+	// Events with an invalid signature are rejected.
+	{
+		payload, _, err := provider.SetSubscriptionStatus(sub.ProviderSubscriptionID, SubscriptionStatus_Active, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate event failed.", tests.Failed)
+		}
+
+		err = repo.HandleEvent(ctx, payload, "t=1566086400,v1=invalid", now)
+		if errors.Cause(err) != ErrInvalidSignature {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tExpected ErrInvalidSignature.", tests.Failed)
+		}
+		t.Logf("\t%s\tInvalid signature rejected ok.", tests.Success)
+	}
+
+	// A failed payment sets the subscription past due and the account pending.
+	{
+		payload, signature, err := provider.Event("invoice.payment_failed", &ProviderInvoice{
+			ID:             "in_fake1",
+			CustomerID:     sub.ProviderCustomerID,
+			SubscriptionID: sub.ProviderSubscriptionID,
+			Number:         "A1B2C3D4-0001",
+			Status:         InvoiceStatus_Open,
+			AmountDue:      900,
+			Currency:       "usd",
+			PeriodStart:    now,
+			PeriodEnd:      now.AddDate(0, 1, 0),
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate event failed.", tests.Failed)
+		}
+
+		// Events are applied once, the provider retries until the event is acknowledged.
+		for i := 0; i < 2; i++ {
+			if err := repo.HandleEvent(ctx, payload, signature, now); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tHandle event failed.", tests.Failed)
+			}
+		}
+
+		res, err := repo.ReadSubscription(ctx, auth.Claims{}, sub.AccountID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead subscription failed.", tests.Failed)
+		} else if res.Status != SubscriptionStatus_PastDue {
+			t.Fatalf("\t%s\tExpected status %s, got %s.", tests.Failed, SubscriptionStatus_PastDue, res.Status)
+		}
+
+		if s := readAccountStatus(t, sub.AccountID); s != account.AccountStatus_Pending {
+			t.Fatalf("\t%s\tExpected account status %s, got %s.", tests.Failed, account.AccountStatus_Pending, s)
+		}
+
+		invoices, err := repo.FindInvoices(ctx, auth.Claims{}, InvoiceFindRequest{
+			Filter: filter.Filter{
+				{Field: "account_id", Operator: filter.Op_Eq, Values: []string{sub.AccountID}},
+			},
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tFind invoices failed.", tests.Failed)
+		} else if len(invoices) != 1 || invoices[0].Status != InvoiceStatus_Open {
+			t.Fatalf("\t%s\tExpected 1 open invoice, got %d.", tests.Failed, len(invoices))
+		}
+		t.Logf("\t%s\tPayment failed ok.", tests.Success)
+	}
+
+	// The subscription is deleted by the provider when the payment is not made, the account is disabled.
+	{
+		payload, signature, err := provider.SetSubscriptionStatus(sub.ProviderSubscriptionID, SubscriptionStatus_Canceled, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate event failed.", tests.Failed)
+		}
+
+		if err := repo.HandleEvent(ctx, payload, signature, now); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tHandle event failed.", tests.Failed)
+		}
+
+		if s := readAccountStatus(t, sub.AccountID); s != account.AccountStatus_Disabled {
+			t.Fatalf("\t%s\tExpected account status %s, got %s.", tests.Failed, account.AccountStatus_Disabled, s)
+		}
+		t.Logf("\t%s\tSubscription deleted ok.", tests.Success)
+	}
+
+	// An event sent before the subscription was deleted that arrives late doesn't change the subscription.
+	{
+		ps, _ := provider.Subscription(sub.ProviderSubscriptionID)
+		ps.Status = SubscriptionStatus_Active
+
+		sent := now.Add(-time.Minute)
+		payload, signature, err := provider.Event("customer.subscription.updated", ps, sent)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate event failed.", tests.Failed)
+		}
+
+		if err := repo.HandleEvent(ctx, payload, signature, sent); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tHandle event failed.", tests.Failed)
+		}
+
+		res, err := repo.ReadSubscription(ctx, auth.Claims{}, sub.AccountID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead subscription failed.", tests.Failed)
+		} else if res.Status != SubscriptionStatus_Canceled {
+			t.Fatalf("\t%s\tExpected status %s, got %s.", tests.Failed, SubscriptionStatus_Canceled, res.Status)
+		}
+
+		if s := readAccountStatus(t, sub.AccountID); s != account.AccountStatus_Disabled {
+			t.Fatalf("\t%s\tExpected account status %s, got %s.", tests.Failed, account.AccountStatus_Disabled, s)
+		}
+		t.Logf("\t%s\tOlder event ignored ok.", tests.Success)
+	}
+}
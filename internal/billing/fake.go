@@ -0,0 +1,197 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/webhook"
+	"github.com/pkg/errors"
+)
+
+// FakeProvider is an in-memory payment provider for testing and local development. No payments are made, the
+// subscriptions are stored in memory. Events are encoded and signed the same way as Stripe so they can be sent to
+// the billing webhook endpoint.
+type FakeProvider struct {
+	// WebhookSecret is used to sign the events.
+	WebhookSecret string
+
+	mu            sync.Mutex
+	seq           int
+	customers     map[string]ProviderCustomerRequest
+	subscriptions map[string]*ProviderSubscription
+}
+
+// NewFakeProvider creates a new FakeProvider that signs events with the webhook secret.
+func NewFakeProvider(webhookSecret string) *FakeProvider {
+	return &FakeProvider{
+		WebhookSecret: webhookSecret,
+		customers:     make(map[string]ProviderCustomerRequest),
+		subscriptions: make(map[string]*ProviderSubscription),
+	}
+}
+
+// nextID returns a new unique ID with the prefix.
+func (p *FakeProvider) nextID(prefix string) string {
+	p.seq++
+	return fmt.Sprintf("%s_fake%d", prefix, p.seq)
+}
+
+// CreateCustomer implements Provider.
+func (p *FakeProvider) CreateCustomer(ctx context.Context, req ProviderCustomerRequest) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id := p.nextID("cus")
+	p.customers[id] = req
+
+	return id, nil
+}
+
+// CreateSubscription implements Provider.
+func (p *FakeProvider) CreateSubscription(ctx context.Context, req ProviderSubscriptionRequest) (*ProviderSubscription, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.customers[req.CustomerID]; !ok {
+		return nil, errors.Errorf("customer %s not found", req.CustomerID)
+	}
+
+	periodEnd := time.Now().UTC().AddDate(0, 1, 0).Truncate(time.Second)
+
+	s := &ProviderSubscription{
+		ID:               p.nextID("sub"),
+		CustomerID:       req.CustomerID,
+		PriceID:          req.PriceID,
+		Quantity:         req.Quantity,
+		Status:           SubscriptionStatus_Active,
+		CurrentPeriodEnd: &periodEnd,
+	}
+
+	if req.TrialEnd != nil {
+		trialEnd := req.TrialEnd.UTC().Truncate(time.Second)
+		s.Status = SubscriptionStatus_Trialing
+		s.TrialEnd = &trialEnd
+		s.CurrentPeriodEnd = &trialEnd
+	}
+
+	p.subscriptions[s.ID] = s
+
+	res := *s
+	return &res, nil
+}
+
+// UpdateSubscription implements Provider.
+func (p *FakeProvider) UpdateSubscription(ctx context.Context, id string, req ProviderSubscriptionRequest) (*ProviderSubscription, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.subscriptions[id]
+	if !ok {
+		return nil, errors.Errorf("subscription %s not found", id)
+	}
+
+	s.PriceID = req.PriceID
+	s.Quantity = req.Quantity
+
+	res := *s
+	return &res, nil
+}
+
+// CancelSubscription implements Provider.
+func (p *FakeProvider) CancelSubscription(ctx context.Context, id string, atPeriodEnd bool) (*ProviderSubscription, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.subscriptions[id]
+	if !ok {
+		return nil, errors.Errorf("subscription %s not found", id)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	s.CanceledAt = &now
+	if atPeriodEnd {
+		s.CancelAtPeriodEnd = true
+	} else {
+		s.Status = SubscriptionStatus_Canceled
+	}
+
+	res := *s
+	return &res, nil
+}
+
+// ParseEvent implements Provider.
+func (p *FakeProvider) ParseEvent(payload []byte, signature string, now time.Time) (*ProviderEvent, error) {
+	return parseStripeEvent(p.WebhookSecret, payload, signature, now)
+}
+
+// Subscription returns the current state of the subscription.
+func (p *FakeProvider) Subscription(id string) (*ProviderSubscription, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s, ok := p.subscriptions[id]
+	if !ok {
+		return nil, false
+	}
+
+	res := *s
+	return &res, true
+}
+
+// SetSubscriptionStatus changes the status of the subscription and returns the signed event that Stripe would send
+// for the change.
+func (p *FakeProvider) SetSubscriptionStatus(id string, status SubscriptionStatus, now time.Time) ([]byte, string, error) {
+	p.mu.Lock()
+	s, ok := p.subscriptions[id]
+	if ok {
+		s.Status = status
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return nil, "", errors.Errorf("subscription %s not found", id)
+	}
+
+	typ := "customer.subscription.updated"
+	if status == SubscriptionStatus_Canceled {
+		typ = "customer.subscription.deleted"
+	}
+
+	res, _ := p.Subscription(id)
+	return p.Event(typ, res, now)
+}
+
+// Event returns the payload and signature of the Stripe event for the subscription or invoice.
+func (p *FakeProvider) Event(typ string, obj interface{}, now time.Time) ([]byte, string, error) {
+	var data interface{}
+	switch v := obj.(type) {
+	case *ProviderSubscription:
+		data = newStripeSubscription(v)
+	case *ProviderInvoice:
+		data = newStripeInvoice(v)
+	default:
+		return nil, "", errors.Errorf("unsupported event object %T", obj)
+	}
+
+	p.mu.Lock()
+	id := p.nextID("evt")
+	p.mu.Unlock()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":      id,
+		"object":  "event",
+		"type":    typ,
+		"created": now.Unix(),
+		"data": map[string]interface{}{
+			"object": data,
+		},
+	})
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	return payload, webhook.Sign(p.WebhookSecret, now, payload), nil
+}
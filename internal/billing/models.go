@@ -0,0 +1,455 @@
+package billing
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// Repository defines the required dependencies for Billing.
+type Repository struct {
+	DbConn  *sqlx.DB
+	Account *account.Repository
+
+	// Provider is used to create the subscriptions and charge the accounts.
+	Provider Provider
+
+	// Plans are the plans accounts can subscribe to.
+	Plans Plans
+}
+
+// NewRepository creates a new Repository that defines dependencies for Billing.
+func NewRepository(db *sqlx.DB, account *account.Repository, provider Provider) *Repository {
+	return &Repository{
+		DbConn:   db,
+		Account:  account,
+		Provider: provider,
+		Plans:    append(Plans{}, DefaultPlans...),
+	}
+}
+
+// Plan defines the price per seat and the limits of a subscription.
+type Plan struct {
+	ID          string       `json:"id" example:"team"`
+	Name        string       `json:"name" example:"Team"`
+	Description string       `json:"description" example:"For growing teams."`
+	Amount      int64        `json:"amount" example:"1500"` // Amount is the price per seat in the smallest unit of the currency.
+	Currency    string       `json:"currency" example:"usd"`
+	Interval    PlanInterval `json:"interval" enums:"month,year" swaggertype:"string" example:"month"`
+	TrialDays   int          `json:"trial_days" example:"14"`
+	MaxSeats    int          `json:"max_seats,omitempty" example:"25"` // MaxSeats is the max number of users, zero is unlimited.
+
+	// PriceID is the ID of the price for the plan at the payment provider, defaults to the ID of the plan.
+	PriceID string `json:"-"`
+}
+
+// priceID returns the ID of the price for the plan at the payment provider.
+func (p Plan) priceID() string {
+	if p.PriceID != "" {
+		return p.PriceID
+	}
+	return p.ID
+}
+
+// Plans a list of Plans.
+type Plans []Plan
+
+// Lookup returns the plan for the provided ID.
+func (l Plans) Lookup(id string) (Plan, bool) {
+	for _, p := range l {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Plan{}, false
+}
+
+// LookupPrice returns the plan for the ID of a price at the payment provider.
+func (l Plans) LookupPrice(priceID string) (Plan, bool) {
+	for _, p := range l {
+		if p.priceID() == priceID {
+			return p, true
+		}
+	}
+	return Plan{}, false
+}
+
+// DefaultPlans are the plans available when none are configured.
+var DefaultPlans = Plans{
+	{
+		ID:          "starter",
+		Name:        "Starter",
+		Description: "For small teams getting started.",
+		Amount:      900,
+		Currency:    "usd",
+		Interval:    PlanInterval_Month,
+		TrialDays:   14,
+		MaxSeats:    5,
+	},
+	{
+		ID:          "team",
+		Name:        "Team",
+		Description: "For growing teams.",
+		Amount:      1500,
+		Currency:    "usd",
+		Interval:    PlanInterval_Month,
+		TrialDays:   14,
+		MaxSeats:    25,
+	},
+	{
+		ID:          "business",
+		Name:        "Business",
+		Description: "For organizations with unlimited users.",
+		Amount:      2500,
+		Currency:    "usd",
+		Interval:    PlanInterval_Month,
+		TrialDays:   14,
+	},
+}
+
+// PlanInterval represents how often a subscription is charged.
+type PlanInterval string
+
+// PlanInterval values define the interval field of plan.
+const (
+	// PlanInterval_Month defines subscriptions that are charged monthly.
+	PlanInterval_Month PlanInterval = "month"
+	// PlanInterval_Year defines subscriptions that are charged yearly.
+	PlanInterval_Year PlanInterval = "year"
+)
+
+// Subscription represents the plan an account is subscribed to.
+type Subscription struct {
+	ID                     string             `json:"id" validate:"required,uuid" example:"72938896-a998-4258-a17b-6418dcdb80e3"`
+	AccountID              string             `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	PlanID                 string             `json:"plan_id" validate:"required" example:"team"`
+	Status                 SubscriptionStatus `json:"status" validate:"omitempty,oneof=trialing active past_due unpaid canceled incomplete" enums:"trialing,active,past_due,unpaid,canceled,incomplete" swaggertype:"string" example:"active"`
+	Seats                  int                `json:"seats" example:"5"`
+	ProviderCustomerID     string             `json:"provider_customer_id" example:"cus_FjJQXUKUg3PfCb"`
+	ProviderSubscriptionID string             `json:"provider_subscription_id" example:"sub_FjJQFYiQFqxrAq"`
+	TrialEndsAt            *pq.NullTime       `json:"trial_ends_at,omitempty"`
+	CurrentPeriodEnd       *pq.NullTime       `json:"current_period_end,omitempty"`
+	CancelAtPeriodEnd      bool               `json:"cancel_at_period_end" example:"false"`
+	CanceledAt             *pq.NullTime       `json:"canceled_at,omitempty"`
+	ProviderEventAt        *pq.NullTime       `json:"provider_event_at,omitempty"` // ProviderEventAt is the time of the latest provider event applied.
+	CreatedAt              time.Time          `json:"created_at"`
+	UpdatedAt              time.Time          `json:"updated_at"`
+}
+
+// SubscriptionResponse represents a subscription that is returned for display.
+type SubscriptionResponse struct {
+	ID                string            `json:"id" example:"72938896-a998-4258-a17b-6418dcdb80e3"`
+	AccountID         string            `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	PlanID            string            `json:"plan_id" example:"team"`
+	Status            web.EnumResponse  `json:"status"` // Status is enum with values [trialing, active, past_due, unpaid, canceled, incomplete].
+	Seats             int               `json:"seats" example:"5"`
+	TrialEndsAt       *web.TimeResponse `json:"trial_ends_at,omitempty"`      // TrialEndsAt contains multiple format options for display.
+	CurrentPeriodEnd  *web.TimeResponse `json:"current_period_end,omitempty"` // CurrentPeriodEnd contains multiple format options for display.
+	CancelAtPeriodEnd bool              `json:"cancel_at_period_end" example:"false"`
+	CanceledAt        *web.TimeResponse `json:"canceled_at,omitempty"` // CanceledAt contains multiple format options for display.
+	CreatedAt         web.TimeResponse  `json:"created_at"`            // CreatedAt contains multiple format options for display.
+	UpdatedAt         web.TimeResponse  `json:"updated_at"`            // UpdatedAt contains multiple format options for display.
+}
+
+// Response transforms Subscription and SubscriptionResponse that is used for display.
+// Additional filtering by context values or translations could be applied.
+func (m *Subscription) Response(ctx context.Context) *SubscriptionResponse {
+	if m == nil {
+		return nil
+	}
+
+	r := &SubscriptionResponse{
+		ID:                m.ID,
+		AccountID:         m.AccountID,
+		PlanID:            m.PlanID,
+		Status:            web.NewEnumResponse(ctx, m.Status, SubscriptionStatus_ValuesInterface()...),
+		Seats:             m.Seats,
+		CancelAtPeriodEnd: m.CancelAtPeriodEnd,
+		CreatedAt:         web.NewTimeResponse(ctx, m.CreatedAt),
+		UpdatedAt:         web.NewTimeResponse(ctx, m.UpdatedAt),
+	}
+
+	if m.TrialEndsAt != nil && m.TrialEndsAt.Valid {
+		at := web.NewTimeResponse(ctx, m.TrialEndsAt.Time)
+		r.TrialEndsAt = &at
+	}
+
+	if m.CurrentPeriodEnd != nil && m.CurrentPeriodEnd.Valid {
+		at := web.NewTimeResponse(ctx, m.CurrentPeriodEnd.Time)
+		r.CurrentPeriodEnd = &at
+	}
+
+	if m.CanceledAt != nil && m.CanceledAt.Valid {
+		at := web.NewTimeResponse(ctx, m.CanceledAt.Time)
+		r.CanceledAt = &at
+	}
+
+	return r
+}
+
+// Subscriptions a list of Subscriptions.
+type Subscriptions []*Subscription
+
+// SubscriptionCreateRequest contains information needed to subscribe an account to a plan.
+type SubscriptionCreateRequest struct {
+	AccountID string `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	PlanID    string `json:"plan_id" validate:"required" example:"team"`
+}
+
+// SubscriptionUpdateRequest defines the information needed to change the plan of the subscription of an account.
+type SubscriptionUpdateRequest struct {
+	AccountID string `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	PlanID    string `json:"plan_id" validate:"required" example:"business"`
+}
+
+// SubscriptionCancelRequest defines the information needed to cancel the subscription of an account. When
+// AtPeriodEnd is true, the account stays active until the end of the current period.
+type SubscriptionCancelRequest struct {
+	AccountID   string `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	AtPeriodEnd bool   `json:"at_period_end" example:"true"`
+}
+
+// SubscriptionStatus represents the status of subscription.
+type SubscriptionStatus string
+
+// SubscriptionStatus values define the status field of subscription.
+const (
+	// SubscriptionStatus_Trialing defines the status of a subscription during the trial period.
+	SubscriptionStatus_Trialing SubscriptionStatus = "trialing"
+	// SubscriptionStatus_Active defines the status of a subscription that is paid.
+	SubscriptionStatus_Active SubscriptionStatus = "active"
+	// SubscriptionStatus_PastDue defines the status of a subscription when the latest payment failed.
+	SubscriptionStatus_PastDue SubscriptionStatus = "past_due"
+	// SubscriptionStatus_Unpaid defines the status of a subscription when all payment retries have failed.
+	SubscriptionStatus_Unpaid SubscriptionStatus = "unpaid"
+	// SubscriptionStatus_Canceled defines the status of a subscription that has ended.
+	SubscriptionStatus_Canceled SubscriptionStatus = "canceled"
+	// SubscriptionStatus_Incomplete defines the status of a subscription when the first payment failed.
+	SubscriptionStatus_Incomplete SubscriptionStatus = "incomplete"
+)
+
+// SubscriptionStatus_Values provides list of valid SubscriptionStatus values.
+var SubscriptionStatus_Values = []SubscriptionStatus{
+	SubscriptionStatus_Trialing,
+	SubscriptionStatus_Active,
+	SubscriptionStatus_PastDue,
+	SubscriptionStatus_Unpaid,
+	SubscriptionStatus_Canceled,
+	SubscriptionStatus_Incomplete,
+}
+
+// SubscriptionStatus_ValuesInterface returns the SubscriptionStatus options as a slice interface.
+func SubscriptionStatus_ValuesInterface() []interface{} {
+	var l []interface{}
+	for _, v := range SubscriptionStatus_Values {
+		l = append(l, v.String())
+	}
+	return l
+}
+
+// Scan supports reading the SubscriptionStatus value from the database.
+func (s *SubscriptionStatus) Scan(value interface{}) error {
+	asBytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("Scan source is not []byte")
+	}
+
+	*s = SubscriptionStatus(string(asBytes))
+	return nil
+}
+
+// Value converts the SubscriptionStatus value to be stored in the database.
+func (s SubscriptionStatus) Value() (driver.Value, error) {
+	v := validator.New()
+	errs := v.Var(s, "required,oneof=trialing active past_due unpaid canceled incomplete")
+	if errs != nil {
+		return nil, errs
+	}
+
+	return string(s), nil
+}
+
+// String converts the SubscriptionStatus value to a string.
+func (s SubscriptionStatus) String() string {
+	return string(s)
+}
+
+// AccountStatus returns the status of the account for the status of the subscription. Accounts stay active while
+// the subscription is paid or in trial, are pending while a payment is being retried and disabled once the
+// subscription is unpaid or canceled.
+func (s SubscriptionStatus) AccountStatus() account.AccountStatus {
+	switch s {
+	case SubscriptionStatus_Trialing, SubscriptionStatus_Active:
+		return account.AccountStatus_Active
+	case SubscriptionStatus_PastDue, SubscriptionStatus_Incomplete:
+		return account.AccountStatus_Pending
+	default:
+		return account.AccountStatus_Disabled
+	}
+}
+
+// Invoice represents a charge for a subscription.
+type Invoice struct {
+	ID                string        `json:"id" validate:"required,uuid" example:"d4a1ba6e-1f6d-4d4c-9e3f-2e4f2f0c8a57"`
+	AccountID         string        `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	SubscriptionID    string        `json:"subscription_id" validate:"required,uuid" example:"72938896-a998-4258-a17b-6418dcdb80e3"`
+	ProviderInvoiceID string        `json:"provider_invoice_id" validate:"required" example:"in_1FDsRQ2eZvKYlo2C"`
+	Number            string        `json:"number" example:"A1B2C3D4-0001"`
+	Status            InvoiceStatus `json:"status" validate:"omitempty,oneof=draft open paid uncollectible void" enums:"draft,open,paid,uncollectible,void" swaggertype:"string" example:"paid"`
+	AmountDue         int64         `json:"amount_due" example:"7500"`
+	AmountPaid        int64         `json:"amount_paid" example:"7500"`
+	Currency          string        `json:"currency" example:"usd"`
+	HostedUrl         string        `json:"hosted_url" example:"https://pay.stripe.com/invoice/invst_1FDsRQ"`
+	PeriodStart       time.Time     `json:"period_start"`
+	PeriodEnd         time.Time     `json:"period_end"`
+	CreatedAt         time.Time     `json:"created_at"`
+	UpdatedAt         time.Time     `json:"updated_at"`
+}
+
+// InvoiceResponse represents an invoice that is returned for display.
+type InvoiceResponse struct {
+	ID             string           `json:"id" example:"d4a1ba6e-1f6d-4d4c-9e3f-2e4f2f0c8a57"`
+	AccountID      string           `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	SubscriptionID string           `json:"subscription_id" example:"72938896-a998-4258-a17b-6418dcdb80e3"`
+	Number         string           `json:"number" example:"A1B2C3D4-0001"`
+	Status         web.EnumResponse `json:"status"` // Status is enum with values [draft, open, paid, uncollectible, void].
+	AmountDue      int64            `json:"amount_due" example:"7500"`
+	AmountPaid     int64            `json:"amount_paid" example:"7500"`
+	Currency       string           `json:"currency" example:"usd"`
+	HostedUrl      string           `json:"hosted_url" example:"https://pay.stripe.com/invoice/invst_1FDsRQ"`
+	PeriodStart    web.TimeResponse `json:"period_start"` // PeriodStart contains multiple format options for display.
+	PeriodEnd      web.TimeResponse `json:"period_end"`   // PeriodEnd contains multiple format options for display.
+	CreatedAt      web.TimeResponse `json:"created_at"`   // CreatedAt contains multiple format options for display.
+	UpdatedAt      web.TimeResponse `json:"updated_at"`   // UpdatedAt contains multiple format options for display.
+}
+
+// Response transforms Invoice and InvoiceResponse that is used for display.
+// Additional filtering by context values or translations could be applied.
+func (m *Invoice) Response(ctx context.Context) *InvoiceResponse {
+	if m == nil {
+		return nil
+	}
+
+	return &InvoiceResponse{
+		ID:             m.ID,
+		AccountID:      m.AccountID,
+		SubscriptionID: m.SubscriptionID,
+		Number:         m.Number,
+		Status:         web.NewEnumResponse(ctx, m.Status, InvoiceStatus_ValuesInterface()...),
+		AmountDue:      m.AmountDue,
+		AmountPaid:     m.AmountPaid,
+		Currency:       m.Currency,
+		HostedUrl:      m.HostedUrl,
+		PeriodStart:    web.NewTimeResponse(ctx, m.PeriodStart),
+		PeriodEnd:      web.NewTimeResponse(ctx, m.PeriodEnd),
+		CreatedAt:      web.NewTimeResponse(ctx, m.CreatedAt),
+		UpdatedAt:      web.NewTimeResponse(ctx, m.UpdatedAt),
+	}
+}
+
+// Invoices a list of Invoices.
+type Invoices []*Invoice
+
+// Response transforms a list of Invoices to a list of InvoiceResponses.
+func (m *Invoices) Response(ctx context.Context) []*InvoiceResponse {
+	var l []*InvoiceResponse
+	if m != nil && len(*m) > 0 {
+		for _, n := range *m {
+			l = append(l, n.Response(ctx))
+		}
+	}
+
+	return l
+}
+
+// InvoiceFindRequest defines the possible options to search for invoices.
+type InvoiceFindRequest struct {
+	Filter filter.Filter `json:"filter,omitempty"`
+	Order  []string      `json:"order" example:"created_at desc"`
+	Limit  *uint         `json:"limit" example:"10"`
+	Offset *uint         `json:"offset" example:"20"`
+	Cursor string        `json:"cursor,omitempty" example:"eyJvIjpbImlkIGFzYyJdLCJ2IjpbIjk4NWYxNzQ2Il19"`
+}
+
+// InvoiceFindFields defines the fields of invoice that can be used to filter and sort a find request.
+var InvoiceFindFields = filter.Fields{
+	{Name: "id", Type: filter.FieldType_UUID, Filterable: true, Sortable: true, Key: true},
+	{Name: "account_id", Type: filter.FieldType_UUID, Filterable: true},
+	{Name: "subscription_id", Type: filter.FieldType_UUID, Filterable: true},
+	{Name: "number", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "status", Type: filter.FieldType_Enum, Values: filter.EnumValues(InvoiceStatus_ValuesInterface()), Filterable: true, Sortable: true},
+	{Name: "amount_due", Type: filter.FieldType_Int, Filterable: true, Sortable: true},
+	{Name: "period_start", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "period_end", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+}
+
+// InvoiceStatus represents the status of invoice.
+type InvoiceStatus string
+
+// InvoiceStatus values define the status field of invoice.
+const (
+	// InvoiceStatus_Draft defines the status of an invoice that has not been finalized.
+	InvoiceStatus_Draft InvoiceStatus = "draft"
+	// InvoiceStatus_Open defines the status of an invoice that is waiting to be paid.
+	InvoiceStatus_Open InvoiceStatus = "open"
+	// InvoiceStatus_Paid defines the status of an invoice that has been paid.
+	InvoiceStatus_Paid InvoiceStatus = "paid"
+	// InvoiceStatus_Uncollectible defines the status of an invoice that is unlikely to be paid.
+	InvoiceStatus_Uncollectible InvoiceStatus = "uncollectible"
+	// InvoiceStatus_Void defines the status of an invoice that was canceled.
+	InvoiceStatus_Void InvoiceStatus = "void"
+)
+
+// InvoiceStatus_Values provides list of valid InvoiceStatus values.
+var InvoiceStatus_Values = []InvoiceStatus{
+	InvoiceStatus_Draft,
+	InvoiceStatus_Open,
+	InvoiceStatus_Paid,
+	InvoiceStatus_Uncollectible,
+	InvoiceStatus_Void,
+}
+
+// InvoiceStatus_ValuesInterface returns the InvoiceStatus options as a slice interface.
+func InvoiceStatus_ValuesInterface() []interface{} {
+	var l []interface{}
+	for _, v := range InvoiceStatus_Values {
+		l = append(l, v.String())
+	}
+	return l
+}
+
+// Scan supports reading the InvoiceStatus value from the database.
+func (s *InvoiceStatus) Scan(value interface{}) error {
+	asBytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("Scan source is not []byte")
+	}
+
+	*s = InvoiceStatus(string(asBytes))
+	return nil
+}
+
+// Value converts the InvoiceStatus value to be stored in the database.
+func (s InvoiceStatus) Value() (driver.Value, error) {
+	v := validator.New()
+	errs := v.Var(s, "required,oneof=draft open paid uncollectible void")
+	if errs != nil {
+		return nil, errs
+	}
+
+	return string(s), nil
+}
+
+// String converts the InvoiceStatus value to a string.
+func (s InvoiceStatus) String() string {
+	return string(s)
+}
@@ -0,0 +1,109 @@
+package billing
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HeaderSignature is the header of the provider webhook requests that contains the signature of the payload.
+const HeaderSignature = "Stripe-Signature"
+
+// SignatureTolerance is how old the signature of a provider webhook can be before it's rejected.
+const SignatureTolerance = 5 * time.Minute
+
+var (
+	// ErrInvalidSignature occurs when the signature of a provider webhook does not match the payload.
+	ErrInvalidSignature = errors.New("Invalid signature")
+)
+
+// Provider defines the payment provider used to charge accounts for their subscriptions. Changes made at the
+// provider, like failed payments, are sent back as events to the billing webhook.
+type Provider interface {
+	// CreateCustomer creates the customer that is charged for the subscriptions of an account.
+	CreateCustomer(ctx context.Context, req ProviderCustomerRequest) (string, error)
+
+	// CreateSubscription subscribes the customer to the price.
+	CreateSubscription(ctx context.Context, req ProviderSubscriptionRequest) (*ProviderSubscription, error)
+
+	// UpdateSubscription changes the price or the quantity of the subscription.
+	UpdateSubscription(ctx context.Context, id string, req ProviderSubscriptionRequest) (*ProviderSubscription, error)
+
+	// CancelSubscription cancels the subscription immediately or at the end of the current period.
+	CancelSubscription(ctx context.Context, id string, atPeriodEnd bool) (*ProviderSubscription, error)
+
+	// ParseEvent verifies the signature of a webhook payload sent by the provider and decodes the event.
+	ParseEvent(payload []byte, signature string, now time.Time) (*ProviderEvent, error)
+}
+
+// ProviderCustomerRequest contains the information needed to create a customer for an account.
+type ProviderCustomerRequest struct {
+	AccountID string
+	Name      string
+	Email     string
+}
+
+// ProviderSubscriptionRequest contains the information needed to create or update a subscription.
+type ProviderSubscriptionRequest struct {
+	AccountID  string
+	CustomerID string
+	PriceID    string
+	Quantity   int
+	TrialEnd   *time.Time
+}
+
+// ProviderSubscription is the state of a subscription at the provider.
+type ProviderSubscription struct {
+	ID                string
+	CustomerID        string
+	PriceID           string
+	Quantity          int
+	Status            SubscriptionStatus
+	TrialEnd          *time.Time
+	CurrentPeriodEnd  *time.Time
+	CancelAtPeriodEnd bool
+	CanceledAt        *time.Time
+}
+
+// ProviderInvoice is the state of an invoice at the provider.
+type ProviderInvoice struct {
+	ID             string
+	CustomerID     string
+	SubscriptionID string
+	Number         string
+	Status         InvoiceStatus
+	AmountDue      int64
+	AmountPaid     int64
+	Currency       string
+	HostedUrl      string
+	PeriodStart    time.Time
+	PeriodEnd      time.Time
+}
+
+// ProviderEventType represents the type of an event sent by the provider.
+type ProviderEventType string
+
+// ProviderEventType values define the events handled by billing.
+const (
+	// ProviderEventType_SubscriptionUpdated is sent when a subscription is created or changed.
+	ProviderEventType_SubscriptionUpdated ProviderEventType = "subscription.updated"
+	// ProviderEventType_SubscriptionDeleted is sent when a subscription has ended.
+	ProviderEventType_SubscriptionDeleted ProviderEventType = "subscription.deleted"
+	// ProviderEventType_InvoiceUpdated is sent when an invoice is created or changed.
+	ProviderEventType_InvoiceUpdated ProviderEventType = "invoice.updated"
+	// ProviderEventType_InvoicePaid is sent when the payment for an invoice succeeded.
+	ProviderEventType_InvoicePaid ProviderEventType = "invoice.paid"
+	// ProviderEventType_InvoicePaymentFailed is sent when the payment for an invoice failed.
+	ProviderEventType_InvoicePaymentFailed ProviderEventType = "invoice.payment_failed"
+)
+
+// ProviderEvent is an event sent by the provider. Either the subscription or the invoice is set depending on the
+// type of the event, events of unknown types have neither set.
+type ProviderEvent struct {
+	ID           string
+	Type         ProviderEventType
+	CreatedAt    time.Time
+	Subscription *ProviderSubscription
+	Invoice      *ProviderInvoice
+}
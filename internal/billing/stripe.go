@@ -0,0 +1,404 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/webhook"
+	"github.com/pkg/errors"
+)
+
+// StripeBaseUrl is the base URL of the Stripe API.
+const StripeBaseUrl = "https://api.stripe.com/v1"
+
+// StripeProvider charges accounts using Stripe.
+type StripeProvider struct {
+	// SecretKey is the API key used to authenticate requests to Stripe.
+	SecretKey string
+
+	// WebhookSecret is the signing secret of the webhook endpoint registered with Stripe.
+	WebhookSecret string
+
+	// BaseUrl is the base URL of the Stripe API, can be changed for testing.
+	BaseUrl string
+
+	// Client is used to send the requests to Stripe.
+	Client *http.Client
+}
+
+// NewStripeProvider creates a new StripeProvider for the API key and webhook signing secret.
+func NewStripeProvider(secretKey, webhookSecret string) *StripeProvider {
+	return &StripeProvider{
+		SecretKey:     secretKey,
+		WebhookSecret: webhookSecret,
+		BaseUrl:       StripeBaseUrl,
+		Client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// stripeError is the error returned by the Stripe API.
+type stripeError struct {
+	Error struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// stripeSubscription is a Stripe subscription object.
+type stripeSubscription struct {
+	ID                string `json:"id"`
+	Object            string `json:"object"`
+	Customer          string `json:"customer"`
+	Status            string `json:"status"`
+	TrialEnd          *int64 `json:"trial_end"`
+	CurrentPeriodEnd  *int64 `json:"current_period_end"`
+	CancelAtPeriodEnd bool   `json:"cancel_at_period_end"`
+	CanceledAt        *int64 `json:"canceled_at"`
+	Items             struct {
+		Data []stripeSubscriptionItem `json:"data"`
+	} `json:"items"`
+}
+
+// stripeSubscriptionItem is a Stripe subscription item object, a subscription has one item for the plan.
+type stripeSubscriptionItem struct {
+	ID       string `json:"id"`
+	Quantity int    `json:"quantity"`
+	Price    struct {
+		ID string `json:"id"`
+	} `json:"price"`
+}
+
+// stripeInvoice is a Stripe invoice object.
+type stripeInvoice struct {
+	ID               string `json:"id"`
+	Object           string `json:"object"`
+	Customer         string `json:"customer"`
+	Subscription     string `json:"subscription"`
+	Number           string `json:"number"`
+	Status           string `json:"status"`
+	AmountDue        int64  `json:"amount_due"`
+	AmountPaid       int64  `json:"amount_paid"`
+	Currency         string `json:"currency"`
+	HostedInvoiceUrl string `json:"hosted_invoice_url"`
+	PeriodStart      int64  `json:"period_start"`
+	PeriodEnd        int64  `json:"period_end"`
+}
+
+// stripeEvent is a Stripe event object sent to the webhook endpoint.
+type stripeEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// stripeEventTypes maps the Stripe event types to the provider event types.
+var stripeEventTypes = map[string]ProviderEventType{
+	"customer.subscription.created":   ProviderEventType_SubscriptionUpdated,
+	"customer.subscription.updated":   ProviderEventType_SubscriptionUpdated,
+	"customer.subscription.deleted":   ProviderEventType_SubscriptionDeleted,
+	"invoice.created":                 ProviderEventType_InvoiceUpdated,
+	"invoice.finalized":               ProviderEventType_InvoiceUpdated,
+	"invoice.updated":                 ProviderEventType_InvoiceUpdated,
+	"invoice.voided":                  ProviderEventType_InvoiceUpdated,
+	"invoice.marked_uncollectible":    ProviderEventType_InvoiceUpdated,
+	"invoice.paid":                    ProviderEventType_InvoicePaid,
+	"invoice.payment_failed":          ProviderEventType_InvoicePaymentFailed,
+	"invoice.payment_action_required": ProviderEventType_InvoicePaymentFailed,
+}
+
+// CreateCustomer implements Provider.
+func (p *StripeProvider) CreateCustomer(ctx context.Context, req ProviderCustomerRequest) (string, error) {
+	form := url.Values{}
+	form.Set("name", req.Name)
+	if req.Email != "" {
+		form.Set("email", req.Email)
+	}
+	form.Set("metadata[account_id]", req.AccountID)
+
+	var res struct {
+		ID string `json:"id"`
+	}
+	if err := p.do(ctx, http.MethodPost, "/customers", form, &res); err != nil {
+		return "", errors.WithMessagef(err, "create customer for account %s failed", req.AccountID)
+	}
+
+	return res.ID, nil
+}
+
+// CreateSubscription implements Provider.
+func (p *StripeProvider) CreateSubscription(ctx context.Context, req ProviderSubscriptionRequest) (*ProviderSubscription, error) {
+	form := url.Values{}
+	form.Set("customer", req.CustomerID)
+	form.Set("items[0][price]", req.PriceID)
+	form.Set("items[0][quantity]", strconv.Itoa(req.Quantity))
+	form.Set("metadata[account_id]", req.AccountID)
+	if req.TrialEnd != nil {
+		form.Set("trial_end", strconv.FormatInt(req.TrialEnd.Unix(), 10))
+	}
+
+	var res stripeSubscription
+	if err := p.do(ctx, http.MethodPost, "/subscriptions", form, &res); err != nil {
+		return nil, errors.WithMessagef(err, "create subscription for account %s failed", req.AccountID)
+	}
+
+	return res.subscription(), nil
+}
+
+// UpdateSubscription implements Provider.
+func (p *StripeProvider) UpdateSubscription(ctx context.Context, id string, req ProviderSubscriptionRequest) (*ProviderSubscription, error) {
+	// The item of the subscription is needed to change the price and quantity.
+	var cur stripeSubscription
+	if err := p.do(ctx, http.MethodGet, "/subscriptions/"+url.PathEscape(id), nil, &cur); err != nil {
+		return nil, errors.WithMessagef(err, "read subscription %s failed", id)
+	} else if len(cur.Items.Data) == 0 {
+		return nil, errors.Errorf("subscription %s has no items", id)
+	}
+
+	form := url.Values{}
+	form.Set("items[0][id]", cur.Items.Data[0].ID)
+	form.Set("items[0][price]", req.PriceID)
+	form.Set("items[0][quantity]", strconv.Itoa(req.Quantity))
+	form.Set("proration_behavior", "create_prorations")
+
+	var res stripeSubscription
+	if err := p.do(ctx, http.MethodPost, "/subscriptions/"+url.PathEscape(id), form, &res); err != nil {
+		return nil, errors.WithMessagef(err, "update subscription %s failed", id)
+	}
+
+	return res.subscription(), nil
+}
+
+// CancelSubscription implements Provider.
+func (p *StripeProvider) CancelSubscription(ctx context.Context, id string, atPeriodEnd bool) (*ProviderSubscription, error) {
+	var res stripeSubscription
+
+	var err error
+	if atPeriodEnd {
+		form := url.Values{}
+		form.Set("cancel_at_period_end", "true")
+		err = p.do(ctx, http.MethodPost, "/subscriptions/"+url.PathEscape(id), form, &res)
+	} else {
+		err = p.do(ctx, http.MethodDelete, "/subscriptions/"+url.PathEscape(id), nil, &res)
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "cancel subscription %s failed", id)
+	}
+
+	return res.subscription(), nil
+}
+
+// ParseEvent implements Provider.
+func (p *StripeProvider) ParseEvent(payload []byte, signature string, now time.Time) (*ProviderEvent, error) {
+	return parseStripeEvent(p.WebhookSecret, payload, signature, now)
+}
+
+// do sends the request to the Stripe API and decodes the response.
+func (p *StripeProvider) do(ctx context.Context, method, path string, form url.Values, res interface{}) error {
+	req, err := http.NewRequest(method, p.BaseUrl+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(p.SecretKey, "")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	dat, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var se stripeError
+		if err := json.Unmarshal(dat, &se); err == nil && se.Error.Message != "" {
+			return errors.Errorf("stripe %d %s: %s", resp.StatusCode, se.Error.Type, se.Error.Message)
+		}
+		return errors.Errorf("stripe %d: %s", resp.StatusCode, string(dat))
+	}
+
+	if err := json.Unmarshal(dat, res); err != nil {
+		return errors.Wrapf(err, "decode stripe response")
+	}
+
+	return nil
+}
+
+// parseStripeEvent verifies the signature of the payload and decodes the Stripe event.
+func parseStripeEvent(secret string, payload []byte, signature string, now time.Time) (*ProviderEvent, error) {
+	if secret == "" {
+		return nil, errors.WithMessage(ErrInvalidSignature, "webhook secret not configured")
+	}
+
+	// Stripe signs the payloads the same way as the webhooks sent to accounts.
+	if err := webhook.VerifySignature(secret, signature, payload, SignatureTolerance, now); err != nil {
+		return nil, errors.WithMessage(ErrInvalidSignature, err.Error())
+	}
+
+	var se stripeEvent
+	if err := json.Unmarshal(payload, &se); err != nil {
+		return nil, errors.Wrap(err, "decode stripe event")
+	} else if se.ID == "" {
+		return nil, errors.New("stripe event missing id")
+	}
+
+	e := &ProviderEvent{
+		ID:        se.ID,
+		Type:      ProviderEventType(se.Type),
+		CreatedAt: time.Unix(se.Created, 0).UTC(),
+	}
+
+	typ, ok := stripeEventTypes[se.Type]
+	if !ok {
+		return e, nil
+	}
+	e.Type = typ
+
+	switch typ {
+	case ProviderEventType_SubscriptionUpdated, ProviderEventType_SubscriptionDeleted:
+		var s stripeSubscription
+		if err := json.Unmarshal(se.Data.Object, &s); err != nil {
+			return nil, errors.Wrapf(err, "decode stripe subscription for event %s", se.ID)
+		}
+		e.Subscription = s.subscription()
+	default:
+		var i stripeInvoice
+		if err := json.Unmarshal(se.Data.Object, &i); err != nil {
+			return nil, errors.Wrapf(err, "decode stripe invoice for event %s", se.ID)
+		}
+		e.Invoice = i.invoice()
+	}
+
+	return e, nil
+}
+
+// subscription converts the Stripe subscription to a provider subscription.
+func (s stripeSubscription) subscription() *ProviderSubscription {
+	r := &ProviderSubscription{
+		ID:                s.ID,
+		CustomerID:        s.Customer,
+		Status:            stripeSubscriptionStatus(s.Status),
+		TrialEnd:          unixTime(s.TrialEnd),
+		CurrentPeriodEnd:  unixTime(s.CurrentPeriodEnd),
+		CancelAtPeriodEnd: s.CancelAtPeriodEnd,
+		CanceledAt:        unixTime(s.CanceledAt),
+	}
+
+	if len(s.Items.Data) > 0 {
+		r.PriceID = s.Items.Data[0].Price.ID
+		r.Quantity = s.Items.Data[0].Quantity
+	}
+
+	return r
+}
+
+// invoice converts the Stripe invoice to a provider invoice.
+func (i stripeInvoice) invoice() *ProviderInvoice {
+	return &ProviderInvoice{
+		ID:             i.ID,
+		CustomerID:     i.Customer,
+		SubscriptionID: i.Subscription,
+		Number:         i.Number,
+		Status:         InvoiceStatus(i.Status),
+		AmountDue:      i.AmountDue,
+		AmountPaid:     i.AmountPaid,
+		Currency:       i.Currency,
+		HostedUrl:      i.HostedInvoiceUrl,
+		PeriodStart:    time.Unix(i.PeriodStart, 0).UTC(),
+		PeriodEnd:      time.Unix(i.PeriodEnd, 0).UTC(),
+	}
+}
+
+// newStripeSubscription converts the provider subscription to a Stripe subscription.
+func newStripeSubscription(s *ProviderSubscription) stripeSubscription {
+	r := stripeSubscription{
+		ID:                s.ID,
+		Object:            "subscription",
+		Customer:          s.CustomerID,
+		Status:            string(s.Status),
+		TrialEnd:          unixSeconds(s.TrialEnd),
+		CurrentPeriodEnd:  unixSeconds(s.CurrentPeriodEnd),
+		CancelAtPeriodEnd: s.CancelAtPeriodEnd,
+		CanceledAt:        unixSeconds(s.CanceledAt),
+	}
+
+	item := stripeSubscriptionItem{
+		ID:       "si_" + strings.TrimPrefix(s.ID, "sub_"),
+		Quantity: s.Quantity,
+	}
+	item.Price.ID = s.PriceID
+	r.Items.Data = []stripeSubscriptionItem{item}
+
+	return r
+}
+
+// newStripeInvoice converts the provider invoice to a Stripe invoice.
+func newStripeInvoice(i *ProviderInvoice) stripeInvoice {
+	return stripeInvoice{
+		ID:               i.ID,
+		Object:           "invoice",
+		Customer:         i.CustomerID,
+		Subscription:     i.SubscriptionID,
+		Number:           i.Number,
+		Status:           string(i.Status),
+		AmountDue:        i.AmountDue,
+		AmountPaid:       i.AmountPaid,
+		Currency:         i.Currency,
+		HostedInvoiceUrl: i.HostedUrl,
+		PeriodStart:      i.PeriodStart.Unix(),
+		PeriodEnd:        i.PeriodEnd.Unix(),
+	}
+}
+
+// stripeSubscriptionStatus converts the status of a Stripe subscription to a subscription status.
+func stripeSubscriptionStatus(s string) SubscriptionStatus {
+	switch s {
+	case "incomplete_expired":
+		return SubscriptionStatus_Canceled
+	case "paused":
+		return SubscriptionStatus_Unpaid
+	}
+
+	for _, v := range SubscriptionStatus_Values {
+		if string(v) == s {
+			return v
+		}
+	}
+
+	// Unknown statuses are treated as incomplete so the account is pending until the subscription is updated.
+	return SubscriptionStatus_Incomplete
+}
+
+// unixTime converts the optional unix timestamp to a time.
+func unixTime(v *int64) *time.Time {
+	if v == nil || *v == 0 {
+		return nil
+	}
+	t := time.Unix(*v, 0).UTC()
+	return &t
+}
+
+// unixSeconds converts the optional time to a unix timestamp.
+func unixSeconds(t *time.Time) *int64 {
+	if t == nil {
+		return nil
+	}
+	v := t.Unix()
+	return &v
+}
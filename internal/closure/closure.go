@@ -0,0 +1,185 @@
+package closure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+var (
+	// ErrRestoreExpired occurs when the the restore hash exceeds the expiration.
+	ErrRestoreExpired = errors.New("Restore expired")
+
+	// ErrForbidden occurs when the user closing the account is not the owner of the account.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+)
+
+// CloseAccount closes the account and sends an email to the owner with a link to restore the account before it's
+// purged. Only the owner of the account can close it.
+func (repo *Repository) CloseAccount(ctx context.Context, claims auth.Claims, req CloseAccountRequest, now time.Time) (string, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.closure.CloseAccount")
+	defer span.Finish()
+
+	v := webcontext.Validator()
+
+	// Validate the request.
+	err := v.StructCtx(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	owner, err := repo.UserAccount.ReadOwner(ctx, auth.Claims{}, req.AccountID)
+	if err != nil {
+		return "", err
+	} else if owner.UserID != claims.Subject {
+		return "", errors.WithMessagef(ErrForbidden, "user %s is not the owner of account %s", claims.Subject, req.AccountID)
+	}
+
+	ownerUser, err := repo.User.ReadByID(ctx, auth.Claims{}, owner.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	err = repo.Account.Close(ctx, claims, account.AccountCloseRequest{ID: req.AccountID}, now)
+	if err != nil {
+		return "", err
+	}
+
+	acc, err := repo.Account.Read(ctx, auth.Claims{}, account.AccountReadRequest{ID: req.AccountID, IncludeArchived: true})
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := NewRestoreHash(ctx, repo.secretKey, RestoreType_Account, acc.ID, owner.UserID, requestIP(ctx), acc.PurgeAt.Time, now)
+	if err != nil {
+		return "", err
+	}
+
+	data := map[string]interface{}{
+		"User":    ownerUser.Response(ctx),
+		"Account": acc.Response(ctx),
+		"Url":     repo.RestoreUrl(hash),
+		"PurgeAt": acc.Response(ctx).PurgeAt,
+	}
+
+	subject := fmt.Sprintf("Your account %s has been closed", acc.Name)
+
+	err = repo.Notify.Send(ctx, ownerUser.Email, subject, "account_closed", data)
+	if err != nil {
+		err = errors.WithMessagef(err, "Send account closed to %s failed.", ownerUser.Email)
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// CloseUser closes the user and sends an email with a link to restore the user before it's purged.
+func (repo *Repository) CloseUser(ctx context.Context, claims auth.Claims, req CloseUserRequest, now time.Time) (string, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.closure.CloseUser")
+	defer span.Finish()
+
+	v := webcontext.Validator()
+
+	// Validate the request.
+	err := v.StructCtx(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	err = repo.User.Close(ctx, claims, user.UserCloseRequest{ID: req.UserID}, now)
+	if err != nil {
+		return "", err
+	}
+
+	usr, err := repo.User.Read(ctx, auth.Claims{}, user.UserReadRequest{ID: req.UserID, IncludeArchived: true})
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := NewRestoreHash(ctx, repo.secretKey, RestoreType_User, usr.ID, usr.ID, requestIP(ctx), usr.PurgeAt.Time, now)
+	if err != nil {
+		return "", err
+	}
+
+	data := map[string]interface{}{
+		"User":    usr.Response(ctx),
+		"Url":     repo.RestoreUrl(hash),
+		"PurgeAt": usr.Response(ctx).PurgeAt,
+	}
+
+	subject := "Your user has been closed"
+
+	err = repo.Notify.Send(ctx, usr.Email, subject, "user_closed", data)
+	if err != nil {
+		err = errors.WithMessagef(err, "Send user closed to %s failed.", usr.Email)
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// Restore re-opens the account or user encoded in the restore hash. The restore fails when the grace period has
+// passed.
+func (repo *Repository) Restore(ctx context.Context, req RestoreRequest, now time.Time) (*RestoreHash, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.closure.Restore")
+	defer span.Finish()
+
+	v := webcontext.Validator()
+
+	// Validate the request.
+	err := v.StructCtx(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := ParseRestoreHash(ctx, req.RestoreHash, repo.secretKey, now)
+	if err != nil {
+		return nil, err
+	}
+
+	switch hash.Type {
+	case RestoreType_Account:
+		err = repo.Account.Restore(ctx, auth.Claims{}, account.AccountRestoreRequest{ID: hash.ID}, now)
+	case RestoreType_User:
+		err = repo.User.Restore(ctx, auth.Claims{}, user.UserRestoreRequest{ID: hash.ID}, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return hash, nil
+}
+
+// Purge permanently deletes all the accounts and users that were closed and have passed their grace period.
+func (repo *Repository) Purge(ctx context.Context, now time.Time) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.closure.Purge")
+	defer span.Finish()
+
+	// Users are still purged when some of the accounts failed, the failures are retried on the next run.
+	accounts, accErr := repo.Account.PurgeClosed(ctx, now)
+
+	users, err := repo.User.PurgeClosed(ctx, now)
+	if err != nil {
+		return accounts + users, err
+	} else if accErr != nil {
+		return accounts + users, accErr
+	}
+
+	return accounts + users, nil
+}
+
+// requestIP returns the IP of the current request.
+func requestIP(ctx context.Context) string {
+	if vals, _ := webcontext.ContextValues(ctx); vals != nil {
+		return vals.RequestIP
+	}
+	return ""
+}
@@ -0,0 +1,401 @@
+package closure
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/notify"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/project"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/webhook"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	test *tests.Test
+	repo *Repository
+)
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+
+	userRepo := user.MockRepository(test.MasterDB)
+	userAccRepo := user_account.NewRepository(test.MasterDB)
+	accRepo := account.NewRepository(test.MasterDB)
+	accPrefRepo := account_preference.NewRepository(test.MasterDB)
+	prjRepo := project.NewRepository(test.MasterDB)
+
+	// Mock the methods needed to send the restore emails.
+	restoreUrl := func(string) string {
+		return ""
+	}
+	notify := &notify.MockEmail{}
+	secretKey := "6368616e676520746869732070613434"
+
+	repo = NewRepository(test.MasterDB, userRepo, userAccRepo, accRepo, accPrefRepo, prjRepo, restoreUrl, notify, secretKey)
+
+	return m.Run()
+}
+
+// mockClaims returns the claims for the user of the account with the provided roles.
+func mockClaims(userID, accountID string, roles []user_account.UserAccountRole, now time.Time) auth.Claims {
+	claims := auth.Claims{
+		AccountIDs: []string{accountID},
+		StandardClaims: jwt.StandardClaims{
+			Subject:   userID,
+			Audience:  accountID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Hour).Unix(),
+		},
+	}
+	for _, r := range roles {
+		claims.Roles = append(claims.Roles, r.String())
+	}
+	return claims
+}
+
+// mockAccount creates a new account and returns the claims for its owner and for a user of the account.
+func mockAccount(t *testing.T, now time.Time) (*account.Account, auth.Claims, auth.Claims) {
+	ctx := tests.Context()
+
+	a, err := repo.Account.Create(ctx, auth.Claims{}, account.AccountCreateRequest{
+		Name:     uuid.NewRandom().String(),
+		Address1: "101 E Main",
+		City:     "Valdez",
+		Region:   "AK",
+		Country:  "US",
+		Zipcode:  "99686",
+	}, now)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tCreate account failed.", tests.Failed)
+	}
+
+	ownerRoles := []user_account.UserAccountRole{user_account.UserAccountRole_Owner, user_account.UserAccountRole_Admin}
+	userRoles := []user_account.UserAccountRole{user_account.UserAccountRole_User}
+
+	var claims []auth.Claims
+	for _, roles := range [][]user_account.UserAccountRole{ownerRoles, userRoles} {
+		initPass := uuid.NewRandom().String()
+		u, err := repo.User.Create(ctx, auth.Claims{}, user.UserCreateRequest{
+			FirstName:       "Lee",
+			LastName:        "Brown",
+			Email:           uuid.NewRandom().String() + "@geeksinthewoods.com",
+			Password:        initPass,
+			PasswordConfirm: initPass,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate user failed.", tests.Failed)
+		}
+
+		_, err = repo.UserAccount.Create(ctx, auth.Claims{}, user_account.UserAccountCreateRequest{
+			UserID:    u.ID,
+			AccountID: a.ID,
+			Roles:     roles,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate user account failed.", tests.Failed)
+		}
+
+		claims = append(claims, mockClaims(u.ID, a.ID, roles, now))
+	}
+
+	return a, claims[0], claims[1]
+}
+
+// zipFileNames returns the sorted names of the files in the zip.
+func zipFileNames(t *testing.T, dat []byte) []string {
+	zr, err := zip.NewReader(bytes.NewReader(dat), int64(len(dat)))
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tRead zip failed.", tests.Failed)
+	}
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// TestExport validates that the exports of an account and a user include the expected files.
+func TestExport(t *testing.T) {
+
+	t.Log("Given the need ensure accounts and users can be exported.")
+	{
+		ctx := tests.Context()
+
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		a, ownerClaims, userClaims := mockAccount(t, now)
+
+		// Ensure a user of the account can't export the account.
+		_, err := repo.ExportAccount(ctx, userClaims, a.ID, now)
+		if errors.Cause(err) != account.ErrForbidden {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", account.ErrForbidden)
+			t.Fatalf("\t%s\tExportAccount as user failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tExportAccount as user ok.", tests.Success)
+
+		dat, err := repo.ExportAccount(ctx, ownerClaims, a.ID, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tExportAccount failed.", tests.Failed)
+		}
+
+		expected := []string{"account.json", "memberships.csv", "memberships.json", "preferences.csv", "preferences.json",
+			"projects.csv", "projects.json", "users.csv", "users.json"}
+		if names := zipFileNames(t, dat); strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Logf("\t\tGot : %v", names)
+			t.Logf("\t\tWant: %v", expected)
+			t.Fatalf("\t%s\tExportAccount files failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tExportAccount ok.", tests.Success)
+
+		dat, err = repo.ExportUser(ctx, userClaims, userClaims.Subject, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tExportUser failed.", tests.Failed)
+		}
+
+		expected = []string{"accounts.csv", "accounts.json", "memberships.csv", "memberships.json", "user.json"}
+		if names := zipFileNames(t, dat); strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Logf("\t\tGot : %v", names)
+			t.Logf("\t\tWant: %v", expected)
+			t.Fatalf("\t%s\tExportUser files failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tExportUser ok.", tests.Success)
+	}
+}
+
+// TestCloseAccount validates that a closed account can be restored during the grace period and is purged after.
+func TestCloseAccount(t *testing.T) {
+
+	t.Log("Given the need ensure the owner can close their account.")
+	{
+		ctx := tests.Context()
+
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		a, ownerClaims, userClaims := mockAccount(t, now)
+
+		// Ensure a user that is not the owner can't close the account.
+		_, err := repo.CloseAccount(ctx, userClaims, CloseAccountRequest{AccountID: a.ID}, now)
+		if errors.Cause(err) != ErrForbidden {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrForbidden)
+			t.Fatalf("\t%s\tCloseAccount as user failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCloseAccount as user ok.", tests.Success)
+
+		hash, err := repo.CloseAccount(ctx, ownerClaims, CloseAccountRequest{AccountID: a.ID}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCloseAccount failed.", tests.Failed)
+		}
+
+		// Ensure the account is no longer available.
+		_, err = repo.Account.ReadByID(ctx, auth.Claims{}, a.ID)
+		if errors.Cause(err) != account.ErrNotFound {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", account.ErrNotFound)
+			t.Fatalf("\t%s\tCloseAccount read failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCloseAccount ok.", tests.Success)
+
+		// Restore the account with the hash and ensure the users of the account are restored.
+		restoreNow := now.Add(time.Hour)
+		res, err := repo.Restore(ctx, RestoreRequest{RestoreHash: hash}, restoreNow)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRestore failed.", tests.Failed)
+		} else if res.Type != RestoreType_Account || res.ID != a.ID {
+			t.Logf("\t\tGot : %s %s", res.Type, res.ID)
+			t.Logf("\t\tWant: %s %s", RestoreType_Account, a.ID)
+			t.Fatalf("\t%s\tRestore failed.", tests.Failed)
+		}
+
+		_, err = repo.UserAccount.Read(ctx, auth.Claims{}, user_account.UserAccountReadRequest{
+			UserID:    userClaims.Subject,
+			AccountID: a.ID,
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRestore user account failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRestore ok.", tests.Success)
+
+		// Close the account again and ensure it's purged once the grace period has passed.
+		_, err = repo.CloseAccount(ctx, ownerClaims, CloseAccountRequest{AccountID: a.ID}, restoreNow)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCloseAccount failed.", tests.Failed)
+		}
+
+		purgeNow := restoreNow.Add(account.DefaultPurgeGracePeriod).Add(time.Hour)
+		_, err = repo.Purge(ctx, purgeNow)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tPurge failed.", tests.Failed)
+		}
+
+		_, err = repo.Account.Read(ctx, auth.Claims{}, account.AccountReadRequest{ID: a.ID, IncludeArchived: true})
+		if errors.Cause(err) != account.ErrNotFound {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", account.ErrNotFound)
+			t.Fatalf("\t%s\tPurge read failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tPurge ok.", tests.Success)
+	}
+}
+
+// TestCloseUser validates that a closed user can be restored during the grace period and is purged after.
+func TestCloseUser(t *testing.T) {
+
+	t.Log("Given the need ensure a user can close their user.")
+	{
+		ctx := tests.Context()
+
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		a, ownerClaims, userClaims := mockAccount(t, now)
+
+		// Ensure the owner of an account can't close their user.
+		_, err := repo.CloseUser(ctx, ownerClaims, CloseUserRequest{UserID: ownerClaims.Subject}, now)
+		if errors.Cause(err) != user.ErrAccountAdmin {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", user.ErrAccountAdmin)
+			t.Fatalf("\t%s\tCloseUser as owner failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCloseUser as owner ok.", tests.Success)
+
+		hash, err := repo.CloseUser(ctx, userClaims, CloseUserRequest{UserID: userClaims.Subject}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCloseUser failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCloseUser ok.", tests.Success)
+
+		// Ensure the restore hash can't be used once the grace period has passed.
+		expiredNow := now.Add(user.DefaultPurgeGracePeriod).Add(time.Hour)
+		_, err = repo.Restore(ctx, RestoreRequest{RestoreHash: hash}, expiredNow)
+		if errors.Cause(err) != ErrRestoreExpired {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrRestoreExpired)
+			t.Fatalf("\t%s\tRestore expired failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRestore expired ok.", tests.Success)
+
+		_, err = repo.Restore(ctx, RestoreRequest{RestoreHash: hash}, now.Add(time.Hour))
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRestore failed.", tests.Failed)
+		}
+
+		_, err = repo.UserAccount.Read(ctx, auth.Claims{}, user_account.UserAccountReadRequest{
+			UserID:    userClaims.Subject,
+			AccountID: a.ID,
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRestore user account failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRestore ok.", tests.Success)
+
+		// Close the user again and ensure it's purged once the grace period has passed.
+		_, err = repo.CloseUser(ctx, userClaims, CloseUserRequest{UserID: userClaims.Subject}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCloseUser failed.", tests.Failed)
+		}
+
+		// Record an event made by the user and a webhook delivery about them, both include their personal data.
+		evt, err := audit.Record(ctx, test.MasterDB, userClaims, audit.AuditEventRecordRequest{
+			AccountID:  a.ID,
+			EntityType: audit.EntityType_Project,
+			EntityID:   uuid.NewRandom().String(),
+			Action:     audit.Action_Create,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRecord audit event failed.", tests.Failed)
+		}
+
+		_, err = webhook.NewRepository(test.MasterDB).Create(ctx, ownerClaims, webhook.WebhookCreateRequest{
+			AccountID:  a.ID,
+			Url:        "https://example.com/hooks",
+			EventTypes: []string{string(webhook.EventType_All)},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate webhook failed.", tests.Failed)
+		}
+
+		err = webhook.Publish(ctx, test.MasterDB, a.ID, webhook.EventType_UserAccountUpdated, map[string]interface{}{
+			"user_id":    userClaims.Subject,
+			"account_id": a.ID,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tPublish webhook failed.", tests.Failed)
+		}
+
+		_, err = repo.Purge(ctx, expiredNow)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tPurge failed.", tests.Failed)
+		}
+
+		_, err = repo.User.Read(ctx, auth.Claims{}, user.UserReadRequest{ID: userClaims.Subject, IncludeArchived: true})
+		if errors.Cause(err) != user.ErrNotFound {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", user.ErrNotFound)
+			t.Fatalf("\t%s\tPurge read failed.", tests.Failed)
+		}
+
+		// Ensure the audit event made by the user is kept without the user.
+		var actors int
+		err = test.MasterDB.GetContext(ctx, &actors, test.MasterDB.Rebind(
+			"SELECT count(*) FROM audit_events WHERE id = ? AND actor_user_id IS NULL AND request_ip = ''"), evt.ID)
+		if err != nil || actors != 1 {
+			t.Logf("\t\tGot : %d %+v", actors, err)
+			t.Logf("\t\tWant: %d", 1)
+			t.Fatalf("\t%s\tPurge audit events failed.", tests.Failed)
+		}
+
+		// Ensure the webhook deliveries about the user are removed.
+		var deliveries int
+		err = test.MasterDB.GetContext(ctx, &deliveries, test.MasterDB.Rebind(
+			"SELECT count(*) FROM webhook_deliveries WHERE payload->'data'->>'user_id' = ?"), userClaims.Subject)
+		if err != nil || deliveries != 0 {
+			t.Logf("\t\tGot : %d %+v", deliveries, err)
+			t.Logf("\t\tWant: %d", 0)
+			t.Fatalf("\t%s\tPurge webhook deliveries failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tPurge ok.", tests.Success)
+	}
+}
@@ -0,0 +1,283 @@
+package closure
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/project"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// ExportAccount returns a zip file that contains the users, memberships, projects and preferences of the account.
+// Each list is included both as JSON and CSV.
+func (repo *Repository) ExportAccount(ctx context.Context, claims auth.Claims, accountID string, now time.Time) ([]byte, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.closure.ExportAccount")
+	defer span.Finish()
+
+	// Ensure the claims can modify the account, exports include the personal data of all the users.
+	err := repo.Account.CanModifyAccount(ctx, claims, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	acc, err := repo.Account.ReadByID(ctx, auth.Claims{}, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := repo.UserAccount.UserFindByAccount(ctx, auth.Claims{}, user_account.UserFindByAccountRequest{
+		AccountID:       accountID,
+		Order:           []string{"created_at asc"},
+		IncludeArchived: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	memberships, err := repo.UserAccount.Find(ctx, auth.Claims{}, user_account.UserAccountFindRequest{
+		Filter: filter.Filter{
+			{Field: "account_id", Operator: filter.Op_Eq, Values: []string{accountID}},
+		},
+		Order:           []string{"created_at asc"},
+		IncludeArchived: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	projects, err := repo.Project.Find(ctx, auth.Claims{}, project.ProjectFindRequest{
+		Filter: filter.Filter{
+			{Field: "account_id", Operator: filter.Op_Eq, Values: []string{accountID}},
+		},
+		Order:           []string{"created_at asc"},
+		IncludeArchived: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prefs, err := repo.AccountPref.FindByAccountID(ctx, auth.Claims{}, account_preference.AccountPreferenceFindByAccountIDRequest{
+		AccountID:       accountID,
+		IncludeArchived: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exp := newExport(now)
+
+	exp.writeJSON("account.json", acc)
+
+	exp.writeJSON("users.json", users)
+	userRows := [][]string{{"id", "first_name", "last_name", "email", "timezone", "created_at", "archived_at"}}
+	for _, u := range users {
+		userRows = append(userRows, []string{u.ID, u.FirstName, u.LastName, u.Email, stringValue(u.Timezone),
+			formatTime(u.CreatedAt), formatNullTime(u.ArchivedAt)})
+	}
+	exp.writeCSV("users.csv", userRows)
+
+	exp.writeMemberships(memberships)
+
+	exp.writeJSON("projects.json", projects)
+	projectRows := [][]string{{"id", "name", "status", "created_at", "updated_at", "archived_at"}}
+	for _, p := range projects {
+		projectRows = append(projectRows, []string{p.ID, p.Name, p.Status.String(),
+			formatTime(p.CreatedAt), formatTime(p.UpdatedAt), formatNullTime(p.ArchivedAt)})
+	}
+	exp.writeCSV("projects.csv", projectRows)
+
+	exp.writeJSON("preferences.json", prefs)
+	prefRows := [][]string{{"name", "value", "created_at", "updated_at", "archived_at"}}
+	for _, p := range prefs {
+		prefRows = append(prefRows, []string{p.Name.String(), p.Value,
+			formatTime(p.CreatedAt), formatTime(p.UpdatedAt), formatNullTime(p.ArchivedAt)})
+	}
+	exp.writeCSV("preferences.csv", prefRows)
+
+	return exp.bytes()
+}
+
+// ExportUser returns a zip file that contains the personal data of the user and their account memberships.
+func (repo *Repository) ExportUser(ctx context.Context, claims auth.Claims, userID string, now time.Time) ([]byte, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.closure.ExportUser")
+	defer span.Finish()
+
+	// Ensure the claims can modify the user specified in the request.
+	err := repo.User.CanModifyUser(ctx, claims, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	usr, err := repo.User.ReadByID(ctx, auth.Claims{}, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberships, err := repo.UserAccount.FindByUserID(ctx, auth.Claims{}, userID, true)
+	if err != nil && errors.Cause(err) != user_account.ErrNotFound {
+		return nil, err
+	}
+
+	var accounts account.Accounts
+	for _, ua := range memberships {
+		acc, err := repo.Account.Read(ctx, auth.Claims{}, account.AccountReadRequest{ID: ua.AccountID, IncludeArchived: true})
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, acc)
+	}
+
+	exp := newExport(now)
+
+	exp.writeJSON("user.json", usr)
+
+	exp.writeMemberships(memberships)
+
+	exp.writeJSON("accounts.json", accounts)
+	accountRows := [][]string{{"id", "name", "status", "created_at", "archived_at"}}
+	for _, a := range accounts {
+		accountRows = append(accountRows, []string{a.ID, a.Name, a.Status.String(),
+			formatTime(a.CreatedAt), formatNullTime(a.ArchivedAt)})
+	}
+	exp.writeCSV("accounts.csv", accountRows)
+
+	return exp.bytes()
+}
+
+// export builds a zip file, the first error encountered is kept and returned by bytes.
+type export struct {
+	buf *bytes.Buffer
+	zw  *zip.Writer
+	now time.Time
+	err error
+}
+
+// newExport creates a new export with the files modified at the provided time.
+func newExport(now time.Time) *export {
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	buf := new(bytes.Buffer)
+	return &export{
+		buf: buf,
+		zw:  zip.NewWriter(buf),
+		now: now.UTC(),
+	}
+}
+
+// header returns the header for a new file in the zip.
+func (e *export) header(name string) *zip.FileHeader {
+	return &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: e.now,
+	}
+}
+
+// writeJSON adds a file with the value encoded as JSON.
+func (e *export) writeJSON(name string, v interface{}) {
+	if e.err != nil {
+		return
+	}
+
+	dat, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		e.err = errors.Wrapf(err, "encode %s failed", name)
+		return
+	}
+
+	w, err := e.zw.CreateHeader(e.header(name))
+	if err != nil {
+		e.err = errors.Wrapf(err, "create %s failed", name)
+		return
+	}
+
+	if _, err := w.Write(dat); err != nil {
+		e.err = errors.Wrapf(err, "write %s failed", name)
+	}
+}
+
+// writeCSV adds a file with the rows encoded as CSV, the first row is the header.
+func (e *export) writeCSV(name string, rows [][]string) {
+	if e.err != nil {
+		return
+	}
+
+	w, err := e.zw.CreateHeader(e.header(name))
+	if err != nil {
+		e.err = errors.Wrapf(err, "create %s failed", name)
+		return
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(rows); err != nil {
+		e.err = errors.Wrapf(err, "write %s failed", name)
+	}
+}
+
+// writeMemberships adds the user accounts as JSON and CSV.
+func (e *export) writeMemberships(memberships user_account.UserAccounts) {
+	e.writeJSON("memberships.json", memberships)
+
+	rows := [][]string{{"user_id", "account_id", "roles", "status", "created_at", "updated_at", "archived_at"}}
+	for _, ua := range memberships {
+		var roles []string
+		for _, r := range ua.Roles {
+			roles = append(roles, r.String())
+		}
+
+		rows = append(rows, []string{ua.UserID, ua.AccountID, strings.Join(roles, ","), ua.Status.String(),
+			formatTime(ua.CreatedAt), formatTime(ua.UpdatedAt), formatNullTime(ua.ArchivedAt)})
+	}
+	e.writeCSV("memberships.csv", rows)
+}
+
+// bytes closes the zip and returns its contents.
+func (e *export) bytes() ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	if err := e.zw.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return e.buf.Bytes(), nil
+}
+
+// formatTime formats the time as RFC3339 for CSV files.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatNullTime formats the time when it's set.
+func formatNullTime(t *pq.NullTime) string {
+	if t == nil || !t.Valid {
+		return ""
+	}
+	return formatTime(t.Time)
+}
+
+// stringValue returns the value of the string pointer or an empty string.
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,146 @@
+package closure
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/notify"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/project"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sudo-suhas/symcrypto"
+)
+
+// Repository defines the required dependencies for closing accounts and users.
+type Repository struct {
+	DbConn      *sqlx.DB
+	User        *user.Repository
+	UserAccount *user_account.Repository
+	Account     *account.Repository
+	AccountPref *account_preference.Repository
+	Project     *project.Repository
+	RestoreUrl  func(string) string
+	Notify      notify.Email
+	secretKey   string
+}
+
+// NewRepository creates a new Repository that defines dependencies for closing accounts and users.
+func NewRepository(db *sqlx.DB, user *user.Repository, userAccount *user_account.Repository, account *account.Repository,
+	accountPref *account_preference.Repository, project *project.Repository, restoreUrl func(string) string,
+	notify notify.Email, secretKey string) *Repository {
+	return &Repository{
+		DbConn:      db,
+		User:        user,
+		UserAccount: userAccount,
+		Account:     account,
+		AccountPref: accountPref,
+		Project:     project,
+		RestoreUrl:  restoreUrl,
+		Notify:      notify,
+		secretKey:   secretKey,
+	}
+}
+
+// CloseAccountRequest defines the information needed for the owner to close their account.
+type CloseAccountRequest struct {
+	AccountID string `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+}
+
+// CloseUserRequest defines the information needed for a user to close their user.
+type CloseUserRequest struct {
+	UserID string `json:"user_id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+}
+
+// RestoreRequest defines the fields need to restore a closed account or user.
+type RestoreRequest struct {
+	RestoreHash string `json:"restore_hash" validate:"required" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+}
+
+// RestoreType defines the type of entity that can be restored.
+type RestoreType string
+
+// RestoreType values define the entity restored by a restore hash.
+const (
+	RestoreType_Account RestoreType = "account"
+	RestoreType_User    RestoreType = "user"
+)
+
+// RestoreHash
+type RestoreHash struct {
+	Type      RestoreType `json:"type" validate:"required,oneof=account user" example:"account"`
+	ID        string      `json:"id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	UserID    string      `json:"user_id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	CreatedAt int         `json:"created_at" validate:"required"`
+	ExpiresAt int         `json:"expires_at" validate:"required"`
+	RequestIP string      `json:"request_ip" validate:"required,ip" example:"69.56.104.36"`
+}
+
+// NewRestoreHash generates a new encrypt restore hash that is web safe for use in URLs. The hash expires when the
+// account or user is purged.
+func NewRestoreHash(ctx context.Context, secretKey string, restoreType RestoreType, id, userID, requestIp string, expiresAt, now time.Time) (string, error) {
+	// Generate a string that embeds additional information.
+	hashPts := []string{
+		string(restoreType),
+		id,
+		userID,
+		strconv.Itoa(int(now.UTC().Unix())),
+		strconv.Itoa(int(expiresAt.UTC().Unix())),
+		requestIp,
+	}
+	hashStr := strings.Join(hashPts, "|")
+
+	// This returns the nonce appended with the encrypted string.
+	crypto, err := symcrypto.New(secretKey)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	encrypted, err := crypto.Encrypt(hashStr)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return encrypted, nil
+}
+
+// ParseRestoreHash extracts the details encrypted in the hash string.
+func ParseRestoreHash(ctx context.Context, encrypted, secretKey string, now time.Time) (*RestoreHash, error) {
+	crypto, err := symcrypto.New(secretKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hashStr, err := crypto.Decrypt(encrypted)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hashPts := strings.Split(hashStr, "|")
+
+	var hash RestoreHash
+	if len(hashPts) == 6 {
+		hash.Type = RestoreType(hashPts[0])
+		hash.ID = hashPts[1]
+		hash.UserID = hashPts[2]
+		hash.CreatedAt, _ = strconv.Atoi(hashPts[3])
+		hash.ExpiresAt, _ = strconv.Atoi(hashPts[4])
+		hash.RequestIP = hashPts[5]
+	}
+
+	// Validate the hash.
+	err = webcontext.Validator().StructCtx(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(hash.ExpiresAt) < now.UTC().Unix() {
+		err = errors.WithMessage(ErrRestoreExpired, "Restore has expired.")
+		return nil, err
+	}
+
+	return &hash, nil
+}
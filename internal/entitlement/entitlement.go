@@ -0,0 +1,341 @@
+package entitlement
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/billing"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// The database table for the metered usage.
+	usageCounterTableName = "usage_counters"
+	// The database table for Project used to count the projects.
+	projectTableName = "projects"
+)
+
+var (
+	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrFeatureNotEntitled occurs when the feature is not included in the plan of the account.
+	ErrFeatureNotEntitled = errors.New("Feature is not included in the plan")
+
+	// ErrLimitExceeded occurs when the usage would exceed the limit of the plan of the account.
+	ErrLimitExceeded = errors.New("Limit of the plan has been reached")
+)
+
+// WebError returns the error as a web error with the status code 402 when a limit has been exceeded or 403 when the
+// feature is not included in the plan. Other errors are returned unchanged.
+func WebError(ctx context.Context, err error) error {
+	switch errors.Cause(err) {
+	case ErrLimitExceeded:
+		return weberror.NewErrorMessage(ctx, err, http.StatusPaymentRequired, "Upgrade your plan to increase the limit.")
+	case ErrFeatureNotEntitled:
+		return weberror.NewErrorMessage(ctx, err, http.StatusForbidden, "Upgrade your plan to use this feature.")
+	}
+	return err
+}
+
+// Plan returns the ID and the entitlements of the plan for the account. Accounts without a subscription or with a
+// subscription that has ended get the default plan.
+func (repo *Repository) Plan(ctx context.Context, accountID string) (string, Plan, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.entitlement.Plan")
+	defer span.Finish()
+
+	planID := repo.DefaultPlanID
+
+	sub, err := repo.Billing.ReadSubscription(ctx, auth.Claims{}, accountID)
+	if err != nil {
+		if errors.Cause(err) != billing.ErrNotFound {
+			return "", Plan{}, err
+		}
+	} else {
+		switch sub.Status {
+		case billing.SubscriptionStatus_Trialing, billing.SubscriptionStatus_Active, billing.SubscriptionStatus_PastDue:
+			planID = sub.PlanID
+		}
+	}
+
+	plan, ok := repo.Plans[planID]
+	if !ok {
+		// Plans that are no longer offered fall back to the default plan.
+		planID = repo.DefaultPlanID
+		plan, ok = repo.Plans[planID]
+		if !ok {
+			return "", Plan{}, errors.Errorf("default plan %s is not defined", planID)
+		}
+	}
+
+	return planID, plan, nil
+}
+
+// HasFeature returns ErrFeatureNotEntitled when the feature is not included in the plan of the account.
+func (repo *Repository) HasFeature(ctx context.Context, accountID string, feature Feature) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.entitlement.HasFeature")
+	defer span.Finish()
+
+	planID, plan, err := repo.Plan(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	if !plan.HasFeature(feature) {
+		return errors.WithMessagef(ErrFeatureNotEntitled, "feature %s is not included in plan %s", feature, planID)
+	}
+
+	return nil
+}
+
+// Usage returns the current usage of the limit for the account.
+func (repo *Repository) Usage(ctx context.Context, accountID string, limit Limit, now time.Time) (*Usage, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.entitlement.Usage")
+	defer span.Finish()
+
+	_, plan, err := repo.Plan(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.usage(ctx, plan, accountID, limit, now)
+}
+
+// usage returns the current usage of the limit for the account on the plan.
+func (repo *Repository) usage(ctx context.Context, plan Plan, accountID string, limit Limit, now time.Time) (*Usage, error) {
+	u := &Usage{
+		Limit: limit,
+	}
+	if max, ok := plan.Limits[limit]; ok {
+		u.Max = &max
+	}
+
+	var err error
+	switch limit {
+	case Limit_Seats:
+		u.Used, err = repo.Billing.Seats(ctx, accountID)
+	case Limit_Projects:
+		u.Used, err = repo.countProjects(ctx, accountID)
+	default:
+		u.Used, err = repo.meteredUsage(ctx, accountID, limit, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Check returns ErrLimitExceeded when the account can't use n more of the limit. Use Reserve to create the resources
+// so concurrent requests can't exceed the limit.
+func (repo *Repository) Check(ctx context.Context, accountID string, limit Limit, n int, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.entitlement.Check")
+	defer span.Finish()
+
+	planID, plan, err := repo.Plan(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	// Skip counting the usage when the limit is unlimited.
+	max, ok := plan.Limits[limit]
+	if !ok {
+		return nil
+	}
+
+	u, err := repo.usage(ctx, plan, accountID, limit, now)
+	if err != nil {
+		return err
+	}
+
+	if u.Used+n > max {
+		return errors.WithMessagef(ErrLimitExceeded, "plan %s allows %d %s, account has used %d", planID, max, limit, u.Used)
+	}
+
+	return nil
+}
+
+// Reserve executes fn while holding a lock on the limit for the account. ErrLimitExceeded is returned without
+// executing fn when the account can't use n more of the limit. The lock is released when the transaction passed to
+// fn is committed, so resources created by fn are counted by concurrent requests. It implements Checker.
+func (repo *Repository) Reserve(ctx context.Context, accountID string, limit Limit, n int, now time.Time, fn func(tx *sqlx.Tx) error) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.entitlement.Reserve")
+	defer span.Finish()
+
+	tx, err := repo.DbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Requests for the same account and limit wait for the lock, so the usage is counted after the resources of the
+	// previous request have been committed.
+	q := `SELECT pg_advisory_xact_lock(hashtext($1))`
+	if _, err := tx.ExecContext(ctx, q, accountID+":"+limit.String()); err != nil {
+		tx.Rollback()
+		err = errors.Wrapf(err, "query - %s", q)
+		return errors.WithMessagef(err, "lock %s for account %s failed", limit, accountID)
+	}
+
+	if err := repo.Check(ctx, accountID, limit, n, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+// Consume records n usage of the metered limit for the account. ErrLimitExceeded is returned when the usage for the
+// current month exceeds the limit of the plan. The usage is recorded even when the limit is exceeded.
+func (repo *Repository) Consume(ctx context.Context, accountID string, limit Limit, n int, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.entitlement.Consume")
+	defer span.Finish()
+
+	if !limit.Metered() {
+		return errors.Errorf("limit %s is not metered", limit)
+	}
+
+	planID, plan, err := repo.Plan(ctx, accountID)
+	if err != nil {
+		return err
+	}
+
+	used, err := repo.increment(ctx, accountID, limit, n, now)
+	if err != nil {
+		return err
+	}
+
+	if max, ok := plan.Limits[limit]; ok && used > max {
+		return errors.WithMessagef(ErrLimitExceeded, "plan %s allows %d %s per month, account has used %d", planID, max, limit, used)
+	}
+
+	return nil
+}
+
+// Read returns the plan, features and the usage of all the limits for the account.
+func (repo *Repository) Read(ctx context.Context, claims auth.Claims, accountID string, now time.Time) (*Entitlements, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.entitlement.Read")
+	defer span.Finish()
+
+	// Users can only read the entitlements for their account.
+	if claims.Audience != "" && claims.Audience != accountID {
+		return nil, errors.WithStack(ErrForbidden)
+	}
+
+	planID, plan, err := repo.Plan(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Entitlements{
+		AccountID: accountID,
+		PlanID:    planID,
+		Features:  plan.Features,
+	}
+
+	for _, limit := range Limit_Values {
+		u, err := repo.usage(ctx, plan, accountID, limit, now)
+		if err != nil {
+			return nil, err
+		}
+		res.Usage = append(res.Usage, *u)
+	}
+
+	return res, nil
+}
+
+// countProjects returns the number of projects for the account that are not archived.
+func (repo *Repository) countProjects(ctx context.Context, accountID string) (int, error) {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Select("count(*)")
+	query.From(projectTableName)
+	query.Where(query.Equal("account_id", accountID))
+	query.Where(query.IsNull("archived_at"))
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var cnt int
+	err := repo.DbConn.QueryRowContext(ctx, queryStr, args...).Scan(&cnt)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "count projects for account %s failed", accountID)
+		return 0, err
+	}
+
+	return cnt, nil
+}
+
+// meteredUsage returns the usage of the metered limit for the account in the current month.
+func (repo *Repository) meteredUsage(ctx context.Context, accountID string, limit Limit, now time.Time) (int, error) {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Select("coalesce(sum(count), 0)")
+	query.From(usageCounterTableName)
+	query.Where(query.Equal("account_id", accountID))
+	query.Where(query.Equal("metric", limit.String()))
+	query.Where(query.Equal("period_start", periodStart(now)))
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var cnt int
+	err := repo.DbConn.QueryRowContext(ctx, queryStr, args...).Scan(&cnt)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "read usage %s for account %s failed", limit, accountID)
+		return 0, err
+	}
+
+	return cnt, nil
+}
+
+// increment adds n to the usage counter of the current month and returns the updated usage.
+func (repo *Repository) increment(ctx context.Context, accountID string, limit Limit, n int, now time.Time) (int, error) {
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(usageCounterTableName)
+	query.Cols("account_id", "metric", "period_start", "count", "updated_at")
+	query.Values(accountID, limit.String(), periodStart(now), n, now)
+
+	// The counter is updated atomically so concurrent requests are all counted.
+	sql, args := query.Build()
+	sql += " ON CONFLICT (account_id, metric, period_start) DO UPDATE SET count = " + usageCounterTableName +
+		".count + EXCLUDED.count, updated_at = EXCLUDED.updated_at RETURNING count"
+	sql = repo.DbConn.Rebind(sql)
+
+	var cnt int
+	err := repo.DbConn.QueryRowContext(ctx, sql, args...).Scan(&cnt)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "increment usage %s for account %s failed", limit, accountID)
+		return 0, err
+	}
+
+	return cnt, nil
+}
+
+// periodStart returns the start of the month used for metered usage.
+func periodStart(now time.Time) time.Time {
+	if now.IsZero() {
+		now = time.Now()
+	}
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,248 @@
+package entitlement
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/billing"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	test *tests.Test
+	repo *Repository
+)
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+
+	billingRepo := billing.NewRepository(test.MasterDB, account.NewRepository(test.MasterDB), billing.NewFakeProvider("whsec_test"))
+	repo = NewRepository(test.MasterDB, billingRepo)
+
+	// Limit the accounts without a subscription so the limits of the plans can be tested.
+	repo.DefaultPlanID = PlanID_Free
+
+	return m.Run()
+}
+
+// mockAccountID creates a new account with an admin user.
+func mockAccountID(t *testing.T, now time.Time) string {
+	ua, err := user_account.MockUserAccount(tests.Context(), test.MasterDB, now, user_account.UserAccountRole_Admin)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tCreate user account failed.", tests.Failed)
+	}
+	return ua.AccountID
+}
+
+// mockProject inserts a new project directly into the database. The project package can't be used here as it
+// depends on the entitlement package.
+func mockProject(t *testing.T, accountID string, now time.Time) {
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto("projects")
+	query.Cols("id", "account_id", "name", "status", "created_at", "updated_at")
+	query.Values(uuid.NewRandom().String(), accountID, "Rocket Launch", "active", now, now)
+
+	sql, args := query.Build()
+	sql = test.MasterDB.Rebind(sql)
+	if _, err := test.MasterDB.Exec(sql, args...); err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tCreate project failed.", tests.Failed)
+	}
+}
+
+// TestFreePlan validates accounts without a subscription are limited to the free plan.
+func TestFreePlan(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2019, time.August, 19, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	accountID := mockAccountID(t, now)
+
+	max := DefaultPlans[PlanID_Free].Limits[Limit_Projects]
+
+	// Projects can be created until the limit of the plan is reached.
+	for i := 0; i < max; i++ {
+		if err := repo.Check(ctx, accountID, Limit_Projects, 1, now); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCheck failed.", tests.Failed)
+		}
+		mockProject(t, accountID, now)
+	}
+
+	err := repo.Check(ctx, accountID, Limit_Projects, 1, now)
+	if errors.Cause(err) != ErrLimitExceeded {
+		t.Logf("\t\tGot : %+v", err)
+		t.Fatalf("\t%s\tExpected ErrLimitExceeded.", tests.Failed)
+	}
+	t.Logf("\t%s\tProject limit ok.", tests.Success)
+
+	u, err := repo.Usage(ctx, accountID, Limit_Projects, now)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tUsage failed.", tests.Failed)
+	} else if u.Used != max || u.Remaining() != 0 {
+		t.Fatalf("\t%s\tExpected %d used and 0 remaining, got %d used and %d remaining.", tests.Failed, max, u.Used, u.Remaining())
+	}
+	t.Logf("\t%s\tUsage ok.", tests.Success)
+
+	err = repo.HasFeature(ctx, accountID, Feature_Webhooks)
+	if errors.Cause(err) != ErrFeatureNotEntitled {
+		t.Logf("\t\tGot : %+v", err)
+		t.Fatalf("\t%s\tExpected ErrFeatureNotEntitled.", tests.Failed)
+	}
+	t.Logf("\t%s\tFeature not entitled ok.", tests.Success)
+
+	// Subscribing to a plan increases the limits.
+	_, err = repo.Billing.CreateSubscription(ctx, auth.Claims{}, billing.SubscriptionCreateRequest{
+		AccountID: accountID,
+		PlanID:    "team",
+	}, now)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tCreate subscription failed.", tests.Failed)
+	}
+
+	if err := repo.Check(ctx, accountID, Limit_Projects, 1, now); err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tCheck failed.", tests.Failed)
+	}
+
+	if err := repo.HasFeature(ctx, accountID, Feature_Webhooks); err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tHasFeature failed.", tests.Failed)
+	}
+	t.Logf("\t%s\tSubscribed plan ok.", tests.Success)
+}
+
+// TestDefaultPlan validates accounts without a subscription are unlimited unless a default plan is configured.
+func TestDefaultPlan(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2019, time.August, 19, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	accountID := mockAccountID(t, now)
+
+	unlimitedRepo := NewRepository(test.MasterDB, repo.Billing)
+
+	planID, _, err := unlimitedRepo.Plan(ctx, accountID)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tPlan failed.", tests.Failed)
+	} else if planID != PlanID_Unlimited {
+		t.Logf("\t\tGot : %s", planID)
+		t.Logf("\t\tWant: %s", PlanID_Unlimited)
+		t.Fatalf("\t%s\tPlan should be the default plan.", tests.Failed)
+	}
+	t.Logf("\t%s\tPlan ok.", tests.Success)
+
+	max := DefaultPlans[PlanID_Free].Limits[Limit_Projects]
+	if err := unlimitedRepo.Check(ctx, accountID, Limit_Projects, max+1, now); err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tCheck failed.", tests.Failed)
+	}
+
+	for _, f := range Feature_Values {
+		if err := unlimitedRepo.HasFeature(ctx, accountID, f); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tHasFeature %s failed.", tests.Failed, f)
+		}
+	}
+	t.Logf("\t%s\tUnlimited ok.", tests.Success)
+}
+
+// TestReserve validates resources are only created when the limit allows them.
+func TestReserve(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2019, time.August, 19, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	accountID := mockAccountID(t, now)
+
+	max := DefaultPlans[PlanID_Free].Limits[Limit_Projects]
+
+	// mockProjectTx inserts a new project with the transaction of the reservation.
+	var created int
+	mockProjectTx := func(tx *sqlx.Tx) error {
+		query := sqlbuilder.NewInsertBuilder()
+		query.InsertInto("projects")
+		query.Cols("id", "account_id", "name", "status", "created_at", "updated_at")
+		query.Values(uuid.NewRandom().String(), accountID, "Rocket Launch", "active", now, now)
+
+		sql, args := query.Build()
+		sql = tx.Rebind(sql)
+		if _, err := tx.ExecContext(ctx, sql, args...); err != nil {
+			return err
+		}
+		created++
+		return nil
+	}
+
+	for i := 0; i < max; i++ {
+		if err := repo.Reserve(ctx, accountID, Limit_Projects, 1, now, mockProjectTx); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tReserve failed.", tests.Failed)
+		}
+	}
+
+	err := repo.Reserve(ctx, accountID, Limit_Projects, 1, now, mockProjectTx)
+	if errors.Cause(err) != ErrLimitExceeded {
+		t.Logf("\t\tGot : %+v", err)
+		t.Fatalf("\t%s\tExpected ErrLimitExceeded.", tests.Failed)
+	} else if created != max {
+		t.Fatalf("\t%s\tExpected %d projects created, got %d.", tests.Failed, max, created)
+	}
+	t.Logf("\t%s\tReserve limit ok.", tests.Success)
+}
+
+// TestConsume validates metered usage is counted per month.
+func TestConsume(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2019, time.August, 19, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	accountID := mockAccountID(t, now)
+
+	max := DefaultPlans[PlanID_Free].Limits[Limit_ApiRequests]
+
+	if err := repo.Consume(ctx, accountID, Limit_ApiRequests, max, now); err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tConsume failed.", tests.Failed)
+	}
+
+	err := repo.Consume(ctx, accountID, Limit_ApiRequests, 1, now)
+	if errors.Cause(err) != ErrLimitExceeded {
+		t.Logf("\t\tGot : %+v", err)
+		t.Fatalf("\t%s\tExpected ErrLimitExceeded.", tests.Failed)
+	}
+	t.Logf("\t%s\tConsume limit ok.", tests.Success)
+
+	// The usage starts over the next month.
+	if err := repo.Consume(ctx, accountID, Limit_ApiRequests, 1, now.AddDate(0, 1, 0)); err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tConsume next month failed.", tests.Failed)
+	}
+	t.Logf("\t%s\tConsume next month ok.", tests.Success)
+}
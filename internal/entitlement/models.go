@@ -0,0 +1,233 @@
+package entitlement
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/billing"
+	"github.com/jmoiron/sqlx"
+)
+
+// Repository defines the required dependencies for Entitlement.
+type Repository struct {
+	DbConn  *sqlx.DB
+	Billing *billing.Repository
+
+	// Plans are the features and limits for each billing plan.
+	Plans Plans
+
+	// DefaultPlanID is the plan for accounts without a subscription or when the subscription has ended. Defaults to
+	// the unlimited plan so enforcing the plans is opt-in for existing deployments.
+	DefaultPlanID string
+}
+
+// NewRepository creates a new Repository that defines dependencies for Entitlement.
+func NewRepository(db *sqlx.DB, billing *billing.Repository) *Repository {
+	return &Repository{
+		DbConn:  db,
+		Billing: billing,
+		Plans:   DefaultPlans,
+
+		DefaultPlanID: PlanID_Unlimited,
+	}
+}
+
+// Checker is used by other repositories to enforce the limits of the plan of an account before resources are created.
+type Checker interface {
+	// Reserve executes fn while holding a lock on the limit for the account so concurrent requests can't exceed the
+	// limit. ErrLimitExceeded is returned without executing fn when the account can't use n more of the limit.
+	Reserve(ctx context.Context, accountID string, limit Limit, n int, now time.Time, fn func(tx *sqlx.Tx) error) error
+}
+
+// Feature represents a part of the service that is only available for some plans.
+type Feature string
+
+// Feature values define the features that can be included in a plan.
+const (
+	// Feature_AuditLog allows the account to view the audit log.
+	Feature_AuditLog Feature = "audit_log"
+	// Feature_Webhooks allows the account to register webhooks.
+	Feature_Webhooks Feature = "webhooks"
+)
+
+// Feature_Values provides list of valid Feature values.
+var Feature_Values = []Feature{
+	Feature_AuditLog,
+	Feature_Webhooks,
+}
+
+// Limit represents a quota of a plan.
+type Limit string
+
+// Limit values define the quotas that can be set for a plan.
+const (
+	// Limit_Projects is the max number of projects that are not archived.
+	Limit_Projects Limit = "projects"
+	// Limit_Seats is the max number of users that are active or invited.
+	Limit_Seats Limit = "seats"
+	// Limit_ApiRequests is the max number of API requests per month.
+	Limit_ApiRequests Limit = "api_requests"
+)
+
+// Limit_Values provides list of valid Limit values.
+var Limit_Values = []Limit{
+	Limit_Projects,
+	Limit_Seats,
+	Limit_ApiRequests,
+}
+
+// Metered returns true when the usage of the limit is counted per month instead of the number of existing resources.
+func (l Limit) Metered() bool {
+	return l == Limit_ApiRequests
+}
+
+// String converts the Limit value to a string.
+func (l Limit) String() string {
+	return string(l)
+}
+
+// PlanID values define the plans used when an account doesn't have a subscription.
+const (
+	// PlanID_Unlimited includes all the features without any limits.
+	PlanID_Unlimited = "unlimited"
+	// PlanID_Free can be set as the Repository.DefaultPlanID to limit accounts without a subscription.
+	PlanID_Free = "free"
+)
+
+// Plan defines the features and limits of a billing plan.
+type Plan struct {
+	Features []Feature
+
+	// Limits are the max usage for each limit, limits not included are unlimited.
+	Limits map[Limit]int
+}
+
+// HasFeature returns true when the feature is included in the plan.
+func (p Plan) HasFeature(feature Feature) bool {
+	for _, f := range p.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Plans maps the ID of a billing plan to its entitlements.
+type Plans map[string]Plan
+
+// DefaultPlans are the entitlements of the billing.DefaultPlans. The seats should match the max seats of the
+// billing plans.
+var DefaultPlans = Plans{
+	PlanID_Unlimited: {
+		Features: Feature_Values,
+	},
+	PlanID_Free: {
+		Limits: map[Limit]int{
+			Limit_Projects:    3,
+			Limit_Seats:       3,
+			Limit_ApiRequests: 1000,
+		},
+	},
+	"starter": {
+		Features: []Feature{Feature_AuditLog},
+		Limits: map[Limit]int{
+			Limit_Projects:    10,
+			Limit_Seats:       5,
+			Limit_ApiRequests: 50000,
+		},
+	},
+	"team": {
+		Features: []Feature{Feature_AuditLog, Feature_Webhooks},
+		Limits: map[Limit]int{
+			Limit_Projects:    100,
+			Limit_Seats:       25,
+			Limit_ApiRequests: 500000,
+		},
+	},
+	"business": {
+		Features: []Feature{Feature_AuditLog, Feature_Webhooks},
+	},
+}
+
+// Usage is the current usage of a limit for an account.
+type Usage struct {
+	Limit Limit
+	Used  int
+
+	// Max is the max usage allowed by the plan, nil when unlimited.
+	Max *int
+}
+
+// Remaining returns how many of the limit can still be used, -1 when unlimited.
+func (u Usage) Remaining() int {
+	if u.Max == nil {
+		return -1
+	} else if u.Used >= *u.Max {
+		return 0
+	}
+	return *u.Max - u.Used
+}
+
+// UsageResponse represents the usage of a limit that is returned for display.
+type UsageResponse struct {
+	Limit     string `json:"limit" example:"projects"`
+	Used      int    `json:"used" example:"4"`
+	Max       *int   `json:"max,omitempty" example:"10"`      // Max is not included when unlimited.
+	Remaining *int   `json:"remaining,omitempty" example:"6"` // Remaining is not included when unlimited.
+}
+
+// Response transforms Usage to the UsageResponse that is used for display.
+func (u Usage) Response(ctx context.Context) UsageResponse {
+	r := UsageResponse{
+		Limit: u.Limit.String(),
+		Used:  u.Used,
+		Max:   u.Max,
+	}
+	if u.Max != nil {
+		rem := u.Remaining()
+		r.Remaining = &rem
+	}
+	return r
+}
+
+// Entitlements are the features and the usage of the limits for an account.
+type Entitlements struct {
+	AccountID string
+	PlanID    string
+	Features  []Feature
+	Usage     []Usage
+}
+
+// EntitlementsResponse represents the entitlements of an account that is returned for display.
+type EntitlementsResponse struct {
+	AccountID string          `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	PlanID    string          `json:"plan_id" example:"team"`
+	Features  []string        `json:"features" example:"audit_log,webhooks"`
+	Usage     []UsageResponse `json:"usage"`
+}
+
+// Response transforms Entitlements to the EntitlementsResponse that is used for display.
+func (m *Entitlements) Response(ctx context.Context) *EntitlementsResponse {
+	if m == nil {
+		return nil
+	}
+
+	r := &EntitlementsResponse{
+		AccountID: m.AccountID,
+		PlanID:    m.PlanID,
+		Features:  []string{},
+		Usage:     []UsageResponse{},
+	}
+
+	for _, f := range m.Features {
+		r.Features = append(r.Features, string(f))
+	}
+	sort.Strings(r.Features)
+
+	for _, u := range m.Usage {
+		r.Usage = append(r.Usage, u.Response(ctx))
+	}
+
+	return r
+}
@@ -0,0 +1,230 @@
+package jobs
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+var (
+	// ErrInvalidSpec occurs when the cron spec of a schedule can't be parsed.
+	ErrInvalidSpec = errors.New("Invalid cron spec")
+)
+
+// cronDescriptors are the predefined schedules supported in place of the five fields.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSpec is a parsed cron spec with the five fields minute, hour, day of month, month and day of week. Each field
+// is the set of values that match.
+type cronSpec struct {
+	minute, hour, dom, month, dow map[int]bool
+
+	// domAny and dowAny are set when the day fields are *, a day matches when either of the restricted fields match.
+	domAny, dowAny bool
+}
+
+// parseCronSpec parses the standard five fields of a cron spec. Each field supports *, a value, a range a-b, a step
+// */n or a-b/n and a list of those separated by a comma. The predefined schedules like @hourly and @daily are also
+// supported.
+func parseCronSpec(spec string) (cronSpec, error) {
+	var s cronSpec
+
+	spec = strings.TrimSpace(spec)
+	if d, ok := cronDescriptors[spec]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return s, errors.WithMessagef(ErrInvalidSpec, "expected 5 fields for %q", spec)
+	}
+
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return s, errors.WithMessagef(err, "minute of %q", spec)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return s, errors.WithMessagef(err, "hour of %q", spec)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return s, errors.WithMessagef(err, "day of month of %q", spec)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return s, errors.WithMessagef(err, "month of %q", spec)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return s, errors.WithMessagef(err, "day of week of %q", spec)
+	}
+
+	// Both 0 and 7 are Sunday.
+	if s.dow[7] {
+		s.dow[0] = true
+		delete(s.dow, 7)
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+
+	return s, nil
+}
+
+// parseCronField returns the set of values within min and max that match the field.
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	vals := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return nil, errors.WithMessagef(ErrInvalidSpec, "invalid step %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			pts := strings.SplitN(part, "-", 2)
+
+			var err error
+			if start, err = strconv.Atoi(pts[0]); err != nil {
+				return nil, errors.WithMessagef(ErrInvalidSpec, "invalid value %q", part)
+			}
+			end = start
+			if len(pts) == 2 {
+				if end, err = strconv.Atoi(pts[1]); err != nil {
+					return nil, errors.WithMessagef(ErrInvalidSpec, "invalid value %q", part)
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return nil, errors.WithMessagef(ErrInvalidSpec, "%q out of range %d-%d", part, min, max)
+		}
+
+		for v := start; v <= end; v += step {
+			vals[v] = true
+		}
+	}
+
+	return vals, nil
+}
+
+// next returns the first time after the provided time the spec is due. A zero time is returned when the spec is not
+// due within the next five years, ie 0 0 30 2 *.
+func (s cronSpec) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case !s.month[int(t.Month())]:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case !s.hour[t.Hour()]:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case !s.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// matchesDay returns true when the day fields match the date. When both day fields are restricted, either of them
+// matching is enough.
+func (s cronSpec) matchesDay(t time.Time) bool {
+	dom := s.dom[t.Day()]
+	dow := s.dow[int(t.Weekday())]
+
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Schedule enqueues the job each time the cron spec is due. The spec uses the five standard fields, minute hour
+// day-of-month month day-of-week, evaluated in UTC. Multiple workers can define the same schedule, each run is only
+// enqueued once.
+func (repo *Repository) Schedule(name, spec string, req JobEnqueueRequest) error {
+	s, err := parseCronSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.schedules = append(repo.schedules, &schedule{
+		name: name,
+		spec: s,
+		req:  req,
+	})
+
+	return nil
+}
+
+// EnqueueScheduled enqueues the jobs of the schedules that have been due since the last call and returns the number
+// of jobs enqueued. The first call only enqueues the jobs due at the current minute.
+func (repo *Repository) EnqueueScheduled(ctx context.Context, now time.Time) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.jobs.EnqueueScheduled")
+	defer span.Finish()
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var total int
+	for _, s := range repo.schedules {
+		if s.last.IsZero() {
+			s.last = now.Truncate(time.Minute).Add(-time.Nanosecond)
+		}
+
+		for t := s.spec.next(s.last); !t.IsZero() && !t.After(now); t = s.spec.next(t) {
+			req := s.req
+			req.RunAt = &t
+			req.UniqueKey = uniqueKey(s.name, t)
+
+			_, err := repo.Enqueue(ctx, req, now)
+			if err != nil && errors.Cause(err) != ErrDuplicate {
+				return total, err
+			} else if err == nil {
+				total++
+			}
+		}
+
+		s.last = now
+	}
+
+	return total, nil
+}
@@ -0,0 +1,231 @@
+package jobs
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/notify"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const (
+	// JobType_SendEmail is the type of the jobs that send the emails enqueued by EmailQueue.
+	JobType_SendEmail = "email.send"
+)
+
+// EmailQueue is an implementation of notify.Email that enqueues the emails to be sent by a worker so they are not
+// sent during the request. The emails are encrypted with the secret key before they are stored in the queue as they
+// can include secrets, ie the links to reset a password or accept an invite.
+type EmailQueue struct {
+	DbConn    *sqlx.DB
+	secretKey string
+}
+
+// NewEmailQueue creates a new EmailQueue that enqueues the emails with the database connection. The worker must use
+// the same secret key to send the emails.
+func NewEmailQueue(db *sqlx.DB, secretKey string) *EmailQueue {
+	return &EmailQueue{
+		DbConn:    db,
+		secretKey: secretKey,
+	}
+}
+
+// emailPayload is the email that is encrypted in the payload of the jobs that send an email.
+type emailPayload struct {
+	ToEmail      string                 `json:"to_email"`
+	Subject      string                 `json:"subject"`
+	TemplateName string                 `json:"template_name"`
+	Data         map[string]interface{} `json:"data"`
+}
+
+// sealedEmailPayload is the payload of the jobs that send an email. Only the template name is left unencrypted so
+// the jobs on the dead-letter list can be identified.
+type sealedEmailPayload struct {
+	TemplateName string `json:"template_name"`
+	Sealed       []byte `json:"sealed"`
+}
+
+// Send enqueues the email to be sent by a worker.
+func (q *EmailQueue) Send(ctx context.Context, toEmail, subject, templateName string, data map[string]interface{}) error {
+	p := emailPayload{
+		ToEmail:      toEmail,
+		Subject:      subject,
+		TemplateName: templateName,
+	}
+	if data != nil {
+		p.Data = templateData(data).(map[string]interface{})
+	}
+
+	sealed, err := sealEmail(q.secretKey, p)
+	if err != nil {
+		return errors.WithMessagef(err, "Encrypt email %s to %s failed.", templateName, toEmail)
+	}
+
+	_, err = Enqueue(ctx, q.DbConn, JobEnqueueRequest{
+		Type: JobType_SendEmail,
+		Payload: sealedEmailPayload{
+			TemplateName: templateName,
+			Sealed:       sealed,
+		},
+	}, time.Now())
+	if err != nil {
+		return errors.WithMessagef(err, "Enqueue email %s to %s failed.", templateName, toEmail)
+	}
+
+	return nil
+}
+
+// Verify ensures the queue can be used to send emails. The provider is verified by the worker.
+func (q *EmailQueue) Verify() error {
+	return nil
+}
+
+// SendEmailHandler returns the handler that sends the emails enqueued by EmailQueue with the provided notify.Email.
+// The secret key must be the same one used by EmailQueue.
+func SendEmailHandler(notifyEmail notify.Email, secretKey string) Handler {
+	return func(ctx context.Context, job *Job) error {
+		var sp sealedEmailPayload
+		if err := job.Decode(&sp); err != nil {
+			return err
+		}
+
+		p, err := openEmail(secretKey, sp.Sealed)
+		if err != nil {
+			return errors.WithMessagef(err, "Decrypt email %s failed.", sp.TemplateName)
+		}
+
+		return notifyEmail.Send(ctx, p.ToEmail, p.Subject, p.TemplateName, p.Data)
+	}
+}
+
+// sealEmail encrypts the email with AES-GCM using a key derived from the secret key. The nonce is prepended to the
+// result.
+func sealEmail(secretKey string, p emailPayload) ([]byte, error) {
+	aead, err := emailCipher(secretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dat, err := json.Marshal(p)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return aead.Seal(nonce, nonce, dat, nil), nil
+}
+
+// openEmail decrypts the email encrypted by sealEmail.
+func openEmail(secretKey string, sealed []byte) (emailPayload, error) {
+	var p emailPayload
+
+	aead, err := emailCipher(secretKey)
+	if err != nil {
+		return p, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return p, errors.New("sealed email is too short")
+	}
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	dat, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return p, errors.WithStack(err)
+	}
+
+	if err := json.Unmarshal(dat, &p); err != nil {
+		return p, errors.WithStack(err)
+	}
+
+	return p, nil
+}
+
+// emailCipher returns the AES-GCM cipher for the emails.
+func emailCipher(secretKey string) (cipher.AEAD, error) {
+	if secretKey == "" {
+		return nil, errors.New("secret key is required to encrypt emails")
+	}
+
+	key := sha256.Sum256([]byte(secretKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return aead, nil
+}
+
+// templateData converts the structs included in the data of an email to maps keyed by the field names. The payload
+// of the job is JSON encoded, without the conversion the fields would be keyed by their JSON names and the email
+// templates that reference the fields, ie {{ .FromUser.FirstName }}, would no longer render them.
+func templateData(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	// Times are encoded as strings and can't be used by the templates as a struct.
+	if t, ok := v.(time.Time); ok {
+		return t
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return templateData(rv.Elem().Interface())
+
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			f := rt.Field(i)
+			if f.PkgPath != "" {
+				// Skip unexported fields.
+				continue
+			}
+			m[f.Name] = templateData(rv.Field(i).Interface())
+		}
+		return m
+
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return v
+		}
+		m := make(map[string]interface{})
+		for _, k := range rv.MapKeys() {
+			m[k.String()] = templateData(rv.MapIndex(k).Interface())
+		}
+		return m
+
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return v
+		}
+		l := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			l[i] = templateData(rv.Index(i).Interface())
+		}
+		return l
+	}
+
+	return v
+}
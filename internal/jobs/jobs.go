@@ -0,0 +1,515 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// The database table for Job
+	jobTableName = "jobs"
+
+	// DefaultMaxAttempts is the number of times a job is run before it's moved to the dead-letter list.
+	DefaultMaxAttempts = 8
+
+	// DefaultRetryBackoff is the delay before the first retry.
+	DefaultRetryBackoff = 30 * time.Second
+
+	// DefaultLease is how long a job is reserved while it runs.
+	DefaultLease = 5 * time.Minute
+
+	// DefaultBatchSize is the max number of jobs run by each call to RunPending.
+	DefaultBatchSize = 10
+)
+
+var (
+	// ErrNotFound abstracts the postgres not found error.
+	ErrNotFound = errors.New("Entity not found")
+
+	// ErrDuplicate occurs when a job is enqueued with a unique key that already exists.
+	ErrDuplicate = errors.New("Job already enqueued")
+
+	// ErrNoHandler occurs when a job is run that does not have a registered handler.
+	ErrNoHandler = errors.New("No handler registered for job type")
+
+	// ErrLeaseLost occurs when the result of a job is saved after its lease expired and it was reserved again.
+	ErrLeaseLost = errors.New("Job lease lost")
+)
+
+// jobMapColumns is the list of columns needed for find.
+var jobMapColumns = "id,type,payload,status,attempts,max_attempts,unique_key,error,run_at,created_at,updated_at"
+
+// Register sets the handler used to run the jobs of the provided type.
+func (repo *Repository) Register(jobType string, h Handler) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.handlers == nil {
+		repo.handlers = make(map[string]Handler)
+	}
+	repo.handlers[jobType] = h
+}
+
+// handler returns the handler registered for the job type.
+func (repo *Repository) handler(jobType string) (Handler, bool) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	h, ok := repo.handlers[jobType]
+	return h, ok
+}
+
+// Enqueue adds a new job to the queue. Jobs with a unique key are only added once, ErrDuplicate is returned when a
+// job with the same unique key already exists.
+func Enqueue(ctx context.Context, dbConn *sqlx.DB, req JobEnqueueRequest, now time.Time) (*Job, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.jobs.Enqueue")
+	defer span.Finish()
+
+	v := webcontext.Validator()
+
+	// Validate the request.
+	err := v.StructCtx(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	payload, err := json.Marshal(req.Payload)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encode payload for %s failed", req.Type)
+	}
+
+	m := Job{
+		ID:          uuid.NewRandom().String(),
+		Type:        req.Type,
+		Payload:     payload,
+		Status:      JobStatus_Pending,
+		MaxAttempts: req.MaxAttempts,
+		RunAt:       now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if m.MaxAttempts == 0 {
+		m.MaxAttempts = DefaultMaxAttempts
+	}
+	if req.RunAt != nil && !req.RunAt.IsZero() {
+		m.RunAt = req.RunAt.UTC().Truncate(time.Millisecond)
+	}
+	if req.UniqueKey != "" {
+		m.UniqueKey = &req.UniqueKey
+	}
+
+	// Jobs with a unique key that already exists are skipped.
+	queryStr := dbConn.Rebind(`INSERT INTO ` + jobTableName + ` (id, type, payload, status, attempts, max_attempts, unique_key, error, run_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, 0, ?, ?, '', ?, ?, ?)
+		ON CONFLICT (unique_key) DO NOTHING`)
+
+	res, err := dbConn.ExecContext(ctx, queryStr, m.ID, m.Type, []byte(m.Payload), m.Status, m.MaxAttempts, m.UniqueKey, m.RunAt, m.CreatedAt, m.UpdatedAt)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", queryStr)
+		err = errors.WithMessagef(err, "enqueue job %s failed", m.Type)
+		return nil, err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, errors.WithMessagef(ErrDuplicate, "job with unique key %s", req.UniqueKey)
+	}
+
+	return &m, nil
+}
+
+// Enqueue adds a new job to the queue using the database connection of the repository.
+func (repo *Repository) Enqueue(ctx context.Context, req JobEnqueueRequest, now time.Time) (*Job, error) {
+	if req.MaxAttempts == 0 {
+		req.MaxAttempts = repo.MaxAttempts
+	}
+	return Enqueue(ctx, repo.DbConn, req, now)
+}
+
+// RunPending runs the jobs that are due and returns the number of jobs run. Each job is reserved with a lease right
+// before it's run so multiple workers can call RunPending concurrently. Jobs that fail are retried with an exponential
+// backoff until the max attempts is reached and then moved to the dead-letter list. Jobs interrupted by the
+// cancellation of the context are released without counting the attempt.
+func (repo *Repository) RunPending(ctx context.Context, now time.Time) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.jobs.RunPending")
+	defer span.Finish()
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// Jobs are reserved one at a time right before they are run so the lease of a job doesn't expire while the jobs
+	// before it are running.
+	start := time.Now()
+
+	var n int
+	for n < repo.BatchSize {
+		// Don't reserve any more jobs once the worker is shutting down.
+		if ctx.Err() != nil {
+			break
+		}
+
+		j, err := repo.reserveJob(ctx, now, now.Add(time.Since(start)+repo.Lease))
+		if err != nil {
+			return n, err
+		} else if j == nil {
+			break
+		}
+
+		repo.run(ctx, j, now)
+		n++
+
+		// Save the result even when the context was cancelled so the job is released. The result of a job that ran
+		// past its lease is dropped as the job has been picked up again by another worker.
+		err = repo.saveJob(context.Background(), j, now)
+		if err != nil && errors.Cause(err) != ErrLeaseLost {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// reserveJob reserves the next job that is due by moving the run at forward to the end of the lease. Running jobs
+// with an expired lease were interrupted and are picked up again. Rows locked by another worker are skipped. Nil is
+// returned when there are no jobs due.
+func (repo *Repository) reserveJob(ctx context.Context, now, leaseUntil time.Time) (*Job, error) {
+	lockedBy := uuid.NewRandom().String()
+
+	queryStr := repo.DbConn.Rebind(`UPDATE ` + jobTableName + ` SET status = ?, attempts = attempts + 1, locked_by = ?, run_at = ?, updated_at = ?
+		WHERE id = (
+			SELECT id FROM ` + jobTableName + ` WHERE status IN (?, ?) AND run_at <= ?
+			ORDER BY run_at LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING ` + jobMapColumns)
+
+	rows, err := repo.DbConn.QueryContext(ctx, queryStr, JobStatus_Running, lockedBy, leaseUntil.Truncate(time.Millisecond), now,
+		JobStatus_Pending, JobStatus_Running, now)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", queryStr)
+		err = errors.WithMessage(err, "reserve job failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			err = errors.Wrapf(err, "query - %s", queryStr)
+			err = errors.WithMessage(err, "reserve job failed")
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	j, err := mapRowsToJob(rows)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", queryStr)
+		return nil, err
+	}
+	j.lockedBy = lockedBy
+
+	return j, nil
+}
+
+// run calls the handler for the job and updates the job with the result.
+func (repo *Repository) run(ctx context.Context, j *Job, now time.Time) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.jobs.run")
+	span.SetTag("job.type", j.Type)
+	defer span.Finish()
+
+	err := func() (err error) {
+		// Jobs reserved while the worker starts shutting down are released.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		h, ok := repo.handler(j.Type)
+		if !ok {
+			return errors.WithMessage(ErrNoHandler, j.Type)
+		}
+
+		// Cancel the job before the lease expires and it's picked up by another worker.
+		ctx, cancel := context.WithTimeout(ctx, repo.Lease)
+		defer cancel()
+
+		// A panic in a handler should only fail the job and not the worker.
+		defer func() {
+			if r := recover(); r != nil {
+				err = errors.Errorf("panic: %v", r)
+			}
+		}()
+
+		return h(ctx, j)
+	}()
+
+	if err == nil {
+		j.Status = JobStatus_Succeeded
+		j.Error = ""
+		return
+	}
+
+	j.Error = err.Error()
+
+	// The worker is shutting down, release the job so it can be run again right away.
+	if ctx.Err() != nil {
+		j.Status = JobStatus_Pending
+		j.Attempts--
+		j.RunAt = now
+		return
+	}
+
+	if j.Attempts >= j.MaxAttempts {
+		j.Status = JobStatus_Dead
+		return
+	}
+
+	// Double the delay for each additional attempt.
+	j.Status = JobStatus_Pending
+	j.RunAt = now.Add(repo.RetryBackoff * time.Duration(1<<uint(j.Attempts-1)))
+}
+
+// saveJob updates the job with the result of the latest attempt and releases the lease. ErrLeaseLost is returned
+// when the job is no longer reserved by this attempt.
+func (repo *Repository) saveJob(ctx context.Context, j *Job, now time.Time) error {
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(jobTableName)
+	query.Set(
+		query.Assign("status", j.Status),
+		query.Assign("attempts", j.Attempts),
+		query.Assign("error", j.Error),
+		query.Assign("run_at", j.RunAt),
+		query.Assign("locked_by", nil),
+		query.Assign("updated_at", now),
+	)
+	query.Where(query.And(
+		query.Equal("id", j.ID),
+		query.Equal("locked_by", j.lockedBy),
+	))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	res, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "update job %s failed", j.ID)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.WithMessagef(ErrLeaseLost, "job %s", j.ID)
+	}
+
+	return nil
+}
+
+// Find gets all the jobs from the database based on the request params.
+func (repo *Repository) Find(ctx context.Context, req JobFindRequest) (Jobs, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.jobs.Find")
+	defer span.Finish()
+
+	query := sqlbuilder.NewSelectBuilder()
+	query.Select(jobMapColumns)
+	query.From(jobTableName)
+
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, JobFindFields); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(req.Order) > 0 {
+		query.OrderBy(req.Order...)
+	} else {
+		query.OrderBy("run_at")
+	}
+
+	if req.Limit != nil {
+		query.Limit(int(*req.Limit))
+	}
+
+	if req.Offset != nil {
+		query.Offset(int(*req.Offset))
+	}
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	// Fetch all entries from the db.
+	rows, err := repo.DbConn.QueryContext(ctx, queryStr, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find jobs failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over each row.
+	resp := []*Job{}
+	for rows.Next() {
+		u, err := mapRowsToJob(rows)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+		resp = append(resp, u)
+	}
+
+	return resp, nil
+}
+
+// FindDead returns the jobs on the dead-letter list, the most recent failures first.
+func (repo *Repository) FindDead(ctx context.Context, limit uint) (Jobs, error) {
+	return repo.Find(ctx, JobFindRequest{
+		Filter: filter.Filter{
+			{Field: "status", Operator: filter.Op_Eq, Values: []string{JobStatus_Dead.String()}},
+		},
+		Order: []string{"updated_at desc"},
+		Limit: &limit,
+	})
+}
+
+// Read gets the specified job from the database.
+func (repo *Repository) Read(ctx context.Context, id string) (*Job, error) {
+	res, err := repo.Find(ctx, JobFindRequest{
+		Filter: filter.Filter{
+			{Field: "id", Operator: filter.Op_Eq, Values: []string{id}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	} else if len(res) == 0 {
+		err = errors.WithMessagef(ErrNotFound, "job %s not found", id)
+		return nil, err
+	}
+
+	return res[0], nil
+}
+
+// Retry moves a job on the dead-letter list back to the queue with its attempts reset.
+func (repo *Repository) Retry(ctx context.Context, id string, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.jobs.Retry")
+	defer span.Finish()
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(jobTableName)
+	query.Set(
+		query.Assign("status", JobStatus_Pending),
+		query.Assign("attempts", 0),
+		query.Assign("run_at", now),
+		query.Assign("updated_at", now),
+	)
+	query.Where(query.And(
+		query.Equal("id", id),
+		query.Equal("status", JobStatus_Dead),
+	))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	res, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "retry job %s failed", id)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.WithMessagef(ErrNotFound, "dead job %s not found", id)
+	}
+
+	return nil
+}
+
+// Prune deletes the jobs that succeeded before the provided time and returns the number of jobs deleted.
+func (repo *Repository) Prune(ctx context.Context, before time.Time) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.jobs.Prune")
+	defer span.Finish()
+
+	query := sqlbuilder.NewDeleteBuilder()
+	query.DeleteFrom(jobTableName)
+	query.Where(query.And(
+		query.Equal("status", JobStatus_Succeeded),
+		query.LessThan("updated_at", before.UTC()),
+	))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	res, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "prune jobs failed")
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return int(n), nil
+}
+
+// mapRowsToJob takes the SQL rows and maps it to the Job struct
+// with the columns defined by jobMapColumns
+func mapRowsToJob(rows *sql.Rows) (*Job, error) {
+	var (
+		m       Job
+		payload []byte
+		err     error
+	)
+	err = rows.Scan(&m.ID, &m.Type, &payload, &m.Status, &m.Attempts, &m.MaxAttempts, &m.UniqueKey, &m.Error, &m.RunAt, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	m.Payload = payload
+
+	return &m, nil
+}
+
+// uniqueKey returns the unique key for a scheduled job so each run is only enqueued once across workers.
+func uniqueKey(name string, t time.Time) string {
+	return fmt.Sprintf("%s|%d", name, t.Unix())
+}
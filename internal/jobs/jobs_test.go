@@ -0,0 +1,503 @@
+package jobs
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	test *tests.Test
+	repo *Repository
+)
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+
+	repo = NewRepository(test.MasterDB)
+
+	return m.Run()
+}
+
+// mockEmail records the emails sent.
+type mockEmail struct {
+	sent []map[string]interface{}
+}
+
+// Send records the email.
+func (n *mockEmail) Send(ctx context.Context, toEmail, subject, templateName string, data map[string]interface{}) error {
+	n.sent = append(n.sent, data)
+	return nil
+}
+
+// Verify does nothing.
+func (n *mockEmail) Verify() error {
+	return nil
+}
+
+// TestEnqueue validates jobs are run by RunPending.
+func TestEnqueue(t *testing.T) {
+
+	t.Log("Given the need to run jobs in the background.")
+	{
+		ctx := tests.Context()
+
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		type payload struct {
+			Name string
+		}
+
+		jobType := "test." + uuid.NewRandom().String()
+
+		var got []string
+		repo.Register(jobType, func(ctx context.Context, job *Job) error {
+			var p payload
+			if err := job.Decode(&p); err != nil {
+				return err
+			}
+			got = append(got, p.Name)
+			return nil
+		})
+
+		job, err := repo.Enqueue(ctx, JobEnqueueRequest{
+			Type:    jobType,
+			Payload: payload{Name: "Lee"},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tEnqueue failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tEnqueue ok.", tests.Success)
+
+		n, err := repo.RunPending(ctx, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRunPending failed.", tests.Failed)
+		} else if n != 1 || len(got) != 1 || got[0] != "Lee" {
+			t.Logf("\t\tGot : %d %v", n, got)
+			t.Logf("\t\tWant: %d %v", 1, []string{"Lee"})
+			t.Fatalf("\t%s\tRunPending failed.", tests.Failed)
+		}
+
+		res, err := repo.Read(ctx, job.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead failed.", tests.Failed)
+		} else if res.Status != JobStatus_Succeeded || res.Attempts != 1 {
+			t.Logf("\t\tGot : %s %d", res.Status, res.Attempts)
+			t.Logf("\t\tWant: %s %d", JobStatus_Succeeded, 1)
+			t.Fatalf("\t%s\tRead status failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRunPending ok.", tests.Success)
+
+		// Ensure the job is not run again.
+		n, err = repo.RunPending(ctx, now.Add(time.Hour))
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRunPending failed.", tests.Failed)
+		} else if n != 0 {
+			t.Logf("\t\tGot : %d", n)
+			t.Logf("\t\tWant: %d", 0)
+			t.Fatalf("\t%s\tRunPending again failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRunPending again ok.", tests.Success)
+	}
+}
+
+// TestRetry validates failed jobs are retried with a backoff and moved to the dead-letter list.
+func TestRetry(t *testing.T) {
+
+	t.Log("Given the need to retry jobs that fail.")
+	{
+		ctx := tests.Context()
+
+		now := time.Date(2018, time.November, 1, 0, 0, 0, 0, time.UTC)
+
+		jobType := "test." + uuid.NewRandom().String()
+
+		fail := true
+		repo.Register(jobType, func(ctx context.Context, job *Job) error {
+			if fail {
+				return errors.New("failed")
+			}
+			return nil
+		})
+
+		job, err := repo.Enqueue(ctx, JobEnqueueRequest{
+			Type:        jobType,
+			MaxAttempts: 2,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tEnqueue failed.", tests.Failed)
+		}
+
+		// The first attempt fails and the job is retried after the backoff.
+		if _, err := repo.RunPending(ctx, now); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRunPending failed.", tests.Failed)
+		}
+
+		res, err := repo.Read(ctx, job.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead failed.", tests.Failed)
+		} else if res.Status != JobStatus_Pending || res.Attempts != 1 || !res.RunAt.Equal(now.Add(repo.RetryBackoff)) || res.Error != "failed" {
+			t.Logf("\t\tGot : %s %d %s %s", res.Status, res.Attempts, res.RunAt, res.Error)
+			t.Logf("\t\tWant: %s %d %s %s", JobStatus_Pending, 1, now.Add(repo.RetryBackoff), "failed")
+			t.Fatalf("\t%s\tRetry backoff failed.", tests.Failed)
+		}
+
+		// Ensure the job is not run before the backoff.
+		n, err := repo.RunPending(ctx, now.Add(repo.RetryBackoff/2))
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRunPending failed.", tests.Failed)
+		} else if n != 0 {
+			t.Logf("\t\tGot : %d", n)
+			t.Logf("\t\tWant: %d", 0)
+			t.Fatalf("\t%s\tRetry backoff failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRetry backoff ok.", tests.Success)
+
+		// The second attempt fails and the job is moved to the dead-letter list.
+		if _, err := repo.RunPending(ctx, now.Add(repo.RetryBackoff)); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRunPending failed.", tests.Failed)
+		}
+
+		dead, err := repo.FindDead(ctx, 100)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tFindDead failed.", tests.Failed)
+		}
+
+		var found bool
+		for _, d := range dead {
+			if d.ID == job.ID {
+				found = d.Attempts == 2
+			}
+		}
+		if !found {
+			t.Logf("\t\tGot : %d dead jobs", len(dead))
+			t.Logf("\t\tWant: %s", job.ID)
+			t.Fatalf("\t%s\tFindDead failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tFindDead ok.", tests.Success)
+
+		// Move the job back to the queue and ensure it's run.
+		retryNow := now.Add(time.Hour)
+		if err := repo.Retry(ctx, job.ID, retryNow); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRetry failed.", tests.Failed)
+		}
+
+		fail = false
+		if _, err := repo.RunPending(ctx, retryNow); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRunPending failed.", tests.Failed)
+		}
+
+		res, err = repo.Read(ctx, job.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead failed.", tests.Failed)
+		} else if res.Status != JobStatus_Succeeded || res.Attempts != 1 {
+			t.Logf("\t\tGot : %s %d", res.Status, res.Attempts)
+			t.Logf("\t\tWant: %s %d", JobStatus_Succeeded, 1)
+			t.Fatalf("\t%s\tRetry failed.", tests.Failed)
+		}
+
+		// Only dead jobs can be retried.
+		err = repo.Retry(ctx, job.ID, retryNow)
+		if errors.Cause(err) != ErrNotFound {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrNotFound)
+			t.Fatalf("\t%s\tRetry succeeded job failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRetry ok.", tests.Success)
+	}
+}
+
+// TestLeaseLost validates the result of a job that ran past its lease doesn't overwrite the worker that picked it up.
+func TestLeaseLost(t *testing.T) {
+
+	t.Log("Given the need to only save the result of a job for the worker holding the lease.")
+	{
+		ctx := tests.Context()
+
+		now := time.Date(2018, time.December, 1, 0, 0, 0, 0, time.UTC)
+
+		jobType := "test." + uuid.NewRandom().String()
+
+		// The lease expires while the job is running and another worker reserves the job again.
+		var second *Job
+		repo.Register(jobType, func(ctx context.Context, job *Job) error {
+			var err error
+			second, err = repo.reserveJob(ctx, now.Add(2*repo.Lease), now.Add(3*repo.Lease))
+			return err
+		})
+
+		job, err := repo.Enqueue(ctx, JobEnqueueRequest{
+			Type: jobType,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tEnqueue failed.", tests.Failed)
+		}
+
+		if _, err := repo.RunPending(ctx, now); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRunPending failed.", tests.Failed)
+		} else if second == nil || second.ID != job.ID {
+			t.Logf("\t\tGot : %v", second)
+			t.Logf("\t\tWant: %s", job.ID)
+			t.Fatalf("\t%s\tReserve again failed.", tests.Failed)
+		}
+
+		res, err := repo.Read(ctx, job.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead failed.", tests.Failed)
+		} else if res.Status != JobStatus_Running || res.Attempts != 2 {
+			t.Logf("\t\tGot : %s %d", res.Status, res.Attempts)
+			t.Logf("\t\tWant: %s %d", JobStatus_Running, 2)
+			t.Fatalf("\t%s\tLease lost failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tLease lost ok.", tests.Success)
+
+		// The worker holding the lease saves the result.
+		second.Status = JobStatus_Succeeded
+		if err := repo.saveJob(ctx, second, now.Add(2*repo.Lease)); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSave failed.", tests.Failed)
+		}
+
+		res, err = repo.Read(ctx, job.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead failed.", tests.Failed)
+		} else if res.Status != JobStatus_Succeeded {
+			t.Logf("\t\tGot : %s", res.Status)
+			t.Logf("\t\tWant: %s", JobStatus_Succeeded)
+			t.Fatalf("\t%s\tSave failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tSave ok.", tests.Success)
+	}
+}
+
+// TestEmailQueue validates emails are enqueued and sent with the data the templates expect.
+func TestEmailQueue(t *testing.T) {
+
+	t.Log("Given the need to send emails in the background.")
+	{
+		ctx := tests.Context()
+
+		now := time.Date(2018, time.December, 1, 0, 0, 0, 0, time.UTC)
+
+		type user struct {
+			FirstName string `json:"first_name"`
+		}
+
+		secretKey := uuid.NewRandom().String()
+
+		notifyEmail := &mockEmail{}
+		repo.Register(JobType_SendEmail, SendEmailHandler(notifyEmail, secretKey))
+
+		inviteUrl := "https://example.com/invite/" + uuid.NewRandom().String()
+
+		err := NewEmailQueue(test.MasterDB, secretKey).Send(ctx, "lee@example.com", "Invite", "user_invite", map[string]interface{}{
+			"FromUser": &user{FirstName: "Lee"},
+			"Minutes":  90,
+			"Url":      inviteUrl,
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSend failed.", tests.Failed)
+		}
+
+		// Ensure the link included in the email is not stored in the queue.
+		var stored int
+		err = test.MasterDB.GetContext(ctx, &stored, test.MasterDB.Rebind(
+			"SELECT count(*) FROM jobs WHERE payload::text LIKE ?"), "%"+inviteUrl+"%")
+		if err != nil || stored != 0 {
+			t.Logf("\t\tGot : %d %+v", stored, err)
+			t.Logf("\t\tWant: %d", 0)
+			t.Fatalf("\t%s\tSend encrypted failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tSend encrypted ok.", tests.Success)
+
+		// The email is enqueued with the current time.
+		if _, err := repo.RunPending(ctx, time.Now()); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRunPending failed.", tests.Failed)
+		}
+
+		if len(notifyEmail.sent) != 1 {
+			t.Logf("\t\tGot : %d", len(notifyEmail.sent))
+			t.Logf("\t\tWant: %d", 1)
+			t.Fatalf("\t%s\tSend email failed.", tests.Failed)
+		}
+
+		// The fields of the structs should be keyed by their name and not the JSON tag.
+		fromUser, ok := notifyEmail.sent[0]["FromUser"].(map[string]interface{})
+		if !ok || fromUser["FirstName"] != "Lee" {
+			t.Logf("\t\tGot : %#v", notifyEmail.sent[0])
+			t.Logf("\t\tWant: FromUser.FirstName = Lee")
+			t.Fatalf("\t%s\tSend email data failed.", tests.Failed)
+		}
+
+		if notifyEmail.sent[0]["Url"] != inviteUrl {
+			t.Logf("\t\tGot : %v", notifyEmail.sent[0]["Url"])
+			t.Logf("\t\tWant: %s", inviteUrl)
+			t.Fatalf("\t%s\tSend email data failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tSend email ok.", tests.Success)
+
+		// Ensure jobs with the same unique key are only enqueued once.
+		req := JobEnqueueRequest{
+			Type:      JobType_SendEmail,
+			UniqueKey: uuid.NewRandom().String(),
+			RunAt:     &now,
+		}
+		if _, err := repo.Enqueue(ctx, req, now); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tEnqueue failed.", tests.Failed)
+		}
+
+		_, err = repo.Enqueue(ctx, req, now)
+		if errors.Cause(err) != ErrDuplicate {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrDuplicate)
+			t.Fatalf("\t%s\tEnqueue duplicate failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tEnqueue duplicate ok.", tests.Success)
+	}
+}
+
+// TestSchedule validates cron schedules are enqueued once for each time they are due.
+func TestSchedule(t *testing.T) {
+
+	t.Log("Given the need to parse cron specs.")
+	{
+		from := time.Date(2019, time.August, 30, 10, 30, 0, 0, time.UTC)
+
+		var specTests = []struct {
+			spec string
+			want time.Time
+		}{
+			{"@hourly", time.Date(2019, time.August, 30, 11, 0, 0, 0, time.UTC)},
+			{"@daily", time.Date(2019, time.August, 31, 0, 0, 0, 0, time.UTC)},
+			{"@monthly", time.Date(2019, time.September, 1, 0, 0, 0, 0, time.UTC)},
+			{"*/15 * * * *", time.Date(2019, time.August, 30, 10, 45, 0, 0, time.UTC)},
+			{"0 9-17/4 * * *", time.Date(2019, time.August, 30, 13, 0, 0, 0, time.UTC)},
+			{"0 0 * * 1", time.Date(2019, time.September, 2, 0, 0, 0, 0, time.UTC)},
+			{"0 0 * * 7", time.Date(2019, time.September, 1, 0, 0, 0, 0, time.UTC)},
+			{"0 0 1,15 * 1", time.Date(2019, time.September, 1, 0, 0, 0, 0, time.UTC)},
+			{"0 0 29 2 *", time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		}
+
+		for i, tt := range specTests {
+			s, err := parseCronSpec(tt.spec)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tParse %d %s failed.", tests.Failed, i, tt.spec)
+			}
+
+			if got := s.next(from); !got.Equal(tt.want) {
+				t.Logf("\t\tGot : %s", got)
+				t.Logf("\t\tWant: %s", tt.want)
+				t.Fatalf("\t%s\tNext %d %s failed.", tests.Failed, i, tt.spec)
+			}
+			t.Logf("\t%s\tNext %d %s ok.", tests.Success, i, tt.spec)
+		}
+
+		for _, spec := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *"} {
+			if _, err := parseCronSpec(spec); errors.Cause(err) != ErrInvalidSpec {
+				t.Logf("\t\tGot : %+v", err)
+				t.Logf("\t\tWant: %+v", ErrInvalidSpec)
+				t.Fatalf("\t%s\tParse invalid %s failed.", tests.Failed, spec)
+			}
+		}
+		t.Logf("\t%s\tParse invalid ok.", tests.Success)
+	}
+
+	t.Log("Given the need to enqueue jobs on a schedule.")
+	{
+		ctx := tests.Context()
+
+		// Use a time in the future so the scheduled jobs aren't run by the other tests.
+		now := time.Date(2030, time.January, 1, 10, 0, 30, 0, time.UTC)
+
+		name := "test." + uuid.NewRandom().String()
+		req := JobEnqueueRequest{Type: name}
+
+		r1 := NewRepository(test.MasterDB)
+		if err := r1.Schedule(name, "@hourly", req); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSchedule failed.", tests.Failed)
+		}
+
+		// A second worker with the same schedule.
+		r2 := NewRepository(test.MasterDB)
+		if err := r2.Schedule(name, "@hourly", req); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSchedule failed.", tests.Failed)
+		}
+
+		var enqueueTests = []struct {
+			repo *Repository
+			now  time.Time
+			want int
+		}{
+			// The first call enqueues the job due at the current minute.
+			{r1, now, 1},
+			// The second worker skips the job already enqueued.
+			{r2, now, 0},
+			{r1, now.Add(30 * time.Minute), 0},
+			// Each time missed since the last call is enqueued.
+			{r1, now.Add(2*time.Hour + 5*time.Minute), 2},
+			{r2, now.Add(2*time.Hour + 6*time.Minute), 0},
+		}
+
+		for i, tt := range enqueueTests {
+			n, err := tt.repo.EnqueueScheduled(ctx, tt.now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tEnqueueScheduled %d failed.", tests.Failed, i)
+			} else if n != tt.want {
+				t.Logf("\t\tGot : %d", n)
+				t.Logf("\t\tWant: %d", tt.want)
+				t.Fatalf("\t%s\tEnqueueScheduled %d failed.", tests.Failed, i)
+			}
+		}
+
+		res, err := r1.Find(ctx, JobFindRequest{
+			Filter: filter.Filter{
+				{Field: "type", Operator: filter.Op_Eq, Values: []string{name}},
+			},
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tFind failed.", tests.Failed)
+		} else if len(res) != 3 {
+			t.Logf("\t\tGot : %d", len(res))
+			t.Logf("\t\tWant: %d", 3)
+			t.Fatalf("\t%s\tFind scheduled failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tEnqueueScheduled ok.", tests.Success)
+	}
+}
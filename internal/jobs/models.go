@@ -0,0 +1,178 @@
+package jobs
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// Repository defines the required dependencies for Jobs.
+type Repository struct {
+	DbConn *sqlx.DB
+
+	// MaxAttempts is the default number of times a job is run before it's moved to the dead-letter list.
+	MaxAttempts int
+
+	// RetryBackoff is the delay before the first retry, the delay doubles for each additional attempt.
+	RetryBackoff time.Duration
+
+	// Lease is how long a job is reserved while it runs. The context passed to the handler is cancelled once the
+	// lease expires and the job can be picked up again by another worker.
+	Lease time.Duration
+
+	// BatchSize is the max number of jobs run by each call to RunPending.
+	BatchSize int
+
+	mu        sync.Mutex
+	handlers  map[string]Handler
+	schedules []*schedule
+}
+
+// NewRepository creates a new Repository that defines dependencies for Jobs.
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		DbConn:       db,
+		MaxAttempts:  DefaultMaxAttempts,
+		RetryBackoff: DefaultRetryBackoff,
+		Lease:        DefaultLease,
+		BatchSize:    DefaultBatchSize,
+		handlers:     make(map[string]Handler),
+	}
+}
+
+// Handler runs a job. Returning an error schedules the job to be retried.
+type Handler func(ctx context.Context, job *Job) error
+
+// Job represents a unit of work that is run in the background by a worker.
+type Job struct {
+	ID          string          `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	Type        string          `json:"type" validate:"required" example:"email.send"`
+	Payload     json.RawMessage `json:"payload" swaggertype:"string"`
+	Status      JobStatus       `json:"status" validate:"omitempty,oneof=pending running succeeded dead" enums:"pending,running,succeeded,dead" swaggertype:"string" example:"pending"`
+	Attempts    int             `json:"attempts" example:"1"`
+	MaxAttempts int             `json:"max_attempts" example:"8"`
+	UniqueKey   *string         `json:"unique_key,omitempty" example:"closure.purge|1566777600"`
+	Error       string          `json:"error,omitempty"`
+	RunAt       time.Time       `json:"run_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+
+	// lockedBy identifies the reservation of the job, only the reservation holding the lease can save the result.
+	lockedBy string
+}
+
+// Jobs a list of Jobs.
+type Jobs []*Job
+
+// Decode unmarshals the payload of the job into the provided value.
+func (m *Job) Decode(v interface{}) error {
+	if err := json.Unmarshal(m.Payload, v); err != nil {
+		return errors.Wrapf(err, "decode payload of job %s failed", m.ID)
+	}
+	return nil
+}
+
+// JobEnqueueRequest contains information needed to add a new job to the queue.
+type JobEnqueueRequest struct {
+	Type        string      `json:"type" validate:"required" example:"email.send"`
+	Payload     interface{} `json:"payload"`
+	RunAt       *time.Time  `json:"run_at,omitempty"`
+	MaxAttempts int         `json:"max_attempts,omitempty" validate:"omitempty,min=1"`
+	UniqueKey   string      `json:"unique_key,omitempty" validate:"omitempty,max=255"`
+}
+
+// JobFindRequest defines the possible options to search for jobs. By default
+// jobs will be ordered by run_at.
+type JobFindRequest struct {
+	Filter filter.Filter `json:"filter,omitempty"`
+	Order  []string      `json:"order" example:"run_at desc"`
+	Limit  *uint         `json:"limit" example:"10"`
+	Offset *uint         `json:"offset" example:"20"`
+}
+
+// JobFindFields defines the fields of job that can be used to filter and sort a find request.
+var JobFindFields = filter.Fields{
+	{Name: "id", Type: filter.FieldType_UUID, Filterable: true, Key: true},
+	{Name: "type", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "status", Type: filter.FieldType_Enum, Values: filter.EnumValues(JobStatus_ValuesInterface()), Filterable: true, Sortable: true},
+	{Name: "attempts", Type: filter.FieldType_Int, Filterable: true, Sortable: true},
+	{Name: "run_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "updated_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+}
+
+// JobStatus represents the status of a job.
+type JobStatus string
+
+// JobStatus values define the status field of a job.
+const (
+	// JobStatus_Pending defines the state when the job is waiting to be run.
+	JobStatus_Pending JobStatus = "pending"
+	// JobStatus_Running defines the state when the job is reserved by a worker.
+	JobStatus_Running JobStatus = "running"
+	// JobStatus_Succeeded defines the state when the job completed.
+	JobStatus_Succeeded JobStatus = "succeeded"
+	// JobStatus_Dead defines the state when the job failed the max attempts and was moved to the dead-letter list.
+	JobStatus_Dead JobStatus = "dead"
+)
+
+// JobStatus_Values provides list of valid JobStatus values.
+var JobStatus_Values = []JobStatus{
+	JobStatus_Pending,
+	JobStatus_Running,
+	JobStatus_Succeeded,
+	JobStatus_Dead,
+}
+
+// JobStatus_ValuesInterface returns the JobStatus options as a slice interface.
+func JobStatus_ValuesInterface() []interface{} {
+	var l []interface{}
+	for _, v := range JobStatus_Values {
+		l = append(l, v.String())
+	}
+	return l
+}
+
+// Scan supports reading the JobStatus value from the database.
+func (s *JobStatus) Scan(value interface{}) error {
+	asBytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("Scan source is not []byte")
+	}
+
+	*s = JobStatus(string(asBytes))
+	return nil
+}
+
+// Value converts the JobStatus value to be stored in the database.
+func (s JobStatus) Value() (driver.Value, error) {
+	v := validator.New()
+	errs := v.Var(s, "required,oneof=pending running succeeded dead")
+	if errs != nil {
+		return nil, errs
+	}
+
+	return string(s), nil
+}
+
+// String converts the JobStatus value to a string.
+func (s JobStatus) String() string {
+	return string(s)
+}
+
+// schedule defines a job that is enqueued each time the cron spec is due.
+type schedule struct {
+	name string
+	spec cronSpec
+	req  JobEnqueueRequest
+
+	// last is the time the schedule was last checked for jobs that are due.
+	last time.Time
+}
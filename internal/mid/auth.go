@@ -22,7 +22,7 @@ func ErrorForbidden(ctx context.Context) error {
 	)
 }
 
-// Authenticate validates a JWT from the `Authorization` header.
+// Authenticate validates a JWT or an API key from the `Authorization` header.
 func AuthenticateHeader(authenticator *auth.Authenticator) web.Middleware {
 
 	// This is the actual middleware function to be executed.
@@ -41,14 +41,28 @@ func AuthenticateHeader(authenticator *auth.Authenticator) web.Middleware {
 					return weberror.NewError(ctx, err, http.StatusUnauthorized)
 				}
 
-				tknStr, err := parseAuthHeader(authHdr)
+				scheme, tknStr, err := parseAuthHeader(authHdr)
 				if err != nil {
 					return weberror.NewError(ctx, err, http.StatusUnauthorized)
 				}
 
-				claims, err := authenticator.ParseClaims(tknStr)
-				if err != nil {
-					return weberror.NewError(ctx, err, http.StatusUnauthorized)
+				var claims auth.Claims
+				if scheme == authSchemeApiKey {
+					// API keys are resolved to the claims granted to the key.
+					claims, err = authenticator.ParseApiKey(ctx, tknStr)
+					if err != nil {
+						return weberror.NewError(ctx, err, http.StatusUnauthorized)
+					}
+				} else {
+					claims, err = authenticator.ParseClaims(tknStr)
+					if err != nil {
+						return weberror.NewError(ctx, err, http.StatusUnauthorized)
+					}
+
+					// Ensure the session has not been revoked, ie after a password change.
+					if err := authenticator.ValidateSession(ctx, claims); err != nil {
+						return weberror.NewError(ctx, err, http.StatusUnauthorized)
+					}
 				}
 
 				// Add claims to the context so they can be retrieved later.
@@ -73,6 +87,55 @@ func AuthenticateHeader(authenticator *auth.Authenticator) web.Middleware {
 	return f
 }
 
+// AuthenticateApiKey validates an API key from the `Authorization` header. The key can be sent with either the Bearer
+// or the ApiKey scheme for clients that only support bearer tokens, ie SCIM provisioning from an identity provider.
+func AuthenticateApiKey(authenticator *auth.Authenticator) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		// Wrap this handler around the next one provided.
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			span, ctx := tracer.StartSpanFromContext(ctx, "internal.mid.AuthenticateApiKey")
+			defer span.Finish()
+
+			m := func() error {
+
+				authHdr := r.Header.Get("Authorization")
+				if authHdr == "" {
+					err := errors.New("missing Authorization header")
+					return weberror.NewError(ctx, err, http.StatusUnauthorized)
+				}
+
+				_, tknStr, err := parseAuthHeader(authHdr)
+				if err != nil {
+					return weberror.NewError(ctx, err, http.StatusUnauthorized)
+				}
+
+				claims, err := authenticator.ParseApiKey(ctx, tknStr)
+				if err != nil {
+					return weberror.NewError(ctx, err, http.StatusUnauthorized)
+				}
+
+				// Add claims to the context so they can be retrieved later.
+				ctx = context.WithValue(ctx, auth.Key, claims)
+
+				return nil
+			}
+
+			if err := m(); err != nil {
+				return web.RespondJsonError(ctx, w, err)
+			}
+
+			return after(ctx, w, r, params)
+		}
+
+		return h
+	}
+
+	return f
+}
+
 // AuthenticateSessionRequired requires a JWT access token to be loaded from the session.
 func AuthenticateSessionRequired(authenticator *auth.Authenticator) web.Middleware {
 	return authenticateSession(authenticator, true)
@@ -114,6 +177,15 @@ func authenticateSession(authenticator *auth.Authenticator, required bool) web.M
 					}
 				}
 
+				// Ensure the session has not been revoked, ie after a password change.
+				if err := authenticator.ValidateSession(ctx, claims); err != nil {
+					if required {
+						return weberror.NewError(ctx, err, http.StatusUnauthorized)
+					} else {
+						return nil
+					}
+				}
+
 				// Add claims to the context so they can be retrieved later.
 				ctx = context.WithValue(ctx, auth.Key, claims)
 
@@ -176,14 +248,34 @@ func HasAuth() web.Middleware {
 }
 
 // HasRole validates that an authenticated user has at least one role from a
-// specified list. This method constructs the actual function that is used.
+// specified list. Permissions such as auth.PermissionProjectWrite are also
+// accepted so routes can be restricted by either. This method constructs the
+// actual function that is used.
 func HasRole(roles ...string) web.Middleware {
+	return hasClaims("internal.mid.HasRole", func(claims auth.Claims) bool {
+		return claims.HasRole(roles...) || claims.HasPermission(roles...)
+	})
+}
+
+// HasPermission validates that an authenticated user has at least one
+// permission from a specified list, either granted by a built-in role or a
+// custom role defined for the account. This method constructs the actual
+// function that is used.
+func HasPermission(perms ...string) web.Middleware {
+	return hasClaims("internal.mid.HasPermission", func(claims auth.Claims) bool {
+		return claims.HasPermission(perms...)
+	})
+}
+
+// hasClaims constructs the middleware that validates the claims for the
+// request using the provided check.
+func hasClaims(spanName string, check func(claims auth.Claims) bool) web.Middleware {
 
 	// This is the actual middleware function to be executed.
 	f := func(after web.Handler) web.Handler {
 
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
-			span, ctx := tracer.StartSpanFromContext(ctx, "internal.mid.HasRole")
+			span, ctx := tracer.StartSpanFromContext(ctx, spanName)
 			defer span.Finish()
 
 			m := func() error {
@@ -192,7 +284,7 @@ func HasRole(roles ...string) web.Middleware {
 					return err
 				}
 
-				if !claims.HasRole(roles...) {
+				if !check(claims) {
 					return ErrorForbidden(ctx)
 				}
 
@@ -215,13 +307,24 @@ func HasRole(roles ...string) web.Middleware {
 	return f
 }
 
+// The supported schemes for the authorization header.
+const (
+	authSchemeBearer = "bearer"
+	authSchemeApiKey = "apikey"
+)
+
 // parseAuthHeader parses an authorization header. Expected header is of
-// the format `Bearer <token>`.
-func parseAuthHeader(bearerStr string) (string, error) {
+// the format `Bearer <token>` or `ApiKey <key>`.
+func parseAuthHeader(bearerStr string) (string, string, error) {
 	split := strings.Split(bearerStr, " ")
-	if len(split) != 2 || strings.ToLower(split[0]) != "bearer" {
-		return "", errors.New("Expected Authorization header format: Bearer <token>")
+	if len(split) != 2 {
+		return "", "", errors.New("Expected Authorization header format: Bearer <token>")
+	}
+
+	scheme := strings.ToLower(split[0])
+	if scheme != authSchemeBearer && scheme != authSchemeApiKey {
+		return "", "", errors.New("Expected Authorization header format: Bearer <token>")
 	}
 
-	return split[1], nil
+	return scheme, split[1], nil
 }
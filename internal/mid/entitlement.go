@@ -0,0 +1,85 @@
+package mid
+
+import (
+	"context"
+	"net/http"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// HasFeature validates the plan of the account for the authenticated user
+// includes the feature. A 403 is returned when the feature is not included.
+// No middleware is applied when the repository is nil.
+func HasFeature(repo *entitlement.Repository, feature entitlement.Feature) web.Middleware {
+	if repo == nil {
+		return nil
+	}
+
+	return hasEntitlement("internal.mid.HasFeature", func(ctx context.Context, accountID string) error {
+		return repo.HasFeature(ctx, accountID, feature)
+	})
+}
+
+// ConsumeUsage records the request as usage of the metered limit for the
+// account of the authenticated user. A 402 is returned once the usage for the
+// month exceeds the limit of the plan. No middleware is applied when the
+// repository is nil.
+func ConsumeUsage(repo *entitlement.Repository, limit entitlement.Limit) web.Middleware {
+	if repo == nil {
+		return nil
+	}
+
+	return hasEntitlement("internal.mid.ConsumeUsage", func(ctx context.Context, accountID string) error {
+		v, err := webcontext.ContextValues(ctx)
+		if err != nil {
+			return err
+		}
+
+		return repo.Consume(ctx, accountID, limit, 1, v.Now)
+	})
+}
+
+// hasEntitlement constructs the middleware that validates the entitlements
+// of the account for the request using the provided check. Requests without
+// an account are not checked.
+func hasEntitlement(spanName string, check func(ctx context.Context, accountID string) error) web.Middleware {
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			span, ctx := tracer.StartSpanFromContext(ctx, spanName)
+			defer span.Finish()
+
+			m := func() error {
+				claims, err := auth.ClaimsFromContext(ctx)
+				if err != nil {
+					return err
+				}
+
+				if claims.Audience == "" {
+					return nil
+				}
+
+				return entitlement.WebError(ctx, check(ctx, claims.Audience))
+			}
+
+			if err := m(); err != nil {
+				if web.RequestIsJson(r) {
+					return web.RespondJsonError(ctx, w, err)
+				}
+				return err
+			}
+
+			return after(ctx, w, r, params)
+		}
+
+		return h
+	}
+
+	return f
+}
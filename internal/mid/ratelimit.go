@@ -0,0 +1,363 @@
+package mid
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	redistrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/go-redis/redis"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// ErrRateLimitExceeded occurs when a request exceeds one of the rate limits defined for the route.
+var ErrRateLimitExceeded = errors.New("Rate limit exceeded")
+
+// RateLimitBy defines what requests are grouped by to share a rate limit.
+type RateLimitBy string
+
+// RateLimitBy values.
+const (
+	// RateLimitBy_Client groups requests by the API key used to authenticate or by the authenticated user.
+	RateLimitBy_Client RateLimitBy = "client"
+	// RateLimitBy_Account groups requests by the account of the authenticated user, the claims audience.
+	RateLimitBy_Account RateLimitBy = "account"
+	// RateLimitBy_IP groups requests by the IP address making the request. The address is read from the request
+	// values set by web.App, only the X-Forwarded-For addresses appended by its trusted proxies are used so clients
+	// can't get a new bucket by setting the header.
+	RateLimitBy_IP RateLimitBy = "ip"
+)
+
+// RateLimitPolicy defines a token bucket that holds up to Limit tokens and is refilled at the rate of Limit tokens
+// every Period. Each request takes a token from the bucket.
+type RateLimitPolicy struct {
+	By     RateLimitBy
+	Limit  int
+	Period time.Duration
+}
+
+// RateLimitResult is the state of a bucket after a token was requested.
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimitStore persists the token buckets used by RateLimit.
+type RateLimitStore interface {
+	// Take removes a token from the bucket identified by the key and returns the state of the bucket.
+	Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimit limits the number of requests for a route using token buckets for each of the policies. Buckets are
+// namespaced by name so routes can either share limits or have their own. The state of the most restrictive bucket is
+// returned to the client with the X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers and a 429
+// is returned with a Retry-After header once any of the buckets is empty. Policies grouped by client or account need
+// to be applied after the request has been authenticated, requests without claims are not limited by them. No
+// middleware is applied when the store is nil.
+func RateLimit(store RateLimitStore, name string, policies ...RateLimitPolicy) web.Middleware {
+	// Ignore any policies that would never refill the bucket.
+	var valid []RateLimitPolicy
+	for _, p := range policies {
+		if p.Limit > 0 && p.Period > 0 {
+			valid = append(valid, p)
+		}
+	}
+	policies = valid
+
+	if store == nil || len(policies) == 0 {
+		return nil
+	}
+
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		// Wrap this handler around the next one provided.
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			span, ctx := tracer.StartSpanFromContext(ctx, "internal.mid.RateLimit")
+			defer span.Finish()
+
+			m := func() error {
+				v, err := webcontext.ContextValues(ctx)
+				if err != nil {
+					return err
+				}
+
+				var res *RateLimitResult
+				for _, p := range policies {
+					val := rateLimitValue(ctx, p.By)
+					if val == "" {
+						continue
+					}
+
+					key := fmt.Sprintf("%s:%s:%s", name, p.By, val)
+
+					pr, err := store.Take(ctx, key, p, v.Now)
+					if err != nil {
+						return err
+					}
+
+					if res == nil || !pr.Allowed || (res.Allowed && pr.Remaining < res.Remaining) {
+						res = &pr
+					}
+					if !pr.Allowed {
+						break
+					}
+				}
+
+				if res == nil {
+					return nil
+				}
+
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(durationSeconds(res.Reset), 10))
+
+				if !res.Allowed {
+					return weberror.NewRetryAfterError(ctx, ErrRateLimitExceeded, res.RetryAfter,
+						"Rate limit exceeded. Try again later.")
+				}
+
+				return nil
+			}
+
+			if err := m(); err != nil {
+				if web.RequestIsJson(r) {
+					return web.RespondJsonError(ctx, w, err)
+				}
+				return err
+			}
+
+			return after(ctx, w, r, params)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// rateLimitValue returns the value requests are grouped by for the policy.
+func rateLimitValue(ctx context.Context, by RateLimitBy) string {
+	switch by {
+	case RateLimitBy_IP:
+		if v, _ := webcontext.ContextValues(ctx); v != nil {
+			return v.RequestIP
+		}
+	case RateLimitBy_Client, RateLimitBy_Account:
+		claims, err := auth.ClaimsFromContext(ctx)
+		if err != nil {
+			return ""
+		}
+
+		if by == RateLimitBy_Account {
+			return claims.Audience
+		} else if claims.ApiKeyID != "" {
+			return "key-" + claims.ApiKeyID
+		} else if claims.Subject != "" {
+			return "user-" + claims.Subject
+		}
+	}
+
+	return ""
+}
+
+// durationSeconds rounds up the duration to whole seconds.
+func durationSeconds(d time.Duration) int64 {
+	secs := int64(d / time.Second)
+	if d%time.Second > 0 {
+		secs++
+	}
+	return secs
+}
+
+// takeToken refills the bucket for the time elapsed since it was last updated and then removes a token when
+// available. The updated number of tokens is returned with the result.
+func takeToken(policy RateLimitPolicy, tokens float64, elapsed time.Duration) (float64, RateLimitResult) {
+	limit := float64(policy.Limit)
+	rate := limit / float64(policy.Period)
+
+	if elapsed > 0 {
+		tokens = math.Min(limit, tokens+float64(elapsed)*rate)
+	}
+
+	res := RateLimitResult{
+		Limit: policy.Limit,
+	}
+
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - tokens) / rate)
+	}
+
+	res.Remaining = int(math.Floor(tokens))
+	res.Reset = time.Duration((limit - tokens) / rate)
+
+	return tokens, res
+}
+
+// MemoryRateLimitStore keeps the token buckets in memory. It's used for tests and as the fallback when Redis is not
+// available, limits are not shared between instances of the service.
+type MemoryRateLimitStore struct {
+	mtx     sync.Mutex
+	buckets map[string]*memoryBucket
+	takes   int
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	expiresAt time.Time
+}
+
+// NewMemoryRateLimitStore returns an empty in-memory store.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+// Take implements the RateLimitStore interface.
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// Periodically remove the buckets that have been refilled so the store doesn't grow unbounded.
+	s.takes++
+	if s.takes%1000 == 0 {
+		for k, b := range s.buckets {
+			if now.After(b.expiresAt) {
+				delete(s.buckets, k)
+			}
+		}
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(policy.Limit), updatedAt: now}
+		s.buckets[key] = b
+	}
+
+	var res RateLimitResult
+	b.tokens, res = takeToken(policy, b.tokens, now.Sub(b.updatedAt))
+	b.updatedAt = now
+	b.expiresAt = now.Add(policy.Period)
+
+	return res, nil
+}
+
+// rateLimitScript atomically refills and takes a token from a bucket stored as a hash in Redis. The number of tokens
+// is returned as a string since Lua numbers are truncated to integers when converted to Redis replies.
+var rateLimitScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = limit
+	ts = now
+end
+
+local elapsed = now - ts
+if elapsed > 0 then
+	tokens = math.min(limit, tokens + elapsed * limit / period)
+else
+	elapsed = 0
+end
+
+local taken = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	taken = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", math.max(now, ts))
+redis.call("PEXPIRE", KEYS[1], period)
+
+return {taken, tostring(tokens)}
+`)
+
+// RedisRateLimitStore keeps the token buckets in Redis so limits are shared between instances of the service. When
+// Redis is not configured or a request to Redis fails, the in-memory fallback store is used instead.
+type RedisRateLimitStore struct {
+	redis    *redistrace.Client
+	prefix   string
+	fallback RateLimitStore
+}
+
+// NewRedisRateLimitStore returns a store that keeps the buckets in Redis with all keys prefixed by the provided value.
+func NewRedisRateLimitStore(redisClient *redistrace.Client, prefix string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{
+		redis:    redisClient,
+		prefix:   prefix,
+		fallback: NewMemoryRateLimitStore(),
+	}
+}
+
+// Take implements the RateLimitStore interface.
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	if s.redis == nil {
+		return s.fallback.Take(ctx, key, policy, now)
+	}
+
+	res, err := s.take(ctx, key, policy, now)
+	if err != nil {
+		// Rate limits should not take down the API when Redis is unavailable.
+		return s.fallback.Take(ctx, key, policy, now)
+	}
+
+	return res, nil
+}
+
+// take runs the script to take a token from the bucket stored in Redis.
+func (s *RedisRateLimitStore) take(ctx context.Context, key string, policy RateLimitPolicy, now time.Time) (RateLimitResult, error) {
+	periodMs := int64(policy.Period / time.Millisecond)
+	if periodMs <= 0 {
+		periodMs = 1
+	}
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+
+	reply, err := rateLimitScript.Run(s.redis.WithContext(ctx), []string{s.prefix + ":" + key}, policy.Limit, periodMs, nowMs).Result()
+	if err != nil {
+		return RateLimitResult{}, errors.Wrapf(err, "Take token for %s failed", key)
+	}
+
+	vals, ok := reply.([]interface{})
+	if !ok || len(vals) != 2 {
+		return RateLimitResult{}, errors.Errorf("Unexpected reply for %s: %v", key, reply)
+	}
+
+	taken, _ := vals[0].(int64)
+	tokenStr, _ := vals[1].(string)
+
+	tokens, err := strconv.ParseFloat(tokenStr, 64)
+	if err != nil {
+		return RateLimitResult{}, errors.Wrapf(err, "Parse tokens for %s failed", key)
+	}
+
+	// The bucket has already been updated by the script, use the remaining tokens to build the result. A token is
+	// added back when it was taken since takeToken will remove one again.
+	if taken == 1 {
+		tokens++
+	}
+	_, res := takeToken(policy, tokens, 0)
+
+	return res, nil
+}
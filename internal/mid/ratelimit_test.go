@@ -0,0 +1,280 @@
+package mid
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+
+	"github.com/pkg/errors"
+)
+
+// rateLimitRequest executes the middleware for a request made at the provided time and returns the recorded
+// response with the error returned by the middleware.
+func rateLimitRequest(mw func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error, ip string, claims *auth.Claims, now time.Time) (*httptest.ResponseRecorder, error) {
+	ctx := context.WithValue(context.Background(), webcontext.KeyValues, &webcontext.Values{
+		Now:       now,
+		RequestIP: ip,
+		Env:       webcontext.Env_Dev,
+	})
+	if claims != nil {
+		ctx = context.WithValue(ctx, auth.Key, *claims)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/v1/projects", nil)
+
+	err := mw(ctx, w, r, nil)
+
+	return w, err
+}
+
+// rateLimitCause returns the cause of the error returned by the middleware. The middleware returns a weberror.Error
+// which stores the original error in the Cause field, so errors.Cause can't unwrap it.
+func rateLimitCause(err error) error {
+	if webErr, ok := err.(*weberror.Error); ok {
+		return errors.Cause(webErr.Cause)
+	}
+	return errors.Cause(err)
+}
+
+// TestRateLimit validates requests are limited using token buckets.
+func TestRateLimit(t *testing.T) {
+	defer tests.Recover(t)
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	t.Log("Given the need to limit requests by IP address.")
+	{
+		store := NewMemoryRateLimitStore()
+		mw := RateLimit(store, "test", RateLimitPolicy{By: RateLimitBy_IP, Limit: 2, Period: time.Minute})(handler)
+
+		now := time.Now()
+
+		for i, want := range []string{"1", "0"} {
+			w, err := rateLimitRequest(mw, "68.69.35.104", nil, now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tRequest %d failed.", tests.Failed, i)
+			} else if got := w.Header().Get("X-RateLimit-Remaining"); got != want {
+				t.Logf("\t\tGot : %s", got)
+				t.Logf("\t\tWant: %s", want)
+				t.Fatalf("\t%s\tRequest %d remaining failed.", tests.Failed, i)
+			} else if got := w.Header().Get("X-RateLimit-Limit"); got != "2" {
+				t.Logf("\t\tGot : %s", got)
+				t.Logf("\t\tWant: %s", "2")
+				t.Fatalf("\t%s\tRequest %d limit failed.", tests.Failed, i)
+			}
+		}
+		t.Logf("\t%s\tRequests within limit ok.", tests.Success)
+
+		// The bucket is empty so the next request should be rejected.
+		_, err := rateLimitRequest(mw, "68.69.35.104", nil, now)
+		if rateLimitCause(err) != ErrRateLimitExceeded {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrRateLimitExceeded)
+			t.Fatalf("\t%s\tRequest exceeding limit failed.", tests.Failed)
+		}
+
+		webErr, ok := err.(*weberror.Error)
+		if !ok || webErr.Status != http.StatusTooManyRequests {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tRequest exceeding limit status failed.", tests.Failed)
+		} else if got := webErr.Header.Get("Retry-After"); got != "30" {
+			t.Logf("\t\tGot : %s", got)
+			t.Logf("\t\tWant: %s", "30")
+			t.Fatalf("\t%s\tRequest exceeding limit Retry-After failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRequest exceeding limit ok.", tests.Success)
+
+		// Requests from a different IP address use a separate bucket.
+		_, err = rateLimitRequest(mw, "68.69.35.105", nil, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRequest from other IP failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRequest from other IP ok.", tests.Success)
+
+		// Once enough time has elapsed to refill a token, the request is allowed again.
+		_, err = rateLimitRequest(mw, "68.69.35.104", nil, now.Add(30*time.Second))
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRequest after refill failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRequest after refill ok.", tests.Success)
+	}
+
+	t.Log("Given the need to limit requests by client and account.")
+	{
+		store := NewMemoryRateLimitStore()
+		mw := RateLimit(store, "test",
+			RateLimitPolicy{By: RateLimitBy_Client, Limit: 1, Period: time.Minute},
+			RateLimitPolicy{By: RateLimitBy_Account, Limit: 3, Period: time.Minute},
+		)(handler)
+
+		now := time.Now()
+
+		userClaims := &auth.Claims{}
+		userClaims.Subject = "user-1"
+		userClaims.Audience = "account-1"
+
+		keyClaims := &auth.Claims{ApiKeyID: "key-1"}
+		keyClaims.Subject = "user-1"
+		keyClaims.Audience = "account-1"
+
+		// The most restrictive bucket should be returned in the headers.
+		w, err := rateLimitRequest(mw, "68.69.35.104", userClaims, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRequest for user failed.", tests.Failed)
+		} else if got := w.Header().Get("X-RateLimit-Remaining"); got != "0" {
+			t.Logf("\t\tGot : %s", got)
+			t.Logf("\t\tWant: %s", "0")
+			t.Fatalf("\t%s\tRequest for user remaining failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRequest for user ok.", tests.Success)
+
+		// The API key is a different client than the user.
+		_, err = rateLimitRequest(mw, "68.69.35.104", keyClaims, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRequest for API key failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRequest for API key ok.", tests.Success)
+
+		// Both clients have used their limit.
+		_, err = rateLimitRequest(mw, "68.69.35.104", keyClaims, now)
+		if rateLimitCause(err) != ErrRateLimitExceeded {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrRateLimitExceeded)
+			t.Fatalf("\t%s\tRequest exceeding client limit failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRequest exceeding client limit ok.", tests.Success)
+
+		// A new client for the account can use the remaining token for the account.
+		otherClaims := &auth.Claims{}
+		otherClaims.Subject = "user-2"
+		otherClaims.Audience = "account-1"
+
+		_, err = rateLimitRequest(mw, "68.69.35.104", otherClaims, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRequest for other user failed.", tests.Failed)
+		}
+
+		otherClaims.Subject = "user-3"
+		_, err = rateLimitRequest(mw, "68.69.35.104", otherClaims, now)
+		if rateLimitCause(err) != ErrRateLimitExceeded {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrRateLimitExceeded)
+			t.Fatalf("\t%s\tRequest exceeding account limit failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRequest exceeding account limit ok.", tests.Success)
+
+		// Requests without claims are not limited by client or account.
+		_, err = rateLimitRequest(mw, "68.69.35.104", nil, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRequest without claims failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRequest without claims ok.", tests.Success)
+	}
+
+	t.Log("Given the need to disable rate limits without a store.")
+	{
+		if mw := RateLimit(nil, "test", RateLimitPolicy{By: RateLimitBy_IP, Limit: 1, Period: time.Minute}); mw != nil {
+			t.Fatalf("\t%s\tRateLimit without store failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRateLimit without store ok.", tests.Success)
+	}
+}
+
+// TestRateLimitForwardedFor validates clients behind a trusted proxy can't get a new bucket by setting the
+// X-Forwarded-For header.
+func TestRateLimitForwardedFor(t *testing.T) {
+	defer tests.Recover(t)
+
+	handler := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	t.Log("Given the need to limit requests by the IP address appended by the trusted proxy.")
+	{
+		store := NewMemoryRateLimitStore()
+
+		app := web.NewApp(nil, log.New(os.Stdout, "", 0), webcontext.Env_Dev)
+		app.TrustedProxyHops = 1
+		app.Handle(http.MethodGet, "/ping", handler, RateLimit(store, "public",
+			RateLimitPolicy{By: RateLimitBy_IP, Limit: 2, Period: time.Minute}))
+
+		for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			r.Header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d, 68.69.35.104", i))
+
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != want {
+				t.Logf("\t\tGot : %d", w.Code)
+				t.Logf("\t\tWant: %d", want)
+				t.Fatalf("\t%s\tRequest %d failed.", tests.Failed, i)
+			}
+		}
+		t.Logf("\t%s\tSpoofed addresses ignored ok.", tests.Success)
+
+		// Another client behind the same proxy has its own bucket.
+		r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		r.Header.Set("X-Forwarded-For", "68.69.35.105")
+
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Logf("\t\tGot : %d", w.Code)
+			t.Logf("\t\tWant: %d", http.StatusOK)
+			t.Fatalf("\t%s\tRequest for other client failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tOther client ok.", tests.Success)
+	}
+}
+
+// TestRedisRateLimitStoreFallback validates the in-memory store is used when Redis is not configured.
+func TestRedisRateLimitStoreFallback(t *testing.T) {
+	defer tests.Recover(t)
+
+	t.Log("Given the need to fallback to memory when Redis is not available.")
+	{
+		store := NewRedisRateLimitStore(nil, "test")
+		policy := RateLimitPolicy{By: RateLimitBy_IP, Limit: 1, Period: time.Minute}
+
+		now := time.Now()
+		ctx := tests.Context()
+
+		res, err := store.Take(ctx, "key", policy, now)
+		if err != nil || !res.Allowed {
+			t.Log("\t\tGot :", res, err)
+			t.Fatalf("\t%s\tTake failed.", tests.Failed)
+		}
+
+		res, err = store.Take(ctx, "key", policy, now)
+		if err != nil || res.Allowed {
+			t.Log("\t\tGot :", res, err)
+			t.Fatalf("\t%s\tTake exceeding limit failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tFallback ok.", tests.Success)
+	}
+}
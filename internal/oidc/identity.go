@@ -0,0 +1,264 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/signup"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// The database table for UserIdentity
+	userIdentityTableName = "user_identities"
+)
+
+var (
+	// ErrNotFound abstracts the postgres not found error.
+	ErrNotFound = errors.New("Entity not found")
+
+	// ErrProvisionRequired occurs when the identity is not linked to any user and a new user needs to signup with the
+	// identity.
+	ErrProvisionRequired = errors.New("Signup required for identity")
+
+	// ErrIdentityLinked occurs when the identity is already linked to a different user.
+	ErrIdentityLinked = errors.New("Identity is already linked to another user")
+
+	// ErrEmailNotVerified occurs when a user is provisioned for an identity without an email address verified by the
+	// provider.
+	ErrEmailNotVerified = errors.New("Email address has not been verified by the identity provider")
+)
+
+// userIdentityMapColumns is the list of columns needed for find.
+var userIdentityMapColumns = "id,user_id,provider,subject,email,created_at,updated_at"
+
+// findIdentities internal method for getting the identities from the database using a select query.
+func findIdentities(ctx context.Context, dbConn *sqlx.DB, query *sqlbuilder.SelectBuilder) (UserIdentities, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.oidc.findIdentities")
+	defer span.Finish()
+
+	query.Select(userIdentityMapColumns)
+	query.From(userIdentityTableName)
+
+	queryStr, queryArgs := query.Build()
+	queryStr = dbConn.Rebind(queryStr)
+
+	// Fetch all entries from the db.
+	rows, err := dbConn.QueryContext(ctx, queryStr, queryArgs...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find user identities failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over each row.
+	resp := []*UserIdentity{}
+	for rows.Next() {
+		var m UserIdentity
+		err = rows.Scan(&m.ID, &m.UserID, &m.Provider, &m.Subject, &m.Email, &m.CreatedAt, &m.UpdatedAt)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+
+		resp = append(resp, &m)
+	}
+
+	return resp, nil
+}
+
+// ReadIdentity gets the user identity for the provider and subject.
+func (repo *Repository) ReadIdentity(ctx context.Context, provider, subject string) (*UserIdentity, error) {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Where(query.And(
+		query.Equal("provider", provider),
+		query.Equal("subject", subject),
+	))
+
+	res, err := findIdentities(ctx, repo.DbConn, query)
+	if err != nil {
+		return nil, err
+	} else if len(res) == 0 {
+		err = errors.WithMessagef(ErrNotFound, "identity %s for provider %s not found", subject, provider)
+		return nil, err
+	}
+
+	return res[0], nil
+}
+
+// FindByUserID gets the identities linked to the user.
+func (repo *Repository) FindByUserID(ctx context.Context, userID string) (UserIdentities, error) {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Where(query.Equal("user_id", userID))
+	query.OrderBy("provider")
+
+	return findIdentities(ctx, repo.DbConn, query)
+}
+
+// Link associates the identity from a provider with the user so the user can login with the provider.
+func (repo *Repository) Link(ctx context.Context, userID string, ident Identity, now time.Time) (*UserIdentity, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.oidc.Link")
+	defer span.Finish()
+
+	existing, err := repo.ReadIdentity(ctx, ident.Provider, ident.Subject)
+	if err != nil && errors.Cause(err) != ErrNotFound {
+		return nil, err
+	} else if existing != nil {
+		if existing.UserID != userID {
+			return nil, errors.WithMessagef(ErrIdentityLinked, "identity %s for provider %s", ident.Subject, ident.Provider)
+		}
+		return existing, nil
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	m := UserIdentity{
+		ID:        uuid.NewRandom().String(),
+		UserID:    userID,
+		Provider:  ident.Provider,
+		Subject:   ident.Subject,
+		Email:     ident.Email,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// Validate the request.
+	err = webcontext.Validator().StructCtx(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the insert SQL statement.
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(userIdentityTableName)
+	query.Cols("id", "user_id", "provider", "subject", "email", "created_at", "updated_at")
+	query.Values(m.ID, m.UserID, m.Provider, m.Subject, m.Email, m.CreatedAt, m.UpdatedAt)
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "link identity failed")
+		return nil, err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, auth.Claims{}, audit.AuditEventRecordRequest{
+		ActorUserID: userID,
+		EntityType:  audit.EntityType_User,
+		EntityID:    userID,
+		Action:      audit.Action_LinkIdentity,
+		After:       m,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Login returns the user linked to the identity. When the identity has not been linked yet, it's linked to the user
+// with the same email address if the provider has verified the email address. ErrProvisionRequired is returned when
+// no user could be found for the identity.
+func (repo *Repository) Login(ctx context.Context, ident Identity, now time.Time) (*user.User, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.oidc.Login")
+	defer span.Finish()
+
+	ui, err := repo.ReadIdentity(ctx, ident.Provider, ident.Subject)
+	if err == nil {
+		return repo.User.ReadByID(ctx, auth.Claims{}, ui.UserID)
+	} else if errors.Cause(err) != ErrNotFound {
+		return nil, err
+	}
+
+	// Only link to an existing user when the provider has verified the user owns the email address, otherwise anyone
+	// could take over an account by creating an identity with the email address of the user.
+	if ident.Email == "" || !ident.EmailVerified {
+		return nil, errors.WithMessagef(ErrProvisionRequired, "identity %s for provider %s", ident.Subject, ident.Provider)
+	}
+
+	u, err := repo.User.ReadByEmail(ctx, auth.Claims{}, ident.Email, false)
+	if err != nil {
+		if errors.Cause(err) == user.ErrNotFound {
+			return nil, errors.WithMessagef(ErrProvisionRequired, "identity %s for provider %s", ident.Subject, ident.Provider)
+		}
+		return nil, err
+	}
+
+	if _, err = repo.Link(ctx, u.ID, ident, now); err != nil {
+		return nil, err
+	}
+
+	// The provider has proven the user owns the email address.
+	if err = repo.User.SetEmailVerified(ctx, u.ID, now); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Provision creates a new account and user for the identity through signup and links the identity to the new user.
+// A random password is set for the user, a password can be set later using reset password. The email address must be
+// verified by the provider, otherwise anyone could claim an email address they don't own.
+func (repo *Repository) Provision(ctx context.Context, ident Identity, req ProvisionRequest, now time.Time) (*signup.SignupResult, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.oidc.Provision")
+	defer span.Finish()
+
+	// Validate the request.
+	err := webcontext.Validator().StructCtx(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ident.Email == "" || !ident.EmailVerified {
+		return nil, errors.WithMessagef(ErrEmailNotVerified, "identity %s for provider %s", ident.Subject, ident.Provider)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pass := hex.EncodeToString(b)
+
+	res, err := repo.Signup.Signup(ctx, auth.Claims{}, signup.SignupRequest{
+		Account: req.Account,
+		User: signup.SignupUser{
+			FirstName:       req.User.FirstName,
+			LastName:        req.User.LastName,
+			Email:           ident.Email,
+			Password:        pass,
+			PasswordConfirm: pass,
+		},
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = repo.Link(ctx, res.User.ID, ident, now); err != nil {
+		return nil, err
+	}
+
+	// The provider has proven the user owns the email address.
+	if err = repo.User.SetEmailVerified(ctx, res.User.ID, now); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
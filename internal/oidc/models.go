@@ -0,0 +1,172 @@
+package oidc
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/signup"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"github.com/jmoiron/sqlx"
+)
+
+// Repository defines the required dependencies for OpenID Connect logins.
+type Repository struct {
+	DbConn     *sqlx.DB
+	User       *user.Repository
+	Signup     *signup.Repository
+	Providers  []Provider
+	HTTPClient *http.Client
+
+	endpoints    map[string]*providerEndpoints
+	endpointsMtx sync.Mutex
+
+	jwks    map[string]*jwksCache
+	jwksMtx sync.Mutex
+}
+
+// NewRepository creates a new Repository that defines dependencies for OpenID Connect logins.
+func NewRepository(db *sqlx.DB, user *user.Repository, signup *signup.Repository, providers ...Provider) *Repository {
+	return &Repository{
+		DbConn:     db,
+		User:       user,
+		Signup:     signup,
+		Providers:  providers,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		endpoints:  make(map[string]*providerEndpoints),
+		jwks:       make(map[string]*jwksCache),
+	}
+}
+
+// ProviderType defines how the identity of the user is loaded from the provider.
+type ProviderType string
+
+// ProviderType values.
+const (
+	// ProviderType_OIDC loads the identity from the ID token returned by an OpenID Connect provider. Endpoints not
+	// defined by the provider are loaded using discovery from the issuer.
+	ProviderType_OIDC ProviderType = "oidc"
+	// ProviderType_GitHub loads the identity from the GitHub API since GitHub only supports OAuth2.
+	ProviderType_GitHub ProviderType = "github"
+)
+
+// Provider defines the configuration of an identity provider users can login with.
+type Provider struct {
+	Name         string       `json:"name" validate:"required"`
+	DisplayName  string       `json:"display_name"`
+	Type         ProviderType `json:"type" validate:"required,oneof=oidc github"`
+	ClientID     string       `json:"client_id" validate:"required"`
+	ClientSecret string       `json:"-"`
+	Issuer       string       `json:"issuer"`
+	AuthURL      string       `json:"auth_url"`
+	TokenURL     string       `json:"token_url"`
+	UserInfoURL  string       `json:"userinfo_url"`
+	JwksURL      string       `json:"jwks_url"`
+	Scopes       []string     `json:"scopes"`
+}
+
+// GoogleProvider returns the configuration for login with Google.
+func GoogleProvider(clientID, clientSecret string) Provider {
+	return Provider{
+		Name:         "google",
+		DisplayName:  "Google",
+		Type:         ProviderType_OIDC,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Issuer:       "https://accounts.google.com",
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// GitHubProvider returns the configuration for login with GitHub.
+func GitHubProvider(clientID, clientSecret string) Provider {
+	return Provider{
+		Name:         "github",
+		DisplayName:  "GitHub",
+		Type:         ProviderType_GitHub,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		UserInfoURL:  "https://api.github.com/user",
+		Scopes:       []string{"read:user", "user:email"},
+	}
+}
+
+// GenericProvider returns the configuration for any OpenID Connect provider that supports discovery.
+func GenericProvider(name, displayName, issuer, clientID, clientSecret string) Provider {
+	return Provider{
+		Name:         name,
+		DisplayName:  displayName,
+		Type:         ProviderType_OIDC,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Issuer:       issuer,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// providerEndpoints are the endpoints loaded using discovery.
+type providerEndpoints struct {
+	Issuer      string `json:"issuer"`
+	AuthURL     string `json:"authorization_endpoint"`
+	TokenURL    string `json:"token_endpoint"`
+	UserInfoURL string `json:"userinfo_endpoint"`
+	JwksURL     string `json:"jwks_uri"`
+}
+
+// AuthState is the state of an authorization request that must be persisted by the client, ie in the session, until
+// the provider redirects the user back to the callback.
+type AuthState struct {
+	Provider     string    `json:"provider"`
+	State        string    `json:"state"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	RedirectURI  string    `json:"redirect_uri"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CallbackRequest defines the values included by the provider in the redirect to the callback.
+type CallbackRequest struct {
+	State            string `json:"state" schema:"state"`
+	Code             string `json:"code" schema:"code"`
+	Error            string `json:"error" schema:"error"`
+	ErrorDescription string `json:"error_description" schema:"error_description"`
+}
+
+// Identity is the user returned by the provider.
+type Identity struct {
+	Provider      string `json:"provider"`
+	Subject       string `json:"subject"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+}
+
+// UserIdentity links an identity from a provider to a user.
+type UserIdentity struct {
+	ID        string    `json:"id" validate:"required,uuid"`
+	UserID    string    `json:"user_id" validate:"required,uuid"`
+	Provider  string    `json:"provider" validate:"required"`
+	Subject   string    `json:"subject" validate:"required"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserIdentities a list of UserIdentity.
+type UserIdentities []*UserIdentity
+
+// ProvisionRequest contains the details needed to signup a new user with an identity. The email address of the user
+// is always the one returned by the provider.
+type ProvisionRequest struct {
+	Account signup.SignupAccount `json:"account" validate:"required"`
+	User    ProvisionUser        `json:"user" validate:"required"`
+}
+
+// ProvisionUser defines the details of the user that can be changed before signup.
+type ProvisionUser struct {
+	FirstName string `json:"first_name" validate:"required" example:"Gabi"`
+	LastName  string `json:"last_name" validate:"required" example:"May"`
+}
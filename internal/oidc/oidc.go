@@ -0,0 +1,579 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+var (
+	// ErrProviderNotFound occurs when no provider is configured with the requested name.
+	ErrProviderNotFound = errors.New("Identity provider not found")
+
+	// ErrInvalidState occurs when the state returned to the callback does not match the authorization request or the
+	// request has expired.
+	ErrInvalidState = errors.New("Invalid login state")
+
+	// ErrAuthorizationDenied occurs when the provider returns an error to the callback, ie the user did not grant
+	// access.
+	ErrAuthorizationDenied = errors.New("Authorization denied by identity provider")
+
+	// ErrInvalidToken occurs when the ID token returned by the provider could not be verified.
+	ErrInvalidToken = errors.New("Invalid ID token")
+)
+
+// authStateTTL is how long the user has to complete the login with the provider.
+const authStateTTL = 10 * time.Minute
+
+const (
+	// jwksCacheTTL is how long the public keys published by a provider are cached.
+	jwksCacheTTL = time.Hour
+
+	// jwksRefreshInterval is how often the public keys are loaded again when a token is signed by an unknown key, so
+	// keys rotated by the provider are picked up before the cache expires.
+	jwksRefreshInterval = time.Minute
+)
+
+// jwksCache stores the public keys published by a provider.
+type jwksCache struct {
+	keys     map[string]*rsa.PublicKey
+	loadedAt time.Time
+}
+
+// key returns the public key with the ID, the only key is returned when the ID is empty.
+func (c *jwksCache) key(kid string) (*rsa.PublicKey, bool) {
+	if key, ok := c.keys[kid]; ok {
+		return key, true
+	} else if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Provider returns the provider configured with the name.
+func (repo *Repository) Provider(name string) (*Provider, error) {
+	for _, p := range repo.Providers {
+		if p.Name == name {
+			p := p
+			return &p, nil
+		}
+	}
+	return nil, errors.WithMessagef(ErrProviderNotFound, "provider %s", name)
+}
+
+// ListProviders returns the providers users can login with.
+func (repo *Repository) ListProviders() []Provider {
+	return repo.Providers
+}
+
+// AuthCodeURL starts the authorization code flow with PKCE for the provider. The returned state must be persisted
+// until the user is redirected back to the callback. The user should be redirected to the returned URL.
+func (repo *Repository) AuthCodeURL(ctx context.Context, providerName, redirectURI string, now time.Time) (*AuthState, string, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.oidc.AuthCodeURL")
+	defer span.Finish()
+
+	p, err := repo.Provider(providerName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ep, err := repo.providerEndpoints(ctx, p)
+	if err != nil {
+		return nil, "", err
+	}
+
+	st := &AuthState{
+		Provider:    p.Name,
+		RedirectURI: redirectURI,
+		CreatedAt:   now.UTC(),
+	}
+
+	if st.State, err = randomString(); err != nil {
+		return nil, "", err
+	}
+	if st.Nonce, err = randomString(); err != nil {
+		return nil, "", err
+	}
+	if st.CodeVerifier, err = randomString(); err != nil {
+		return nil, "", err
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", st.State)
+	q.Set("code_challenge", codeChallenge(st.CodeVerifier))
+	q.Set("code_challenge_method", "S256")
+	if p.Type == ProviderType_OIDC {
+		q.Set("nonce", st.Nonce)
+	}
+
+	authURL := ep.AuthURL
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + q.Encode()
+	} else {
+		authURL += "?" + q.Encode()
+	}
+
+	return st, authURL, nil
+}
+
+// Exchange completes the authorization code flow using the values returned to the callback and returns the identity
+// of the user from the provider.
+func (repo *Repository) Exchange(ctx context.Context, st AuthState, req CallbackRequest, now time.Time) (*Identity, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.oidc.Exchange")
+	defer span.Finish()
+
+	if req.Error != "" {
+		return nil, errors.WithMessagef(ErrAuthorizationDenied, "%s: %s", req.Error, req.ErrorDescription)
+	}
+
+	if st.State == "" || subtle.ConstantTimeCompare([]byte(st.State), []byte(req.State)) != 1 {
+		return nil, errors.WithMessage(ErrInvalidState, "state does not match")
+	} else if now.UTC().Sub(st.CreatedAt) > authStateTTL {
+		return nil, errors.WithMessage(ErrInvalidState, "state expired")
+	} else if req.Code == "" {
+		return nil, errors.WithMessage(ErrInvalidState, "code is required")
+	}
+
+	p, err := repo.Provider(st.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	ep, err := repo.providerEndpoints(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exchange the code for tokens, the code verifier proves this is the client that started the flow.
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", req.Code)
+	form.Set("redirect_uri", st.RedirectURI)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", st.CodeVerifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	var tkn struct {
+		AccessToken      string `json:"access_token"`
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	tknReq, err := http.NewRequest(http.MethodPost, ep.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	tknReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	err = repo.doJson(ctx, tknReq, &tkn)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "exchange code with %s failed", p.Name)
+	} else if tkn.Error != "" {
+		return nil, errors.WithMessagef(ErrAuthorizationDenied, "%s: %s", tkn.Error, tkn.ErrorDescription)
+	} else if tkn.AccessToken == "" {
+		return nil, errors.Errorf("exchange code with %s failed: no access token", p.Name)
+	}
+
+	var ident *Identity
+	switch p.Type {
+	case ProviderType_GitHub:
+		ident, err = repo.githubIdentity(ctx, p, ep, tkn.AccessToken)
+	default:
+		ident, err = repo.verifyIDToken(ctx, p, ep, tkn.IDToken, st.Nonce, now)
+		if err == nil && ident.Email == "" && ep.UserInfoURL != "" {
+			err = repo.loadUserInfo(ctx, ep, tkn.AccessToken, ident)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ident.Provider = p.Name
+
+	return ident, nil
+}
+
+// providerEndpoints returns the endpoints for the provider, any that are not configured are loaded with discovery
+// from the issuer.
+func (repo *Repository) providerEndpoints(ctx context.Context, p *Provider) (*providerEndpoints, error) {
+	ep := &providerEndpoints{
+		Issuer:      p.Issuer,
+		AuthURL:     p.AuthURL,
+		TokenURL:    p.TokenURL,
+		UserInfoURL: p.UserInfoURL,
+		JwksURL:     p.JwksURL,
+	}
+
+	if p.Type != ProviderType_OIDC || (ep.AuthURL != "" && ep.TokenURL != "" && ep.JwksURL != "") {
+		return ep, nil
+	} else if p.Issuer == "" {
+		return nil, errors.Errorf("provider %s requires an issuer", p.Name)
+	}
+
+	repo.endpointsMtx.Lock()
+	defer repo.endpointsMtx.Unlock()
+
+	if repo.endpoints == nil {
+		repo.endpoints = make(map[string]*providerEndpoints)
+	}
+
+	disc, ok := repo.endpoints[p.Issuer]
+	if !ok {
+		discURL := strings.TrimRight(p.Issuer, "/") + "/.well-known/openid-configuration"
+
+		discReq, err := http.NewRequest(http.MethodGet, discURL, nil)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		disc = &providerEndpoints{}
+		if err := repo.doJson(ctx, discReq, disc); err != nil {
+			return nil, errors.WithMessagef(err, "discovery for %s failed", p.Name)
+		}
+
+		if disc.Issuer != strings.TrimRight(p.Issuer, "/") && disc.Issuer != p.Issuer {
+			return nil, errors.Errorf("discovery for %s returned issuer %s", p.Name, disc.Issuer)
+		}
+
+		repo.endpoints[p.Issuer] = disc
+	}
+
+	if ep.AuthURL == "" {
+		ep.AuthURL = disc.AuthURL
+	}
+	if ep.TokenURL == "" {
+		ep.TokenURL = disc.TokenURL
+	}
+	if ep.UserInfoURL == "" {
+		ep.UserInfoURL = disc.UserInfoURL
+	}
+	if ep.JwksURL == "" {
+		ep.JwksURL = disc.JwksURL
+	}
+	ep.Issuer = disc.Issuer
+
+	return ep, nil
+}
+
+// verifyIDToken validates the signature and claims of the ID token and returns the identity it contains.
+func (repo *Repository) verifyIDToken(ctx context.Context, p *Provider, ep *providerEndpoints, idToken, nonce string, now time.Time) (*Identity, error) {
+	if idToken == "" {
+		return nil, errors.WithMessage(ErrInvalidToken, "no id_token returned")
+	}
+
+	var keyErr error
+
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{
+		ValidMethods: []string{"RS256", "RS384", "RS512"},
+		// The time based claims are validated below using the provided time.
+		SkipClaimsValidation: true,
+	}
+	_, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		var key *rsa.PublicKey
+		key, keyErr = repo.publicKey(ctx, ep.JwksURL, kid)
+		if keyErr != nil {
+			return nil, keyErr
+		} else if key == nil {
+			return nil, errors.Errorf("key %s not found", kid)
+		}
+		return key, nil
+	})
+	if keyErr != nil {
+		return nil, keyErr
+	} else if err != nil {
+		return nil, errors.WithMessage(ErrInvalidToken, err.Error())
+	}
+
+	if iss, _ := claims["iss"].(string); iss != ep.Issuer {
+		return nil, errors.WithMessagef(ErrInvalidToken, "issuer %s does not match %s", iss, ep.Issuer)
+	} else if !hasAudience(claims["aud"], p.ClientID) {
+		return nil, errors.WithMessagef(ErrInvalidToken, "audience does not include %s", p.ClientID)
+	} else if !claims.VerifyExpiresAt(now.Unix(), true) {
+		return nil, errors.WithMessage(ErrInvalidToken, "token expired")
+	} else if n, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(n), []byte(nonce)) != 1 {
+		return nil, errors.WithMessage(ErrInvalidToken, "nonce does not match")
+	}
+
+	ident := &Identity{}
+	ident.Subject, _ = claims["sub"].(string)
+	ident.Email, _ = claims["email"].(string)
+	ident.EmailVerified = claimBool(claims["email_verified"])
+	ident.FirstName, _ = claims["given_name"].(string)
+	ident.LastName, _ = claims["family_name"].(string)
+	if ident.FirstName == "" && ident.LastName == "" {
+		name, _ := claims["name"].(string)
+		ident.FirstName, ident.LastName = splitName(name)
+	}
+
+	if ident.Subject == "" {
+		return nil, errors.WithMessage(ErrInvalidToken, "subject is required")
+	}
+
+	return ident, nil
+}
+
+// loadUserInfo updates the identity with the claims returned by the userinfo endpoint.
+func (repo *Repository) loadUserInfo(ctx context.Context, ep *providerEndpoints, accessToken string, ident *Identity) error {
+	req, err := http.NewRequest(http.MethodGet, ep.UserInfoURL, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var info map[string]interface{}
+	if err := repo.doJson(ctx, req, &info); err != nil {
+		return errors.WithMessage(err, "load userinfo failed")
+	}
+
+	// The subject must match the ID token to prevent token substitution.
+	if sub, _ := info["sub"].(string); sub != ident.Subject {
+		return errors.WithMessage(ErrInvalidToken, "userinfo subject does not match")
+	}
+
+	ident.Email, _ = info["email"].(string)
+	ident.EmailVerified = claimBool(info["email_verified"])
+	if ident.FirstName == "" && ident.LastName == "" {
+		ident.FirstName, _ = info["given_name"].(string)
+		ident.LastName, _ = info["family_name"].(string)
+	}
+
+	return nil
+}
+
+// githubIdentity loads the identity of the user from the GitHub API.
+func (repo *Repository) githubIdentity(ctx context.Context, p *Provider, ep *providerEndpoints, accessToken string) (*Identity, error) {
+	req, err := http.NewRequest(http.MethodGet, ep.UserInfoURL, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.Header.Set("Authorization", "token "+accessToken)
+
+	var u struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := repo.doJson(ctx, req, &u); err != nil {
+		return nil, errors.WithMessagef(err, "load user from %s failed", p.Name)
+	}
+
+	ident := &Identity{
+		Subject: fmt.Sprintf("%d", u.ID),
+	}
+	ident.FirstName, ident.LastName = splitName(u.Name)
+	if ident.FirstName == "" {
+		ident.FirstName = u.Login
+	}
+
+	// The email on the user is the public email, load the primary email address and if it has been verified.
+	req, err = http.NewRequest(http.MethodGet, strings.TrimRight(ep.UserInfoURL, "/")+"/emails", nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.Header.Set("Authorization", "token "+accessToken)
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := repo.doJson(ctx, req, &emails); err != nil {
+		return nil, errors.WithMessagef(err, "load emails from %s failed", p.Name)
+	}
+
+	for _, e := range emails {
+		if e.Primary {
+			ident.Email = e.Email
+			ident.EmailVerified = e.Verified
+			break
+		}
+	}
+
+	return ident, nil
+}
+
+// publicKey returns the public key with the ID published by the provider. The keys are cached and only loaded again
+// once the cache has expired or when the key is not found, nil is returned when the provider has not published it.
+func (repo *Repository) publicKey(ctx context.Context, jwksURL, kid string) (*rsa.PublicKey, error) {
+	repo.jwksMtx.Lock()
+	defer repo.jwksMtx.Unlock()
+
+	if repo.jwks == nil {
+		repo.jwks = make(map[string]*jwksCache)
+	}
+
+	now := time.Now()
+
+	c, ok := repo.jwks[jwksURL]
+	if ok && now.Sub(c.loadedAt) < jwksCacheTTL {
+		if key, ok := c.key(kid); ok {
+			return key, nil
+		} else if now.Sub(c.loadedAt) < jwksRefreshInterval {
+			return nil, nil
+		}
+	}
+
+	keys, err := repo.loadJwks(ctx, jwksURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c = &jwksCache{keys: keys, loadedAt: now}
+	repo.jwks[jwksURL] = c
+
+	key, _ := c.key(kid)
+	return key, nil
+}
+
+// loadJwks returns the RSA public keys published by the provider keyed by ID.
+func (repo *Repository) loadJwks(ctx context.Context, jwksURL string) (map[string]*rsa.PublicKey, error) {
+	req, err := http.NewRequest(http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := repo.doJson(ctx, req, &jwks); err != nil {
+		return nil, errors.WithMessage(err, "load jwks failed")
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode modulus for key %s failed", k.Kid)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode exponent for key %s failed", k.Kid)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+// doJson executes the request and decodes the JSON response.
+func (repo *Repository) doJson(ctx context.Context, req *http.Request, v interface{}) error {
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	client := repo.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "request %s failed", req.URL.String())
+	}
+	defer res.Body.Close()
+
+	dat, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrapf(err, "read response from %s failed", req.URL.String())
+	}
+
+	// Token endpoints return errors as JSON with a 400 status.
+	if res.StatusCode >= 300 && !(res.StatusCode == http.StatusBadRequest && len(dat) > 0 && dat[0] == '{') {
+		return errors.Errorf("request %s failed with status %d: %s", req.URL.String(), res.StatusCode, string(dat))
+	}
+
+	if err := json.Unmarshal(dat, v); err != nil {
+		return errors.Wrapf(err, "decode response from %s failed", req.URL.String())
+	}
+
+	return nil
+}
+
+// randomString returns a random URL safe string with 256 bits of entropy.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// codeChallenge returns the S256 PKCE code challenge for the verifier.
+func codeChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// hasAudience checks the aud claim which can either be a string or a list of strings.
+func hasAudience(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimBool parses a boolean claim, some providers return booleans as strings.
+func claimBool(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	}
+	return false
+}
+
+// splitName splits a full name into the first and last name.
+func splitName(name string) (string, string) {
+	name = strings.TrimSpace(name)
+	if i := strings.Index(name, " "); i > 0 {
+		return name[:i], strings.TrimSpace(name[i+1:])
+	}
+	return name, ""
+}
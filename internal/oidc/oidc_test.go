@@ -0,0 +1,297 @@
+package oidc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/signup"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var test *tests.Test
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+	return m.Run()
+}
+
+// stubLogin starts a login with the stub provider, follows the authorization redirect and returns the state with the
+// values included in the redirect to the callback.
+func stubLogin(t *testing.T, repo *Repository, redirectURI string, now time.Time) (*AuthState, CallbackRequest) {
+	ctx := tests.Context()
+
+	st, authURL, err := repo.AuthCodeURL(ctx, "stub", redirectURI, now)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tAuthCodeURL failed.", tests.Failed)
+	}
+
+	// The stub approves the request and redirects back to the callback.
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tAuthorize failed.", tests.Failed)
+	}
+	res.Body.Close()
+
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(loc.String(), redirectURI) {
+		t.Log("\t\tGot :", res.Header.Get("Location"))
+		t.Fatalf("\t%s\tAuthorize redirect failed.", tests.Failed)
+	}
+
+	return st, CallbackRequest{
+		State:            loc.Query().Get("state"),
+		Code:             loc.Query().Get("code"),
+		Error:            loc.Query().Get("error"),
+		ErrorDescription: loc.Query().Get("error_description"),
+	}
+}
+
+// TestLogin validates login with an OpenID Connect provider including account linking and provisioning.
+func TestLogin(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2019, time.August, 21, 0, 0, 0, 0, time.UTC)
+
+	ident := Identity{
+		Subject:       uuid.NewRandom().String(),
+		Email:         uuid.NewRandom().String() + "@example.com",
+		EmailVerified: true,
+		FirstName:     "Lee",
+		LastName:      "Brown",
+	}
+
+	stub, err := NewStubProvider("test-client", ident)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tNewStubProvider failed.", tests.Failed)
+	}
+
+	server := httptest.NewServer(stub)
+	defer server.Close()
+	stub.Issuer = server.URL
+
+	userRepo := user.MockRepository(test.MasterDB)
+	signupRepo := signup.NewRepository(test.MasterDB, userRepo, user_account.NewRepository(test.MasterDB), account.NewRepository(test.MasterDB))
+
+	repo := NewRepository(test.MasterDB, userRepo, signupRepo, stub.Provider("stub"))
+
+	redirectURI := "http://127.0.0.1:3000/user/login/oidc/stub/callback"
+
+	t.Log("Given the need to login with an OpenID Connect provider.")
+	{
+		ctx := tests.Context()
+
+		st, req := stubLogin(t, repo, redirectURI, now)
+
+		if req.Code == "" || req.State != st.State {
+			t.Logf("\t\tGot : %+v", req)
+			t.Fatalf("\t%s\tCallback request failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthorize ok.", tests.Success)
+
+		// A state that does not match the one returned by the provider must be rejected.
+		_, err := repo.Exchange(ctx, AuthState{State: "invalid"}, req, now)
+		if errors.Cause(err) != ErrInvalidState {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidState)
+			t.Fatalf("\t%s\tExchange with invalid state failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tExchange with invalid state ok.", tests.Success)
+
+		// A state that has expired must be rejected.
+		_, err = repo.Exchange(ctx, *st, req, now.Add(authStateTTL+time.Second))
+		if errors.Cause(err) != ErrInvalidState {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidState)
+			t.Fatalf("\t%s\tExchange with expired state failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tExchange with expired state ok.", tests.Success)
+
+		// Use the current time since the ID token issued by the stub is validated against it.
+		st, req = stubLogin(t, repo, redirectURI, time.Now())
+
+		got, err := repo.Exchange(ctx, *st, req, time.Now())
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tExchange failed.", tests.Failed)
+		}
+
+		want := ident
+		want.Provider = "stub"
+		if *got != want {
+			t.Logf("\t\tGot : %+v", *got)
+			t.Logf("\t\tWant: %+v", want)
+			t.Fatalf("\t%s\tExchange identity failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tExchange ok.", tests.Success)
+
+		// The code can only be used once.
+		_, err = repo.Exchange(ctx, *st, req, time.Now())
+		if errors.Cause(err) != ErrAuthorizationDenied {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrAuthorizationDenied)
+			t.Fatalf("\t%s\tExchange with used code failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tExchange with used code ok.", tests.Success)
+
+		// The public keys of the provider are cached between logins.
+		st, req = stubLogin(t, repo, redirectURI, time.Now())
+		if _, err = repo.Exchange(ctx, *st, req, time.Now()); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tExchange again failed.", tests.Failed)
+		} else if stub.jwksRequests != 1 {
+			t.Logf("\t\tGot : %d", stub.jwksRequests)
+			t.Logf("\t\tWant: %d", 1)
+			t.Fatalf("\t%s\tJwks requests failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tJwks cached ok.", tests.Success)
+
+		// No user exists for the identity so a new user needs to be provisioned.
+		_, err = repo.Login(ctx, *got, now)
+		if errors.Cause(err) != ErrProvisionRequired {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrProvisionRequired)
+			t.Fatalf("\t%s\tLogin without user failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tLogin without user ok.", tests.Success)
+
+		provReq := ProvisionRequest{
+			Account: signup.SignupAccount{
+				Name:     uuid.NewRandom().String(),
+				Address1: "103 East Main St",
+				Address2: "Unit 546",
+				City:     "Valdez",
+				Region:   "AK",
+				Country:  "USA",
+				Zipcode:  "99686",
+			},
+			User: ProvisionUser{
+				FirstName: got.FirstName,
+				LastName:  got.LastName,
+			},
+		}
+
+		// The email address must be verified by the provider to provision a user.
+		unverified := *got
+		unverified.EmailVerified = false
+
+		_, err = repo.Provision(ctx, unverified, provReq, now)
+		if errors.Cause(err) != ErrEmailNotVerified {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrEmailNotVerified)
+			t.Fatalf("\t%s\tProvision with unverified email failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tProvision with unverified email ok.", tests.Success)
+
+		res, err := repo.Provision(ctx, *got, provReq, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tProvision failed.", tests.Failed)
+		} else if res.User.Email != ident.Email {
+			t.Logf("\t\tGot : %s", res.User.Email)
+			t.Logf("\t\tWant: %s", ident.Email)
+			t.Fatalf("\t%s\tProvision email failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tProvision ok.", tests.Success)
+
+		u, err := repo.Login(ctx, *got, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tLogin failed.", tests.Failed)
+		} else if u.ID != res.User.ID {
+			t.Logf("\t\tGot : %s", u.ID)
+			t.Logf("\t\tWant: %s", res.User.ID)
+			t.Fatalf("\t%s\tLogin user failed.", tests.Failed)
+		} else if !u.EmailVerified() {
+			t.Fatalf("\t%s\tLogin email verified failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tLogin ok.", tests.Success)
+	}
+
+	t.Log("Given the need to link an identity to an existing user by verified email.")
+	{
+		ctx := tests.Context()
+
+		usr, err := user.MockUser(ctx, test.MasterDB, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tMockUser failed.", tests.Failed)
+		}
+
+		unverified := Identity{
+			Provider: "stub",
+			Subject:  uuid.NewRandom().String(),
+			Email:    usr.User.Email,
+		}
+
+		// The email address must be verified by the provider to link to an existing user.
+		_, err = repo.Login(ctx, unverified, now)
+		if errors.Cause(err) != ErrProvisionRequired {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrProvisionRequired)
+			t.Fatalf("\t%s\tLogin with unverified email failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tLogin with unverified email ok.", tests.Success)
+
+		verified := unverified
+		verified.EmailVerified = true
+
+		u, err := repo.Login(ctx, verified, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tLogin with verified email failed.", tests.Failed)
+		} else if u.ID != usr.User.ID {
+			t.Logf("\t\tGot : %s", u.ID)
+			t.Logf("\t\tWant: %s", usr.User.ID)
+			t.Fatalf("\t%s\tLogin with verified email user failed.", tests.Failed)
+		}
+
+		idents, err := repo.FindByUserID(ctx, usr.User.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tFindByUserID failed.", tests.Failed)
+		} else if len(idents) != 1 || idents[0].Subject != verified.Subject {
+			t.Logf("\t\tGot : %+v", idents)
+			t.Fatalf("\t%s\tLinked identity failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tLogin with verified email ok.", tests.Success)
+
+		// The identity can not be linked to another user.
+		other, err := user.MockUser(ctx, test.MasterDB, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tMockUser failed.", tests.Failed)
+		}
+
+		_, err = repo.Link(ctx, other.User.ID, verified, now)
+		if errors.Cause(err) != ErrIdentityLinked {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrIdentityLinked)
+			t.Fatalf("\t%s\tLink to other user failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tLink to other user ok.", tests.Success)
+	}
+}
@@ -0,0 +1,257 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// stubKeyID is the ID of the key used by the stub provider to sign ID tokens.
+const stubKeyID = "stub"
+
+// StubProvider is a minimal OpenID Connect provider used to test logins locally without an external provider. Every
+// authorization request is approved for the configured identity. The Issuer must be set to the base URL the provider
+// is served from, ie the URL of an httptest.Server.
+type StubProvider struct {
+	Issuer   string
+	ClientID string
+	Identity Identity
+
+	key    *rsa.PrivateKey
+	codes  map[string]stubCode
+	tokens map[string]bool
+	mtx    sync.Mutex
+
+	// jwksRequests is the number of times the public keys have been requested.
+	jwksRequests int
+}
+
+// stubCode is an authorization code issued by the stub provider.
+type stubCode struct {
+	RedirectURI   string
+	CodeChallenge string
+	Nonce         string
+}
+
+// NewStubProvider returns a stub provider that authenticates every request as the identity.
+func NewStubProvider(clientID string, ident Identity) (*StubProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &StubProvider{
+		ClientID: clientID,
+		Identity: ident,
+		key:      key,
+		codes:    make(map[string]stubCode),
+		tokens:   make(map[string]bool),
+	}, nil
+}
+
+// Provider returns the configuration to use the stub provider.
+func (s *StubProvider) Provider(name string) Provider {
+	return GenericProvider(name, "Stub", s.Issuer, s.ClientID, "")
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *StubProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, strings.TrimRight(mustPath(s.Issuer), "/")) {
+	case "/.well-known/openid-configuration":
+		s.discovery(w, r)
+	case "/authorize":
+		s.authorize(w, r)
+	case "/token":
+		s.token(w, r)
+	case "/jwks":
+		s.jwks(w, r)
+	case "/userinfo":
+		s.userinfo(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *StubProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	stubJson(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.Issuer,
+		"authorization_endpoint":                s.Issuer + "/authorize",
+		"token_endpoint":                        s.Issuer + "/token",
+		"userinfo_endpoint":                     s.Issuer + "/userinfo",
+		"jwks_uri":                              s.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (s *StubProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	} else if q.Get("client_id") != s.ClientID {
+		http.Error(w, "invalid client_id", http.StatusBadRequest)
+		return
+	}
+
+	rq := redirectURI.Query()
+	rq.Set("state", q.Get("state"))
+
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		rq.Set("error", "invalid_request")
+	} else {
+		code, err := randomString()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		s.mtx.Lock()
+		s.codes[code] = stubCode{
+			RedirectURI:   q.Get("redirect_uri"),
+			CodeChallenge: q.Get("code_challenge"),
+			Nonce:         q.Get("nonce"),
+		}
+		s.mtx.Unlock()
+
+		rq.Set("code", code)
+	}
+
+	redirectURI.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *StubProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		stubJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	s.mtx.Lock()
+	c, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mtx.Unlock()
+
+	// Codes can only be used once and the verifier must match the challenge from the authorization request.
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("client_id") != s.ClientID ||
+		r.PostForm.Get("redirect_uri") != c.RedirectURI ||
+		subtle.ConstantTimeCompare([]byte(codeChallenge(r.PostForm.Get("code_verifier"))), []byte(c.CodeChallenge)) != 1 {
+		stubJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+
+	claims := s.claims()
+	claims["iss"] = s.Issuer
+	claims["aud"] = s.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	if c.Nonce != "" {
+		claims["nonce"] = c.Nonce
+	}
+
+	tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tkn.Header["kid"] = stubKeyID
+
+	idToken, err := tkn.SignedString(s.key)
+	if err != nil {
+		stubJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	accessToken, err := randomString()
+	if err != nil {
+		stubJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	s.mtx.Lock()
+	s.tokens[accessToken] = true
+	s.mtx.Unlock()
+
+	stubJson(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *StubProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	s.jwksRequests++
+	s.mtx.Unlock()
+
+	pub := s.key.PublicKey
+
+	stubJson(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kid": stubKeyID,
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			},
+		},
+	})
+}
+
+func (s *StubProvider) userinfo(w http.ResponseWriter, r *http.Request) {
+	tkn := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mtx.Lock()
+	ok := s.tokens[tkn]
+	s.mtx.Unlock()
+
+	if !ok {
+		stubJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+
+	stubJson(w, http.StatusOK, s.claims())
+}
+
+// claims returns the standard claims for the identity.
+func (s *StubProvider) claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":            s.Identity.Subject,
+		"email":          s.Identity.Email,
+		"email_verified": s.Identity.EmailVerified,
+		"given_name":     s.Identity.FirstName,
+		"family_name":    s.Identity.LastName,
+	}
+}
+
+// stubJson writes the value as a JSON response.
+func stubJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// mustPath returns the path of the URL, the stub can be served from a sub path.
+func mustPath(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
@@ -1,15 +1,17 @@
 package auth
 
 import (
+	"context"
 	"crypto/rsa"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 )
 
-// KeyFunc is used to map a JWT key id (kid) to the corresponding public key.
+// KeyFunc is used to map a JWT key id (kid) to the signer with the corresponding public key.
 // It is a requirement for creating an Authenticator.
 //
 // * Private keys should be rotated. During the transition period, tokens
@@ -18,91 +20,223 @@ import (
 //
 // * Key-id-to-public-key resolution is usually accomplished via a public JWKS
 // endpoint. See https://auth0.com/docs/jwks for more details.
-type KeyFunc func(keyID string) (*rsa.PublicKey, error)
+type KeyFunc func(keyID string) (Signer, error)
 
 // NewKeyFunc is a multiple implementation of KeyFunc that
 // supports a map of keys.
-func NewKeyFunc(keys map[string]*PrivateKey) KeyFunc {
-	return func(kid string) (*rsa.PublicKey, error) {
+func NewKeyFunc(keys map[string]Signer) KeyFunc {
+	return func(kid string) (Signer, error) {
 		key, ok := keys[kid]
 		if !ok {
 			return nil, fmt.Errorf("unrecognized kid %q", kid)
 		}
-		return key.Public().(*rsa.PublicKey), nil
+		return key, nil
 	}
 }
 
+// SessionValidator is used to verify the session referenced by a set of claims
+// has not been revoked since the token was issued.
+type SessionValidator interface {
+	ValidateSession(ctx context.Context, claims Claims) error
+}
+
+// ApiKeyResolver is used to look up the claims granted to an API key.
+type ApiKeyResolver interface {
+	ResolveApiKey(ctx context.Context, key string) (Claims, error)
+}
+
 // Authenticator is used to authenticate clients. It can generate a token for a
 // set of user claims and recreate the claims by parsing the token.
 type Authenticator struct {
-	privateKey *PrivateKey
-	keyID      string
-	algorithm  string
-	kf         KeyFunc
-	parser     *jwt.Parser
-	Storage    Storage
-}
-
-// PrivateKey is used to associate a private key with a keyID and algorithm.
-type PrivateKey struct {
-	*rsa.PrivateKey
-	keyID     string
-	algorithm string
+	// Guards the keys while they are reloaded from the storage.
+	mu      sync.RWMutex
+	signer  Signer
+	kf      KeyFunc
+	parser  *jwt.Parser
+	Storage Storage
+	// Issuer is optional and when set is included as the iss claim of the
+	// generated tokens.
+	Issuer string
+	// Serializes the reloads and tracks the last one so a token signed with an
+	// unknown key doesn't trigger a reload for every request.
+	reloadMu   sync.Mutex
+	reloadedAt time.Time
+	// SessionValidator is optional and when set is used by ValidateSession to
+	// reject tokens for sessions that have been revoked.
+	SessionValidator SessionValidator
+	// ApiKeyResolver is optional and when set is used by ParseApiKey to
+	// authenticate clients with an API key instead of a token.
+	ApiKeyResolver ApiKeyResolver
 }
 
 // NewAuthenticator creates an *Authenticator for use.
 // key expiration is optional to filter out old keys
 // It will error if:
-// - The specified algorithm is unsupported.
+// - The algorithm of the current key is unsupported.
 // - No current private key exists.
 func NewAuthenticator(storage Storage, now time.Time) (*Authenticator, error) {
 
-	// Lookup function to be used by the middleware to validate the kid and
-	// Return the associated public key.
-	publicKeyLookup := NewKeyFunc(storage.Keys())
-
-	// Validate the globally defined encryption algorithm is valid.
-	if jwt.GetSigningMethod(algorithm) == nil {
-		return nil, errors.Errorf("unknown algorithm %v", algorithm)
-	}
-
 	// Create the token parser to use. The algorithm used to sign the JWT must be
 	// validated to avoid a critical vulnerability:
 	// https://auth0.com/blog/critical-vulnerabilities-in-json-web-token-libraries/
+	// The algorithm is also checked against the algorithm of the key by ParseClaims.
 	parser := jwt.Parser{
-		ValidMethods: []string{algorithm},
+		ValidMethods: supportedAlgorithms,
+	}
+
+	a := Authenticator{
+		parser:  &parser,
+		Storage: storage,
 	}
 
 	// Load the current key from the storage engine.
+	err := a.setKeys(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// setKeys updates the keys used to generate and parse tokens with the ones loaded by the storage.
+func (a *Authenticator) setKeys(storage Storage) error {
 	curKey := storage.Current()
 	if curKey == nil {
-		return nil, errors.New("Missing private key")
+		return errors.New("Missing private key")
 	}
 
-	a := Authenticator{
-		privateKey: curKey,
-		keyID:      curKey.keyID,
-		algorithm:  algorithm,
-		kf:         publicKeyLookup,
-		parser:     &parser,
+	// Validate the algorithm of the key is supported.
+	if jwt.GetSigningMethod(curKey.Algorithm()) == nil {
+		return errors.Errorf("unknown algorithm %v", curKey.Algorithm())
 	}
 
-	return &a, nil
+	// Lookup function to be used by the middleware to validate the kid and
+	// Return the associated public key.
+	publicKeyLookup := NewKeyFunc(storage.Keys())
+
+	a.mu.Lock()
+	a.signer = curKey
+	a.kf = publicKeyLookup
+	a.mu.Unlock()
+
+	a.reloadedAt = time.Now()
+
+	return nil
+}
+
+// Reload loads the keys from the storage without a restart. Expired keys are
+// removed and the current key is rotated based on the key expiration of the storage.
+func (a *Authenticator) Reload(now time.Time) error {
+	if a.Storage == nil {
+		return nil
+	}
+
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	err := a.Storage.Reload(now)
+	if err != nil {
+		return errors.WithMessage(err, "reload keys")
+	}
+
+	return a.setKeys(a.Storage)
+}
+
+// StartReload reloads the keys from the storage at the provided interval until the returned func is called. This
+// ensures keys rotated by another instance of the service are picked up and the current key is rotated once it expires.
+// Errors are passed to the optional errFn, the existing keys continue to be used.
+func (a *Authenticator) StartReload(interval time.Duration, errFn func(error)) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := a.Reload(time.Now()); err != nil && errFn != nil {
+					errFn(err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// minUnknownKeyReload is the min duration between reloads triggered by a token
+// that references an unknown key id.
+const minUnknownKeyReload = 30 * time.Second
+
+// verifyKey returns the key for the key id. When the key id is unknown, the keys
+// are reloaded in case the key was generated by another instance.
+func (a *Authenticator) verifyKey(kid string) (Signer, error) {
+	a.mu.RLock()
+	kf := a.kf
+	a.mu.RUnlock()
+
+	key, err := kf(kid)
+	if err == nil || a.Storage == nil {
+		return key, err
+	}
+
+	a.reloadMu.Lock()
+	stale := time.Since(a.reloadedAt) > minUnknownKeyReload
+	a.reloadMu.Unlock()
+
+	if !stale {
+		return nil, err
+	}
+
+	if rerr := a.Reload(time.Now()); rerr != nil {
+		return nil, err
+	}
+
+	a.mu.RLock()
+	kf = a.kf
+	a.mu.RUnlock()
+
+	return kf(kid)
 }
 
 // GenerateToken generates a signed JWT token string representing the user Claims.
 func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
-	method := jwt.GetSigningMethod(a.algorithm)
+	if claims.Issuer == "" {
+		claims.Issuer = a.Issuer
+	}
+
+	a.mu.RLock()
+	signer := a.signer
+	a.mu.RUnlock()
+
+	method := jwt.GetSigningMethod(signer.Algorithm())
 
 	tkn := jwt.NewWithClaims(method, claims)
-	tkn.Header["kid"] = a.keyID
+	tkn.Header["kid"] = signer.KeyID()
 
-	str, err := tkn.SignedString(a.privateKey.PrivateKey)
+	// The token is signed by the signer so the private key can be kept in an
+	// external signing service.
+	str, err := tkn.SigningString()
 	if err != nil {
 		return "", errors.Wrap(err, "signing token")
 	}
 
-	return str, nil
+	sig, err := signer.Sign(str)
+	if err != nil {
+		return "", errors.Wrap(err, "signing token")
+	}
+
+	return str + "." + jwt.EncodeSegment(sig), nil
 }
 
 // ParseClaims recreates the Claims that were used to generate a token. It
@@ -122,7 +256,18 @@ func (a *Authenticator) ParseClaims(tknStr string) (Claims, error) {
 			return nil, errors.New("Token key id (kid) must be string")
 		}
 
-		return a.kf(kidStr)
+		key, err := a.verifyKey(kidStr)
+		if err != nil {
+			return nil, err
+		}
+
+		// The token must be signed with the algorithm of the key to prevent a
+		// token signed with one algorithm being verified with another.
+		if t.Method.Alg() != key.Algorithm() {
+			return nil, errors.Errorf("Token algorithm %s does not match key algorithm %s", t.Method.Alg(), key.Algorithm())
+		}
+
+		return key.Public(), nil
 	}
 
 	var claims Claims
@@ -138,6 +283,24 @@ func (a *Authenticator) ParseClaims(tknStr string) (Claims, error) {
 	return claims, nil
 }
 
+// ValidateSession checks the session for the claims is still active. When no
+// SessionValidator has been set all sessions are considered valid.
+func (a *Authenticator) ValidateSession(ctx context.Context, claims Claims) error {
+	if a.SessionValidator == nil || claims.SessionID == "" {
+		return nil
+	}
+	return a.SessionValidator.ValidateSession(ctx, claims)
+}
+
+// ParseApiKey recreates the claims granted to the API key. When no
+// ApiKeyResolver has been set API keys are not supported.
+func (a *Authenticator) ParseApiKey(ctx context.Context, key string) (Claims, error) {
+	if a.ApiKeyResolver == nil {
+		return Claims{}, errors.New("API keys are not supported")
+	}
+	return a.ApiKeyResolver.ResolveApiKey(ctx, key)
+}
+
 // mockTokenGenerator is used for testing that Authenticate calls its provided
 // token generator in a specific way.
 type MockTokenGenerator struct {
@@ -1,14 +1,19 @@
 package auth_test
 
 import (
+	"crypto/rsa"
+	"encoding/base64"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"io/ioutil"
+	"math/big"
 	"os"
 	"testing"
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/pborman/uuid"
 )
 
@@ -52,7 +57,7 @@ func TestAuthenticatorFile(t *testing.T) {
 		for i, tt := range authTests {
 			t.Logf("\tTest: %d\tWhen running test: %s", i, tt.name)
 			{
-				a, err := auth.NewAuthenticatorFile("", tt.now, tt.keyExpiration)
+				a, err := auth.NewAuthenticatorFile("", tt.now, tt.keyExpiration, 0, "")
 				if err != tt.error {
 					t.Log("\t\tGot :", err)
 					t.Log("\t\tWant:", tt.error)
@@ -127,7 +132,7 @@ func TestAuthenticatorAws(t *testing.T) {
 		for i, tt := range authTests {
 			t.Logf("\tTest: %d\tWhen running test: %s", i, tt.name)
 			{
-				a, err := auth.NewAuthenticatorAws(test.AwsSession, tt.awsSecretID, tt.now, tt.keyExpiration)
+				a, err := auth.NewAuthenticatorAws(test.AwsSession, tt.awsSecretID, tt.now, tt.keyExpiration, 0, "")
 				if err != tt.error {
 					t.Log("\t\tGot :", err)
 					t.Log("\t\tWant:", tt.error)
@@ -163,3 +168,350 @@ func TestAuthenticatorAws(t *testing.T) {
 		}
 	}
 }
+
+// TestAuthenticatorReload validates keys are rotated and shared between instances without a restart.
+func TestAuthenticatorReload(t *testing.T) {
+
+	t.Log("Given the need to rotate keys without restarting the service.")
+	{
+		localDir, err := ioutil.TempDir("", "auth-reload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(localDir)
+
+		now := time.Now().UTC()
+		keyExpiration := time.Hour
+		publishDelay := 10 * time.Minute
+
+		signedClaims := auth.Claims{
+			Roles: []string{auth.RoleAdmin},
+		}
+
+		// The publish delay must leave time to use the key.
+		if _, err := auth.NewAuthenticatorFile(localDir, now, keyExpiration, keyExpiration, ""); err == nil {
+			t.Fatalf("\t%s\tNewAuthenticatorFile with publish delay of the key expiration should fail.", tests.Failed)
+		}
+
+		// Two instances of the service sharing the same keys.
+		a1, err := auth.NewAuthenticatorFile(localDir, now, keyExpiration, publishDelay, "")
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tNewAuthenticatorFile failed.", tests.Failed)
+		}
+		a2, err := auth.NewAuthenticatorFile(localDir, now, keyExpiration, publishDelay, "")
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tNewAuthenticatorFile failed.", tests.Failed)
+		}
+
+		tkn1, err := a1.GenerateToken(signedClaims)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGenerateToken failed.", tests.Failed)
+		}
+
+		// The current key is about to expire, the first instance generates the next key and publishes it while it
+		// still signs with the current key.
+		publishNow := now.Add(keyExpiration - publishDelay + time.Minute)
+		if err := a1.Reload(publishNow); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tReload failed.", tests.Failed)
+		}
+
+		if got := len(a1.JWKS().Keys); got != 2 {
+			t.Logf("\t\tGot : %d", got)
+			t.Logf("\t\tWant: %d", 2)
+			t.Fatalf("\t%s\tShould publish the next key.", tests.Failed)
+		}
+
+		tkn2, err := a1.GenerateToken(signedClaims)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGenerateToken failed.", tests.Failed)
+		} else if tokenKeyID(t, tkn1) != tokenKeyID(t, tkn2) {
+			t.Logf("\t\tGot : %s", tokenKeyID(t, tkn2))
+			t.Logf("\t\tWant: %s", tokenKeyID(t, tkn1))
+			t.Fatalf("\t%s\tShould sign with the current key until the next key is published.", tests.Failed)
+		}
+		t.Logf("\t%s\tReload publish key ok.", tests.Success)
+
+		// The second instance loads the next key without generating another one.
+		if err := a2.Reload(publishNow); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tReload failed.", tests.Failed)
+		}
+
+		if got := len(a2.JWKS().Keys); got != 2 {
+			t.Logf("\t\tGot : %d", got)
+			t.Logf("\t\tWant: %d", 2)
+			t.Fatalf("\t%s\tShould load the next key.", tests.Failed)
+		}
+		t.Logf("\t%s\tReload other instance ok.", tests.Success)
+
+		// Once the next key has been published for the publish delay, both instances sign with it.
+		rotateNow := publishNow.Add(publishDelay)
+		for _, a := range []*auth.Authenticator{a1, a2} {
+			if err := a.Reload(rotateNow); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tReload failed.", tests.Failed)
+			}
+		}
+
+		tkn3, err := a1.GenerateToken(signedClaims)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGenerateToken failed.", tests.Failed)
+		} else if tokenKeyID(t, tkn1) == tokenKeyID(t, tkn3) {
+			t.Logf("\t\tGot : %s", tokenKeyID(t, tkn3))
+			t.Fatalf("\t%s\tShould sign with a new key after rotation.", tests.Failed)
+		}
+
+		tkn4, err := a2.GenerateToken(signedClaims)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGenerateToken failed.", tests.Failed)
+		} else if tokenKeyID(t, tkn3) != tokenKeyID(t, tkn4) {
+			t.Logf("\t\tGot : %s", tokenKeyID(t, tkn4))
+			t.Logf("\t\tWant: %s", tokenKeyID(t, tkn3))
+			t.Fatalf("\t%s\tShould sign with the key rotated by the other instance.", tests.Failed)
+		}
+
+		// Tokens signed with the previous key are still valid.
+		if _, err := a2.ParseClaims(tkn1); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tParseClaims previous key failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tReload rotate key ok.", tests.Success)
+
+		// Once the previous key expires it's no longer loaded.
+		if err := a1.Reload(now.Add(keyExpiration*2 + time.Minute)); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tReload failed.", tests.Failed)
+		}
+
+		if _, err := a1.ParseClaims(tkn1); err == nil {
+			t.Fatalf("\t%s\tParseClaims expired key should fail.", tests.Failed)
+		}
+		if _, err := a1.ParseClaims(tkn3); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tParseClaims current key failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tReload expire key ok.", tests.Success)
+	}
+}
+
+// TestJWKS validates tokens can be verified with the published public keys.
+func TestJWKS(t *testing.T) {
+
+	t.Log("Given the need to validate tokens with the public keys.")
+	{
+		a, err := auth.NewAuthenticatorMemory(time.Now())
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tNewAuthenticatorMemory failed.", tests.Failed)
+		}
+		a.Issuer = "https://api.example.saasstartupkit.com"
+
+		tknStr, err := a.GenerateToken(auth.Claims{Roles: []string{auth.RoleUser}})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGenerateToken failed.", tests.Failed)
+		}
+
+		set := a.JWKS()
+		if len(set.Keys) != 1 {
+			t.Logf("\t\tGot : %d", len(set.Keys))
+			t.Logf("\t\tWant: %d", 1)
+			t.Fatalf("\t%s\tJWKS failed.", tests.Failed)
+		}
+		jwk := set.Keys[0]
+
+		// Verify the token with only the public key from the set.
+		var claims auth.Claims
+		tkn, err := jwt.ParseWithClaims(tknStr, &claims, func(tkn *jwt.Token) (interface{}, error) {
+			if tkn.Header["kid"] != jwk.Kid {
+				t.Fatalf("\t%s\tShould have kid %s.", tests.Failed, jwk.Kid)
+			}
+
+			n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+			if err != nil {
+				return nil, err
+			}
+
+			return &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}, nil
+		})
+		if err != nil || !tkn.Valid {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tVerify token with JWKS failed.", tests.Failed)
+		} else if claims.Issuer != a.Issuer {
+			t.Logf("\t\tGot : %s", claims.Issuer)
+			t.Logf("\t\tWant: %s", a.Issuer)
+			t.Fatalf("\t%s\tToken issuer failed.", tests.Failed)
+		}
+
+		t.Logf("\t%s\tJWKS ok.", tests.Success)
+	}
+}
+
+// TestSigner validates tokens signed with each of the supported algorithms.
+func TestSigner(t *testing.T) {
+
+	var signerTests = []struct {
+		alg string
+		kty string
+		crv string
+	}{
+		{auth.AlgorithmRS256, "RSA", ""},
+		{auth.AlgorithmES256, "EC", "P-256"},
+		{auth.AlgorithmES384, "EC", "P-384"},
+		{auth.AlgorithmEdDSA, "OKP", "Ed25519"},
+	}
+
+	signedClaims := auth.Claims{
+		Roles: []string{auth.RoleAdmin},
+	}
+
+	t.Log("Given the need to sign tokens with different algorithms.")
+	{
+		signers := make(map[string]auth.Signer)
+
+		for i, tt := range signerTests {
+			t.Logf("	Test: %d	When signing with %s.", i, tt.alg)
+			{
+				keyContents, err := auth.KeyGenAlgorithm(tt.alg)
+				if err != nil {
+					t.Log("		Got :", err)
+					t.Fatalf("	%s	KeyGenAlgorithm failed.", tests.Failed)
+				}
+
+				f, err := ioutil.TempFile("", "auth-signer")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.Remove(f.Name())
+
+				if _, err := f.Write(keyContents); err != nil {
+					t.Fatal(err)
+				}
+				f.Close()
+
+				signer, err := auth.NewFileSigner(f.Name())
+				if err != nil {
+					t.Log("		Got :", err)
+					t.Fatalf("	%s	NewFileSigner failed.", tests.Failed)
+				} else if signer.Algorithm() != tt.alg {
+					t.Logf("		Got : %s", signer.Algorithm())
+					t.Logf("		Want: %s", tt.alg)
+					t.Fatalf("	%s	NewFileSigner algorithm failed.", tests.Failed)
+				}
+				signers[tt.alg] = signer
+
+				// The key id is derived from the key so it's the same for every instance.
+				signer2, err := auth.NewFileSigner(f.Name())
+				if err != nil {
+					t.Log("		Got :", err)
+					t.Fatalf("	%s	NewFileSigner failed.", tests.Failed)
+				} else if signer.KeyID() != signer2.KeyID() {
+					t.Logf("		Got : %s", signer2.KeyID())
+					t.Logf("		Want: %s", signer.KeyID())
+					t.Fatalf("	%s	NewFileSigner key id failed.", tests.Failed)
+				}
+
+				a, err := auth.NewAuthenticatorSigner(signer, time.Now())
+				if err != nil {
+					t.Log("		Got :", err)
+					t.Fatalf("	%s	NewAuthenticatorSigner failed.", tests.Failed)
+				}
+
+				tknStr, err := a.GenerateToken(signedClaims)
+				if err != nil {
+					t.Log("		Got :", err)
+					t.Fatalf("	%s	GenerateToken failed.", tests.Failed)
+				}
+
+				parsedClaims, err := a.ParseClaims(tknStr)
+				if err != nil {
+					t.Log("		Got :", err)
+					t.Fatalf("	%s	ParseClaims failed.", tests.Failed)
+				} else if len(parsedClaims.Roles) != 1 || parsedClaims.Roles[0] != auth.RoleAdmin {
+					t.Logf("		Got : %v", parsedClaims.Roles)
+					t.Logf("		Want: %v", signedClaims.Roles)
+					t.Fatalf("	%s	ParseClaims roles failed.", tests.Failed)
+				}
+
+				set := a.JWKS()
+				if len(set.Keys) != 1 {
+					t.Logf("		Got : %d", len(set.Keys))
+					t.Logf("		Want: %d", 1)
+					t.Fatalf("	%s	JWKS failed.", tests.Failed)
+				} else if jwk := set.Keys[0]; jwk.Kty != tt.kty || jwk.Crv != tt.crv || jwk.Alg != tt.alg || jwk.Kid != signer.KeyID() {
+					t.Logf("		Got : %+v", jwk)
+					t.Fatalf("	%s	JWKS key failed.", tests.Failed)
+				}
+
+				t.Logf("	%s	Sign with %s ok.", tests.Success, tt.alg)
+			}
+		}
+
+		// A token signed with one key can't be verified with a key for another
+		// algorithm using the same key id.
+		t.Log("	When the algorithm of the token doesn't match the key.")
+		{
+			es256 := signers[auth.AlgorithmES256]
+			eddsa := signers[auth.AlgorithmEdDSA]
+
+			a1, err := auth.NewAuthenticatorSigner(eddsa, time.Now())
+			if err != nil {
+				t.Log("		Got :", err)
+				t.Fatalf("	%s	NewAuthenticatorSigner failed.", tests.Failed)
+			}
+
+			tknStr, err := a1.GenerateToken(signedClaims)
+			if err != nil {
+				t.Log("		Got :", err)
+				t.Fatalf("	%s	GenerateToken failed.", tests.Failed)
+			}
+
+			a2, err := auth.NewAuthenticatorSigner(&keyIDSigner{Signer: es256, keyID: eddsa.KeyID()}, time.Now())
+			if err != nil {
+				t.Log("		Got :", err)
+				t.Fatalf("	%s	NewAuthenticatorSigner failed.", tests.Failed)
+			}
+
+			if _, err := a2.ParseClaims(tknStr); err == nil {
+				t.Fatalf("	%s	ParseClaims should fail.", tests.Failed)
+			}
+
+			t.Logf("	%s	Reject algorithm mismatch ok.", tests.Success)
+		}
+	}
+}
+
+// keyIDSigner overrides the key id of a signer.
+type keyIDSigner struct {
+	auth.Signer
+	keyID string
+}
+
+// KeyID returns the overridden key id.
+func (s *keyIDSigner) KeyID() string {
+	return s.keyID
+}
+
+// tokenKeyID returns the key id from the header of the token.
+func tokenKeyID(t *testing.T, tknStr string) string {
+	tkn, _, err := new(jwt.Parser).ParseUnverified(tknStr, &auth.Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := tkn.Header["kid"].(string)
+	return kid
+}
@@ -9,8 +9,12 @@ import (
 	"github.com/pkg/errors"
 )
 
-// These are the expected values for Claims.Roles.
+// These are the built-in values for Claims.Roles. Accounts can define
+// additional roles, see RolePermissions. Every account has a single owner
+// that also has the admin role, the owner role can only be assigned by
+// transferring the ownership of the account.
 const (
+	RoleOwner = "owner"
 	RoleAdmin = "admin"
 	RoleUser  = "user"
 )
@@ -27,7 +31,10 @@ type Claims struct {
 	RootAccountID string           `json:"root_account_id"`
 	AccountIDs    []string         `json:"accounts"`
 	Roles         []string         `json:"roles"`
+	Permissions   []string         `json:"perms,omitempty"`
 	Preferences   ClaimPreferences `json:"prefs"`
+	SessionID     string           `json:"sid,omitempty"`
+	ApiKeyID      string           `json:"akid,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -79,12 +86,15 @@ func NewClaimPreferences(timezone *time.Location, datetimeFormat, dateFormat, ti
 // Valid is called during the parsing of a token.
 func (c Claims) Valid() error {
 	for _, r := range c.Roles {
-		switch r {
-		case RoleAdmin, RoleUser: // Role is valid.
-		default:
+		if !ValidRoleName(r) {
 			return fmt.Errorf("invalid role %q", r)
 		}
 	}
+	for _, p := range c.Permissions {
+		if !ValidPermission(p) {
+			return fmt.Errorf("invalid permission %q", p)
+		}
+	}
 	if err := c.StandardClaims.Valid(); err != nil {
 		return errors.Wrap(err, "validating standard claims")
 	}
@@ -111,6 +121,22 @@ func (c Claims) HasRole(roles ...string) bool {
 	return false
 }
 
+// HasPermission returns true if the claims has at least one of the provided
+// permissions. Claims issued without permissions fall back to the permissions
+// of the built-in roles.
+func (c Claims) HasPermission(perms ...string) bool {
+	if len(c.Permissions) > 0 {
+		return hasAny(c.Permissions, perms)
+	}
+
+	for _, r := range c.Roles {
+		if hasAny(RolePermissions[r], perms) {
+			return true
+		}
+	}
+	return false
+}
+
 // TimeLocation returns the timezone used to format datetimes for the user.
 func (c ClaimPreferences) TimeLocation() *time.Location {
 	if c.tz == nil && c.Timezone != "" {
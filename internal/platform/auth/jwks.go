@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+)
+
+// JwksMaxAge is the duration clients can cache the public keys. The keys are published by the storage engines for at
+// least this duration plus the reload interval before they are used to sign tokens.
+const JwksMaxAge = 5 * time.Minute
+
+// JSONWebKey is the public key used to verify tokens as defined by RFC 7517.
+type JSONWebKey struct {
+	Kty string `json:"kty" example:"RSA"`
+	Use string `json:"use" example:"sig"`
+	Kid string `json:"kid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	Alg string `json:"alg" example:"RS256"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty" example:"AQAB"`
+	Crv string `json:"crv,omitempty" example:"P-256"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is the set of public keys exposed by a JWKS endpoint so
+// other services can verify the tokens without calling the service.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys of all the keys loaded from the storage,
+// including the keys that have been rotated but not yet expired.
+func (a *Authenticator) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{
+		Keys: []JSONWebKey{},
+	}
+	for _, k := range a.Storage.Keys() {
+		jwk, err := NewJSONWebKey(k)
+		if err != nil {
+			// Keys that can't be represented can't be used to verify tokens offline.
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	// Sort the keys so the response is consistent.
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+
+	return set
+}
+
+// NewJSONWebKey returns the public key of the signer as a JSON Web Key.
+func NewJSONWebKey(s Signer) (JSONWebKey, error) {
+	jwk := JSONWebKey{
+		Use: "sig",
+		Kid: s.KeyID(),
+		Alg: s.Algorithm(),
+	}
+
+	switch pub := s.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+
+	case *ecdsa.PublicKey:
+		// The coordinates are padded to the size of the curve as required by RFC 7518.
+		size := (pub.Curve.Params().BitSize + 7) / 8
+
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(pub.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(pub.Y.Bytes(), size))
+
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+
+	default:
+		return jwk, errors.Errorf("unsupported public key %T", pub)
+	}
+
+	return jwk, nil
+}
+
+// padBytes left pads the bytes with zeros to the provided size.
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	p := make([]byte, size)
+	copy(p[size-len(b):], b)
+	return p
+}
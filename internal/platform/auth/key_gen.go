@@ -2,26 +2,119 @@ package auth
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
+
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
 )
 
 // Algorithm to be used to for the private key.
 const algorithm = "RS256"
 
+// Algorithms supported for signing tokens.
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmES384 = "ES384"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// supportedAlgorithms is the list of algorithms accepted when parsing tokens.
+var supportedAlgorithms = []string{
+	AlgorithmRS256,
+	AlgorithmES256,
+	AlgorithmES384,
+	AlgorithmEdDSA,
+}
+
+// oidEd25519 is the algorithm identifier of Ed25519 keys defined by RFC 8410.
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// pkcs8 reflects an ASN.1, PKCS #8 PrivateKey.
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
 // keyGen creates an x509 private key for signing auth tokens.
 func KeyGen() ([]byte, error) {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return []byte{}, errors.Wrap(err, "generating keys")
-	}
+	return KeyGenAlgorithm(algorithm)
+}
+
+// KeyGenAlgorithm creates a PEM encoded private key for signing auth tokens with
+// the provided algorithm.
+func KeyGenAlgorithm(alg string) ([]byte, error) {
+	var block pem.Block
 
-	block := pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	switch alg {
+	case AlgorithmRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return []byte{}, errors.Wrap(err, "generating keys")
+		}
+
+		block = pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}
+
+	case AlgorithmES256, AlgorithmES384:
+		curve := elliptic.P256()
+		if alg == AlgorithmES384 {
+			curve = elliptic.P384()
+		}
+
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return []byte{}, errors.Wrap(err, "generating keys")
+		}
+
+		dat, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return []byte{}, errors.Wrap(err, "marshal private key")
+		}
+
+		block = pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: dat,
+		}
+
+	case AlgorithmEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return []byte{}, errors.Wrap(err, "generating keys")
+		}
+
+		// Ed25519 keys are stored as PKCS #8 as defined by RFC 8410.
+		seed, err := asn1.Marshal(key.Seed())
+		if err != nil {
+			return []byte{}, errors.Wrap(err, "marshal private key")
+		}
+
+		dat, err := asn1.Marshal(pkcs8{
+			Algo:       pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+			PrivateKey: seed,
+		})
+		if err != nil {
+			return []byte{}, errors.Wrap(err, "marshal private key")
+		}
+
+		block = pem.Block{
+			Type:  "PRIVATE KEY",
+			Bytes: dat,
+		}
+
+	default:
+		return []byte{}, errors.Errorf("unsupported algorithm %s", alg)
 	}
 
 	buf := new(bytes.Buffer)
@@ -31,3 +124,87 @@ func KeyGen() ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// ParsePrivateKey parses a PEM encoded RSA, ECDSA or Ed25519 private key and returns
+// the key with the algorithm used to sign tokens.
+func ParsePrivateKey(keyID string, dat []byte) (*PrivateKey, error) {
+	block, _ := pem.Decode(dat)
+	if block == nil {
+		return nil, errors.New("private key must be PEM encoded")
+	}
+
+	var (
+		key crypto.Signer
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = parsePKCS8PrivateKey(block.Bytes)
+	default:
+		err = errors.Errorf("unsupported private key type %s", block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing auth private key")
+	}
+
+	pk := &PrivateKey{
+		key:   key,
+		keyID: keyID,
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		pk.algorithm = AlgorithmRS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().Name {
+		case "P-256":
+			pk.algorithm = AlgorithmES256
+		case "P-384":
+			pk.algorithm = AlgorithmES384
+		default:
+			return nil, errors.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		pk.algorithm = AlgorithmEdDSA
+	default:
+		return nil, errors.Errorf("unsupported private key %T", key)
+	}
+
+	return pk, nil
+}
+
+// parsePKCS8PrivateKey parses a PKCS #8 private key. Ed25519 keys are parsed
+// separately as they are not supported by x509 in all versions of Go.
+func parsePKCS8PrivateKey(der []byte) (crypto.Signer, error) {
+	var privKey pkcs8
+	if _, err := asn1.Unmarshal(der, &privKey); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if privKey.Algo.Algorithm.Equal(oidEd25519) {
+		var seed []byte
+		if _, err := asn1.Unmarshal(privKey.PrivateKey, &seed); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(seed) != ed25519.SeedSize {
+			return nil, errors.Errorf("invalid Ed25519 private key length %d", len(seed))
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key %T", key)
+	}
+
+	return signer, nil
+}
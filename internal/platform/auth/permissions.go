@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"regexp"
+)
+
+// These are the permissions that can be granted to a role. Permissions are
+// named by the resource followed by the action allowed on it.
+const (
+	PermissionAccountRead     = "account:read"
+	PermissionAccountWrite    = "account:write"
+	PermissionApiKeyWrite     = "api_key:write"
+	PermissionAuditRead       = "audit:read"
+	PermissionBillingRead     = "billing:read"
+	PermissionBillingWrite    = "billing:write"
+	PermissionRoleWrite       = "role:write"
+	PermissionProjectRead     = "project:read"
+	PermissionProjectWrite    = "project:write"
+	PermissionScimProvision   = "scim:provision"
+	PermissionUserRead        = "user:read"
+	PermissionUserWrite       = "user:write"
+	PermissionUserInvite      = "user:invite"
+	PermissionUserImpersonate = "user:impersonate"
+	PermissionWebhookWrite    = "webhook:write"
+)
+
+// Permissions is the list of all the valid permissions.
+var Permissions = []string{
+	PermissionAccountRead,
+	PermissionAccountWrite,
+	PermissionApiKeyWrite,
+	PermissionAuditRead,
+	PermissionBillingRead,
+	PermissionBillingWrite,
+	PermissionRoleWrite,
+	PermissionProjectRead,
+	PermissionProjectWrite,
+	PermissionScimProvision,
+	PermissionUserRead,
+	PermissionUserWrite,
+	PermissionUserInvite,
+	PermissionUserImpersonate,
+	PermissionWebhookWrite,
+}
+
+// RolePermissions defines the permissions for the built-in roles that are
+// available to every account. Accounts can define additional roles that map
+// to any of the valid permissions.
+var RolePermissions = map[string][]string{
+	RoleOwner: Permissions,
+	RoleAdmin: Permissions,
+	RoleUser: {
+		PermissionAccountRead,
+		PermissionProjectRead,
+		PermissionUserRead,
+	},
+}
+
+// roleNameRegex defines the format for role names, lowercase letters, numbers,
+// dashes and underscores.
+var roleNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_\-]{0,63}$`)
+
+// ValidRoleName returns true if the name can be used for a role.
+func ValidRoleName(name string) bool {
+	return roleNameRegex.MatchString(name)
+}
+
+// ValidPermission returns true if the permission is defined.
+func ValidPermission(perm string) bool {
+	for _, p := range Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBuiltInRole returns true if the role is defined for every account.
+func IsBuiltInRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// RolesWithPermission returns the built-in roles that have at least one of the
+// provided permissions.
+func RolesWithPermission(perms ...string) []string {
+	var roles []string
+	for _, r := range []string{RoleOwner, RoleAdmin, RoleUser} {
+		if hasAny(RolePermissions[r], perms) {
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
+
+// hasAny returns true if at least one of the wanted values is in the list.
+func hasAny(list []string, want []string) bool {
+	for _, has := range list {
+		for _, w := range want {
+			if has == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Signer is used to sign tokens. Implementations can keep the private key in an
+// external signing service, like AWS KMS or Google Cloud KMS, so the key is never
+// loaded by the service.
+type Signer interface {
+	// KeyID returns the key id (kid) included in the header of the signed tokens.
+	KeyID() string
+	// Algorithm returns the JWT algorithm used to sign, ie RS256, ES256 or EdDSA.
+	Algorithm() string
+	// Public returns the public key used to verify the signatures.
+	Public() crypto.PublicKey
+	// Sign returns the signature of the signing string, the encoded header and
+	// claims of the token. The signature must be in the format defined by RFC 7518
+	// for the algorithm, ie ECDSA signatures are r || s and not ASN.1 encoded.
+	Sign(signingString string) ([]byte, error)
+}
+
+// PrivateKey is used to associate a private key with a keyID and algorithm.
+type PrivateKey struct {
+	key       crypto.Signer
+	keyID     string
+	algorithm string
+}
+
+// KeyID returns the key id (kid) included in the header of the signed tokens.
+func (k *PrivateKey) KeyID() string {
+	return k.keyID
+}
+
+// Algorithm returns the JWT algorithm used to sign.
+func (k *PrivateKey) Algorithm() string {
+	return k.algorithm
+}
+
+// Public returns the public key used to verify the signatures.
+func (k *PrivateKey) Public() crypto.PublicKey {
+	return k.key.Public()
+}
+
+// Sign returns the signature of the signing string.
+func (k *PrivateKey) Sign(signingString string) ([]byte, error) {
+	method := jwt.GetSigningMethod(k.algorithm)
+	if method == nil {
+		return nil, errors.Errorf("unknown algorithm %v", k.algorithm)
+	}
+
+	sig, err := method.Sign(signingString, k.key)
+	if err != nil {
+		return nil, errors.Wrap(err, "signing token")
+	}
+
+	return jwt.DecodeSegment(sig)
+}
+
+// SigningMethodEd25519 implements the EdDSA signing method defined by RFC 8037
+// for Ed25519 keys.
+type SigningMethodEd25519 struct{}
+
+// SigningMethodEdDSA is the instance of SigningMethodEd25519 registered with jwt.
+var SigningMethodEdDSA *SigningMethodEd25519
+
+func init() {
+	SigningMethodEdDSA = &SigningMethodEd25519{}
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+// Alg returns the name of the algorithm.
+func (m *SigningMethodEd25519) Alg() string {
+	return AlgorithmEdDSA
+}
+
+// Verify checks the signature with an ed25519.PublicKey.
+func (m *SigningMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+// Sign signs the signing string with an ed25519.PrivateKey.
+func (m *SigningMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
+
+// FileSigner is a Signer that loads the private key from a PEM encoded file on
+// the local file system. The private key is not exposed, mirroring a signer backed
+// by an external signing service.
+type FileSigner struct {
+	filePath string
+	key      *PrivateKey
+}
+
+// NewFileSigner loads the PEM encoded RSA, ECDSA or Ed25519 private key from the
+// file. The key id is the JWK thumbprint of the public key as defined by RFC 7638
+// so it remains the same when the file is loaded by multiple services.
+func NewFileSigner(filePath string) (*FileSigner, error) {
+	dat, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed read file %s", filePath)
+	}
+
+	key, err := ParsePrivateKey("", dat)
+	if err != nil {
+		return nil, err
+	}
+
+	key.keyID, err = thumbprint(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSigner{
+		filePath: filePath,
+		key:      key,
+	}, nil
+}
+
+// KeyID returns the key id (kid) included in the header of the signed tokens.
+func (s *FileSigner) KeyID() string {
+	return s.key.KeyID()
+}
+
+// Algorithm returns the JWT algorithm used to sign.
+func (s *FileSigner) Algorithm() string {
+	return s.key.Algorithm()
+}
+
+// Public returns the public key used to verify the signatures.
+func (s *FileSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+// Sign returns the signature of the signing string.
+func (s *FileSigner) Sign(signingString string) ([]byte, error) {
+	return s.key.Sign(signingString)
+}
+
+// thumbprint returns the JWK thumbprint of the public key of the signer as
+// defined by RFC 7638.
+func thumbprint(s Signer) (string, error) {
+	jwk, err := NewJSONWebKey(s)
+	if err != nil {
+		return "", err
+	}
+
+	// Only the required members of the key are included, sorted lexicographically.
+	var members map[string]string
+	switch jwk.Kty {
+	case "RSA":
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case "EC":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X, "y": jwk.Y}
+	default:
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	}
+
+	// The keys of a map are encoded in sorted order.
+	dat, err := json.Marshal(members)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	h := sha256.Sum256(dat)
+	return jwt.EncodeSegment(h[:]), nil
+}
+
+// StorageSigner is a storage engine for keys that are managed outside of the service,
+// like with an external signing service. The keys are not rotated by the service.
+type StorageSigner struct {
+	current Signer
+	keys    map[string]Signer
+}
+
+// NewStorageSigner implements the interface Storage to sign tokens with the provided
+// signer. Previous signers are optional and only used to verify tokens signed before
+// the key was rotated.
+func NewStorageSigner(current Signer, previous ...Signer) *StorageSigner {
+	s := &StorageSigner{
+		current: current,
+		keys:    map[string]Signer{current.KeyID(): current},
+	}
+	for _, p := range previous {
+		s.keys[p.KeyID()] = p
+	}
+	return s
+}
+
+// Keys returns a map of signers by kID.
+func (s *StorageSigner) Keys() map[string]Signer {
+	if s == nil || s.keys == nil {
+		return map[string]Signer{}
+	}
+	return s.keys
+}
+
+// Current returns the signer used to sign new tokens.
+func (s *StorageSigner) Current() Signer {
+	if s == nil {
+		return nil
+	}
+	return s.current
+}
+
+// Reload does nothing as the keys are managed outside of the service.
+func (s *StorageSigner) Reload(now time.Time) error {
+	return nil
+}
+
+// NewAuthenticatorSigner is a help function that inits a new Authenticator that
+// signs tokens with the provided signer.
+func NewAuthenticatorSigner(signer Signer, now time.Time, previous ...Signer) (*Authenticator, error) {
+	return NewAuthenticator(NewStorageSigner(signer, previous...), now)
+}
@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"github.com/dgrijalva/jwt-go"
 	"github.com/pborman/uuid"
 	"github.com/pkg/errors"
 	"time"
@@ -9,10 +8,13 @@ import (
 
 // Storage provides the ability to persist keys to custom locations.
 type Storage interface {
-	// Keys returns a map of private keys by kID.
-	Keys() map[string]*PrivateKey
-	// Current returns the most recently generated private key.
-	Current() *PrivateKey
+	// Keys returns a map of signers by kID.
+	Keys() map[string]Signer
+	// Current returns the signer of the most recently generated private key.
+	Current() Signer
+	// Reload loads the keys again from the storage engine. Expired keys are
+	// removed and a new key is generated when the current key needs to be rotated.
+	Reload(now time.Time) error
 }
 
 // StorageMemory is a storage engine that stores a single private key in memory.
@@ -21,23 +23,28 @@ type StorageMemory struct {
 }
 
 // Keys returns a map of private keys by kID.
-func (s *StorageMemory) Keys() map[string]*PrivateKey {
+func (s *StorageMemory) Keys() map[string]Signer {
 	if s == nil || s.privateKey == nil {
-		return map[string]*PrivateKey{}
+		return map[string]Signer{}
 	}
-	return map[string]*PrivateKey{
+	return map[string]Signer{
 		s.privateKey.keyID: s.privateKey,
 	}
 }
 
 // Current returns the most recently generated private key.
-func (s *StorageMemory) Current() *PrivateKey {
-	if s == nil {
+func (s *StorageMemory) Current() Signer {
+	if s == nil || s.privateKey == nil {
 		return nil
 	}
 	return s.privateKey
 }
 
+// Reload does nothing as the single key stored in memory is never rotated.
+func (s *StorageMemory) Reload(now time.Time) error {
+	return nil
+}
+
 // NewAuthenticatorMemory is a help function that inits a new Authenticator with a single key stored in memory.
 func NewAuthenticatorMemory(now time.Time) (*Authenticator, error) {
 	storage, err := NewStorageMemory()
@@ -56,18 +63,60 @@ func NewStorageMemory() (*StorageMemory, error) {
 		return nil, errors.Wrap(err, "failed to generate new private key")
 	}
 
-	pk, err := jwt.ParseRSAPrivateKeyFromPEM(privateKey)
+	pk, err := ParsePrivateKey(uuid.NewRandom().String(), privateKey)
 	if err != nil {
-		return nil, errors.Wrap(err, "parsing auth private key")
+		return nil, err
 	}
 
 	storage := &StorageMemory{
-		privateKey: &PrivateKey{
-			PrivateKey: pk,
-			keyID:      uuid.NewRandom().String(),
-			algorithm:  algorithm,
-		},
+		privateKey: pk,
 	}
 
 	return storage, nil
 }
+
+// storedKey is a private key loaded by a storage engine with the time it was generated.
+type storedKey struct {
+	privateKey *PrivateKey
+	createdAt  time.Time
+}
+
+// currentKey returns the key to sign tokens with and whether the next key needs to be generated. A new key is only
+// used once it's older than the publish delay, until then it's only returned by Keys so the services and clients
+// caching the public keys have it before the first token signed with it is issued. The next key is generated the
+// publish delay before the current key expires so the current key can still be used while it's published.
+func currentKey(keys []storedKey, alg string, now time.Time, keyExpiration, publishDelay time.Duration) (*PrivateKey, bool) {
+	var (
+		cur, newest storedKey
+	)
+	for _, k := range keys {
+		// Keys generated with a different algorithm are only loaded to verify the tokens they signed.
+		if k.privateKey.Algorithm() != alg {
+			continue
+		}
+
+		if newest.privateKey == nil || k.createdAt.After(newest.createdAt) {
+			newest = k
+		}
+
+		if now.Sub(k.createdAt) < publishDelay {
+			continue
+		}
+		if cur.privateKey == nil || k.createdAt.After(cur.createdAt) {
+			cur = k
+		}
+	}
+
+	// Generate a new key when there isn't one or the next key is due.
+	generate := newest.privateKey == nil
+	if !generate && keyExpiration > 0 && now.Sub(newest.createdAt) >= keyExpiration-publishDelay {
+		generate = true
+	}
+
+	// When no key has been published long enough, ie on the first start, the newest key is used right away.
+	if cur.privateKey == nil {
+		cur = newest
+	}
+
+	return cur.privateKey, generate
+}
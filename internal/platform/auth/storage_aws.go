@@ -1,45 +1,70 @@
 package auth
 
 import (
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 )
 
 // StorageAws is a storage engine that uses AWS Secrets Manager to persist private keys.
 type StorageAws struct {
+	// Guards the keys while they are reloaded.
+	mu sync.RWMutex
+	// AWS Secret Manager client and the secret ID the keys are stored under.
+	secretManager *secretsmanager.SecretsManager
+	awsSecretID   string
+	// Duration for keys to be valid.
 	keyExpiration time.Duration
+	// Duration a new key is published before it's used.
+	publishDelay time.Duration
+	// Algorithm used to generate new keys.
+	algorithm string
 	// Map of keys by kid (version id).
-	keys map[string]*PrivateKey
+	keys map[string]Signer
 	// The current active key to be used.
 	curPrivateKey *PrivateKey
 }
 
 // Keys returns a map of private keys by kID.
-func (s *StorageAws) Keys() map[string]*PrivateKey {
-	if s == nil || s.keys == nil {
-		return map[string]*PrivateKey{}
+func (s *StorageAws) Keys() map[string]Signer {
+	if s == nil {
+		return map[string]Signer{}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.keys == nil {
+		return map[string]Signer{}
 	}
 	return s.keys
 }
 
-// Current returns the most recently generated private key.
-func (s *StorageAws) Current() *PrivateKey {
+// Current returns the private key used to sign tokens, the most recently generated key that has been published for
+// the publish delay.
+func (s *StorageAws) Current() Signer {
 	if s == nil {
 		return nil
 	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.curPrivateKey == nil {
+		return nil
+	}
 	return s.curPrivateKey
 }
 
 // NewAuthenticatorAws is a help function that inits a new Authenticator
 // using the AWS storage.
-func NewAuthenticatorAws(awsSession *session.Session, awsSecretID string, now time.Time, keyExpiration time.Duration) (*Authenticator, error) {
-	storage, err := NewStorageAws(awsSession, awsSecretID, now, keyExpiration)
+func NewAuthenticatorAws(awsSession *session.Session, awsSecretID string, now time.Time, keyExpiration, publishDelay time.Duration, alg string) (*Authenticator, error) {
+	storage, err := NewStorageAws(awsSession, awsSecretID, now, keyExpiration, publishDelay, alg)
 	if err != nil {
 		return nil, err
 	}
@@ -48,11 +73,13 @@ func NewAuthenticatorAws(awsSession *session.Session, awsSecretID string, now ti
 }
 
 // NewStorageAws implements the interface Storage to support persisting private keys
-// to AWS Secrets Manager.
+// to AWS Secrets Manager. New keys are published for the publish delay before they are used, it should be at least
+// the interval the keys are reloaded plus the time the public keys are cached by clients.
 // It will error if:
 // - The aws session is nil.
 // - The aws secret id is blank.
-func NewStorageAws(awsSession *session.Session, awsSecretID string, now time.Time, keyExpiration time.Duration) (*StorageAws, error) {
+// - The publish delay is not less than the key expiration.
+func NewStorageAws(awsSession *session.Session, awsSecretID string, now time.Time, keyExpiration, publishDelay time.Duration, alg string) (*StorageAws, error) {
 	if awsSession == nil {
 		return nil, errors.New("aws session cannot be nil")
 	}
@@ -61,11 +88,40 @@ func NewStorageAws(awsSession *session.Session, awsSecretID string, now time.Tim
 		return nil, errors.New("aws secret id cannot be empty")
 	}
 
+	if keyExpiration > 0 && publishDelay >= keyExpiration {
+		return nil, errors.Errorf("publish delay %s must be less than the key expiration %s", publishDelay, keyExpiration)
+	}
+
+	// Default to the algorithm used before others were supported.
+	if alg == "" {
+		alg = algorithm
+	}
+
 	storage := &StorageAws{
+		// Init new AWS Secret Manager using provided AWS session.
+		secretManager: secretsmanager.New(awsSession),
+		awsSecretID:   awsSecretID,
 		keyExpiration: keyExpiration,
-		keys:          make(map[string]*PrivateKey),
+		publishDelay:  publishDelay,
+		algorithm:     alg,
 	}
 
+	err := storage.Reload(now)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// Reload loads the private keys from AWS Secrets Manager. Keys that have expired are not loaded and the next key is
+// generated the publish delay before the current key needs to be rotated.
+func (s *StorageAws) Reload(now time.Time) error {
+	secretManager := s.secretManager
+	awsSecretID := s.awsSecretID
+	keyExpiration := s.keyExpiration
+	keys := make(map[string]Signer)
+
 	if now.IsZero() {
 		now = time.Now().UTC()
 	}
@@ -74,10 +130,6 @@ func NewStorageAws(awsSession *session.Session, awsSecretID string, now time.Tim
 	// before this value will not be loaded.
 	var disabledCreatedDate time.Time
 
-	// Time threshold to create a new key. If a current key exists and the
-	// created date of the key is before this value, a new key will be created.
-	var activeCreatedDate time.Time
-
 	// If an expiration duration is included, convert to past time from now.
 	if keyExpiration.Seconds() != 0 {
 		// Ensure the expiration is a time in the past for comparison below.
@@ -86,14 +138,8 @@ func NewStorageAws(awsSession *session.Session, awsSecretID string, now time.Tim
 		}
 		// Stop loading keys when the created date exceeds two times the key expiration
 		disabledCreatedDate = now.UTC().Add(keyExpiration * 2)
-
-		// Time used to determine when a new key should be created.
-		activeCreatedDate = now.UTC().Add(keyExpiration)
 	}
 
-	// Init new AWS Secret Manager using provided AWS session.
-	secretManager := secretsmanager.New(awsSession)
-
 	// A List of version ids for the stored secret. All keys will be stored under
 	// the same name in AWS secret manager. We still want to load old keys for a
 	// short period of time to ensure any requests in flight have the opportunity
@@ -137,58 +183,47 @@ func NewStorageAws(awsSession *session.Session, awsSecretID string, now time.Tim
 		}
 
 		if !awsSecretIDNotFound {
-			return nil, errors.Wrapf(err, "aws list secret version ids for secret ID %s failed", awsSecretID)
+			return errors.Wrapf(err, "aws list secret version ids for secret ID %s failed", awsSecretID)
 		}
 	}
 
-	// Map of keys stored by version id. version id is kid.
-	keyContents := make(map[string][]byte)
-
-	// The current key id if there is an active one.
-	var curKeyId string
+	// List of the keys loaded with the time they were generated. The version id is the kid.
+	var storedKeys []storedKey
 
 	// If the list of version ids is not empty, load the keys from secret manager.
 	if len(versionIds) > 0 {
-		// The max created data to determine the most recent key.
-		var lastCreatedDate time.Time
-
 		for _, id := range versionIds {
 			res, err := secretManager.GetSecretValue(&secretsmanager.GetSecretValueInput{
 				SecretId:  aws.String(awsSecretID),
 				VersionId: aws.String(id),
 			})
 			if err != nil {
-				return nil, errors.Wrapf(err, "aws secret id %s, version id %s value failed", awsSecretID, id)
+				return errors.Wrapf(err, "aws secret id %s, version id %s value failed", awsSecretID, id)
 			}
 
 			if len(res.SecretBinary) == 0 {
 				continue
 			}
 
-			keyContents[*res.VersionId] = res.SecretBinary
-
-			if lastCreatedDate.IsZero() || res.CreatedDate.UTC().Unix() > lastCreatedDate.UTC().Unix() {
-				curKeyId = *res.VersionId
-				lastCreatedDate = res.CreatedDate.UTC()
+			pk, err := ParsePrivateKey(*res.VersionId, res.SecretBinary)
+			if err != nil {
+				return err
 			}
-		}
 
-		//
-		if !activeCreatedDate.IsZero() && lastCreatedDate.UTC().Unix() < activeCreatedDate.UTC().Unix() {
-			curKeyId = ""
+			storedKeys = append(storedKeys, storedKey{privateKey: pk, createdAt: res.CreatedDate.UTC()})
 		}
 	}
 
 	// If there are no keys stored in secret manager, create a new one or
-	// if the current key needs to be rotated, generate a new key and update the secret.
-	// @TODO: When a new key is generated and there are multiple instances of the service running
-	//  		its possible based on the key expiration set that requests fail because keys are only
-	// 			refreshed on instance launch. Could store keys in a kv store and update that value
-	// 			when new keys are generated
-	if len(keyContents) == 0 || curKeyId == "" {
-		privateKey, err := KeyGen()
+	// if the current key needs to be rotated, generate the next key and update the secret.
+	// When multiple instances of the service are running, the keys are reloaded periodically by
+	// the Authenticator so the next key is picked up by the other instances before it's used.
+	curPrivateKey, generate := currentKey(storedKeys, s.algorithm, now.UTC(), s.keyExpiration, s.publishDelay)
+	if generate {
+		var curKeyId string
+		privateKey, err := KeyGenAlgorithm(s.algorithm)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to generate new private key")
+			return errors.Wrap(err, "failed to generate new private key")
 		}
 
 		if awsSecretIDNotFound {
@@ -197,7 +232,7 @@ func NewStorageAws(awsSession *session.Session, awsSecretID string, now time.Tim
 				SecretBinary: privateKey,
 			})
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to create new secret with private key")
+				return errors.Wrap(err, "failed to create new secret with private key")
 			}
 			curKeyId = *res.VersionId
 		} else {
@@ -206,31 +241,29 @@ func NewStorageAws(awsSession *session.Session, awsSecretID string, now time.Tim
 				SecretBinary: privateKey,
 			})
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to create new secret with private key")
+				return errors.Wrap(err, "failed to create new secret with private key")
 			}
 			curKeyId = *res.VersionId
 		}
 
-		keyContents[curKeyId] = privateKey
-	}
-
-	// Loop through all the key bytes and load the private key.
-	for kid, key := range keyContents {
-		pk, err := jwt.ParseRSAPrivateKeyFromPEM(key)
+		pk, err := ParsePrivateKey(curKeyId, privateKey)
 		if err != nil {
-			return nil, errors.Wrap(err, "parsing auth private key")
+			return err
 		}
 
-		storage.keys[kid] = &PrivateKey{
-			PrivateKey: pk,
-			keyID:      kid,
-			algorithm:  algorithm,
-		}
+		storedKeys = append(storedKeys, storedKey{privateKey: pk, createdAt: now.UTC()})
 
-		if kid == curKeyId {
-			storage.curPrivateKey = storage.keys[kid]
-		}
+		curPrivateKey, _ = currentKey(storedKeys, s.algorithm, now.UTC(), s.keyExpiration, s.publishDelay)
 	}
 
-	return storage, nil
+	for _, k := range storedKeys {
+		keys[k.privateKey.KeyID()] = k.privateKey
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.curPrivateKey = curPrivateKey
+	s.mu.Unlock()
+
+	return nil
 }
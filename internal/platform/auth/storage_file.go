@@ -7,45 +7,66 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/pborman/uuid"
 	"github.com/pkg/errors"
 )
 
 // StorageFile is a storage engine that stores private keys on the local file system.
 type StorageFile struct {
+	// Guards the keys while they are reloaded.
+	mu sync.RWMutex
 	// Local directory for storing private keys.
 	localDir string
 	// Duration for keys to be valid.
 	keyExpiration time.Duration
+	// Duration a new key is published before it's used.
+	publishDelay time.Duration
+	// Algorithm used to generate new keys.
+	algorithm string
 	// Map of keys by kid (version id).
-	keys map[string]*PrivateKey
+	keys map[string]Signer
 	// The current active key to be used.
 	curPrivateKey *PrivateKey
 }
 
 // Keys returns a map of private keys by kID.
-func (s *StorageFile) Keys() map[string]*PrivateKey {
-	if s == nil || s.keys == nil {
-		return map[string]*PrivateKey{}
+func (s *StorageFile) Keys() map[string]Signer {
+	if s == nil {
+		return map[string]Signer{}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.keys == nil {
+		return map[string]Signer{}
 	}
 	return s.keys
 }
 
-// Current returns the most recently generated private key.
-func (s *StorageFile) Current() *PrivateKey {
+// Current returns the private key used to sign tokens, the most recently generated key that has been published for
+// the publish delay.
+func (s *StorageFile) Current() Signer {
 	if s == nil {
 		return nil
 	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.curPrivateKey == nil {
+		return nil
+	}
 	return s.curPrivateKey
 }
 
 // NewAuthenticatorFile is a help function that inits a new Authenticator
 // using the file storage.
-func NewAuthenticatorFile(localDir string, now time.Time, keyExpiration time.Duration) (*Authenticator, error) {
-	storage, err := NewStorageFile(localDir, now, keyExpiration)
+func NewAuthenticatorFile(localDir string, now time.Time, keyExpiration, publishDelay time.Duration, alg string) (*Authenticator, error) {
+	storage, err := NewStorageFile(localDir, now, keyExpiration, publishDelay, alg)
 	if err != nil {
 		return nil, err
 	}
@@ -54,8 +75,13 @@ func NewAuthenticatorFile(localDir string, now time.Time, keyExpiration time.Dur
 }
 
 // NewStorageFile implements the interface Storage to support persisting private keys
-// to the local file system.
-func NewStorageFile(localDir string, now time.Time, keyExpiration time.Duration) (*StorageFile, error) {
+// to the local file system. New keys are published for the publish delay before they are used, it should be at least
+// the interval the keys are reloaded plus the time the public keys are cached by clients.
+func NewStorageFile(localDir string, now time.Time, keyExpiration, publishDelay time.Duration, alg string) (*StorageFile, error) {
+	if keyExpiration > 0 && publishDelay >= keyExpiration {
+		return nil, errors.Errorf("publish delay %s must be less than the key expiration %s", publishDelay, keyExpiration)
+	}
+
 	if localDir == "" {
 		localDir = filepath.Join(os.TempDir(), "auth-private-keys")
 	}
@@ -67,12 +93,33 @@ func NewStorageFile(localDir string, now time.Time, keyExpiration time.Duration)
 		}
 	}
 
+	// Default to the algorithm used before others were supported.
+	if alg == "" {
+		alg = algorithm
+	}
+
 	storage := &StorageFile{
 		localDir:      localDir,
 		keyExpiration: keyExpiration,
-		keys:          make(map[string]*PrivateKey),
+		publishDelay:  publishDelay,
+		algorithm:     alg,
 	}
 
+	err := storage.Reload(now)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage, nil
+}
+
+// Reload loads the private keys from the local file system. Keys that have expired are not loaded and the next key is
+// generated the publish delay before the current key needs to be rotated.
+func (s *StorageFile) Reload(now time.Time) error {
+	localDir := s.localDir
+	keyExpiration := s.keyExpiration
+	keys := make(map[string]Signer)
+
 	if now.IsZero() {
 		now = time.Now().UTC()
 	}
@@ -81,10 +128,6 @@ func NewStorageFile(localDir string, now time.Time, keyExpiration time.Duration)
 	// before this value will not be loaded.
 	var disabledCreatedDate time.Time
 
-	// Time threshold to create a new key. If a current key exists and the
-	// created date of the key is before this value, a new key will be created.
-	var activeCreatedDate time.Time
-
 	// If an expiration duration is included, convert to past time from now.
 	if keyExpiration.Seconds() != 0 {
 		// Ensure the expiration is a time in the past for comparison below.
@@ -93,9 +136,6 @@ func NewStorageFile(localDir string, now time.Time, keyExpiration time.Duration)
 		}
 		// Stop loading keys when the created date exceeds two times the key expiration
 		disabledCreatedDate = now.UTC().Add(keyExpiration * 2)
-
-		// Time used to determine when a new key should be created.
-		activeCreatedDate = now.UTC().Add(keyExpiration)
 	}
 
 	// Values used to format filename.
@@ -104,17 +144,11 @@ func NewStorageFile(localDir string, now time.Time, keyExpiration time.Duration)
 
 	files, err := ioutil.ReadDir(localDir)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to list files in directory %s", localDir)
+		return errors.Wrapf(err, "failed to list files in directory %s", localDir)
 	}
 
-	// Map of keys stored by version id. version id is kid.
-	keyContents := make(map[string][]byte)
-
-	// The current key id if there is an active one.
-	var curKeyId string
-
-	// The max created data to determine the most recent key.
-	var lastCreatedDate time.Time
+	// List of the keys loaded with the time they were generated.
+	var storedKeys []storedKey
 
 	for _, f := range files {
 		if !strings.HasPrefix(f.Name(), filePrefix) || !strings.HasSuffix(f.Name(), fileExt) {
@@ -125,7 +159,7 @@ func NewStorageFile(localDir string, now time.Time, keyExpiration time.Duration)
 		fname := strings.TrimSuffix(f.Name(), fileExt)
 		pts := strings.Split(fname, "_")
 		if len(pts) != 3 {
-			return nil, errors.Errorf("unable to parse filename %s", f.Name())
+			return errors.Errorf("unable to parse filename %s", f.Name())
 		}
 		createdAt := pts[1]
 		kID := pts[2]
@@ -133,7 +167,7 @@ func NewStorageFile(localDir string, now time.Time, keyExpiration time.Duration)
 		// Covert string timestamp to int.
 		createdAtSecs, err := strconv.Atoi(createdAt)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed parse timestamp from %s", f.Name())
+			return errors.Wrapf(err, "failed parse timestamp from %s", f.Name())
 		}
 		ts := time.Unix(int64(createdAtSecs), 0)
 
@@ -145,27 +179,23 @@ func NewStorageFile(localDir string, now time.Time, keyExpiration time.Duration)
 		filePath := filepath.Join(localDir, f.Name())
 		dat, err := ioutil.ReadFile(filePath)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed read file %s", f.Name())
+			return errors.Wrapf(err, "failed read file %s", f.Name())
 		}
 
-		keyContents[kID] = dat
-
-		if lastCreatedDate.IsZero() || ts.UTC().Unix() > lastCreatedDate.UTC().Unix() {
-			curKeyId = kID
-			lastCreatedDate = ts.UTC()
+		pk, err := ParsePrivateKey(kID, dat)
+		if err != nil {
+			return err
 		}
-	}
 
-	//
-	if !activeCreatedDate.IsZero() && lastCreatedDate.UTC().Unix() < activeCreatedDate.UTC().Unix() {
-		curKeyId = ""
+		storedKeys = append(storedKeys, storedKey{privateKey: pk, createdAt: ts.UTC()})
 	}
 
-	// If there are no keys or the current key needs to be rotated, generate a new key.
-	if len(keyContents) == 0 || curKeyId == "" {
-		privateKey, err := KeyGen()
+	// If there are no keys or the current key needs to be rotated, generate the next key.
+	curPrivateKey, generate := currentKey(storedKeys, s.algorithm, now.UTC(), s.keyExpiration, s.publishDelay)
+	if generate {
+		privateKey, err := KeyGenAlgorithm(s.algorithm)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to generate new private key")
+			return errors.Wrap(err, "failed to generate new private key")
 		}
 
 		kID := uuid.NewRandom().String()
@@ -176,29 +206,27 @@ func NewStorageFile(localDir string, now time.Time, keyExpiration time.Duration)
 
 		err = ioutil.WriteFile(filePath, privateKey, 0644)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed write file %s", filePath)
+			return errors.Wrapf(err, "failed write file %s", filePath)
 		}
 
-		keyContents[curKeyId] = privateKey
-	}
-
-	// Loop through all the key bytes and load the private key.
-	for kid, key := range keyContents {
-		pk, err := jwt.ParseRSAPrivateKeyFromPEM(key)
+		pk, err := ParsePrivateKey(kID, privateKey)
 		if err != nil {
-			return nil, errors.Wrap(err, "parsing auth private key")
+			return err
 		}
 
-		storage.keys[kid] = &PrivateKey{
-			PrivateKey: pk,
-			keyID:      kid,
-			algorithm:  algorithm,
-		}
+		storedKeys = append(storedKeys, storedKey{privateKey: pk, createdAt: time.Unix(now.UTC().Unix(), 0).UTC()})
 
-		if kid == curKeyId {
-			storage.curPrivateKey = storage.keys[kid]
-		}
+		curPrivateKey, _ = currentKey(storedKeys, s.algorithm, now.UTC(), s.keyExpiration, s.publishDelay)
 	}
 
-	return storage, nil
+	for _, k := range storedKeys {
+		keys[k.privateKey.KeyID()] = k.privateKey
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.curPrivateKey = curPrivateKey
+	s.mu.Unlock()
+
+	return nil
 }
@@ -0,0 +1,303 @@
+package bruteforce
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	redistrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/go-redis/redis"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+var (
+	// ErrTooManyAttempts occurs when a key is temporarily blocked because of too many failed attempts.
+	ErrTooManyAttempts = errors.New("Too many failed attempts")
+)
+
+// BlockedError is returned when a key is blocked by either a progressive delay or a lockout.
+type BlockedError struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("%s for %s, retry after %s", ErrTooManyAttempts.Error(), e.Key, e.RetryAfter)
+}
+
+// Cause allows errors.Cause to return ErrTooManyAttempts.
+func (e *BlockedError) Cause() error {
+	return ErrTooManyAttempts
+}
+
+// RetryAfter returns the duration the client should wait before trying again when the error was caused by a blocked
+// key. The second value reports if a BlockedError was found.
+func RetryAfter(err error) (time.Duration, bool) {
+	type causer interface {
+		Cause() error
+	}
+
+	for err != nil {
+		if be, ok := err.(*BlockedError); ok {
+			return be.RetryAfter, true
+		}
+
+		cause, ok := err.(causer)
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+
+	return 0, false
+}
+
+// Config defines the thresholds used by the limiter.
+type Config struct {
+	// FreeAttempts is the number of failed attempts allowed before progressive delays are applied.
+	FreeAttempts int
+	// MaxAttempts is the number of failed attempts after which the key is locked out.
+	MaxAttempts int
+	// BaseDelay is the delay applied after the first failure that exceeds FreeAttempts. It doubles for each
+	// additional failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Lockout is how long the key is blocked once MaxAttempts has been reached.
+	Lockout time.Duration
+	// Window is how long failed attempts are remembered.
+	Window time.Duration
+}
+
+// Limiter tracks failed attempts in Redis and blocks keys using progressive delays and temporary lockouts. A nil
+// Limiter allows all attempts.
+type Limiter struct {
+	redis  *redistrace.Client
+	prefix string
+	cfg    Config
+}
+
+// New returns a limiter that stores its state in Redis with all keys prefixed by the provided value.
+func New(redisClient *redistrace.Client, prefix string, cfg Config) *Limiter {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.FreeAttempts <= 0 || cfg.FreeAttempts > cfg.MaxAttempts {
+		cfg.FreeAttempts = cfg.MaxAttempts
+	}
+	if cfg.BaseDelay <= 0 {
+		cfg.BaseDelay = time.Second
+	}
+	if cfg.MaxDelay < cfg.BaseDelay {
+		cfg.MaxDelay = cfg.BaseDelay
+	}
+	if cfg.Lockout <= 0 {
+		cfg.Lockout = 15 * time.Minute
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Hour
+	}
+
+	return &Limiter{
+		redis:  redisClient,
+		prefix: prefix,
+		cfg:    cfg,
+	}
+}
+
+// Key builds a key for the limiter from an action, ie login, the type of value and the value, ie an IP address or an
+// email address. Values are lower cased so an email address can't bypass the limiter by changing case.
+func Key(action, typ, value string) string {
+	return strings.Join([]string{action, typ, strings.ToLower(strings.TrimSpace(value))}, ":")
+}
+
+// RequestKeys returns the keys for an action limited by both the IP address making the request and the email address
+// provided. The IP key is empty when the request IP is not available on the context.
+func RequestKeys(ctx context.Context, action, email string) (ipKey, emailKey string) {
+	if vals, _ := webcontext.ContextValues(ctx); vals != nil && vals.RequestIP != "" {
+		ipKey = Key(action, "ip", vals.RequestIP)
+	}
+	emailKey = Key(action, "email", email)
+	return ipKey, emailKey
+}
+
+// ClientKeys returns the keys for an action limited by both the IP address making the request and the combination of
+// the value, ie an email address, and the IP address. Failed attempts from one IP address can't block the value for
+// other clients, so the value can't be locked out by anyone that knows it. The client key falls back to the value
+// when the request IP is not available on the context.
+func ClientKeys(ctx context.Context, action, value string) (ipKey, clientKey string) {
+	if vals, _ := webcontext.ContextValues(ctx); vals != nil && vals.RequestIP != "" {
+		ipKey = Key(action, "ip", vals.RequestIP)
+		clientKey = Key(action, "client", value+"|"+vals.RequestIP)
+	} else {
+		clientKey = Key(action, "client", value)
+	}
+	return ipKey, clientKey
+}
+
+// Allow returns a BlockedError when any of the keys is currently blocked. Empty keys are ignored.
+func (l *Limiter) Allow(ctx context.Context, keys ...string) error {
+	if l == nil || l.redis == nil {
+		return nil
+	}
+
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.platform.bruteforce.Allow")
+	defer span.Finish()
+
+	client := l.redis.WithContext(ctx)
+
+	var blocked *BlockedError
+	for _, k := range keys {
+		if k == "" {
+			continue
+		}
+
+		ttl, err := client.PTTL(l.blockKey(k)).Result()
+		if err != nil && err != redis.Nil {
+			return errors.Wrapf(err, "Get block for %s failed", k)
+		}
+
+		if ttl > 0 && (blocked == nil || ttl > blocked.RetryAfter) {
+			blocked = &BlockedError{Key: k, RetryAfter: ttl}
+		}
+	}
+
+	if blocked != nil {
+		return blocked
+	}
+
+	return nil
+}
+
+// Fail records a failed attempt for the key and blocks it for a progressive delay once the free attempts have been
+// used. When the max attempts are reached, the key is locked out and true is returned so the caller can notify the
+// owner of the key.
+func (l *Limiter) Fail(ctx context.Context, key string) (bool, error) {
+	if l == nil || l.redis == nil || key == "" {
+		return false, nil
+	}
+
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.platform.bruteforce.Fail")
+	defer span.Finish()
+
+	client := l.redis.WithContext(ctx)
+
+	pipe := client.Pipeline()
+	incr := pipe.Incr(l.failKey(key))
+	pipe.Expire(l.failKey(key), l.cfg.Window)
+	if _, err := pipe.Exec(); err != nil {
+		return false, errors.Wrapf(err, "Record failure for %s failed", key)
+	}
+
+	attempts := int(incr.Val())
+
+	if attempts >= l.cfg.MaxAttempts {
+		// Reset the failures so the delays start over once the lockout has expired.
+		pipe := client.Pipeline()
+		pipe.Set(l.blockKey(key), attempts, l.cfg.Lockout)
+		pipe.Del(l.failKey(key))
+		if _, err := pipe.Exec(); err != nil {
+			return false, errors.Wrapf(err, "Lockout %s failed", key)
+		}
+		return true, nil
+	}
+
+	if delay := l.Delay(attempts); delay > 0 {
+		err := client.Set(l.blockKey(key), attempts, delay).Err()
+		if err != nil {
+			return false, errors.Wrapf(err, "Block %s failed", key)
+		}
+	}
+
+	return false, nil
+}
+
+// Throttle records a failed attempt for the key and blocks it for a progressive delay once the free attempts have
+// been used. Unlike Fail the key is never locked out, the delay stops growing at MaxDelay. It's used for values anyone
+// can submit, ie an email address, so attempts from many IP addresses are slowed down without allowing anyone to lock
+// out the owner of the value.
+func (l *Limiter) Throttle(ctx context.Context, key string) error {
+	if l == nil || l.redis == nil || key == "" {
+		return nil
+	}
+
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.platform.bruteforce.Throttle")
+	defer span.Finish()
+
+	client := l.redis.WithContext(ctx)
+
+	pipe := client.Pipeline()
+	incr := pipe.Incr(l.failKey(key))
+	pipe.Expire(l.failKey(key), l.cfg.Window)
+	if _, err := pipe.Exec(); err != nil {
+		return errors.Wrapf(err, "Record failure for %s failed", key)
+	}
+
+	if delay := l.ThrottleDelay(int(incr.Val())); delay > 0 {
+		err := client.Set(l.blockKey(key), incr.Val(), delay).Err()
+		if err != nil {
+			return errors.Wrapf(err, "Block %s failed", key)
+		}
+	}
+
+	return nil
+}
+
+// Reset clears the failed attempts and any block for the key, ie after a successful login.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	if l == nil || l.redis == nil || key == "" {
+		return nil
+	}
+
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.platform.bruteforce.Reset")
+	defer span.Finish()
+
+	err := l.redis.WithContext(ctx).Del(l.failKey(key), l.blockKey(key)).Err()
+	if err != nil {
+		return errors.Wrapf(err, "Reset %s failed", key)
+	}
+
+	return nil
+}
+
+// Delay returns how long a key is blocked after the number of failed attempts. It returns zero while there are free
+// attempts left and the lockout duration once the max attempts have been reached.
+func (l *Limiter) Delay(attempts int) time.Duration {
+	if attempts >= l.cfg.MaxAttempts {
+		return l.cfg.Lockout
+	}
+	return l.ThrottleDelay(attempts)
+}
+
+// ThrottleDelay returns how long a throttled key is blocked after the number of failed attempts. It returns zero
+// while there are free attempts left and never more than MaxDelay.
+func (l *Limiter) ThrottleDelay(attempts int) time.Duration {
+	if attempts <= l.cfg.FreeAttempts {
+		return 0
+	}
+
+	delay := float64(l.cfg.BaseDelay) * math.Pow(2, float64(attempts-l.cfg.FreeAttempts-1))
+	if delay > float64(l.cfg.MaxDelay) {
+		return l.cfg.MaxDelay
+	}
+
+	return time.Duration(delay)
+}
+
+// LockoutDuration returns how long a key is blocked once the max attempts have been reached.
+func (l *Limiter) LockoutDuration() time.Duration {
+	return l.cfg.Lockout
+}
+
+func (l *Limiter) failKey(key string) string {
+	return l.prefix + ":fail:" + key
+}
+
+func (l *Limiter) blockKey(key string) string {
+	return l.prefix + ":block:" + key
+}
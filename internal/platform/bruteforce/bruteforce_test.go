@@ -0,0 +1,178 @@
+package bruteforce
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+
+	"github.com/pkg/errors"
+)
+
+// TestDelay validates the progressive delays applied after failed attempts.
+func TestDelay(t *testing.T) {
+	l := New(nil, "test", Config{
+		FreeAttempts: 3,
+		MaxAttempts:  8,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Second,
+		Lockout:      15 * time.Minute,
+	})
+
+	var delayTests = []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 5 * time.Second},
+		{8, 15 * time.Minute},
+		{12, 15 * time.Minute},
+	}
+
+	t.Log("Given the need to apply progressive delays after failed attempts.")
+	{
+		for i, tt := range delayTests {
+			t.Logf("\tTest: %d\tWhen attempts is %d", i, tt.attempts)
+			{
+				got := l.Delay(tt.attempts)
+				if got != tt.want {
+					t.Logf("\t\tGot : %v", got)
+					t.Logf("\t\tWant: %v", tt.want)
+					t.Fatalf("\t%s\tDelay failed.", tests.Failed)
+				}
+				t.Logf("\t%s\tDelay ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestThrottleDelay validates the delays of throttled keys stop growing at the max delay without a lockout.
+func TestThrottleDelay(t *testing.T) {
+	l := New(nil, "test", Config{
+		FreeAttempts: 3,
+		MaxAttempts:  8,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Second,
+		Lockout:      15 * time.Minute,
+	})
+
+	var delayTests = []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{3, 0},
+		{4, time.Second},
+		{6, 4 * time.Second},
+		{8, 5 * time.Second},
+		{1000, 5 * time.Second},
+	}
+
+	t.Log("Given the need to throttle failed attempts without a lockout.")
+	{
+		for i, tt := range delayTests {
+			t.Logf("\tTest: %d\tWhen attempts is %d", i, tt.attempts)
+			{
+				got := l.ThrottleDelay(tt.attempts)
+				if got != tt.want {
+					t.Logf("\t\tGot : %v", got)
+					t.Logf("\t\tWant: %v", tt.want)
+					t.Fatalf("\t%s\tThrottleDelay failed.", tests.Failed)
+				}
+				t.Logf("\t%s\tThrottleDelay ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestRetryAfter validates the retry duration can be recovered from a wrapped BlockedError.
+func TestRetryAfter(t *testing.T) {
+	t.Log("Given the need to return the retry duration for a blocked key.")
+	{
+		err := errors.WithMessage(&BlockedError{Key: "login:ip:127.0.0.1", RetryAfter: time.Minute}, "authenticate failed")
+
+		if errors.Cause(err) != ErrTooManyAttempts {
+			t.Logf("\t\tGot : %+v", errors.Cause(err))
+			t.Logf("\t\tWant: %+v", ErrTooManyAttempts)
+			t.Fatalf("\t%s\tCause failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCause ok.", tests.Success)
+
+		d, ok := RetryAfter(err)
+		if !ok || d != time.Minute {
+			t.Logf("\t\tGot : %v %v", d, ok)
+			t.Logf("\t\tWant: %v %v", time.Minute, true)
+			t.Fatalf("\t%s\tRetryAfter failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRetryAfter ok.", tests.Success)
+
+		if _, ok := RetryAfter(errors.New("other")); ok {
+			t.Fatalf("\t%s\tRetryAfter for other error failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRetryAfter for other error ok.", tests.Success)
+	}
+}
+
+// TestClientKeys validates the keys for a value are scoped to the IP address of the request.
+func TestClientKeys(t *testing.T) {
+	t.Log("Given the need to limit failed attempts for a value by client.")
+	{
+		t.Log("\tWhen the request IP is available.")
+		{
+			ctx := context.WithValue(context.Background(), webcontext.KeyValues, &webcontext.Values{RequestIP: "127.0.0.1"})
+
+			ipKey, clientKey := ClientKeys(ctx, "login", "Gabi@Geeksinthewoods.com")
+			if ipKey != "login:ip:127.0.0.1" {
+				t.Logf("\t\tGot : %v", ipKey)
+				t.Fatalf("\t%s\tIP key failed.", tests.Failed)
+			} else if clientKey != "login:client:gabi@geeksinthewoods.com|127.0.0.1" {
+				t.Logf("\t\tGot : %v", clientKey)
+				t.Fatalf("\t%s\tClient key failed.", tests.Failed)
+			}
+			t.Logf("\t%s\tKeys ok.", tests.Success)
+
+			// Another IP address gets a different key so it's not blocked by the failures of the first.
+			ctx2 := context.WithValue(context.Background(), webcontext.KeyValues, &webcontext.Values{RequestIP: "10.0.0.1"})
+			if _, clientKey2 := ClientKeys(ctx2, "login", "gabi@geeksinthewoods.com"); clientKey2 == clientKey {
+				t.Logf("\t\tGot : %v", clientKey2)
+				t.Fatalf("\t%s\tClient key for another IP should differ.", tests.Failed)
+			}
+			t.Logf("\t%s\tKey for another IP ok.", tests.Success)
+		}
+
+		t.Log("\tWhen the request IP is not available.")
+		{
+			ipKey, clientKey := ClientKeys(context.Background(), "login", "gabi@geeksinthewoods.com")
+			if ipKey != "" || clientKey != "login:client:gabi@geeksinthewoods.com" {
+				t.Logf("\t\tGot : %v %v", ipKey, clientKey)
+				t.Fatalf("\t%s\tKeys failed.", tests.Failed)
+			}
+			t.Logf("\t%s\tKeys ok.", tests.Success)
+		}
+	}
+}
+
+// TestNilLimiter validates a nil limiter allows all attempts.
+func TestNilLimiter(t *testing.T) {
+	t.Log("Given the need to disable the limiter when it's not configured.")
+	{
+		var l *Limiter
+
+		if err := l.Allow(context.Background(), Key("login", "ip", "127.0.0.1")); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tAllow failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAllow ok.", tests.Success)
+
+		if locked, err := l.Fail(context.Background(), Key("login", "ip", "127.0.0.1")); err != nil || locked {
+			t.Log("\t\tGot :", locked, err)
+			t.Fatalf("\t%s\tFail failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tFail ok.", tests.Success)
+	}
+}
@@ -0,0 +1,231 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/pkg/errors"
+)
+
+// Cursor is the position of the last row of a page used for keyset pagination. Instead of using an offset, the
+// next page is selected by comparing the ordered columns to the values of the last row so the query can use an
+// index and rows are not skipped or repeated when rows are added between requests.
+type Cursor struct {
+	Order  []string      `json:"o"`
+	Values []interface{} `json:"v"`
+}
+
+// KeyOrder appends the key fields to the order so the rows have a stable order for pagination.
+func (fs Fields) KeyOrder(order []string) []string {
+	res := append([]string{}, order...)
+	for _, f := range fs {
+		if !f.Key {
+			continue
+		}
+
+		var exists bool
+		for _, o := range order {
+			if col, _ := splitOrder(o); col == f.column() {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			res = append(res, f.column()+" asc")
+		}
+	}
+	return res
+}
+
+// FetchLimit returns the limit used to query a page. One more row than the limit is fetched so NextPage can determine
+// if there is a page after it without counting the rows.
+func FetchLimit(limit *uint) *uint {
+	if limit == nil || *limit == 0 {
+		return limit
+	}
+	l := *limit + 1
+	return &l
+}
+
+// NextPage removes the extra row fetched with FetchLimit from the rows and returns the encoded cursor for the page
+// after them. More is true when there is a next page, the cursor is only returned when there is a next page and the
+// order can be used for keyset pagination, otherwise an empty string is returned. Rows must be a pointer to a slice.
+func (fs Fields) NextPage(order []string, limit *uint, rows interface{}) (string, bool, error) {
+	rv := reflect.ValueOf(rows)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice {
+		return "", false, errors.Errorf("rows must be a pointer to a slice, got %T", rows)
+	}
+	rv = rv.Elem()
+
+	if limit == nil || *limit == 0 || rv.Len() <= int(*limit) {
+		return "", false, nil
+	}
+	rv.Set(rv.Slice(0, int(*limit)))
+
+	cursor, err := fs.nextCursor(order, rv.Index(rv.Len()-1).Interface())
+	if err != nil {
+		return "", false, err
+	}
+
+	return cursor, true, nil
+}
+
+// nextCursor returns the encoded cursor for the rows after the last row of a page.
+func (fs Fields) nextCursor(order []string, last interface{}) (string, error) {
+	if len(order) == 0 {
+		return "", nil
+	}
+
+	// The fields of the last row are used to build the cursor. The JSON names of the row are expected to match the
+	// names of the fields.
+	dat, err := json.Marshal(last)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	var row map[string]interface{}
+	if err := json.Unmarshal(dat, &row); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	c := Cursor{Order: order}
+	for _, o := range order {
+		col, _ := splitOrder(o)
+
+		f, ok := fs.lookupColumn(col)
+		if !ok || f.Nullable {
+			return "", nil
+		}
+
+		v, ok := row[f.Name]
+		if !ok || v == nil {
+			return "", nil
+		}
+		c.Values = append(c.Values, v)
+	}
+
+	dat, err = json.Marshal(c)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(dat), nil
+}
+
+// ApplyCursor decodes the cursor and adds the condition to the select query to only include the rows after the
+// cursor. The order must be the same as the order used to create the cursor.
+func (fs Fields) ApplyCursor(query *sqlbuilder.SelectBuilder, order []string, cursor string) error {
+	invalid := func(msg string) error {
+		return weberror.NewFieldsError(ErrInvalidCursor, newFieldError("cursor", cursor, "cursor", msg))
+	}
+
+	dat, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return invalid("cursor is invalid")
+	}
+
+	var c Cursor
+	dec := json.NewDecoder(bytes.NewReader(dat))
+	dec.UseNumber()
+	if err := dec.Decode(&c); err != nil || len(c.Order) == 0 || len(c.Order) != len(c.Values) {
+		return invalid("cursor is invalid")
+	}
+
+	if strings.Join(c.Order, ",") != strings.Join(order, ",") {
+		return invalid("cursor does not match the order, the order can't be changed when paging")
+	}
+
+	var (
+		cols []string
+		dirs []string
+		vals []interface{}
+	)
+	for i, o := range c.Order {
+		col, dir := splitOrder(o)
+
+		f, ok := fs.lookupColumn(col)
+		if !ok || f.Nullable {
+			return invalid("cursor is invalid")
+		}
+
+		v, err := cursorValue(f, c.Values[i])
+		if err != nil {
+			return invalid("cursor is invalid")
+		}
+
+		cols = append(cols, col)
+		dirs = append(dirs, dir)
+		vals = append(vals, v)
+	}
+
+	// Rows are after the cursor when the first column that is not equal to the value of the cursor is after it in
+	// the direction of the order.
+	var or []string
+	for i := range cols {
+		var and []string
+		for j := 0; j < i; j++ {
+			and = append(and, query.Equal(cols[j], vals[j]))
+		}
+
+		if dirs[i] == "desc" {
+			and = append(and, query.LessThan(cols[i], vals[i]))
+		} else {
+			and = append(and, query.GreaterThan(cols[i], vals[i]))
+		}
+
+		or = append(or, query.And(and...))
+	}
+	query.Where(query.Or(or...))
+
+	return nil
+}
+
+// cursorValue converts the decoded value of a cursor to the type of the field.
+func cursorValue(f Field, v interface{}) (interface{}, error) {
+	switch f.Type {
+	case FieldType_Int:
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, errors.Errorf("%s is not a number", f.Name)
+		}
+		return n.Int64()
+	case FieldType_Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.Errorf("%s is not a boolean", f.Name)
+		}
+		return b, nil
+	case FieldType_Time:
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.Errorf("%s is not a time", f.Name)
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	default:
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.Errorf("%s is not a string", f.Name)
+		}
+		return s, nil
+	}
+}
+
+// splitOrder returns the column and direction of an order value.
+func splitOrder(o string) (string, string) {
+	pts := strings.Fields(o)
+	if len(pts) == 0 {
+		return "", ""
+	}
+
+	dir := "asc"
+	if len(pts) > 1 {
+		dir = strings.ToLower(pts[1])
+	}
+	return pts[0], dir
+}
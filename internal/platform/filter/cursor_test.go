@@ -0,0 +1,157 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+	"github.com/google/go-cmp/cmp"
+	"github.com/huandu/go-sqlbuilder"
+)
+
+var cursorFields = Fields{
+	{Name: "id", Type: FieldType_UUID, Sortable: true, Key: true},
+	{Name: "name", Type: FieldType_String, Sortable: true},
+	{Name: "attempts", Type: FieldType_Int, Sortable: true},
+	{Name: "created_at", Type: FieldType_Time, Sortable: true},
+	{Name: "archived_at", Type: FieldType_Time, Sortable: true, Nullable: true},
+}
+
+type cursorRow struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Attempts   int        `json:"attempts"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ArchivedAt *time.Time `json:"archived_at"`
+}
+
+// TestKeyOrder validates the key fields are appended to the order.
+func TestKeyOrder(t *testing.T) {
+	t.Log("Given the need to ensure rows have a stable order for cursor pagination.")
+	{
+		var orderTests = []struct {
+			order    []string
+			expected []string
+		}{
+			{nil, []string{"id asc"}},
+			{[]string{"created_at desc"}, []string{"created_at desc", "id asc"}},
+			{[]string{"id desc", "name asc"}, []string{"id desc", "name asc"}},
+		}
+
+		for _, tt := range orderTests {
+			res := cursorFields.KeyOrder(tt.order)
+			if diff := cmp.Diff(res, tt.expected); diff != "" {
+				t.Fatalf("\t%s\tExpected order to match. Diff:\n%s", tests.Failed, diff)
+			}
+		}
+		t.Logf("\t%s\tKeyOrder ok.", tests.Success)
+	}
+}
+
+// TestCursor validates the cursor for the next page is created from the last row and compiled to where conditions.
+func TestCursor(t *testing.T) {
+	created := time.Date(2019, time.August, 1, 10, 30, 0, 123000000, time.UTC)
+
+	// One more row than the limit is fetched to determine if there is a next page.
+	rows := []cursorRow{
+		{ID: "4b4f0b19-2f62-4b6e-8ab3-6d3a6e0a1c52", Name: "Moon", Attempts: 1, CreatedAt: created.Add(time.Hour)},
+		{ID: "985f1746-1d9f-459f-a2d9-fc53ece5ae86", Name: "Mars", Attempts: 3, CreatedAt: created},
+		{ID: "c4653bf9-5978-48b7-89c5-95704aebb7e2", Name: "Venus", Attempts: 5, CreatedAt: created},
+	}
+	limit := uint(len(rows) - 1)
+
+	var cursorTests = []struct {
+		name     string
+		order    []string
+		expected string
+		args     []interface{}
+	}{
+		{"Key only",
+			[]string{"id asc"},
+			"SELECT id FROM projects WHERE ((id > ?))",
+			[]interface{}{"985f1746-1d9f-459f-a2d9-fc53ece5ae86"},
+		},
+		{"Time desc",
+			[]string{"created_at desc", "id asc"},
+			"SELECT id FROM projects WHERE ((created_at < ?) OR (created_at = ? AND id > ?))",
+			[]interface{}{created, created, "985f1746-1d9f-459f-a2d9-fc53ece5ae86"},
+		},
+		{"Int and string",
+			[]string{"attempts desc", "name asc", "id asc"},
+			"SELECT id FROM projects WHERE ((attempts < ?) OR (attempts = ? AND name > ?) OR (attempts = ? AND name = ? AND id > ?))",
+			[]interface{}{int64(3), int64(3), "Mars", int64(3), "Mars", "985f1746-1d9f-459f-a2d9-fc53ece5ae86"},
+		},
+	}
+
+	t.Log("Given the need to page through results with a cursor.")
+	{
+		for i, tt := range cursorTests {
+			t.Logf("\tTest: %d\tWhen running test: %s", i, tt.name)
+			{
+				page := append([]cursorRow{}, rows...)
+				cursor, more, err := cursorFields.NextPage(tt.order, &limit, &page)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tNextPage failed.", tests.Failed)
+				} else if !more || cursor == "" {
+					t.Fatalf("\t%s\tExpected cursor when there are more rows.", tests.Failed)
+				} else if len(page) != int(limit) {
+					t.Fatalf("\t%s\tExpected page to have %d rows, got %d.", tests.Failed, limit, len(page))
+				}
+
+				query := sqlbuilder.NewSelectBuilder()
+				query.Select("id")
+				query.From("projects")
+
+				err = cursorFields.ApplyCursor(query, tt.order, cursor)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tApplyCursor failed.", tests.Failed)
+				}
+
+				sql, args := query.Build()
+				if diff := cmp.Diff(sql, tt.expected); diff != "" {
+					t.Fatalf("\t%s\tExpected result query to match. Diff:\n%s", tests.Failed, diff)
+				}
+
+				if diff := cmp.Diff(args, tt.args); diff != "" {
+					t.Fatalf("\t%s\tExpected result args to match. Diff:\n%s", tests.Failed, diff)
+				}
+
+				t.Logf("\t%s\tCursor ok.", tests.Success)
+			}
+		}
+
+		// No cursor should be returned when there are no more rows, even when the page is full, or the order
+		// includes a nullable field.
+		page := append([]cursorRow{}, rows[:limit]...)
+		if cursor, more, _ := cursorFields.NextPage([]string{"id asc"}, &limit, &page); cursor != "" || more {
+			t.Fatalf("\t%s\tExpected no cursor for the last page.", tests.Failed)
+		}
+		page = append([]cursorRow{}, rows...)
+		if cursor, more, _ := cursorFields.NextPage([]string{"archived_at asc", "id asc"}, &limit, &page); cursor != "" || !more {
+			t.Fatalf("\t%s\tExpected no cursor for order with nullable field.", tests.Failed)
+		}
+		t.Logf("\t%s\tNo cursor ok.", tests.Success)
+
+		// The cursor is rejected when it was created for a different order or is invalid.
+		page = append([]cursorRow{}, rows...)
+		cursor, _, _ := cursorFields.NextPage([]string{"id asc"}, &limit, &page)
+		for _, c := range []struct {
+			order  []string
+			cursor string
+		}{
+			{[]string{"name asc", "id asc"}, cursor},
+			{[]string{"id asc"}, "not-a-cursor"},
+		} {
+			err := cursorFields.ApplyCursor(sqlbuilder.NewSelectBuilder(), c.order, c.cursor)
+			webErr, ok := err.(*weberror.Error)
+			if !ok || webErr.Err != ErrInvalidCursor {
+				t.Logf("\t\tGot : %+v", err)
+				t.Fatalf("\t%s\tExpected invalid cursor error.", tests.Failed)
+			}
+		}
+		t.Logf("\t%s\tInvalid cursor ok.", tests.Success)
+	}
+}
@@ -0,0 +1,461 @@
+package filter
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidCursor occurs when the cursor can't be decoded or was created for a different order.
+	ErrInvalidCursor = errors.New("Invalid cursor")
+
+	// ErrInvalidFilter occurs when a filter references an unknown field or contains invalid values.
+	ErrInvalidFilter = errors.New("Invalid filter")
+
+	// ErrInvalidOrder occurs when the order references a field that is not sortable.
+	ErrInvalidOrder = errors.New("Invalid order")
+)
+
+// filterKeyRe matches query keys in the format filter[field] and filter[field][operator].
+var filterKeyRe = regexp.MustCompile(`^filter\[([a-z0-9_]+)\](?:\[([a-z_]+)\])?$`)
+
+// Operator defines how the value of a field is compared to the values of a condition.
+type Operator string
+
+// Operator values define the comparisons supported by filter conditions.
+const (
+	// Op_Eq matches when the field is equal to the value.
+	Op_Eq Operator = "eq"
+	// Op_Ne matches when the field is not equal to the value.
+	Op_Ne Operator = "ne"
+	// Op_Contains matches when the field contains the value, the comparison is case insensitive.
+	Op_Contains Operator = "contains"
+	// Op_StartsWith matches when the field starts with the value, the comparison is case insensitive.
+	Op_StartsWith Operator = "starts_with"
+	// Op_In matches when the field is equal to one of the comma separated values.
+	Op_In Operator = "in"
+	// Op_Gt matches when the field is greater than the value.
+	Op_Gt Operator = "gt"
+	// Op_Gte matches when the field is greater than or equal to the value.
+	Op_Gte Operator = "gte"
+	// Op_Lt matches when the field is less than the value.
+	Op_Lt Operator = "lt"
+	// Op_Lte matches when the field is less than or equal to the value.
+	Op_Lte Operator = "lte"
+	// Op_Null matches when the field is null for the value true and not null for the value false.
+	Op_Null Operator = "null"
+)
+
+// FieldType defines the type of values a field accepts.
+type FieldType string
+
+// FieldType values define the types of fields that can be filtered.
+const (
+	FieldType_String FieldType = "string"
+	FieldType_UUID   FieldType = "uuid"
+	FieldType_Enum   FieldType = "enum"
+	FieldType_Int    FieldType = "int"
+	FieldType_Bool   FieldType = "bool"
+	FieldType_Time   FieldType = "time"
+)
+
+// FieldType_Operators defines the operators supported for each field type.
+var FieldType_Operators = map[FieldType][]Operator{
+	FieldType_String: {Op_Eq, Op_Ne, Op_Contains, Op_StartsWith, Op_In, Op_Null},
+	FieldType_UUID:   {Op_Eq, Op_Ne, Op_In, Op_Null},
+	FieldType_Enum:   {Op_Eq, Op_Ne, Op_In},
+	FieldType_Int:    {Op_Eq, Op_Ne, Op_In, Op_Gt, Op_Gte, Op_Lt, Op_Lte, Op_Null},
+	FieldType_Bool:   {Op_Eq, Op_Ne, Op_Null},
+	FieldType_Time:   {Op_Eq, Op_Gt, Op_Gte, Op_Lt, Op_Lte, Op_Null},
+}
+
+// Field defines a column of an entity that clients are allowed to filter or sort by.
+type Field struct {
+	// Name is the name of the field used by requests.
+	Name string
+	// Column is the database column for the field, defaults to name.
+	Column string
+	// Type is used to determine the supported operators and to validate the values.
+	Type FieldType
+	// Values are the allowed values for fields of type enum.
+	Values []string
+	// Filterable allows the field to be used in a filter.
+	Filterable bool
+	// Sortable allows the field to be used to order results.
+	Sortable bool
+	// Nullable marks fields that can be null, they can't be used to order results or for cursor pagination.
+	Nullable bool
+	// Key marks the fields that uniquely identify a row, they are appended to the order for cursor pagination.
+	Key bool
+}
+
+// column returns the database column for the field.
+func (f Field) column() string {
+	if f.Column != "" {
+		return f.Column
+	}
+	return f.Name
+}
+
+// supports checks if the operator can be used for the field.
+func (f Field) supports(op Operator) bool {
+	for _, o := range FieldType_Operators[f.Type] {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Fields defines the list of fields that can be filtered or sorted for an entity.
+type Fields []Field
+
+// Lookup returns the field for the provided name.
+func (fs Fields) Lookup(name string) (Field, bool) {
+	for _, f := range fs {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// lookupColumn returns the field for the provided database column.
+func (fs Fields) lookupColumn(col string) (Field, bool) {
+	for _, f := range fs {
+		if f.column() == col {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// FilterableNames returns the names of the fields that can be used in a filter.
+func (fs Fields) FilterableNames() []string {
+	var l []string
+	for _, f := range fs {
+		if f.Filterable {
+			l = append(l, f.Name)
+		}
+	}
+	return l
+}
+
+// SortableNames returns the names of the fields that can be used to order results.
+func (fs Fields) SortableNames() []string {
+	var l []string
+	for _, f := range fs {
+		if f.Sortable && !f.Nullable {
+			l = append(l, f.Name)
+		}
+	}
+	return l
+}
+
+// Order validates the list of order values in the format "field [asc|desc]" and returns the columns to order by.
+func (fs Fields) Order(order []string) ([]string, error) {
+	var (
+		res    []string
+		fields []weberror.FieldError
+	)
+	for _, o := range order {
+		pts := strings.Fields(o)
+		if len(pts) == 0 {
+			continue
+		}
+
+		f, ok := fs.Lookup(pts[0])
+		if !ok || !f.Sortable {
+			fields = append(fields, newFieldError("order", o, "sortable",
+				fmt.Sprintf("%s is not a sortable field, must be one of [%s]", pts[0], strings.Join(fs.SortableNames(), " "))))
+			continue
+		} else if f.Nullable {
+			// Null values are not ordered consistently with the other values and can't be compared in a cursor.
+			fields = append(fields, newFieldError("order", o, "sortable",
+				fmt.Sprintf("%s can be null and can't be used to order results, must be one of [%s]", pts[0], strings.Join(fs.SortableNames(), " "))))
+			continue
+		}
+
+		dir := "asc"
+		if len(pts) > 1 {
+			dir = strings.ToLower(pts[1])
+		}
+		if len(pts) > 2 || (dir != "asc" && dir != "desc") {
+			fields = append(fields, newFieldError("order", o, "oneof",
+				fmt.Sprintf("order for %s must be one of [asc desc]", pts[0])))
+			continue
+		}
+
+		res = append(res, f.column()+" "+dir)
+	}
+
+	if len(fields) > 0 {
+		return nil, weberror.NewFieldsError(ErrInvalidOrder, fields...)
+	}
+
+	return res, nil
+}
+
+// EnumValues converts the options of an enum to the values of a field.
+func EnumValues(l []interface{}) []string {
+	var res []string
+	for _, v := range l {
+		res = append(res, fmt.Sprintf("%v", v))
+	}
+	return res
+}
+
+// Condition defines a comparison of a field to one or more values.
+type Condition struct {
+	Field    string   `json:"field" example:"name"`
+	Operator Operator `json:"operator" example:"contains"`
+	Values   []string `json:"values" example:"moon"`
+}
+
+// key returns the query key for the condition.
+func (c Condition) key() string {
+	return fmt.Sprintf("filter[%s][%s]", c.Field, c.Operator)
+}
+
+// Filter is a list of conditions that must all match.
+type Filter []Condition
+
+// Parse extracts the filter from query values in the format filter[field][operator]=value. When the operator is
+// omitted, eq is used. The values for the in operator are comma separated. The conditions are validated against the
+// provided fields.
+func Parse(vals url.Values, fields Fields) (Filter, error) {
+	var keys []string
+	for k := range vals {
+		if strings.HasPrefix(k, "filter[") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var (
+		f    Filter
+		errs []weberror.FieldError
+	)
+	for _, k := range keys {
+		m := filterKeyRe.FindStringSubmatch(k)
+		if m == nil {
+			errs = append(errs, newFieldError(k, strings.Join(vals[k], ","), "filter",
+				fmt.Sprintf("%s must be in the format filter[field][operator]", k)))
+			continue
+		}
+
+		op := Operator(m[2])
+		if op == "" {
+			op = Op_Eq
+		}
+
+		for _, v := range vals[k] {
+			c := Condition{Field: m[1], Operator: op}
+			if op == Op_In {
+				for _, iv := range strings.Split(v, ",") {
+					iv = strings.TrimSpace(iv)
+					if iv != "" {
+						c.Values = append(c.Values, iv)
+					}
+				}
+			} else {
+				c.Values = []string{v}
+			}
+			f = append(f, c)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, weberror.NewFieldsError(ErrInvalidFilter, errs...)
+	}
+
+	if err := f.Validate(fields); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Validate ensures each condition references a filterable field with a supported operator and valid values.
+func (f Filter) Validate(fields Fields) error {
+	var errs []weberror.FieldError
+	for _, c := range f {
+		if _, err := c.args(fields); err != nil {
+			errs = append(errs, *err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return weberror.NewFieldsError(ErrInvalidFilter, errs...)
+	}
+
+	return nil
+}
+
+// Apply validates the filter and adds the conditions to the select query. All values are passed as bind vars.
+func (f Filter) Apply(query *sqlbuilder.SelectBuilder, fields Fields) error {
+	if err := f.Validate(fields); err != nil {
+		return err
+	}
+
+	for _, c := range f {
+		fd, _ := fields.Lookup(c.Field)
+		col := fd.column()
+
+		args, _ := c.args(fields)
+
+		switch c.Operator {
+		case Op_Eq:
+			query.Where(query.Equal(col, args[0]))
+		case Op_Ne:
+			query.Where(query.NotEqual(col, args[0]))
+		case Op_Contains:
+			query.Where(fmt.Sprintf("%s ILIKE %s", col, query.Var("%"+escapeLike(c.Values[0])+"%")))
+		case Op_StartsWith:
+			query.Where(fmt.Sprintf("%s ILIKE %s", col, query.Var(escapeLike(c.Values[0])+"%")))
+		case Op_In:
+			query.Where(query.In(col, args...))
+		case Op_Gt:
+			query.Where(query.GreaterThan(col, args[0]))
+		case Op_Gte:
+			query.Where(query.GreaterEqualThan(col, args[0]))
+		case Op_Lt:
+			query.Where(query.LessThan(col, args[0]))
+		case Op_Lte:
+			query.Where(query.LessEqualThan(col, args[0]))
+		case Op_Null:
+			if args[0].(bool) {
+				query.Where(query.IsNull(col))
+			} else {
+				query.Where(query.IsNotNull(col))
+			}
+		}
+	}
+
+	return nil
+}
+
+// args validates the condition and converts the values to the type of the field.
+func (c Condition) args(fields Fields) ([]interface{}, *weberror.FieldError) {
+	key := c.key()
+	val := strings.Join(c.Values, ",")
+
+	fd, ok := fields.Lookup(c.Field)
+	if !ok || !fd.Filterable {
+		err := newFieldError(key, val, "filter",
+			fmt.Sprintf("%s is not a filterable field, must be one of [%s]", c.Field, strings.Join(fields.FilterableNames(), " ")))
+		return nil, &err
+	}
+
+	if !fd.supports(c.Operator) {
+		var ops []string
+		for _, o := range FieldType_Operators[fd.Type] {
+			ops = append(ops, string(o))
+		}
+		err := newFieldError(key, val, "operator",
+			fmt.Sprintf("operator for %s must be one of [%s]", c.Field, strings.Join(ops, " ")))
+		return nil, &err
+	}
+
+	if len(c.Values) == 0 || (c.Operator != Op_In && len(c.Values) > 1) {
+		err := newFieldError(key, val, "required", fmt.Sprintf("%s requires a value", key))
+		return nil, &err
+	}
+
+	var args []interface{}
+	for _, v := range c.Values {
+		if c.Operator == Op_Null {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				ferr := newFieldError(key, v, "boolean", fmt.Sprintf("%s must be true or false", key))
+				return nil, &ferr
+			}
+			args = append(args, b)
+			continue
+		}
+
+		switch fd.Type {
+		case FieldType_UUID:
+			if uuid.Parse(v) == nil {
+				err := newFieldError(key, v, "uuid", fmt.Sprintf("%s must be a valid UUID", key))
+				return nil, &err
+			}
+			args = append(args, v)
+		case FieldType_Enum:
+			var found bool
+			for _, ev := range fd.Values {
+				if ev == v {
+					found = true
+					break
+				}
+			}
+			if !found {
+				err := newFieldError(key, v, "oneof",
+					fmt.Sprintf("%s must be one of [%s]", key, strings.Join(fd.Values, " ")))
+				return nil, &err
+			}
+			args = append(args, v)
+		case FieldType_Int:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				ferr := newFieldError(key, v, "number", fmt.Sprintf("%s must be a valid integer", key))
+				return nil, &ferr
+			}
+			args = append(args, n)
+		case FieldType_Bool:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				ferr := newFieldError(key, v, "boolean", fmt.Sprintf("%s must be true or false", key))
+				return nil, &ferr
+			}
+			args = append(args, b)
+		case FieldType_Time:
+			t, err := parseTime(v)
+			if err != nil {
+				ferr := newFieldError(key, v, "datetime",
+					fmt.Sprintf("%s must be a date in the format 2006-01-02 or RFC3339", key))
+				return nil, &ferr
+			}
+			args = append(args, t)
+		default:
+			args = append(args, v)
+		}
+	}
+
+	return args, nil
+}
+
+// parseTime parses the value as a RFC3339 timestamp or as a date.
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// escapeLike escapes the wildcard characters of a LIKE pattern.
+func escapeLike(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(v)
+}
+
+// newFieldError returns a field error for the request key.
+func newFieldError(key, value, tag, msg string) weberror.FieldError {
+	return weberror.FieldError{
+		Field:     key,
+		FormField: key,
+		Value:     value,
+		Tag:       tag,
+		Error:     msg,
+		Display:   msg,
+	}
+}
@@ -0,0 +1,185 @@
+package filter
+
+import (
+	"net/url"
+	"testing"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/huandu/go-sqlbuilder"
+)
+
+var testFields = Fields{
+	{Name: "id", Type: FieldType_UUID, Filterable: true, Sortable: true},
+	{Name: "name", Type: FieldType_String, Filterable: true, Sortable: true},
+	{Name: "status", Type: FieldType_Enum, Values: []string{"active", "disabled"}, Filterable: true},
+	{Name: "attempts", Type: FieldType_Int, Filterable: true, Sortable: true},
+	{Name: "created_at", Column: "p.created_at", Type: FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "secret", Type: FieldType_String},
+	{Name: "archived_at", Type: FieldType_Time, Filterable: true, Sortable: true, Nullable: true},
+}
+
+// TestParse validates the query values are parsed to a filter and compiled to where conditions.
+func TestParse(t *testing.T) {
+	var parseTests = []struct {
+		name     string
+		query    string
+		expected string
+		args     []interface{}
+	}{
+		{"Default operator",
+			"filter[status]=active",
+			"SELECT id FROM projects WHERE status = ?",
+			[]interface{}{"active"},
+		},
+		{"Contains",
+			"filter[name][contains]=50%25_moon",
+			"SELECT id FROM projects WHERE name ILIKE ?",
+			[]interface{}{`%50\%\_moon%`},
+		},
+		{"Starts with",
+			"filter[name][starts_with]=moon",
+			"SELECT id FROM projects WHERE name ILIKE ?",
+			[]interface{}{"moon%"},
+		},
+		{"In",
+			"filter[status][in]=active, disabled",
+			"SELECT id FROM projects WHERE status IN (?, ?)",
+			[]interface{}{"active", "disabled"},
+		},
+		{"Range",
+			"filter[attempts][gte]=2&filter[attempts][lt]=5",
+			"SELECT id FROM projects WHERE attempts >= ? AND attempts < ?",
+			[]interface{}{int64(2), int64(5)},
+		},
+		{"Null with column",
+			"filter[created_at][null]=false",
+			"SELECT id FROM projects WHERE p.created_at IS NOT NULL",
+			nil,
+		},
+		{"Ignore other params",
+			"order=name&limit=10",
+			"SELECT id FROM projects",
+			nil,
+		},
+	}
+
+	t.Log("Given the need to ensure filters are correctly parsed from query values.")
+	{
+		for i, tt := range parseTests {
+			t.Logf("\tTest: %d\tWhen running test: %s", i, tt.name)
+			{
+				vals, err := url.ParseQuery(tt.query)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tParse query failed.", tests.Failed)
+				}
+
+				f, err := Parse(vals, testFields)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tParse failed.", tests.Failed)
+				}
+
+				query := sqlbuilder.NewSelectBuilder()
+				query.Select("id")
+				query.From("projects")
+
+				err = f.Apply(query, testFields)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tApply failed.", tests.Failed)
+				}
+
+				sql, args := query.Build()
+				if diff := cmp.Diff(sql, tt.expected); diff != "" {
+					t.Fatalf("\t%s\tExpected result query to match. Diff:\n%s", tests.Failed, diff)
+				}
+
+				if diff := cmp.Diff(args, tt.args, cmpopts.EquateEmpty()); diff != "" {
+					t.Fatalf("\t%s\tExpected result args to match. Diff:\n%s", tests.Failed, diff)
+				}
+
+				t.Logf("\t%s\tParse ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestParseValidation ensures invalid filters return field validation errors.
+func TestParseValidation(t *testing.T) {
+	var validationTests = []struct {
+		name  string
+		query string
+		field string
+		tag   string
+	}{
+		{"Unknown field", "filter[password][eq]=x", "filter[password][eq]", "filter"},
+		{"Field not filterable", "filter[secret]=x", "filter[secret][eq]", "filter"},
+		{"Invalid key", "filter[name][contains][x]=moon", "filter[name][contains][x]", "filter"},
+		{"Unsupported operator", "filter[status][contains]=act", "filter[status][contains]", "operator"},
+		{"Invalid UUID", "filter[id]=1", "filter[id][eq]", "uuid"},
+		{"Invalid enum", "filter[status]=deleted", "filter[status][eq]", "oneof"},
+		{"Invalid int", "filter[attempts][gt]=many", "filter[attempts][gt]", "number"},
+		{"Invalid time", "filter[created_at][gt]=yesterday", "filter[created_at][gt]", "datetime"},
+		{"Invalid null", "filter[name][null]=maybe", "filter[name][null]", "boolean"},
+		{"Empty in", "filter[status][in]=,", "filter[status][in]", "required"},
+	}
+
+	t.Log("Given the need to ensure invalid filters are rejected.")
+	{
+		for i, tt := range validationTests {
+			t.Logf("\tTest: %d\tWhen running test: %s", i, tt.name)
+			{
+				vals, err := url.ParseQuery(tt.query)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tParse query failed.", tests.Failed)
+				}
+
+				_, err = Parse(vals, testFields)
+				webErr, ok := err.(*weberror.Error)
+				if !ok {
+					t.Logf("\t\tGot : %+v", err)
+					t.Fatalf("\t%s\tExpected field validation error.", tests.Failed)
+				} else if webErr.Err != ErrInvalidFilter || len(webErr.Fields) != 1 {
+					t.Logf("\t\tGot : %+v", webErr.Fields)
+					t.Fatalf("\t%s\tExpected one field error for invalid filter.", tests.Failed)
+				} else if webErr.Fields[0].Field != tt.field || webErr.Fields[0].Tag != tt.tag {
+					t.Logf("\t\tGot : %s %s", webErr.Fields[0].Field, webErr.Fields[0].Tag)
+					t.Logf("\t\tWant: %s %s", tt.field, tt.tag)
+					t.Fatalf("\t%s\tExpected field error to match.", tests.Failed)
+				}
+
+				t.Logf("\t%s\tParse ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestOrder validates order values are limited to the sortable fields.
+func TestOrder(t *testing.T) {
+	t.Log("Given the need to ensure results can only be ordered by sortable fields.")
+	{
+		res, err := testFields.Order([]string{"name", " created_at DESC ", ""})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tOrder failed.", tests.Failed)
+		} else if diff := cmp.Diff(res, []string{"name asc", "p.created_at desc"}); diff != "" {
+			t.Fatalf("\t%s\tExpected order to match. Diff:\n%s", tests.Failed, diff)
+		}
+		t.Logf("\t%s\tOrder ok.", tests.Success)
+
+		// Nullable fields are rejected even when they are marked as sortable.
+		for _, o := range []string{"status", "secret desc", "name; drop table projects", "name sideways", "archived_at"} {
+			_, err = testFields.Order([]string{o})
+			if _, ok := err.(*weberror.Error); !ok {
+				t.Logf("\t\tGot : %+v", err)
+				t.Fatalf("\t%s\tExpected field validation error for order %s.", tests.Failed, o)
+			}
+		}
+		t.Logf("\t%s\tOrder with invalid fields ok.", tests.Success)
+	}
+}
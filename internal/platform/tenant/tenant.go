@@ -0,0 +1,89 @@
+package tenant
+
+import (
+	"context"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// Role is the database role a scoped transaction switches to. The row level security policies only apply to this
+	// role since the owner of the tables and superusers bypass them.
+	Role = "saas_tenant"
+
+	// SettingAccountID is the name of the setting that contains the account ID of the claims, referenced by the row
+	// level security policies with current_setting.
+	SettingAccountID = "app.current_account_id"
+
+	// SettingUserID is the name of the setting that contains the user ID of the claims.
+	SettingUserID = "app.current_user_id"
+)
+
+// IsScoped determines if a transaction should be scoped for the claims. Requests without claims are internal and have
+// no ACL applied, matching the applyClaimsSelect methods of the repositories.
+func IsScoped(claims auth.Claims) bool {
+	return claims.Audience != "" || claims.Subject != ""
+}
+
+// Apply sets the current account and user of the transaction from the claims and switches to the tenant role so the
+// row level security policies are enforced for the remainder of the transaction. The settings are local to the
+// transaction and are reset when it is committed or rolled back.
+func Apply(ctx context.Context, tx *sqlx.Tx, claims auth.Claims) error {
+	q1 := `SELECT set_config($1, $2, true), set_config($3, $4, true)`
+	if _, err := tx.ExecContext(ctx, q1, SettingAccountID, claims.Audience, SettingUserID, claims.Subject); err != nil {
+		return errors.Wrapf(err, "query - %s", q1)
+	}
+
+	// The role name can't be a placeholder.
+	q2 := `SET LOCAL ROLE ` + Role
+	if _, err := tx.ExecContext(ctx, q2); err != nil {
+		return errors.Wrapf(err, "query - %s", q2)
+	}
+
+	return nil
+}
+
+// BeginTx starts a new transaction scoped to the account and user of the claims.
+func BeginTx(ctx context.Context, dbConn *sqlx.DB, claims auth.Claims) (*sqlx.Tx, error) {
+	tx, err := dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := Apply(ctx, tx, claims); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// Do executes fn with a transaction scoped to the account and user of the claims. The transaction is committed when
+// fn returns without an error. When the claims are empty, fn is executed with the database connection.
+func Do(ctx context.Context, dbConn *sqlx.DB, claims auth.Claims, fn func(conn sqlx.ExtContext) error) error {
+	if !IsScoped(claims) {
+		return fn(dbConn)
+	}
+
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.platform.tenant.Do")
+	defer span.Finish()
+
+	tx, err := BeginTx(ctx, dbConn, claims)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package tenant_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tenant"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/project"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/jmoiron/sqlx"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var test *tests.Test
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+	return m.Run()
+}
+
+// tenantData is the data created for a single tenant.
+type tenantData struct {
+	claims    auth.Claims
+	projectID string
+}
+
+// mockTenant creates a user with an account, a project and an account preference.
+func mockTenant(ctx context.Context, t *testing.T, now time.Time) tenantData {
+	ua, err := user_account.MockUserAccount(ctx, test.MasterDB, now, user_account.UserAccountRole_Admin)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tMockUserAccount failed.", tests.Failed)
+	}
+
+	projectID := uuid.NewRandom().String()
+	q := test.MasterDB.Rebind(`INSERT INTO projects (id, account_id, name, created_at) VALUES (?, ?, ?, ?)`)
+	if _, err := test.MasterDB.ExecContext(ctx, q, projectID, ua.AccountID, "Rocket Launch", now); err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tInsert project failed.", tests.Failed)
+	}
+
+	prefRepo := account_preference.NewRepository(test.MasterDB)
+	err = prefRepo.Set(ctx, auth.Claims{}, account_preference.AccountPreferenceSetRequest{
+		AccountID: ua.AccountID,
+		Name:      account_preference.AccountPreference_Datetime_Format,
+		Value:     account_preference.AccountPreference_Datetime_Format_Default,
+	}, now)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tSet account preference failed.", tests.Failed)
+	}
+
+	return tenantData{
+		claims:    auth.NewClaims(ua.UserID, ua.AccountID, []string{ua.AccountID}, []string{auth.RoleAdmin}, auth.ClaimPreferences{}, now, time.Hour),
+		projectID: projectID,
+	}
+}
+
+// TestRowLevelSecurity validates cross tenant reads fail even when the query doesn't filter by the claims.
+func TestRowLevelSecurity(t *testing.T) {
+	ctx := tests.Context()
+
+	now := time.Now().UTC()
+
+	t1 := mockTenant(ctx, t, now)
+	t2 := mockTenant(ctx, t, now)
+
+	// The queries are executed without any filter for the account of the claims.
+	var queryTests = []struct {
+		name  string
+		query string
+		own   interface{}
+		other interface{}
+	}{
+		{"Projects", `SELECT account_id FROM projects WHERE id = $1`, t1.projectID, t2.projectID},
+		{"UsersAccounts", `SELECT account_id FROM users_accounts WHERE user_id = $1`, t1.claims.Subject, t2.claims.Subject},
+		{"AccountPreferences", `SELECT account_id FROM account_preferences WHERE account_id = $1 LIMIT 1`, t1.claims.Audience, t2.claims.Audience},
+	}
+
+	t.Log("Given the need to isolate tenants with row level security.")
+	{
+		for i, tt := range queryTests {
+			t.Logf("\tTest: %d\tWhen querying %s.", i, tt.name)
+			{
+				var accountID string
+				err := tenant.Do(ctx, test.MasterDB, t1.claims, func(conn sqlx.ExtContext) error {
+					return conn.QueryRowxContext(ctx, tt.query, tt.own).Scan(&accountID)
+				})
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tRead own %s failed.", tests.Failed, tt.name)
+				} else if accountID != t1.claims.Audience {
+					t.Logf("\t\tGot : %s", accountID)
+					t.Logf("\t\tWant: %s", t1.claims.Audience)
+					t.Fatalf("\t%s\tRead own %s failed.", tests.Failed, tt.name)
+				}
+				t.Logf("\t%s\tRead own %s ok.", tests.Success, tt.name)
+
+				err = tenant.Do(ctx, test.MasterDB, t1.claims, func(conn sqlx.ExtContext) error {
+					return conn.QueryRowxContext(ctx, tt.query, tt.other).Scan(&accountID)
+				})
+				if errors.Cause(err) != sql.ErrNoRows {
+					t.Logf("\t\tGot : %+v", err)
+					t.Logf("\t\tWant: %+v", sql.ErrNoRows)
+					t.Fatalf("\t%s\tRead other tenant %s should fail.", tests.Failed, tt.name)
+				}
+				t.Logf("\t%s\tRead other tenant %s ok.", tests.Success, tt.name)
+
+				// Requests without claims are internal and are not scoped.
+				err = tenant.Do(ctx, test.MasterDB, auth.Claims{}, func(conn sqlx.ExtContext) error {
+					return conn.QueryRowxContext(ctx, tt.query, tt.other).Scan(&accountID)
+				})
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tRead %s without claims failed.", tests.Failed, tt.name)
+				}
+				t.Logf("\t%s\tRead %s without claims ok.", tests.Success, tt.name)
+			}
+		}
+
+		t.Log("\tWhen writing a project for another tenant.")
+		{
+			err := tenant.Do(ctx, test.MasterDB, t1.claims, func(conn sqlx.ExtContext) error {
+				q := `INSERT INTO projects (id, account_id, name, created_at) VALUES ($1, $2, $3, $4)`
+				_, err := conn.ExecContext(ctx, q, uuid.NewRandom().String(), t2.claims.Audience, "Moon Launch", now)
+				return err
+			})
+			if err == nil {
+				t.Fatalf("\t%s\tInsert project for other tenant should fail.", tests.Failed)
+			}
+			t.Logf("\t%s\tInsert project for other tenant ok.", tests.Success)
+
+			var updated int64
+			err = tenant.Do(ctx, test.MasterDB, t1.claims, func(conn sqlx.ExtContext) error {
+				res, err := conn.ExecContext(ctx, `UPDATE projects SET name = 'Moon Launch' WHERE id = $1`, t2.projectID)
+				if err != nil {
+					return err
+				}
+				updated, err = res.RowsAffected()
+				return err
+			})
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tUpdate project failed.", tests.Failed)
+			} else if updated != 0 {
+				t.Logf("\t\tGot : %d", updated)
+				t.Logf("\t\tWant: %d", 0)
+				t.Fatalf("\t%s\tUpdate project for other tenant should not match any rows.", tests.Failed)
+			}
+			t.Logf("\t%s\tUpdate project for other tenant ok.", tests.Success)
+		}
+
+		t.Log("\tWhen the repository has row level security enabled.")
+		{
+			repo := project.NewRepository(test.MasterDB)
+			repo.RowLevelSecurity = true
+
+			res, err := repo.Find(ctx, t1.claims, project.ProjectFindRequest{})
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tFind failed.", tests.Failed)
+			}
+			for _, p := range res {
+				if p.AccountID != t1.claims.Audience {
+					t.Logf("\t\tGot : %s", p.AccountID)
+					t.Logf("\t\tWant: %s", t1.claims.Audience)
+					t.Fatalf("\t%s\tFind should only return projects for the account.", tests.Failed)
+				}
+			}
+			if len(res) != 1 || res[0].ID != t1.projectID {
+				t.Logf("\t\tGot : %d", len(res))
+				t.Logf("\t\tWant: %d", 1)
+				t.Fatalf("\t%s\tFind failed.", tests.Failed)
+			}
+
+			total, err := repo.Count(ctx, t1.claims, project.ProjectFindRequest{})
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tCount failed.", tests.Failed)
+			} else if total != 1 {
+				t.Logf("\t\tGot : %d", total)
+				t.Logf("\t\tWant: %d", 1)
+				t.Fatalf("\t%s\tCount failed.", tests.Failed)
+			}
+
+			if _, err := repo.ReadByID(ctx, t1.claims, t2.projectID); errors.Cause(err) != project.ErrNotFound {
+				t.Logf("\t\tGot : %+v", err)
+				t.Logf("\t\tWant: %+v", project.ErrNotFound)
+				t.Fatalf("\t%s\tReadByID other tenant should fail.", tests.Failed)
+			}
+
+			t.Logf("\t%s\tRepository with row level security ok.", tests.Success)
+		}
+
+		t.Log("\tWhen the repositories write with row level security enabled.")
+		{
+			prjRepo := project.NewRepository(test.MasterDB)
+			prjRepo.RowLevelSecurity = true
+
+			prefRepo := account_preference.NewRepository(test.MasterDB)
+			prefRepo.RowLevelSecurity = true
+
+			name := "Moon Launch"
+			err := prjRepo.Update(ctx, t1.claims, project.ProjectUpdateRequest{ID: t1.projectID, Name: &name}, now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tUpdate own project failed.", tests.Failed)
+			}
+
+			err = prefRepo.Set(ctx, t1.claims, account_preference.AccountPreferenceSetRequest{
+				AccountID: t1.claims.Audience,
+				Name:      account_preference.AccountPreference_Datetime_Format,
+				Value:     account_preference.AccountPreference_Datetime_Format_Default,
+			}, now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tSet own account preference failed.", tests.Failed)
+			}
+			t.Logf("\t%s\tWrite own tenant ok.", tests.Success)
+
+			// Claims without an account are not checked by the ACL of the repositories, the writes for another
+			// tenant are only rejected by the policies.
+			noAccount := t1.claims
+			noAccount.Audience = ""
+
+			_, err = prjRepo.Create(ctx, noAccount, project.ProjectCreateRequest{
+				AccountID: t2.claims.Audience,
+				Name:      "Moon Launch",
+			}, now)
+			if err == nil {
+				t.Fatalf("\t%s\tCreate project for other tenant should fail.", tests.Failed)
+			}
+
+			err = prefRepo.Set(ctx, noAccount, account_preference.AccountPreferenceSetRequest{
+				AccountID: t2.claims.Audience,
+				Name:      account_preference.AccountPreference_Datetime_Format,
+				Value:     account_preference.AccountPreference_Datetime_Format_Default,
+			}, now)
+			if err == nil {
+				t.Fatalf("\t%s\tSet account preference for other tenant should fail.", tests.Failed)
+			}
+
+			err = prjRepo.Archive(ctx, noAccount, project.ProjectArchiveRequest{ID: t2.projectID}, now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tArchive project failed.", tests.Failed)
+			}
+
+			other, err := prjRepo.ReadByID(ctx, auth.Claims{}, t2.projectID)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tReadByID failed.", tests.Failed)
+			} else if other.ArchivedAt != nil {
+				t.Logf("\t\tGot : %v", other.ArchivedAt)
+				t.Fatalf("\t%s\tArchive project for other tenant should not match any rows.", tests.Failed)
+			}
+			t.Logf("\t%s\tWrite other tenant ok.", tests.Success)
+		}
+	}
+}
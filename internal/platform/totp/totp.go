@@ -0,0 +1,128 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Period is the number of seconds a code is valid for, the time step defined by RFC 6238.
+	Period = 30
+	// Digits is the number of digits in a generated code.
+	Digits = 6
+	// Skew is the number of time steps before and after the current one that are also
+	// accepted to allow for clock drift between the server and the authenticator app.
+	Skew = 1
+	// secretSize is the number of random bytes used for a secret, 160 bits as recommended
+	// by RFC 4226 for HMAC-SHA1.
+	secretSize = 20
+)
+
+var (
+	// ErrInvalidSecret occurs when the secret is not valid base32.
+	ErrInvalidSecret = errors.New("Invalid secret")
+
+	// b32 is the base32 encoding used by authenticator apps, padding is not included.
+	b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// GenerateSecret returns a new random base32 encoded secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// Step returns the time step for the supplied time.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// GenerateCode returns the code for the secret at the supplied time.
+func GenerateCode(secret string, t time.Time) (string, error) {
+	return generateCode(secret, Step(t))
+}
+
+// Validate checks the code against the secret for the time steps around the supplied time.
+// The matching time step is returned so callers can prevent the same code from being
+// used more than once.
+func Validate(secret, code string, t time.Time) (int64, bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false, nil
+	}
+
+	step := Step(t)
+	for i := -Skew; i <= Skew; i++ {
+		expected, err := generateCode(secret, step+int64(i))
+		if err != nil {
+			return 0, false, err
+		}
+
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step + int64(i), true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// ProvisioningUri returns the otpauth URI that is encoded in a QR code and scanned by
+// authenticator apps to enroll the secret.
+func ProvisioningUri(issuer, accountName, secret string) string {
+	label := url.PathEscape(accountName)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	if issuer != "" {
+		q.Set("issuer", issuer)
+	}
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprintf("%d", Digits))
+	q.Set("period", fmt.Sprintf("%d", Period))
+
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// generateCode implements HOTP as defined by RFC 4226 for the supplied counter.
+func generateCode(secret string, counter int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(strings.Replace(secret, " ", "", -1)))
+	if err != nil {
+		return "", errors.WithStack(ErrInvalidSecret)
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation.
+	offset := sum[len(sum)-1] & 0xf
+	value := int64(((int(sum[offset]) & 0x7f) << 24) |
+		((int(sum[offset+1] & 0xff)) << 16) |
+		((int(sum[offset+2] & 0xff)) << 8) |
+		(int(sum[offset+3]) & 0xff))
+
+	mod := int64(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
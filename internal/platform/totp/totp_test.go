@@ -0,0 +1,87 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+)
+
+// rfcSecret is the base32 encoding of the SHA1 seed "12345678901234567890" from RFC 6238 Appendix B.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestGenerateCode validates codes against the test vectors defined by RFC 6238 truncated to six digits.
+func TestGenerateCode(t *testing.T) {
+	var codeTests = []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	t.Log("Given the need to generate TOTP codes.")
+	{
+		for i, tt := range codeTests {
+			code, err := GenerateCode(rfcSecret, time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tTest %d - GenerateCode failed.", tests.Failed, i)
+			} else if code != tt.code {
+				t.Logf("\t\tGot : %s", code)
+				t.Logf("\t\tWant: %s", tt.code)
+				t.Fatalf("\t%s\tTest %d - GenerateCode failed.", tests.Failed, i)
+			}
+			t.Logf("\t%s\tTest %d - GenerateCode ok.", tests.Success, i)
+		}
+	}
+}
+
+// TestValidate validates codes are accepted within the allowed skew.
+func TestValidate(t *testing.T) {
+	t.Log("Given the need to validate TOTP codes.")
+	{
+		secret, err := GenerateSecret()
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGenerateSecret failed.", tests.Failed)
+		}
+
+		now := time.Now()
+
+		code, err := GenerateCode(secret, now.Add(time.Second*-Period))
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGenerateCode failed.", tests.Failed)
+		}
+
+		step, ok, err := Validate(secret, code, now)
+		if err != nil || !ok {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tValidate previous time step failed.", tests.Failed)
+		} else if step != Step(now)-1 {
+			t.Logf("\t\tGot : %d", step)
+			t.Logf("\t\tWant: %d", Step(now)-1)
+			t.Fatalf("\t%s\tValidate previous time step failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tValidate previous time step ok.", tests.Success)
+
+		_, ok, err = Validate(secret, code, now.Add(time.Second*Period*3))
+		if err != nil || ok {
+			t.Fatalf("\t%s\tValidate expired code failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tValidate expired code ok.", tests.Success)
+
+		uri := ProvisioningUri("SaaS Starter Kit", "gabi@geeksinthewoods.com", secret)
+		if !strings.HasPrefix(uri, "otpauth://totp/SaaS%20Starter%20Kit:gabi@geeksinthewoods.com?") || !strings.Contains(uri, "secret="+secret) {
+			t.Logf("\t\tGot : %s", uri)
+			t.Fatalf("\t%s\tProvisioningUri failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tProvisioningUri ok.", tests.Success)
+	}
+}
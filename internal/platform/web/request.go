@@ -10,8 +10,6 @@ import (
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/weberror"
 	"github.com/gorilla/schema"
 	"github.com/pkg/errors"
-	"github.com/xwb1989/sqlparser"
-	"github.com/xwb1989/sqlparser/dependency/querypb"
 )
 
 // Headers
@@ -29,6 +27,9 @@ const (
 	HeaderXRequestedWith      = "X-Requested-With"
 	HeaderServer              = "Server"
 	HeaderOrigin              = "Origin"
+	HeaderLink                = "Link"
+	HeaderXNextCursor         = "X-Next-Cursor"
+	HeaderXTotalCount         = "X-Total-Count"
 )
 
 // Decode reads the body of an HTTP request looking for a JSON document. The
@@ -54,46 +55,6 @@ func Decode(ctx context.Context, r *http.Request, val interface{}) error {
 	return nil
 }
 
-// ExtractWhereArgs extracts the sql args from where. This allows requests to accept sql queries for filters and
-// then replaces the raw values with placeholders. The resulting query will then be executed with bind vars.
-func ExtractWhereArgs(where string) (string, []interface{}, error) {
-	// Create a full select sql query.
-	query := "select `t` from test where " + where
-
-	// Parse the query.
-	stmt, err := sqlparser.Parse(query)
-	if err != nil {
-		return "", nil, errors.WithMessagef(err, "Failed to parse query - %s", where)
-	}
-
-	// Normalize changes the query statement to use bind values, and updates the bind vars to those values. The
-	// supplied prefix is used to generate the bind var names.
-	bindVars := make(map[string]*querypb.BindVariable)
-	sqlparser.Normalize(stmt, bindVars, "redacted")
-
-	// Loop through all the bind vars and append to the response args list.
-	var vals []interface{}
-	for _, bv := range bindVars {
-		if bv.Values != nil {
-			var l []interface{}
-			for _, v := range bv.Values {
-				l = append(l, string(v.Value))
-			}
-			vals = append(vals, l)
-		} else {
-			vals = append(vals, string(bv.Value))
-		}
-	}
-
-	// Update the original query to include the redacted values.
-	query = sqlparser.String(stmt)
-
-	// Parse out the updated where.
-	where = strings.Split(query, " where ")[1]
-
-	return where, vals, nil
-}
-
 func RequestIsJson(r *http.Request) bool {
 	if r == nil {
 		return false
@@ -154,13 +115,27 @@ func RequestScheme(r *http.Request) string {
 	return "http"
 }
 
-func RequestRealIP(r *http.Request) string {
-	if ip := r.Header.Get(HeaderXForwardedFor); ip != "" {
-		return strings.Split(ip, ", ")[0]
-	}
-	if ip := r.Header.Get(HeaderXRealIP); ip != "" {
-		return ip
+// RequestRealIP returns the IP address of the client for a service behind the number of trusted proxies. Each proxy
+// appends the address it received the request from to the X-Forwarded-For header, so the client address is the one
+// appended by the first trusted proxy. Addresses further to the left are set by the client and can't be trusted. The
+// remote address of the connection is used when there are no trusted proxies or the request didn't pass through all
+// of them.
+func RequestRealIP(r *http.Request, trustedHops int) string {
+	if trustedHops > 0 {
+		var ips []string
+		for _, h := range r.Header[HeaderXForwardedFor] {
+			for _, ip := range strings.Split(h, ",") {
+				ips = append(ips, strings.TrimSpace(ip))
+			}
+		}
+
+		if len(ips) >= trustedHops {
+			if ip := ips[len(ips)-trustedHops]; net.ParseIP(ip) != nil {
+				return ip
+			}
+		}
 	}
+
 	ra, _, _ := net.SplitHostPort(r.RemoteAddr)
 	return ra
 }
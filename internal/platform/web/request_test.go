@@ -1,63 +1,48 @@
 package web
 
 import (
-	"github.com/google/go-cmp/cmp"
+	"net/http/httptest"
 	"testing"
-)
 
-func TestExtractWhereArgs(t *testing.T) {
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+)
 
-	var queryTests = []struct {
-		where    string
-		redacted string
-		args     []interface{}
+// TestRequestRealIP validates the client IP is only read from the addresses appended by the trusted proxies.
+func TestRequestRealIP(t *testing.T) {
+	var ipTests = []struct {
+		name         string
+		forwardedFor []string
+		trustedHops  int
+		want         string
 	}{
-		{
-			"name = 'xxxx' or name = :test",
-			"name = :redacted1 or name = :test",
-			[]interface{}{"xxxx"},
-		},
-		{
-			"name = 'xxxx' or name is null",
-			"name = :redacted1 or name is null",
-			[]interface{}{"xxxx"},
-		},
-		{
-			"name = 'xxxx' or name in ('yyyy', 'zzzz')",
-			"name = :redacted1 or name in ::redacted2",
-			[]interface{}{"xxxx", []interface{}{"yyyy", "zzzz"}},
-		},
-		{
-			"id = 3232 or id in (2323, 3239, 483484)",
-			"id = :redacted1 or id in ::redacted2",
-			[]interface{}{"3232", []interface{}{"2323", "3239", "483484"}},
-		},
+		{"no proxy ignores header", []string{"203.0.113.9"}, 0, "192.0.2.1"},
+		{"one proxy", []string{"203.0.113.9"}, 1, "203.0.113.9"},
+		{"one proxy with spoofed address", []string{"198.51.100.7, 203.0.113.9"}, 1, "203.0.113.9"},
+		{"two proxies", []string{"198.51.100.7, 203.0.113.9, 10.0.0.2"}, 2, "203.0.113.9"},
+		{"multiple headers", []string{"198.51.100.7", "203.0.113.9"}, 1, "203.0.113.9"},
+		{"missing header", nil, 1, "192.0.2.1"},
+		{"fewer addresses than proxies", []string{"203.0.113.9"}, 2, "192.0.2.1"},
+		{"invalid address", []string{"unknown"}, 1, "192.0.2.1"},
 	}
 
-	t.Log("Given the need to ensure values are correctly extracted from a where query string.")
+	t.Log("Given the need to determine the IP address of the client.")
 	{
-		for i, tt := range queryTests {
-			t.Logf("\tTest: %d\tWhen running test: #%d", i, i)
+		for i, tt := range ipTests {
+			t.Logf("\tTest: %d\tWhen %s", i, tt.name)
 			{
-				res, args, err := ExtractWhereArgs(tt.where)
-				if err != nil {
-					t.Log("\t\tGot :", err)
-					t.Fatalf("\t\tExtract failed.")
+				r := httptest.NewRequest("GET", "/", nil)
+				r.RemoteAddr = "192.0.2.1:1234"
+				for _, h := range tt.forwardedFor {
+					r.Header.Add(HeaderXForwardedFor, h)
 				}
 
-				if res != tt.redacted {
-					t.Logf("\t\tGot : %+v", res)
-					t.Logf("\t\tWant: %+v", tt.redacted)
-					t.Fatalf("\t\tResulting where does not match expected.")
+				got := RequestRealIP(r, tt.trustedHops)
+				if got != tt.want {
+					t.Logf("\t\tGot : %v", got)
+					t.Logf("\t\tWant: %v", tt.want)
+					t.Fatalf("\t%s\tRequestRealIP failed.", tests.Failed)
 				}
-
-				if diff := cmp.Diff(tt.args, args); diff != "" {
-					t.Logf("\t\tGot : %+v", args)
-					t.Logf("\t\tWant: %+v", tt.args)
-					t.Fatalf("\t\tResulting args does not match expected. Diff:\n%s", diff)
-				}
-
-				t.Logf("\t\tOk.")
+				t.Logf("\t%s\tRequestRealIP ok.", tests.Success)
 			}
 		}
 	}
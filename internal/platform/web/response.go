@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
@@ -27,6 +30,7 @@ const (
 	MIMETextPlain                  = "text/plain"
 	MIMETextPlainCharsetUTF8       = MIMETextPlain + "; " + charsetUTF8
 	MIMEOctetStream                = "application/octet-stream"
+	MIMEApplicationZip             = "application/zip"
 )
 
 // RespondJsonError sends an error formatted as JSON response back to the client.
@@ -49,6 +53,8 @@ func RespondJsonError(ctx context.Context, w http.ResponseWriter, er error) erro
 
 	v.StatusCode = webErr.Status
 
+	setErrorHeader(w, webErr)
+
 	return RespondJson(ctx, w, webErr.Response(ctx, false), webErr.Status)
 }
 
@@ -119,6 +125,8 @@ func RespondErrorStatus(ctx context.Context, w http.ResponseWriter, er error, st
 	webErr := weberror.NewError(ctx, er, v.StatusCode).(*weberror.Error)
 	v.StatusCode = webErr.Status
 
+	setErrorHeader(w, webErr)
+
 	respErr := webErr.Response(ctx, false).String()
 
 	switch webcontext.ContextEnv(ctx) {
@@ -191,6 +199,8 @@ func RenderError(ctx context.Context, w http.ResponseWriter, r *http.Request, er
 	}
 	v.StatusCode = webErr.Status
 
+	setErrorHeader(w, webErr)
+
 	if RequestIsImage(r) {
 		return nil
 	}
@@ -207,6 +217,15 @@ func RenderError(ctx context.Context, w http.ResponseWriter, r *http.Request, er
 	return renderer.Render(ctx, w, r, templateLayoutName, templateContentName, contentType, webErr.Status, data)
 }
 
+// setErrorHeader copies any headers defined for the error to the response.
+func setErrorHeader(w http.ResponseWriter, webErr *weberror.Error) {
+	for k, vals := range webErr.Header {
+		for _, val := range vals {
+			w.Header().Add(k, val)
+		}
+	}
+}
+
 // Static registers a new route with path prefix to serve static files from the
 // provided root directory. All errors will result in 404 File Not Found.
 func Static(rootDir, prefix string) Handler {
@@ -261,6 +280,68 @@ func StaticHandler(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	return nil
 }
 
+// PageResponse is the response of the list endpoints. The next cursor is only set when there are more results, it's
+// passed as the cursor param to request the next page.
+type PageResponse struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty" example:"eyJvIjpbImlkIGFzYyJdLCJ2IjpbIjk4NWYxNzQ2Il19"`
+}
+
+// NewPageResponse returns the response for a page of results. An empty page is returned as an empty list.
+func NewPageResponse(data interface{}, nextCursor string) PageResponse {
+	if v := reflect.ValueOf(data); v.Kind() == reflect.Slice && v.IsNil() {
+		data = reflect.MakeSlice(v.Type(), 0, 0).Interface()
+	}
+	return PageResponse{Data: data, NextCursor: nextCursor}
+}
+
+// SetPageHeaders sets the headers of a list response used by clients to page through the results. When there are more
+// results, the Link header includes the next page using the cursor when set, otherwise the offset is advanced by the
+// limit. The total is only set when provided since counting requires an additional query.
+func SetPageHeaders(w http.ResponseWriter, r *http.Request, more bool, limit, offset *uint, nextCursor string, total *int) {
+	link := func(q url.Values, rel string) string {
+		u := url.URL{
+			Scheme:   RequestScheme(r),
+			Host:     r.Host,
+			Path:     r.URL.Path,
+			RawQuery: q.Encode(),
+		}
+		return fmt.Sprintf("<%s>; rel=\"%s\"", u.String(), rel)
+	}
+
+	var links []string
+	if more && limit != nil {
+		q := r.URL.Query()
+		if nextCursor != "" {
+			q.Set("cursor", nextCursor)
+			q.Del("offset")
+			w.Header().Set(HeaderXNextCursor, nextCursor)
+		} else {
+			var o uint
+			if offset != nil {
+				o = *offset
+			}
+			q.Set("offset", strconv.Itoa(int(o+*limit)))
+			q.Del("cursor")
+		}
+		links = append(links, link(q, "next"))
+	}
+
+	if q := r.URL.Query(); q.Get("cursor") != "" || q.Get("offset") != "" {
+		q.Del("cursor")
+		q.Del("offset")
+		links = append(links, link(q, "first"))
+	}
+
+	if len(links) > 0 {
+		w.Header().Set(HeaderLink, strings.Join(links, ", "))
+	}
+
+	if total != nil {
+		w.Header().Set(HeaderXTotalCount, strconv.Itoa(*total))
+	}
+}
+
 // Redirect ensures the session is flushed to the browser before the redirect is issued.
 func Redirect(ctx context.Context, w http.ResponseWriter, r *http.Request, url string, code int) error {
 	if sess := webcontext.ContextSession(ctx); sess != nil {
@@ -124,6 +124,13 @@ func NewTemplate(templateFuncs template.FuncMap) *Template {
 			}
 			return claims.HasRole(roles...)
 		},
+		"HasPermission": func(ctx context.Context, perms ...string) bool {
+			claims, err := auth.ClaimsFromContext(ctx)
+			if err != nil {
+				return false
+			}
+			return claims.HasPermission(perms...)
+		},
 
 		"CmpString": func(str1 string, str2Ptr *string) bool {
 			var str2 string
@@ -26,6 +26,10 @@ type App struct {
 	log      *log.Logger
 	env      webcontext.Env
 	mw       []Middleware
+
+	// TrustedProxyHops is the number of proxies in front of the service, ie a load balancer, that append the address
+	// of the client to the X-Forwarded-For header. When zero, the remote address of the connection is the client.
+	TrustedProxyHops int
 }
 
 // NewApp creates an App value that handle a set of routes for the application.
@@ -69,7 +73,8 @@ func (a *App) Handle(verb, path string, handler Handler, mw ...Middleware) {
 		v := webcontext.Values{
 			Now:       time.Now(),
 			Env:       a.env,
-			RequestIP: RequestRealIP(r),
+			RequestIP: RequestRealIP(r, a.TrustedProxyHops),
+			UserAgent: r.UserAgent(),
 		}
 		ctx := context.WithValue(r.Context(), webcontext.KeyValues, &v)
 
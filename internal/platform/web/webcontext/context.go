@@ -23,6 +23,7 @@ type Values struct {
 	StatusCode int
 	Env        Env
 	RequestIP  string
+	UserAgent  string
 }
 
 func ContextValues(ctx context.Context) (*Values, error) {
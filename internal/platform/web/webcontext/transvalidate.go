@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"strings"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
 	"github.com/go-playground/locales/en"
 	"github.com/go-playground/locales/fr"
 	"github.com/go-playground/locales/id"
@@ -162,6 +163,15 @@ func newValidator() *validator.Validate {
 	}
 	v.RegisterValidationCtx("unique", fctx)
 
+	// Custom validation functions for the role and permission tags that ensure the values can be used for
+	// authorization.
+	v.RegisterValidation("role", func(fl validator.FieldLevel) bool {
+		return auth.ValidRoleName(fl.Field().String())
+	})
+	v.RegisterValidation("permission", func(fl validator.FieldLevel) bool {
+		return auth.ValidPermission(fl.Field().String())
+	})
+
 	return v
 }
 
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -18,6 +20,7 @@ type Error struct {
 	Fields            []FieldError
 	Cause             error
 	Message           string
+	Header            http.Header
 	isValidationError bool
 }
 
@@ -65,7 +68,7 @@ func NewError(ctx context.Context, er error, status int) error {
 		cause = er
 	}
 
-	return &Error{er, status, nil, cause, "", false}
+	return &Error{er, status, nil, cause, "", nil, false}
 }
 
 // Error implements the error interface. It uses the default message of the
@@ -149,6 +152,28 @@ func WithMessage(ctx context.Context, er error, msg string) error {
 	return weberr
 }
 
+// WithHeader sets a header that should be included in the response for the error.
+func WithHeader(ctx context.Context, er error, key, value string) error {
+	weberr := NewError(ctx, er, 0).(*Error)
+	if weberr.Header == nil {
+		weberr.Header = make(http.Header)
+	}
+	weberr.Header.Set(key, value)
+	return weberr
+}
+
+// NewRetryAfterError wraps a provided error with the HTTP status code 429 Too Many Requests and sets the Retry-After
+// header to the number of seconds the client should wait before making another request.
+func NewRetryAfterError(ctx context.Context, er error, retryAfter time.Duration, msg string) error {
+	secs := int64(retryAfter / time.Second)
+	if retryAfter%time.Second > 0 {
+		secs++
+	}
+
+	er = NewErrorMessage(ctx, er, http.StatusTooManyRequests, msg)
+	return WithHeader(ctx, er, "Retry-After", strconv.FormatInt(secs, 10))
+}
+
 // SessionFlashError
 func SessionFlashError(ctx context.Context, er error) {
 
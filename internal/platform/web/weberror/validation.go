@@ -87,6 +87,19 @@ func NewValidationError(ctx context.Context, err error) (error, bool) {
 	}, true
 }
 
+// NewFieldsError returns a validation error for field errors that were detected outside of the validator, such as
+// the conditions of a filter.
+func NewFieldsError(err error, fields ...FieldError) error {
+	return &Error{
+		Err:               err,
+		Status:            http.StatusBadRequest,
+		Fields:            fields,
+		Cause:             err,
+		Message:           "Field validation error",
+		isValidationError: true,
+	}
+}
+
 func FormField(namespace string) string {
 	if !strings.Contains(namespace, ".") {
 		return namespace
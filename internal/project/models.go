@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"database/sql/driver"
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
@@ -15,6 +17,13 @@ import (
 // Repository defines the required dependencies for Project.
 type Repository struct {
 	DbConn *sqlx.DB
+
+	// Entitlements is used to enforce the project limit of the plan when set.
+	Entitlements entitlement.Checker
+
+	// RowLevelSecurity scopes the queries to the account of the claims with a transaction so the row level security
+	// policies are enforced in addition to the ACL applied to the queries.
+	RowLevelSecurity bool
 }
 
 // NewRepository creates a new Repository that defines dependencies for Project.
@@ -122,14 +131,25 @@ type ProjectDeleteRequest struct {
 // ProjectFindRequest defines the possible options to search for projects. By default
 // archived project will be excluded from response.
 type ProjectFindRequest struct {
-	Where           string        `json:"where" example:"name = ? and status = ?"`
-	Args            []interface{} `json:"args" swaggertype:"array,string" example:"Moon Launch,active"`
+	Filter          filter.Filter `json:"filter,omitempty"`
 	Order           []string      `json:"order" example:"created_at desc"`
 	Limit           *uint         `json:"limit" example:"10"`
 	Offset          *uint         `json:"offset" example:"20"`
+	Cursor          string        `json:"cursor,omitempty" example:"eyJvIjpbImlkIGFzYyJdLCJ2IjpbIjk4NWYxNzQ2Il19"`
 	IncludeArchived bool          `json:"include-archived" example:"false"`
 }
 
+// ProjectFindFields defines the fields of project that can be used to filter and sort a find request.
+var ProjectFindFields = filter.Fields{
+	{Name: "id", Type: filter.FieldType_UUID, Filterable: true, Sortable: true, Key: true},
+	{Name: "account_id", Type: filter.FieldType_UUID, Filterable: true},
+	{Name: "name", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "status", Type: filter.FieldType_Enum, Values: filter.EnumValues(ProjectStatus_ValuesInterface()), Filterable: true, Sortable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "updated_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "archived_at", Type: filter.FieldType_Time, Filterable: true, Nullable: true},
+}
+
 // ProjectStatus represents the status of project.
 type ProjectStatus string
 
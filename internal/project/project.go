@@ -5,8 +5,12 @@ import (
 	"database/sql"
 	"time"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tenant"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/webhook"
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/jmoiron/sqlx"
 	"github.com/pborman/uuid"
@@ -67,8 +71,8 @@ func (repo *Repository) CanModifyProject(ctx context.Context, claims auth.Claims
 		return err
 	}
 
-	// Admin users can update projects they have access to.
-	if !claims.HasRole(auth.RoleAdmin) {
+	// Users with the project:write permission can update projects they have access to.
+	if !claims.HasPermission(auth.PermissionProjectWrite) {
 		return errors.WithStack(ErrForbidden)
 	}
 
@@ -88,6 +92,23 @@ func applyClaimsSelect(ctx context.Context, claims auth.Claims, query *sqlbuilde
 	return nil
 }
 
+// conn executes fn with the database connection. When row level security is enabled, fn is executed with a
+// transaction scoped to the account of the claims.
+func (repo *Repository) conn(ctx context.Context, claims auth.Claims, fn func(conn sqlx.ExtContext) error) error {
+	if !repo.RowLevelSecurity {
+		return fn(repo.DbConn)
+	}
+	return tenant.Do(ctx, repo.DbConn, claims, fn)
+}
+
+// scopeTx scopes an existing transaction to the account of the claims when row level security is enabled.
+func (repo *Repository) scopeTx(ctx context.Context, claims auth.Claims, tx *sqlx.Tx) error {
+	if !repo.RowLevelSecurity || !tenant.IsScoped(claims) {
+		return nil
+	}
+	return tenant.Apply(ctx, tx, claims)
+}
+
 // projectMapColumns is the list of columns needed for find.
 var projectMapColumns = "id,account_id,name,status,created_at,updated_at,archived_at"
 
@@ -100,13 +121,18 @@ func selectQuery() *sqlbuilder.SelectBuilder {
 }
 
 // findRequestQuery generates the select query for the given find request.
-// TODO: Need to figure out why can't parse the args when appending the where
-// 			to the query.
-func findRequestQuery(req ProjectFindRequest) (*sqlbuilder.SelectBuilder, []interface{}) {
+func findRequestQuery(req ProjectFindRequest) (*sqlbuilder.SelectBuilder, error) {
 	query := selectQuery()
 
-	if req.Where != "" {
-		query.Where(query.And(req.Where))
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, ProjectFindFields); err != nil {
+			return nil, err
+		}
+	}
+	if req.Cursor != "" {
+		if err := ProjectFindFields.ApplyCursor(query, req.Order, req.Cursor); err != nil {
+			return nil, err
+		}
 	}
 
 	if len(req.Order) > 0 {
@@ -121,17 +147,72 @@ func findRequestQuery(req ProjectFindRequest) (*sqlbuilder.SelectBuilder, []inte
 		query.Offset(int(*req.Offset))
 	}
 
-	return query, req.Args
+	return query, nil
 }
 
 // Find gets all the projects from the database based on the request params.
 func (repo *Repository) Find(ctx context.Context, claims auth.Claims, req ProjectFindRequest) (Projects, error) {
-	query, args := findRequestQuery(req)
-	return find(ctx, claims, repo.DbConn, query, args, req.IncludeArchived)
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.find(ctx, claims, query, []interface{}{}, req.IncludeArchived)
+}
+
+// Count gets the total number of projects from the database that match the request params. The order, limit,
+// offset and cursor of the request are ignored.
+func (repo *Repository) Count(ctx context.Context, claims auth.Claims, req ProjectFindRequest) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.project.Count")
+	defer span.Finish()
+
+	req.Order, req.Limit, req.Offset, req.Cursor = nil, nil, nil, ""
+
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return 0, err
+	}
+	query.Select("count(*)")
+
+	if !req.IncludeArchived {
+		query.Where(query.IsNull("archived_at"))
+	}
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err = applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return 0, err
+	}
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var total int
+	err = repo.conn(ctx, claims, func(conn sqlx.ExtContext) error {
+		return conn.QueryRowxContext(ctx, queryStr, args...).Scan(&total)
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "count projects failed")
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// find executes find with a transaction scoped to the claims when row level security is enabled.
+func (repo *Repository) find(ctx context.Context, claims auth.Claims, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) (Projects, error) {
+	var res Projects
+	err := repo.conn(ctx, claims, func(conn sqlx.ExtContext) error {
+		var err error
+		res, err = find(ctx, claims, conn, query, args, includedArchived)
+		return err
+	})
+	return res, err
 }
 
 // find internal method for getting all the projects from the database using a select query.
-func find(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) (Projects, error) {
+func find(ctx context.Context, claims auth.Claims, dbConn sqlx.ExtContext, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) (Projects, error) {
 	span, ctx := tracer.StartSpanFromContext(ctx, "internal.project.Find")
 	defer span.Finish()
 
@@ -157,6 +238,7 @@ func find(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB, query *sqlbu
 		err = errors.WithMessage(err, "find projects failed")
 		return nil, err
 	}
+	defer rows.Close()
 
 	// Iterate over each row.
 	resp := []*Project{}
@@ -201,7 +283,7 @@ func (repo *Repository) Read(ctx context.Context, claims auth.Claims, req Projec
 	query := sqlbuilder.NewSelectBuilder()
 	query.Where(query.Equal("id", req.ID))
 
-	res, err := find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
+	res, err := repo.find(ctx, claims, query, []interface{}{}, req.IncludeArchived)
 	if err != nil {
 		return nil, err
 	} else if res == nil || len(res) == 0 {
@@ -218,8 +300,8 @@ func (repo *Repository) Create(ctx context.Context, claims auth.Claims, req Proj
 	span, ctx := tracer.StartSpanFromContext(ctx, "internal.project.Create")
 	defer span.Finish()
 	if claims.Audience != "" {
-		// Admin users can update projects they have access to.
-		if !claims.HasRole(auth.RoleAdmin) {
+		// Users with the project:write permission can create projects for their account.
+		if !claims.HasPermission(auth.PermissionProjectWrite) {
 			return nil, errors.WithStack(ErrForbidden)
 		}
 
@@ -289,13 +371,56 @@ func (repo *Repository) Create(ctx context.Context, claims auth.Claims, req Proj
 		m.ArchivedAt,
 	)
 
-	// Execute the query with the provided context.
+	// Execute the query with the provided context. The project is created, recorded and published in one
+	// transaction so a project is never created without its audit event and webhook deliveries.
 	sql, args := query.Build()
 	sql = repo.DbConn.Rebind(sql)
-	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	create := func(tx *sqlx.Tx) error {
+		err := repo.scopeTx(ctx, claims, tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessage(err, "create project failed")
+			return err
+		}
+
+		_, err = audit.Record(ctx, tx, claims, audit.AuditEventRecordRequest{
+			AccountID:  m.AccountID,
+			EntityType: audit.EntityType_Project,
+			EntityID:   m.ID,
+			Action:     audit.Action_Create,
+			After:      m,
+		}, now)
+		if err != nil {
+			return err
+		}
+
+		return webhook.Publish(ctx, tx, m.AccountID, webhook.EventType_ProjectCreated, m, now)
+	}
+
+	if repo.Entitlements != nil {
+		// Ensure the plan of the account allows another project. The project is inserted while the limit is
+		// reserved so concurrent requests can't exceed it.
+		err = repo.Entitlements.Reserve(ctx, req.AccountID, entitlement.Limit_Projects, 1, now, create)
+	} else {
+		var tx *sqlx.Tx
+		tx, err = repo.DbConn.BeginTxx(ctx, nil)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		err = create(tx)
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = errors.WithStack(tx.Commit())
+		}
+	}
 	if err != nil {
-		err = errors.Wrapf(err, "query - %s", query.String())
-		err = errors.WithMessage(err, "create project failed")
 		return nil, err
 	}
 
@@ -320,6 +445,12 @@ func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req Proj
 		return err
 	}
 
+	// Load the current project so the change can be recorded.
+	before, err := repo.Read(ctx, auth.Claims{}, ProjectReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
 	// If now empty set it to the current time.
 	if now.IsZero() {
 		now = time.Now()
@@ -354,13 +485,39 @@ func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req Proj
 	// Execute the query with the provided context.
 	sql, args := query.Build()
 	sql = repo.DbConn.Rebind(sql)
-	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	err = repo.conn(ctx, claims, func(conn sqlx.ExtContext) error {
+		_, err := conn.ExecContext(ctx, sql, args...)
+		return err
+	})
 	if err != nil {
 		err = errors.Wrapf(err, "query - %s", query.String())
 		err = errors.WithMessagef(err, "update project %s failed", req.ID)
 		return err
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  before.AccountID,
+		EntityType: audit.EntityType_Project,
+		EntityID:   req.ID,
+		Action:     audit.Action_Update,
+		Before:     before,
+		After:      req,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	// Load the updated project so it can be included in the event.
+	after, err := repo.Read(ctx, auth.Claims{}, ProjectReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
+	err = webhook.Publish(ctx, repo.DbConn, after.AccountID, webhook.EventType_ProjectUpdated, after, now)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -382,6 +539,12 @@ func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req Pro
 		return err
 	}
 
+	// Load the current project so the change can be recorded.
+	before, err := repo.Read(ctx, auth.Claims{}, ProjectReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
 	// If now empty set it to the current time.
 	if now.IsZero() {
 		now = time.Now()
@@ -403,13 +566,39 @@ func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req Pro
 	// Execute the query with the provided context.
 	sql, args := query.Build()
 	sql = repo.DbConn.Rebind(sql)
-	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	err = repo.conn(ctx, claims, func(conn sqlx.ExtContext) error {
+		_, err := conn.ExecContext(ctx, sql, args...)
+		return err
+	})
 	if err != nil {
 		err = errors.Wrapf(err, "query - %s", query.String())
 		err = errors.WithMessagef(err, "archive project %s failed", req.ID)
 		return err
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  before.AccountID,
+		EntityType: audit.EntityType_Project,
+		EntityID:   req.ID,
+		Action:     audit.Action_Archive,
+		Before:     before,
+		After:      map[string]interface{}{"archived_at": now},
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	// Load the archived project so it can be included in the event.
+	after, err := repo.Read(ctx, auth.Claims{}, ProjectReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
+	err = webhook.Publish(ctx, repo.DbConn, after.AccountID, webhook.EventType_ProjectArchived, after, now)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -431,6 +620,12 @@ func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req Proj
 		return err
 	}
 
+	// Load the current project so the change can be recorded.
+	before, err := repo.Read(ctx, auth.Claims{}, ProjectReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
 	// Build the delete SQL statement.
 	query := sqlbuilder.NewDeleteBuilder()
 	query.DeleteFrom(projectTableName)
@@ -438,12 +633,31 @@ func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req Proj
 	// Execute the query with the provided context.
 	sql, args := query.Build()
 	sql = repo.DbConn.Rebind(sql)
-	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	err = repo.conn(ctx, claims, func(conn sqlx.ExtContext) error {
+		_, err := conn.ExecContext(ctx, sql, args...)
+		return err
+	})
 	if err != nil {
 		err = errors.Wrapf(err, "query - %s", query.String())
 		err = errors.WithMessagef(err, "delete project %s failed", req.ID)
 		return err
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  before.AccountID,
+		EntityType: audit.EntityType_Project,
+		EntityID:   req.ID,
+		Action:     audit.Action_Delete,
+		Before:     before,
+	}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = webhook.Publish(ctx, repo.DbConn, before.AccountID, webhook.EventType_ProjectDeleted, before, time.Now())
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
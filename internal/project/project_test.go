@@ -1,13 +1,21 @@
 package project
 
 import (
+	"context"
 	"os"
 	"testing"
+	"time"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
 	"github.com/google/go-cmp/cmp"
 	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -38,10 +46,9 @@ func TestFindRequestQuery(t *testing.T) {
 	)
 
 	req := ProjectFindRequest{
-		Where: "field1 = ? or field2 = ?",
-		Args: []interface{}{
-			"lee brown",
-			"103 East Main St.",
+		Filter: filter.Filter{
+			{Field: "name", Operator: filter.Op_Contains, Values: []string{"moon"}},
+			{Field: "status", Operator: filter.Op_In, Values: []string{"active", "disabled"}},
 		},
 
 		Order: []string{
@@ -53,13 +60,18 @@ func TestFindRequestQuery(t *testing.T) {
 		Offset: &offset,
 	}
 
-	expected := "SELECT " + projectMapColumns + " FROM " + projectTableName + " WHERE (field1 = ? or field2 = ?) ORDER BY id asc, created_at desc LIMIT 12 OFFSET 34"
-	res, args := findRequestQuery(req)
+	expected := "SELECT " + projectMapColumns + " FROM " + projectTableName + " WHERE name ILIKE ? AND status IN (?, ?) ORDER BY id asc, created_at desc LIMIT 12 OFFSET 34"
+	res, err := findRequestQuery(req)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tFind request query failed.", tests.Failed)
+	}
 	if diff := cmp.Diff(res.String(), expected); diff != "" {
 		t.Fatalf("\t%s\tExpected result query to match. Diff:\n%s", tests.Failed, diff)
 	}
 
-	if diff := cmp.Diff(args, req.Args); diff != "" {
+	_, args := res.Build()
+	if diff := cmp.Diff(args, []interface{}{"%moon%", "active", "disabled"}); diff != "" {
 		t.Fatalf("\t%s\tExpected result query to match. Diff:\n%s", tests.Failed, diff)
 	}
 
@@ -107,3 +119,71 @@ func TestApplyClaimsSelect(t *testing.T) {
 	}
 
 }
+
+// rollbackChecker executes fn with a transaction that is always rolled back, as if the commit failed.
+type rollbackChecker struct {
+	dbConn *sqlx.DB
+}
+
+// Reserve implements entitlement.Checker.
+func (c rollbackChecker) Reserve(ctx context.Context, accountID string, limit entitlement.Limit, n int, now time.Time, fn func(tx *sqlx.Tx) error) error {
+	tx, err := c.dbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return errors.New("commit failed")
+}
+
+// TestCreateRollback validates the project, the audit event and the webhook deliveries are created in one transaction.
+func TestCreateRollback(t *testing.T) {
+	defer tests.Recover(t)
+
+	ctx := tests.Context()
+
+	now := time.Date(2019, time.September, 1, 0, 0, 0, 0, time.UTC)
+
+	acc, err := account.MockAccount(ctx, test.MasterDB, now)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tCreate account failed.", tests.Failed)
+	}
+
+	t.Log("Given the need to create a project in one transaction.")
+	{
+		r := NewRepository(test.MasterDB)
+		r.Entitlements = rollbackChecker{dbConn: test.MasterDB}
+
+		_, err = r.Create(ctx, auth.Claims{}, ProjectCreateRequest{AccountID: acc.ID, Name: "rollback"}, now)
+		if err == nil {
+			t.Fatalf("\t%s\tExpected the create to fail.", tests.Failed)
+		}
+
+		res, err := repo.Find(ctx, auth.Claims{}, ProjectFindRequest{
+			Filter: filter.Filter{
+				{Field: "account_id", Operator: filter.Op_Eq, Values: []string{acc.ID}},
+			},
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tFind failed.", tests.Failed)
+		} else if len(res) != 0 {
+			t.Fatalf("\t%s\tExpected no projects, got %d.", tests.Failed, len(res))
+		}
+
+		var cnt int
+		q := `SELECT count(*) FROM audit_events WHERE account_id = $1 AND entity_type = $2`
+		if err := test.MasterDB.QueryRowContext(ctx, q, acc.ID, string(audit.EntityType_Project)).Scan(&cnt); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCount audit events failed.", tests.Failed)
+		} else if cnt != 0 {
+			t.Fatalf("\t%s\tExpected no audit events, got %d.", tests.Failed, cnt)
+		}
+		t.Logf("\t%s\tCreate rolled back ok.", tests.Success)
+	}
+}
@@ -46,12 +46,30 @@ func (r ProjectRoute) UserResetPassword(resetHash string) string {
 	return u.String()
 }
 
+func (r ProjectRoute) UserVerifyEmail(verifyHash string) string {
+	u := r.webAppUrl
+	u.Path = "/user/verify-email/" + verifyHash
+	return u.String()
+}
+
 func (r ProjectRoute) UserInviteAccept(inviteHash string) string {
 	u := r.webAppUrl
 	u.Path = "/users/invite/" + inviteHash
 	return u.String()
 }
 
+func (r ProjectRoute) AccountTransferAccept(transferHash string) string {
+	u := r.webAppUrl
+	u.Path = "/account/transfer/" + transferHash
+	return u.String()
+}
+
+func (r ProjectRoute) AccountRestore(restoreHash string) string {
+	u := r.webAppUrl
+	u.Path = "/restore/" + restoreHash
+	return u.String()
+}
+
 func (r ProjectRoute) ApiDocs() string {
 	u := r.webApiUrl
 	u.Path = "/docs"
@@ -0,0 +1,175 @@
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/xml"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SAML namespaces, bindings and formats.
+const (
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+
+	bindingHttpRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	bindingHttpPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	nameIDFormatEmail = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+
+	statusSuccess        = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	subjectConfirmBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+)
+
+// ErrInvalidMetadata occurs when the metadata of an identity provider can not be used.
+var ErrInvalidMetadata = errors.New("Invalid identity provider metadata")
+
+// entityDescriptor is the metadata of a SAML entity.
+type entityDescriptor struct {
+	XMLName          xml.Name          `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID         string            `xml:"entityID,attr"`
+	IDPSSODescriptor *idpSSODescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata IDPSSODescriptor"`
+	SPSSODescriptor  *spSSODescriptor  `xml:"urn:oasis:names:tc:SAML:2.0:metadata SPSSODescriptor"`
+}
+
+// entitiesDescriptor is a group of entities, some identity providers publish a single entity in a group.
+type entitiesDescriptor struct {
+	XMLName           xml.Name           `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntitiesDescriptor"`
+	EntityDescriptors []entityDescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+}
+
+type idpSSODescriptor struct {
+	KeyDescriptors      []keyDescriptor `xml:"urn:oasis:names:tc:SAML:2.0:metadata KeyDescriptor"`
+	SingleSignOnService []endpoint      `xml:"urn:oasis:names:tc:SAML:2.0:metadata SingleSignOnService"`
+}
+
+type spSSODescriptor struct {
+	AuthnRequestsSigned        bool              `xml:"AuthnRequestsSigned,attr"`
+	WantAssertionsSigned       bool              `xml:"WantAssertionsSigned,attr"`
+	ProtocolSupportEnumeration string            `xml:"protocolSupportEnumeration,attr"`
+	NameIDFormats              []string          `xml:"urn:oasis:names:tc:SAML:2.0:metadata NameIDFormat"`
+	AssertionConsumerServices  []indexedEndpoint `xml:"urn:oasis:names:tc:SAML:2.0:metadata AssertionConsumerService"`
+}
+
+type keyDescriptor struct {
+	Use              string   `xml:"use,attr,omitempty"`
+	X509Certificates []string `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo>X509Data>X509Certificate"`
+}
+
+type endpoint struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+}
+
+type indexedEndpoint struct {
+	Binding   string `xml:"Binding,attr"`
+	Location  string `xml:"Location,attr"`
+	Index     int    `xml:"index,attr"`
+	IsDefault bool   `xml:"isDefault,attr,omitempty"`
+}
+
+// IdpMetadata is the configuration of an identity provider loaded from its metadata.
+type IdpMetadata struct {
+	EntityID     string
+	SsoUrl       string
+	Certificates []string
+}
+
+// ParseIdpMetadata parses the metadata published by an identity provider. The identity provider must support the
+// HTTP-Redirect binding and publish at least one signing certificate.
+func ParseIdpMetadata(dat []byte) (*IdpMetadata, error) {
+	// Reject documents with a DTD before decoding.
+	if _, err := parseXml(dat); err != nil {
+		return nil, errors.WithMessage(ErrInvalidMetadata, err.Error())
+	}
+
+	var ed entityDescriptor
+	if err := xml.Unmarshal(dat, &ed); err != nil {
+		var eds entitiesDescriptor
+		if err2 := xml.Unmarshal(dat, &eds); err2 != nil {
+			return nil, errors.WithMessage(ErrInvalidMetadata, err.Error())
+		}
+		for _, e := range eds.EntityDescriptors {
+			if e.IDPSSODescriptor != nil {
+				ed = e
+				break
+			}
+		}
+	}
+
+	if ed.EntityID == "" || ed.IDPSSODescriptor == nil {
+		return nil, errors.WithMessage(ErrInvalidMetadata, "no identity provider entity found")
+	}
+
+	md := &IdpMetadata{
+		EntityID: ed.EntityID,
+	}
+
+	for _, s := range ed.IDPSSODescriptor.SingleSignOnService {
+		if s.Binding == bindingHttpRedirect {
+			md.SsoUrl = s.Location
+			break
+		}
+	}
+	if md.SsoUrl == "" {
+		return nil, errors.WithMessage(ErrInvalidMetadata, "no single sign-on service with the HTTP-Redirect binding")
+	}
+
+	for _, kd := range ed.IDPSSODescriptor.KeyDescriptors {
+		if kd.Use != "" && kd.Use != "signing" {
+			continue
+		}
+		for _, c := range kd.X509Certificates {
+			c = strings.Join(strings.Fields(c), "")
+			if _, err := parseCertificate(c); err != nil {
+				return nil, errors.WithMessage(ErrInvalidMetadata, err.Error())
+			}
+			md.Certificates = append(md.Certificates, c)
+		}
+	}
+	if len(md.Certificates) == 0 {
+		return nil, errors.WithMessage(ErrInvalidMetadata, "no signing certificate")
+	}
+
+	return md, nil
+}
+
+// parseCertificate parses a base64 encoded DER certificate as included in metadata.
+func parseCertificate(s string) (*x509.Certificate, error) {
+	der, err := decodeBase64(s)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return cert, nil
+}
+
+// spMetadata returns the metadata of the service provider for an account.
+func spMetadata(entityID, acsUrl string) ([]byte, error) {
+	ed := entityDescriptor{
+		EntityID: entityID,
+		SPSSODescriptor: &spSSODescriptor{
+			AuthnRequestsSigned:        false,
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: nsProtocol,
+			NameIDFormats:              []string{nameIDFormatEmail},
+			AssertionConsumerServices: []indexedEndpoint{
+				{Binding: bindingHttpPost, Location: acsUrl, Index: 1, IsDefault: true},
+			},
+		},
+	}
+
+	dat, err := xml.MarshalIndent(ed, "", "  ")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return append([]byte(xml.Header), dat...), nil
+}
@@ -0,0 +1,270 @@
+package saml
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Repository defines the required dependencies for SAML single sign-on.
+type Repository struct {
+	DbConn      *sqlx.DB
+	User        *user.Repository
+	UserAccount *user_account.Repository
+	WebAppUrl   func(urlPath string) string
+	LookupTXT   func(ctx context.Context, name string) ([]string, error)
+}
+
+// NewRepository creates a new Repository that defines dependencies for SAML single sign-on. The web app URL is used
+// to build the entity ID and the assertion consumer service URL of each account.
+func NewRepository(db *sqlx.DB, user *user.Repository, usrAcc *user_account.Repository, webAppUrl func(urlPath string) string) *Repository {
+	return &Repository{
+		DbConn:      db,
+		User:        user,
+		UserAccount: usrAcc,
+		WebAppUrl:   webAppUrl,
+		LookupTXT:   net.DefaultResolver.LookupTXT,
+	}
+}
+
+// AccountSso is the SAML identity provider configured for an account. The domain must be verified before users can
+// login through the identity provider. When enforced, users with an email address for the verified domain can only
+// login through the identity provider.
+type AccountSso struct {
+	ID               string         `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	AccountID        string         `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	IdpEntityID      string         `json:"idp_entity_id" validate:"required" example:"https://idp.example.com/metadata"`
+	IdpSsoUrl        string         `json:"idp_sso_url" validate:"required,url" example:"https://idp.example.com/sso"`
+	IdpCertificates  pq.StringArray `json:"-" validate:"required,min=1"`
+	IdpMetadata      string         `json:"-"`
+	Domain           string         `json:"domain" validate:"required,fqdn" example:"example.com"`
+	DomainToken      string         `json:"-" validate:"required"`
+	DomainVerifiedAt *pq.NullTime   `json:"domain_verified_at,omitempty"`
+	Enforced         bool           `json:"enforced" example:"true"`
+	RoleAttribute    string         `json:"role_attribute" example:"groups"`
+	RoleMappings     RoleMappings   `json:"role_mappings"`
+	DefaultRole      string         `json:"default_role" validate:"required,role" example:"user"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+}
+
+// DomainVerified returns true when the ownership of the domain has been verified.
+func (m *AccountSso) DomainVerified() bool {
+	return m.DomainVerifiedAt != nil && m.DomainVerifiedAt.Valid && !m.DomainVerifiedAt.Time.IsZero()
+}
+
+// DomainTxtRecord returns the value of the DNS TXT record that must be added to the domain to verify it is owned by
+// the account.
+func (m *AccountSso) DomainTxtRecord() string {
+	return domainTxtRecordPrefix + m.DomainToken
+}
+
+// AccountSsoResponse represents the SAML identity provider of an account that is returned for display.
+type AccountSsoResponse struct {
+	ID               string            `json:"id" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	AccountID        string            `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	IdpEntityID      string            `json:"idp_entity_id" example:"https://idp.example.com/metadata"`
+	IdpSsoUrl        string            `json:"idp_sso_url" example:"https://idp.example.com/sso"`
+	Domain           string            `json:"domain" example:"example.com"`
+	DomainTxtRecord  string            `json:"domain_txt_record" example:"saas-starter-kit-verification=0b8ba1b4d0cbb4c7f3d7a3e0e4c2b1a6"`
+	DomainVerified   bool              `json:"domain_verified" example:"true"`
+	DomainVerifiedAt *web.TimeResponse `json:"domain_verified_at,omitempty"` // DomainVerifiedAt contains multiple format options for display.
+	Enforced         bool              `json:"enforced" example:"true"`
+	RoleAttribute    string            `json:"role_attribute" example:"groups"`
+	RoleMappings     string            `json:"role_mappings" example:"Engineering Leads=admin"`
+	DefaultRole      string            `json:"default_role" example:"user"`
+	CreatedAt        web.TimeResponse  `json:"created_at"` // CreatedAt contains multiple format options for display.
+	UpdatedAt        web.TimeResponse  `json:"updated_at"` // UpdatedAt contains multiple format options for display.
+}
+
+// Response transforms AccountSso to the AccountSsoResponse that is used for display.
+func (m *AccountSso) Response(ctx context.Context) *AccountSsoResponse {
+	if m == nil {
+		return nil
+	}
+
+	r := &AccountSsoResponse{
+		ID:              m.ID,
+		AccountID:       m.AccountID,
+		IdpEntityID:     m.IdpEntityID,
+		IdpSsoUrl:       m.IdpSsoUrl,
+		Domain:          m.Domain,
+		DomainTxtRecord: m.DomainTxtRecord(),
+		DomainVerified:  m.DomainVerified(),
+		Enforced:        m.Enforced,
+		RoleAttribute:   m.RoleAttribute,
+		RoleMappings:    m.RoleMappings.String(),
+		DefaultRole:     m.DefaultRole,
+		CreatedAt:       web.NewTimeResponse(ctx, m.CreatedAt),
+		UpdatedAt:       web.NewTimeResponse(ctx, m.UpdatedAt),
+	}
+
+	if m.DomainVerified() {
+		dv := web.NewTimeResponse(ctx, m.DomainVerifiedAt.Time)
+		r.DomainVerifiedAt = &dv
+	}
+
+	return r
+}
+
+// AccountSsoSaveRequest contains the information needed to configure the SAML identity provider for an account. The
+// metadata is the XML document published by the identity provider.
+type AccountSsoSaveRequest struct {
+	AccountID     string       `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	Metadata      string       `json:"metadata" validate:"required" example:"<EntityDescriptor ...>"`
+	Domain        string       `json:"domain" validate:"required,fqdn" example:"example.com"`
+	Enforced      bool         `json:"enforced" example:"true"`
+	RoleAttribute string       `json:"role_attribute" example:"groups"`
+	RoleMappings  RoleMappings `json:"role_mappings"`
+	DefaultRole   string       `json:"default_role" validate:"omitempty,role" example:"user"`
+}
+
+// AccountSsoDeleteRequest defines the information needed to remove the SAML identity provider for an account.
+type AccountSsoDeleteRequest struct {
+	AccountID string `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+}
+
+// AccountSsoVerifyDomainRequest defines the information needed to verify the ownership of the domain configured for
+// single sign-on of an account.
+type AccountSsoVerifyDomainRequest struct {
+	AccountID string `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+}
+
+// RoleMappings maps the values of the role attribute sent by the identity provider to the roles of the account.
+type RoleMappings map[string]string
+
+// ParseRoleMappings parses role mappings with one "value=role" pair per line.
+func ParseRoleMappings(s string) (RoleMappings, error) {
+	m := make(RoleMappings)
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+
+		pts := strings.SplitN(l, "=", 2)
+		if len(pts) != 2 || strings.TrimSpace(pts[0]) == "" || strings.TrimSpace(pts[1]) == "" {
+			return nil, errors.Errorf("invalid role mapping %q, expected value=role", l)
+		}
+		m[strings.TrimSpace(pts[0])] = strings.TrimSpace(pts[1])
+	}
+	return m, nil
+}
+
+// String returns the role mappings with one "value=role" pair per line.
+func (m RoleMappings) String() string {
+	var l []string
+	for k, v := range m {
+		l = append(l, k+"="+v)
+	}
+	sort.Strings(l)
+	return strings.Join(l, "\n")
+}
+
+// Scan supports reading the RoleMappings value from the database.
+func (m *RoleMappings) Scan(value interface{}) error {
+	var dat []byte
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		dat = v
+	case string:
+		dat = []byte(v)
+	default:
+		return errors.Errorf("unsupported type %T for role mappings", value)
+	}
+	return json.Unmarshal(dat, m)
+}
+
+// Value converts the RoleMappings value to be stored in the database.
+func (m RoleMappings) Value() (driver.Value, error) {
+	if m == nil {
+		m = RoleMappings{}
+	}
+	dat, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return string(dat), nil
+}
+
+// AuthnRequestState is the state of an authentication request that must be persisted by the client, ie in the session,
+// until the identity provider posts the response to the assertion consumer service.
+type AuthnRequestState struct {
+	ID        string    `json:"id"`
+	AccountID string    `json:"account_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Assertion contains the verified details of the user returned by the identity provider.
+type Assertion struct {
+	ID           string              `json:"id"`
+	ExpiresAt    time.Time           `json:"expires_at"`
+	NameID       string              `json:"name_id"`
+	NameIDFormat string              `json:"name_id_format"`
+	SessionIndex string              `json:"session_index"`
+	Attributes   map[string][]string `json:"attributes"`
+}
+
+// Attribute returns the first value of the first attribute found with one of the names.
+func (a *Assertion) Attribute(names ...string) string {
+	for _, n := range names {
+		if vals := a.Attributes[n]; len(vals) > 0 {
+			return vals[0]
+		}
+	}
+	return ""
+}
+
+// Common attribute names used by identity providers for the details of the user.
+var (
+	emailAttributeNames = []string{
+		"email", "Email", "mail", "emailAddress",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	}
+	firstNameAttributeNames = []string{
+		"firstName", "FirstName", "first_name", "givenName",
+		"urn:oid:2.5.4.42",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname",
+	}
+	lastNameAttributeNames = []string{
+		"lastName", "LastName", "last_name", "sn", "surname",
+		"urn:oid:2.5.4.4",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname",
+	}
+)
+
+// Email returns the email address of the user, either from an attribute or the name ID.
+func (a *Assertion) Email() string {
+	if v := a.Attribute(emailAttributeNames...); v != "" {
+		return strings.ToLower(strings.TrimSpace(v))
+	}
+	if a.NameIDFormat == nameIDFormatEmail || strings.Contains(a.NameID, "@") {
+		return strings.ToLower(strings.TrimSpace(a.NameID))
+	}
+	return ""
+}
+
+// FirstName returns the first name of the user.
+func (a *Assertion) FirstName() string {
+	return a.Attribute(firstNameAttributeNames...)
+}
+
+// LastName returns the last name of the user.
+func (a *Assertion) LastName() string {
+	return a.Attribute(lastNameAttributeNames...)
+}
@@ -0,0 +1,984 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_role"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/lib/pq"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// The database table for AccountSso
+	accountSsoTableName = "account_sso"
+	// The database table for the IDs of the assertions that have been used to login.
+	samlAssertionTableName = "saml_assertions"
+)
+
+var (
+	// ErrNotFound abstracts the postgres not found error.
+	ErrNotFound = errors.New("Entity not found")
+
+	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrInvalidResponse occurs when the response posted by the identity provider is not valid for the account.
+	ErrInvalidResponse = errors.New("Invalid SAML response")
+
+	// ErrDomainMismatch occurs when the identity provider returns a user with an email address that is not for the
+	// domain of the account.
+	ErrDomainMismatch = errors.New("Email address does not match the domain of the account")
+
+	// ErrDomainInUse occurs when another account has already verified the domain for single sign-on.
+	ErrDomainInUse = errors.New("Domain is already used by another account")
+
+	// ErrDomainNotVerified occurs when the DNS TXT record to verify the ownership of the domain was not found or when
+	// single sign-on is used before the domain has been verified.
+	ErrDomainNotVerified = errors.New("Domain has not been verified")
+
+	// ErrNotMember occurs when the identity provider returns an existing user that is not a member of the account. The
+	// identity provider is only trusted to create new users and to login the users of the account.
+	ErrNotMember = errors.New("User is not a member of the account")
+)
+
+// domainTxtRecordPrefix is the prefix of the value of the DNS TXT record used to verify the ownership of a domain.
+const domainTxtRecordPrefix = "saas-starter-kit-verification="
+
+// The time allowed between the clocks of the identity provider and the service provider.
+const clockSkew = 3 * time.Minute
+
+// authnRequestTTL is how long the user has to complete the login with the identity provider.
+const authnRequestTTL = 10 * time.Minute
+
+// accountSsoMapColumns is the list of columns needed for find.
+var accountSsoMapColumns = "id,account_id,idp_entity_id,idp_sso_url,idp_certificates,idp_metadata,domain,domain_token," +
+	"domain_verified_at,enforced,role_attribute,role_mappings,default_role,created_at,updated_at"
+
+// find internal method for getting the single sign-on configurations from the database using a select query.
+func find(ctx context.Context, repo *Repository, query *sqlbuilder.SelectBuilder) ([]*AccountSso, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.saml.find")
+	defer span.Finish()
+
+	query.Select(accountSsoMapColumns)
+	query.From(accountSsoTableName)
+
+	queryStr, queryArgs := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	// Fetch all entries from the db.
+	rows, err := repo.DbConn.QueryContext(ctx, queryStr, queryArgs...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find account sso failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over each row.
+	resp := []*AccountSso{}
+	for rows.Next() {
+		var m AccountSso
+		err = rows.Scan(&m.ID, &m.AccountID, &m.IdpEntityID, &m.IdpSsoUrl, &m.IdpCertificates, &m.IdpMetadata,
+			&m.Domain, &m.DomainToken, &m.DomainVerifiedAt, &m.Enforced, &m.RoleAttribute, &m.RoleMappings, &m.DefaultRole, &m.CreatedAt, &m.UpdatedAt)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+
+		resp = append(resp, &m)
+	}
+
+	return resp, nil
+}
+
+// Read gets the single sign-on configuration for the account.
+func (repo *Repository) Read(ctx context.Context, claims auth.Claims, accountID string) (*AccountSso, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.saml.Read")
+	defer span.Finish()
+
+	err := account.CanReadAccount(ctx, claims, repo.DbConn, accountID)
+	if err != nil {
+		return nil, mapAccountError(err)
+	}
+
+	query := sqlbuilder.NewSelectBuilder()
+	query.Where(query.Equal("account_id", accountID))
+
+	res, err := find(ctx, repo, query)
+	if err != nil {
+		return nil, err
+	} else if len(res) == 0 {
+		err = errors.WithMessagef(ErrNotFound, "single sign-on for account %s not found", accountID)
+		return nil, err
+	}
+
+	return res[0], nil
+}
+
+// ReadByDomain gets the single sign-on configuration that has verified the domain of an email address.
+func (repo *Repository) ReadByDomain(ctx context.Context, domain string) (*AccountSso, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.saml.ReadByDomain")
+	defer span.Finish()
+
+	query := sqlbuilder.NewSelectBuilder()
+	query.Where(query.And(
+		query.Equal("domain", strings.ToLower(domain)),
+		query.IsNotNull("domain_verified_at"),
+	))
+
+	res, err := find(ctx, repo, query)
+	if err != nil {
+		return nil, err
+	} else if len(res) == 0 {
+		err = errors.WithMessagef(ErrNotFound, "verified single sign-on for domain %s not found", domain)
+		return nil, err
+	}
+
+	return res[0], nil
+}
+
+// ReadEnforcedForEmail gets the single sign-on configuration that must be used by the email address to login.
+func (repo *Repository) ReadEnforcedForEmail(ctx context.Context, email string) (*AccountSso, error) {
+	sso, err := repo.ReadByDomain(ctx, emailDomain(email))
+	if err != nil {
+		return nil, err
+	} else if !sso.Enforced {
+		err = errors.WithMessagef(ErrNotFound, "single sign-on for %s is not enforced", email)
+		return nil, err
+	}
+	return sso, nil
+}
+
+// SsoRequired returns true when the verified domain of the email address is enforced to login with single sign-on,
+// which disables password logins.
+func (repo *Repository) SsoRequired(ctx context.Context, email string) (bool, error) {
+	_, err := repo.ReadEnforcedForEmail(ctx, email)
+	if err != nil {
+		if errors.Cause(err) == ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Save creates or updates the single sign-on configuration for the account using the metadata of the identity
+// provider. A new token to verify the domain is generated when the domain is changed and the domain must be verified
+// using VerifyDomain before it can be used.
+func (repo *Repository) Save(ctx context.Context, claims auth.Claims, req AccountSsoSaveRequest, now time.Time) (*AccountSso, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.saml.Save")
+	defer span.Finish()
+
+	req.Domain = strings.ToLower(strings.TrimSpace(req.Domain))
+
+	// Validate the request.
+	err := webcontext.Validator().StructCtx(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the claims can modify the account specified in the request.
+	err = account.CanModifyAccount(ctx, claims, repo.DbConn, req.AccountID)
+	if err != nil {
+		return nil, mapAccountError(err)
+	}
+
+	md, err := ParseIdpMetadata([]byte(req.Metadata))
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the mapped roles are defined for the account.
+	roleNames := []string{req.DefaultRole}
+	if req.DefaultRole == "" {
+		roleNames[0] = user_account.UserAccountRole_User.String()
+	}
+	for _, r := range req.RoleMappings {
+		roleNames = append(roleNames, r)
+	}
+	err = account_role.ValidateRoles(ctx, repo.DbConn, req.AccountID, roleNames)
+	if err != nil {
+		return nil, err
+	}
+
+	// A domain can only be verified by a single account.
+	if other, err := repo.ReadByDomain(ctx, req.Domain); err == nil && other.AccountID != req.AccountID {
+		return nil, errors.WithMessagef(ErrDomainInUse, "domain %s", req.Domain)
+	} else if err != nil && errors.Cause(err) != ErrNotFound {
+		return nil, err
+	}
+
+	existing, err := repo.Read(ctx, auth.Claims{}, req.AccountID)
+	if err != nil && errors.Cause(err) != ErrNotFound {
+		return nil, err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	m := AccountSso{
+		ID:              uuid.NewRandom().String(),
+		AccountID:       req.AccountID,
+		IdpEntityID:     md.EntityID,
+		IdpSsoUrl:       md.SsoUrl,
+		IdpCertificates: md.Certificates,
+		IdpMetadata:     req.Metadata,
+		Domain:          req.Domain,
+		Enforced:        req.Enforced,
+		RoleAttribute:   strings.TrimSpace(req.RoleAttribute),
+		RoleMappings:    req.RoleMappings,
+		DefaultRole:     req.DefaultRole,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	if m.DefaultRole == "" {
+		m.DefaultRole = user_account.UserAccountRole_User.String()
+	}
+	if m.RoleMappings == nil {
+		m.RoleMappings = RoleMappings{}
+	}
+	if existing != nil {
+		m.ID = existing.ID
+		m.CreatedAt = existing.CreatedAt
+	}
+
+	// The verification of the domain is kept until the domain is changed.
+	if existing != nil && existing.Domain == m.Domain {
+		m.DomainToken = existing.DomainToken
+		m.DomainVerifiedAt = existing.DomainVerifiedAt
+	} else {
+		m.DomainToken, err = newDomainToken()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate the configuration.
+	err = webcontext.Validator().StructCtx(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	var query interface {
+		Build() (string, []interface{})
+		String() string
+	}
+	if existing == nil {
+		// Build the insert SQL statement.
+		q := sqlbuilder.NewInsertBuilder()
+		q.InsertInto(accountSsoTableName)
+		q.Cols("id", "account_id", "idp_entity_id", "idp_sso_url", "idp_certificates", "idp_metadata", "domain",
+			"domain_token", "enforced", "role_attribute", "role_mappings", "default_role", "created_at", "updated_at")
+		q.Values(m.ID, m.AccountID, m.IdpEntityID, m.IdpSsoUrl, m.IdpCertificates, m.IdpMetadata, m.Domain,
+			m.DomainToken, m.Enforced, m.RoleAttribute, m.RoleMappings, m.DefaultRole, m.CreatedAt, m.UpdatedAt)
+		query = q
+	} else {
+		// Build the update SQL statement.
+		q := sqlbuilder.NewUpdateBuilder()
+		q.Update(accountSsoTableName)
+		q.Set(
+			q.Assign("idp_entity_id", m.IdpEntityID),
+			q.Assign("idp_sso_url", m.IdpSsoUrl),
+			q.Assign("idp_certificates", m.IdpCertificates),
+			q.Assign("idp_metadata", m.IdpMetadata),
+			q.Assign("domain", m.Domain),
+			q.Assign("domain_token", m.DomainToken),
+			q.Assign("domain_verified_at", m.DomainVerifiedAt),
+			q.Assign("enforced", m.Enforced),
+			q.Assign("role_attribute", m.RoleAttribute),
+			q.Assign("role_mappings", m.RoleMappings),
+			q.Assign("default_role", m.DefaultRole),
+			q.Assign("updated_at", m.UpdatedAt),
+		)
+		q.Where(q.Equal("id", m.ID))
+		query = q
+	}
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "save single sign-on for account %s failed", req.AccountID)
+		return nil, err
+	}
+
+	action := audit.Action_Create
+	if existing != nil {
+		action = audit.Action_Update
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  m.AccountID,
+		EntityType: audit.EntityType_AccountSso,
+		EntityID:   m.ID,
+		Action:     action,
+		Before:     existing,
+		After:      m,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// VerifyDomain verifies the ownership of the domain configured for single sign-on of the account. The domain must
+// have a DNS TXT record with the value returned by DomainTxtRecord.
+func (repo *Repository) VerifyDomain(ctx context.Context, claims auth.Claims, req AccountSsoVerifyDomainRequest, now time.Time) (*AccountSso, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.saml.VerifyDomain")
+	defer span.Finish()
+
+	// Validate the request.
+	err := webcontext.Validator().StructCtx(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the claims can modify the account specified in the request.
+	err = account.CanModifyAccount(ctx, claims, repo.DbConn, req.AccountID)
+	if err != nil {
+		return nil, mapAccountError(err)
+	}
+
+	existing, err := repo.Read(ctx, auth.Claims{}, req.AccountID)
+	if err != nil {
+		return nil, err
+	} else if existing.DomainVerified() {
+		return existing, nil
+	}
+
+	records, err := repo.LookupTXT(ctx, existing.Domain)
+	if err != nil {
+		if _, ok := err.(*net.DNSError); ok {
+			return nil, errors.WithMessagef(ErrDomainNotVerified, "lookup TXT records for %s: %s", existing.Domain, err)
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	var found bool
+	for _, r := range records {
+		if strings.TrimSpace(r) == existing.DomainTxtRecord() {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.WithMessagef(ErrDomainNotVerified, "TXT record %s not found for %s",
+			existing.DomainTxtRecord(), existing.Domain)
+	}
+
+	// A domain can only be verified by a single account.
+	if other, err := repo.ReadByDomain(ctx, existing.Domain); err == nil && other.AccountID != req.AccountID {
+		return nil, errors.WithMessagef(ErrDomainInUse, "domain %s", existing.Domain)
+	} else if err != nil && errors.Cause(err) != ErrNotFound {
+		return nil, err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	m := *existing
+	m.DomainVerifiedAt = &pq.NullTime{Time: now, Valid: true}
+	m.UpdatedAt = now
+
+	// Build the update SQL statement. The token is matched so a domain changed in the meantime is not verified.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(accountSsoTableName)
+	query.Set(
+		query.Assign("domain_verified_at", m.DomainVerifiedAt),
+		query.Assign("updated_at", m.UpdatedAt),
+	)
+	query.Where(query.And(
+		query.Equal("id", m.ID),
+		query.Equal("domain_token", m.DomainToken),
+	))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	res, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "verify domain %s for account %s failed", m.Domain, req.AccountID)
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, errors.WithStack(err)
+	} else if n == 0 {
+		return nil, errors.WithMessagef(ErrDomainNotVerified, "domain for account %s changed", req.AccountID)
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  m.AccountID,
+		EntityType: audit.EntityType_AccountSso,
+		EntityID:   m.ID,
+		Action:     audit.Action_Update,
+		Before:     existing,
+		After:      m,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Delete removes the single sign-on configuration for the account.
+func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req AccountSsoDeleteRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.saml.Delete")
+	defer span.Finish()
+
+	// Validate the request.
+	err := webcontext.Validator().StructCtx(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can modify the account specified in the request.
+	err = account.CanModifyAccount(ctx, claims, repo.DbConn, req.AccountID)
+	if err != nil {
+		return mapAccountError(err)
+	}
+
+	existing, err := repo.Read(ctx, auth.Claims{}, req.AccountID)
+	if err != nil {
+		return err
+	}
+
+	// Build the delete SQL statement.
+	query := sqlbuilder.NewDeleteBuilder()
+	query.DeleteFrom(accountSsoTableName)
+	query.Where(query.Equal("id", existing.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "delete single sign-on for account %s failed", req.AccountID)
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  existing.AccountID,
+		EntityType: audit.EntityType_AccountSso,
+		EntityID:   existing.ID,
+		Action:     audit.Action_Delete,
+		Before:     existing,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// EntityID returns the entity ID of the service provider for the account, which is also the URL of the metadata.
+func (repo *Repository) EntityID(accountID string) string {
+	return repo.WebAppUrl(fmt.Sprintf("/sso/saml/%s/metadata", accountID))
+}
+
+// AcsUrl returns the URL of the assertion consumer service for the account.
+func (repo *Repository) AcsUrl(accountID string) string {
+	return repo.WebAppUrl(fmt.Sprintf("/sso/saml/%s/acs", accountID))
+}
+
+// SpMetadata returns the metadata of the service provider for the account that is needed to configure the
+// identity provider.
+func (repo *Repository) SpMetadata(ctx context.Context, accountID string) ([]byte, error) {
+	if _, err := repo.Read(ctx, auth.Claims{}, accountID); err != nil {
+		return nil, err
+	}
+	return spMetadata(repo.EntityID(accountID), repo.AcsUrl(accountID))
+}
+
+// AuthnRequestUrl starts a login with the identity provider of the account using the HTTP-Redirect binding. The
+// returned state must be persisted until the identity provider posts the response.
+func (repo *Repository) AuthnRequestUrl(ctx context.Context, accountID, relayState string, now time.Time) (*AuthnRequestState, string, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.saml.AuthnRequestUrl")
+	defer span.Finish()
+
+	sso, err := repo.Read(ctx, auth.Claims{}, accountID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	st := &AuthnRequestState{
+		// IDs must not start with a number.
+		ID:        "id-" + hex.EncodeToString(b),
+		AccountID: accountID,
+		CreatedAt: now.UTC(),
+	}
+
+	authnReq := fmt.Sprintf(`<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s" `+
+		`Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="%s">`+
+		`<saml:Issuer>%s</saml:Issuer><samlp:NameIDPolicy Format="%s" AllowCreate="true"/></samlp:AuthnRequest>`,
+		nsProtocol, nsAssertion, st.ID, st.CreatedAt.Format(time.RFC3339), escapeAttr(sso.IdpSsoUrl),
+		escapeAttr(repo.AcsUrl(accountID)), bindingHttpPost, escapeText(repo.EntityID(accountID)), nameIDFormatEmail)
+
+	// The HTTP-Redirect binding uses raw deflate.
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	if _, err := fw.Write([]byte(authnReq)); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+	if err := fw.Close(); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	q := url.Values{}
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if relayState != "" {
+		q.Set("RelayState", relayState)
+	}
+
+	ssoUrl := sso.IdpSsoUrl
+	if strings.Contains(ssoUrl, "?") {
+		ssoUrl += "&" + q.Encode()
+	} else {
+		ssoUrl += "?" + q.Encode()
+	}
+
+	return st, ssoUrl, nil
+}
+
+// ParseResponse verifies the response posted by the identity provider to the assertion consumer service and returns
+// the assertion. The assertion must be signed by the identity provider, be issued for the account in response to the
+// request and still be valid.
+func (repo *Repository) ParseResponse(ctx context.Context, st AuthnRequestState, samlResponse string, now time.Time) (*Assertion, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.saml.ParseResponse")
+	defer span.Finish()
+
+	if st.ID == "" {
+		return nil, errors.WithMessage(ErrInvalidResponse, "no pending request")
+	} else if now.UTC().Sub(st.CreatedAt) > authnRequestTTL {
+		return nil, errors.WithMessage(ErrInvalidResponse, "request expired")
+	}
+
+	sso, err := repo.Read(ctx, auth.Claims{}, st.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for _, c := range sso.IdpCertificates {
+		cert, err := parseCertificate(c)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	dat, err := decodeBase64(samlResponse)
+	if err != nil {
+		return nil, errors.WithMessage(ErrInvalidResponse, "invalid encoding")
+	}
+
+	a, err := verifyResponse(dat, certs, sso.IdpEntityID, repo.EntityID(st.AccountID), repo.AcsUrl(st.AccountID), st.ID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := repo.useAssertion(ctx, st.AccountID, a, now); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// useAssertion records the ID of the assertion so a response captured in transit or from the browser can't be posted
+// again. The IDs are kept until the assertion expires since expired assertions are rejected anyway.
+func (repo *Repository) useAssertion(ctx context.Context, accountID string, a *Assertion, now time.Time) error {
+	now = now.UTC()
+
+	// Remove the IDs of the assertions that have expired.
+	{
+		query := sqlbuilder.NewDeleteBuilder()
+		query.DeleteFrom(samlAssertionTableName)
+		query.Where(query.LessThan("expires_at", now))
+
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessage(err, "delete expired assertions failed")
+			return err
+		}
+	}
+
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(samlAssertionTableName)
+	query.Cols("account_id", "id", "expires_at", "created_at")
+	query.Values(accountID, a.ID, a.ExpiresAt, now)
+
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql + " ON CONFLICT (account_id, id) DO NOTHING")
+	res, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "record assertion %s failed", a.ID)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.WithStack(err)
+	} else if n == 0 {
+		return errors.WithMessagef(ErrInvalidResponse, "assertion %s has already been used", a.ID)
+	}
+
+	return nil
+}
+
+// verifyResponse verifies the response and returns the assertion.
+func verifyResponse(dat []byte, certs []*x509.Certificate, idpEntityID, spEntityID, acsUrl, requestID string, now time.Time) (*Assertion, error) {
+	root, err := parseXml(dat)
+	if err != nil {
+		return nil, errors.WithMessage(ErrInvalidResponse, err.Error())
+	} else if !root.Is(nsProtocol, "Response") {
+		return nil, errors.WithMessage(ErrInvalidResponse, "root element is not a response")
+	}
+
+	if code := root.Child(nsProtocol, "Status").Child(nsProtocol, "StatusCode").Attr("Value"); code != statusSuccess {
+		return nil, errors.WithMessagef(ErrInvalidResponse, "status %s", code)
+	}
+
+	if d := root.Attr("Destination"); d != "" && d != acsUrl {
+		return nil, errors.WithMessagef(ErrInvalidResponse, "destination %s does not match", d)
+	}
+	if irt := root.Attr("InResponseTo"); irt != "" && irt != requestID {
+		return nil, errors.WithMessagef(ErrInvalidResponse, "response to %s does not match request", irt)
+	}
+
+	if len(root.ChildElements(nsAssertion, "EncryptedAssertion")) > 0 {
+		return nil, errors.WithMessage(ErrInvalidResponse, "encrypted assertions are not supported")
+	}
+
+	assertions := root.ChildElements(nsAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, errors.WithMessage(ErrInvalidResponse, "exactly one assertion is required")
+	}
+	a := assertions[0]
+
+	if a.Attr("ID") == "" {
+		return nil, errors.WithMessage(ErrInvalidResponse, "assertion has no ID")
+	}
+
+	// Either the response or the assertion must be signed, the values are only read from the verified elements.
+	if root.Child(nsDsig, "Signature") != nil {
+		if err := verifySignature(root, root, certs); err != nil {
+			return nil, err
+		}
+	} else if err := verifySignature(root, a, certs); err != nil {
+		return nil, err
+	}
+
+	if iss := a.Child(nsAssertion, "Issuer").Text(); iss != idpEntityID {
+		return nil, errors.WithMessagef(ErrInvalidResponse, "issuer %s does not match", iss)
+	}
+
+	now = now.UTC()
+
+	// The assertion can be used until the earliest of the times it's valid until.
+	var expiresAt time.Time
+
+	// The subject must be confirmed for this request and service provider.
+	subject := a.Child(nsAssertion, "Subject")
+	var confirmed bool
+	for _, sc := range subject.ChildElements(nsAssertion, "SubjectConfirmation") {
+		if sc.Attr("Method") != subjectConfirmBearer {
+			continue
+		}
+		scd := sc.Child(nsAssertion, "SubjectConfirmationData")
+		if scd.Attr("Recipient") != acsUrl || scd.Attr("InResponseTo") != requestID {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, scd.Attr("NotOnOrAfter"))
+		if err != nil || !now.Before(t.Add(clockSkew)) {
+			continue
+		}
+		expiresAt = t.Add(clockSkew)
+		confirmed = true
+		break
+	}
+	if !confirmed {
+		return nil, errors.WithMessage(ErrInvalidResponse, "subject confirmation failed")
+	}
+
+	// The conditions limit when and for which audience the assertion is valid.
+	cond := a.Child(nsAssertion, "Conditions")
+	if cond == nil {
+		return nil, errors.WithMessage(ErrInvalidResponse, "conditions are required")
+	}
+	if v := cond.Attr("NotBefore"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err != nil || now.Add(clockSkew).Before(t) {
+			return nil, errors.WithMessage(ErrInvalidResponse, "assertion is not valid yet")
+		}
+	}
+	if v := cond.Attr("NotOnOrAfter"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil || !now.Before(t.Add(clockSkew)) {
+			return nil, errors.WithMessage(ErrInvalidResponse, "assertion expired")
+		} else if t.Add(clockSkew).Before(expiresAt) {
+			expiresAt = t.Add(clockSkew)
+		}
+	}
+	var audience bool
+	for _, ar := range cond.ChildElements(nsAssertion, "AudienceRestriction") {
+		for _, aud := range ar.ChildElements(nsAssertion, "Audience") {
+			if aud.Text() == spEntityID {
+				audience = true
+			}
+		}
+	}
+	if !audience {
+		return nil, errors.WithMessage(ErrInvalidResponse, "audience does not match")
+	}
+
+	nameID := subject.Child(nsAssertion, "NameID")
+	res := &Assertion{
+		ID:           a.Attr("ID"),
+		ExpiresAt:    expiresAt.UTC(),
+		NameID:       nameID.Text(),
+		NameIDFormat: nameID.Attr("Format"),
+		SessionIndex: a.Child(nsAssertion, "AuthnStatement").Attr("SessionIndex"),
+		Attributes:   make(map[string][]string),
+	}
+
+	for _, as := range a.ChildElements(nsAssertion, "AttributeStatement") {
+		for _, attr := range as.ChildElements(nsAssertion, "Attribute") {
+			name := attr.Attr("Name")
+			for _, v := range attr.ChildElements(nsAssertion, "AttributeValue") {
+				res.Attributes[name] = append(res.Attributes[name], v.Text())
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// Login creates the user for the assertion just-in-time or updates an existing member of the account, and sets the
+// roles of the user for the account mapped from the attributes of the assertion. Existing users that are not members
+// of the account are never signed in, the identity provider of one account can't take over the users of others.
+func (repo *Repository) Login(ctx context.Context, accountID string, a *Assertion, now time.Time) (*user.User, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.saml.Login")
+	defer span.Finish()
+
+	sso, err := repo.Read(ctx, auth.Claims{}, accountID)
+	if err != nil {
+		return nil, err
+	} else if !sso.DomainVerified() {
+		return nil, errors.WithMessagef(ErrDomainNotVerified, "domain %s for account %s", sso.Domain, accountID)
+	}
+
+	// The identity provider is only trusted for the domain of the account, otherwise it could login as any user.
+	email := a.Email()
+	if email == "" {
+		return nil, errors.WithMessage(ErrInvalidResponse, "email address is required")
+	} else if emailDomain(email) != sso.Domain {
+		return nil, errors.WithMessagef(ErrDomainMismatch, "email %s for domain %s", email, sso.Domain)
+	}
+
+	firstName, lastName := a.FirstName(), a.LastName()
+
+	var ua *user_account.UserAccount
+	u, err := repo.User.ReadByEmail(ctx, auth.Claims{}, email, false)
+	if err == nil {
+		ua, err = repo.UserAccount.Read(ctx, auth.Claims{}, user_account.UserAccountReadRequest{
+			UserID:    u.ID,
+			AccountID: accountID,
+		})
+		if err != nil {
+			if errors.Cause(err) == user_account.ErrNotFound {
+				return nil, errors.WithMessagef(ErrNotMember, "user %s for account %s", u.ID, accountID)
+			}
+			return nil, err
+		}
+	}
+
+	if err != nil {
+		if errors.Cause(err) != user.ErrNotFound {
+			return nil, err
+		}
+
+		if firstName == "" || lastName == "" {
+			return nil, errors.WithMessage(ErrInvalidResponse, "first name and last name attributes are required")
+		}
+
+		// Users that login with single sign-on don't have a password, a random one is set that can be changed using
+		// reset password when single sign-on is not enforced.
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		pass := hex.EncodeToString(b)
+
+		u, err = repo.User.Create(ctx, auth.Claims{}, user.UserCreateRequest{
+			FirstName:       firstName,
+			LastName:        lastName,
+			Email:           email,
+			Password:        pass,
+			PasswordConfirm: pass,
+		}, now)
+		if err != nil {
+			return nil, err
+		}
+	} else if (firstName != "" && firstName != u.FirstName) || (lastName != "" && lastName != u.LastName) {
+		upReq := user.UserUpdateRequest{ID: u.ID}
+		if firstName != "" {
+			upReq.FirstName = &firstName
+		}
+		if lastName != "" {
+			upReq.LastName = &lastName
+		}
+		if err = repo.User.Update(ctx, auth.Claims{}, upReq, now); err != nil {
+			return nil, err
+		}
+	}
+
+	// The identity provider controls the domain so the email address is verified.
+	if !u.EmailVerified() {
+		if err = repo.User.SetEmailVerified(ctx, u.ID, now); err != nil {
+			return nil, err
+		}
+	}
+
+	roles := sso.MapRoles(a.Attributes[sso.RoleAttribute])
+
+	if ua == nil || !sameRoles(ua.Roles, roles) {
+		_, err = repo.UserAccount.Create(ctx, auth.Claims{}, user_account.UserAccountCreateRequest{
+			UserID:    u.ID,
+			AccountID: accountID,
+			Roles:     roles,
+		}, now)
+		if err != nil {
+			// The current roles are kept when the mapped roles would remove the owner or the last admin of the
+			// account, the user can still login.
+			switch errors.Cause(err) {
+			case user_account.ErrLastAdmin, user_account.ErrOwnerRole:
+				if ua == nil {
+					return nil, err
+				}
+			default:
+				return nil, err
+			}
+		}
+	}
+
+	return repo.User.ReadByID(ctx, auth.Claims{}, u.ID)
+}
+
+// MapRoles returns the roles of the account for the values of the role attribute. The default role is used when no
+// value is mapped.
+func (m *AccountSso) MapRoles(values []string) user_account.UserAccountRoles {
+	var roles user_account.UserAccountRoles
+	seen := make(map[string]bool)
+	for _, v := range values {
+		r, ok := m.RoleMappings[v]
+		if !ok || seen[r] {
+			continue
+		}
+		seen[r] = true
+		roles = append(roles, user_account.UserAccountRole(r))
+	}
+
+	if len(roles) == 0 {
+		roles = append(roles, user_account.UserAccountRole(m.DefaultRole))
+	}
+
+	return roles
+}
+
+// sameRoles returns true when both lists contain the same roles.
+func sameRoles(a, b user_account.UserAccountRoles) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[user_account.UserAccountRole]bool)
+	for _, r := range a {
+		seen[r] = true
+	}
+	for _, r := range b {
+		if !seen[r] {
+			return false
+		}
+	}
+	return true
+}
+
+// emailDomain returns the lower case domain of the email address.
+func emailDomain(email string) string {
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		return strings.ToLower(email[i+1:])
+	}
+	return ""
+}
+
+// newDomainToken returns a random token used to verify the ownership of a domain.
+func newDomainToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// mapAccountError maps account errors to local defined errors.
+func mapAccountError(err error) error {
+	switch errors.Cause(err) {
+	case account.ErrNotFound:
+		err = ErrNotFound
+	case account.ErrForbidden:
+		err = ErrForbidden
+	}
+	return err
+}
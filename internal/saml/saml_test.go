@@ -0,0 +1,477 @@
+package saml
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var test *tests.Test
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+	return m.Run()
+}
+
+// stubCertificates returns the parsed signing certificates from the metadata of the stub identity provider.
+func stubCertificates(t *testing.T, idp *StubIdentityProvider) []*x509.Certificate {
+	md, err := ParseIdpMetadata(idp.Metadata())
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tParseIdpMetadata failed.", tests.Failed)
+	}
+
+	var certs []*x509.Certificate
+	for _, c := range md.Certificates {
+		cert, err := parseCertificate(c)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tParse certificate failed.", tests.Failed)
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
+// TestVerifyResponse validates the signature and conditions of responses posted by an identity provider.
+func TestVerifyResponse(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2019, time.August, 22, 0, 0, 0, 0, time.UTC)
+
+	idp, err := NewStubIdentityProvider("https://idp.example.com/metadata", "https://idp.example.com/sso")
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tNewStubIdentityProvider failed.", tests.Failed)
+	}
+
+	spEntityID := "http://127.0.0.1:3000/sso/saml/test/metadata"
+	acsUrl := "http://127.0.0.1:3000/sso/saml/test/acs"
+	requestID := "id-request"
+
+	req := StubResponseRequest{
+		AcsUrl:       acsUrl,
+		Audience:     spEntityID,
+		InResponseTo: requestID,
+		NameID:       "lee@example.com",
+		Attributes: map[string][]string{
+			"firstName": {"Lee"},
+			"lastName":  {"Brown"},
+			"groups":    {"Engineering", "Everyone"},
+		},
+		Now: now,
+	}
+
+	t.Log("Given the need to verify responses from an identity provider.")
+	{
+		md, err := ParseIdpMetadata(idp.Metadata())
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tParseIdpMetadata failed.", tests.Failed)
+		} else if md.EntityID != idp.EntityID || md.SsoUrl != idp.SsoUrl || len(md.Certificates) != 1 {
+			t.Logf("\t\tGot : %+v", md)
+			t.Fatalf("\t%s\tParseIdpMetadata values failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tParseIdpMetadata ok.", tests.Success)
+
+		// Metadata with a DTD is rejected to prevent entity expansion.
+		_, err = ParseIdpMetadata([]byte(`<!DOCTYPE x [<!ENTITY a "a">]>` + string(idp.Metadata())))
+		if errors.Cause(err) != ErrInvalidMetadata {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidMetadata)
+			t.Fatalf("\t%s\tParseIdpMetadata with DTD failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tParseIdpMetadata with DTD ok.", tests.Success)
+
+		certs := stubCertificates(t, idp)
+
+		res, err := idp.Response(req)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tResponse failed.", tests.Failed)
+		}
+		dat, _ := base64.StdEncoding.DecodeString(res)
+
+		a, err := verifyResponse(dat, certs, idp.EntityID, spEntityID, acsUrl, requestID, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tVerify failed.", tests.Failed)
+		} else if a.Email() != "lee@example.com" || a.FirstName() != "Lee" || a.LastName() != "Brown" || len(a.Attributes["groups"]) != 2 {
+			t.Logf("\t\tGot : %+v", a)
+			t.Fatalf("\t%s\tVerify assertion failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerify ok.", tests.Success)
+
+		// Changing any signed value must invalidate the signature.
+		tampered := strings.Replace(string(dat), "lee@example.com", "admin@example.com", 1)
+		_, err = verifyResponse([]byte(tampered), certs, idp.EntityID, spEntityID, acsUrl, requestID, now)
+		if errors.Cause(err) != ErrInvalidSignature {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidSignature)
+			t.Fatalf("\t%s\tVerify tampered failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerify tampered ok.", tests.Success)
+
+		// Responses signed by another identity provider must be rejected.
+		other, err := NewStubIdentityProvider(idp.EntityID, idp.SsoUrl)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tNewStubIdentityProvider failed.", tests.Failed)
+		}
+		_, err = verifyResponse(dat, stubCertificates(t, other), idp.EntityID, spEntityID, acsUrl, requestID, now)
+		if errors.Cause(err) != ErrInvalidSignature {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidSignature)
+			t.Fatalf("\t%s\tVerify other certificate failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerify other certificate ok.", tests.Success)
+
+		// Responses signed or digested with SHA-1 must be rejected.
+		for _, alg := range []struct{ name, from, to, msg string }{
+			{"signature", algRsaSha256, "http://www.w3.org/2000/09/xmldsig#rsa-sha1", "signature method"},
+			{"digest", algDigestSha256, "http://www.w3.org/2000/09/xmldsig#sha1", "digest method"},
+		} {
+			sha1 := strings.Replace(string(dat), alg.from, alg.to, -1)
+			_, err = verifyResponse([]byte(sha1), certs, idp.EntityID, spEntityID, acsUrl, requestID, now)
+			if errors.Cause(err) != ErrInvalidSignature || !strings.Contains(err.Error(), alg.msg) {
+				t.Logf("\t\tGot : %+v", err)
+				t.Logf("\t\tWant: %+v", ErrInvalidSignature)
+				t.Fatalf("\t%s\tVerify SHA-1 %s failed.", tests.Failed, alg.name)
+			}
+			t.Logf("\t%s\tVerify SHA-1 %s ok.", tests.Success, alg.name)
+		}
+
+		// The response must be for the pending request.
+		_, err = verifyResponse(dat, certs, idp.EntityID, spEntityID, acsUrl, "id-other", now)
+		if errors.Cause(err) != ErrInvalidResponse {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidResponse)
+			t.Fatalf("\t%s\tVerify other request failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerify other request ok.", tests.Success)
+
+		// The assertion must be issued for the service provider.
+		_, err = verifyResponse(dat, certs, idp.EntityID, "http://127.0.0.1:3000/sso/saml/other/metadata", acsUrl, requestID, now)
+		if errors.Cause(err) != ErrInvalidResponse {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidResponse)
+			t.Fatalf("\t%s\tVerify other audience failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerify other audience ok.", tests.Success)
+
+		// Expired assertions must be rejected.
+		_, err = verifyResponse(dat, certs, idp.EntityID, spEntityID, acsUrl, requestID, now.Add(time.Hour))
+		if errors.Cause(err) != ErrInvalidResponse {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidResponse)
+			t.Fatalf("\t%s\tVerify expired failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerify expired ok.", tests.Success)
+	}
+}
+
+// TestLogin validates configuring single sign-on for an account and just-in-time provisioning of users.
+func TestLogin(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2019, time.August, 22, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	acc, err := account.MockAccount(ctx, test.MasterDB, now)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tMockAccount failed.", tests.Failed)
+	}
+
+	idp, err := NewStubIdentityProvider("https://idp.example.com/"+acc.ID, "https://idp.example.com/sso")
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tNewStubIdentityProvider failed.", tests.Failed)
+	}
+
+	webAppUrl := func(p string) string {
+		return "http://127.0.0.1:3000" + p
+	}
+	repo := NewRepository(test.MasterDB, user.MockRepository(test.MasterDB), user_account.NewRepository(test.MasterDB), webAppUrl)
+
+	// The DNS TXT records of the domain are stubbed.
+	var txtRecords []string
+	repo.LookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		return txtRecords, nil
+	}
+
+	domain := strings.Split(uuid.NewRandom().String(), "-")[0] + ".example.com"
+
+	t.Log("Given the need to login with the SAML identity provider of an account.")
+	{
+		// Only users of the account with admin can configure single sign-on.
+		userClaims := auth.Claims{
+			Roles: []string{auth.RoleUser},
+		}
+		userClaims.Subject = uuid.NewRandom().String()
+		userClaims.Audience = acc.ID
+
+		saveReq := AccountSsoSaveRequest{
+			AccountID:     acc.ID,
+			Metadata:      string(idp.Metadata()),
+			Domain:        domain,
+			Enforced:      true,
+			RoleAttribute: "groups",
+			RoleMappings:  RoleMappings{"Admins": auth.RoleAdmin},
+			DefaultRole:   auth.RoleUser,
+		}
+
+		_, err = repo.Save(ctx, userClaims, saveReq, now)
+		if errors.Cause(err) != ErrForbidden {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrForbidden)
+			t.Fatalf("\t%s\tSave as user failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tSave as user ok.", tests.Success)
+
+		sso, err := repo.Save(ctx, auth.Claims{}, saveReq, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSave failed.", tests.Failed)
+		} else if sso.IdpEntityID != idp.EntityID || sso.IdpSsoUrl != idp.SsoUrl || sso.Domain != domain {
+			t.Logf("\t\tGot : %+v", sso)
+			t.Fatalf("\t%s\tSave values failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tSave ok.", tests.Success)
+
+		// The domain can't be used until it has been verified.
+		required, err := repo.SsoRequired(ctx, "someone@"+domain)
+		if err != nil || required {
+			t.Logf("\t\tGot : %v %+v", required, err)
+			t.Fatalf("\t%s\tSsoRequired unverified failed.", tests.Failed)
+		}
+
+		_, err = repo.Login(ctx, acc.ID, &Assertion{NameID: "someone@" + domain}, now)
+		if errors.Cause(err) != ErrDomainNotVerified {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrDomainNotVerified)
+			t.Fatalf("\t%s\tLogin unverified failed.", tests.Failed)
+		}
+
+		_, err = repo.VerifyDomain(ctx, auth.Claims{}, AccountSsoVerifyDomainRequest{AccountID: acc.ID}, now)
+		if errors.Cause(err) != ErrDomainNotVerified {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrDomainNotVerified)
+			t.Fatalf("\t%s\tVerifyDomain without TXT record failed.", tests.Failed)
+		}
+
+		txtRecords = []string{"v=spf1 -all", sso.DomainTxtRecord()}
+		sso, err = repo.VerifyDomain(ctx, auth.Claims{}, AccountSsoVerifyDomainRequest{AccountID: acc.ID}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tVerifyDomain failed.", tests.Failed)
+		} else if !sso.DomainVerified() {
+			t.Logf("\t\tGot : %+v", sso)
+			t.Fatalf("\t%s\tVerifyDomain values failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerifyDomain ok.", tests.Success)
+
+		// Another account can configure the domain but can't verify it.
+		acc2, err := account.MockAccount(ctx, test.MasterDB, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tMockAccount failed.", tests.Failed)
+		}
+
+		saveReq2 := saveReq
+		saveReq2.AccountID = acc2.ID
+		saveReq2.RoleMappings = nil
+		sso2, err := repo.Save(ctx, auth.Claims{}, saveReq2, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSave other account failed.", tests.Failed)
+		}
+
+		txtRecords = []string{sso2.DomainTxtRecord()}
+		_, err = repo.VerifyDomain(ctx, auth.Claims{}, AccountSsoVerifyDomainRequest{AccountID: acc2.ID}, now)
+		if errors.Cause(err) != ErrDomainInUse {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrDomainInUse)
+			t.Fatalf("\t%s\tVerifyDomain other account failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerifyDomain other account ok.", tests.Success)
+
+		required, err = repo.SsoRequired(ctx, "someone@"+strings.ToUpper(domain))
+		if err != nil || !required {
+			t.Logf("\t\tGot : %v %+v", required, err)
+			t.Fatalf("\t%s\tSsoRequired failed.", tests.Failed)
+		}
+		required, err = repo.SsoRequired(ctx, "someone@other-"+domain)
+		if err != nil || required {
+			t.Logf("\t\tGot : %v %+v", required, err)
+			t.Fatalf("\t%s\tSsoRequired other domain failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tSsoRequired ok.", tests.Success)
+
+		md, err := repo.SpMetadata(ctx, acc.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSpMetadata failed.", tests.Failed)
+		} else if !strings.Contains(string(md), repo.AcsUrl(acc.ID)) {
+			t.Logf("\t\tGot : %s", string(md))
+			t.Fatalf("\t%s\tSpMetadata values failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tSpMetadata ok.", tests.Success)
+
+		// Use the current time since the assertion issued by the stub is validated against it.
+		st, ssoUrl, err := repo.AuthnRequestUrl(ctx, acc.ID, "/projects", time.Now())
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tAuthnRequestUrl failed.", tests.Failed)
+		} else if u, err := url.Parse(ssoUrl); err != nil || u.Query().Get("SAMLRequest") == "" || u.Query().Get("RelayState") != "/projects" {
+			t.Logf("\t\tGot : %s", ssoUrl)
+			t.Fatalf("\t%s\tAuthnRequestUrl values failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthnRequestUrl ok.", tests.Success)
+
+		email := "lee." + strings.Split(uuid.NewRandom().String(), "-")[0] + "@" + domain
+
+		res, err := idp.Response(StubResponseRequest{
+			AcsUrl:       repo.AcsUrl(acc.ID),
+			Audience:     repo.EntityID(acc.ID),
+			InResponseTo: st.ID,
+			NameID:       email,
+			Attributes: map[string][]string{
+				"firstName": {"Lee"},
+				"lastName":  {"Brown"},
+				"groups":    {"Admins"},
+			},
+			Now: time.Now(),
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tResponse failed.", tests.Failed)
+		}
+
+		a, err := repo.ParseResponse(ctx, *st, res, time.Now())
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tParseResponse failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tParseResponse ok.", tests.Success)
+
+		// The same response can't be used to login again.
+		_, err = repo.ParseResponse(ctx, *st, res, time.Now())
+		if errors.Cause(err) != ErrInvalidResponse {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidResponse)
+			t.Fatalf("\t%s\tParseResponse replayed failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tParseResponse replayed ok.", tests.Success)
+
+		// The user is created for the account with the mapped role.
+		u, err := repo.Login(ctx, acc.ID, a, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tLogin failed.", tests.Failed)
+		} else if u.Email != email || u.FirstName != "Lee" || !u.EmailVerified() {
+			t.Logf("\t\tGot : %+v", u)
+			t.Fatalf("\t%s\tLogin user failed.", tests.Failed)
+		}
+
+		ua, err := repo.UserAccount.Read(ctx, auth.Claims{}, user_account.UserAccountReadRequest{UserID: u.ID, AccountID: acc.ID})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead user account failed.", tests.Failed)
+		} else if len(ua.Roles) != 1 || ua.Roles[0] != user_account.UserAccountRole(auth.RoleAdmin) {
+			t.Logf("\t\tGot : %+v", ua.Roles)
+			t.Fatalf("\t%s\tLogin roles failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tLogin ok.", tests.Success)
+
+		// The roles are updated on the next login when the attributes change.
+		a.Attributes["groups"] = []string{"Everyone"}
+		a.Attributes["lastName"] = []string{"Green"}
+		u, err = repo.Login(ctx, acc.ID, a, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tLogin existing failed.", tests.Failed)
+		} else if u.LastName != "Green" {
+			t.Logf("\t\tGot : %+v", u)
+			t.Fatalf("\t%s\tLogin existing user failed.", tests.Failed)
+		}
+
+		ua, err = repo.UserAccount.Read(ctx, auth.Claims{}, user_account.UserAccountReadRequest{UserID: u.ID, AccountID: acc.ID})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead user account failed.", tests.Failed)
+		} else if len(ua.Roles) != 1 || ua.Roles[0] != user_account.UserAccountRole(auth.RoleUser) {
+			t.Logf("\t\tGot : %+v", ua.Roles)
+			t.Fatalf("\t%s\tLogin existing roles failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tLogin existing ok.", tests.Success)
+
+		// Existing users that are not members of the account can't login.
+		otherEmail := "sam." + strings.Split(uuid.NewRandom().String(), "-")[0] + "@" + domain
+		_, err = repo.User.Create(ctx, auth.Claims{}, user.UserCreateRequest{
+			FirstName:       "Sam",
+			LastName:        "Jones",
+			Email:           otherEmail,
+			Password:        "akTechFr0n!ier",
+			PasswordConfirm: "akTechFr0n!ier",
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate user failed.", tests.Failed)
+		}
+
+		a.NameID = otherEmail
+		delete(a.Attributes, "lastName")
+		_, err = repo.Login(ctx, acc.ID, a, now)
+		if errors.Cause(err) != ErrNotMember {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrNotMember)
+			t.Fatalf("\t%s\tLogin non-member failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tLogin non-member ok.", tests.Success)
+
+		// The identity provider can't login users for other domains.
+		a.NameID = "lee@other-" + domain
+		_, err = repo.Login(ctx, acc.ID, a, now)
+		if errors.Cause(err) != ErrDomainMismatch {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrDomainMismatch)
+			t.Fatalf("\t%s\tLogin other domain failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tLogin other domain ok.", tests.Success)
+
+		err = repo.Delete(ctx, auth.Claims{}, AccountSsoDeleteRequest{AccountID: acc.ID}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tDelete failed.", tests.Failed)
+		}
+
+		_, err = repo.Read(ctx, auth.Claims{}, acc.ID)
+		if errors.Cause(err) != ErrNotFound {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrNotFound)
+			t.Fatalf("\t%s\tRead after delete failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tDelete ok.", tests.Success)
+	}
+}
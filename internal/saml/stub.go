@@ -0,0 +1,255 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StubIdentityProvider is a minimal SAML identity provider used to test single sign-on locally without an external
+// identity provider. Every authentication request is approved for the configured user.
+type StubIdentityProvider struct {
+	EntityID   string
+	SsoUrl     string
+	NameID     string
+	Attributes map[string][]string
+
+	key  *rsa.PrivateKey
+	cert []byte
+}
+
+// StubResponseRequest defines the values of a response created by the stub identity provider.
+type StubResponseRequest struct {
+	AcsUrl       string
+	Audience     string
+	InResponseTo string
+	NameID       string
+	Attributes   map[string][]string
+	Now          time.Time
+}
+
+// NewStubIdentityProvider returns a stub identity provider with a new signing certificate.
+func NewStubIdentityProvider(entityID, ssoUrl string) (*StubIdentityProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: entityID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * 365 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &StubIdentityProvider{
+		EntityID: entityID,
+		SsoUrl:   ssoUrl,
+		key:      key,
+		cert:     cert,
+	}, nil
+}
+
+// Metadata returns the metadata of the stub identity provider that can be saved for an account.
+func (s *StubIdentityProvider) Metadata() []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<md:EntityDescriptor xmlns:md="%s" xmlns:ds="%s" entityID="%s">
+  <md:IDPSSODescriptor protocolSupportEnumeration="%s">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo><ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate></ds:X509Data></ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:NameIDFormat>%s</md:NameIDFormat>
+    <md:SingleSignOnService Binding="%s" Location="%s"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>`, nsMetadata, nsDsig, escapeAttr(s.EntityID), nsProtocol,
+		base64.StdEncoding.EncodeToString(s.cert), nameIDFormatEmail, bindingHttpRedirect, escapeAttr(s.SsoUrl)))
+}
+
+// Response returns a base64 encoded response with a signed assertion as it would be posted to the assertion
+// consumer service.
+func (s *StubIdentityProvider) Response(req StubResponseRequest) (string, error) {
+	now := req.Now.UTC()
+
+	assertionID, err := stubID()
+	if err != nil {
+		return "", err
+	}
+	responseID, err := stubID()
+	if err != nil {
+		return "", err
+	}
+
+	var attrs strings.Builder
+	var names []string
+	for n := range req.Attributes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		attrs.WriteString(`<saml:Attribute Name="` + escapeAttr(n) + `">`)
+		for _, v := range req.Attributes[n] {
+			attrs.WriteString(`<saml:AttributeValue>` + escapeText(v) + `</saml:AttributeValue>`)
+		}
+		attrs.WriteString(`</saml:Attribute>`)
+	}
+
+	issuer := `<saml:Issuer>` + escapeText(s.EntityID) + `</saml:Issuer>`
+
+	assertion := `<saml:Assertion xmlns:saml="` + nsAssertion + `" ID="` + assertionID + `" Version="2.0" IssueInstant="` + now.Format(time.RFC3339) + `">` +
+		issuer +
+		`<saml:Subject>` +
+		`<saml:NameID Format="` + nameIDFormatEmail + `">` + escapeText(req.NameID) + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="` + subjectConfirmBearer + `">` +
+		`<saml:SubjectConfirmationData InResponseTo="` + escapeAttr(req.InResponseTo) + `" NotOnOrAfter="` + now.Add(5*time.Minute).Format(time.RFC3339) + `" Recipient="` + escapeAttr(req.AcsUrl) + `"/>` +
+		`</saml:SubjectConfirmation>` +
+		`</saml:Subject>` +
+		`<saml:Conditions NotBefore="` + now.Add(-time.Minute).Format(time.RFC3339) + `" NotOnOrAfter="` + now.Add(5*time.Minute).Format(time.RFC3339) + `">` +
+		`<saml:AudienceRestriction><saml:Audience>` + escapeText(req.Audience) + `</saml:Audience></saml:AudienceRestriction>` +
+		`</saml:Conditions>` +
+		`<saml:AuthnStatement AuthnInstant="` + now.Format(time.RFC3339) + `" SessionIndex="` + assertionID + `"/>` +
+		`<saml:AttributeStatement>` + attrs.String() + `</saml:AttributeStatement>` +
+		`</saml:Assertion>`
+
+	signature, err := s.sign([]byte(assertion), assertionID)
+	if err != nil {
+		return "", err
+	}
+
+	// The signature is enveloped in the assertion right after the issuer.
+	assertion = strings.Replace(assertion, issuer, issuer+signature, 1)
+
+	response := `<samlp:Response xmlns:samlp="` + nsProtocol + `" xmlns:saml="` + nsAssertion + `" ID="` + responseID + `" Version="2.0" IssueInstant="` + now.Format(time.RFC3339) + `" Destination="` + escapeAttr(req.AcsUrl) + `" InResponseTo="` + escapeAttr(req.InResponseTo) + `">` +
+		issuer +
+		`<samlp:Status><samlp:StatusCode Value="` + statusSuccess + `"/></samlp:Status>` +
+		assertion +
+		`</samlp:Response>`
+
+	return base64.StdEncoding.EncodeToString([]byte(response)), nil
+}
+
+// sign returns the enveloped signature for the element with the ID.
+func (s *StubIdentityProvider) sign(dat []byte, id string) (string, error) {
+	el, err := parseXml(dat)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256(canonicalize(el, nil, nil))
+
+	signedInfo := `<ds:SignedInfo xmlns:ds="` + nsDsig + `">` +
+		`<ds:CanonicalizationMethod Algorithm="` + algExcC14N + `"/>` +
+		`<ds:SignatureMethod Algorithm="` + algRsaSha256 + `"/>` +
+		`<ds:Reference URI="#` + id + `">` +
+		`<ds:Transforms>` +
+		`<ds:Transform Algorithm="` + algEnveloped + `"/>` +
+		`<ds:Transform Algorithm="` + algExcC14N + `"/>` +
+		`</ds:Transforms>` +
+		`<ds:DigestMethod Algorithm="` + algDigestSha256 + `"/>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue>` +
+		`</ds:Reference>` +
+		`</ds:SignedInfo>`
+
+	si, err := parseXml([]byte(signedInfo))
+	if err != nil {
+		return "", err
+	}
+
+	hashed := sha256.Sum256(canonicalize(si, nil, nil))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	// The namespace is declared on the signature so the signed info is rendered the same once enveloped.
+	return `<ds:Signature xmlns:ds="` + nsDsig + `">` +
+		strings.Replace(signedInfo, ` xmlns:ds="`+nsDsig+`"`, "", 1) +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(sig) + `</ds:SignatureValue>` +
+		`<ds:KeyInfo><ds:X509Data><ds:X509Certificate>` + base64.StdEncoding.EncodeToString(s.cert) + `</ds:X509Certificate></ds:X509Data></ds:KeyInfo>` +
+		`</ds:Signature>`, nil
+}
+
+// stubAutoPostTmpl posts the response to the assertion consumer service.
+var stubAutoPostTmpl = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html><body onload="document.forms[0].submit()">
+<form method="post" action="{{ .AcsUrl }}">
+<input type="hidden" name="SAMLResponse" value="{{ .SAMLResponse }}">
+<input type="hidden" name="RelayState" value="{{ .RelayState }}">
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body></html>`))
+
+// ServeHTTP implements the http.Handler interface for the single sign-on URL. The authentication request is approved
+// for the configured user and the response is posted back to the service provider.
+func (s *StubIdentityProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	deflated, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("SAMLRequest"))
+	if err != nil {
+		http.Error(w, "invalid SAMLRequest", http.StatusBadRequest)
+		return
+	}
+
+	dat, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		http.Error(w, "invalid SAMLRequest", http.StatusBadRequest)
+		return
+	}
+
+	authnReq, err := parseXml(dat)
+	if err != nil || !authnReq.Is(nsProtocol, "AuthnRequest") {
+		http.Error(w, "invalid SAMLRequest", http.StatusBadRequest)
+		return
+	}
+
+	acsUrl := authnReq.Attr("AssertionConsumerServiceURL")
+
+	res, err := s.Response(StubResponseRequest{
+		AcsUrl:       acsUrl,
+		Audience:     authnReq.Child(nsAssertion, "Issuer").Text(),
+		InResponseTo: authnReq.Attr("ID"),
+		NameID:       s.NameID,
+		Attributes:   s.Attributes,
+		Now:          time.Now(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = stubAutoPostTmpl.Execute(w, map[string]string{
+		"AcsUrl":       acsUrl,
+		"SAMLResponse": res,
+		"RelayState":   r.URL.Query().Get("RelayState"),
+	})
+}
+
+// stubID returns a random ID for a SAML message.
+func stubID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return "id-" + hex.EncodeToString(b), nil
+}
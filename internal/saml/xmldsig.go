@@ -0,0 +1,423 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// XML namespaces and algorithms used to verify signatures.
+const (
+	nsXml   = "http://www.w3.org/XML/1998/namespace"
+	nsDsig  = "http://www.w3.org/2000/09/xmldsig#"
+	nsExcNs = "http://www.w3.org/2001/10/xml-exc-c14n#"
+
+	algExcC14N      = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnveloped    = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algRsaSha256    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algDigestSha256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+)
+
+// ErrInvalidSignature occurs when the signature of a SAML message is missing or can not be verified.
+var ErrInvalidSignature = errors.New("Invalid signature")
+
+// element is a node of a parsed XML document. The prefixes are kept as they appear in the document so the element can
+// be canonicalized to verify signatures.
+type element struct {
+	Prefix   string
+	Local    string
+	Attrs    []xml.Attr
+	NsDecls  map[string]string
+	Children []interface{}
+	Parent   *element
+}
+
+// parseXml parses the XML document into a tree of elements. Documents with a DTD are rejected.
+func parseXml(dat []byte) (*element, error) {
+	dec := xml.NewDecoder(bytes.NewReader(dat))
+
+	var root, cur *element
+	for {
+		tkn, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		switch t := tkn.(type) {
+		case xml.StartElement:
+			el := &element{
+				Prefix:  t.Name.Space,
+				Local:   t.Name.Local,
+				NsDecls: make(map[string]string),
+				Parent:  cur,
+			}
+			for _, a := range t.Attr {
+				if a.Name.Space == "" && a.Name.Local == "xmlns" {
+					el.NsDecls[""] = a.Value
+				} else if a.Name.Space == "xmlns" {
+					el.NsDecls[a.Name.Local] = a.Value
+				} else {
+					el.Attrs = append(el.Attrs, a)
+				}
+			}
+
+			if cur == nil {
+				if root != nil {
+					return nil, errors.New("xml document has multiple root elements")
+				}
+				root = el
+			} else {
+				cur.Children = append(cur.Children, el)
+			}
+			cur = el
+		case xml.EndElement:
+			if cur == nil || cur.Prefix != t.Name.Space || cur.Local != t.Name.Local {
+				return nil, errors.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			cur = cur.Parent
+		case xml.CharData:
+			if cur != nil {
+				cur.Children = append(cur.Children, string(t))
+			}
+		case xml.Directive:
+			return nil, errors.New("xml document with a DTD is not supported")
+		}
+	}
+
+	if root == nil || cur != nil {
+		return nil, errors.New("xml document is incomplete")
+	}
+
+	return root, nil
+}
+
+// lookupNs returns the namespace URI for the prefix in scope for the element.
+func (e *element) lookupNs(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return nsXml, true
+	}
+	for n := e; n != nil; n = n.Parent {
+		if uri, ok := n.NsDecls[prefix]; ok {
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+// Space returns the namespace URI of the element.
+func (e *element) Space() string {
+	uri, _ := e.lookupNs(e.Prefix)
+	return uri
+}
+
+// Is returns true when the element has the namespace and local name.
+func (e *element) Is(space, local string) bool {
+	return e != nil && e.Local == local && e.Space() == space
+}
+
+// Attr returns the value of the attribute without a namespace.
+func (e *element) Attr(local string) string {
+	if e == nil {
+		return ""
+	}
+	for _, a := range e.Attrs {
+		if a.Name.Space == "" && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// ChildElements returns the child elements with the namespace and local name.
+func (e *element) ChildElements(space, local string) []*element {
+	var l []*element
+	if e == nil {
+		return l
+	}
+	for _, c := range e.Children {
+		if ce, ok := c.(*element); ok && ce.Is(space, local) {
+			l = append(l, ce)
+		}
+	}
+	return l
+}
+
+// Child returns the first child element with the namespace and local name.
+func (e *element) Child(space, local string) *element {
+	if l := e.ChildElements(space, local); len(l) > 0 {
+		return l[0]
+	}
+	return nil
+}
+
+// Text returns the text content of the element.
+func (e *element) Text() string {
+	if e == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, c := range e.Children {
+		switch v := c.(type) {
+		case string:
+			b.WriteString(v)
+		case *element:
+			b.WriteString(v.Text())
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// countAttr returns the number of elements in the tree with the attribute value, used to reject documents where the
+// referenced ID is not unique.
+func (e *element) countAttr(local, value string) int {
+	var n int
+	if e.Attr(local) == value {
+		n++
+	}
+	for _, c := range e.Children {
+		if ce, ok := c.(*element); ok {
+			n += ce.countAttr(local, value)
+		}
+	}
+	return n
+}
+
+// canonicalize returns the exclusive XML canonicalization without comments of the element. The excluded element and
+// its descendants are omitted, which is used to apply the enveloped signature transform.
+func canonicalize(e, exclude *element, inclusivePrefixes []string) []byte {
+	var buf bytes.Buffer
+	writeCanonical(&buf, e, exclude, map[string]string{}, inclusivePrefixes)
+	return buf.Bytes()
+}
+
+func writeCanonical(buf *bytes.Buffer, e, exclude *element, rendered map[string]string, inclusivePrefixes []string) {
+	if e == exclude {
+		return
+	}
+
+	// Only the namespaces visibly utilized by the element and its attributes are rendered, plus the prefixes listed
+	// as inclusive.
+	used := map[string]bool{e.Prefix: true}
+	for _, a := range e.Attrs {
+		if a.Name.Space != "" && a.Name.Space != "xml" {
+			used[a.Name.Space] = true
+		}
+	}
+	for _, p := range inclusivePrefixes {
+		if p == "#default" {
+			p = ""
+		}
+		if _, ok := e.lookupNs(p); ok {
+			used[p] = true
+		}
+	}
+
+	scope := make(map[string]string, len(rendered))
+	for k, v := range rendered {
+		scope[k] = v
+	}
+
+	var prefixes []string
+	for p := range used {
+		uri, _ := e.lookupNs(p)
+		if prev, ok := scope[p]; (ok && prev == uri) || (!ok && p == "" && uri == "") {
+			continue
+		}
+		scope[p] = uri
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	attrs := make([]xml.Attr, len(e.Attrs))
+	copy(attrs, e.Attrs)
+	attrNs := func(a xml.Attr) string {
+		if a.Name.Space == "" {
+			return ""
+		}
+		uri, _ := e.lookupNs(a.Name.Space)
+		return uri
+	}
+	sort.SliceStable(attrs, func(i, j int) bool {
+		ni, nj := attrNs(attrs[i]), attrNs(attrs[j])
+		if ni != nj {
+			return ni < nj
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+
+	name := qualifiedName(e.Prefix, e.Local)
+
+	buf.WriteString("<" + name)
+	for _, p := range prefixes {
+		if p == "" {
+			buf.WriteString(` xmlns="` + escapeAttr(scope[p]) + `"`)
+		} else {
+			buf.WriteString(` xmlns:` + p + `="` + escapeAttr(scope[p]) + `"`)
+		}
+	}
+	for _, a := range attrs {
+		buf.WriteString(" " + qualifiedName(a.Name.Space, a.Name.Local) + `="` + escapeAttr(a.Value) + `"`)
+	}
+	buf.WriteString(">")
+
+	for _, c := range e.Children {
+		switch v := c.(type) {
+		case string:
+			buf.WriteString(escapeText(v))
+		case *element:
+			writeCanonical(buf, v, exclude, scope, inclusivePrefixes)
+		}
+	}
+
+	buf.WriteString("</" + name + ">")
+}
+
+func qualifiedName(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
+
+// inclusivePrefixList returns the prefixes of the InclusiveNamespaces element of a transform.
+func inclusivePrefixList(e *element) []string {
+	if in := e.Child(nsExcNs, "InclusiveNamespaces"); in != nil {
+		return strings.Fields(in.Attr("PrefixList"))
+	}
+	return nil
+}
+
+// verifySignature verifies the enveloped signature of the element was created by one of the certificates. Only
+// signatures that reference the element itself are accepted so the signed content can not be moved.
+func verifySignature(root, e *element, certs []*x509.Certificate) error {
+	sig := e.Child(nsDsig, "Signature")
+	if sig == nil {
+		return errors.WithMessagef(ErrInvalidSignature, "%s is not signed", e.Local)
+	}
+
+	id := e.Attr("ID")
+	if id == "" {
+		return errors.WithMessagef(ErrInvalidSignature, "%s has no ID", e.Local)
+	} else if root.countAttr("ID", id) != 1 {
+		return errors.WithMessagef(ErrInvalidSignature, "ID %s is not unique", id)
+	}
+
+	signedInfo := sig.Child(nsDsig, "SignedInfo")
+	if signedInfo == nil {
+		return errors.WithMessage(ErrInvalidSignature, "SignedInfo is missing")
+	}
+
+	c14nMethod := signedInfo.Child(nsDsig, "CanonicalizationMethod")
+	if c14nMethod.Attr("Algorithm") != algExcC14N {
+		return errors.WithMessagef(ErrInvalidSignature, "canonicalization %s is not supported", c14nMethod.Attr("Algorithm"))
+	}
+
+	// SHA-1 is not accepted for either the signature or the digest since collisions can be computed for it.
+	var sigHash crypto.Hash
+	switch signedInfo.Child(nsDsig, "SignatureMethod").Attr("Algorithm") {
+	case algRsaSha256:
+		sigHash = crypto.SHA256
+	default:
+		return errors.WithMessagef(ErrInvalidSignature, "signature method %s is not supported", signedInfo.Child(nsDsig, "SignatureMethod").Attr("Algorithm"))
+	}
+
+	refs := signedInfo.ChildElements(nsDsig, "Reference")
+	if len(refs) != 1 {
+		return errors.WithMessage(ErrInvalidSignature, "exactly one reference is required")
+	} else if refs[0].Attr("URI") != "#"+id {
+		return errors.WithMessagef(ErrInvalidSignature, "reference %s does not match ID %s", refs[0].Attr("URI"), id)
+	}
+	ref := refs[0]
+
+	var (
+		enveloped         bool
+		inclusivePrefixes []string
+	)
+	for _, t := range ref.Child(nsDsig, "Transforms").ChildElements(nsDsig, "Transform") {
+		switch t.Attr("Algorithm") {
+		case algEnveloped:
+			enveloped = true
+		case algExcC14N:
+			inclusivePrefixes = inclusivePrefixList(t)
+		default:
+			return errors.WithMessagef(ErrInvalidSignature, "transform %s is not supported", t.Attr("Algorithm"))
+		}
+	}
+	if !enveloped {
+		return errors.WithMessage(ErrInvalidSignature, "enveloped signature transform is required")
+	}
+
+	var digest []byte
+	switch ref.Child(nsDsig, "DigestMethod").Attr("Algorithm") {
+	case algDigestSha256:
+		h := sha256.Sum256(canonicalize(e, sig, inclusivePrefixes))
+		digest = h[:]
+	default:
+		return errors.WithMessagef(ErrInvalidSignature, "digest method %s is not supported", ref.Child(nsDsig, "DigestMethod").Attr("Algorithm"))
+	}
+
+	expectedDigest, err := decodeBase64(ref.Child(nsDsig, "DigestValue").Text())
+	if err != nil {
+		return errors.WithMessage(ErrInvalidSignature, "invalid digest value")
+	} else if subtle.ConstantTimeCompare(digest, expectedDigest) != 1 {
+		return errors.WithMessage(ErrInvalidSignature, "digest does not match")
+	}
+
+	sigValue, err := decodeBase64(sig.Child(nsDsig, "SignatureValue").Text())
+	if err != nil {
+		return errors.WithMessage(ErrInvalidSignature, "invalid signature value")
+	}
+
+	h := sigHash.New()
+	h.Write(canonicalize(signedInfo, nil, inclusivePrefixList(c14nMethod)))
+	hashed := h.Sum(nil)
+
+	for _, cert := range certs {
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if err := rsa.VerifyPKCS1v15(pub, sigHash, hashed, sigValue); err == nil {
+			return nil
+		}
+	}
+
+	return errors.WithMessage(ErrInvalidSignature, "signature not created by a trusted certificate")
+}
+
+// decodeBase64 decodes base64 that can contain whitespace as is common in XML documents.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '\r':
+			return -1
+		}
+		return r
+	}, s)
+	return base64.StdEncoding.DecodeString(s)
+}
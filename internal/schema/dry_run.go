@@ -0,0 +1,169 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// newDryRunDB returns a database connection that writes the queries to w instead of executing them. Queries return
+// no rows and statements don't affect any rows.
+func newDryRunDB(w io.Writer) *sqlx.DB {
+	db := sql.OpenDB(&dryRunConnector{w: w})
+
+	// Use the postgres bind type so the queries are written as they would be executed.
+	return sqlx.NewDb(db, "postgres")
+}
+
+// dryRunConnector implements driver.Connector for the dry run connection.
+type dryRunConnector struct {
+	w io.Writer
+}
+
+// Connect returns a new dry run connection.
+func (c *dryRunConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &dryRunConn{w: c.w}, nil
+}
+
+// Driver returns the driver of the connector.
+func (c *dryRunConnector) Driver() driver.Driver {
+	return dryRunDriver{c}
+}
+
+// dryRunDriver implements driver.Driver for the dry run connection.
+type dryRunDriver struct {
+	c *dryRunConnector
+}
+
+// Open returns a new dry run connection.
+func (d dryRunDriver) Open(name string) (driver.Conn, error) {
+	return d.c.Connect(context.Background())
+}
+
+// dryRunConn implements driver.Conn and writes the queries instead of executing them.
+type dryRunConn struct {
+	w io.Writer
+}
+
+// Prepare returns a statement for the query.
+func (c *dryRunConn) Prepare(query string) (driver.Stmt, error) {
+	return &dryRunStmt{conn: c, query: query}, nil
+}
+
+// Close does nothing as there is no connection to the database.
+func (c *dryRunConn) Close() error {
+	return nil
+}
+
+// Begin starts a transaction that does nothing when committed or rolled back.
+func (c *dryRunConn) Begin() (driver.Tx, error) {
+	return dryRunTx{}, nil
+}
+
+// ExecContext writes the query.
+func (c *dryRunConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.write(query, args)
+	return driver.RowsAffected(0), nil
+}
+
+// QueryContext writes the query and returns no rows.
+func (c *dryRunConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.write(query, args)
+	return dryRunRows{}, nil
+}
+
+// write writes the query with the args as a comment.
+func (c *dryRunConn) write(query string, args []driver.NamedValue) {
+	query = strings.TrimSpace(query)
+	if !strings.HasSuffix(query, ";") {
+		query += ";"
+	}
+
+	if len(args) > 0 {
+		var vals []string
+		for _, a := range args {
+			switch v := a.Value.(type) {
+			case string:
+				vals = append(vals, fmt.Sprintf("$%d = '%s'", a.Ordinal, v))
+			case []byte:
+				vals = append(vals, fmt.Sprintf("$%d = '%s'", a.Ordinal, string(v)))
+			default:
+				vals = append(vals, fmt.Sprintf("$%d = %v", a.Ordinal, v))
+			}
+		}
+		query += " -- " + strings.Join(vals, ", ")
+	}
+
+	fmt.Fprintln(c.w, query)
+}
+
+// dryRunStmt implements driver.Stmt for the dry run connection.
+type dryRunStmt struct {
+	conn  *dryRunConn
+	query string
+}
+
+// Close does nothing.
+func (s *dryRunStmt) Close() error {
+	return nil
+}
+
+// NumInput returns -1 so the number of args is not checked.
+func (s *dryRunStmt) NumInput() int {
+	return -1
+}
+
+// Exec writes the query.
+func (s *dryRunStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+// Query writes the query and returns no rows.
+func (s *dryRunStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+// namedValues converts the args to named values.
+func namedValues(args []driver.Value) []driver.NamedValue {
+	resp := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		resp[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return resp
+}
+
+// dryRunTx implements driver.Tx for the dry run connection.
+type dryRunTx struct{}
+
+// Commit does nothing.
+func (dryRunTx) Commit() error {
+	return nil
+}
+
+// Rollback does nothing.
+func (dryRunTx) Rollback() error {
+	return nil
+}
+
+// dryRunRows implements driver.Rows with no rows.
+type dryRunRows struct{}
+
+// Columns returns no columns.
+func (dryRunRows) Columns() []string {
+	return []string{}
+}
+
+// Close does nothing.
+func (dryRunRows) Close() error {
+	return nil
+}
+
+// Next always returns io.EOF as there are no rows.
+func (dryRunRows) Next(dest []driver.Value) error {
+	return io.EOF
+}
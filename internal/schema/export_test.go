@@ -0,0 +1,7 @@
+package schema
+
+// SQLMigrationFiles exports the embedded SQL files for testing.
+var SQLMigrationFiles = sqlMigrationFiles
+
+// LoadSQLMigrations exports loadSQLMigrations for testing.
+var LoadSQLMigrations = loadSQLMigrations
@@ -0,0 +1,16 @@
+-- Create table users.
+
+CREATE TABLE IF NOT EXISTS users (
+    id char(36) NOT NULL,
+    email varchar(200) NOT NULL,
+    name varchar(200) NOT NULL DEFAULT '',
+    password_hash varchar(256) NOT NULL,
+    password_salt varchar(36) NOT NULL,
+    password_reset varchar(36) DEFAULT NULL,
+    timezone varchar(128) NOT NULL DEFAULT 'America/Anchorage',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT email UNIQUE  (email)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS accounts;
+
+DROP TYPE IF EXISTS account_status_t;
@@ -0,0 +1,23 @@
+-- Create new table accounts.
+
+CREATE TYPE account_status_t as enum('active','pending','disabled');
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id char(36) NOT NULL,
+    name varchar(255) NOT NULL,
+    address1 varchar(255) NOT NULL DEFAULT '',
+    address2 varchar(255) NOT NULL DEFAULT '',
+    city varchar(100) NOT NULL DEFAULT '',
+    region varchar(255) NOT NULL DEFAULT '',
+    country varchar(255) NOT NULL DEFAULT '',
+    zipcode varchar(20) NOT NULL DEFAULT '',
+    status account_status_t NOT NULL DEFAULT 'active',
+    timezone varchar(128) NOT NULL DEFAULT 'America/Anchorage',
+    signup_user_id char(36) DEFAULT NULL REFERENCES users(id) ON DELETE SET NULL,
+    billing_user_id char(36) DEFAULT NULL REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT name UNIQUE  (name)
+);
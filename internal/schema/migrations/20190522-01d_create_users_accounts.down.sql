@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS users_accounts;
+
+DROP TYPE IF EXISTS user_account_role_t;
+
+DROP TYPE IF EXISTS user_account_status_t;
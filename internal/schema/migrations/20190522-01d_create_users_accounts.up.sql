@@ -0,0 +1,18 @@
+-- Create new table user_accounts.
+
+CREATE TYPE user_account_role_t as enum('admin', 'user');
+
+CREATE TYPE user_account_status_t as enum('active', 'invited','disabled');
+
+CREATE TABLE IF NOT EXISTS users_accounts (
+    id char(36) NOT NULL,
+    account_id char(36) NOT NULL  REFERENCES accounts(id) ON DELETE NO ACTION,
+    user_id char(36) NOT NULL  REFERENCES users(id) ON DELETE NO ACTION,
+    roles user_account_role_t[] NOT NULL,
+    status user_account_status_t NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT user_account UNIQUE (user_id,account_id)
+);
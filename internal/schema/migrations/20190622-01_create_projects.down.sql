@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS projects;
+
+DROP TYPE IF EXISTS project_status_t;
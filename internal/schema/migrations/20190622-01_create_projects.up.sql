@@ -0,0 +1,14 @@
+-- Create new table projects.
+
+CREATE TYPE project_status_t as enum('active','disabled');
+
+CREATE TABLE IF NOT EXISTS projects (
+    id char(36) NOT NULL,
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE SET NULL,
+    name varchar(255) NOT NULL,
+    status project_status_t NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS last_name;
+
+ALTER TABLE users
+    RENAME COLUMN first_name to name;
@@ -0,0 +1,7 @@
+-- Split users.name into first_name and last_name columns.
+
+ALTER TABLE users
+    RENAME COLUMN name to first_name;
+
+ALTER TABLE users
+    ADD last_name varchar(200) NOT NULL DEFAULT '';
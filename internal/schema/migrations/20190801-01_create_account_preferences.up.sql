@@ -0,0 +1,11 @@
+-- Create new table account_preferences.
+
+CREATE TABLE IF NOT EXISTS account_preferences (
+    account_id char(36) NOT NULL  REFERENCES accounts(id) ON DELETE NO ACTION,
+    name varchar(200) NOT NULL DEFAULT '',
+    value varchar(200) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    CONSTRAINT account_preferences_pkey UNIQUE (account_id,name)
+);
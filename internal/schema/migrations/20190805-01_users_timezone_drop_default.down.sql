@@ -0,0 +1,6 @@
+-- Users without a timezone get the previous default.
+UPDATE users SET timezone = 'America/Anchorage' WHERE timezone IS NULL;
+
+ALTER TABLE users ALTER COLUMN timezone SET NOT NULL;
+
+ALTER TABLE users ALTER COLUMN timezone SET DEFAULT 'America/Anchorage';
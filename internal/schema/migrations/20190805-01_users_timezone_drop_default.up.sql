@@ -0,0 +1,5 @@
+-- Remove default value for users.timezone.
+
+ALTER TABLE users ALTER COLUMN timezone DROP DEFAULT;
+
+ALTER TABLE users ALTER COLUMN timezone DROP NOT NULL;
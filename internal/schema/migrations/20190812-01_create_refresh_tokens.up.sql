@@ -0,0 +1,23 @@
+-- Create new table refresh_tokens.
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id char(36) NOT NULL,
+    session_id char(36) NOT NULL,
+    replaced_by_id char(36) DEFAULT NULL,
+    user_id char(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    root_user_id char(36) DEFAULT NULL,
+    root_account_id char(36) DEFAULT NULL,
+    token_hash varchar(64) NOT NULL,
+    scopes varchar(200)[] DEFAULT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT refresh_tokens_token_hash UNIQUE (token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens (session_id);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS user_mfa_recovery_codes;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS mfa_secret,
+    DROP COLUMN IF EXISTS mfa_enabled_at,
+    DROP COLUMN IF EXISTS mfa_last_step,
+    DROP COLUMN IF EXISTS mfa_challenge_id,
+    DROP COLUMN IF EXISTS mfa_challenge_attempts;
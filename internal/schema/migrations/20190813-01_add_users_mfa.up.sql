@@ -0,0 +1,18 @@
+-- Add multi-factor authentication to users and create new table user_mfa_recovery_codes.
+
+ALTER TABLE users
+    ADD COLUMN mfa_secret varchar(256) DEFAULT NULL,
+    ADD COLUMN mfa_enabled_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    ADD COLUMN mfa_last_step bigint DEFAULT NULL,
+    ADD COLUMN mfa_challenge_id char(36) DEFAULT NULL,
+    ADD COLUMN mfa_challenge_attempts integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS user_mfa_recovery_codes (
+    id char(36) NOT NULL,
+    user_id char(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash varchar(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT user_mfa_recovery_codes_code UNIQUE (user_id,code_hash)
+);
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS account_roles;
+
+CREATE TYPE user_account_role_t as enum('admin', 'user');
+
+-- Custom roles can't be represented by the enum, fall back to the built-in role user.
+UPDATE users_accounts SET roles = array(
+    select case when r in ('admin', 'user') then r else 'user' end from unnest(roles) r);
+
+ALTER TABLE users_accounts ALTER COLUMN roles TYPE user_account_role_t[] USING roles::text[]::user_account_role_t[];
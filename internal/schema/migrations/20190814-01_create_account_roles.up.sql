@@ -0,0 +1,19 @@
+-- Replace the user_account_role_t enum with varchar so accounts can define custom roles and create new table
+-- account_roles.
+
+ALTER TABLE users_accounts ALTER COLUMN roles TYPE varchar(200)[] USING roles::varchar(200)[];
+
+DROP TYPE IF EXISTS user_account_role_t;
+
+CREATE TABLE IF NOT EXISTS account_roles (
+    id char(36) NOT NULL,
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    name varchar(200) NOT NULL,
+    description varchar(255) NOT NULL DEFAULT '',
+    permissions varchar(200)[] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT account_roles_name UNIQUE (account_id,name)
+);
@@ -0,0 +1,19 @@
+-- Create new table audit_events.
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id char(36) NOT NULL,
+    account_id char(36) DEFAULT NULL,
+    actor_user_id char(36) DEFAULT NULL,
+    root_user_id char(36) DEFAULT NULL,
+    entity_type varchar(50) NOT NULL,
+    entity_id varchar(200) NOT NULL,
+    action varchar(50) NOT NULL,
+    diff jsonb DEFAULT NULL,
+    request_ip varchar(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_account_id_created_at ON audit_events (account_id, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_entity ON audit_events (entity_type, entity_id);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+
+DROP TYPE webhook_delivery_status_t;
+
+DROP TABLE IF EXISTS webhooks;
+
+DROP TYPE webhook_status_t;
@@ -0,0 +1,40 @@
+-- Create new tables webhooks and webhook_deliveries.
+
+CREATE TYPE webhook_status_t as enum('active','disabled');
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id char(36) NOT NULL,
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    url varchar(2000) NOT NULL,
+    description varchar(255) NOT NULL DEFAULT '',
+    secret varchar(200) NOT NULL,
+    event_types varchar(200)[] NOT NULL,
+    status webhook_status_t NOT NULL DEFAULT 'active',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE TYPE webhook_delivery_status_t as enum('pending','succeeded','failed');
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id char(36) NOT NULL,
+    webhook_id char(36) NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    account_id char(36) NOT NULL,
+    event_type varchar(200) NOT NULL,
+    payload jsonb NOT NULL,
+    status webhook_delivery_status_t NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    response_status integer DEFAULT NULL,
+    response_body text NOT NULL DEFAULT '',
+    error text NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status_next_attempt_at ON webhook_deliveries (status, next_attempt_at);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id_created_at ON webhook_deliveries (webhook_id, created_at);
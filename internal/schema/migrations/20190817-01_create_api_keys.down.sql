@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS api_keys;
+
+DROP TYPE api_key_type_t;
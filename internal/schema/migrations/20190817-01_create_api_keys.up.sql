@@ -0,0 +1,24 @@
+-- Create new table api_keys.
+
+CREATE TYPE api_key_type_t as enum('personal','account');
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id char(36) NOT NULL,
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    user_id char(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type api_key_type_t NOT NULL DEFAULT 'personal',
+    name varchar(200) NOT NULL,
+    prefix varchar(20) NOT NULL,
+    key_hash varchar(64) NOT NULL,
+    roles varchar(200)[] NOT NULL,
+    scopes varchar(200)[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    last_used_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT api_keys_prefix UNIQUE (prefix)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_account_id ON api_keys (account_id);
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS billing_events;
+
+DROP TABLE IF EXISTS invoices;
+
+DROP TYPE invoice_status_t;
+
+DROP TABLE IF EXISTS subscriptions;
+
+DROP TYPE subscription_status_t;
@@ -0,0 +1,53 @@
+-- Create new tables for billing subscriptions and invoices.
+
+CREATE TYPE subscription_status_t as enum('trialing','active','past_due','unpaid','canceled','incomplete');
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id char(36) NOT NULL,
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    plan_id varchar(50) NOT NULL,
+    status subscription_status_t NOT NULL,
+    seats integer NOT NULL DEFAULT 1,
+    provider_customer_id varchar(255) NOT NULL,
+    provider_subscription_id varchar(255) NOT NULL,
+    trial_ends_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    current_period_end TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    cancel_at_period_end boolean NOT NULL DEFAULT false,
+    canceled_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT subscriptions_provider_subscription_id UNIQUE (provider_subscription_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_account_id ON subscriptions (account_id);
+
+CREATE TYPE invoice_status_t as enum('draft','open','paid','uncollectible','void');
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id char(36) NOT NULL,
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    subscription_id char(36) NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    provider_invoice_id varchar(255) NOT NULL,
+    number varchar(100) NOT NULL DEFAULT '',
+    status invoice_status_t NOT NULL,
+    amount_due bigint NOT NULL DEFAULT 0,
+    amount_paid bigint NOT NULL DEFAULT 0,
+    currency varchar(3) NOT NULL,
+    hosted_url varchar(2000) NOT NULL DEFAULT '',
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT invoices_provider_invoice_id UNIQUE (provider_invoice_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_invoices_account_id ON invoices (account_id);
+
+CREATE TABLE IF NOT EXISTS billing_events (
+    id varchar(255) NOT NULL,
+    type varchar(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (id)
+);
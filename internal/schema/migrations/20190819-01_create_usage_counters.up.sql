@@ -0,0 +1,10 @@
+-- Create new table usage_counters for metered usage of plan limits.
+
+CREATE TABLE IF NOT EXISTS usage_counters (
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    metric varchar(100) NOT NULL,
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    count integer NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (account_id, metric, period_start)
+);
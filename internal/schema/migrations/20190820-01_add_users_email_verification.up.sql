@@ -0,0 +1,9 @@
+-- Add email verification to users.
+
+ALTER TABLE users
+    ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    ADD COLUMN email_verify varchar(36) DEFAULT NULL,
+    ADD COLUMN email_pending varchar(200) DEFAULT NULL;
+
+-- Users that existed before email verification was added are treated as verified so they can still sign in.
+UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
@@ -0,0 +1,15 @@
+-- Create new table user_identities to link users to identities from OpenID Connect providers.
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id char(36) NOT NULL,
+    user_id char(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider varchar(50) NOT NULL,
+    subject varchar(255) NOT NULL,
+    email varchar(200) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT user_identities_provider_subject UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities (user_id);
@@ -0,0 +1,20 @@
+-- Create new table account_sso to store the SAML identity provider configured for an account.
+
+CREATE TABLE IF NOT EXISTS account_sso (
+    id char(36) NOT NULL,
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    idp_entity_id varchar(255) NOT NULL,
+    idp_sso_url varchar(2000) NOT NULL,
+    idp_certificates text[] NOT NULL,
+    idp_metadata text NOT NULL DEFAULT '',
+    domain varchar(255) NOT NULL,
+    enforced bool NOT NULL DEFAULT false,
+    role_attribute varchar(255) NOT NULL DEFAULT '',
+    role_mappings jsonb NOT NULL DEFAULT '{}',
+    default_role varchar(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT account_sso_account_id UNIQUE (account_id),
+    CONSTRAINT account_sso_domain UNIQUE (domain)
+);
@@ -0,0 +1,29 @@
+-- Create new table user_sessions to track the active sessions of users with the device they were started from.
+
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id char(36) NOT NULL,
+    user_id char(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    root_user_id char(36) DEFAULT NULL,
+    ip_address varchar(45) NOT NULL DEFAULT '',
+    user_agent varchar(512) NOT NULL DEFAULT '',
+    device varchar(200) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions (user_id);
+
+-- Sessions started before this migration only exist as refresh tokens, create an entry for each of them so they can be
+-- listed and revoked.
+INSERT INTO user_sessions (id, user_id, account_id, root_user_id, created_at, last_seen_at, revoked_at)
+    SELECT session_id,
+        (array_agg(user_id ORDER BY created_at DESC))[1],
+        (array_agg(account_id ORDER BY created_at DESC))[1],
+        (array_agg(root_user_id ORDER BY created_at DESC))[1],
+        min(created_at), max(created_at), max(revoked_at)
+    FROM refresh_tokens
+    GROUP BY session_id
+    ON CONFLICT (id) DO NOTHING;
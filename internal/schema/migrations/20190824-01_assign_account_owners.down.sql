@@ -0,0 +1 @@
+UPDATE users_accounts SET roles = array_remove(roles, 'owner'::varchar(200)) WHERE 'owner' = ANY (roles);
@@ -0,0 +1,13 @@
+-- Assign the owner role to a single admin of each account, either the user that signed up the account or the admin that
+-- has been active for the account the longest.
+
+UPDATE users_accounts ua SET roles = array_prepend('owner'::varchar(200), ua.roles)
+    FROM (
+        SELECT DISTINCT ON (ua.account_id) ua.account_id, ua.user_id
+        FROM users_accounts ua
+        JOIN accounts a ON a.id = ua.account_id
+        WHERE ua.status = 'active' AND ua.archived_at IS NULL AND 'admin' = ANY (ua.roles)
+        ORDER BY ua.account_id, COALESCE(ua.user_id = a.signup_user_id, false) DESC, ua.created_at ASC
+    ) o
+    WHERE ua.account_id = o.account_id AND ua.user_id = o.user_id
+        AND NOT EXISTS (SELECT 1 FROM users_accounts ea WHERE ea.account_id = ua.account_id AND 'owner' = ANY (ea.roles));
@@ -0,0 +1,9 @@
+-- Closed accounts and users are purged once the grace period to restore them has passed.
+
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS purge_at TIMESTAMP WITH TIME ZONE DEFAULT NULL;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS purge_at TIMESTAMP WITH TIME ZONE DEFAULT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_accounts_purge_at ON accounts (purge_at) WHERE purge_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_purge_at ON users (purge_at) WHERE purge_at IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS jobs;
+
+DROP TYPE job_status_t;
@@ -0,0 +1,21 @@
+-- Create new table jobs for the background job queue run by the worker.
+
+CREATE TYPE job_status_t as enum('pending','running','succeeded','dead');
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id char(36) NOT NULL,
+    type varchar(200) NOT NULL,
+    payload jsonb NOT NULL DEFAULT '{}',
+    status job_status_t NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    unique_key varchar(255) DEFAULT NULL,
+    error text NOT NULL DEFAULT '',
+    run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT jobs_unique_key UNIQUE (unique_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_jobs_status_run_at ON jobs (status, run_at);
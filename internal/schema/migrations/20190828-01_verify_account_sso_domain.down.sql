@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS idx_account_sso_verified_domain;
+
+-- Unverified configurations for a domain that is also used by another account are removed, keeping the verified or the
+-- oldest one.
+DELETE FROM account_sso a USING account_sso b WHERE a.domain = b.domain AND a.id <> b.id AND a.domain_verified_at IS NULL
+    AND (b.domain_verified_at IS NOT NULL OR a.created_at > b.created_at);
+
+ALTER TABLE account_sso ADD CONSTRAINT account_sso_domain UNIQUE (domain);
+
+ALTER TABLE account_sso DROP COLUMN IF EXISTS domain_verified_at;
+
+ALTER TABLE account_sso DROP COLUMN IF EXISTS domain_token;
@@ -0,0 +1,13 @@
+-- The domain of a single sign-on configuration must be verified with a DNS TXT record before it can be used to login
+-- or to enforce single sign-on. Existing configurations are given a token and must be verified again.
+
+ALTER TABLE account_sso ADD COLUMN IF NOT EXISTS domain_token varchar(64) NOT NULL DEFAULT '';
+
+ALTER TABLE account_sso ADD COLUMN IF NOT EXISTS domain_verified_at TIMESTAMP WITH TIME ZONE DEFAULT NULL;
+
+UPDATE account_sso SET domain_token = md5(random()::text || id) WHERE domain_token = '';
+
+-- Only a verified domain is claimed, otherwise any account could block the owner of the domain from using it.
+ALTER TABLE account_sso DROP CONSTRAINT IF EXISTS account_sso_domain;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_account_sso_verified_domain ON account_sso (domain) WHERE domain_verified_at IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Users created by SCIM are linked to the account that provisioned them. Only that account or an account that has
+-- verified the domain of the email address can change the name and email address of the user.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS provisioned_account_id char(36) DEFAULT NULL REFERENCES accounts(id) ON DELETE SET NULL;
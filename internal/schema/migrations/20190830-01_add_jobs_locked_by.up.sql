@@ -0,0 +1,4 @@
+-- Each reservation of a job is identified so a worker that runs a job past its lease can't overwrite the result of
+-- the worker that picked the job up again.
+
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS locked_by char(36) DEFAULT NULL;
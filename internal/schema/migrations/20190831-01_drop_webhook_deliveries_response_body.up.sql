@@ -0,0 +1,4 @@
+-- The response body of the webhook endpoints is no longer stored, it would allow the delivery log to be used to read
+-- the responses of services the endpoint points to.
+
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS response_body;
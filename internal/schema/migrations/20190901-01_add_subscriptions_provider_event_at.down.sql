@@ -0,0 +1 @@
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS provider_event_at;
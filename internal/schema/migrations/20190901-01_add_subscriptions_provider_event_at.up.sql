@@ -0,0 +1,4 @@
+-- The time of the latest provider event applied to a subscription. The provider doesn't send events in order, events
+-- older than the one applied are ignored.
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS provider_event_at TIMESTAMP WITH TIME ZONE DEFAULT NULL;
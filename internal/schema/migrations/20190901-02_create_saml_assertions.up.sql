@@ -0,0 +1,12 @@
+-- Create new table saml_assertions with the IDs of the SAML assertions used to login, so a captured response can't be
+-- replayed. Rows are removed once the assertion has expired.
+
+CREATE TABLE IF NOT EXISTS saml_assertions (
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    id varchar(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (account_id, id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_saml_assertions_expires_at ON saml_assertions (expires_at);
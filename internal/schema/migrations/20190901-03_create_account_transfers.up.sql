@@ -0,0 +1,14 @@
+-- Create new table account_transfers with the digests of the ownership transfer hashes that have been accepted, so a
+-- hash can't be used again after the ownership changed back. Rows are removed once the hash has expired.
+
+CREATE TABLE IF NOT EXISTS account_transfers (
+    id char(64) NOT NULL,
+    account_id char(36) NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+    from_user_id char(36) NOT NULL,
+    to_user_id char(36) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_transfers_expires_at ON account_transfers (expires_at);
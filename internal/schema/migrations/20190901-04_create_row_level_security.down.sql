@@ -0,0 +1,13 @@
+DROP POLICY IF EXISTS tenant_isolation ON projects;
+ALTER TABLE projects DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON users_accounts;
+ALTER TABLE users_accounts DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON account_preferences;
+ALTER TABLE account_preferences DISABLE ROW LEVEL SECURITY;
+
+-- The role is not dropped as it can be used by other databases of the server.
+ALTER DEFAULT PRIVILEGES IN SCHEMA public REVOKE SELECT, INSERT, UPDATE, DELETE ON TABLES FROM saas_tenant;
+REVOKE ALL ON ALL TABLES IN SCHEMA public FROM saas_tenant;
+REVOKE USAGE ON SCHEMA public FROM saas_tenant;
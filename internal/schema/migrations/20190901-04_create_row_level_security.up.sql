@@ -0,0 +1,61 @@
+-- Create the role saas_tenant and the row level security policies that isolate the tenants. The policies only apply
+-- to transactions that switch to the role, which the services do when DB_ROW_LEVEL_SECURITY is enabled. The owner of
+-- the tables bypasses them. Outside of a scoped transaction the settings are missing, current_setting returns NULL and
+-- no rows are matched.
+
+-- Roles are shared by all the databases of the server, the role is only created when it doesn't already exist. A user
+-- without the CREATEROLE privilege can run the migration once the role has been created and granted to it.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'saas_tenant') THEN
+        CREATE ROLE saas_tenant NOLOGIN;
+    END IF;
+EXCEPTION
+    WHEN duplicate_object THEN
+        NULL;
+    WHEN insufficient_privilege THEN
+        RAISE EXCEPTION 'role saas_tenant does not exist and user % can not create it', CURRENT_USER
+            USING HINT = 'Create the role with CREATE ROLE saas_tenant NOLOGIN and grant it to the user.';
+END
+$$;
+
+DO $$
+BEGIN
+    IF NOT pg_has_role(CURRENT_USER, 'saas_tenant', 'MEMBER') THEN
+        GRANT saas_tenant TO CURRENT_USER;
+    END IF;
+END
+$$;
+
+GRANT USAGE ON SCHEMA public TO saas_tenant;
+
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO saas_tenant;
+
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO saas_tenant;
+
+ALTER TABLE projects ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON projects;
+
+CREATE POLICY tenant_isolation ON projects TO saas_tenant
+    USING (account_id = current_setting('app.current_account_id', true));
+
+ALTER TABLE users_accounts ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON users_accounts;
+
+CREATE POLICY tenant_isolation ON users_accounts TO saas_tenant
+    USING (account_id = current_setting('app.current_account_id', true)
+        OR user_id = current_setting('app.current_user_id', true))
+    WITH CHECK (account_id = current_setting('app.current_account_id', true));
+
+ALTER TABLE account_preferences ENABLE ROW LEVEL SECURITY;
+
+DROP POLICY IF EXISTS tenant_isolation ON account_preferences;
+
+CREATE POLICY tenant_isolation ON account_preferences TO saas_tenant
+    USING (account_id = current_setting('app.current_account_id', true)
+        OR account_id IN (
+            SELECT account_id FROM users_accounts
+            WHERE user_id = current_setting('app.current_user_id', true)))
+    WITH CHECK (account_id = current_setting('app.current_account_id', true));
@@ -0,0 +1,59 @@
+//go:build ignore
+// +build ignore
+
+// This program generates files.go to embed the SQL files of the migrations directory in the migrations package.
+// It is invoked by running go generate in the migrations package.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	files, err := filepath.Glob("*.sql")
+	if err != nil {
+		log.Fatalf("main : Glob : %v", err)
+	}
+	sort.Strings(files)
+
+	var buf bytes.Buffer
+	buf.WriteString("// Code generated by gen.go; DO NOT EDIT.\n\n")
+	buf.WriteString("package migrations\n\n")
+	buf.WriteString("// Files contains the SQL files of the migrations directory keyed by file name.\n")
+	buf.WriteString("var Files = map[string]string{\n")
+
+	for _, f := range files {
+		dat, err := ioutil.ReadFile(f)
+		if err != nil {
+			log.Fatalf("main : ReadFile %s : %v", f, err)
+		}
+
+		// Use raw strings so the SQL remains readable, unless the SQL contains a backtick.
+		val := string(dat)
+		if strings.Contains(val, "`") {
+			val = strconv.Quote(val)
+		} else {
+			val = "`" + val + "`"
+		}
+
+		fmt.Fprintf(&buf, "%s: %s,\n", strconv.Quote(filepath.Base(f)), val)
+	}
+	buf.WriteString("}\n")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatalf("main : Format : %v", err)
+	}
+
+	if err := ioutil.WriteFile("files.go", src, 0644); err != nil {
+		log.Fatalf("main : WriteFile : %v", err)
+	}
+}
@@ -0,0 +1,5 @@
+// Package migrations embeds the SQL files of the schema migrations so they are included in the binaries. The files
+// are named <ID>_<name>.up.sql and <ID>_<name>.down.sql, after adding or changing a file regenerate files.go.
+package migrations
+
+//go:generate go run gen.go
@@ -0,0 +1,45 @@
+package migrations_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/schema/migrations"
+)
+
+// TestFiles validates the embedded SQL files match the migrations directory. It doesn't require a database so the
+// files are checked even when the database tests can't run.
+func TestFiles(t *testing.T) {
+	t.Log("Given the need to embed the SQL migration files.")
+	{
+		t.Log("\tWhen comparing the embedded files to the migrations directory.")
+		{
+			files, err := filepath.Glob("*.sql")
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tGlob failed.", tests.Failed)
+			}
+
+			if len(files) != len(migrations.Files) {
+				t.Logf("\t\tGot : %d", len(migrations.Files))
+				t.Logf("\t\tWant: %d", len(files))
+				t.Fatalf("\t%s\tEmbedded files are out of date, run go generate.", tests.Failed)
+			}
+
+			for _, f := range files {
+				dat, err := ioutil.ReadFile(f)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tReadFile failed.", tests.Failed)
+				}
+
+				if migrations.Files[f] != string(dat) {
+					t.Fatalf("\t%s\tEmbedded file %s is out of date, run go generate.", tests.Failed, f)
+				}
+			}
+			t.Logf("\t%s\tEmbedded files ok.", tests.Success)
+		}
+	}
+}
@@ -2,15 +2,72 @@ package schema
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"io"
 	"log"
 
 	"github.com/geeks-accelerator/sqlxmigrate"
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 )
 
+// lockKey is the key of the Postgres advisory lock held while migrations are executed so concurrent deploys don't
+// run the same migrations at the same time.
+const lockKey = 3820190522
+
+var (
+	// ErrMigrationNotFound occurs when the ID does not match any of the migrations.
+	ErrMigrationNotFound = errors.New("Migration not found")
+
+	// ErrRollbackMissing occurs when a migration does not define a rollback.
+	ErrRollbackMissing = errors.New("Migration rollback missing")
+)
+
+// MigrationStatus defines if a migration has been applied to the database.
+type MigrationStatus struct {
+	ID      string
+	Kind    MigrationKind
+	Applied bool
+
+	// Modified is true when the SQL file of an applied migration has been edited.
+	Modified bool
+}
+
+// Migrate executes all the migrations that have not been applied to the database.
 func Migrate(ctx context.Context, masterDb *sqlx.DB, log *log.Logger, isUnittest bool) error {
+	return MigrateTo(ctx, masterDb, log, isUnittest, "")
+}
+
+// MigrateTo executes the migrations that have not been applied to the database up to and including the migration
+// with the ID. When the ID is empty all the migrations are executed.
+func MigrateTo(ctx context.Context, masterDb *sqlx.DB, log *log.Logger, isUnittest bool, migrationID string) error {
 	// Load list of Schema migrations and init new sqlxmigrate client
-	migrations := migrationList(ctx, masterDb, log, isUnittest)
+	migrations, err := migrationList(ctx, masterDb, log, isUnittest)
+	if err != nil {
+		return err
+	} else if err := validateMigrations(migrations); err != nil {
+		return err
+	}
+
+	migrations, err = migrationsTo(migrations, migrationID)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lock(ctx, masterDb, log)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(ctx, masterDb)
+	if err != nil {
+		return err
+	} else if err := verifyChecksums(ctx, masterDb, applied, true); err != nil {
+		return err
+	}
+
 	m := sqlxmigrate.New(masterDb, sqlxmigrate.DefaultOptions, migrations)
 	m.SetLogger(log)
 
@@ -21,3 +78,328 @@ func Migrate(ctx context.Context, masterDb *sqlx.DB, log *log.Logger, isUnittest
 	// Execute the migrations
 	return m.Migrate()
 }
+
+// RollbackTo rolls back the applied migrations after the migration with the ID in reverse order, the migration with
+// the ID is not rolled back. When the ID is empty all the migrations are rolled back.
+func RollbackTo(ctx context.Context, masterDb *sqlx.DB, log *log.Logger, isUnittest bool, migrationID string) error {
+	migrations, err := migrationList(ctx, masterDb, log, isUnittest)
+	if err != nil {
+		return err
+	} else if err := validateMigrations(migrations); err != nil {
+		return err
+	}
+
+	rollbacks, err := migrationsAfter(migrations, migrationID)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lock(ctx, masterDb, log)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(ctx, masterDb)
+	if err != nil {
+		return err
+	} else if err := verifyChecksums(ctx, masterDb, applied, true); err != nil {
+		return err
+	}
+
+	for i := len(rollbacks) - 1; i >= 0; i-- {
+		m := rollbacks[i]
+		if !applied[m.ID] {
+			continue
+		}
+
+		log.Printf("Migration %s - rolling back", m.ID)
+		if err := rollbackMigration(ctx, masterDb, m); err != nil {
+			return errors.WithMessagef(err, "Migration %s rollback failed", m.ID)
+		}
+	}
+
+	return nil
+}
+
+// Status returns the list of migrations with if they have been applied to the database.
+func Status(ctx context.Context, masterDb *sqlx.DB, log *log.Logger, isUnittest bool) ([]MigrationStatus, error) {
+	migrations, err := migrationList(ctx, masterDb, log, isUnittest)
+	if err != nil {
+		return nil, err
+	} else if err := validateMigrations(migrations); err != nil {
+		return nil, err
+	}
+
+	sqlMigrations, err := loadSQLMigrations(sqlMigrationFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := make(map[string]MigrationKind)
+	for _, m := range sqlMigrations {
+		kinds[m.ID] = MigrationKind_SQL
+	}
+
+	applied, err := appliedMigrations(ctx, masterDb)
+	if err != nil {
+		return nil, err
+	}
+
+	modified, err := modifiedMigrations(ctx, masterDb, applied, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []MigrationStatus
+	for _, m := range migrations {
+		s := MigrationStatus{
+			ID:      m.ID,
+			Kind:    MigrationKind_Go,
+			Applied: applied[m.ID],
+		}
+		if k, ok := kinds[m.ID]; ok {
+			s.Kind = k
+		}
+		for _, id := range modified {
+			if id == m.ID {
+				s.Modified = true
+			}
+		}
+		resp = append(resp, s)
+	}
+
+	return resp, nil
+}
+
+// DryRunMigrateTo writes the SQL of the migrations that would be executed by MigrateTo without changing the database.
+func DryRunMigrateTo(ctx context.Context, masterDb *sqlx.DB, log *log.Logger, isUnittest bool, migrationID string, w io.Writer) error {
+	applied, err := appliedMigrations(ctx, masterDb)
+	if err != nil {
+		return err
+	} else if err := verifyChecksums(ctx, masterDb, applied, false); err != nil {
+		return err
+	}
+
+	dryDb := newDryRunDB(w)
+	defer dryDb.Close()
+
+	// The migrations use the dry run connection so any queries executed outside of the transaction are written too.
+	migrations, err := migrationList(ctx, dryDb, log, isUnittest)
+	if err != nil {
+		return err
+	} else if err := validateMigrations(migrations); err != nil {
+		return err
+	}
+
+	migrations, err = migrationsTo(migrations, migrationID)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.ID] {
+			continue
+		}
+
+		fmt.Fprintf(w, "-- Migration %s - migrate\n", m.ID)
+		if err := dryRun(ctx, dryDb, m.Migrate); err != nil {
+			return errors.WithMessagef(err, "Migration %s failed", m.ID)
+		}
+	}
+
+	return nil
+}
+
+// DryRunRollbackTo writes the SQL of the migrations that would be rolled back by RollbackTo without changing the
+// database.
+func DryRunRollbackTo(ctx context.Context, masterDb *sqlx.DB, log *log.Logger, isUnittest bool, migrationID string, w io.Writer) error {
+	applied, err := appliedMigrations(ctx, masterDb)
+	if err != nil {
+		return err
+	} else if err := verifyChecksums(ctx, masterDb, applied, false); err != nil {
+		return err
+	}
+
+	dryDb := newDryRunDB(w)
+	defer dryDb.Close()
+
+	migrations, err := migrationList(ctx, dryDb, log, isUnittest)
+	if err != nil {
+		return err
+	} else if err := validateMigrations(migrations); err != nil {
+		return err
+	}
+
+	rollbacks, err := migrationsAfter(migrations, migrationID)
+	if err != nil {
+		return err
+	}
+
+	for i := len(rollbacks) - 1; i >= 0; i-- {
+		m := rollbacks[i]
+		if !applied[m.ID] {
+			continue
+		}
+
+		fmt.Fprintf(w, "-- Migration %s - rollback\n", m.ID)
+		if err := dryRun(ctx, dryDb, m.Rollback); err != nil {
+			return errors.WithMessagef(err, "Migration %s rollback failed", m.ID)
+		}
+	}
+
+	return nil
+}
+
+// validateMigrations ensures every migration has a unique ID and defines a rollback.
+func validateMigrations(migrations []*sqlxmigrate.Migration) error {
+	ids := make(map[string]bool)
+	for _, m := range migrations {
+		if m.ID == "" {
+			return errors.New("Migration ID missing")
+		} else if ids[m.ID] {
+			return errors.Errorf("Migration %s is duplicated", m.ID)
+		}
+		ids[m.ID] = true
+
+		if m.Migrate == nil {
+			return errors.Errorf("Migration %s migrate missing", m.ID)
+		} else if m.Rollback == nil {
+			return errors.WithMessagef(ErrRollbackMissing, "migration %s", m.ID)
+		}
+	}
+	return nil
+}
+
+// migrationIndex returns the position of the migration with the ID.
+func migrationIndex(migrations []*sqlxmigrate.Migration, migrationID string) (int, error) {
+	for i, m := range migrations {
+		if m.ID == migrationID {
+			return i, nil
+		}
+	}
+	return -1, errors.WithMessagef(ErrMigrationNotFound, "migration %s", migrationID)
+}
+
+// migrationsTo returns the migrations up to and including the migration with the ID.
+func migrationsTo(migrations []*sqlxmigrate.Migration, migrationID string) ([]*sqlxmigrate.Migration, error) {
+	if migrationID == "" {
+		return migrations, nil
+	}
+
+	idx, err := migrationIndex(migrations, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	return migrations[:idx+1], nil
+}
+
+// migrationsAfter returns the migrations after the migration with the ID.
+func migrationsAfter(migrations []*sqlxmigrate.Migration, migrationID string) ([]*sqlxmigrate.Migration, error) {
+	if migrationID == "" {
+		return migrations, nil
+	}
+
+	idx, err := migrationIndex(migrations, migrationID)
+	if err != nil {
+		return nil, err
+	}
+	return migrations[idx+1:], nil
+}
+
+// appliedMigrations returns the IDs of the migrations stored in the migrations table.
+func appliedMigrations(ctx context.Context, masterDb *sqlx.DB) (map[string]bool, error) {
+	resp := make(map[string]bool)
+
+	// The migrations table is created by the first migration.
+	exists, err := tableExists(ctx, masterDb, sqlxmigrate.DefaultOptions.TableName)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return resp, nil
+	}
+
+	var ids []string
+	q := fmt.Sprintf("SELECT %s FROM %s", sqlxmigrate.DefaultOptions.IDColumnName, sqlxmigrate.DefaultOptions.TableName)
+	if err := masterDb.SelectContext(ctx, &ids, q); err != nil {
+		return nil, errors.Wrapf(err, "query - %s", q)
+	}
+
+	for _, id := range ids {
+		resp[id] = true
+	}
+
+	return resp, nil
+}
+
+// tableExists determines if the table exists in the database.
+func tableExists(ctx context.Context, masterDb *sqlx.DB, tableName string) (bool, error) {
+	var exists bool
+	q := `SELECT to_regclass($1) IS NOT NULL`
+	if err := masterDb.QueryRowContext(ctx, q, tableName).Scan(&exists); err != nil {
+		return false, errors.Wrapf(err, "query - %s", q)
+	}
+	return exists, nil
+}
+
+// rollbackMigration executes the rollback of the migration and removes it from the migrations table in a single
+// transaction.
+func rollbackMigration(ctx context.Context, masterDb *sqlx.DB, m *sqlxmigrate.Migration) error {
+	tx, err := masterDb.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := m.Rollback(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	q := fmt.Sprintf("DELETE FROM %s WHERE %s = $1", sqlxmigrate.DefaultOptions.TableName, sqlxmigrate.DefaultOptions.IDColumnName)
+	if _, err := tx.ExecContext(ctx, q, m.ID); err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "query - %s", q)
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+// lock takes the advisory lock used to run the migrations, waiting for any other process that holds it. The lock is
+// held by a single connection until the returned function is called.
+func lock(ctx context.Context, masterDb *sqlx.DB, log *log.Logger) (func(), error) {
+	conn, err := masterDb.Conn(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	log.Printf("Migration lock - waiting")
+
+	q1 := `SELECT pg_advisory_lock($1)`
+	if _, err := conn.ExecContext(ctx, q1, lockKey); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "query - %s", q1)
+	}
+
+	log.Printf("Migration lock - acquired")
+
+	return func() {
+		// Use a new context so the lock is released when the context has been canceled.
+		q2 := `SELECT pg_advisory_unlock($1)`
+		if _, err := conn.ExecContext(context.Background(), q2, lockKey); err != nil {
+			log.Printf("Migration lock - release failed : %v", err)
+		}
+		conn.Close()
+	}, nil
+}
+
+// dryRun executes the migrate or rollback function of a migration with a transaction that writes the queries
+// instead of executing them.
+func dryRun(ctx context.Context, dryDb *sqlx.DB, fn func(*sql.Tx) error) error {
+	tx, err := dryDb.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer tx.Rollback()
+
+	return fn(tx)
+}
@@ -0,0 +1,241 @@
+package schema_test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/schema"
+	"github.com/pkg/errors"
+)
+
+var test *tests.Test
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+
+	return m.Run()
+}
+
+// assertStatus validates the first number of migrations are applied and the remaining are pending. A negative number
+// expects all the migrations to be applied.
+func assertStatus(t *testing.T, applied int) []schema.MigrationStatus {
+	ctx := tests.Context()
+
+	res, err := schema.Status(ctx, test.MasterDB, test.Log, true)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tStatus failed.", tests.Failed)
+	}
+
+	if applied < 0 {
+		applied = len(res)
+	}
+
+	for i, m := range res {
+		if want := i < applied; m.Applied != want {
+			t.Logf("\t\tGot : %v", m.Applied)
+			t.Logf("\t\tWant: %v", want)
+			t.Fatalf("\t%s\tStatus for migration %s failed.", tests.Failed, m.ID)
+		}
+	}
+
+	return res
+}
+
+// TestMigrations validates the migrations can be rolled back and applied again.
+func TestMigrations(t *testing.T) {
+	ctx := tests.Context()
+
+	t.Log("Given the need to manage the schema migrations.")
+	{
+		t.Log("\tWhen all the migrations have been applied.")
+		{
+			res := assertStatus(t, -1)
+			if len(res) < 2 {
+				t.Logf("\t\tGot : %d", len(res))
+				t.Fatalf("\t%s\tStatus should return all the migrations.", tests.Failed)
+			}
+			t.Logf("\t%s\tStatus ok.", tests.Success)
+
+			// A dry run should print the SQL without rolling back the migration.
+			last := res[len(res)-1].ID
+			prev := res[len(res)-2].ID
+
+			var buf bytes.Buffer
+			err := schema.DryRunRollbackTo(ctx, test.MasterDB, test.Log, true, prev, &buf)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tDryRunRollbackTo failed.", tests.Failed)
+			} else if !strings.Contains(buf.String(), "-- Migration "+last+" - rollback") {
+				t.Logf("\t\tGot : %s", buf.String())
+				t.Fatalf("\t%s\tDryRunRollbackTo should write the rollback of migration %s.", tests.Failed, last)
+			}
+			assertStatus(t, -1)
+			t.Logf("\t%s\tDryRunRollbackTo ok.", tests.Success)
+
+			err = schema.RollbackTo(ctx, test.MasterDB, test.Log, true, "invalid")
+			if errors.Cause(err) != schema.ErrMigrationNotFound {
+				t.Logf("\t\tGot : %+v", err)
+				t.Logf("\t\tWant: %+v", schema.ErrMigrationNotFound)
+				t.Fatalf("\t%s\tRollbackTo invalid migration should fail.", tests.Failed)
+			}
+			t.Logf("\t%s\tRollbackTo invalid migration ok.", tests.Success)
+		}
+
+		t.Log("\tWhen rolling back all the migrations.")
+		{
+			// Every migration is rolled back so a missing or broken rollback fails the test.
+			if err := schema.RollbackTo(ctx, test.MasterDB, test.Log, true, ""); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tRollbackTo failed.", tests.Failed)
+			}
+			res := assertStatus(t, 0)
+			t.Logf("\t%s\tRollbackTo ok.", tests.Success)
+
+			// Apply the first migration only.
+			if err := schema.MigrateTo(ctx, test.MasterDB, test.Log, true, res[0].ID); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tMigrateTo failed.", tests.Failed)
+			}
+			assertStatus(t, 1)
+			t.Logf("\t%s\tMigrateTo ok.", tests.Success)
+
+			// Apply the remaining migrations.
+			if err := schema.Migrate(ctx, test.MasterDB, test.Log, true); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tMigrate failed.", tests.Failed)
+			}
+			assertStatus(t, -1)
+			t.Logf("\t%s\tMigrate ok.", tests.Success)
+		}
+	}
+}
+
+// TestLoadSQLMigrations validates the embedded SQL files are valid migrations. The embedded files are compared to the
+// migrations directory by the tests of the migrations package.
+func TestLoadSQLMigrations(t *testing.T) {
+	t.Log("Given the need to load the SQL migration files.")
+	{
+		t.Log("\tWhen loading the embedded files.")
+		{
+			if _, err := schema.LoadSQLMigrations(schema.SQLMigrationFiles); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tLoadSQLMigrations failed.", tests.Failed)
+			}
+			t.Logf("\t%s\tLoadSQLMigrations ok.", tests.Success)
+		}
+
+		t.Log("\tWhen a migration is missing the down file.")
+		{
+			_, err := schema.LoadSQLMigrations(map[string]string{
+				"20190901-01_create_tests.up.sql": "CREATE TABLE tests (id char(36) NOT NULL);",
+			})
+			if errors.Cause(err) != schema.ErrRollbackMissing {
+				t.Logf("\t\tGot : %+v", err)
+				t.Logf("\t\tWant: %+v", schema.ErrRollbackMissing)
+				t.Fatalf("\t%s\tLoadSQLMigrations should fail.", tests.Failed)
+			}
+			t.Logf("\t%s\tLoadSQLMigrations ok.", tests.Success)
+		}
+	}
+}
+
+// TestChecksums validates edits to the SQL files of applied migrations are detected.
+func TestChecksums(t *testing.T) {
+	ctx := tests.Context()
+
+	sqlMigrations, err := schema.LoadSQLMigrations(schema.SQLMigrationFiles)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tLoadSQLMigrations failed.", tests.Failed)
+	}
+	m := sqlMigrations[0]
+
+	// status returns the status of the migration.
+	status := func() schema.MigrationStatus {
+		res, err := schema.Status(ctx, test.MasterDB, test.Log, true)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tStatus failed.", tests.Failed)
+		}
+		for _, r := range res {
+			if r.ID == m.ID {
+				return r
+			}
+		}
+		t.Fatalf("\t%s\tStatus is missing migration %s.", tests.Failed, m.ID)
+		return schema.MigrationStatus{}
+	}
+
+	t.Log("Given the need to detect edited migrations.")
+	{
+		t.Log("\tWhen the migration has not been edited.")
+		{
+			if s := status(); s.Kind != schema.MigrationKind_SQL || !s.Applied || s.Modified {
+				t.Logf("\t\tGot : %+v", s)
+				t.Fatalf("\t%s\tStatus failed.", tests.Failed)
+			}
+			t.Logf("\t%s\tStatus ok.", tests.Success)
+		}
+
+		t.Log("\tWhen the migration has been edited.")
+		{
+			// Change the recorded checksum to simulate an edit of the file after it was applied.
+			q := `UPDATE migration_checksums SET checksum = $1 WHERE id = $2`
+			if _, err := test.MasterDB.ExecContext(ctx, q, strings.Repeat("0", 64), m.ID); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tUpdate checksum failed.", tests.Failed)
+			}
+
+			if s := status(); !s.Modified {
+				t.Logf("\t\tGot : %+v", s)
+				t.Fatalf("\t%s\tStatus should be modified.", tests.Failed)
+			}
+			t.Logf("\t%s\tStatus ok.", tests.Success)
+
+			err := schema.Migrate(ctx, test.MasterDB, test.Log, true)
+			if errors.Cause(err) != schema.ErrChecksumMismatch {
+				t.Logf("\t\tGot : %+v", err)
+				t.Logf("\t\tWant: %+v", schema.ErrChecksumMismatch)
+				t.Fatalf("\t%s\tMigrate should fail.", tests.Failed)
+			}
+			t.Logf("\t%s\tMigrate ok.", tests.Success)
+		}
+
+		t.Log("\tWhen the migration has no checksum recorded.")
+		{
+			// Migrations applied before they were defined as SQL files don't have a checksum.
+			q := `DELETE FROM migration_checksums WHERE id = $1`
+			if _, err := test.MasterDB.ExecContext(ctx, q, m.ID); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tDelete checksum failed.", tests.Failed)
+			}
+
+			if err := schema.Migrate(ctx, test.MasterDB, test.Log, true); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tMigrate failed.", tests.Failed)
+			}
+
+			var checksum string
+			q = `SELECT checksum FROM migration_checksums WHERE id = $1`
+			if err := test.MasterDB.QueryRowContext(ctx, q, m.ID).Scan(&checksum); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tSelect checksum failed.", tests.Failed)
+			} else if checksum != m.Checksum() {
+				t.Logf("\t\tGot : %s", checksum)
+				t.Logf("\t\tWant: %s", m.Checksum())
+				t.Fatalf("\t%s\tMigrate should record the checksum.", tests.Failed)
+			}
+			t.Logf("\t%s\tMigrate ok.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package schema
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/schema/migrations"
+	"github.com/geeks-accelerator/sqlxmigrate"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// checksumTable is the name of the table that stores the checksum of the applied SQL migrations.
+const checksumTable = "migration_checksums"
+
+// ErrChecksumMismatch occurs when the SQL file of an applied migration has been edited.
+var ErrChecksumMismatch = errors.New("Migration checksum mismatch")
+
+// sqlMigrationFiles contains the SQL files of the migrations directory keyed by file name.
+var sqlMigrationFiles = migrations.Files
+
+// MigrationKind defines how a migration is defined.
+type MigrationKind string
+
+// MigrationKind values.
+const (
+	MigrationKind_SQL MigrationKind = "sql"
+	MigrationKind_Go  MigrationKind = "go"
+)
+
+// sqlMigration defines a migration loaded from the up and down SQL files of the migrations directory.
+type sqlMigration struct {
+	ID   string
+	Name string
+	Up   string
+	Down string
+}
+
+// Checksum returns the SHA-256 of the up SQL. The down SQL is excluded since it doesn't change the state of the
+// database when the migration is applied and can still be fixed afterwards.
+func (m *sqlMigration) Checksum() string {
+	h := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(h[:])
+}
+
+// fileName returns the name of the SQL file for the direction, either up or down.
+func (m *sqlMigration) fileName(direction string) string {
+	if m.Name == "" {
+		return fmt.Sprintf("%s.%s.sql", m.ID, direction)
+	}
+	return fmt.Sprintf("%s_%s.%s.sql", m.ID, m.Name, direction)
+}
+
+// migration returns the migration that executes the SQL files and records the checksum of the up SQL.
+func (m *sqlMigration) migration() *sqlxmigrate.Migration {
+	return &sqlxmigrate.Migration{
+		ID: m.ID,
+		Migrate: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(m.Up); err != nil {
+				return errors.WithMessagef(err, "Query failed %s", m.fileName("up"))
+			}
+			return saveChecksum(tx, m.ID, m.Checksum())
+		},
+		Rollback: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(m.Down); err != nil {
+				return errors.WithMessagef(err, "Query failed %s", m.fileName("down"))
+			}
+			return deleteChecksum(tx, m.ID)
+		},
+	}
+}
+
+// loadSQLMigrations parses the SQL files keyed by file name and returns the migrations sorted by ID. Files are named
+// <ID>_<name>.up.sql and <ID>_<name>.down.sql, every migration requires both.
+func loadSQLMigrations(files map[string]string) ([]*sqlMigration, error) {
+	byID := make(map[string]*sqlMigration)
+	for fileName, dat := range files {
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, errors.Errorf("Migration file %s must end with .up.sql or .down.sql", fileName)
+		}
+
+		base := strings.TrimSuffix(fileName, "."+direction+".sql")
+
+		var id, name string
+		if pts := strings.SplitN(base, "_", 2); len(pts) == 2 {
+			id, name = pts[0], pts[1]
+		} else {
+			id = base
+		}
+
+		if id == "" {
+			return nil, errors.Errorf("Migration file %s is missing the ID", fileName)
+		}
+
+		m, ok := byID[id]
+		if !ok {
+			m = &sqlMigration{ID: id, Name: name}
+			byID[id] = m
+		} else if m.Name != name {
+			return nil, errors.Errorf("Migration %s is duplicated", id)
+		}
+
+		if direction == "up" {
+			m.Up = dat
+		} else {
+			m.Down = dat
+		}
+	}
+
+	var resp []*sqlMigration
+	for _, m := range byID {
+		if strings.TrimSpace(m.Up) == "" {
+			return nil, errors.Errorf("Migration %s up file missing", m.ID)
+		} else if strings.TrimSpace(m.Down) == "" {
+			return nil, errors.WithMessagef(ErrRollbackMissing, "migration %s down file missing", m.ID)
+		}
+		resp = append(resp, m)
+	}
+
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].ID < resp[j].ID
+	})
+
+	return resp, nil
+}
+
+// migrationList returns the migrations defined as SQL files and as Go code sorted by ID.
+func migrationList(ctx context.Context, db *sqlx.DB, log *log.Logger, isUnittest bool) ([]*sqlxmigrate.Migration, error) {
+	sqlMigrations, err := loadSQLMigrations(sqlMigrationFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := goMigrations(ctx, db, log, isUnittest)
+	for _, m := range sqlMigrations {
+		migrations = append(migrations, m.migration())
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return migrations[i].ID < migrations[j].ID
+	})
+
+	return migrations, nil
+}
+
+// modifiedMigrations returns the IDs of the applied SQL migrations where the checksum of the file no longer matches
+// the checksum recorded when the migration was applied. Applied migrations without a checksum, such as migrations that
+// were previously defined as Go code, have the checksum of the file recorded when record is true.
+func modifiedMigrations(ctx context.Context, masterDb *sqlx.DB, applied map[string]bool, record bool) ([]string, error) {
+	sqlMigrations, err := loadSQLMigrations(sqlMigrationFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make(map[string]string)
+
+	exists, err := tableExists(ctx, masterDb, checksumTable)
+	if err != nil {
+		return nil, err
+	} else if exists {
+		var res []struct {
+			ID       string `db:"id"`
+			Checksum string `db:"checksum"`
+		}
+		q := `SELECT id, checksum FROM ` + checksumTable
+		if err := masterDb.SelectContext(ctx, &res, q); err != nil {
+			return nil, errors.Wrapf(err, "query - %s", q)
+		}
+
+		for _, r := range res {
+			checksums[r.ID] = r.Checksum
+		}
+	}
+
+	var resp []string
+	for _, m := range sqlMigrations {
+		if !applied[m.ID] {
+			continue
+		}
+
+		cs, ok := checksums[m.ID]
+		if !ok {
+			if record {
+				if err := recordChecksum(ctx, masterDb, m.ID, m.Checksum()); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if cs != m.Checksum() {
+			resp = append(resp, m.ID)
+		}
+	}
+
+	return resp, nil
+}
+
+// verifyChecksums returns ErrChecksumMismatch when the SQL file of an applied migration has been edited. Edits to
+// applied migrations are not executed, a new migration should be added instead.
+func verifyChecksums(ctx context.Context, masterDb *sqlx.DB, applied map[string]bool, record bool) error {
+	modified, err := modifiedMigrations(ctx, masterDb, applied, record)
+	if err != nil {
+		return err
+	} else if len(modified) > 0 {
+		return errors.WithMessagef(ErrChecksumMismatch, "migrations %s", strings.Join(modified, ", "))
+	}
+	return nil
+}
+
+// recordChecksum stores the checksum of an applied migration in a new transaction.
+func recordChecksum(ctx context.Context, masterDb *sqlx.DB, migrationID, checksum string) error {
+	tx, err := masterDb.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := saveChecksum(tx, migrationID, checksum); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+// saveChecksum stores the checksum of a migration, creating the table if it does not exist.
+func saveChecksum(tx *sql.Tx, migrationID, checksum string) error {
+	q1 := `CREATE TABLE IF NOT EXISTS ` + checksumTable + ` (
+		  id varchar(255) NOT NULL,
+		  checksum char(64) NOT NULL,
+		  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		  PRIMARY KEY (id)
+		)`
+	if _, err := tx.Exec(q1); err != nil {
+		return errors.WithMessagef(err, "Query failed %s", q1)
+	}
+
+	q2 := `INSERT INTO ` + checksumTable + ` (id, checksum, created_at) VALUES ($1, $2, now())
+		ON CONFLICT (id) DO UPDATE SET checksum = excluded.checksum, created_at = excluded.created_at`
+	if _, err := tx.Exec(q2, migrationID, checksum); err != nil {
+		return errors.WithMessagef(err, "Query failed %s", q2)
+	}
+
+	return nil
+}
+
+// deleteChecksum removes the checksum of a migration that has been rolled back. The table exists since the checksums of
+// the applied migrations are recorded before any are rolled back.
+func deleteChecksum(tx *sql.Tx, migrationID string) error {
+	q := `DELETE FROM ` + checksumTable + ` WHERE id = $1`
+	if _, err := tx.Exec(q, migrationID); err != nil {
+		return errors.WithMessagef(err, "Query failed %s", q)
+	}
+
+	return nil
+}
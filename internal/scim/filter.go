@@ -0,0 +1,534 @@
+package scim
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filterExpr is a node of a parsed SCIM filter, see RFC 7644 section 3.4.2.2. Attribute paths are lower case since
+// the attribute names are case insensitive.
+type filterExpr interface{}
+
+// attrExpr compares the value of an attribute. The value is a string, bool, float64 or nil.
+type attrExpr struct {
+	Path  string
+	Op    string
+	Value interface{}
+}
+
+// logicalExpr combines two expressions with and/or.
+type logicalExpr struct {
+	Op          string
+	Left, Right filterExpr
+}
+
+// notExpr negates an expression.
+type notExpr struct {
+	Expr filterExpr
+}
+
+// The comparison operators of a filter.
+var filterCompareOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true, "gt": true, "ge": true, "lt": true, "le": true,
+}
+
+type filterTokenKind int
+
+const (
+	tokenWord filterTokenKind = iota
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	val  string
+}
+
+// tokenizeFilter splits the filter into words, quoted strings, parentheses and brackets.
+func tokenizeFilter(s string) ([]filterToken, error) {
+	var toks []filterToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			toks = append(toks, filterToken{kind: tokenLParen, val: "("})
+			i++
+		case c == ')':
+			toks = append(toks, filterToken{kind: tokenRParen, val: ")"})
+			i++
+		case c == '[':
+			toks = append(toks, filterToken{kind: tokenLBracket, val: "["})
+			i++
+		case c == ']':
+			toks = append(toks, filterToken{kind: tokenRBracket, val: "]"})
+			i++
+		case c == '"':
+			// Strings are JSON strings, find the closing quote skipping escaped characters.
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, errInvalidFilter("unterminated string in filter")
+			}
+
+			var v string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &v); err != nil {
+				return nil, errInvalidFilter("invalid string " + s[i:j+1] + " in filter")
+			}
+			toks = append(toks, filterToken{kind: tokenString, val: v})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(s) && !strings.ContainsRune(" \t\n\r()[]\"", rune(s[j])); j++ {
+			}
+			toks = append(toks, filterToken{kind: tokenWord, val: s[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+// filterParser is a recursive descent parser for the filter grammar:
+//
+//	filter    = and *("or" and)
+//	and       = unary *("and" unary)
+//	unary     = "not" "(" filter ")" / "(" filter ")" / attrPath "pr" / attrPath compareOp compValue / attrPath "[" filter "]"
+type filterParser struct {
+	toks []filterToken
+	pos  int
+}
+
+// parseFilter parses a SCIM filter.
+func parseFilter(s string) (filterExpr, error) {
+	return parseValueFilter(s, "")
+}
+
+// parseValueFilter parses a SCIM filter for the sub-attributes of an attribute, the prefix is prepended to the
+// attribute paths of the filter.
+func parseValueFilter(s, prefix string) (filterExpr, error) {
+	toks, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	} else if len(toks) == 0 {
+		return nil, errInvalidFilter("filter is empty")
+	}
+
+	p := &filterParser{toks: toks}
+	e, err := p.parseOr(prefix)
+	if err != nil {
+		return nil, err
+	} else if p.pos < len(p.toks) {
+		return nil, errInvalidFilter("unexpected " + p.toks[p.pos].val + " in filter")
+	}
+	return e, nil
+}
+
+func (p *filterParser) peek() *filterToken {
+	if p.pos < len(p.toks) {
+		return &p.toks[p.pos]
+	}
+	return nil
+}
+
+func (p *filterParser) next() *filterToken {
+	t := p.peek()
+	if t != nil {
+		p.pos++
+	}
+	return t
+}
+
+// keyword returns true when the next token is the keyword and consumes it.
+func (p *filterParser) keyword(kw string) bool {
+	if t := p.peek(); t != nil && t.kind == tokenWord && strings.EqualFold(t.val, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(kind filterTokenKind, val string) error {
+	if t := p.next(); t == nil || t.kind != kind {
+		return errInvalidFilter("expected " + val + " in filter")
+	}
+	return nil
+}
+
+// parseOr parses expressions joined by or, the prefix is prepended to attribute paths inside a value path.
+func (p *filterParser) parseOr(prefix string) (filterExpr, error) {
+	l, err := p.parseAnd(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		r, err := p.parseAnd(prefix)
+		if err != nil {
+			return nil, err
+		}
+		l = logicalExpr{Op: "or", Left: l, Right: r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseAnd(prefix string) (filterExpr, error) {
+	l, err := p.parseUnary(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		r, err := p.parseUnary(prefix)
+		if err != nil {
+			return nil, err
+		}
+		l = logicalExpr{Op: "and", Left: l, Right: r}
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseUnary(prefix string) (filterExpr, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, errInvalidFilter("unexpected end of filter")
+	}
+
+	if t.kind == tokenLParen {
+		p.pos++
+		e, err := p.parseOr(prefix)
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(tokenRParen, ")")
+	}
+
+	if p.keyword("not") {
+		if err := p.expect(tokenLParen, "( after not"); err != nil {
+			return nil, err
+		}
+		e, err := p.parseOr(prefix)
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{Expr: e}, p.expect(tokenRParen, ")")
+	}
+
+	if t.kind != tokenWord {
+		return nil, errInvalidFilter("expected attribute in filter, got " + t.val)
+	}
+	p.pos++
+
+	path := prefix + normalizePath(t.val)
+
+	// A value path filters the sub-attributes of a multi-valued attribute, ie emails[type eq "work"].
+	if nt := p.peek(); nt != nil && nt.kind == tokenLBracket {
+		if prefix != "" {
+			return nil, errInvalidFilter("nested value paths are not supported")
+		}
+		p.pos++
+		e, err := p.parseOr(path + ".")
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(tokenRBracket, "]")
+	}
+
+	opTok := p.next()
+	if opTok == nil || opTok.kind != tokenWord {
+		return nil, errInvalidFilter("expected operator after " + t.val)
+	}
+	op := strings.ToLower(opTok.val)
+
+	if op == "pr" {
+		return attrExpr{Path: path, Op: op}, nil
+	} else if !filterCompareOps[op] {
+		return nil, errInvalidFilter("unsupported operator " + opTok.val)
+	}
+
+	vt := p.next()
+	if vt == nil {
+		return nil, errInvalidFilter("expected value after " + opTok.val)
+	}
+
+	var val interface{}
+	if vt.kind == tokenString {
+		val = vt.val
+	} else if vt.kind != tokenWord {
+		return nil, errInvalidFilter("expected value after " + opTok.val)
+	} else {
+		switch strings.ToLower(vt.val) {
+		case "true":
+			val = true
+		case "false":
+			val = false
+		case "null":
+			val = nil
+		default:
+			f, err := strconv.ParseFloat(vt.val, 64)
+			if err != nil {
+				return nil, errInvalidFilter("invalid value " + vt.val)
+			}
+			val = f
+		}
+	}
+
+	return attrExpr{Path: path, Op: op, Value: val}, nil
+}
+
+// normalizePath returns the lower case attribute path without the schema URI of the core resources.
+func normalizePath(s string) string {
+	s = strings.ToLower(s)
+	for _, schema := range []string{SchemaUser, SchemaGroup} {
+		if prefix := strings.ToLower(schema) + ":"; strings.HasPrefix(s, prefix) {
+			return strings.TrimPrefix(s, prefix)
+		}
+	}
+	return s
+}
+
+// filterColumnType defines how the value of an attribute is compared to the column.
+type filterColumnType int
+
+const (
+	columnString filterColumnType = iota
+	columnID
+	columnTime
+	columnActive
+)
+
+// filterColumn maps an attribute to a column of the database.
+type filterColumn struct {
+	Name string
+	Type filterColumnType
+}
+
+// filterSql returns the where condition with ? placeholders and the args for the filter. Only the attributes defined
+// by the columns can be filtered.
+func filterSql(e filterExpr, cols map[string]filterColumn) (string, []interface{}, error) {
+	switch e := e.(type) {
+	case logicalExpr:
+		l, largs, err := filterSql(e.Left, cols)
+		if err != nil {
+			return "", nil, err
+		}
+		r, rargs, err := filterSql(e.Right, cols)
+		if err != nil {
+			return "", nil, err
+		}
+		return "(" + l + " " + strings.ToUpper(e.Op) + " " + r + ")", append(largs, rargs...), nil
+	case notExpr:
+		s, args, err := filterSql(e.Expr, cols)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + s + ")", args, nil
+	case attrExpr:
+		col, ok := cols[e.Path]
+		if !ok {
+			return "", nil, errInvalidFilter("filtering by " + e.Path + " is not supported")
+		}
+		return attrSql(e, col)
+	}
+	return "", nil, errInvalidFilter("invalid filter")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// sqlCompareOps maps the comparison operators to SQL.
+var sqlCompareOps = map[string]string{
+	"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<=",
+}
+
+func attrSql(e attrExpr, col filterColumn) (string, []interface{}, error) {
+	invalidOp := errInvalidFilter("operator " + e.Op + " is not supported for " + e.Path)
+
+	if col.Type == columnActive {
+		// The active attribute is true when the status for the account is active.
+		if e.Op == "pr" {
+			return "TRUE", nil, nil
+		}
+		v, ok := e.Value.(bool)
+		if !ok {
+			return "", nil, errInvalidFilter(e.Path + " must be compared to a boolean")
+		}
+		switch e.Op {
+		case "eq":
+		case "ne":
+			v = !v
+		default:
+			return "", nil, invalidOp
+		}
+		if v {
+			return col.Name + " = 'active'", nil, nil
+		}
+		return col.Name + " <> 'active'", nil, nil
+	}
+
+	if e.Op == "pr" {
+		if col.Type == columnString {
+			return "(" + col.Name + " IS NOT NULL AND " + col.Name + " <> '')", nil, nil
+		}
+		return col.Name + " IS NOT NULL", nil, nil
+	}
+
+	v, ok := e.Value.(string)
+	if !ok {
+		return "", nil, errInvalidFilter(e.Path + " must be compared to a string")
+	}
+
+	switch col.Type {
+	case columnTime:
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", nil, errInvalidFilter(e.Path + " must be compared to a date time")
+		}
+		op, ok := sqlCompareOps[e.Op]
+		if !ok {
+			return "", nil, invalidOp
+		}
+		return col.Name + " " + op + " ?", []interface{}{t.UTC()}, nil
+	case columnID:
+		// Identifiers are case exact.
+		switch e.Op {
+		case "eq", "ne":
+			return col.Name + " " + sqlCompareOps[e.Op] + " ?", []interface{}{v}, nil
+		}
+		return "", nil, invalidOp
+	}
+
+	// String attributes are case insensitive.
+	switch e.Op {
+	case "co":
+		return col.Name + " ILIKE ?", []interface{}{"%" + likeEscaper.Replace(v) + "%"}, nil
+	case "sw":
+		return col.Name + " ILIKE ?", []interface{}{likeEscaper.Replace(v) + "%"}, nil
+	case "ew":
+		return col.Name + " ILIKE ?", []interface{}{"%" + likeEscaper.Replace(v)}, nil
+	}
+	return "lower(" + col.Name + ") " + sqlCompareOps[e.Op] + " lower(?)", []interface{}{v}, nil
+}
+
+// filterMatch evaluates the filter for a resource, the values returns the values of an attribute and false when the
+// attribute can't be filtered.
+func filterMatch(e filterExpr, values func(path string) ([]string, bool)) (bool, error) {
+	switch e := e.(type) {
+	case logicalExpr:
+		l, err := filterMatch(e.Left, values)
+		if err != nil {
+			return false, err
+		}
+		r, err := filterMatch(e.Right, values)
+		if err != nil {
+			return false, err
+		}
+		if e.Op == "and" {
+			return l && r, nil
+		}
+		return l || r, nil
+	case notExpr:
+		m, err := filterMatch(e.Expr, values)
+		return !m, err
+	case attrExpr:
+		vals, ok := values(e.Path)
+		if !ok {
+			return false, errInvalidFilter("filtering by " + e.Path + " is not supported")
+		}
+		if e.Op == "pr" {
+			return len(vals) > 0, nil
+		}
+
+		want, ok := e.Value.(string)
+		if !ok {
+			return false, errInvalidFilter(e.Path + " must be compared to a string")
+		}
+		want = strings.ToLower(want)
+
+		// Multi-valued attributes match when any of the values match, except for ne that requires none to be equal.
+		if e.Op == "ne" {
+			for _, v := range vals {
+				if strings.ToLower(v) == want {
+					return false, nil
+				}
+			}
+			return true, nil
+		}
+		for _, v := range vals {
+			v = strings.ToLower(v)
+			var m bool
+			switch e.Op {
+			case "eq":
+				m = v == want
+			case "co":
+				m = strings.Contains(v, want)
+			case "sw":
+				m = strings.HasPrefix(v, want)
+			case "ew":
+				m = strings.HasSuffix(v, want)
+			case "gt":
+				m = v > want
+			case "ge":
+				m = v >= want
+			case "lt":
+				m = v < want
+			case "le":
+				m = v <= want
+			}
+			if m {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, errInvalidFilter("invalid filter")
+}
+
+// patchPath is the parsed path of a patch operation, ie members[value eq "id"] or name.givenName.
+type patchPath struct {
+	Attr    string
+	Filter  filterExpr
+	SubAttr string
+}
+
+// parsePatchPath parses the path of a patch operation, see RFC 7644 section 3.5.2.
+func parsePatchPath(s string) (*patchPath, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errInvalidPath("path is empty")
+	}
+
+	i := strings.Index(s, "[")
+	if i < 0 {
+		return &patchPath{Attr: normalizePath(s)}, nil
+	}
+
+	j := strings.LastIndex(s, "]")
+	if j < i {
+		return nil, errInvalidPath("invalid path " + s)
+	}
+
+	pp := &patchPath{Attr: normalizePath(s[:i])}
+
+	f, err := parseValueFilter(s[i+1:j], pp.Attr+".")
+	if err != nil {
+		return nil, errInvalidPath("invalid filter in path " + s)
+	}
+	pp.Filter = f
+
+	if rest := s[j+1:]; rest != "" {
+		if !strings.HasPrefix(rest, ".") || len(rest) == 1 {
+			return nil, errInvalidPath("invalid path " + s)
+		}
+		pp.SubAttr = strings.ToLower(rest[1:])
+	}
+
+	return pp, nil
+}
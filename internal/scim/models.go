@@ -0,0 +1,225 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_role"
+	"geeks-accelerator/oss/saas-starter-kit/internal/saml"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/jmoiron/sqlx"
+)
+
+// Repository defines the required dependencies for SCIM provisioning.
+type Repository struct {
+	DbConn      *sqlx.DB
+	User        *user.Repository
+	UserAccount *user_account.Repository
+	AccountRole *account_role.Repository
+	Saml        *saml.Repository
+	WebApiUrl   func(urlPath string) string
+}
+
+// NewRepository creates a new Repository that defines dependencies for SCIM provisioning. The web api URL is used to
+// build the location of each resource. The domains verified for single sign-on determine which existing users can be
+// provisioned by an account.
+func NewRepository(db *sqlx.DB, user *user.Repository, usrAcc *user_account.Repository, accRole *account_role.Repository, samlRepo *saml.Repository, webApiUrl func(urlPath string) string) *Repository {
+	return &Repository{
+		DbConn:      db,
+		User:        user,
+		UserAccount: usrAcc,
+		AccountRole: accRole,
+		Saml:        samlRepo,
+		WebApiUrl:   webApiUrl,
+	}
+}
+
+// The schema URIs defined by RFC 7643 and RFC 7644.
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// The default and max number of resources returned per page of a list response.
+const (
+	DefaultCount = 100
+	MaxCount     = 200
+)
+
+// User is the SCIM representation of a user of an account. The user name is the email address of the user and the
+// user is active when the status for the account is active.
+type User struct {
+	Schemas    []string    `json:"schemas"`
+	ID         string      `json:"id,omitempty"`
+	ExternalID string      `json:"externalId,omitempty"`
+	UserName   string      `json:"userName"`
+	Name       *Name       `json:"name,omitempty"`
+	Emails     []Email     `json:"emails,omitempty"`
+	Active     *bool       `json:"active,omitempty"`
+	Groups     []Reference `json:"groups,omitempty"`
+	Meta       *Meta       `json:"meta,omitempty"`
+}
+
+// Name is the name of a user.
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// Email is an email address of a user.
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Group is the SCIM representation of a role of an account, the members are the users with the role.
+type Group struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	DisplayName string      `json:"displayName"`
+	Members     []Reference `json:"members,omitempty"`
+	Meta        *Meta       `json:"meta,omitempty"`
+}
+
+// Reference is a reference to another resource, ie the members of a group.
+type Reference struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// Meta contains the resource metadata.
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+// ListResponse is returned for a query of resources.
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// FindRequest defines the query of resources. The start index is 1-based and the count is limited to MaxCount. The members of groups are not returned
+// when excluded, identity providers request this to avoid listing all the users of the account.
+type FindRequest struct {
+	Filter         string
+	StartIndex     int
+	Count          int
+	ExcludeMembers bool
+}
+
+// PatchRequest defines the operations to modify a resource.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is a single operation of a patch request. The op is one of add, remove or replace.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Error is a SCIM error that is returned with the HTTP status, the SCIM type details the type of the bad request.
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// MarshalJSON returns the error response defined by RFC 7644 section 3.12.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Schemas  []string `json:"schemas"`
+		Status   string   `json:"status"`
+		ScimType string   `json:"scimType,omitempty"`
+		Detail   string   `json:"detail,omitempty"`
+	}{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(e.Status),
+		ScimType: e.ScimType,
+		Detail:   e.Detail,
+	})
+}
+
+// NewError returns a SCIM error with the HTTP status.
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{Status: status, ScimType: scimType, Detail: detail}
+}
+
+// The SCIM types of bad requests.
+const (
+	ScimTypeInvalidFilter = "invalidFilter"
+	ScimTypeInvalidPath   = "invalidPath"
+	ScimTypeInvalidValue  = "invalidValue"
+	ScimTypeInvalidSyntax = "invalidSyntax"
+	ScimTypeMutability    = "mutability"
+	ScimTypeNoTarget      = "noTarget"
+	ScimTypeUniqueness    = "uniqueness"
+)
+
+func errInvalidFilter(detail string) *Error {
+	return NewError(http.StatusBadRequest, ScimTypeInvalidFilter, detail)
+}
+
+func errInvalidValue(detail string) *Error {
+	return NewError(http.StatusBadRequest, ScimTypeInvalidValue, detail)
+}
+
+func errInvalidPath(detail string) *Error {
+	return NewError(http.StatusBadRequest, ScimTypeInvalidPath, detail)
+}
+
+// ServiceProviderConfig returns the features of the SCIM implementation, see RFC 7643 section 5.
+func (repo *Repository) ServiceProviderConfig() map[string]interface{} {
+	supported := func(v bool) map[string]interface{} {
+		return map[string]interface{}{"supported": v}
+	}
+
+	return map[string]interface{}{
+		"schemas":        []string{SchemaServiceProviderConfig},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": MaxCount},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]interface{}{
+			{
+				"type":        "oauthbearertoken",
+				"name":        "Bearer Token",
+				"description": "Authentication with an API key of the account that has the scim:provision permission.",
+				"primary":     true,
+			},
+		},
+		"meta": map[string]interface{}{
+			"resourceType": "ServiceProviderConfig",
+			"location":     repo.WebApiUrl("/scim/v2/ServiceProviderConfig"),
+		},
+	}
+}
@@ -0,0 +1,1178 @@
+package scim
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/saml"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+var (
+	// ErrNotFound occurs when a resource does not exist for the account.
+	ErrNotFound = errors.New("Entity not found")
+
+	// ErrForbidden occurs when the claims are not allowed to provision the account.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+)
+
+// userFilterColumns maps the attributes of a user that can be filtered to the columns of the users of an account.
+var userFilterColumns = map[string]filterColumn{
+	"id":                {Name: "id", Type: columnID},
+	"username":          {Name: "email", Type: columnString},
+	"emails":            {Name: "email", Type: columnString},
+	"emails.value":      {Name: "email", Type: columnString},
+	"name.givenname":    {Name: "first_name", Type: columnString},
+	"name.familyname":   {Name: "last_name", Type: columnString},
+	"name.formatted":    {Name: "name", Type: columnString},
+	"displayname":       {Name: "name", Type: columnString},
+	"active":            {Name: "status", Type: columnActive},
+	"meta.created":      {Name: "created_at", Type: columnTime},
+	"meta.lastmodified": {Name: "updated_at", Type: columnTime},
+}
+
+// claimsAccountID returns the account that is provisioned by the claims. The claims are issued for an API key of the
+// account with the scim:provision permission.
+func claimsAccountID(claims auth.Claims) (string, error) {
+	if claims.Audience == "" || !claims.HasPermission(auth.PermissionScimProvision) {
+		return "", errors.WithStack(ErrForbidden)
+	}
+	return claims.Audience, nil
+}
+
+// pageRange returns the start index and the count of resources to return for the request, the default count is used
+// when the count is not set.
+func pageRange(req FindRequest) (int, int) {
+	startIndex, count := req.StartIndex, req.Count
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count <= 0 {
+		count = DefaultCount
+	} else if count > MaxCount {
+		count = MaxCount
+	}
+	return startIndex, count
+}
+
+// UserFind returns the users of the account that match the filter.
+func (repo *Repository) UserFind(ctx context.Context, claims auth.Claims, req FindRequest) (*ListResponse, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.scim.UserFind")
+	defer span.Finish()
+
+	accountID, err := claimsAccountID(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	findReq := user_account.UserFindByAccountRequest{
+		AccountID: accountID,
+		Order:     []string{"created_at asc", "id asc"},
+	}
+	if req.Filter != "" {
+		f, err := parseFilter(req.Filter)
+		if err != nil {
+			return nil, err
+		}
+		where, args, err := filterSql(f, userFilterColumns)
+		if err != nil {
+			return nil, err
+		}
+		findReq = findReq.WithCondition(where, args...)
+	}
+
+	total, err := repo.UserAccount.UserCountByAccount(ctx, auth.Claims{}, findReq)
+	if err != nil {
+		return nil, err
+	}
+
+	startIndex, count := pageRange(req)
+
+	resources := []*User{}
+	if startIndex <= total {
+		limit, offset := uint(count), uint(startIndex-1)
+		findReq.Limit, findReq.Offset = &limit, &offset
+
+		users, err := repo.UserAccount.UserFindByAccount(ctx, auth.Claims{}, findReq)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			resources = append(resources, repo.userResource(u))
+		}
+	}
+
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}, nil
+}
+
+// UserRead returns the user of the account by ID.
+func (repo *Repository) UserRead(ctx context.Context, claims auth.Claims, id string) (*User, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.scim.UserRead")
+	defer span.Finish()
+
+	accountID, err := claimsAccountID(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := repo.readUser(ctx, accountID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.userResource(u), nil
+}
+
+// UserCreate provisions a user for the account. Users are global so an existing user with the same email address is
+// only added to the account when the account manages the user, otherwise the user is created with a random password
+// and linked to the account. New members of the account are given the user role, other roles are assigned with groups.
+func (repo *Repository) UserCreate(ctx context.Context, claims auth.Claims, req User, now time.Time) (*User, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.scim.UserCreate")
+	defer span.Finish()
+
+	accountID, err := claimsAccountID(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := userChangesFromResource(req)
+	if ch.Email == nil {
+		return nil, errInvalidValue("userName is required")
+	}
+
+	u, err := repo.User.ReadByEmail(ctx, auth.Claims{}, *ch.Email, false)
+	if err != nil {
+		if errors.Cause(err) != user.ErrNotFound {
+			return nil, err
+		}
+
+		if err := repo.checkEmailDomain(ctx, accountID, *ch.Email); err != nil {
+			return nil, err
+		}
+
+		// Provisioned users login with single sign-on, a random password is set that can be changed using reset
+		// password when single sign-on is not enforced.
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		pass := hex.EncodeToString(b)
+
+		createReq := user.UserCreateRequest{
+			Email:           *ch.Email,
+			Password:        pass,
+			PasswordConfirm: pass,
+		}
+		if ch.FirstName != nil {
+			createReq.FirstName = *ch.FirstName
+		}
+		if ch.LastName != nil {
+			createReq.LastName = *ch.LastName
+		}
+
+		u, err = repo.User.Create(ctx, auth.Claims{}, createReq, now)
+		if err != nil {
+			return nil, err
+		}
+
+		err = repo.setProvisionedAccount(ctx, u.ID, accountID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ua, err := repo.UserAccount.Read(ctx, auth.Claims{}, user_account.UserAccountReadRequest{
+			UserID:          u.ID,
+			AccountID:       accountID,
+			IncludeArchived: true,
+		})
+		if err != nil && errors.Cause(err) != user_account.ErrNotFound {
+			return nil, err
+		} else if ua != nil && (ua.ArchivedAt == nil || !ua.ArchivedAt.Valid) {
+			return nil, NewError(http.StatusConflict, ScimTypeUniqueness, "User "+*ch.Email+" already exists for the account.")
+		}
+
+		// Other users can only join the account with an invite they accept.
+		managed, err := repo.managesUser(ctx, accountID, u.ID, u.Email)
+		if err != nil {
+			return nil, err
+		} else if !managed {
+			return nil, NewError(http.StatusConflict, ScimTypeUniqueness,
+				"User "+*ch.Email+" already exists and can only be added to the account with an invite.")
+		}
+	}
+
+	status := user_account.UserAccountStatus_Active
+	if ch.Active != nil && !*ch.Active {
+		status = user_account.UserAccountStatus_Disabled
+	}
+
+	_, err = repo.UserAccount.Create(ctx, auth.Claims{}, user_account.UserAccountCreateRequest{
+		UserID:    u.ID,
+		AccountID: accountID,
+		Roles:     user_account.UserAccountRoles{user_account.UserAccountRole_User},
+		Status:    &status,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := repo.readUser(ctx, accountID, u.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.userResource(res), nil
+}
+
+// UserReplace replaces the attributes of the user with the ones of the request.
+func (repo *Repository) UserReplace(ctx context.Context, claims auth.Claims, id string, req User, now time.Time) (*User, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.scim.UserReplace")
+	defer span.Finish()
+
+	accountID, err := claimsAccountID(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := repo.readUser(ctx, accountID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.updateUser(ctx, accountID, cur, userChangesFromResource(req), now)
+}
+
+// UserPatch applies the operations of the request to the user.
+func (repo *Repository) UserPatch(ctx context.Context, claims auth.Claims, id string, req PatchRequest, now time.Time) (*User, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.scim.UserPatch")
+	defer span.Finish()
+
+	accountID, err := claimsAccountID(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := repo.readUser(ctx, accountID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var ch userChanges
+	for _, op := range req.Operations {
+		if err := ch.applyPatch(op); err != nil {
+			return nil, err
+		}
+	}
+
+	return repo.updateUser(ctx, accountID, cur, ch, now)
+}
+
+// UserDelete removes the user from the account, the user is archived for the account.
+func (repo *Repository) UserDelete(ctx context.Context, claims auth.Claims, id string, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.scim.UserDelete")
+	defer span.Finish()
+
+	accountID, err := claimsAccountID(claims)
+	if err != nil {
+		return err
+	}
+
+	if _, err := repo.readUser(ctx, accountID, id); err != nil {
+		return err
+	}
+
+	err = repo.UserAccount.Archive(ctx, auth.Claims{}, user_account.UserAccountArchiveRequest{
+		UserID:    id,
+		AccountID: accountID,
+	}, now)
+	return mapUserAccountError(err)
+}
+
+// mapUserAccountError maps the errors for changes that would leave the account without its owner or an active admin
+// to SCIM errors.
+func mapUserAccountError(err error) error {
+	switch cause := errors.Cause(err); cause {
+	case user_account.ErrLastAdmin, user_account.ErrOwnerRole:
+		return NewError(http.StatusBadRequest, ScimTypeMutability, cause.Error()+".")
+	}
+	return err
+}
+
+// readUser returns the user of the account, users removed from the account are not found.
+func (repo *Repository) readUser(ctx context.Context, accountID, id string) (*user_account.User, error) {
+	// IDs assigned by the identity provider are not valid user IDs.
+	if uuid.Parse(id) == nil {
+		return nil, errors.WithMessagef(ErrNotFound, "user %s not found for account %s", id, accountID)
+	}
+
+	users, err := repo.UserAccount.UserFindByAccount(ctx, auth.Claims{}, user_account.UserFindByAccountRequest{
+		AccountID: accountID,
+		Filter: filter.Filter{
+			{Field: "id", Operator: filter.Op_Eq, Values: []string{id}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	} else if len(users) == 0 {
+		return nil, errors.WithMessagef(ErrNotFound, "user %s not found for account %s", id, accountID)
+	}
+	return users[0], nil
+}
+
+// managesUser returns true when the account provisioned the user or has verified the domain of the email address for
+// single sign-on. Only those users can be added to the account by SCIM or have their name and email address changed.
+func (repo *Repository) managesUser(ctx context.Context, accountID, userID, email string) (bool, error) {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Select("id")
+	query.From("users")
+	query.Where(query.And(
+		query.Equal("id", userID),
+		query.Equal("provisioned_account_id", accountID),
+	))
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var ids []string
+	if err := repo.DbConn.SelectContext(ctx, &ids, queryStr, args...); err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "provisioned account for user %s failed", userID)
+		return false, err
+	} else if len(ids) > 0 {
+		return true, nil
+	}
+
+	domainAccountID, err := repo.domainAccountID(ctx, email)
+	if err != nil {
+		return false, err
+	}
+	return domainAccountID == accountID, nil
+}
+
+// domainAccountID returns the account that has verified the domain of the email address for single sign-on, an
+// empty string is returned when the domain is not verified.
+func (repo *Repository) domainAccountID(ctx context.Context, email string) (string, error) {
+	i := strings.LastIndex(email, "@")
+	if i < 0 || repo.Saml == nil {
+		return "", nil
+	}
+
+	sso, err := repo.Saml.ReadByDomain(ctx, email[i+1:])
+	if err != nil {
+		if errors.Cause(err) == saml.ErrNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return sso.AccountID, nil
+}
+
+// checkEmailDomain ensures the email address is not for a domain verified by another account, which manages the
+// users of the domain with its own identity provider.
+func (repo *Repository) checkEmailDomain(ctx context.Context, accountID, email string) error {
+	domainAccountID, err := repo.domainAccountID(ctx, email)
+	if err != nil {
+		return err
+	} else if domainAccountID != "" && domainAccountID != accountID {
+		return errInvalidValue("Email " + email + " is for a domain verified by another account.")
+	}
+	return nil
+}
+
+// setProvisionedAccount links a user created by SCIM to the account that provisioned it.
+func (repo *Repository) setProvisionedAccount(ctx context.Context, userID, accountID string) error {
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update("users")
+	query.Set(query.Assign("provisioned_account_id", accountID))
+	query.Where(query.Equal("id", userID))
+
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "set provisioned account for user %s failed", userID)
+		return err
+	}
+	return nil
+}
+
+// updateUser applies the changes to the user. The name and the email address are global for the user so they can
+// only be changed when the account manages the user and the user is not a member of other accounts, including
+// accounts the user has been removed from.
+func (repo *Repository) updateUser(ctx context.Context, accountID string, cur *user_account.User, ch userChanges, now time.Time) (*User, error) {
+	upReq := user.UserUpdateRequest{ID: cur.ID}
+	if ch.Email != nil && !strings.EqualFold(*ch.Email, cur.Email) {
+		upReq.Email = ch.Email
+	}
+	if ch.FirstName != nil && *ch.FirstName != cur.FirstName {
+		upReq.FirstName = ch.FirstName
+	}
+	if ch.LastName != nil && *ch.LastName != cur.LastName {
+		upReq.LastName = ch.LastName
+	}
+
+	if upReq.Email != nil || upReq.FirstName != nil || upReq.LastName != nil {
+		managed, err := repo.managesUser(ctx, accountID, cur.ID, cur.Email)
+		if err != nil {
+			return nil, err
+		} else if !managed {
+			return nil, NewError(http.StatusBadRequest, ScimTypeMutability,
+				"The user was not provisioned by the account, the name and email address can't be changed.")
+		}
+
+		if upReq.Email != nil {
+			if err := repo.checkEmailDomain(ctx, accountID, *upReq.Email); err != nil {
+				return nil, err
+			}
+		}
+
+		uas, err := repo.UserAccount.FindByUserID(ctx, auth.Claims{}, cur.ID, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, ua := range uas {
+			if ua.AccountID != accountID {
+				return nil, NewError(http.StatusBadRequest, ScimTypeMutability,
+					"The user is a member of other accounts, the name and email address can't be changed.")
+			}
+		}
+
+		if upReq.Email != nil {
+			uniq, err := user.UniqueEmail(ctx, repo.DbConn, *upReq.Email, cur.ID)
+			if err != nil {
+				return nil, err
+			} else if !uniq {
+				return nil, NewError(http.StatusConflict, ScimTypeUniqueness, "Email "+*upReq.Email+" is already used by another user.")
+			}
+		}
+
+		if err := repo.User.Update(ctx, auth.Claims{}, upReq, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if ch.Active != nil && *ch.Active != (cur.Status == user_account.UserAccountStatus_Active) {
+		status := user_account.UserAccountStatus_Active
+		if !*ch.Active {
+			status = user_account.UserAccountStatus_Disabled
+		}
+
+		err := repo.UserAccount.Update(ctx, auth.Claims{}, user_account.UserAccountUpdateRequest{
+			UserID:    cur.ID,
+			AccountID: accountID,
+			Status:    &status,
+		}, now)
+		if err != nil {
+			return nil, mapUserAccountError(err)
+		}
+	}
+
+	res, err := repo.readUser(ctx, accountID, cur.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.userResource(res), nil
+}
+
+// userResource returns the SCIM representation of the user, the groups are the roles for the account.
+func (repo *Repository) userResource(u *user_account.User) *User {
+	active := u.Status == user_account.UserAccountStatus_Active
+	created, updated := u.CreatedAt.UTC(), u.UpdatedAt.UTC()
+
+	res := &User{
+		Schemas:  []string{SchemaUser},
+		ID:       u.ID,
+		UserName: u.Email,
+		Name: &Name{
+			Formatted:  strings.TrimSpace(u.FirstName + " " + u.LastName),
+			GivenName:  u.FirstName,
+			FamilyName: u.LastName,
+		},
+		Emails: []Email{{Value: u.Email, Type: "work", Primary: true}},
+		Active: &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      &created,
+			LastModified: &updated,
+			Location:     repo.WebApiUrl("/scim/v2/Users/" + u.ID),
+		},
+	}
+
+	for _, r := range u.Roles {
+		res.Groups = append(res.Groups, Reference{
+			Value:   r.String(),
+			Ref:     repo.WebApiUrl("/scim/v2/Groups/" + r.String()),
+			Display: r.String(),
+		})
+	}
+
+	return res
+}
+
+// userChanges are the attributes of a user set by a request, nil values are left unchanged.
+type userChanges struct {
+	Email     *string
+	FirstName *string
+	LastName  *string
+	Active    *bool
+}
+
+// userChangesFromResource returns the changes for the user of a create or replace request.
+func userChangesFromResource(req User) userChanges {
+	var ch userChanges
+
+	email := req.UserName
+	if email == "" {
+		email = primaryEmail(req.Emails)
+	}
+	if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+		ch.Email = &email
+	}
+
+	if req.Name != nil {
+		if req.Name.GivenName != "" {
+			ch.FirstName = &req.Name.GivenName
+		}
+		if req.Name.FamilyName != "" {
+			ch.LastName = &req.Name.FamilyName
+		}
+	}
+
+	ch.Active = req.Active
+
+	return ch
+}
+
+// primaryEmail returns the primary email address, or the first when none is primary.
+func primaryEmail(emails []Email) string {
+	for _, e := range emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+// applyPatch applies a patch operation to the changes. Attributes that are not stored are ignored, ie externalId.
+func (ch *userChanges) applyPatch(op PatchOperation) error {
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+	case "remove":
+		if op.Path == "" {
+			return NewError(http.StatusBadRequest, ScimTypeNoTarget, "path is required to remove an attribute")
+		}
+		pp, err := parsePatchPath(op.Path)
+		if err != nil {
+			return err
+		}
+		switch pp.Attr {
+		case "username", "name", "name.givenname", "name.familyname", "emails":
+			return NewError(http.StatusBadRequest, ScimTypeMutability, pp.Attr+" is required and can't be removed")
+		}
+		return nil
+	default:
+		return errInvalidValue("unsupported patch operation " + op.Op)
+	}
+
+	// Without a path the value contains the attributes to set.
+	if op.Path == "" {
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attrs); err != nil {
+			return errInvalidValue("value must be an object when the path is not set")
+		}
+		for k, v := range attrs {
+			if err := ch.set(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return ch.set(op.Path, op.Value)
+}
+
+// set sets the attribute of the path to the value.
+func (ch *userChanges) set(path string, v json.RawMessage) error {
+	pp, err := parsePatchPath(path)
+	if err != nil {
+		return err
+	}
+
+	attr := pp.Attr
+	if pp.SubAttr != "" {
+		attr += "." + pp.SubAttr
+	}
+
+	switch attr {
+	case "username", "emails.value":
+		s, err := stringValue(attr, v)
+		if err != nil {
+			return err
+		}
+		s = strings.ToLower(strings.TrimSpace(s))
+		ch.Email = &s
+	case "emails":
+		var emails []Email
+		if err := json.Unmarshal(v, &emails); err != nil {
+			return errInvalidValue("emails must be a list of emails")
+		}
+		if s := strings.ToLower(strings.TrimSpace(primaryEmail(emails))); s != "" {
+			ch.Email = &s
+		}
+	case "name":
+		var n Name
+		if err := json.Unmarshal(v, &n); err != nil {
+			return errInvalidValue("name must be an object")
+		}
+		if n.GivenName != "" {
+			ch.FirstName = &n.GivenName
+		}
+		if n.FamilyName != "" {
+			ch.LastName = &n.FamilyName
+		}
+	case "name.givenname":
+		s, err := stringValue(attr, v)
+		if err != nil {
+			return err
+		}
+		ch.FirstName = &s
+	case "name.familyname":
+		s, err := stringValue(attr, v)
+		if err != nil {
+			return err
+		}
+		ch.LastName = &s
+	case "active":
+		b, err := boolValue(attr, v)
+		if err != nil {
+			return err
+		}
+		ch.Active = &b
+	}
+
+	return nil
+}
+
+// stringValue decodes a string value for the attribute.
+func stringValue(attr string, v json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil || strings.TrimSpace(s) == "" {
+		return "", errInvalidValue(attr + " must be a non empty string")
+	}
+	return s, nil
+}
+
+// boolValue decodes a boolean value for the attribute, some identity providers send booleans as strings.
+func boolValue(attr string, v json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(v, &b); err == nil {
+		return b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+
+	return false, errInvalidValue(attr + " must be a boolean")
+}
+
+// accountGroup is a role of the account that is exposed as a group.
+type accountGroup struct {
+	Name      string
+	CreatedAt *time.Time
+	UpdatedAt *time.Time
+}
+
+// GroupFind returns the roles of the account that match the filter as groups.
+func (repo *Repository) GroupFind(ctx context.Context, claims auth.Claims, req FindRequest) (*ListResponse, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.scim.GroupFind")
+	defer span.Finish()
+
+	accountID, err := claimsAccountID(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	var f filterExpr
+	if req.Filter != "" {
+		f, err = parseFilter(req.Filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := repo.groups(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []*Group
+	for _, g := range groups {
+		// Members are only loaded when they are returned or filtered.
+		var members user_account.Users
+		if !req.ExcludeMembers || f != nil {
+			members, err = repo.groupMembers(ctx, accountID, g.Name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		res := repo.groupResource(g, members)
+
+		if f != nil {
+			ok, err := filterMatch(f, groupValues(res))
+			if err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+		}
+
+		if req.ExcludeMembers {
+			res.Members = nil
+		}
+		matches = append(matches, res)
+	}
+
+	startIndex, count := pageRange(req)
+
+	resources := []*Group{}
+	if startIndex <= len(matches) {
+		end := startIndex - 1 + count
+		if end > len(matches) {
+			end = len(matches)
+		}
+		resources = append(resources, matches[startIndex-1:end]...)
+	}
+
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: len(matches),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}, nil
+}
+
+// GroupRead returns the role of the account by name as a group.
+func (repo *Repository) GroupRead(ctx context.Context, claims auth.Claims, id string, excludeMembers bool) (*Group, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.scim.GroupRead")
+	defer span.Finish()
+
+	accountID, err := claimsAccountID(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := repo.readGroup(ctx, accountID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if excludeMembers {
+		return repo.groupResource(g, nil), nil
+	}
+
+	members, err := repo.groupMembers(ctx, accountID, g.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.groupResource(g, members), nil
+}
+
+// GroupReplace replaces the members of the group. Groups are the roles of the account so the display name can't be
+// changed.
+func (repo *Repository) GroupReplace(ctx context.Context, claims auth.Claims, id string, req Group, now time.Time) (*Group, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.scim.GroupReplace")
+	defer span.Finish()
+
+	accountID, err := claimsAccountID(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := repo.readGroup(ctx, accountID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkDisplayName(g, req.DisplayName); err != nil {
+		return nil, err
+	}
+
+	members, err := repo.groupMembers(ctx, accountID, g.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	want := make(map[string]bool)
+	for _, m := range req.Members {
+		want[m.Value] = true
+	}
+
+	return repo.updateGroupMembers(ctx, accountID, g, members, want, now)
+}
+
+// GroupPatch applies the operations of the request to the members of the group.
+func (repo *Repository) GroupPatch(ctx context.Context, claims auth.Claims, id string, req PatchRequest, now time.Time) (*Group, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.scim.GroupPatch")
+	defer span.Finish()
+
+	accountID, err := claimsAccountID(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := repo.readGroup(ctx, accountID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := repo.groupMembers(ctx, accountID, g.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	want := make(map[string]bool)
+	for _, m := range members {
+		want[m.ID] = true
+	}
+
+	for _, op := range req.Operations {
+		opName := strings.ToLower(op.Op)
+		switch opName {
+		case "add", "remove", "replace":
+		default:
+			return nil, errInvalidValue("unsupported patch operation " + op.Op)
+		}
+
+		// Without a path the value contains the attributes to set.
+		if op.Path == "" {
+			if opName == "remove" {
+				return nil, NewError(http.StatusBadRequest, ScimTypeNoTarget, "path is required to remove an attribute")
+			}
+
+			var attrs map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &attrs); err != nil {
+				return nil, errInvalidValue("value must be an object when the path is not set")
+			}
+			for k, v := range attrs {
+				switch normalizePath(k) {
+				case "members":
+					if err := patchMembers(want, opName, nil, v); err != nil {
+						return nil, err
+					}
+				case "displayname":
+					if err := checkDisplayNameValue(g, v); err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		}
+
+		pp, err := parsePatchPath(op.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		switch pp.Attr {
+		case "members":
+			if pp.SubAttr != "" && pp.SubAttr != "value" {
+				return nil, errInvalidPath("invalid path " + op.Path)
+			}
+			if err := patchMembers(want, opName, pp.Filter, op.Value); err != nil {
+				return nil, err
+			}
+		case "displayname":
+			if opName == "remove" {
+				return nil, NewError(http.StatusBadRequest, ScimTypeMutability, "displayName is required and can't be removed")
+			}
+			if err := checkDisplayNameValue(g, op.Value); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errInvalidPath("unsupported path " + op.Path)
+		}
+	}
+
+	return repo.updateGroupMembers(ctx, accountID, g, members, want, now)
+}
+
+// patchMembers applies the operation to the IDs of the members of the group. The filter selects the members to remove,
+// ie members[value eq "id"].
+func patchMembers(want map[string]bool, op string, f filterExpr, v json.RawMessage) error {
+	var ids []string
+	if len(v) > 0 && string(v) != "null" {
+		var refs []Reference
+		if err := json.Unmarshal(v, &refs); err != nil {
+			// Some identity providers send a single member instead of a list.
+			var ref Reference
+			if err := json.Unmarshal(v, &ref); err != nil {
+				return errInvalidValue("members must be a list of members")
+			}
+			refs = append(refs, ref)
+		}
+		for _, r := range refs {
+			if r.Value == "" {
+				return errInvalidValue("member value is required")
+			}
+			ids = append(ids, r.Value)
+		}
+	}
+
+	if f != nil {
+		if op != "remove" {
+			return errInvalidPath("a filter on members is only supported to remove members")
+		}
+		for id := range want {
+			ok, err := filterMatch(f, func(path string) ([]string, bool) {
+				if path == "members.value" {
+					return []string{id}, true
+				}
+				return nil, false
+			})
+			if err != nil {
+				return err
+			} else if ok {
+				delete(want, id)
+			}
+		}
+		return nil
+	}
+
+	switch op {
+	case "add":
+		for _, id := range ids {
+			want[id] = true
+		}
+	case "remove":
+		// All the members are removed when none are specified.
+		if len(ids) == 0 {
+			for id := range want {
+				delete(want, id)
+			}
+		}
+		for _, id := range ids {
+			delete(want, id)
+		}
+	case "replace":
+		for id := range want {
+			delete(want, id)
+		}
+		for _, id := range ids {
+			want[id] = true
+		}
+	}
+
+	return nil
+}
+
+// checkDisplayName ensures the display name is not changed, groups are the roles of the account and are renamed in
+// the app since the permissions are managed there.
+func checkDisplayName(g accountGroup, displayName string) error {
+	if displayName != "" && !strings.EqualFold(displayName, g.Name) {
+		return NewError(http.StatusBadRequest, ScimTypeMutability, "The displayName of a group can't be changed.")
+	}
+	return nil
+}
+
+func checkDisplayNameValue(g accountGroup, v json.RawMessage) error {
+	s, err := stringValue("displayName", v)
+	if err != nil {
+		return err
+	}
+	return checkDisplayName(g, s)
+}
+
+// updateGroupMembers adds the role to the users that should be members and removes it from the users that no longer
+// are. Users without any roles are given the user role since a user of an account requires at least one role.
+func (repo *Repository) updateGroupMembers(ctx context.Context, accountID string, g accountGroup, members user_account.Users, want map[string]bool, now time.Time) (*Group, error) {
+	role := user_account.UserAccountRole(g.Name)
+
+	current := make(map[string]bool)
+	for _, m := range members {
+		current[m.ID] = true
+
+		if want[m.ID] {
+			continue
+		}
+
+		var roles user_account.UserAccountRoles
+		for _, r := range m.Roles {
+			if r != role {
+				roles = append(roles, r)
+			}
+		}
+		if len(roles) == 0 {
+			roles = append(roles, user_account.UserAccountRole_User)
+		}
+		if len(roles) == len(m.Roles) {
+			continue
+		}
+
+		err := repo.UserAccount.Update(ctx, auth.Claims{}, user_account.UserAccountUpdateRequest{
+			UserID:    m.ID,
+			AccountID: accountID,
+			Roles:     &roles,
+		}, now)
+		if err != nil {
+			return nil, mapUserAccountError(err)
+		}
+	}
+
+	// Add the role in a consistent order.
+	var added []string
+	for id := range want {
+		if !current[id] {
+			added = append(added, id)
+		}
+	}
+	sort.Strings(added)
+
+	for _, id := range added {
+		u, err := repo.readUser(ctx, accountID, id)
+		if err != nil {
+			if errors.Cause(err) == ErrNotFound {
+				return nil, errInvalidValue("Member " + id + " is not a user of the account.")
+			}
+			return nil, err
+		}
+
+		roles := append(user_account.UserAccountRoles{}, u.Roles...)
+		roles = append(roles, role)
+
+		err = repo.UserAccount.Update(ctx, auth.Claims{}, user_account.UserAccountUpdateRequest{
+			UserID:    id,
+			AccountID: accountID,
+			Roles:     &roles,
+		}, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	members, err := repo.groupMembers(ctx, accountID, g.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.groupResource(g, members), nil
+}
+
+// groups returns the built-in roles and the custom roles of the account.
+func (repo *Repository) groups(ctx context.Context, accountID string) ([]accountGroup, error) {
+	var groups []accountGroup
+	for _, r := range user_account.UserAccountRole_Values {
+		groups = append(groups, accountGroup{Name: r.String()})
+	}
+
+	roles, err := repo.AccountRole.FindByAccountID(ctx, auth.Claims{}, accountID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range roles {
+		created, updated := r.CreatedAt.UTC(), r.UpdatedAt.UTC()
+		groups = append(groups, accountGroup{Name: r.Name, CreatedAt: &created, UpdatedAt: &updated})
+	}
+
+	return groups, nil
+}
+
+// readGroup returns the role of the account by name.
+func (repo *Repository) readGroup(ctx context.Context, accountID, id string) (accountGroup, error) {
+	groups, err := repo.groups(ctx, accountID)
+	if err != nil {
+		return accountGroup{}, err
+	}
+	for _, g := range groups {
+		if g.Name == id {
+			return g, nil
+		}
+	}
+	return accountGroup{}, errors.WithMessagef(ErrNotFound, "group %s not found for account %s", id, accountID)
+}
+
+// groupMembers returns the users of the account with the role.
+func (repo *Repository) groupMembers(ctx context.Context, accountID, role string) (user_account.Users, error) {
+	return repo.UserAccount.UserFindByAccount(ctx, auth.Claims{}, user_account.UserFindByAccountRequest{
+		AccountID: accountID,
+		Role:      role,
+		Order:     []string{"created_at asc", "id asc"},
+	})
+}
+
+// groupResource returns the SCIM representation of the role.
+func (repo *Repository) groupResource(g accountGroup, members user_account.Users) *Group {
+	res := &Group{
+		Schemas:     []string{SchemaGroup},
+		ID:          g.Name,
+		DisplayName: g.Name,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      g.CreatedAt,
+			LastModified: g.UpdatedAt,
+			Location:     repo.WebApiUrl("/scim/v2/Groups/" + g.Name),
+		},
+	}
+
+	for _, m := range members {
+		res.Members = append(res.Members, Reference{
+			Value:   m.ID,
+			Ref:     repo.WebApiUrl("/scim/v2/Users/" + m.ID),
+			Display: m.Email,
+		})
+	}
+
+	return res
+}
+
+// groupValues returns the values of the attributes of a group that can be filtered.
+func groupValues(g *Group) func(path string) ([]string, bool) {
+	return func(path string) ([]string, bool) {
+		switch path {
+		case "id":
+			return []string{g.ID}, true
+		case "displayname":
+			return []string{g.DisplayName}, true
+		case "members", "members.value":
+			var ids []string
+			for _, m := range g.Members {
+				ids = append(ids, m.Value)
+			}
+			return ids, true
+		}
+		return nil, false
+	}
+}
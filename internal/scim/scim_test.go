@@ -0,0 +1,428 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_role"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/saml"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var test *tests.Test
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+	return m.Run()
+}
+
+// TestFilterSql validates SCIM filters are parsed and converted to SQL.
+func TestFilterSql(t *testing.T) {
+	now := time.Date(2019, time.August, 23, 0, 0, 0, 0, time.UTC)
+
+	var filterTests = []struct {
+		filter string
+		where  string
+		args   []interface{}
+		err    string
+	}{
+		{`userName eq "Gabi@Example.com"`, "lower(email) = lower(?)", []interface{}{"Gabi@Example.com"}, ""},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName Eq "a"`, "lower(email) = lower(?)", []interface{}{"a"}, ""},
+		{`name.familyName co "o_%"`, "last_name ILIKE ?", []interface{}{`%o\_\%%`}, ""},
+		{`emails[type eq "work" and value sw "gabi"]`, "", nil, ScimTypeInvalidFilter},
+		{`emails[value ew "@example.com"]`, "email ILIKE ?", []interface{}{"%@example.com"}, ""},
+		{`active eq false`, "status <> 'active'", nil, ""},
+		{`not (active ne true)`, "NOT (status <> 'active')", nil, ""},
+		{`title pr`, "", nil, ScimTypeInvalidFilter},
+		{`id eq "1" or (name.givenName pr and meta.lastModified gt "2019-08-23T00:00:00Z")`,
+			"(id = ? OR ((first_name IS NOT NULL AND first_name <> '') AND updated_at > ?))",
+			[]interface{}{"1", now}, ""},
+		{`id co "1"`, "", nil, ScimTypeInvalidFilter},
+		{`userName eq`, "", nil, ScimTypeInvalidFilter},
+		{`userName eq "a" and`, "", nil, ScimTypeInvalidFilter},
+		{`(userName eq "a"`, "", nil, ScimTypeInvalidFilter},
+		{`userName eq "a`, "", nil, ScimTypeInvalidFilter},
+		{`userName regex "a"`, "", nil, ScimTypeInvalidFilter},
+		{`active eq "true"`, "", nil, ScimTypeInvalidFilter},
+	}
+
+	t.Log("Given the need to filter users with SCIM filters.")
+	{
+		for i, tt := range filterTests {
+			t.Logf("\tTest: %d\tWhen filtering with %s", i, tt.filter)
+			{
+				var (
+					where string
+					args  []interface{}
+				)
+				f, err := parseFilter(tt.filter)
+				if err == nil {
+					where, args, err = filterSql(f, userFilterColumns)
+				}
+
+				if tt.err != "" {
+					serr, ok := err.(*Error)
+					if !ok || serr.ScimType != tt.err || serr.Status != http.StatusBadRequest {
+						t.Logf("\t\tGot : %+v", err)
+						t.Logf("\t\tWant: %+v", tt.err)
+						t.Fatalf("\t%s\tInvalid filter failed.", tests.Failed)
+					}
+					t.Logf("\t%s\tInvalid filter ok.", tests.Success)
+					continue
+				}
+
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tFilter failed.", tests.Failed)
+				} else if where != tt.where || !reflect.DeepEqual(args, tt.args) {
+					t.Logf("\t\tGot : %s %v", where, args)
+					t.Logf("\t\tWant: %s %v", tt.where, tt.args)
+					t.Fatalf("\t%s\tFilter failed.", tests.Failed)
+				}
+				t.Logf("\t%s\tFilter ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestPatchMembers validates patch operations on the members of a group.
+func TestPatchMembers(t *testing.T) {
+	var patchTests = []struct {
+		op     string
+		path   string
+		value  string
+		want   []string
+		errTyp string
+	}{
+		{"add", "members", `[{"value":"c"},{"value":"d"}]`, []string{"a", "b", "c", "d"}, ""},
+		{"add", "members", `{"value":"c"}`, []string{"a", "b", "c"}, ""},
+		{"remove", `members[value eq "a"]`, ``, []string{"b"}, ""},
+		{"remove", `members[value eq "a" or value eq "b"]`, ``, []string{}, ""},
+		{"remove", "members", `[{"value":"b"}]`, []string{"a"}, ""},
+		{"remove", "members", ``, []string{}, ""},
+		{"replace", "members", `[{"value":"c"}]`, []string{"c"}, ""},
+		{"add", `members[value eq "a"]`, `[{"value":"c"}]`, nil, ScimTypeInvalidPath},
+		{"add", "members", `[{"display":"c"}]`, nil, ScimTypeInvalidValue},
+	}
+
+	t.Log("Given the need to patch the members of a group.")
+	{
+		for i, tt := range patchTests {
+			t.Logf("\tTest: %d\tWhen %s %s %s", i, tt.op, tt.path, tt.value)
+			{
+				want := map[string]bool{"a": true, "b": true}
+
+				pp, err := parsePatchPath(tt.path)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tParse path failed.", tests.Failed)
+				}
+
+				err = patchMembers(want, tt.op, pp.Filter, json.RawMessage(tt.value))
+				if tt.errTyp != "" {
+					if serr, ok := err.(*Error); !ok || serr.ScimType != tt.errTyp {
+						t.Logf("\t\tGot : %+v", err)
+						t.Logf("\t\tWant: %+v", tt.errTyp)
+						t.Fatalf("\t%s\tInvalid patch failed.", tests.Failed)
+					}
+					t.Logf("\t%s\tInvalid patch ok.", tests.Success)
+					continue
+				} else if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tPatch failed.", tests.Failed)
+				}
+
+				got := []string{}
+				for _, id := range []string{"a", "b", "c", "d"} {
+					if want[id] {
+						got = append(got, id)
+					}
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Logf("\t\tGot : %v", got)
+					t.Logf("\t\tWant: %v", tt.want)
+					t.Fatalf("\t%s\tPatch failed.", tests.Failed)
+				}
+				t.Logf("\t%s\tPatch ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestProvision validates users and group membership are provisioned for an account.
+func TestProvision(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2019, time.August, 23, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	acc, err := account.MockAccount(ctx, test.MasterDB, now)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tMockAccount failed.", tests.Failed)
+	}
+
+	webApiUrl := func(p string) string {
+		return "http://127.0.0.1:3001" + p
+	}
+	usrRepo := user.MockRepository(test.MasterDB)
+	usrAccRepo := user_account.NewRepository(test.MasterDB)
+	samlRepo := saml.NewRepository(test.MasterDB, usrRepo, usrAccRepo, webApiUrl)
+	repo := NewRepository(test.MasterDB, usrRepo, usrAccRepo, account_role.NewRepository(test.MasterDB), samlRepo, webApiUrl)
+
+	// Claims of an API key for the account scoped to SCIM provisioning.
+	claims := auth.Claims{
+		Permissions: []string{auth.PermissionScimProvision},
+		ApiKeyID:    uuid.NewRandom().String(),
+	}
+	claims.Subject = uuid.NewRandom().String()
+	claims.Audience = acc.ID
+
+	email := "scim-" + strings.Split(uuid.NewRandom().String(), "-")[0] + "@example.com"
+
+	t.Log("Given the need to provision users with SCIM.")
+	{
+		// Claims without the permission can't provision the account.
+		readClaims := claims
+		readClaims.Permissions = []string{auth.PermissionUserRead}
+		_, err = repo.UserFind(ctx, readClaims, FindRequest{})
+		if errors.Cause(err) != ErrForbidden {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrForbidden)
+			t.Fatalf("\t%s\tFind without permission failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tFind without permission ok.", tests.Success)
+
+		u, err := repo.UserCreate(ctx, claims, User{
+			Schemas:  []string{SchemaUser},
+			UserName: strings.ToUpper(email),
+			Name:     &Name{GivenName: "Gabi", FamilyName: "May"},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+		} else if u.UserName != email || u.Active == nil || !*u.Active || len(u.Groups) != 1 || u.Groups[0].Value != auth.RoleUser {
+			t.Logf("\t\tGot : %+v", u)
+			t.Fatalf("\t%s\tCreate values failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate ok.", tests.Success)
+
+		_, err = repo.UserCreate(ctx, claims, User{UserName: email, Name: &Name{GivenName: "Gabi", FamilyName: "May"}}, now)
+		if serr, ok := errors.Cause(err).(*Error); !ok || serr.Status != http.StatusConflict {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tCreate existing failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate existing ok.", tests.Success)
+
+		res, err := repo.UserFind(ctx, claims, FindRequest{Filter: `userName eq "` + strings.ToUpper(email) + `"`})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tFind failed.", tests.Failed)
+		} else if users := res.Resources.([]*User); res.TotalResults != 1 || len(users) != 1 || users[0].ID != u.ID {
+			t.Logf("\t\tGot : %+v", res)
+			t.Fatalf("\t%s\tFind values failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tFind ok.", tests.Success)
+
+		// Identity providers deactivate users with a patch, some send the value as a string.
+		u, err = repo.UserPatch(ctx, claims, u.ID, PatchRequest{
+			Operations: []PatchOperation{
+				{Op: "Replace", Path: "active", Value: json.RawMessage(`"False"`)},
+				{Op: "replace", Value: json.RawMessage(`{"name.givenName":"Gabriella"}`)},
+			},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tPatch failed.", tests.Failed)
+		} else if *u.Active || u.Name.GivenName != "Gabriella" {
+			t.Logf("\t\tGot : %+v", u)
+			t.Fatalf("\t%s\tPatch values failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tPatch ok.", tests.Success)
+
+		ua, err := repo.UserAccount.Read(ctx, auth.Claims{}, user_account.UserAccountReadRequest{UserID: u.ID, AccountID: acc.ID})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead user account failed.", tests.Failed)
+		} else if ua.Status != user_account.UserAccountStatus_Disabled {
+			t.Logf("\t\tGot : %v", ua.Status)
+			t.Logf("\t\tWant: %v", user_account.UserAccountStatus_Disabled)
+			t.Fatalf("\t%s\tPatch status failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tPatch status ok.", tests.Success)
+
+		active := true
+		u, err = repo.UserReplace(ctx, claims, u.ID, User{
+			UserName: email,
+			Name:     &Name{GivenName: "Gabi", FamilyName: "May"},
+			Active:   &active,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tReplace failed.", tests.Failed)
+		} else if !*u.Active || u.Name.GivenName != "Gabi" {
+			t.Logf("\t\tGot : %+v", u)
+			t.Fatalf("\t%s\tReplace values failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tReplace ok.", tests.Success)
+
+		// Roles are assigned with the members of groups.
+		g, err := repo.GroupPatch(ctx, claims, auth.RoleAdmin, PatchRequest{
+			Operations: []PatchOperation{
+				{Op: "add", Path: "members", Value: json.RawMessage(`[{"value":"` + u.ID + `"}]`)},
+			},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGroup add member failed.", tests.Failed)
+		} else if len(g.Members) != 1 || g.Members[0].Value != u.ID {
+			t.Logf("\t\tGot : %+v", g)
+			t.Fatalf("\t%s\tGroup add member values failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tGroup add member ok.", tests.Success)
+
+		_, err = repo.GroupPatch(ctx, claims, auth.RoleAdmin, PatchRequest{
+			Operations: []PatchOperation{
+				{Op: "add", Path: "members", Value: json.RawMessage(`[{"value":"` + uuid.NewRandom().String() + `"}]`)},
+			},
+		}, now)
+		if serr, ok := errors.Cause(err).(*Error); !ok || serr.ScimType != ScimTypeInvalidValue {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tGroup add unknown member failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tGroup add unknown member ok.", tests.Success)
+
+		groups, err := repo.GroupFind(ctx, claims, FindRequest{Filter: `members[value eq "` + u.ID + `"]`})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGroup find failed.", tests.Failed)
+		} else if res := groups.Resources.([]*Group); groups.TotalResults != 2 || res[0].ID != auth.RoleAdmin || res[1].ID != auth.RoleUser {
+			t.Logf("\t\tGot : %+v", groups)
+			t.Fatalf("\t%s\tGroup find values failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tGroup find ok.", tests.Success)
+
+		// Removing the user from the user group leaves the admin role.
+		g, err = repo.GroupPatch(ctx, claims, auth.RoleUser, PatchRequest{
+			Operations: []PatchOperation{
+				{Op: "remove", Path: `members[value eq "` + u.ID + `"]`},
+			},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGroup remove member failed.", tests.Failed)
+		} else if len(g.Members) != 0 {
+			t.Logf("\t\tGot : %+v", g)
+			t.Fatalf("\t%s\tGroup remove member values failed.", tests.Failed)
+		}
+
+		u, err = repo.UserRead(ctx, claims, u.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead failed.", tests.Failed)
+		} else if len(u.Groups) != 1 || u.Groups[0].Value != auth.RoleAdmin {
+			t.Logf("\t\tGot : %+v", u.Groups)
+			t.Fatalf("\t%s\tGroup remove member roles failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tGroup remove member ok.", tests.Success)
+
+		// The last active admin of the account can't be removed.
+		_, err = repo.GroupReplace(ctx, claims, auth.RoleAdmin, Group{DisplayName: auth.RoleAdmin}, now)
+		if serr, ok := errors.Cause(err).(*Error); !ok || serr.ScimType != ScimTypeMutability {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tGroup remove last admin failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tGroup remove last admin ok.", tests.Success)
+
+		admin, err := user.MockUser(ctx, test.MasterDB, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tMockUser failed.", tests.Failed)
+		}
+		_, err = repo.UserAccount.Create(ctx, auth.Claims{}, user_account.UserAccountCreateRequest{
+			UserID:    admin.ID,
+			AccountID: acc.ID,
+			Roles:     []user_account.UserAccountRole{user_account.UserAccountRole_Admin},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate admin failed.", tests.Failed)
+		}
+
+		// Users that were not provisioned by the account can't be added or changed.
+		other, err := user.MockUser(ctx, test.MasterDB, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tMockUser failed.", tests.Failed)
+		}
+		_, err = repo.UserCreate(ctx, claims, User{UserName: other.Email, Name: &Name{GivenName: "Gabi", FamilyName: "May"}}, now)
+		if serr, ok := errors.Cause(err).(*Error); !ok || serr.Status != http.StatusConflict {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tCreate not provisioned failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate not provisioned ok.", tests.Success)
+
+		_, err = repo.UserReplace(ctx, claims, admin.ID, User{UserName: "taken-" + admin.Email, Active: &active}, now)
+		if serr, ok := errors.Cause(err).(*Error); !ok || serr.ScimType != ScimTypeMutability {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tReplace not provisioned failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tReplace not provisioned ok.", tests.Success)
+
+		// The last role of a user falls back to the user role.
+		_, err = repo.GroupReplace(ctx, claims, auth.RoleAdmin, Group{DisplayName: auth.RoleAdmin, Members: []Reference{{Value: admin.ID}}}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGroup replace failed.", tests.Failed)
+		}
+
+		u, err = repo.UserRead(ctx, claims, u.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead failed.", tests.Failed)
+		} else if len(u.Groups) != 1 || u.Groups[0].Value != auth.RoleUser {
+			t.Logf("\t\tGot : %+v", u.Groups)
+			t.Fatalf("\t%s\tGroup replace roles failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tGroup replace ok.", tests.Success)
+
+		_, err = repo.GroupReplace(ctx, claims, auth.RoleAdmin, Group{DisplayName: "owners"}, now)
+		if serr, ok := errors.Cause(err).(*Error); !ok || serr.ScimType != ScimTypeMutability {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tGroup rename failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tGroup rename ok.", tests.Success)
+
+		err = repo.UserDelete(ctx, claims, u.ID, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tDelete failed.", tests.Failed)
+		}
+
+		_, err = repo.UserRead(ctx, claims, u.ID)
+		if errors.Cause(err) != ErrNotFound {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrNotFound)
+			t.Fatalf("\t%s\tRead after delete failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tDelete ok.", tests.Success)
+	}
+}
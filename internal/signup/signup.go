@@ -9,6 +9,7 @@ import (
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/webhook"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
@@ -78,11 +79,11 @@ func (repo *Repository) Signup(ctx context.Context, claims auth.Claims, req Sign
 	}
 
 	// Associate the created user with the new account. The first user for the account will
-	// always have the role of admin.
+	// always have the role of admin and is the owner of the account.
 	ua := user_account.UserAccountCreateRequest{
 		UserID:    resp.User.ID,
 		AccountID: resp.Account.ID,
-		Roles:     []user_account.UserAccountRole{user_account.UserAccountRole_Admin},
+		Roles:     []user_account.UserAccountRole{user_account.UserAccountRole_Owner, user_account.UserAccountRole_Admin},
 		//Status:  Use default value
 	}
 
@@ -91,5 +92,16 @@ func (repo *Repository) Signup(ctx context.Context, claims auth.Claims, req Sign
 		return nil, err
 	}
 
+	err = webhook.Publish(ctx, repo.DbConn, resp.Account.ID, webhook.EventType_AccountCreated, resp.Account, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the email to the user to verify their email address.
+	_, err = repo.User.VerifyEmail(ctx, user.UserVerifyEmailRequest{Email: resp.User.Email}, now)
+	if err != nil {
+		return nil, err
+	}
+
 	return &resp, nil
 }
@@ -0,0 +1,563 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/totp"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/lib/pq"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"github.com/sudo-suhas/symcrypto"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// The database table for User MFA Recovery Codes
+	userMfaRecoveryCodeTableName = "user_mfa_recovery_codes"
+
+	// mfaRecoveryCodeCount is the number of recovery codes generated on enrollment.
+	mfaRecoveryCodeCount = 10
+
+	// mfaRecoveryCodeAlphabet excludes characters that are easily confused when read back by a user.
+	mfaRecoveryCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+	// MfaChallengeMaxAttempts is the number of codes that can be tried for a two-factor authentication challenge
+	// before the challenge is no longer valid and the user has to authenticate with their password again.
+	MfaChallengeMaxAttempts = 5
+)
+
+var (
+	// ErrMfaExpired occurs when the the mfa hash exceeds the expiration.
+	ErrMfaExpired = errors.New("Two-factor authentication challenge expired")
+
+	// ErrMfaAlreadyEnabled occurs when a user tries to enroll but has already enabled two-factor authentication.
+	ErrMfaAlreadyEnabled = errors.New("Two-factor authentication is already enabled")
+
+	// ErrMfaNotEnrolled occurs when a code is verified for a user that has not enrolled.
+	ErrMfaNotEnrolled = errors.New("Two-factor authentication is not enabled")
+
+	// ErrMfaInvalidCode occurs when the code supplied does not match the current code or an unused recovery code.
+	ErrMfaInvalidCode = errors.New("Invalid two-factor authentication code")
+
+	// ErrMfaChallengeInvalid occurs when a challenge has been completed, replaced by a new challenge or has exceeded
+	// the max number of attempts.
+	ErrMfaChallengeInvalid = errors.New("Two-factor authentication challenge is no longer valid")
+)
+
+// userMfa contains the private two-factor authentication details for a user.
+type userMfa struct {
+	Email     string
+	Secret    sql.NullString
+	EnabledAt pq.NullTime
+	LastStep  sql.NullInt64
+}
+
+// readMfa loads the two-factor authentication details for a user.
+func (repo *Repository) readMfa(ctx context.Context, userID string) (*userMfa, error) {
+	query := sqlbuilder.NewSelectBuilder().Select("email,mfa_secret,mfa_enabled_at,mfa_last_step").From(userTableName)
+	query.Where(query.And(
+		query.Equal("id", userID),
+		query.IsNull("archived_at"),
+	))
+
+	queryStr, queryArgs := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var m userMfa
+	err := repo.DbConn.QueryRowContext(ctx, queryStr, queryArgs...).Scan(&m.Email, &m.Secret, &m.EnabledAt, &m.LastStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.WithMessagef(ErrNotFound, "user %s not found", userID)
+		}
+		err = errors.Wrapf(err, "query - %s", query.String())
+		return nil, err
+	}
+
+	if m.Secret.Valid && m.Secret.String != "" {
+		crypto, err := symcrypto.New(repo.secretKey)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		m.Secret.String, err = crypto.Decrypt(m.Secret.String)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return &m, nil
+}
+
+// MfaEnroll generates a new two-factor authentication secret and set of recovery codes for the user. Enrollment is
+// not complete until the first code generated by the authenticator app is verified with MfaVerify.
+func (repo *Repository) MfaEnroll(ctx context.Context, claims auth.Claims, req UserMfaEnrollRequest, now time.Time) (*UserMfaEnrollment, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.MfaEnroll")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Two-factor authentication can only be managed by the user themselves.
+	err = repo.canManageMfa(ctx, claims, req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := repo.readMfa(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	} else if mfa.EnabledAt.Valid && !mfa.EnabledAt.Time.IsZero() {
+		return nil, errors.WithStack(ErrMfaAlreadyEnabled)
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	// The secret is needed to verify codes so it is stored encrypted instead of hashed.
+	crypto, err := symcrypto.New(repo.secretKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	encrypted, err := crypto.Encrypt(secret)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(userTableName)
+	query.Set(
+		query.Assign("mfa_secret", encrypted),
+		query.Assign("mfa_enabled_at", nil),
+		query.Assign("mfa_last_step", nil),
+		query.Assign("updated_at", now),
+	)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "enroll mfa for user %s failed", req.ID)
+		return nil, err
+	}
+
+	codes, err := repo.createMfaRecoveryCodes(ctx, req.ID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserMfaEnrollment{
+		Secret:          secret,
+		ProvisioningUri: totp.ProvisioningUri(repo.MfaIssuer, mfa.Email, secret),
+		RecoveryCodes:   codes,
+	}, nil
+}
+
+// MfaVerify checks the code against the two-factor authentication secret for the user. Recovery codes are accepted
+// once enrollment is complete and can only be used once. The first valid code verified after enrollment enables
+// two-factor authentication for the user.
+func (repo *Repository) MfaVerify(ctx context.Context, claims auth.Claims, req UserMfaVerifyRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.MfaVerify")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	// Two-factor authentication can only be managed by the user themselves.
+	err = repo.canManageMfa(ctx, claims, req.ID)
+	if err != nil {
+		return err
+	}
+
+	mfa, err := repo.readMfa(ctx, req.ID)
+	if err != nil {
+		return err
+	} else if !mfa.Secret.Valid || mfa.Secret.String == "" {
+		return errors.WithStack(ErrMfaNotEnrolled)
+	}
+	enabled := mfa.EnabledAt.Valid && !mfa.EnabledAt.Time.IsZero()
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	code := strings.Replace(strings.TrimSpace(req.Code), " ", "", -1)
+
+	step, ok, err := totp.Validate(mfa.Secret.String, code, now)
+	if err != nil {
+		return err
+	} else if ok {
+		// Build the update SQL statement.
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(userTableName)
+
+		fields := []string{
+			query.Assign("mfa_last_step", step),
+		}
+		if !enabled {
+			fields = append(fields, query.Assign("mfa_enabled_at", now), query.Assign("updated_at", now))
+		}
+		query.Set(fields...)
+
+		// A code can only be used once, the update only matches when no code for the same or a later time step has
+		// been used so concurrent requests with the same code can't both succeed.
+		query.Where(query.And(
+			query.Equal("id", req.ID),
+			query.Or(
+				query.IsNull("mfa_last_step"),
+				query.LessThan("mfa_last_step", step),
+			),
+		))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		res, err := repo.DbConn.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "verify mfa for user %s failed", req.ID)
+			return err
+		}
+
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errors.WithStack(ErrMfaInvalidCode)
+		}
+
+		if !enabled {
+			_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+				ActorUserID: req.ID,
+				EntityType:  audit.EntityType_User,
+				EntityID:    req.ID,
+				Action:      audit.Action_MfaEnable,
+			}, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// Recovery codes can't be used to complete enrollment.
+	if !enabled {
+		return errors.WithStack(ErrMfaInvalidCode)
+	}
+
+	// Try to use the code as a recovery code.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(userMfaRecoveryCodeTableName)
+	query.Set(query.Assign("used_at", now))
+	query.Where(query.And(
+		query.Equal("user_id", req.ID),
+		query.Equal("code_hash", hashMfaRecoveryCode(code)),
+		query.IsNull("used_at"),
+	))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	res, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "verify mfa recovery code for user %s failed", req.ID)
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.WithStack(ErrMfaInvalidCode)
+	}
+
+	return nil
+}
+
+// MfaDisable removes two-factor authentication for the user after verifying a current code.
+func (repo *Repository) MfaDisable(ctx context.Context, claims auth.Claims, req UserMfaDisableRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.MfaDisable")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	err = repo.MfaVerify(ctx, claims, UserMfaVerifyRequest{ID: req.ID, Code: req.Code}, now)
+	if err != nil {
+		return err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(userTableName)
+	query.Set(
+		query.Assign("mfa_secret", nil),
+		query.Assign("mfa_enabled_at", nil),
+		query.Assign("mfa_last_step", nil),
+		query.Assign("updated_at", now),
+	)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "disable mfa for user %s failed", req.ID)
+		return err
+	}
+
+	err = repo.deleteMfaRecoveryCodes(ctx, req.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		ActorUserID: req.ID,
+		EntityType:  audit.EntityType_User,
+		EntityID:    req.ID,
+		Action:      audit.Action_MfaDisable,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MfaChallengeAttempt records an attempt to complete the two-factor authentication challenge. ErrMfaChallengeInvalid
+// is returned when the challenge is not the current challenge for the user or the max number of attempts has been
+// reached, preventing the code from being brute-forced with the same challenge.
+func (repo *Repository) MfaChallengeAttempt(ctx context.Context, hash *MfaHash) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.MfaChallengeAttempt")
+	defer span.Finish()
+
+	// The attempts are incremented with a single update so concurrent requests can't exceed the max.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(userTableName)
+	query.Set(query.Incr("mfa_challenge_attempts"))
+	query.Where(query.And(
+		query.Equal("id", hash.UserID),
+		query.Equal("mfa_challenge_id", hash.ChallengeID),
+		query.LessThan("mfa_challenge_attempts", MfaChallengeMaxAttempts),
+	))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	res, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "attempt mfa challenge for user %s failed", hash.UserID)
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.WithStack(ErrMfaChallengeInvalid)
+	}
+
+	return nil
+}
+
+// MfaChallengeComplete invalidates the two-factor authentication challenge once it has been completed so the same
+// challenge can't be used again.
+func (repo *Repository) MfaChallengeComplete(ctx context.Context, hash *MfaHash) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.MfaChallengeComplete")
+	defer span.Finish()
+
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(userTableName)
+	query.Set(query.Assign("mfa_challenge_id", nil))
+	query.Where(query.And(
+		query.Equal("id", hash.UserID),
+		query.Equal("mfa_challenge_id", hash.ChallengeID),
+	))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	res, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "complete mfa challenge for user %s failed", hash.UserID)
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.WithStack(ErrMfaChallengeInvalid)
+	}
+
+	return nil
+}
+
+// newMfaChallenge starts a new two-factor authentication challenge for the user, replacing any previous challenge.
+func (repo *Repository) newMfaChallenge(ctx context.Context, userID string) (string, error) {
+	challengeID := uuid.NewRandom().String()
+
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(userTableName)
+	query.Set(
+		query.Assign("mfa_challenge_id", challengeID),
+		query.Assign("mfa_challenge_attempts", 0),
+	)
+	query.Where(query.Equal("id", userID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "create mfa challenge for user %s failed", userID)
+		return "", err
+	}
+
+	return challengeID, nil
+}
+
+// canManageMfa ensures the claims belong to the user. Unlike other user settings, admins are not able to manage
+// two-factor authentication on behalf of other users since that would expose the secret. Empty claims are allowed
+// for the authentication flow where the user has not been issued a token yet.
+func (repo *Repository) canManageMfa(ctx context.Context, claims auth.Claims, userID string) error {
+	if claims.Subject != "" && claims.Subject != userID {
+		return errors.WithStack(ErrForbidden)
+	}
+
+	return nil
+}
+
+// createMfaRecoveryCodes replaces any existing recovery codes for the user with a new set. Only a hash of each code
+// is stored.
+func (repo *Repository) createMfaRecoveryCodes(ctx context.Context, userID string, now time.Time) ([]string, error) {
+	err := repo.deleteMfaRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(userMfaRecoveryCodeTableName)
+	query.Cols("id", "user_id", "code_hash", "created_at")
+
+	var codes []string
+	for i := 0; i < mfaRecoveryCodeCount; i++ {
+		code, err := newMfaRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+
+		query.Values(uuid.NewRandom().String(), userID, hashMfaRecoveryCode(code), now)
+	}
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "create mfa recovery codes for user %s failed", userID)
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// deleteMfaRecoveryCodes removes all the recovery codes for the user.
+func (repo *Repository) deleteMfaRecoveryCodes(ctx context.Context, userID string) error {
+	query := sqlbuilder.NewDeleteBuilder()
+	query.DeleteFrom(userMfaRecoveryCodeTableName)
+	query.Where(query.Equal("user_id", userID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "delete mfa recovery codes for user %s failed", userID)
+		return err
+	}
+
+	return nil
+}
+
+// newMfaRecoveryCode returns a random recovery code formatted as three groups of four characters.
+func newMfaRecoveryCode() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	var code []byte
+	for i, c := range b {
+		if i > 0 && i%4 == 0 {
+			code = append(code, '-')
+		}
+		code = append(code, mfaRecoveryCodeAlphabet[int(c)%len(mfaRecoveryCodeAlphabet)])
+	}
+
+	return string(code), nil
+}
+
+// hashMfaRecoveryCode returns the hex encoded SHA-256 hash of a normalized recovery code.
+func hashMfaRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	h := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(h[:])
+}
@@ -8,6 +8,8 @@ import (
 	"strings"
 	"time"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/bruteforce"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/notify"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
@@ -17,12 +19,19 @@ import (
 	"github.com/sudo-suhas/symcrypto"
 )
 
+// DefaultPurgeGracePeriod is the duration a closed user can be restored before it's purged.
+const DefaultPurgeGracePeriod = 30 * 24 * time.Hour
+
 // Repository defines the required dependencies for User.
 type Repository struct {
-	DbConn    *sqlx.DB
-	ResetUrl  func(string) string
-	Notify    notify.Email
-	secretKey string
+	DbConn           *sqlx.DB
+	ResetUrl         func(string) string
+	VerifyUrl        func(string) string
+	Notify           notify.Email
+	MfaIssuer        string
+	Limiter          *bruteforce.Limiter
+	PurgeGracePeriod time.Duration
+	secretKey        string
 }
 
 // NewRepository creates a new Repository that defines dependencies for User.
@@ -37,31 +46,50 @@ func NewRepository(db *sqlx.DB, resetUrl func(string) string, notify notify.Emai
 
 // User represents someone with access to our system.
 type User struct {
-	ID            string          `json:"id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
-	FirstName     string          `json:"first_name" validate:"required" example:"Gabi"`
-	LastName      string          `json:"last_name" validate:"required" example:"May"`
-	Email         string          `json:"email" validate:"required,email,unique" example:"gabi@geeksinthewoods.com"`
-	PasswordSalt  string          `json:"-" validate:"required"`
-	PasswordHash  []byte          `json:"-" validate:"required"`
-	PasswordReset *sql.NullString `json:"-"`
-	Timezone      *string         `json:"timezone" validate:"omitempty" example:"America/Anchorage"`
-	CreatedAt     time.Time       `json:"created_at"`
-	UpdatedAt     time.Time       `json:"updated_at"`
-	ArchivedAt    *pq.NullTime    `json:"archived_at,omitempty"`
+	ID              string          `json:"id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	FirstName       string          `json:"first_name" validate:"required" example:"Gabi"`
+	LastName        string          `json:"last_name" validate:"required" example:"May"`
+	Email           string          `json:"email" validate:"required,email,unique" example:"gabi@geeksinthewoods.com"`
+	PasswordSalt    string          `json:"-" validate:"required"`
+	PasswordHash    []byte          `json:"-" validate:"required"`
+	PasswordReset   *sql.NullString `json:"-"`
+	Timezone        *string         `json:"timezone" validate:"omitempty" example:"America/Anchorage"`
+	MfaEnabledAt    *pq.NullTime    `json:"mfa_enabled_at,omitempty"`
+	EmailVerifiedAt *pq.NullTime    `json:"email_verified_at,omitempty"`
+	EmailPending    *sql.NullString `json:"email_pending,omitempty"`
+	EmailVerify     *sql.NullString `json:"-"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	ArchivedAt      *pq.NullTime    `json:"archived_at,omitempty"`
+	PurgeAt         *pq.NullTime    `json:"purge_at,omitempty"`
+}
+
+// MfaEnabled returns true when the user has completed enrollment for two-factor authentication.
+func (m *User) MfaEnabled() bool {
+	return m.MfaEnabledAt != nil && m.MfaEnabledAt.Valid && !m.MfaEnabledAt.Time.IsZero()
+}
+
+// EmailVerified returns true when the user has verified their current email address.
+func (m *User) EmailVerified() bool {
+	return m.EmailVerifiedAt != nil && m.EmailVerifiedAt.Valid && !m.EmailVerifiedAt.Time.IsZero()
 }
 
 // UserResponse represents someone with access to our system that is returned for display.
 type UserResponse struct {
-	ID         string               `json:"id" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
-	Name       string               `json:"name" example:"Gabi"`
-	FirstName  string               `json:"first_name" example:"Gabi"`
-	LastName   string               `json:"last_name" example:"May"`
-	Email      string               `json:"email" example:"gabi@geeksinthewoods.com"`
-	Timezone   string               `json:"timezone" example:"America/Anchorage"`
-	CreatedAt  web.TimeResponse     `json:"created_at"`            // CreatedAt contains multiple format options for display.
-	UpdatedAt  web.TimeResponse     `json:"updated_at"`            // UpdatedAt contains multiple format options for display.
-	ArchivedAt *web.TimeResponse    `json:"archived_at,omitempty"` // ArchivedAt contains multiple format options for display.
-	Gravatar   web.GravatarResponse `json:"gravatar"`
+	ID            string               `json:"id" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	Name          string               `json:"name" example:"Gabi"`
+	FirstName     string               `json:"first_name" example:"Gabi"`
+	LastName      string               `json:"last_name" example:"May"`
+	Email         string               `json:"email" example:"gabi@geeksinthewoods.com"`
+	Timezone      string               `json:"timezone" example:"America/Anchorage"`
+	MfaEnabled    bool                 `json:"mfa_enabled" example:"false"`
+	EmailVerified bool                 `json:"email_verified" example:"true"`
+	EmailPending  string               `json:"email_pending,omitempty" example:"gabi.may@geeksinthewoods.com"`
+	CreatedAt     web.TimeResponse     `json:"created_at"`            // CreatedAt contains multiple format options for display.
+	UpdatedAt     web.TimeResponse     `json:"updated_at"`            // UpdatedAt contains multiple format options for display.
+	ArchivedAt    *web.TimeResponse    `json:"archived_at,omitempty"` // ArchivedAt contains multiple format options for display.
+	PurgeAt       *web.TimeResponse    `json:"purge_at,omitempty"`    // PurgeAt is set for closed users that will be permanently deleted.
+	Gravatar      web.GravatarResponse `json:"gravatar"`
 }
 
 // Response transforms User and UserResponse that is used for display.
@@ -72,25 +100,36 @@ func (m *User) Response(ctx context.Context) *UserResponse {
 	}
 
 	r := &UserResponse{
-		ID:        m.ID,
-		Name:      m.FirstName + " " + m.LastName,
-		FirstName: m.FirstName,
-		LastName:  m.LastName,
-		Email:     m.Email,
-		CreatedAt: web.NewTimeResponse(ctx, m.CreatedAt),
-		UpdatedAt: web.NewTimeResponse(ctx, m.UpdatedAt),
-		Gravatar:  web.NewGravatarResponse(ctx, m.Email),
+		ID:            m.ID,
+		Name:          m.FirstName + " " + m.LastName,
+		FirstName:     m.FirstName,
+		LastName:      m.LastName,
+		Email:         m.Email,
+		MfaEnabled:    m.MfaEnabled(),
+		EmailVerified: m.EmailVerified(),
+		CreatedAt:     web.NewTimeResponse(ctx, m.CreatedAt),
+		UpdatedAt:     web.NewTimeResponse(ctx, m.UpdatedAt),
+		Gravatar:      web.NewGravatarResponse(ctx, m.Email),
 	}
 
 	if m.Timezone != nil {
 		r.Timezone = *m.Timezone
 	}
 
+	if m.EmailPending != nil && m.EmailPending.Valid {
+		r.EmailPending = m.EmailPending.String
+	}
+
 	if m.ArchivedAt != nil && !m.ArchivedAt.Time.IsZero() {
 		at := web.NewTimeResponse(ctx, m.ArchivedAt.Time)
 		r.ArchivedAt = &at
 	}
 
+	if m.PurgeAt != nil && !m.PurgeAt.Time.IsZero() {
+		at := web.NewTimeResponse(ctx, m.PurgeAt.Time)
+		r.PurgeAt = &at
+	}
+
 	return r
 }
 
@@ -170,6 +209,12 @@ type UserArchiveRequest struct {
 	force bool
 }
 
+// UserCloseRequest defines the information needed for a user to close their own user. The user is archived and then
+// purged once the grace period has passed unless it's restored.
+type UserCloseRequest struct {
+	ID string `json:"id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+}
+
 // UserRestoreRequest defines the information needed to restore an user.
 type UserRestoreRequest struct {
 	ID string `json:"id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
@@ -184,14 +229,26 @@ type UserDeleteRequest struct {
 // UserFindRequest defines the possible options to search for users. By default
 // archived users will be excluded from response.
 type UserFindRequest struct {
-	Where           string        `json:"where" example:"name = ? and email = ?"`
-	Args            []interface{} `json:"args" swaggertype:"array,string" example:"Company Name,gabi.may@geeksinthewoods.com"`
+	Filter          filter.Filter `json:"filter,omitempty"`
 	Order           []string      `json:"order" example:"created_at desc"`
 	Limit           *uint         `json:"limit" example:"10"`
 	Offset          *uint         `json:"offset" example:"20"`
+	Cursor          string        `json:"cursor,omitempty" example:"eyJvIjpbImlkIGFzYyJdLCJ2IjpbIjk4NWYxNzQ2Il19"`
 	IncludeArchived bool          `json:"include-archived" example:"false"`
 }
 
+// UserFindFields defines the fields of user that can be used to filter and sort a find request.
+var UserFindFields = filter.Fields{
+	{Name: "id", Type: filter.FieldType_UUID, Filterable: true, Sortable: true, Key: true},
+	{Name: "first_name", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "last_name", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "email", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "timezone", Type: filter.FieldType_String, Filterable: true, Nullable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "updated_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "archived_at", Type: filter.FieldType_Time, Filterable: true, Nullable: true},
+}
+
 // UserResetPasswordRequest defines the fields need to reset a user password.
 type UserResetPasswordRequest struct {
 	Email string        `json:"email" validate:"required,email" example:"gabi.may@geeksinthewoods.com"`
@@ -273,6 +330,216 @@ func ParseResetHash(ctx context.Context, secretKey string, str string, now time.
 	return &hash, nil
 }
 
+// UserVerifyEmailRequest defines the fields needed to send the email to verify an email address. The email can
+// either be the current email address of an unverified user or a pending email address requested by an update.
+type UserVerifyEmailRequest struct {
+	Email string        `json:"email" validate:"required,email" example:"gabi.may@geeksinthewoods.com"`
+	TTL   time.Duration `json:"ttl,omitempty" `
+}
+
+// UserVerifyEmailConfirmRequest defines the fields needed to verify an email address.
+type UserVerifyEmailConfirmRequest struct {
+	VerifyHash string `json:"verify_hash" validate:"required" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+}
+
+// VerifyHash
+type VerifyHash struct {
+	VerifyID  string `json:"verify_id" validate:"required" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	Email     string `json:"email" validate:"required,email" example:"gabi.may@geeksinthewoods.com"`
+	CreatedAt int    `json:"created_at" validate:"required"`
+	ExpiresAt int    `json:"expires_at" validate:"required"`
+	RequestIP string `json:"request_ip" validate:"required,ip" example:"69.56.104.36"`
+}
+
+// NewVerifyHash generates a new encrypted verify hash that is web safe for use in URLs. The hash includes the email
+// address being verified, so a link sent to a previous email address can't be used to verify another one.
+func NewVerifyHash(ctx context.Context, secretKey, verifyId, email, requestIp string, ttl time.Duration, now time.Time) (string, error) {
+
+	// Generate a string that embeds additional information.
+	hashPts := []string{
+		verifyId,
+		email,
+		strconv.Itoa(int(now.UTC().Unix())),
+		strconv.Itoa(int(now.UTC().Add(ttl).Unix())),
+		requestIp,
+	}
+	hashStr := strings.Join(hashPts, "|")
+
+	// This returns the nonce appended with the encrypted string.
+	crypto, err := symcrypto.New(secretKey)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	encrypted, err := crypto.Encrypt(hashStr)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return encrypted, nil
+}
+
+// ParseVerifyHash extracts the details encrypted in the hash string.
+func ParseVerifyHash(ctx context.Context, secretKey string, str string, now time.Time) (*VerifyHash, error) {
+
+	crypto, err := symcrypto.New(secretKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hashStr, err := crypto.Decrypt(str)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hashPts := strings.Split(hashStr, "|")
+
+	var hash VerifyHash
+	if len(hashPts) == 5 {
+		hash.VerifyID = hashPts[0]
+		hash.Email = hashPts[1]
+		hash.CreatedAt, _ = strconv.Atoi(hashPts[2])
+		hash.ExpiresAt, _ = strconv.Atoi(hashPts[3])
+		hash.RequestIP = hashPts[4]
+	}
+
+	// Validate the hash.
+	err = webcontext.Validator().StructCtx(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(hash.ExpiresAt) < now.UTC().Unix() {
+		err = errors.WithMessage(ErrVerifyExpired, "Email verification has expired.")
+		return nil, err
+	}
+
+	return &hash, nil
+}
+
+// UserMfaEnrollRequest defines the information needed to start two-factor authentication enrollment for a user.
+type UserMfaEnrollRequest struct {
+	ID string `json:"id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+}
+
+// UserMfaEnrollment contains the details the user needs to add the secret to their authenticator app. The
+// recovery codes are only returned once and can each be used a single time in place of a code.
+type UserMfaEnrollment struct {
+	Secret          string   `json:"secret" example:"JBSWY3DPEHPK3PXP"`
+	ProvisioningUri string   `json:"provisioning_uri" example:"otpauth://totp/SaaS:gabi@geeksinthewoods.com?secret=JBSWY3DPEHPK3PXP"`
+	RecoveryCodes   []string `json:"recovery_codes" example:"8ktx-2q7m-w9c3"`
+}
+
+// UserMfaVerifyRequest defines the information needed to verify a two-factor authentication code for a user.
+type UserMfaVerifyRequest struct {
+	ID   string `json:"id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	Code string `json:"code" validate:"required" example:"287082"`
+}
+
+// UserMfaDisableRequest defines the information needed to disable two-factor authentication for a user.
+type UserMfaDisableRequest struct {
+	ID   string `json:"id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	Code string `json:"code" validate:"required" example:"287082"`
+}
+
+// MfaHash
+type MfaHash struct {
+	UserID    string `json:"user_id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	AccountID string `json:"account_id" validate:"omitempty,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	Scope     string `json:"scope" validate:"omitempty" example:"admin"`
+	CreatedAt int    `json:"created_at" validate:"required"`
+	ExpiresAt int    `json:"expires_at" validate:"required"`
+	RequestIP string `json:"request_ip" validate:"required,ip" example:"69.56.104.36"`
+
+	// ChallengeID identifies the challenge so the number of attempts can be limited.
+	ChallengeID string `json:"challenge_id" validate:"required,uuid" example:"1f4bd2a5-4f38-4a0b-a1a6-0c5b4b8b4b4f"`
+}
+
+// NewMfaHash generates a new encrypted hash that identifies a user who has authenticated with their password
+// and still needs to complete the two-factor authentication challenge.
+func NewMfaHash(ctx context.Context, secretKey, userID, accountID, scope, requestIp, challengeID string, ttl time.Duration, now time.Time) (string, error) {
+
+	// Generate a string that embeds additional information.
+	hashPts := []string{
+		userID,
+		accountID,
+		scope,
+		strconv.Itoa(int(now.UTC().Unix())),
+		strconv.Itoa(int(now.UTC().Add(ttl).Unix())),
+		requestIp,
+		challengeID,
+	}
+	hashStr := strings.Join(hashPts, "|")
+
+	// This returns the nonce appended with the encrypted string.
+	crypto, err := symcrypto.New(secretKey)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	encrypted, err := crypto.Encrypt(hashStr)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return encrypted, nil
+}
+
+// ParseMfaHash extracts the details encrypted in the hash string.
+func ParseMfaHash(ctx context.Context, secretKey string, str string, now time.Time) (*MfaHash, error) {
+
+	crypto, err := symcrypto.New(secretKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hashStr, err := crypto.Decrypt(str)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hashPts := strings.Split(hashStr, "|")
+
+	var hash MfaHash
+	if len(hashPts) == 7 {
+		hash.UserID = hashPts[0]
+		hash.AccountID = hashPts[1]
+		hash.Scope = hashPts[2]
+		hash.CreatedAt, _ = strconv.Atoi(hashPts[3])
+		hash.ExpiresAt, _ = strconv.Atoi(hashPts[4])
+		hash.RequestIP = hashPts[5]
+		hash.ChallengeID = hashPts[6]
+	}
+
+	// Validate the hash.
+	err = webcontext.Validator().StructCtx(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(hash.ExpiresAt) < now.UTC().Unix() {
+		err = errors.WithMessage(ErrMfaExpired, "Two-factor authentication challenge has expired.")
+		return nil, err
+	}
+
+	return &hash, nil
+}
+
+// NewMfaHash starts a new two-factor authentication challenge for the user and generates a new encrypted hash using
+// the secret key for the repository. Any previous challenge for the user is no longer valid.
+func (repo *Repository) NewMfaHash(ctx context.Context, userID, accountID, scope, requestIp string, ttl time.Duration, now time.Time) (string, error) {
+	challengeID, err := repo.newMfaChallenge(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	return NewMfaHash(ctx, repo.secretKey, userID, accountID, scope, requestIp, challengeID, ttl, now)
+}
+
+// ParseMfaHash extracts the details encrypted in the hash string.
+func (repo *Repository) ParseMfaHash(ctx context.Context, str string, now time.Time) (*MfaHash, error) {
+	return ParseMfaHash(ctx, repo.secretKey, str, now)
+}
+
+// ParseVerifyHash extracts the details encrypted in the hash string.
+func (repo *Repository) ParseVerifyHash(ctx context.Context, str string, now time.Time) (*VerifyHash, error) {
+	return ParseVerifyHash(ctx, repo.secretKey, str, now)
+}
+
 // ParseResetHash extracts the details encrypted in the hash string.
 func (repo *Repository) ParseResetHash(ctx context.Context, str string, now time.Time) (*ResetHash, error) {
 	return ParseResetHash(ctx, repo.secretKey, str, now)
@@ -3,9 +3,12 @@ package user
 import (
 	"context"
 	"database/sql"
+	"log"
 	"time"
 
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/bruteforce"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/notify"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"github.com/huandu/go-sqlbuilder"
@@ -23,6 +26,14 @@ const (
 	accountTableName = "accounts"
 	// The database table for User Account
 	userAccountTableName = "users_accounts"
+	// The database table for Refresh Token
+	refreshTokenTableName = "refresh_tokens"
+	// The database table for User Session
+	userSessionTableName = "user_sessions"
+	// The database table for AuditEvent
+	auditEventTableName = "audit_events"
+	// The database table for WebhookDelivery
+	webhookDeliveryTableName = "webhook_deliveries"
 )
 
 var (
@@ -34,10 +45,13 @@ var (
 
 	// ErrResetExpired occurs when the the reset hash exceeds the expiration.
 	ErrResetExpired = errors.New("Reset expired")
+
+	// ErrAccountAdmin occurs when removing a user would leave an account without its owner or an active admin.
+	ErrAccountAdmin = errors.New("User is the owner or the last active admin of an account")
 )
 
 // userMapColumns is the list of columns needed for mapRowsToUser
-var userMapColumns = "id,first_name,last_name,email,password_salt,password_hash,password_reset,timezone,created_at,updated_at,archived_at"
+var userMapColumns = "id,first_name,last_name,email,password_salt,password_hash,password_reset,timezone,mfa_enabled_at,email_verified_at,email_pending,email_verify,created_at,updated_at,archived_at,purge_at"
 
 // mapRowsToUser takes the SQL rows and maps it to the UserAccount struct
 // with the columns defined by userMapColumns
@@ -46,7 +60,7 @@ func mapRowsToUser(rows *sql.Rows) (*User, error) {
 		u   User
 		err error
 	)
-	err = rows.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.PasswordSalt, &u.PasswordHash, &u.PasswordReset, &u.Timezone, &u.CreatedAt, &u.UpdatedAt, &u.ArchivedAt)
+	err = rows.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.PasswordSalt, &u.PasswordHash, &u.PasswordReset, &u.Timezone, &u.MfaEnabledAt, &u.EmailVerifiedAt, &u.EmailPending, &u.EmailVerify, &u.CreatedAt, &u.UpdatedAt, &u.ArchivedAt, &u.PurgeAt)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -92,8 +106,8 @@ func (repo *Repository) CanModifyUser(ctx context.Context, claims auth.Claims, u
 	// If the request has claims from a specific user, ensure that the user
 	// has the correct role for creating a new user.
 	if claims.Subject != "" && claims.Subject != userID {
-		// Users with the role of admin are ony allows to create users.
-		if !claims.HasRole(auth.RoleAdmin) {
+		// Users with the user:write permission are ony allows to create users.
+		if !claims.HasPermission(auth.PermissionUserWrite) {
 			err := errors.WithStack(ErrForbidden)
 			return err
 		}
@@ -178,12 +192,17 @@ func selectQuery() *sqlbuilder.SelectBuilder {
 }
 
 // findRequestQuery generates the select query for the given find request.
-// TODO: Need to figure out why can't parse the args when appending the where
-// 			to the query.
-func findRequestQuery(req UserFindRequest) (*sqlbuilder.SelectBuilder, []interface{}) {
+func findRequestQuery(req UserFindRequest) (*sqlbuilder.SelectBuilder, error) {
 	query := selectQuery()
-	if req.Where != "" {
-		query.Where(query.And(req.Where))
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, UserFindFields); err != nil {
+			return nil, err
+		}
+	}
+	if req.Cursor != "" {
+		if err := UserFindFields.ApplyCursor(query, req.Order, req.Cursor); err != nil {
+			return nil, err
+		}
 	}
 	if len(req.Order) > 0 {
 		query.OrderBy(req.Order...)
@@ -195,13 +214,55 @@ func findRequestQuery(req UserFindRequest) (*sqlbuilder.SelectBuilder, []interfa
 		query.Offset(int(*req.Offset))
 	}
 
-	return query, req.Args
+	return query, nil
 }
 
 // Find gets all the users from the database based on the request params.
 func (repo *Repository) Find(ctx context.Context, claims auth.Claims, req UserFindRequest) (Users, error) {
-	query, args := findRequestQuery(req)
-	return find(ctx, claims, repo.DbConn, query, args, req.IncludeArchived)
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
+}
+
+// Count gets the total number of users from the database that match the request params. The order, limit,
+// offset and cursor of the request are ignored.
+func (repo *Repository) Count(ctx context.Context, claims auth.Claims, req UserFindRequest) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.Count")
+	defer span.Finish()
+
+	req.Order, req.Limit, req.Offset, req.Cursor = nil, nil, nil, ""
+
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return 0, err
+	}
+	query.Select("count(*)")
+
+	if !req.IncludeArchived {
+		query.Where(query.IsNull("archived_at"))
+	}
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err = applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return 0, err
+	}
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var total int
+	err = repo.DbConn.QueryRowContext(ctx, queryStr, args...).Scan(&total)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "count users failed")
+		return 0, err
+	}
+
+	return total, nil
 }
 
 // find internal method for getting all the users from the database using a select query.
@@ -299,8 +360,8 @@ func (repo *Repository) Create(ctx context.Context, claims auth.Claims, req User
 	// If the request has claims from a specific user, ensure that the user
 	// has the correct role for creating a new user.
 	if claims.Subject != "" {
-		// Users with the role of admin are ony allows to create users.
-		if !claims.HasRole(auth.RoleAdmin) {
+		// Users with the user:write permission are ony allows to create users.
+		if !claims.HasPermission(auth.PermissionUserWrite) {
 			err = errors.WithStack(ErrForbidden)
 			return nil, err
 		}
@@ -354,6 +415,16 @@ func (repo *Repository) Create(ctx context.Context, claims auth.Claims, req User
 		return nil, err
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		EntityType: audit.EntityType_User,
+		EntityID:   u.ID,
+		Action:     audit.Action_Create,
+		After:      u,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
 	return &u, nil
 }
 
@@ -380,8 +451,8 @@ func (repo *Repository) CreateInvite(ctx context.Context, claims auth.Claims, re
 	// If the request has claims from a specific user, ensure that the user
 	// has the correct role for creating a new user.
 	if claims.Subject != "" {
-		// Users with the role of admin are ony allows to create users.
-		if !claims.HasRole(auth.RoleAdmin) {
+		// Users with the user:write permission are ony allows to create users.
+		if !claims.HasPermission(auth.PermissionUserWrite) {
 			err = errors.WithStack(ErrForbidden)
 			return nil, err
 		}
@@ -422,6 +493,16 @@ func (repo *Repository) CreateInvite(ctx context.Context, claims auth.Claims, re
 		return nil, err
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		EntityType: audit.EntityType_User,
+		EntityID:   u.ID,
+		Action:     audit.Action_Create,
+		After:      u,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
 	return &u, nil
 }
 
@@ -512,6 +593,12 @@ func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req User
 		return err
 	}
 
+	// Load the current user so the change can be recorded.
+	before, err := repo.Read(ctx, auth.Claims{}, UserReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
 	// If now empty set it to the current time.
 	if now.IsZero() {
 		now = time.Now()
@@ -535,8 +622,20 @@ func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req User
 	if req.LastName != nil {
 		fields = append(fields, query.Assign("last_name", req.LastName))
 	}
-	if req.Email != nil {
-		fields = append(fields, query.Assign("email", req.Email))
+
+	// Changes to the email address requested by a user are only applied once the new email address has been
+	// verified. Internal requests update the email address directly, but it will need to be verified again.
+	var pendingEmail string
+	if req.Email != nil && *req.Email != before.Email {
+		if claims.HasAuth() {
+			pendingEmail = *req.Email
+			fields = append(fields, query.Assign("email_pending", pendingEmail))
+		} else {
+			fields = append(fields, query.Assign("email", req.Email))
+			fields = append(fields, query.Assign("email_verified_at", nil))
+			fields = append(fields, query.Assign("email_pending", nil))
+			fields = append(fields, query.Assign("email_verify", nil))
+		}
 	}
 	if req.Timezone != nil && *req.Timezone != "" {
 		fields = append(fields, query.Assign("timezone", *req.Timezone))
@@ -563,6 +662,25 @@ func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req User
 		return err
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		EntityType: audit.EntityType_User,
+		EntityID:   req.ID,
+		Action:     audit.Action_Update,
+		Before:     before,
+		After:      req,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	// Send the email to verify the new email address.
+	if pendingEmail != "" {
+		_, err = repo.sendVerifyEmail(ctx, before, pendingEmail, 0, now)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -624,6 +742,21 @@ func (repo *Repository) UpdatePassword(ctx context.Context, claims auth.Claims,
 		return err
 	}
 
+	// Revoke all existing sessions for the user since the password has changed.
+	err = revokeUserSessions(ctx, repo.DbConn, req.ID, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		EntityType: audit.EntityType_User,
+		EntityID:   req.ID,
+		Action:     audit.Action_UpdatePassword,
+	}, now)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -659,6 +792,19 @@ func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req Use
 	// here so the value we return is consistent with what we store.
 	now = now.Truncate(time.Millisecond)
 
+	// Start a new transaction to handle rollbacks on error.
+	tx, err := repo.DbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Ensure the accounts of the user keep their owner and an active admin.
+	err = checkAccountAdmins(ctx, tx, req.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Build the update SQL statement.
 	query := sqlbuilder.NewUpdateBuilder()
 	query.Update(userTableName)
@@ -670,8 +816,10 @@ func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req Use
 	// Execute the query with the provided context.
 	sql, args := query.Build()
 	sql = repo.DbConn.Rebind(sql)
-	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	_, err = tx.ExecContext(ctx, sql, args...)
 	if err != nil {
+		tx.Rollback()
+
 		err = errors.Wrapf(err, "query - %s", query.String())
 		err = errors.WithMessagef(err, "archive user %s failed", req.ID)
 		return err
@@ -690,18 +838,42 @@ func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req Use
 		// Execute the query with the provided context.
 		sql, args := query.Build()
 		sql = repo.DbConn.Rebind(sql)
-		_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+		_, err = tx.ExecContext(ctx, sql, args...)
 		if err != nil {
+			tx.Rollback()
+
 			err = errors.Wrapf(err, "query - %s", query.String())
 			err = errors.WithMessagef(err, "archive accounts for user %s failed", req.ID)
 			return err
 		}
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Revoke all existing sessions for the archived user.
+	err = revokeUserSessions(ctx, repo.DbConn, req.ID, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		EntityType: audit.EntityType_User,
+		EntityID:   req.ID,
+		Action:     audit.Action_Archive,
+		After:      map[string]interface{}{"archived_at": now},
+	}, now)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// Restore undeletes the user from the database.
+// Restore undeletes the user from the database. When the user was closed, the user accounts that were archived when
+// the user was closed are restored as well.
 func (repo *Repository) Restore(ctx context.Context, claims auth.Claims, req UserRestoreRequest, now time.Time) error {
 	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.Restore")
 	defer span.Finish()
@@ -731,11 +903,22 @@ func (repo *Repository) Restore(ctx context.Context, claims auth.Claims, req Use
 	// here so the value we return is consistent with what we store.
 	now = now.Truncate(time.Millisecond)
 
+	before, err := repo.Read(ctx, auth.Claims{}, UserReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
+	closed := before.PurgeAt != nil && before.PurgeAt.Valid
+	if closed && !before.PurgeAt.Time.After(now) {
+		return errors.WithMessagef(ErrNotFound, "user %s was scheduled to be purged at %s", req.ID, before.PurgeAt.Time)
+	}
+
 	// Build the update SQL statement.
 	query := sqlbuilder.NewUpdateBuilder()
 	query.Update(userTableName)
 	query.Set(
 		query.Assign("archived_at", nil),
+		query.Assign("purge_at", nil),
 	)
 	query.Where(query.Equal("id", req.ID))
 
@@ -749,6 +932,38 @@ func (repo *Repository) Restore(ctx context.Context, claims auth.Claims, req Use
 		return err
 	}
 
+	// Restore the user accounts archived when the user was closed.
+	if closed && before.ArchivedAt != nil && before.ArchivedAt.Valid {
+		// Build the update SQL statement.
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(userAccountTableName)
+		query.Set(query.Assign("archived_at", nil))
+		query.Where(query.And(
+			query.Equal("user_id", req.ID),
+			query.Equal("archived_at", before.ArchivedAt.Time),
+		))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "restore accounts for user %s failed", req.ID)
+			return err
+		}
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		EntityType: audit.EntityType_User,
+		EntityID:   req.ID,
+		Action:     audit.Action_Restore,
+		After:      map[string]interface{}{"archived_at": nil},
+	}, now)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -772,12 +987,25 @@ func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req User
 		return errors.WithStack(ErrForbidden)
 	}
 
+	// Load the current user so the change can be recorded.
+	before, err := repo.Read(ctx, auth.Claims{}, UserReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
 	// Start a new transaction to handle rollbacks on error.
-	tx, err := repo.DbConn.Begin()
+	tx, err := repo.DbConn.BeginTxx(ctx, nil)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	// Ensure the accounts of the user keep their owner and an active admin.
+	err = checkAccountAdmins(ctx, tx, req.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Delete all the associated user accounts.
 	// Required to execute first to avoid foreign key constraints.
 	{
@@ -823,6 +1051,327 @@ func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req User
 		return errors.WithStack(err)
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		EntityType: audit.EntityType_User,
+		EntityID:   req.ID,
+		Action:     audit.Action_Delete,
+		Before:     before,
+	}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Close archives the user and removes them from all their accounts. The user can be restored until the grace period
+// has passed and then they will be permanently deleted by PurgeClosed.
+func (repo *Repository) Close(ctx context.Context, claims auth.Claims, req UserCloseRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.Close")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can modify the user specified in the request.
+	err = repo.CanModifyUser(ctx, claims, req.ID)
+	if err != nil {
+		return err
+	}
+
+	// Load the current user so the change can be recorded, users already archived can't be closed.
+	before, err := repo.Read(ctx, auth.Claims{}, UserReadRequest{ID: req.ID})
+	if err != nil {
+		return err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	gracePeriod := repo.PurgeGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultPurgeGracePeriod
+	}
+	purgeAt := now.Add(gracePeriod)
+
+	// Start a new transaction to handle rollbacks on error.
+	tx, err := repo.DbConn.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Ensure the accounts of the user keep their owner and an active admin.
+	err = checkAccountAdmins(ctx, tx, req.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(userTableName)
+	query.Set(
+		query.Assign("archived_at", now),
+		query.Assign("purge_at", purgeAt),
+	)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = tx.ExecContext(ctx, sql, args...)
+	if err != nil {
+		tx.Rollback()
+
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "close user %s failed", req.ID)
+		return err
+	}
+
+	// Archive the user accounts that are not already archived, restore relies on the matching archived_at to only
+	// restore the user accounts archived by close.
+	{
+		// Build the update SQL statement.
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(userAccountTableName)
+		query.Set(query.Assign("archived_at", now))
+		query.Where(query.And(
+			query.Equal("user_id", req.ID),
+			query.IsNull("archived_at"),
+		))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err = tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			tx.Rollback()
+
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "archive accounts for user %s failed", req.ID)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Revoke all existing sessions for the closed user.
+	err = revokeUserSessions(ctx, repo.DbConn, req.ID, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		EntityType: audit.EntityType_User,
+		EntityID:   req.ID,
+		Action:     audit.Action_Close,
+		Before:     before,
+		After:      map[string]interface{}{"archived_at": now, "purge_at": purgeAt},
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PurgeClosed permanently deletes the users that were closed and not restored before the grace period passed. The
+// audit events recorded for changes to the user and the webhook deliveries about them are removed as they include
+// their personal data, other audit events are kept without the user. A user that fails to purge is logged and
+// skipped. The number of purged users is returned.
+func (repo *Repository) PurgeClosed(ctx context.Context, now time.Time) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.PurgeClosed")
+	defer span.Finish()
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	query := sqlbuilder.NewSelectBuilder().Select("id").From(userTableName)
+	query.Where(query.And(
+		query.IsNotNull("purge_at"),
+		query.LessEqualThan("purge_at", now),
+	))
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var ids []string
+	err := repo.DbConn.SelectContext(ctx, &ids, queryStr, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find users to purge failed")
+		return 0, err
+	}
+
+	// A user that fails to purge shouldn't block the others, it will be retried on the next run.
+	var purged, failed int
+	for _, id := range ids {
+		err = repo.purge(ctx, id, now)
+		if err != nil {
+			log.Printf("internal.user.PurgeClosed : purge user %s failed : %+v", id, err)
+			failed++
+			continue
+		}
+		purged++
+	}
+
+	if failed > 0 {
+		return purged, errors.Errorf("purge failed for %d of %d users", failed, len(ids))
+	}
+
+	return purged, nil
+}
+
+// purge deletes the user and the personal data associated with them.
+func (repo *Repository) purge(ctx context.Context, userID string, now time.Time) error {
+	// Start a new transaction to handle rollbacks on error.
+	tx, err := repo.DbConn.Begin()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Delete all the associated user accounts.
+	// Required to execute first to avoid foreign key constraints.
+	{
+		// Build the delete SQL statement.
+		query := sqlbuilder.NewDeleteBuilder()
+		query.DeleteFrom(userAccountTableName)
+		query.Where(query.Equal("user_id", userID))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err = tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			tx.Rollback()
+
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "purge accounts for user %s failed", userID)
+			return err
+		}
+	}
+
+	// Delete the audit events for the user and their account memberships that include their personal data.
+	{
+		// Build the delete SQL statement.
+		query := sqlbuilder.NewDeleteBuilder()
+		query.DeleteFrom(auditEventTableName)
+		query.Where(query.And(
+			query.In("entity_type", string(audit.EntityType_User), string(audit.EntityType_UserAccount)),
+			query.Equal("entity_id", userID),
+		))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err = tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			tx.Rollback()
+
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "purge audit events for user %s failed", userID)
+			return err
+		}
+	}
+
+	// The audit events of other entities are kept for the account, only the user and the IP they made the
+	// request from are removed.
+	for _, col := range []string{"actor_user_id", "root_user_id"} {
+		// Build the update SQL statement.
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(auditEventTableName)
+		query.Set(
+			query.Assign(col, nil),
+			query.Assign("request_ip", ""),
+		)
+		query.Where(query.Equal(col, userID))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err = tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			tx.Rollback()
+
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "purge audit event %s for user %s failed", col, userID)
+			return err
+		}
+	}
+
+	// Delete the webhook deliveries with a payload about the user, they can include their email.
+	{
+		// Build the delete SQL statement.
+		query := sqlbuilder.NewDeleteBuilder()
+		query.DeleteFrom(webhookDeliveryTableName)
+		query.Where("payload->'data'->>'user_id' = " + query.Var(userID))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err = tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			tx.Rollback()
+
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "purge webhook deliveries for user %s failed", userID)
+			return err
+		}
+	}
+
+	// Build the delete SQL statement.
+	query := sqlbuilder.NewDeleteBuilder()
+	query.DeleteFrom(userTableName)
+	query.Where(query.Equal("id", userID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = tx.ExecContext(ctx, sql, args...)
+	if err != nil {
+		tx.Rollback()
+
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "purge user %s failed", userID)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Only the fact the user was purged is recorded.
+	_, err = audit.Record(ctx, repo.DbConn, auth.Claims{}, audit.AuditEventRecordRequest{
+		EntityType: audit.EntityType_User,
+		EntityID:   userID,
+		Action:     audit.Action_Purge,
+	}, now)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -839,6 +1388,18 @@ func (repo *Repository) ResetPassword(ctx context.Context, req UserResetPassword
 		return "", err
 	}
 
+	// Throttle password reset requests from the same IP address or for the same email address. Every request counts
+	// as an attempt since each one sends an email.
+	ipKey, emailKey := bruteforce.RequestKeys(ctx, "reset", req.Email)
+	if err := repo.Limiter.Allow(ctx, ipKey, emailKey); err != nil {
+		return "", errors.WithMessagef(err, "password reset for %s blocked", req.Email)
+	}
+	for _, k := range []string{ipKey, emailKey} {
+		if _, err := repo.Limiter.Fail(ctx, k); err != nil {
+			return "", err
+		}
+	}
+
 	// Find user by email address.
 	var u *User
 	{
@@ -986,11 +1547,117 @@ func (repo *Repository) ResetConfirm(ctx context.Context, req UserResetConfirmRe
 			err = errors.WithMessagef(err, "update password for user %s failed", u.ID)
 			return nil, err
 		}
+
+		// Revoke all existing sessions for the user since the password has changed.
+		err = revokeUserSessions(ctx, repo.DbConn, u.ID, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, auth.Claims{}, audit.AuditEventRecordRequest{
+		ActorUserID: u.ID,
+		EntityType:  audit.EntityType_User,
+		EntityID:    u.ID,
+		Action:      audit.Action_UpdatePassword,
+	}, now)
+	if err != nil {
+		return nil, err
 	}
 
 	return u, nil
 }
 
+// revokeUserSessions revokes all the sessions and refresh tokens issued to the user, including ones for virtual logins
+// the user initiated. Access tokens issued for the revoked sessions will be rejected by the auth middleware.
+func revokeUserSessions(ctx context.Context, dbConn *sqlx.DB, userID string, now time.Time) error {
+	for _, tableName := range []string{userSessionTableName, refreshTokenTableName} {
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(tableName)
+		query.Set(query.Assign("revoked_at", now))
+		query.Where(query.And(
+			query.Or(
+				query.Equal("user_id", userID),
+				query.Equal("root_user_id", userID),
+			),
+			query.IsNull("revoked_at"),
+		))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = dbConn.Rebind(sql)
+		_, err := dbConn.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "revoke sessions for user %s failed", userID)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkAccountAdmins ensures the user can be removed from all their accounts. Users that own an account or are the
+// last active admin of an account can't be removed until the ownership is transferred or another admin is added. The
+// accounts of the user are locked by the transaction so concurrent changes to their users are serialized with the
+// removal of the user.
+func checkAccountAdmins(ctx context.Context, tx *sqlx.Tx, userID string) error {
+	// Lock the accounts in a consistent order to avoid deadlocks.
+	lockQuery := sqlbuilder.NewSelectBuilder().Select("id").From(accountTableName)
+	lockQuery.Where("id IN (SELECT account_id FROM " + userAccountTableName + " WHERE user_id = " + lockQuery.Var(userID) + ")")
+	lockQuery.OrderBy("id")
+	lockQueryStr, lockArgs := lockQuery.Build()
+	lockQueryStr = tx.Rebind(lockQueryStr + " FOR UPDATE")
+
+	var accountIDs []string
+	if err := tx.SelectContext(ctx, &accountIDs, lockQueryStr, lockArgs...); err != nil {
+		err = errors.Wrapf(err, "query - %s FOR UPDATE", lockQuery.String())
+		err = errors.WithMessagef(err, "lock accounts for user %s failed", userID)
+		return err
+	}
+
+	isAdmin := func(alias string) string {
+		return "('" + auth.RoleOwner + "' = ANY (" + alias + ".roles) OR '" + auth.RoleAdmin + "' = ANY (" + alias + ".roles))"
+	}
+
+	// Build select statement for other active admins of the account.
+	otherQuery := sqlbuilder.NewSelectBuilder().Select("o.id").From(userAccountTableName + " o")
+	otherQuery.Where(
+		"o.account_id = ua.account_id",
+		"o.user_id != ua.user_id",
+		"o.status = 'active'",
+		"o.archived_at IS NULL",
+		isAdmin("o"),
+	)
+	otherQueryStr, _ := otherQuery.Build()
+
+	query := sqlbuilder.NewSelectBuilder().Select("ua.account_id").From(userAccountTableName + " ua")
+	query.Where(query.And(
+		query.Equal("ua.user_id", userID),
+		"ua.status = 'active'",
+		"ua.archived_at IS NULL",
+		"('"+auth.RoleOwner+"' = ANY (ua.roles) OR ('"+auth.RoleAdmin+"' = ANY (ua.roles) AND NOT EXISTS ("+otherQueryStr+")))",
+	))
+	query.Limit(1)
+
+	queryStr, args := query.Build()
+	queryStr = tx.Rebind(queryStr)
+
+	var accountID string
+	err := tx.QueryRowContext(ctx, queryStr, args...).Scan(&accountID)
+	if err != nil && err != sql.ErrNoRows {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "check accounts for user %s failed", userID)
+		return err
+	}
+
+	if accountID != "" {
+		return errors.WithMessagef(ErrAccountAdmin, "user %s is required for account %s", userID, accountID)
+	}
+
+	return nil
+}
+
 type MockUserResponse struct {
 	*User
 	Password string
@@ -1030,5 +1697,12 @@ func MockRepository(dbConn *sqlx.DB) *Repository {
 	notify := &notify.MockEmail{}
 	secretKey := "6368616e676520746869732070617373"
 
-	return NewRepository(dbConn, resetUrl, notify, secretKey)
+	repo := NewRepository(dbConn, resetUrl, notify, secretKey)
+
+	// Mock the methods needed to verify an email address.
+	repo.VerifyUrl = func(string) string {
+		return ""
+	}
+
+	return repo
 }
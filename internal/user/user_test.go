@@ -1,6 +1,7 @@
 package user
 
 import (
+	"database/sql"
 	"math/rand"
 	"os"
 	"strings"
@@ -8,7 +9,9 @@ import (
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/totp"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/go-cmp/cmp"
 	"github.com/huandu/go-sqlbuilder"
@@ -44,10 +47,9 @@ func TestFindRequestQuery(t *testing.T) {
 	)
 
 	req := UserFindRequest{
-		Where: "first_name = ? or email = ?",
-		Args: []interface{}{
-			"lee",
-			"lee@geeksinthewoods.com",
+		Filter: filter.Filter{
+			{Field: "first_name", Operator: filter.Op_Eq, Values: []string{"lee"}},
+			{Field: "email", Operator: filter.Op_StartsWith, Values: []string{"lee@"}},
 		},
 		Order: []string{
 			"id asc",
@@ -56,14 +58,19 @@ func TestFindRequestQuery(t *testing.T) {
 		Limit:  &limit,
 		Offset: &offset,
 	}
-	expected := "SELECT " + userMapColumns + " FROM " + userTableName + " WHERE (first_name = ? or email = ?) ORDER BY id asc, created_at desc LIMIT 12 OFFSET 34"
+	expected := "SELECT " + userMapColumns + " FROM " + userTableName + " WHERE first_name = ? AND email ILIKE ? ORDER BY id asc, created_at desc LIMIT 12 OFFSET 34"
 
-	res, args := findRequestQuery(req)
+	res, err := findRequestQuery(req)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tFind request query failed.", tests.Failed)
+	}
 
 	if diff := cmp.Diff(res.String(), expected); diff != "" {
 		t.Fatalf("\t%s\tExpected result query to match. Diff:\n%s", tests.Failed, diff)
 	}
-	if diff := cmp.Diff(args, req.Args); diff != "" {
+	_, args := res.Build()
+	if diff := cmp.Diff(args, []interface{}{"lee", "lee@%"}); diff != "" {
 		t.Fatalf("\t%s\tExpected result query to match. Diff:\n%s", tests.Failed, diff)
 	}
 }
@@ -700,8 +707,11 @@ func TestCrud(t *testing.T) {
 		nil,
 		func(user *User, req UserUpdateRequest) *User {
 			return &User{
-				Email: *req.Email,
+				// The email is only changed once the new email address has been verified.
+				EmailPending: &sql.NullString{String: *req.Email, Valid: true},
 				// Copy this fields from the created user.
+				Email:         user.Email,
+				EmailVerify:   user.EmailVerify,
 				ID:            user.ID,
 				FirstName:     user.FirstName,
 				LastName:      user.LastName,
@@ -777,8 +787,11 @@ func TestCrud(t *testing.T) {
 		nil,
 		func(user *User, req UserUpdateRequest) *User {
 			return &User{
-				Email: *req.Email,
+				// The email is only changed once the new email address has been verified.
+				EmailPending: &sql.NullString{String: *req.Email, Valid: true},
 				// Copy this fields from the created user.
+				Email:         user.Email,
+				EmailVerify:   user.EmailVerify,
 				ID:            user.ID,
 				FirstName:     user.FirstName,
 				LastName:      user.LastName,
@@ -826,6 +839,26 @@ func TestCrud(t *testing.T) {
 					t.Fatalf("\t%s\tCreate user account failed.", tests.Failed)
 				}
 
+				// The last admin of the account can't be archived.
+				err = repo.Archive(ctx, auth.Claims{}, UserArchiveRequest{ID: user.ID, force: true}, now)
+				if errors.Cause(err) != ErrAccountAdmin {
+					t.Logf("\t\tGot : %+v", err)
+					t.Logf("\t\tWant: %+v", ErrAccountAdmin)
+					t.Fatalf("\t%s\tArchive last admin failed.", tests.Failed)
+				}
+
+				// Add another admin to the account so the user can be removed.
+				admin, err := MockUser(ctx, test.MasterDB, user.CreatedAt)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tCreate admin user failed.", tests.Failed)
+				}
+				err = mockUserAccount(admin.ID, accountId, user.CreatedAt, auth.RoleAdmin)
+				if err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tCreate admin user account failed.", tests.Failed)
+				}
+
 				// Update the user.
 				updateReq := tt.update(user)
 				err = repo.Update(ctx, tt.claims(user, accountId), updateReq, now)
@@ -946,14 +979,16 @@ func TestFind(t *testing.T) {
 
 	var userTests []userTest
 
-	createdFilter := "created_at BETWEEN ? AND ?"
+	createdFilter := filter.Filter{
+		{Field: "created_at", Operator: filter.Op_Gte, Values: []string{startTime.Format(time.RFC3339Nano)}},
+		{Field: "created_at", Operator: filter.Op_Lte, Values: []string{endTime.Format(time.RFC3339Nano)}},
+	}
 
 	// Test sort users.
 	userTests = append(userTests, userTest{"Find all order by created_at asc",
 		UserFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at"},
+			Filter: createdFilter,
+			Order:  []string{"created_at"},
 		},
 		users,
 		nil,
@@ -966,9 +1001,8 @@ func TestFind(t *testing.T) {
 	}
 	userTests = append(userTests, userTest{"Find all order by created_at desc",
 		UserFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at desc"},
+			Filter: createdFilter,
+			Order:  []string{"created_at desc"},
 		},
 		expected,
 		nil,
@@ -978,10 +1012,9 @@ func TestFind(t *testing.T) {
 	var limit uint = 2
 	userTests = append(userTests, userTest{"Find limit",
 		UserFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at"},
-			Limit: &limit,
+			Filter: createdFilter,
+			Order:  []string{"created_at"},
+			Limit:  &limit,
 		},
 		users[0:2],
 		nil,
@@ -991,8 +1024,7 @@ func TestFind(t *testing.T) {
 	var offset uint = 3
 	userTests = append(userTests, userTest{"Find limit, offset",
 		UserFindRequest{
-			Where:  createdFilter,
-			Args:   []interface{}{startTime, endTime},
+			Filter: createdFilter,
 			Order:  []string{"created_at"},
 			Limit:  &limit,
 			Offset: &offset,
@@ -1002,26 +1034,23 @@ func TestFind(t *testing.T) {
 	})
 
 	// Test where filter.
-	whereParts := []string{}
-	whereArgs := []interface{}{startTime, endTime}
+	var values []string
 	expected = []*User{}
-	for i := 0; i <= len(users); i++ {
-		if rand.Intn(100) < 50 {
+	for i := 0; i < len(users); i++ {
+		// Always include the first one so the filter has a value.
+		if i > 0 && rand.Intn(100) < 50 {
 			continue
 		}
 		u := *users[i]
 
-		whereParts = append(whereParts, "email = ?")
-		whereArgs = append(whereArgs, u.Email)
+		values = append(values, u.Email)
 		expected = append(expected, &u)
 	}
 
-	where := createdFilter + " AND (" + strings.Join(whereParts, " OR ") + ")"
 	userTests = append(userTests, userTest{"Find where",
 		UserFindRequest{
-			Where: where,
-			Args:  whereArgs,
-			Order: []string{"created_at"},
+			Filter: append(createdFilter, filter.Condition{Field: "email", Operator: filter.Op_In, Values: values}),
+			Order:  []string{"created_at"},
 		},
 		expected,
 		nil,
@@ -1211,6 +1240,274 @@ func TestResetPassword(t *testing.T) {
 	}
 }
 
+// TestVerifyEmail validates email verification for new users and email changes.
+func TestVerifyEmail(t *testing.T) {
+
+	t.Log("Given the need ensure a user can verify their email address.")
+	{
+		ctx := tests.Context()
+
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		// Create a new user for testing.
+		initPass := uuid.NewRandom().String()
+		user, err := repo.Create(ctx, auth.Claims{}, UserCreateRequest{
+			FirstName:       "Lee",
+			LastName:        "Brown",
+			Email:           uuid.NewRandom().String() + "@geeksinthewoods.com",
+			Password:        initPass,
+			PasswordConfirm: initPass,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+		} else if user.EmailVerified() {
+			t.Fatalf("\t%s\tExpected new user to not be verified.", tests.Failed)
+		}
+
+		ttl := time.Hour
+
+		// Make the verify email request.
+		verifyHash, err := repo.VerifyEmail(ctx, UserVerifyEmailRequest{
+			Email: user.Email,
+			TTL:   ttl,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tVerifyEmail failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerifyEmail ok.", tests.Success)
+
+		// Ensure the TTL is enforced.
+		_, err = repo.VerifyEmailConfirm(ctx, UserVerifyEmailConfirmRequest{VerifyHash: verifyHash}, now.UTC().Add(ttl*2))
+		if errors.Cause(err) != ErrVerifyExpired {
+			t.Logf("\t\tGot : %+v", errors.Cause(err))
+			t.Logf("\t\tWant: %+v", ErrVerifyExpired)
+			t.Fatalf("\t%s\tVerifyEmailConfirm enforce TTL failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerifyEmailConfirm enforce TTL ok.", tests.Success)
+
+		// Assuming we have received the email and clicked the link, we now can ensure confirm works.
+		verified, err := repo.VerifyEmailConfirm(ctx, UserVerifyEmailConfirmRequest{VerifyHash: verifyHash}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tVerifyEmailConfirm failed.", tests.Failed)
+		} else if verified.ID != user.ID || !verified.EmailVerified() {
+			t.Logf("\t\tGot : %+v", verified)
+			t.Fatalf("\t%s\tVerifyEmailConfirm failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerifyEmailConfirm ok.", tests.Success)
+
+		// Ensure the email can't be verified again.
+		_, err = repo.VerifyEmail(ctx, UserVerifyEmailRequest{Email: user.Email}, now)
+		if errors.Cause(err) != ErrEmailAlreadyVerified {
+			t.Logf("\t\tGot : %+v", errors.Cause(err))
+			t.Logf("\t\tWant: %+v", ErrEmailAlreadyVerified)
+			t.Fatalf("\t%s\tVerifyEmail already verified failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerifyEmail already verified ok.", tests.Success)
+
+		// Changing the email address as the user only sets the pending email address.
+		claims := auth.Claims{
+			Roles: []string{auth.RoleUser},
+			StandardClaims: jwt.StandardClaims{
+				Subject: user.ID,
+			},
+		}
+		newEmail := uuid.NewRandom().String() + "@geeksinthewoods.com"
+		err = repo.Update(ctx, claims, UserUpdateRequest{ID: user.ID, Email: &newEmail}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tUpdate failed.", tests.Failed)
+		}
+
+		updated, err := repo.ReadByID(ctx, auth.Claims{}, user.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead failed.", tests.Failed)
+		} else if updated.Email != user.Email || updated.EmailPending == nil || updated.EmailPending.String != newEmail {
+			t.Logf("\t\tGot : %+v", updated)
+			t.Fatalf("\t%s\tExpected email %s to be pending.", tests.Failed, newEmail)
+		}
+		t.Logf("\t%s\tUpdate email pending ok.", tests.Success)
+
+		// Request a new email for the pending email address and confirm it.
+		verifyHash, err = repo.VerifyEmail(ctx, UserVerifyEmailRequest{Email: newEmail}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tVerifyEmail pending failed.", tests.Failed)
+		}
+
+		verified, err = repo.VerifyEmailConfirm(ctx, UserVerifyEmailConfirmRequest{VerifyHash: verifyHash}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tVerifyEmailConfirm pending failed.", tests.Failed)
+		} else if verified.Email != newEmail || verified.EmailPending != nil || !verified.EmailVerified() {
+			t.Logf("\t\tGot : %+v", verified)
+			t.Fatalf("\t%s\tExpected email to be changed to %s.", tests.Failed, newEmail)
+		}
+		t.Logf("\t%s\tVerifyEmailConfirm pending ok.", tests.Success)
+
+		// Ensure the verify hash does not work after its used.
+		_, err = repo.VerifyEmailConfirm(ctx, UserVerifyEmailConfirmRequest{VerifyHash: verifyHash}, now)
+		if errors.Cause(err) != ErrNotFound {
+			t.Logf("\t\tGot : %+v", errors.Cause(err))
+			t.Logf("\t\tWant: %+v", ErrNotFound)
+			t.Fatalf("\t%s\tVerifyEmailConfirm reuse failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tVerifyEmailConfirm reuse disabled ok.", tests.Success)
+	}
+}
+
+// TestMfa validates two-factor authentication enrollment, verification and disable.
+func TestMfa(t *testing.T) {
+
+	t.Log("Given the need ensure a user can enable two-factor authentication.")
+	{
+		ctx := tests.Context()
+
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		// Create a new user for testing.
+		initPass := uuid.NewRandom().String()
+		user, err := repo.Create(ctx, auth.Claims{}, UserCreateRequest{
+			FirstName:       "Lee",
+			LastName:        "Brown",
+			Email:           uuid.NewRandom().String() + "@geeksinthewoods.com",
+			Password:        initPass,
+			PasswordConfirm: initPass,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+		}
+
+		// Ensure another user is not able to enroll the user.
+		otherClaims := auth.Claims{
+			Roles: []string{auth.RoleAdmin},
+			StandardClaims: jwt.StandardClaims{
+				Subject: uuid.NewRandom().String(),
+			},
+		}
+		_, err = repo.MfaEnroll(ctx, otherClaims, UserMfaEnrollRequest{ID: user.ID}, now)
+		if errors.Cause(err) != ErrForbidden {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrForbidden)
+			t.Fatalf("\t%s\tMfaEnroll other user failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tMfaEnroll other user ok.", tests.Success)
+
+		// Start enrollment for the user.
+		enrollment, err := repo.MfaEnroll(ctx, auth.Claims{}, UserMfaEnrollRequest{ID: user.ID}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tMfaEnroll failed.", tests.Failed)
+		} else if enrollment.Secret == "" || len(enrollment.RecoveryCodes) != mfaRecoveryCodeCount {
+			t.Logf("\t\tGot : %+v", enrollment)
+			t.Fatalf("\t%s\tMfaEnroll failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tMfaEnroll ok.", tests.Success)
+
+		// Ensure a recovery code can't be used to complete enrollment.
+		err = repo.MfaVerify(ctx, auth.Claims{}, UserMfaVerifyRequest{ID: user.ID, Code: enrollment.RecoveryCodes[0]}, now)
+		if errors.Cause(err) != ErrMfaInvalidCode {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrMfaInvalidCode)
+			t.Fatalf("\t%s\tMfaVerify recovery code before enrollment failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tMfaVerify recovery code before enrollment ok.", tests.Success)
+
+		// Complete enrollment with a code generated from the secret.
+		code, err := totp.GenerateCode(enrollment.Secret, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGenerateCode failed.", tests.Failed)
+		}
+		err = repo.MfaVerify(ctx, auth.Claims{}, UserMfaVerifyRequest{ID: user.ID, Code: code}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tMfaVerify failed.", tests.Failed)
+		}
+
+		readUser, err := repo.ReadByID(ctx, auth.Claims{}, user.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tReadByID failed.", tests.Failed)
+		} else if !readUser.MfaEnabled() {
+			t.Fatalf("\t%s\tMfaVerify failed to enable two-factor authentication.", tests.Failed)
+		}
+		t.Logf("\t%s\tMfaVerify ok.", tests.Success)
+
+		// Ensure the same code can't be used twice.
+		err = repo.MfaVerify(ctx, auth.Claims{}, UserMfaVerifyRequest{ID: user.ID, Code: code}, now)
+		if errors.Cause(err) != ErrMfaInvalidCode {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrMfaInvalidCode)
+			t.Fatalf("\t%s\tMfaVerify replay failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tMfaVerify replay ok.", tests.Success)
+
+		// Ensure enrollment can't be started again once enabled.
+		_, err = repo.MfaEnroll(ctx, auth.Claims{}, UserMfaEnrollRequest{ID: user.ID}, now)
+		if errors.Cause(err) != ErrMfaAlreadyEnabled {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrMfaAlreadyEnabled)
+			t.Fatalf("\t%s\tMfaEnroll when enabled failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tMfaEnroll when enabled ok.", tests.Success)
+
+		// Ensure a recovery code can only be used once.
+		err = repo.MfaVerify(ctx, auth.Claims{}, UserMfaVerifyRequest{ID: user.ID, Code: enrollment.RecoveryCodes[1]}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tMfaVerify recovery code failed.", tests.Failed)
+		}
+		err = repo.MfaVerify(ctx, auth.Claims{}, UserMfaVerifyRequest{ID: user.ID, Code: enrollment.RecoveryCodes[1]}, now)
+		if errors.Cause(err) != ErrMfaInvalidCode {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrMfaInvalidCode)
+			t.Fatalf("\t%s\tMfaVerify used recovery code failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tMfaVerify recovery code ok.", tests.Success)
+
+		// Disable two-factor authentication with a code from the next time step.
+		next := now.Add(time.Second * totp.Period)
+		code, err = totp.GenerateCode(enrollment.Secret, next)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGenerateCode failed.", tests.Failed)
+		}
+		err = repo.MfaDisable(ctx, auth.Claims{}, UserMfaDisableRequest{ID: user.ID, Code: code}, next)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tMfaDisable failed.", tests.Failed)
+		}
+
+		readUser, err = repo.ReadByID(ctx, auth.Claims{}, user.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tReadByID failed.", tests.Failed)
+		} else if readUser.MfaEnabled() {
+			t.Fatalf("\t%s\tMfaDisable failed to disable two-factor authentication.", tests.Failed)
+		}
+		t.Logf("\t%s\tMfaDisable ok.", tests.Success)
+
+		// Ensure the challenge hash expires.
+		hash, err := repo.NewMfaHash(ctx, user.ID, "", "", "69.56.104.36", time.Minute, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tNewMfaHash failed.", tests.Failed)
+		}
+		_, err = repo.ParseMfaHash(ctx, hash, now.Add(time.Minute*2))
+		if errors.Cause(err) != ErrMfaExpired {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrMfaExpired)
+			t.Fatalf("\t%s\tParseMfaHash expired failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tParseMfaHash expired ok.", tests.Success)
+	}
+}
+
 func mockUserAccount(userId, accountId string, now time.Time, roles ...string) error {
 	var roleArr pq.StringArray
 	for _, r := range roles {
@@ -0,0 +1,282 @@
+package user
+
+import (
+	"context"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/lib/pq"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+var (
+	// ErrVerifyExpired occurs when the the verify hash exceeds the expiration.
+	ErrVerifyExpired = errors.New("Verification expired")
+
+	// ErrEmailAlreadyVerified occurs when a verification email is requested for an email address that has already
+	// been verified.
+	ErrEmailAlreadyVerified = errors.New("Email address is already verified")
+
+	// ErrEmailUnavailable occurs when a pending email address is verified but another user has started using the
+	// email address in the meantime.
+	ErrEmailUnavailable = errors.New("Email address is already in use")
+)
+
+// VerifyEmail sends an email to the user with a link to verify their email address. The email address can either be
+// the current email address of a user that has not been verified yet or a pending email address requested by an
+// update.
+func (repo *Repository) VerifyEmail(ctx context.Context, req UserVerifyEmailRequest, now time.Time) (string, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.VerifyEmail")
+	defer span.Finish()
+
+	v := webcontext.Validator()
+
+	// Validate the request.
+	err := v.StructCtx(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	// Find users by email address, a pending email address could have been requested by more than one user.
+	query := selectQuery()
+	query.Where(query.Or(
+		query.Equal("email", req.Email),
+		query.Equal("email_pending", req.Email),
+	))
+
+	res, err := find(ctx, auth.Claims{}, repo.DbConn, query, []interface{}{}, false)
+	if err != nil {
+		return "", err
+	} else if res == nil || len(res) == 0 {
+		err = errors.WithMessagef(ErrNotFound, "No user found using '%s'.", req.Email)
+		return "", err
+	}
+
+	var encrypted string
+	for _, u := range res {
+		if u.Email == req.Email && u.EmailVerified() {
+			if len(res) == 1 {
+				return "", errors.WithMessagef(ErrEmailAlreadyVerified, "Email '%s' is already verified.", req.Email)
+			}
+			continue
+		}
+
+		encrypted, err = repo.sendVerifyEmail(ctx, u, req.Email, req.TTL, now)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return encrypted, nil
+}
+
+// VerifyEmailConfirm verifies the email address for a user using the provided verify hash. When the user has a
+// pending email address, it replaces the current email address.
+func (repo *Repository) VerifyEmailConfirm(ctx context.Context, req UserVerifyEmailConfirmRequest, now time.Time) (*User, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.VerifyEmailConfirm")
+	defer span.Finish()
+
+	v := webcontext.Validator()
+
+	// Validate the request.
+	err := v.StructCtx(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := ParseVerifyHash(ctx, repo.secretKey, req.VerifyHash, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find user by email_verify.
+	var u *User
+	{
+		query := selectQuery()
+		query.Where(query.Equal("email_verify", hash.VerifyID))
+
+		res, err := find(ctx, auth.Claims{}, repo.DbConn, query, []interface{}{}, false)
+		if err != nil {
+			return nil, err
+		} else if res == nil || len(res) == 0 {
+			err = errors.WithMessage(ErrNotFound, "Invalid email verification.")
+			return nil, err
+		}
+		u = res[0]
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(userTableName)
+
+	fields := []string{
+		query.Assign("email_verified_at", now),
+		query.Assign("email_verify", nil),
+		query.Assign("updated_at", now),
+	}
+
+	if u.EmailPending != nil && u.EmailPending.Valid && u.EmailPending.String == hash.Email {
+		// Replace the current email address with the pending email address that has been verified.
+		uniq, err := UniqueEmail(ctx, repo.DbConn, hash.Email, u.ID)
+		if err != nil {
+			return nil, err
+		} else if !uniq {
+			return nil, errors.WithMessagef(ErrEmailUnavailable, "Email '%s' is already in use.", hash.Email)
+		}
+
+		fields = append(fields, query.Assign("email", hash.Email), query.Assign("email_pending", nil))
+		u.Email = hash.Email
+		u.EmailPending = nil
+	} else if u.Email != hash.Email {
+		err = errors.WithMessage(ErrNotFound, "Invalid email verification.")
+		return nil, err
+	}
+
+	query.Set(fields...)
+	query.Where(query.Equal("id", u.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "verify email for user %s failed", u.ID)
+		return nil, err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, auth.Claims{}, audit.AuditEventRecordRequest{
+		ActorUserID: u.ID,
+		EntityType:  audit.EntityType_User,
+		EntityID:    u.ID,
+		Action:      audit.Action_VerifyEmail,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	u.EmailVerifiedAt = &pq.NullTime{Time: now, Valid: true}
+	u.EmailVerify = nil
+	u.UpdatedAt = now
+
+	return u, nil
+}
+
+// SetEmailVerified marks the current email address for the user as verified. It's used when the user has proven they
+// own the email address some other way, ie by accepting an invite sent to it.
+func (repo *Repository) SetEmailVerified(ctx context.Context, userID string, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user.SetEmailVerified")
+	defer span.Finish()
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(userTableName)
+	query.Set(
+		query.Assign("email_verified_at", now),
+		query.Assign("updated_at", now),
+	)
+	query.Where(query.And(
+		query.Equal("id", userID),
+		query.IsNull("email_verified_at"),
+	))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "verify email for user %s failed", userID)
+		return err
+	}
+
+	return nil
+}
+
+// sendVerifyEmail updates the user with a new random string used to confirm the email address and then sends the
+// email with the link to verify it.
+func (repo *Repository) sendVerifyEmail(ctx context.Context, u *User, email string, ttl time.Duration, now time.Time) (string, error) {
+	if repo.VerifyUrl == nil {
+		return "", errors.New("Verify url not defined for repository")
+	}
+
+	// Update the user with a random string used to confirm the email address.
+	verifyId := uuid.NewRandom().String()
+	{
+		// Always store the time as UTC.
+		now = now.UTC()
+
+		// Postgres truncates times to milliseconds when storing. We and do the same
+		// here so the value we return is consistent with what we store.
+		now = now.Truncate(time.Millisecond)
+
+		// Build the update SQL statement.
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(userTableName)
+		query.Set(
+			query.Assign("email_verify", verifyId),
+			query.Assign("updated_at", now),
+		)
+		query.Where(query.Equal("id", u.ID))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "Update user %s failed.", u.ID)
+			return "", err
+		}
+	}
+
+	if ttl.Seconds() == 0 {
+		ttl = time.Hour * 24
+	}
+
+	// Load the current IP makings the request.
+	var requestIp string
+	if vals, _ := webcontext.ContextValues(ctx); vals != nil {
+		requestIp = vals.RequestIP
+	}
+
+	encrypted, err := NewVerifyHash(ctx, repo.secretKey, verifyId, email, requestIp, ttl, now)
+	if err != nil {
+		return "", err
+	}
+
+	data := map[string]interface{}{
+		"Name":  u.FirstName,
+		"Email": email,
+		"Url":   repo.VerifyUrl(encrypted),
+		"Hours": ttl.Hours(),
+	}
+
+	err = repo.Notify.Send(ctx, email, "Verify your Email Address", "user_verify_email", data)
+	if err != nil {
+		err = errors.WithMessagef(err, "Send verify email to %s failed.", email)
+		return "", err
+	}
+
+	return encrypted, nil
+}
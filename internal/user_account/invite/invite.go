@@ -3,15 +3,19 @@ package invite
 import (
 	"context"
 	"fmt"
-	"strings"
 	"time"
 
 	//"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/webhook"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
@@ -51,8 +55,9 @@ func (repo *Repository) SendUserInvites(ctx context.Context, claims auth.Claims,
 	{
 		// Find all users without passing in claims to search all users.
 		users, err := repo.User.Find(ctx, auth.Claims{}, user.UserFindRequest{
-			Where: fmt.Sprintf("email in ('%s')",
-				strings.Join(req.Emails, "','")),
+			Filter: filter.Filter{
+				{Field: "email", Operator: filter.Op_In, Values: req.Emails},
+			},
 		})
 		if err != nil {
 			return nil, err
@@ -65,16 +70,17 @@ func (repo *Repository) SendUserInvites(ctx context.Context, claims auth.Claims,
 
 	// Find users that are already active for this account.
 	activelUserIDs := make(map[string]bool)
-	{
-		var args []string
+	if len(emailUserIDs) > 0 {
+		var userIDs []string
 		for _, userID := range emailUserIDs {
-			args = append(args, userID)
+			userIDs = append(userIDs, userID)
 		}
 
 		userAccs, err := repo.UserAccount.Find(ctx, claims, user_account.UserAccountFindRequest{
-			Where: fmt.Sprintf("user_id in ('%s') and status = '%s'",
-				strings.Join(args, "','"),
-				user_account.UserAccountStatus_Active.String()),
+			Filter: filter.Filter{
+				{Field: "user_id", Operator: filter.Op_In, Values: userIDs},
+				{Field: "status", Operator: filter.Op_Eq, Values: []string{user_account.UserAccountStatus_Active.String()}},
+			},
 		})
 		if err != nil {
 			return nil, err
@@ -92,40 +98,65 @@ func (repo *Repository) SendUserInvites(ctx context.Context, claims auth.Claims,
 	// here so the value we return is consistent with what we store.
 	now = now.Truncate(time.Millisecond)
 
-	// Create any users that don't already exist.
-	for _, email := range req.Emails {
-		if uId, ok := emailUserIDs[email]; ok && uId != "" {
-			continue
+	// createInvites creates any users that don't already exist and the user accounts for the users that are not
+	// already active.
+	createInvites := func() error {
+		for _, email := range req.Emails {
+			if uId, ok := emailUserIDs[email]; ok && uId != "" {
+				continue
+			}
+
+			u, err := repo.User.CreateInvite(ctx, claims, user.UserCreateInviteRequest{
+				Email: email,
+			}, now)
+			if err != nil {
+				return err
+			}
+
+			emailUserIDs[email] = u.ID
 		}
 
-		u, err := repo.User.CreateInvite(ctx, claims, user.UserCreateInviteRequest{
-			Email: email,
-		}, now)
-		if err != nil {
-			return nil, err
+		// Loop through all the existing users who either do not have an user_account record or
+		// have an existing record, but the status is disabled.
+		for _, userID := range emailUserIDs {
+			// User already is active, skip.
+			if activelUserIDs[userID] {
+				continue
+			}
+
+			status := user_account.UserAccountStatus_Invited
+			_, err := repo.UserAccount.Create(ctx, claims, user_account.UserAccountCreateRequest{
+				UserID:    userID,
+				AccountID: req.AccountID,
+				Roles:     req.Roles,
+				Status:    &status,
+			}, now)
+			if err != nil {
+				return err
+			}
 		}
 
-		emailUserIDs[email] = u.ID
+		return nil
 	}
 
-	// Loop through all the existing users who either do not have an user_account record or
-	// have an existing record, but the status is disabled.
-	for _, userID := range emailUserIDs {
-		// User already is active, skip.
-		if activelUserIDs[userID] {
-			continue
+	var seats int
+	for _, email := range req.Emails {
+		if !activelUserIDs[emailUserIDs[email]] {
+			seats++
 		}
+	}
 
-		status := user_account.UserAccountStatus_Invited
-		_, err = repo.UserAccount.Create(ctx, claims, user_account.UserAccountCreateRequest{
-			UserID:    userID,
-			AccountID: req.AccountID,
-			Roles:     req.Roles,
-			Status:    &status,
-		}, now)
-		if err != nil {
-			return nil, err
-		}
+	if repo.Entitlements != nil && seats > 0 {
+		// Ensure the plan of the account has a seat for every user that is not already active. The user accounts are
+		// created while the seats are reserved so concurrent invites can't exceed the limit.
+		err = repo.Entitlements.Reserve(ctx, req.AccountID, entitlement.Limit_Seats, seats, now, func(tx *sqlx.Tx) error {
+			return createInvites()
+		})
+	} else {
+		err = createInvites()
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	if req.TTL.Seconds() == 0 {
@@ -170,6 +201,31 @@ func (repo *Repository) SendUserInvites(ctx context.Context, claims auth.Claims,
 			return nil, err
 		}
 
+		_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+			AccountID:  req.AccountID,
+			EntityType: audit.EntityType_UserAccount,
+			EntityID:   userID,
+			Action:     audit.Action_Invite,
+			After: map[string]interface{}{
+				"email": email,
+				"roles": req.Roles,
+			},
+		}, now)
+		if err != nil {
+			return nil, err
+		}
+
+		err = webhook.Publish(ctx, repo.DbConn, req.AccountID, webhook.EventType_UserInvited, map[string]interface{}{
+			"user_id":    userID,
+			"account_id": req.AccountID,
+			"email":      email,
+			"roles":      req.Roles,
+			"invited_by": req.UserID,
+		}, now)
+		if err != nil {
+			return nil, err
+		}
+
 		inviteHashes = append(inviteHashes, hash)
 	}
 
@@ -237,6 +293,17 @@ func (repo *Repository) AcceptInvite(ctx context.Context, req AcceptInviteReques
 		if err != nil {
 			return nil, err
 		}
+
+		_, err = audit.Record(ctx, repo.DbConn, auth.Claims{}, audit.AuditEventRecordRequest{
+			AccountID:   hash.AccountID,
+			ActorUserID: hash.UserID,
+			EntityType:  audit.EntityType_UserAccount,
+			EntityID:    hash.UserID,
+			Action:      audit.Action_AcceptInvite,
+		}, now)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return usrAcc, nil
@@ -303,6 +370,14 @@ func (repo *Repository) AcceptInviteUser(ctx context.Context, req AcceptInviteUs
 		return nil, err
 	}
 
+	// The invite was sent to the email address of the user, so when it's unchanged the email address is verified.
+	if req.Email == u.Email {
+		err = repo.User.SetEmailVerified(ctx, hash.UserID, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err = repo.User.UpdatePassword(ctx, auth.Claims{}, user.UserUpdatePasswordRequest{
 		ID:              hash.UserID,
 		Password:        req.Password,
@@ -322,5 +397,16 @@ func (repo *Repository) AcceptInviteUser(ctx context.Context, req AcceptInviteUs
 		return nil, err
 	}
 
+	_, err = audit.Record(ctx, repo.DbConn, auth.Claims{}, audit.AuditEventRecordRequest{
+		AccountID:   hash.AccountID,
+		ActorUserID: hash.UserID,
+		EntityType:  audit.EntityType_UserAccount,
+		EntityID:    hash.UserID,
+		Action:      audit.Action_AcceptInvite,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
 	return usrAcc, nil
 }
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/entitlement"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/notify"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user"
@@ -25,6 +26,9 @@ type Repository struct {
 	ResetUrl    func(string) string
 	Notify      notify.Email
 	secretKey   string
+
+	// Entitlements is used to enforce the seat limit of the plan when set.
+	Entitlements entitlement.Checker
 }
 
 // NewRepository creates a new Repository that defines dependencies for User Invite.
@@ -7,6 +7,7 @@ import (
 
 	"database/sql/driver"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
@@ -17,6 +18,10 @@ import (
 // Repository defines the required dependencies for UserAccount.
 type Repository struct {
 	DbConn *sqlx.DB
+
+	// RowLevelSecurity scopes the queries to the account and user of the claims with a transaction so the row level
+	// security policies are enforced in addition to the ACL applied to the queries.
+	RowLevelSecurity bool
 }
 
 // NewRepository creates a new Repository that defines dependencies for UserAccount.
@@ -36,7 +41,7 @@ type UserAccount struct {
 	//ID         string            `json:"id" validate:"required,uuid" example:"72938896-a998-4258-a17b-6418dcdb80e3"`
 	UserID     string            `json:"user_id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
 	AccountID  string            `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Roles      UserAccountRoles  `json:"roles" validate:"required,dive,oneof=admin user" enums:"admin,user" swaggertype:"array,string" example:"admin"`
+	Roles      UserAccountRoles  `json:"roles" validate:"required,dive,role" swaggertype:"array,string" example:"admin"`
 	Status     UserAccountStatus `json:"status" validate:"omitempty,oneof=active invited disabled" enums:"active,invited,disabled" swaggertype:"string" example:"active"`
 	CreatedAt  time.Time         `json:"created_at"`
 	UpdatedAt  time.Time         `json:"updated_at"`
@@ -48,7 +53,7 @@ type UserAccountResponse struct {
 	//ID         string            `json:"id" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
 	UserID     string                `json:"user_id" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
 	AccountID  string                `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Roles      web.EnumMultiResponse `json:"roles" validate:"required,dive,oneof=admin user" enums:"admin,user" swaggertype:"array,string" example:"admin"`
+	Roles      web.EnumMultiResponse `json:"roles" validate:"required,dive,role" swaggertype:"array,string" example:"admin"`
 	Status     web.EnumResponse      `json:"status"`                // Status is enum with values [active, invited, disabled].
 	CreatedAt  web.TimeResponse      `json:"created_at"`            // CreatedAt contains multiple format options for display.
 	UpdatedAt  web.TimeResponse      `json:"updated_at"`            // UpdatedAt contains multiple format options for display.
@@ -120,7 +125,7 @@ func (m *UserAccounts) Response(ctx context.Context) []*UserAccountResponse {
 type UserAccountCreateRequest struct {
 	UserID    string             `json:"user_id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
 	AccountID string             `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Roles     UserAccountRoles   `json:"roles" validate:"required,dive,oneof=admin user" enums:"admin,user" swaggertype:"array,string" example:"admin"`
+	Roles     UserAccountRoles   `json:"roles" validate:"required,dive,role" swaggertype:"array,string" example:"admin"`
 	Status    *UserAccountStatus `json:"status,omitempty" validate:"omitempty,oneof=active invited disabled" enums:"active,invited,disabled" swaggertype:"string" example:"active"`
 }
 
@@ -136,7 +141,7 @@ type UserAccountReadRequest struct {
 type UserAccountUpdateRequest struct {
 	UserID    string             `json:"user_id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
 	AccountID string             `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Roles     *UserAccountRoles  `json:"roles,omitempty" validate:"omitempty,dive,oneof=admin user" enums:"admin,user" swaggertype:"array,string" example:"user"`
+	Roles     *UserAccountRoles  `json:"roles,omitempty" validate:"omitempty,dive,role" swaggertype:"array,string" example:"user"`
 	Status    *UserAccountStatus `json:"status,omitempty" validate:"omitempty,oneof=active invited disabled" enums:"active,invited,disabled" swaggertype:"string" example:"disabled"`
 	unArchive bool               `json:"-"` // Internal use only.
 }
@@ -155,17 +160,47 @@ type UserAccountDeleteRequest struct {
 	AccountID string `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
 }
 
+// UserAccountTransferOwnerRequest defines the information needed to transfer the ownership
+// of an account from the current owner to another active user of the account.
+type UserAccountTransferOwnerRequest struct {
+	AccountID  string `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	FromUserID string `json:"from_user_id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	ToUserID   string `json:"to_user_id" validate:"required,uuid,nefield=FromUserID" example:"a2e3b4c5-1c55-4f2d-8fd3-9f5f4c3b2e1d"`
+}
+
 // UserAccountFindRequest defines the possible options to search for users accounts.
 // By default archived user accounts will be excluded from response.
 type UserAccountFindRequest struct {
-	Where           string        `json:"where" example:"user_id = ? and account_id = ?"`
-	Args            []interface{} `json:"args" swaggertype:"array,string" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2,c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	Filter          filter.Filter `json:"filter,omitempty"`
 	Order           []string      `json:"order" example:"created_at desc"`
 	Limit           *uint         `json:"limit" example:"10"`
 	Offset          *uint         `json:"offset" example:"20"`
+	Cursor          string        `json:"cursor,omitempty" example:"eyJvIjpbImlkIGFzYyJdLCJ2IjpbIjk4NWYxNzQ2Il19"`
 	IncludeArchived bool          `json:"include-archived" example:"false"`
 }
 
+// UserAccountFindFields defines the fields of user account that can be used to filter and sort a find request.
+var UserAccountFindFields = filter.Fields{
+	{Name: "user_id", Type: filter.FieldType_UUID, Filterable: true, Sortable: true, Key: true},
+	{Name: "account_id", Type: filter.FieldType_UUID, Filterable: true, Sortable: true, Key: true},
+	{Name: "status", Type: filter.FieldType_Enum, Values: filter.EnumValues(UserAccountStatus_ValuesInterface()), Filterable: true, Sortable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "updated_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "archived_at", Type: filter.FieldType_Time, Filterable: true, Nullable: true},
+}
+
+// UserFindByAccountFields defines the fields of the users of an account that can be used to filter a find request.
+var UserFindByAccountFields = filter.Fields{
+	{Name: "id", Type: filter.FieldType_UUID, Filterable: true},
+	{Name: "first_name", Type: filter.FieldType_String, Filterable: true},
+	{Name: "last_name", Type: filter.FieldType_String, Filterable: true},
+	{Name: "email", Type: filter.FieldType_String, Filterable: true},
+	{Name: "status", Type: filter.FieldType_Enum, Values: filter.EnumValues(UserAccountStatus_ValuesInterface()), Filterable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true},
+	{Name: "updated_at", Type: filter.FieldType_Time, Filterable: true},
+	{Name: "archived_at", Type: filter.FieldType_Time, Filterable: true},
+}
+
 // UserAccountStatus represents the status of a user for an account.
 type UserAccountStatus string
 
@@ -224,11 +259,16 @@ func (s UserAccountStatus) String() string {
 	return string(s)
 }
 
-// UserAccountRole represents the role of a user for an account.
+// UserAccountRole represents the role of a user for an account. Besides the built-in roles
+// below, accounts can define custom roles, see account_role.
 type UserAccountRole string
 
-// UserAccountRole values define the role field of a user account.
+// UserAccountRole values define the built-in roles of a user account.
 const (
+	// UserAccountRole_Owner defines the user that owns an account. Every account has a
+	// single owner that also has the admin role. The owner role can't be assigned
+	// directly and is only changed by transferring the ownership of the account.
+	UserAccountRole_Owner UserAccountRole = auth.RoleOwner
 	// UserAccountRole_Admin defines the state of a user when they have admin
 	// privileges for accessing an account. This role provides a user with full
 	// access to an account.
@@ -239,7 +279,8 @@ const (
 	UserAccountRole_User UserAccountRole = auth.RoleUser
 )
 
-// UserAccountRole_Values provides list of valid UserAccountRole values.
+// UserAccountRole_Values provides list of valid UserAccountRole values that can be
+// assigned to a user account, the owner role is excluded.
 var UserAccountRole_Values = []UserAccountRole{
 	UserAccountRole_Admin,
 	UserAccountRole_User,
@@ -278,13 +319,10 @@ func (s *UserAccountRoles) Scan(value interface{}) error {
 
 // Value converts the UserAccountRole value to be stored in the database.
 func (s UserAccountRoles) Value() (driver.Value, error) {
-	v := validator.New()
-
 	var arr pq.StringArray
 	for _, r := range s {
-		errs := v.Var(r, "required,oneof=admin user")
-		if errs != nil {
-			return nil, errs
+		if !auth.ValidRoleName(r.String()) {
+			return nil, errors.Errorf("invalid role %q", r)
 		}
 		arr = append(arr, r.String())
 	}
@@ -301,7 +339,7 @@ type User struct {
 	Email      string            `json:"email" validate:"required,email,unique" example:"gabi@geeksinthewoods.com"`
 	Timezone   *string           `json:"timezone" validate:"omitempty" example:"America/Anchorage"`
 	AccountID  string            `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Roles      UserAccountRoles  `json:"roles" validate:"required,dive,oneof=admin user" enums:"admin,user" swaggertype:"array,string" example:"admin"`
+	Roles      UserAccountRoles  `json:"roles" validate:"required,dive,role" swaggertype:"array,string" example:"admin"`
 	Status     UserAccountStatus `json:"status" validate:"omitempty,oneof=active invited disabled" enums:"active,invited,disabled" swaggertype:"string" example:"active"`
 	CreatedAt  time.Time         `json:"created_at"`
 	UpdatedAt  time.Time         `json:"updated_at"`
@@ -317,7 +355,7 @@ type UserResponse struct {
 	Email      string                `json:"email" example:"gabi@geeksinthewoods.com"`
 	Timezone   string                `json:"timezone" example:"America/Anchorage"`
 	AccountID  string                `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Roles      web.EnumMultiResponse `json:"roles" validate:"required,dive,oneof=admin user" enums:"admin,user" swaggertype:"array,string" example:"admin"`
+	Roles      web.EnumMultiResponse `json:"roles" validate:"required,dive,role" swaggertype:"array,string" example:"admin"`
 	Status     web.EnumResponse      `json:"status"`                // Status is enum with values [active, invited, disabled].
 	CreatedAt  web.TimeResponse      `json:"created_at"`            // CreatedAt contains multiple format options for display.
 	UpdatedAt  web.TimeResponse      `json:"updated_at"`            // UpdatedAt contains multiple format options for display.
@@ -386,10 +424,22 @@ func (m *Users) Response(ctx context.Context) []*UserResponse {
 // By default archived users will be excluded from response.
 type UserFindByAccountRequest struct {
 	AccountID       string        `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Where           string        `json:"where" example:"name = ? and email = ?"`
-	Args            []interface{} `json:"args" swaggertype:"array,string" example:"Company Name,gabi.may@geeksinthewoods.com"`
+	Filter          filter.Filter `json:"filter,omitempty"`
+	Role            string        `json:"role,omitempty" example:"admin"`
 	Order           []string      `json:"order" example:"created_at desc"`
 	Limit           *uint         `json:"limit" example:"10"`
 	Offset          *uint         `json:"offset" example:"20"`
 	IncludeArchived bool          `json:"include-archived" example:"false"`
+
+	// condition is an additional where condition built by another package, it can't be set by requests.
+	condition     string
+	conditionArgs []interface{}
+}
+
+// WithCondition returns a copy of the request with an additional where condition. The condition must be generated
+// by the caller with ? placeholders for the args, values from requests should use the filter.
+func (req UserFindByAccountRequest) WithCondition(where string, args ...interface{}) UserFindByAccountRequest {
+	req.condition = where
+	req.conditionArgs = args
+	return req
 }
@@ -0,0 +1,126 @@
+package transfer
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/notify"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sudo-suhas/symcrypto"
+)
+
+// Repository defines the required dependencies for Ownership Transfer.
+type Repository struct {
+	DbConn      *sqlx.DB
+	User        *user.Repository
+	UserAccount *user_account.Repository
+	Account     *account.Repository
+	ConfirmUrl  func(string) string
+	Notify      notify.Email
+	secretKey   string
+}
+
+// NewRepository creates a new Repository that defines dependencies for Ownership Transfer.
+func NewRepository(db *sqlx.DB, user *user.Repository, userAccount *user_account.Repository, account *account.Repository,
+	confirmUrl func(string) string, notify notify.Email, secretKey string) *Repository {
+	return &Repository{
+		DbConn:      db,
+		User:        user,
+		UserAccount: userAccount,
+		Account:     account,
+		ConfirmUrl:  confirmUrl,
+		Notify:      notify,
+		secretKey:   secretKey,
+	}
+}
+
+// SendTransferRequest defines the data needed to request the transfer of the ownership of an account to another user.
+type SendTransferRequest struct {
+	AccountID string        `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	UserID    string        `json:"user_id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	TTL       time.Duration `json:"ttl,omitempty"`
+}
+
+// TransferHash
+type TransferHash struct {
+	FromUserID string `json:"from_user_id" validate:"required,uuid" example:"a2e3b4c5-1c55-4f2d-8fd3-9f5f4c3b2e1d"`
+	ToUserID   string `json:"to_user_id" validate:"required,uuid" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+	AccountID  string `json:"account_id" validate:"required,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	CreatedAt  int    `json:"created_at" validate:"required"`
+	ExpiresAt  int    `json:"expires_at" validate:"required"`
+	RequestIP  string `json:"request_ip" validate:"required,ip" example:"69.56.104.36"`
+}
+
+// AcceptTransferRequest defines the fields need to confirm the transfer of the ownership of an account.
+type AcceptTransferRequest struct {
+	TransferHash string `json:"transfer_hash" validate:"required" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
+}
+
+// NewTransferHash generates a new encrypt transfer hash that is web safe for use in URLs.
+func NewTransferHash(ctx context.Context, secretKey, fromUserID, toUserID, accountID, requestIp string, ttl time.Duration, now time.Time) (string, error) {
+	// Generate a string that embeds additional information.
+	hashPts := []string{
+		fromUserID,
+		toUserID,
+		accountID,
+		strconv.Itoa(int(now.UTC().Unix())),
+		strconv.Itoa(int(now.UTC().Add(ttl).Unix())),
+		requestIp,
+	}
+	hashStr := strings.Join(hashPts, "|")
+
+	// This returns the nonce appended with the encrypted string.
+	crypto, err := symcrypto.New(secretKey)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	encrypted, err := crypto.Encrypt(hashStr)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return encrypted, nil
+}
+
+// ParseTransferHash extracts the details encrypted in the hash string.
+func ParseTransferHash(ctx context.Context, encrypted, secretKey string, now time.Time) (*TransferHash, error) {
+	crypto, err := symcrypto.New(secretKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hashStr, err := crypto.Decrypt(encrypted)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	hashPts := strings.Split(hashStr, "|")
+
+	var hash TransferHash
+	if len(hashPts) == 6 {
+		hash.FromUserID = hashPts[0]
+		hash.ToUserID = hashPts[1]
+		hash.AccountID = hashPts[2]
+		hash.CreatedAt, _ = strconv.Atoi(hashPts[3])
+		hash.ExpiresAt, _ = strconv.Atoi(hashPts[4])
+		hash.RequestIP = hashPts[5]
+	}
+
+	// Validate the hash.
+	err = webcontext.Validator().StructCtx(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(hash.ExpiresAt) < now.UTC().Unix() {
+		err = errors.WithMessage(ErrTransferExpired, "Transfer has expired.")
+		return nil, err
+	}
+
+	return &hash, nil
+}
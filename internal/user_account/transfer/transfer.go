@@ -0,0 +1,211 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+var (
+	// ErrTransferExpired occurs when the the transfer hash exceeds the expiration.
+	ErrTransferExpired = errors.New("Transfer expired")
+
+	// ErrForbidden occurs when the user requesting the transfer is not the owner of the account.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrUserNotActive occurs when the new owner is not an active user of the account.
+	ErrUserNotActive = errors.New("User not active")
+
+	// ErrTransferUsed occurs when the transfer hash has already been accepted.
+	ErrTransferUsed = errors.New("Transfer already used")
+)
+
+const (
+	// The database table for the transfer hashes that have been accepted.
+	transferTableName = "account_transfers"
+)
+
+// SendTransfer sends an email to a user of the account asking them to confirm they accept the ownership of the
+// account. Only the current owner can transfer the ownership.
+func (repo *Repository) SendTransfer(ctx context.Context, claims auth.Claims, req SendTransferRequest, now time.Time) (string, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_account.transfer.SendTransfer")
+	defer span.Finish()
+
+	v := webcontext.Validator()
+
+	// Validate the request.
+	err := v.StructCtx(ctx, req)
+	if err != nil {
+		return "", err
+	}
+
+	// Ensure the claims can modify the account specified in the request.
+	err = repo.Account.CanModifyAccount(ctx, claims, req.AccountID)
+	if err != nil {
+		return "", err
+	}
+
+	owner, err := repo.UserAccount.ReadOwner(ctx, auth.Claims{}, req.AccountID)
+	if err != nil {
+		return "", err
+	} else if owner.UserID != claims.Subject {
+		return "", errors.WithMessagef(ErrForbidden, "user %s is not the owner of account %s", claims.Subject, req.AccountID)
+	} else if owner.UserID == req.UserID {
+		return "", errors.WithMessagef(ErrUserNotActive, "user %s is already the owner of account %s", req.UserID, req.AccountID)
+	}
+
+	usrAcc, err := repo.UserAccount.Read(ctx, auth.Claims{}, user_account.UserAccountReadRequest{
+		UserID:    req.UserID,
+		AccountID: req.AccountID,
+	})
+	if err != nil {
+		if errors.Cause(err) == user_account.ErrNotFound {
+			err = errors.WithMessagef(ErrUserNotActive, "user %s not found for account %s", req.UserID, req.AccountID)
+		}
+		return "", err
+	} else if usrAcc.Status != user_account.UserAccountStatus_Active {
+		return "", errors.WithMessagef(ErrUserNotActive, "user %s is %s for account %s", req.UserID, usrAcc.Status, req.AccountID)
+	}
+
+	if req.TTL.Seconds() == 0 {
+		req.TTL = time.Hour * 24
+	}
+
+	fromUser, err := repo.User.ReadByID(ctx, auth.Claims{}, owner.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	toUser, err := repo.User.ReadByID(ctx, auth.Claims{}, req.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	account, err := repo.Account.ReadByID(ctx, claims, req.AccountID)
+	if err != nil {
+		return "", err
+	}
+
+	// Load the current IP makings the request.
+	var requestIp string
+	if vals, _ := webcontext.ContextValues(ctx); vals != nil {
+		requestIp = vals.RequestIP
+	}
+
+	hash, err := NewTransferHash(ctx, repo.secretKey, owner.UserID, req.UserID, req.AccountID, requestIp, req.TTL, now)
+	if err != nil {
+		return "", err
+	}
+
+	data := map[string]interface{}{
+		"FromUser": fromUser.Response(ctx),
+		"Account":  account.Response(ctx),
+		"Url":      repo.ConfirmUrl(hash),
+		"Hours":    req.TTL.Hours(),
+	}
+
+	subject := fmt.Sprintf("%s %s wants to transfer the ownership of %s to you", fromUser.FirstName, fromUser.LastName, account.Name)
+
+	err = repo.Notify.Send(ctx, toUser.Email, subject, "account_transfer_owner", data)
+	if err != nil {
+		err = errors.WithMessagef(err, "Send transfer to %s failed.", toUser.Email)
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// AcceptTransfer makes the user the owner of the account using the provided transfer hash. The transfer fails when
+// the ownership of the account changed since the transfer was sent or when the hash has already been accepted, the
+// use of the hash is recorded in the transaction that moves the ownership.
+func (repo *Repository) AcceptTransfer(ctx context.Context, req AcceptTransferRequest, now time.Time) (*user_account.UserAccount, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_account.transfer.AcceptTransfer")
+	defer span.Finish()
+
+	v := webcontext.Validator()
+
+	// Validate the request.
+	err := v.StructCtx(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := ParseTransferHash(ctx, req.TransferHash, repo.secretKey, now)
+	if err != nil {
+		return nil, err
+	}
+
+	err = repo.UserAccount.TransferOwnerTx(ctx, auth.Claims{}, user_account.UserAccountTransferOwnerRequest{
+		AccountID:  hash.AccountID,
+		FromUserID: hash.FromUserID,
+		ToUserID:   hash.ToUserID,
+	}, now, func(tx *sqlx.Tx) error {
+		return useTransfer(ctx, tx, req.TransferHash, hash, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.UserAccount.Read(ctx, auth.Claims{}, user_account.UserAccountReadRequest{
+		UserID:    hash.ToUserID,
+		AccountID: hash.AccountID,
+	})
+}
+
+// useTransfer records the transfer hash as accepted. Only a digest of the hash is stored and the rows are removed once
+// the hash has expired since expired hashes are rejected anyway.
+func useTransfer(ctx context.Context, tx *sqlx.Tx, transferHash string, hash *TransferHash, now time.Time) error {
+	h := sha256.Sum256([]byte(transferHash))
+	id := hex.EncodeToString(h[:])
+
+	now = now.UTC()
+
+	// Remove the transfers that have expired.
+	{
+		query := sqlbuilder.NewDeleteBuilder()
+		query.DeleteFrom(transferTableName)
+		query.Where(query.LessThan("expires_at", now))
+
+		sql, args := query.Build()
+		sql = tx.Rebind(sql)
+		_, err := tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessage(err, "delete expired transfers failed")
+			return err
+		}
+	}
+
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(transferTableName)
+	query.Cols("id", "account_id", "from_user_id", "to_user_id", "expires_at", "created_at")
+	query.Values(id, hash.AccountID, hash.FromUserID, hash.ToUserID, time.Unix(int64(hash.ExpiresAt), 0).UTC(), now)
+
+	sql, args := query.Build()
+	sql = tx.Rebind(sql + " ON CONFLICT (id) DO NOTHING")
+	res, err := tx.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "record transfer for account %s failed", hash.AccountID)
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return errors.WithStack(err)
+	} else if n == 0 {
+		return errors.WithMessagef(ErrTransferUsed, "transfer for account %s has already been accepted", hash.AccountID)
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package transfer
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/notify"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	test *tests.Test
+	repo *Repository
+)
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+
+	userRepo := user.MockRepository(test.MasterDB)
+	userAccRepo := user_account.NewRepository(test.MasterDB)
+	accRepo := account.NewRepository(test.MasterDB)
+
+	// Mock the methods needed to send a transfer.
+	confirmUrl := func(string) string {
+		return ""
+	}
+	notify := &notify.MockEmail{}
+	secretKey := "6368616e676520746869732070613434"
+
+	repo = NewRepository(test.MasterDB, userRepo, userAccRepo, accRepo, confirmUrl, notify, secretKey)
+
+	return m.Run()
+}
+
+// mockClaims returns the claims for the user of the account with the provided roles.
+func mockClaims(userID, accountID string, roles []user_account.UserAccountRole, now time.Time) auth.Claims {
+	claims := auth.Claims{
+		AccountIDs: []string{accountID},
+		StandardClaims: jwt.StandardClaims{
+			Subject:   userID,
+			Audience:  accountID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Hour).Unix(),
+		},
+	}
+	for _, r := range roles {
+		claims.Roles = append(claims.Roles, r.String())
+	}
+	return claims
+}
+
+// TestTransfer validates that the owner of an account can transfer the ownership to another user.
+func TestTransfer(t *testing.T) {
+
+	t.Log("Given the need ensure the owner can transfer the ownership of their account.")
+	{
+		ctx := tests.Context()
+
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		a, err := repo.Account.Create(ctx, auth.Claims{}, account.AccountCreateRequest{
+			Name:     uuid.NewRandom().String(),
+			Address1: "101 E Main",
+			City:     "Valdez",
+			Region:   "AK",
+			Country:  "US",
+			Zipcode:  "99686",
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate account failed.", tests.Failed)
+		}
+
+		// Create the owner and an admin of the account.
+		ownerRoles := []user_account.UserAccountRole{user_account.UserAccountRole_Owner, user_account.UserAccountRole_Admin}
+		adminRoles := []user_account.UserAccountRole{user_account.UserAccountRole_Admin}
+
+		var userIDs []string
+		for _, roles := range [][]user_account.UserAccountRole{ownerRoles, adminRoles} {
+			initPass := uuid.NewRandom().String()
+			u, err := repo.User.Create(ctx, auth.Claims{}, user.UserCreateRequest{
+				FirstName:       "Lee",
+				LastName:        "Brown",
+				Email:           uuid.NewRandom().String() + "@geeksinthewoods.com",
+				Password:        initPass,
+				PasswordConfirm: initPass,
+			}, now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tCreate user failed.", tests.Failed)
+			}
+
+			_, err = repo.UserAccount.Create(ctx, auth.Claims{}, user_account.UserAccountCreateRequest{
+				UserID:    u.ID,
+				AccountID: a.ID,
+				Roles:     roles,
+			}, now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tCreate user account failed.", tests.Failed)
+			}
+
+			userIDs = append(userIDs, u.ID)
+		}
+		ownerID, adminID := userIDs[0], userIDs[1]
+
+		ownerClaims := mockClaims(ownerID, a.ID, ownerRoles, now)
+		adminClaims := mockClaims(adminID, a.ID, adminRoles, now)
+
+		// Ensure validation is working by trying SendTransfer with an empty request.
+		{
+			expectedErr := errors.New("Key: 'SendTransferRequest.account_id' Error:Field validation for 'account_id' failed on the 'required' tag\n" +
+				"Key: 'SendTransferRequest.user_id' Error:Field validation for 'user_id' failed on the 'required' tag")
+			_, err = repo.SendTransfer(ctx, ownerClaims, SendTransferRequest{}, now)
+			if err == nil {
+				t.Logf("\t\tWant: %+v", expectedErr)
+				t.Fatalf("\t%s\tSendTransfer failed.", tests.Failed)
+			}
+
+			errStr := strings.Replace(err.Error(), "{{", "", -1)
+			errStr = strings.Replace(errStr, "}}", "", -1)
+
+			if errStr != expectedErr.Error() {
+				t.Logf("\t\tGot : %+v", errStr)
+				t.Logf("\t\tWant: %+v", expectedErr)
+				t.Fatalf("\t%s\tSendTransfer Validation failed.", tests.Failed)
+			}
+			t.Logf("\t%s\tSendTransfer Validation ok.", tests.Success)
+		}
+
+		// Ensure only the owner can transfer the ownership.
+		{
+			_, err = repo.SendTransfer(ctx, adminClaims, SendTransferRequest{
+				AccountID: a.ID,
+				UserID:    adminID,
+			}, now)
+			if errors.Cause(err) != ErrForbidden {
+				t.Logf("\t\tGot : %+v", errors.Cause(err))
+				t.Logf("\t\tWant: %+v", ErrForbidden)
+				t.Fatalf("\t%s\tSendTransfer by admin failed.", tests.Failed)
+			}
+			t.Logf("\t%s\tSendTransfer by admin forbidden ok.", tests.Success)
+		}
+
+		// Ensure the ownership can only be transferred to another active user of the account.
+		for _, userID := range []string{ownerID, uuid.NewRandom().String()} {
+			_, err = repo.SendTransfer(ctx, ownerClaims, SendTransferRequest{
+				AccountID: a.ID,
+				UserID:    userID,
+			}, now)
+			if errors.Cause(err) != ErrUserNotActive {
+				t.Logf("\t\tGot : %+v", errors.Cause(err))
+				t.Logf("\t\tWant: %+v", ErrUserNotActive)
+				t.Fatalf("\t%s\tSendTransfer to %s failed.", tests.Failed, userID)
+			}
+		}
+		t.Logf("\t%s\tSendTransfer to inactive user ok.", tests.Success)
+
+		ttl := time.Hour
+
+		transferHash, err := repo.SendTransfer(ctx, ownerClaims, SendTransferRequest{
+			AccountID: a.ID,
+			UserID:    adminID,
+			TTL:       ttl,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSendTransfer failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tSendTransfer ok.", tests.Success)
+
+		// Ensure the TTL is enforced.
+		{
+			_, err = repo.AcceptTransfer(ctx, AcceptTransferRequest{
+				TransferHash: transferHash,
+			}, now.UTC().Add(ttl*2))
+			if errors.Cause(err) != ErrTransferExpired {
+				t.Logf("\t\tGot : %+v", errors.Cause(err))
+				t.Logf("\t\tWant: %+v", ErrTransferExpired)
+				t.Fatalf("\t%s\tAcceptTransfer enforce TTL failed.", tests.Failed)
+			}
+			t.Logf("\t%s\tAcceptTransfer enforce TTL ok.", tests.Success)
+		}
+
+		// Assuming we have received the email and clicked the link, we now can ensure accept works.
+		{
+			usrAcc, err := repo.AcceptTransfer(ctx, AcceptTransferRequest{
+				TransferHash: transferHash,
+			}, now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tAcceptTransfer failed.", tests.Failed)
+			} else if usrAcc.UserID != adminID || !usrAcc.HasRole(user_account.UserAccountRole_Owner) {
+				t.Logf("\t\tGot : %+v", usrAcc)
+				t.Fatalf("\t%s\tAcceptTransfer failed.", tests.Failed)
+			}
+
+			owner, err := repo.UserAccount.ReadOwner(ctx, auth.Claims{}, a.ID)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tReadOwner failed.", tests.Failed)
+			} else if owner.UserID != adminID {
+				t.Logf("\t\tGot : %+v", owner.UserID)
+				t.Logf("\t\tWant: %+v", adminID)
+				t.Fatalf("\t%s\tAcceptTransfer failed.", tests.Failed)
+			}
+			t.Logf("\t%s\tAcceptTransfer ok.", tests.Success)
+		}
+
+		// Ensure the transfer hash does not work after its used.
+		{
+			_, err = repo.AcceptTransfer(ctx, AcceptTransferRequest{
+				TransferHash: transferHash,
+			}, now)
+			if errors.Cause(err) != user_account.ErrOwnerRole {
+				t.Logf("\t\tGot : %+v", errors.Cause(err))
+				t.Logf("\t\tWant: %+v", user_account.ErrOwnerRole)
+				t.Fatalf("\t%s\tAcceptTransfer verify reuse failed.", tests.Failed)
+			}
+			t.Logf("\t%s\tAcceptTransfer verify reuse disabled ok.", tests.Success)
+		}
+
+		// Ensure the transfer hash does not work after the ownership was transferred back.
+		{
+			backHash, err := repo.SendTransfer(ctx, adminClaims, SendTransferRequest{
+				AccountID: a.ID,
+				UserID:    ownerID,
+			}, now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tSendTransfer back failed.", tests.Failed)
+			}
+
+			_, err = repo.AcceptTransfer(ctx, AcceptTransferRequest{
+				TransferHash: backHash,
+			}, now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tAcceptTransfer back failed.", tests.Failed)
+			}
+
+			_, err = repo.AcceptTransfer(ctx, AcceptTransferRequest{
+				TransferHash: transferHash,
+			}, now)
+			if errors.Cause(err) != ErrTransferUsed {
+				t.Logf("\t\tGot : %+v", errors.Cause(err))
+				t.Logf("\t\tWant: %+v", ErrTransferUsed)
+				t.Fatalf("\t%s\tAcceptTransfer verify replay failed.", tests.Failed)
+			}
+
+			owner, err := repo.UserAccount.ReadOwner(ctx, auth.Claims{}, a.ID)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tReadOwner failed.", tests.Failed)
+			} else if owner.UserID != ownerID {
+				t.Logf("\t\tGot : %+v", owner.UserID)
+				t.Logf("\t\tWant: %+v", ownerID)
+				t.Fatalf("\t%s\tAcceptTransfer verify replay failed.", tests.Failed)
+			}
+			t.Logf("\t%s\tAcceptTransfer verify replay disabled ok.", tests.Success)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package user_account
 
 import (
 	"context"
+	"fmt"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
@@ -60,47 +61,11 @@ func (repo *Repository) UserFindByAccount(ctx context.Context, claims auth.Claim
 
 	*/
 
-	subQuery := sqlbuilder.NewSelectBuilder().
-		Select("u.id,u.first_name,u.last_name,concat(u.first_name, ' ',u.last_name) as name,u.email,u.timezone,ua.account_id,ua.status,ua.roles,"+
-			"CASE WHEN ua.created_at > u.created_at THEN ua.created_at ELSE u.created_at END AS created_at,"+
-			"CASE WHEN ua.updated_at > u.updated_at THEN ua.updated_at ELSE u.updated_at END AS updated_at,"+
-			"CASE WHEN ua.archived_at > u.archived_at THEN ua.archived_at ELSE u.archived_at END AS archived_at").
-		From(userTableName+" u").
-		Join(userAccountTableName+" ua", "u.id = ua.user_id", "ua.account_id = '"+req.AccountID+"'")
-
-	if !req.IncludeArchived {
-		subQuery.Where(subQuery.And(
-			subQuery.IsNull("u.archived_at"),
-			subQuery.IsNull("ua.archived_at")))
-	}
-
-	if claims.Audience != "" || claims.Subject != "" {
-		// Build select statement for users_accounts table
-		authQuery := sqlbuilder.NewSelectBuilder().Select("account_id").From(userAccountTableName)
-
-		var or []string
-		if claims.Audience != "" {
-			or = append(or, authQuery.Equal("account_id", claims.Audience))
-		}
-		if claims.Subject != "" {
-			or = append(or, authQuery.Equal("user_id", claims.Subject))
-		}
-
-		// Append sub query
-		if len(or) > 0 {
-			authQuery.Where(authQuery.Or(or...))
-			subQuery.Where(subQuery.In("account_id", authQuery))
-		}
-	}
-
-	subQueryStr, queryArgs := subQuery.Build()
-
-	query := sqlbuilder.NewSelectBuilder().
-		Select("id,first_name,last_name,name,email,timezone,account_id,status,roles,created_at,updated_at,archived_at").
-		From("(" + subQueryStr + ") res")
-	if req.Where != "" {
-		query.Where(query.And(req.Where))
+	query, queryArgs, err := userFindByAccountQuery(claims, req)
+	if err != nil {
+		return nil, err
 	}
+	query.Select("id,first_name,last_name,name,email,timezone,account_id,status,roles,created_at,updated_at,archived_at")
 	if len(req.Order) > 0 {
 		query.OrderBy(req.Order...)
 	}
@@ -144,3 +109,97 @@ func (repo *Repository) UserFindByAccount(ctx context.Context, claims auth.Claim
 
 	return resp, nil
 }
+
+// UserCountByAccount gets the total number of users for a given account ID that match the request params. The
+// order, limit and offset of the request are ignored.
+func (repo *Repository) UserCountByAccount(ctx context.Context, claims auth.Claims, req UserFindByAccountRequest) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_account.UserCountByAccount")
+	defer span.Finish()
+
+	v := webcontext.Validator()
+
+	// Validate the request.
+	err := v.StructCtx(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	query, queryArgs, err := userFindByAccountQuery(claims, req)
+	if err != nil {
+		return 0, err
+	}
+	query.Select("count(*)")
+
+	queryStr, moreQueryArgs := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	queryArgs = append(queryArgs, moreQueryArgs...)
+
+	var total int
+	err = repo.DbConn.QueryRowContext(ctx, queryStr, queryArgs...).Scan(&total)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "count users failed")
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// userFindByAccountQuery returns the query for the users of the account with the where and filter of the request
+// applied and the args for the placeholders.
+func userFindByAccountQuery(claims auth.Claims, req UserFindByAccountRequest) (*sqlbuilder.SelectBuilder, []interface{}, error) {
+	subQuery := sqlbuilder.NewSelectBuilder().
+		Select("u.id,u.first_name,u.last_name,concat(u.first_name, ' ',u.last_name) as name,u.email,u.timezone,ua.account_id,ua.status,ua.roles,"+
+			"CASE WHEN ua.created_at > u.created_at THEN ua.created_at ELSE u.created_at END AS created_at,"+
+			"CASE WHEN ua.updated_at > u.updated_at THEN ua.updated_at ELSE u.updated_at END AS updated_at,"+
+			"CASE WHEN ua.archived_at > u.archived_at THEN ua.archived_at ELSE u.archived_at END AS archived_at").
+		From(userTableName+" u").
+		Join(userAccountTableName+" ua", "u.id = ua.user_id", "ua.account_id = '"+req.AccountID+"'")
+
+	if !req.IncludeArchived {
+		subQuery.Where(subQuery.And(
+			subQuery.IsNull("u.archived_at"),
+			subQuery.IsNull("ua.archived_at")))
+	}
+
+	if req.Role != "" {
+		subQuery.Where(fmt.Sprintf("%s = ANY (ua.roles)", subQuery.Var(req.Role)))
+	}
+
+	if claims.Audience != "" || claims.Subject != "" {
+		// Build select statement for users_accounts table
+		authQuery := sqlbuilder.NewSelectBuilder().Select("account_id").From(userAccountTableName)
+
+		var or []string
+		if claims.Audience != "" {
+			or = append(or, authQuery.Equal("account_id", claims.Audience))
+		}
+		if claims.Subject != "" {
+			or = append(or, authQuery.Equal("user_id", claims.Subject))
+		}
+
+		// Append sub query
+		if len(or) > 0 {
+			authQuery.Where(authQuery.Or(or...))
+			subQuery.Where(subQuery.In("account_id", authQuery))
+		}
+	}
+
+	subQueryStr, queryArgs := subQuery.Build()
+
+	// The args of the condition are placed after the args of the sub query to match the order of the placeholders. The
+	// args of the filter are returned when the query is built.
+	query := sqlbuilder.NewSelectBuilder().From("(" + subQueryStr + ") res")
+	if req.condition != "" {
+		query.Where(req.condition)
+		queryArgs = append(queryArgs, req.conditionArgs...)
+	}
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, UserFindByAccountFields); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return query, queryArgs, nil
+}
@@ -6,9 +6,13 @@ import (
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/account"
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_role"
+	"geeks-accelerator/oss/saas-starter-kit/internal/audit"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tenant"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+	"geeks-accelerator/oss/saas-starter-kit/internal/webhook"
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/jmoiron/sqlx"
 	"github.com/pborman/uuid"
@@ -22,6 +26,15 @@ var (
 
 	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
 	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrInvalidRole occurs when a role is assigned that is not a built-in role or defined for the account.
+	ErrInvalidRole = errors.New("Invalid role")
+
+	// ErrLastAdmin occurs when a change would leave an account without an active admin.
+	ErrLastAdmin = errors.New("Account requires at least one active admin")
+
+	// ErrOwnerRole occurs when the owner role is assigned or removed without transferring the ownership of the account.
+	ErrOwnerRole = errors.New("Owner role can only be changed by transferring ownership")
 )
 
 // The database table for UserAccount
@@ -30,6 +43,9 @@ const userAccountTableName = "users_accounts"
 // The database table for User
 const userTableName = "users"
 
+// The database table for Account
+const accountTableName = "accounts"
+
 // The list of columns needed for mapRowsToUserAccount
 var userAccountMapColumns = "user_id,account_id,roles,status,created_at,updated_at,archived_at"
 
@@ -71,6 +87,163 @@ func mapAccountError(err error) error {
 	return err
 }
 
+// validateRoles ensures the roles are either built-in roles or custom roles defined for the account.
+func (repo *Repository) validateRoles(ctx context.Context, accountID string, roles UserAccountRoles) error {
+	var names []string
+	for _, r := range roles {
+		names = append(names, r.String())
+	}
+
+	err := account_role.ValidateRoles(ctx, repo.DbConn, accountID, names)
+	if errors.Cause(err) == account_role.ErrInvalidRole {
+		err = errors.WithMessage(ErrInvalidRole, err.Error())
+	}
+	return err
+}
+
+// isActiveAdmin determines if the user account grants admin access to the account.
+func isActiveAdmin(ua *UserAccount) bool {
+	if ua == nil || ua.Status != UserAccountStatus_Active || (ua.ArchivedAt != nil && ua.ArchivedAt.Valid) {
+		return false
+	}
+	return ua.HasRole(UserAccountRole_Owner) || ua.HasRole(UserAccountRole_Admin)
+}
+
+// changeMembership executes fn to write a change to a user account in a transaction that locks the account. The
+// change is checked with checkMembershipChange in the same transaction so concurrent changes to the users of the
+// account are serialized and can't remove the owner or the last active admin.
+func (repo *Repository) changeMembership(ctx context.Context, claims auth.Claims, before, after *UserAccount, fn func(tx *sqlx.Tx) error) error {
+	var accountID string
+	if before != nil {
+		accountID = before.AccountID
+	} else if after != nil {
+		accountID = after.AccountID
+	}
+
+	tx, err := repo.beginTx(ctx, claims)
+	if err != nil {
+		return err
+	}
+
+	err = lockAccount(ctx, tx, accountID)
+	if err == nil {
+		err = checkMembershipChange(ctx, tx, before, after)
+	}
+	if err == nil {
+		err = fn(tx)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+// lockAccount locks the row of the account until the transaction completes.
+func lockAccount(ctx context.Context, tx *sqlx.Tx, accountID string) error {
+	query := sqlbuilder.NewSelectBuilder().Select("id").From(accountTableName)
+	query.Where(query.Equal("id", accountID))
+	queryStr, args := query.Build()
+	queryStr = tx.Rebind(queryStr + " FOR UPDATE")
+
+	var id string
+	err := tx.QueryRowContext(ctx, queryStr, args...).Scan(&id)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s FOR UPDATE", query.String())
+		err = errors.WithMessagef(err, "lock account %s failed", accountID)
+		return err
+	}
+
+	return nil
+}
+
+// checkMembershipChange ensures a change to a user account keeps the owner of the account and at least one active
+// admin. The before value is nil when the user account is created and the after value is nil when it is removed. The
+// account must be locked by the transaction.
+func checkMembershipChange(ctx context.Context, tx *sqlx.Tx, before, after *UserAccount) error {
+	if before.HasRole(UserAccountRole_Owner) {
+		// The owner must remain an active admin until the ownership is transferred.
+		if !isActiveAdmin(after) || !after.HasRole(UserAccountRole_Owner) || !after.HasRole(UserAccountRole_Admin) {
+			return errors.WithMessagef(ErrOwnerRole, "user %s is the owner of account %s", before.UserID, before.AccountID)
+		}
+		return nil
+	} else if after.HasRole(UserAccountRole_Owner) {
+		// The owner role can only be assigned to an admin when the account doesn't have an owner, ie on signup.
+		if !isActiveAdmin(after) || !after.HasRole(UserAccountRole_Admin) {
+			return errors.WithMessagef(ErrOwnerRole, "owner of account %s must be an active admin", after.AccountID)
+		}
+
+		query := sqlbuilder.NewSelectBuilder().Select("count(*)").From(userAccountTableName)
+		query.Where(query.And(
+			query.Equal("account_id", after.AccountID),
+			query.IsNull("archived_at"),
+			"'"+UserAccountRole_Owner.String()+"' = ANY (roles)",
+		))
+		queryStr, args := query.Build()
+		queryStr = tx.Rebind(queryStr)
+
+		var owners int
+		err := tx.QueryRowContext(ctx, queryStr, args...).Scan(&owners)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "count owners for account %s failed", after.AccountID)
+			return err
+		} else if owners > 0 {
+			return errors.WithMessagef(ErrOwnerRole, "account %s already has an owner", after.AccountID)
+		}
+	}
+
+	if !isActiveAdmin(before) || isActiveAdmin(after) {
+		return nil
+	}
+
+	// Count the other active admins of the account.
+	query := sqlbuilder.NewSelectBuilder().Select("count(*)").From(userAccountTableName)
+	query.Where(query.And(
+		query.Equal("account_id", before.AccountID),
+		query.NotEqual("user_id", before.UserID),
+		query.Equal("status", UserAccountStatus_Active.String()),
+		query.IsNull("archived_at"),
+		"('"+UserAccountRole_Owner.String()+"' = ANY (roles) OR '"+UserAccountRole_Admin.String()+"' = ANY (roles))",
+	))
+	queryStr, args := query.Build()
+	queryStr = tx.Rebind(queryStr)
+
+	var admins int
+	err := tx.QueryRowContext(ctx, queryStr, args...).Scan(&admins)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "count admins for account %s failed", before.AccountID)
+		return err
+	}
+
+	if admins == 0 {
+		return errors.WithMessagef(ErrLastAdmin, "user %s is the last active admin of account %s", before.UserID, before.AccountID)
+	}
+
+	return nil
+}
+
+// conn executes fn with the database connection. When row level security is enabled, fn is executed with a
+// transaction scoped to the account and user of the claims.
+func (repo *Repository) conn(ctx context.Context, claims auth.Claims, fn func(conn sqlx.ExtContext) error) error {
+	if !repo.RowLevelSecurity {
+		return fn(repo.DbConn)
+	}
+	return tenant.Do(ctx, repo.DbConn, claims, fn)
+}
+
+// beginTx starts a new transaction. When row level security is enabled, the transaction is scoped to the account and
+// user of the claims.
+func (repo *Repository) beginTx(ctx context.Context, claims auth.Claims) (*sqlx.Tx, error) {
+	if !repo.RowLevelSecurity || !tenant.IsScoped(claims) {
+		tx, err := repo.DbConn.BeginTxx(ctx, nil)
+		return tx, errors.WithStack(err)
+	}
+	return tenant.BeginTx(ctx, repo.DbConn, claims)
+}
+
 // applyClaimsSelect applies a sub-query to the provided query
 // to enforce ACL based on the claims provided.
 // 	1. All role types can access their user ID
@@ -110,12 +283,17 @@ func selectQuery() *sqlbuilder.SelectBuilder {
 }
 
 // findRequestQuery generates the select query for the given find request.
-// TODO: Need to figure out why can't parse the args when appending the where
-// 			to the query.
-func findRequestQuery(req UserAccountFindRequest) (*sqlbuilder.SelectBuilder, []interface{}) {
+func findRequestQuery(req UserAccountFindRequest) (*sqlbuilder.SelectBuilder, error) {
 	query := selectQuery()
-	if req.Where != "" {
-		query.Where(query.And(req.Where))
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, UserAccountFindFields); err != nil {
+			return nil, err
+		}
+	}
+	if req.Cursor != "" {
+		if err := UserAccountFindFields.ApplyCursor(query, req.Order, req.Cursor); err != nil {
+			return nil, err
+		}
 	}
 	if len(req.Order) > 0 {
 		query.OrderBy(req.Order...)
@@ -127,17 +305,72 @@ func findRequestQuery(req UserAccountFindRequest) (*sqlbuilder.SelectBuilder, []
 		query.Offset(int(*req.Offset))
 	}
 
-	return query, req.Args
+	return query, nil
 }
 
 // Find gets all the user accounts from the database based on the request params.
 func (repo *Repository) Find(ctx context.Context, claims auth.Claims, req UserAccountFindRequest) (UserAccounts, error) {
-	query, args := findRequestQuery(req)
-	return find(ctx, claims, repo.DbConn, query, args, req.IncludeArchived)
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.find(ctx, claims, query, []interface{}{}, req.IncludeArchived)
+}
+
+// Count gets the total number of user accounts from the database that match the request params. The order, limit,
+// offset and cursor of the request are ignored.
+func (repo *Repository) Count(ctx context.Context, claims auth.Claims, req UserAccountFindRequest) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_account.Count")
+	defer span.Finish()
+
+	req.Order, req.Limit, req.Offset, req.Cursor = nil, nil, nil, ""
+
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return 0, err
+	}
+	query.Select("count(*)")
+
+	if !req.IncludeArchived {
+		query.Where(query.IsNull("archived_at"))
+	}
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err = applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return 0, err
+	}
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var total int
+	err = repo.conn(ctx, claims, func(conn sqlx.ExtContext) error {
+		return conn.QueryRowxContext(ctx, queryStr, args...).Scan(&total)
+	})
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "count user accounts failed")
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// find executes find with a transaction scoped to the claims when row level security is enabled.
+func (repo *Repository) find(ctx context.Context, claims auth.Claims, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) (UserAccounts, error) {
+	var res UserAccounts
+	err := repo.conn(ctx, claims, func(conn sqlx.ExtContext) error {
+		var err error
+		res, err = find(ctx, claims, conn, query, args, includedArchived)
+		return err
+	})
+	return res, err
 }
 
 // Find gets all the user accounts from the database based on the select query
-func find(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) (UserAccounts, error) {
+func find(ctx context.Context, claims auth.Claims, dbConn sqlx.ExtContext, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) (UserAccounts, error) {
 	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_account.Find")
 	defer span.Finish()
 
@@ -164,6 +397,7 @@ func find(ctx context.Context, claims auth.Claims, dbConn *sqlx.DB, query *sqlbu
 		err = errors.WithMessage(err, "find user accounts failed")
 		return nil, err
 	}
+	defer rows.Close()
 
 	// iterate over each row
 	resp := []*UserAccount{}
@@ -190,7 +424,7 @@ func (repo *Repository) FindByUserID(ctx context.Context, claims auth.Claims, us
 	query.OrderBy("created_at")
 
 	// Execute the find accounts method.
-	res, err := find(ctx, claims, repo.DbConn, query, []interface{}{}, includedArchived)
+	res, err := repo.find(ctx, claims, query, []interface{}{}, includedArchived)
 	if err != nil {
 		return nil, err
 	} else if res == nil || len(res) == 0 {
@@ -219,6 +453,12 @@ func (repo *Repository) Create(ctx context.Context, claims auth.Claims, req User
 		return nil, err
 	}
 
+	// Ensure the roles are defined for the account.
+	err = repo.validateRoles(ctx, req.AccountID, req.Roles)
+	if err != nil {
+		return nil, err
+	}
+
 	// If now empty set it to the current time.
 	if now.IsZero() {
 		now = time.Now()
@@ -277,19 +517,42 @@ func (repo *Repository) Create(ctx context.Context, claims auth.Claims, req User
 			ua.Status = *req.Status
 		}
 
-		// Build the insert SQL statement.
-		query := sqlbuilder.NewInsertBuilder()
-		query.InsertInto(userAccountTableName)
-		query.Cols("id", "user_id", "account_id", "roles", "status", "created_at", "updated_at")
-		query.Values(uaID, ua.UserID, ua.AccountID, ua.Roles, ua.Status.String(), ua.CreatedAt, ua.UpdatedAt)
+		// Ensure the owner role is only assigned when the account doesn't have an owner.
+		err = repo.changeMembership(ctx, claims, nil, &ua, func(tx *sqlx.Tx) error {
+			// Build the insert SQL statement.
+			query := sqlbuilder.NewInsertBuilder()
+			query.InsertInto(userAccountTableName)
+			query.Cols("id", "user_id", "account_id", "roles", "status", "created_at", "updated_at")
+			query.Values(uaID, ua.UserID, ua.AccountID, ua.Roles, ua.Status.String(), ua.CreatedAt, ua.UpdatedAt)
+
+			// Execute the query with the provided context.
+			sql, args := query.Build()
+			sql = tx.Rebind(sql)
+			_, err := tx.ExecContext(ctx, sql, args...)
+			if err != nil {
+				err = errors.Wrapf(err, "query - %s", query.String())
+				err = errors.WithMessagef(err, "add account %s to user %s failed", req.AccountID, req.UserID)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
 
-		// Execute the query with the provided context.
-		sql, args := query.Build()
-		sql = repo.DbConn.Rebind(sql)
-		_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+		_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+			AccountID:  req.AccountID,
+			EntityType: audit.EntityType_UserAccount,
+			EntityID:   req.UserID,
+			Action:     audit.Action_Create,
+			After:      ua,
+		}, now)
+		if err != nil {
+			return nil, err
+		}
+
+		err = webhook.Publish(ctx, repo.DbConn, req.AccountID, webhook.EventType_UserAccountCreated, ua, now)
 		if err != nil {
-			err = errors.Wrapf(err, "query - %s", query.String())
-			err = errors.WithMessagef(err, "add account %s to user %s failed", req.AccountID, req.UserID)
 			return nil, err
 		}
 	}
@@ -315,7 +578,7 @@ func (repo *Repository) Read(ctx context.Context, claims auth.Claims, req UserAc
 		query.Equal("user_id", req.UserID),
 		query.Equal("account_id", req.AccountID)))
 
-	res, err := find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
+	res, err := repo.find(ctx, claims, query, []interface{}{}, req.IncludeArchived)
 	if err != nil {
 		return nil, err
 	} else if res == nil || len(res) == 0 {
@@ -327,6 +590,197 @@ func (repo *Repository) Read(ctx context.Context, claims auth.Claims, req UserAc
 	return u, nil
 }
 
+// ReadOwner gets the user account of the owner of the account.
+func (repo *Repository) ReadOwner(ctx context.Context, claims auth.Claims, accountID string) (*UserAccount, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_account.ReadOwner")
+	defer span.Finish()
+
+	// Filter base select query by account ID and the owner role.
+	query := selectQuery()
+	query.Where(query.And(
+		query.Equal("account_id", accountID),
+		"'"+UserAccountRole_Owner.String()+"' = ANY (roles)"))
+
+	res, err := repo.find(ctx, claims, query, []interface{}{}, false)
+	if err != nil {
+		return nil, err
+	} else if res == nil || len(res) == 0 {
+		err = errors.WithMessagef(ErrNotFound, "owner for account %s not found", accountID)
+		return nil, err
+	}
+
+	return res[0], nil
+}
+
+// TransferOwner moves the owner role from the current owner of the account to another active user of the account.
+// The new owner is also assigned the admin role, the previous owner keeps their other roles.
+func (repo *Repository) TransferOwner(ctx context.Context, claims auth.Claims, req UserAccountTransferOwnerRequest, now time.Time) error {
+	return repo.TransferOwnerTx(ctx, claims, req, now, nil)
+}
+
+// TransferOwnerTx moves the owner role like TransferOwner and executes fn in the same transaction, so the transfer is
+// rolled back when fn fails. fn can be nil.
+func (repo *Repository) TransferOwnerTx(ctx context.Context, claims auth.Claims, req UserAccountTransferOwnerRequest, now time.Time, fn func(tx *sqlx.Tx) error) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_account.TransferOwner")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can modify the account specified in the request.
+	err = repo.CanModifyAccount(ctx, claims, req.AccountID)
+	if err != nil {
+		return err
+	}
+
+	from, err := repo.Read(ctx, auth.Claims{}, UserAccountReadRequest{UserID: req.FromUserID, AccountID: req.AccountID})
+	if err != nil {
+		return err
+	} else if !from.HasRole(UserAccountRole_Owner) {
+		return errors.WithMessagef(ErrOwnerRole, "user %s is not the owner of account %s", req.FromUserID, req.AccountID)
+	}
+
+	to, err := repo.Read(ctx, auth.Claims{}, UserAccountReadRequest{UserID: req.ToUserID, AccountID: req.AccountID})
+	if err != nil {
+		return err
+	} else if to.Status != UserAccountStatus_Active {
+		return errors.WithMessagef(ErrOwnerRole, "user %s is not active for account %s", req.ToUserID, req.AccountID)
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	fromRoles := UserAccountRoles{}
+	for _, r := range from.Roles {
+		if r != UserAccountRole_Owner {
+			fromRoles = append(fromRoles, r)
+		}
+	}
+
+	toRoles := UserAccountRoles{UserAccountRole_Owner, UserAccountRole_Admin}
+	for _, r := range to.Roles {
+		if r != UserAccountRole_Owner && r != UserAccountRole_Admin {
+			toRoles = append(toRoles, r)
+		}
+	}
+
+	// Start a new transaction that locks the account so the account always has a single owner.
+	tx, err := repo.beginTx(ctx, claims)
+	if err != nil {
+		return err
+	}
+
+	err = lockAccount(ctx, tx, req.AccountID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, ch := range []struct {
+		userID string
+		roles  UserAccountRoles
+		where  string
+	}{
+		{req.FromUserID, fromRoles, "'" + UserAccountRole_Owner.String() + "' = ANY (roles)"},
+		{req.ToUserID, toRoles, "status = '" + UserAccountStatus_Active.String() + "'"},
+	} {
+		// Build the update SQL statement. The users are checked again since they could have changed since they were
+		// read before the account was locked.
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(userAccountTableName)
+		query.Set(
+			query.Assign("roles", ch.roles),
+			query.Assign("updated_at", now),
+		)
+		query.Where(query.And(
+			query.Equal("user_id", ch.userID),
+			query.Equal("account_id", req.AccountID),
+			query.IsNull("archived_at"),
+			ch.where,
+		))
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = tx.Rebind(sql)
+		res, err := tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			tx.Rollback()
+
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "update roles of account %s for user %s failed", req.AccountID, ch.userID)
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			tx.Rollback()
+			return errors.WithStack(err)
+		} else if n == 0 {
+			tx.Rollback()
+			return errors.WithMessagef(ErrOwnerRole, "user %s of account %s changed during the transfer", ch.userID, req.AccountID)
+		}
+	}
+
+	if fn != nil {
+		err = fn(tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Internal requests are confirmed by the new owner, ie with the link of a transfer email.
+	var actorUserID string
+	if claims.Subject == "" {
+		actorUserID = req.ToUserID
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:   req.AccountID,
+		ActorUserID: actorUserID,
+		EntityType:  audit.EntityType_UserAccount,
+		EntityID:    req.ToUserID,
+		Action:      audit.Action_TransferOwner,
+		Before:      map[string]interface{}{"owner_user_id": req.FromUserID},
+		After:       map[string]interface{}{"owner_user_id": req.ToUserID},
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range []string{req.FromUserID, req.ToUserID} {
+		// Load the updated user account so it can be included in the event.
+		after, err := repo.Read(ctx, auth.Claims{}, UserAccountReadRequest{UserID: userID, AccountID: req.AccountID})
+		if err != nil {
+			return err
+		}
+
+		err = webhook.Publish(ctx, repo.DbConn, req.AccountID, webhook.EventType_UserAccountUpdated, after, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Update replaces a user account in the database.
 func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req UserAccountUpdateRequest, now time.Time) error {
 	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_account.Update")
@@ -345,6 +799,43 @@ func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req User
 		return err
 	}
 
+	// Ensure the roles are defined for the account.
+	if req.Roles != nil {
+		err = repo.validateRoles(ctx, req.AccountID, *req.Roles)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Load the current user account so the change can be recorded.
+	before, err := repo.Read(ctx, auth.Claims{}, UserAccountReadRequest{
+		UserID:          req.UserID,
+		AccountID:       req.AccountID,
+		IncludeArchived: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Apply the changes to a copy of the current user account to ensure the account keeps an active admin.
+	updated := *before
+	if req.Roles != nil {
+		updated.Roles = *req.Roles
+
+		// The owner role is not included in the roles that can be assigned, keep it for the owner.
+		if before.HasRole(UserAccountRole_Owner) && !updated.HasRole(UserAccountRole_Owner) {
+			roles := append(UserAccountRoles{UserAccountRole_Owner}, updated.Roles...)
+			updated.Roles = roles
+			req.Roles = &roles
+		}
+	}
+	if req.Status != nil {
+		updated.Status = *req.Status
+	}
+	if req.unArchive {
+		updated.ArchivedAt = nil
+	}
+
 	// If now empty set it to the current time.
 	if now.IsZero() {
 		now = time.Now()
@@ -387,13 +878,43 @@ func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req User
 		query.Equal("account_id", req.AccountID),
 	))
 
-	// Execute the query with the provided context.
-	sql, args := query.Build()
-	sql = repo.DbConn.Rebind(sql)
-	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	// Ensure the account keeps its owner and an active admin.
+	err = repo.changeMembership(ctx, claims, before, &updated, func(tx *sqlx.Tx) error {
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = tx.Rebind(sql)
+		_, err := tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "update account %s for user %s failed", req.AccountID, req.UserID)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  req.AccountID,
+		EntityType: audit.EntityType_UserAccount,
+		EntityID:   req.UserID,
+		Action:     audit.Action_Update,
+		Before:     before,
+		After:      req,
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	// Load the updated user account so it can be included in the event.
+	after, err := repo.Read(ctx, auth.Claims{}, UserAccountReadRequest{UserID: req.UserID, AccountID: req.AccountID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
+	err = webhook.Publish(ctx, repo.DbConn, req.AccountID, webhook.EventType_UserAccountUpdated, after, now)
 	if err != nil {
-		err = errors.Wrapf(err, "query - %s", query.String())
-		err = errors.WithMessagef(err, "update account %s for user %s failed", req.AccountID, req.UserID)
 		return err
 	}
 
@@ -418,6 +939,16 @@ func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req Use
 		return err
 	}
 
+	// Load the current user account so the change can be recorded.
+	before, err := repo.Read(ctx, auth.Claims{}, UserAccountReadRequest{
+		UserID:          req.UserID,
+		AccountID:       req.AccountID,
+		IncludeArchived: true,
+	})
+	if err != nil {
+		return err
+	}
+
 	// If now empty set it to the current time.
 	if now.IsZero() {
 		now = time.Now()
@@ -439,13 +970,43 @@ func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req Use
 		query.Equal("account_id", req.AccountID),
 	))
 
-	// Execute the query with the provided context.
-	sql, args := query.Build()
-	sql = repo.DbConn.Rebind(sql)
-	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	// Ensure the account keeps an active admin.
+	err = repo.changeMembership(ctx, claims, before, nil, func(tx *sqlx.Tx) error {
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = tx.Rebind(sql)
+		_, err := tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "archive account %s from user %s failed", req.AccountID, req.UserID)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  req.AccountID,
+		EntityType: audit.EntityType_UserAccount,
+		EntityID:   req.UserID,
+		Action:     audit.Action_Archive,
+		Before:     before,
+		After:      map[string]interface{}{"archived_at": now},
+	}, now)
+	if err != nil {
+		return err
+	}
+
+	// Load the archived user account so it can be included in the event.
+	after, err := repo.Read(ctx, auth.Claims{}, UserAccountReadRequest{UserID: req.UserID, AccountID: req.AccountID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
+	err = webhook.Publish(ctx, repo.DbConn, req.AccountID, webhook.EventType_UserAccountArchived, after, now)
 	if err != nil {
-		err = errors.Wrapf(err, "query - %s", query.String())
-		err = errors.WithMessagef(err, "archive account %s from user %s failed", req.AccountID, req.UserID)
 		return err
 	}
 
@@ -470,6 +1031,16 @@ func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req User
 		return err
 	}
 
+	// Load the current user account so the change can be recorded.
+	before, err := repo.Read(ctx, auth.Claims{}, UserAccountReadRequest{
+		UserID:          req.UserID,
+		AccountID:       req.AccountID,
+		IncludeArchived: true,
+	})
+	if err != nil {
+		return err
+	}
+
 	// Build the delete SQL statement.
 	query := sqlbuilder.NewDeleteBuilder()
 	query.DeleteFrom(userAccountTableName)
@@ -478,13 +1049,36 @@ func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req User
 		query.Equal("account_id", req.AccountID),
 	))
 
-	// Execute the query with the provided context.
-	sql, args := query.Build()
-	sql = repo.DbConn.Rebind(sql)
-	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	// Ensure the account keeps an active admin.
+	err = repo.changeMembership(ctx, claims, before, nil, func(tx *sqlx.Tx) error {
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = tx.Rebind(sql)
+		_, err := tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "delete account %s for user %s failed", req.AccountID, req.UserID)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = audit.Record(ctx, repo.DbConn, claims, audit.AuditEventRecordRequest{
+		AccountID:  req.AccountID,
+		EntityType: audit.EntityType_UserAccount,
+		EntityID:   req.UserID,
+		Action:     audit.Action_Delete,
+		Before:     before,
+	}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = webhook.Publish(ctx, repo.DbConn, req.AccountID, webhook.EventType_UserAccountDeleted, before, time.Now())
 	if err != nil {
-		err = errors.Wrapf(err, "query - %s", query.String())
-		err = errors.WithMessagef(err, "delete account %s for user %s failed", req.AccountID, req.UserID)
 		return err
 	}
 
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/go-cmp/cmp"
@@ -45,10 +46,9 @@ func TestFindRequestQuery(t *testing.T) {
 	)
 
 	req := UserAccountFindRequest{
-		Where: "account_id = ? or user_id = ?",
-		Args: []interface{}{
-			"xy7",
-			"qwert",
+		Filter: filter.Filter{
+			{Field: "account_id", Operator: filter.Op_Eq, Values: []string{"c4653bf9-5978-48b7-89c5-95704aebb7e2"}},
+			{Field: "status", Operator: filter.Op_In, Values: []string{"active", "invited"}},
 		},
 		Order: []string{
 			"id asc",
@@ -57,14 +57,19 @@ func TestFindRequestQuery(t *testing.T) {
 		Limit:  &limit,
 		Offset: &offset,
 	}
-	expected := "SELECT " + userAccountMapColumns + " FROM " + userAccountTableName + " WHERE (account_id = ? or user_id = ?) ORDER BY id asc, created_at desc LIMIT 12 OFFSET 34"
+	expected := "SELECT " + userAccountMapColumns + " FROM " + userAccountTableName + " WHERE account_id = ? AND status IN (?, ?) ORDER BY id asc, created_at desc LIMIT 12 OFFSET 34"
 
-	res, args := findRequestQuery(req)
+	res, err := findRequestQuery(req)
+	if err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tFind request query failed.", tests.Failed)
+	}
 
 	if diff := cmp.Diff(res.String(), expected); diff != "" {
 		t.Fatalf("\t%s\tExpected result query to match. Diff:\n%s", tests.Failed, diff)
 	}
-	if diff := cmp.Diff(args, req.Args); diff != "" {
+	_, args := res.Build()
+	if diff := cmp.Diff(args, []interface{}{"c4653bf9-5978-48b7-89c5-95704aebb7e2", "active", "invited"}); diff != "" {
 		t.Fatalf("\t%s\tExpected result query to match. Diff:\n%s", tests.Failed, diff)
 	}
 }
@@ -144,7 +149,7 @@ func TestApplyClaimsSelectvalidates(t *testing.T) {
 // TestCreateValidation ensures all the validation tags work on user account create.
 func TestCreateValidation(t *testing.T) {
 
-	invalidRole := UserAccountRole("moon")
+	invalidRole := UserAccountRole("Moon Launch")
 	invalidStatus := UserAccountStatus("moon")
 
 	var accountTests = []struct {
@@ -171,7 +176,7 @@ func TestCreateValidation(t *testing.T) {
 			func(req UserAccountCreateRequest, res *UserAccount) *UserAccount {
 				return nil
 			},
-			errors.New("Key: 'UserAccountCreateRequest.roles[0]' Error:Field validation for 'roles[0]' failed on the 'oneof' tag"),
+			errors.New("Key: 'UserAccountCreateRequest.roles[0]' Error:Field validation for 'roles[0]' failed on the 'role' tag"),
 		},
 		{"Valid Status",
 			UserAccountCreateRequest{
@@ -321,6 +326,13 @@ func TestCreateExistingEntry(t *testing.T) {
 			t.Fatalf("\t%s\tCreate user account roles should match request. Diff:\n%s", tests.Failed, diff)
 		}
 
+		// Add another admin to the account so the user account can be archived.
+		err = mockAdmin(accountID, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tMock admin failed.", tests.Failed)
+		}
+
 		// Now archive the user account to test trying to create a new entry for an archived entry
 		err = repo.Archive(tests.Context(), auth.Claims{}, UserAccountArchiveRequest{
 			UserID:    req1.UserID,
@@ -372,7 +384,7 @@ func TestCreateExistingEntry(t *testing.T) {
 // TestUpdateValidation ensures all the validation tags work on user account update.
 func TestUpdateValidation(t *testing.T) {
 
-	invalidRole := UserAccountRole("moon")
+	invalidRole := UserAccountRole("Moon Launch")
 	invalidStatus := UserAccountStatus("xxxxxxxxx")
 
 	var accountTests = []struct {
@@ -391,7 +403,7 @@ func TestUpdateValidation(t *testing.T) {
 				AccountID: uuid.NewRandom().String(),
 				Roles:     &UserAccountRoles{invalidRole},
 			},
-			errors.New("Key: 'UserAccountUpdateRequest.roles[0]' Error:Field validation for 'roles[0]' failed on the 'oneof' tag"),
+			errors.New("Key: 'UserAccountUpdateRequest.roles[0]' Error:Field validation for 'roles[0]' failed on the 'role' tag"),
 		},
 
 		{"Valid Status",
@@ -605,8 +617,10 @@ func TestCrud(t *testing.T) {
 				// Find the account for the user to verify the updates where made. There should only
 				// be one account associated with the user for this test.
 				findRes, err := repo.Find(tests.Context(), tt.claims(userID, accountID), UserAccountFindRequest{
-					Where: "user_id = ? or account_id = ?",
-					Args:  []interface{}{userID, accountID},
+					Filter: filter.Filter{
+						{Field: "user_id", Operator: filter.Op_Eq, Values: []string{userID}},
+						{Field: "account_id", Operator: filter.Op_Eq, Values: []string{accountID}},
+					},
 					Order: []string{"created_at"},
 				})
 				if err != nil && errors.Cause(err) != tt.findErr {
@@ -631,6 +645,13 @@ func TestCrud(t *testing.T) {
 					t.Logf("\t%s\tVerify update user account ok.", tests.Success)
 				}
 
+				// Add another admin to the account so the user account can be archived.
+				err = mockAdmin(accountID, now)
+				if err != nil {
+					t.Logf("\t\tGot : %+v", err)
+					t.Fatalf("\t%s\tMock admin failed.", tests.Failed)
+				}
+
 				// Archive (soft-delete) the user account.
 				err = repo.Archive(tests.Context(), tt.claims(userID, accountID), UserAccountArchiveRequest{
 					UserID:    userID,
@@ -748,14 +769,16 @@ func TestFind(t *testing.T) {
 
 	var accountTests []accountTest
 
-	createdFilter := "created_at BETWEEN ? AND ?"
+	createdFilter := filter.Filter{
+		{Field: "created_at", Operator: filter.Op_Gte, Values: []string{startTime.Format(time.RFC3339Nano)}},
+		{Field: "created_at", Operator: filter.Op_Lte, Values: []string{endTime.Format(time.RFC3339Nano)}},
+	}
 
 	// Test sort users.
 	accountTests = append(accountTests, accountTest{"Find all order by created_at asx",
 		UserAccountFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at"},
+			Filter: createdFilter,
+			Order:  []string{"created_at"},
 		},
 		userAccounts,
 		nil,
@@ -768,9 +791,8 @@ func TestFind(t *testing.T) {
 	}
 	accountTests = append(accountTests, accountTest{"Find all order by created_at desc",
 		UserAccountFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at desc"},
+			Filter: createdFilter,
+			Order:  []string{"created_at desc"},
 		},
 		expected,
 		nil,
@@ -780,10 +802,9 @@ func TestFind(t *testing.T) {
 	var limit uint = 2
 	accountTests = append(accountTests, accountTest{"Find limit",
 		UserAccountFindRequest{
-			Where: createdFilter,
-			Args:  []interface{}{startTime, endTime},
-			Order: []string{"created_at"},
-			Limit: &limit,
+			Filter: createdFilter,
+			Order:  []string{"created_at"},
+			Limit:  &limit,
 		},
 		userAccounts[0:2],
 		nil,
@@ -793,8 +814,7 @@ func TestFind(t *testing.T) {
 	var offset uint = 3
 	accountTests = append(accountTests, accountTest{"Find limit, offset",
 		UserAccountFindRequest{
-			Where:  createdFilter,
-			Args:   []interface{}{startTime, endTime},
+			Filter: createdFilter,
 			Order:  []string{"created_at"},
 			Limit:  &limit,
 			Offset: &offset,
@@ -804,26 +824,23 @@ func TestFind(t *testing.T) {
 	})
 
 	// Test where filter.
-	whereParts := []string{}
-	whereArgs := []interface{}{startTime, endTime}
+	var values []string
 	expected = []*UserAccount{}
-	for i := 0; i <= len(userAccounts); i++ {
-		if rand.Intn(100) < 50 {
+	for i := 0; i < len(userAccounts); i++ {
+		// Always include the first one so the filter has a value.
+		if i > 0 && rand.Intn(100) < 50 {
 			continue
 		}
 		ua := *userAccounts[i]
 
-		whereParts = append(whereParts, "(user_id = ? and account_id = ?)")
-		whereArgs = append(whereArgs, ua.UserID)
-		whereArgs = append(whereArgs, ua.AccountID)
+		values = append(values, ua.AccountID)
 		expected = append(expected, &ua)
 	}
 
 	accountTests = append(accountTests, accountTest{"Find where",
 		UserAccountFindRequest{
-			Where: createdFilter + " AND (" + strings.Join(whereParts, " OR ") + ")",
-			Args:  whereArgs,
-			Order: []string{"created_at"},
+			Filter: append(createdFilter, filter.Condition{Field: "account_id", Operator: filter.Op_In, Values: values}),
+			Order:  []string{"created_at"},
 		},
 		expected,
 		nil,
@@ -852,6 +869,264 @@ func TestFind(t *testing.T) {
 	}
 }
 
+// TestOwnerAndAdmins validates the owner role can only be changed by transferring the ownership and that accounts
+// always keep an active admin.
+func TestOwnerAndAdmins(t *testing.T) {
+
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Given the need ensure an account always has an owner and an active admin.")
+	{
+		ctx := tests.Context()
+
+		// Generate a new random account with three users.
+		accountID := uuid.NewRandom().String()
+		err := mockAccount(accountID, now)
+		if err != nil {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tMock account failed.", tests.Failed)
+		}
+
+		var userIDs []string
+		for i := 0; i < 3; i++ {
+			userID := uuid.NewRandom().String()
+			err := mockUser(userID, now)
+			if err != nil {
+				t.Logf("\t\tGot : %+v", err)
+				t.Fatalf("\t%s\tMock user failed.", tests.Failed)
+			}
+			userIDs = append(userIDs, userID)
+		}
+		ownerID, adminID, otherID := userIDs[0], userIDs[1], userIDs[2]
+
+		// The first admin of the account is the only user that is active.
+		_, err = repo.Create(ctx, auth.Claims{}, UserAccountCreateRequest{
+			UserID:    adminID,
+			AccountID: accountID,
+			Roles:     []UserAccountRole{UserAccountRole_Admin},
+		}, now)
+		if err != nil {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tCreate admin failed.", tests.Failed)
+		}
+
+		disabled := UserAccountStatus_Disabled
+		for _, req := range []UserAccountUpdateRequest{
+			{UserID: adminID, AccountID: accountID, Roles: &UserAccountRoles{UserAccountRole_User}},
+			{UserID: adminID, AccountID: accountID, Status: &disabled},
+		} {
+			err = repo.Update(ctx, auth.Claims{}, req, now)
+			if errors.Cause(err) != ErrLastAdmin {
+				t.Logf("\t\tGot : %+v", err)
+				t.Logf("\t\tWant: %+v", ErrLastAdmin)
+				t.Fatalf("\t%s\tUpdate last admin failed.", tests.Failed)
+			}
+		}
+
+		err = repo.Archive(ctx, auth.Claims{}, UserAccountArchiveRequest{UserID: adminID, AccountID: accountID}, now)
+		if errors.Cause(err) != ErrLastAdmin {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrLastAdmin)
+			t.Fatalf("\t%s\tArchive last admin failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tLast admin ok.", tests.Success)
+
+		// The owner role can be assigned when the account doesn't have an owner.
+		_, err = repo.Create(ctx, auth.Claims{}, UserAccountCreateRequest{
+			UserID:    ownerID,
+			AccountID: accountID,
+			Roles:     []UserAccountRole{UserAccountRole_Owner, UserAccountRole_Admin},
+		}, now)
+		if err != nil {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tCreate owner failed.", tests.Failed)
+		}
+
+		_, err = repo.Create(ctx, auth.Claims{}, UserAccountCreateRequest{
+			UserID:    otherID,
+			AccountID: accountID,
+			Roles:     []UserAccountRole{UserAccountRole_Owner, UserAccountRole_Admin},
+		}, now)
+		if errors.Cause(err) != ErrOwnerRole {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrOwnerRole)
+			t.Fatalf("\t%s\tCreate second owner failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate owner ok.", tests.Success)
+
+		// The owner role is kept when the roles of the owner are updated.
+		err = repo.Update(ctx, auth.Claims{}, UserAccountUpdateRequest{
+			UserID:    ownerID,
+			AccountID: accountID,
+			Roles:     &UserAccountRoles{UserAccountRole_Admin},
+		}, now)
+		if err != nil {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tUpdate owner failed.", tests.Failed)
+		}
+
+		owner, err := repo.ReadOwner(ctx, auth.Claims{}, accountID)
+		if err != nil {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tRead owner failed.", tests.Failed)
+		} else if diff := cmp.Diff(owner.Roles, UserAccountRoles{UserAccountRole_Owner, UserAccountRole_Admin}); owner.UserID != ownerID || diff != "" {
+			t.Fatalf("\t%s\tExpected owner roles to be kept. Diff:\n%s", tests.Failed, diff)
+		}
+
+		for _, req := range []UserAccountUpdateRequest{
+			{UserID: ownerID, AccountID: accountID, Roles: &UserAccountRoles{UserAccountRole_User}},
+			{UserID: ownerID, AccountID: accountID, Status: &disabled},
+		} {
+			err = repo.Update(ctx, auth.Claims{}, req, now)
+			if errors.Cause(err) != ErrOwnerRole {
+				t.Logf("\t\tGot : %+v", err)
+				t.Logf("\t\tWant: %+v", ErrOwnerRole)
+				t.Fatalf("\t%s\tUpdate owner failed.", tests.Failed)
+			}
+		}
+
+		err = repo.Archive(ctx, auth.Claims{}, UserAccountArchiveRequest{UserID: ownerID, AccountID: accountID}, now)
+		if errors.Cause(err) != ErrOwnerRole {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrOwnerRole)
+			t.Fatalf("\t%s\tArchive owner failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tUpdate owner ok.", tests.Success)
+
+		// With the owner as an admin the other admin can be removed.
+		err = repo.Update(ctx, auth.Claims{}, UserAccountUpdateRequest{
+			UserID:    adminID,
+			AccountID: accountID,
+			Roles:     &UserAccountRoles{UserAccountRole_User},
+		}, now)
+		if err != nil {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tUpdate admin failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tUpdate admin ok.", tests.Success)
+
+		// Transfer the ownership to the user, they are also assigned the admin role.
+		err = repo.TransferOwner(ctx, auth.Claims{}, UserAccountTransferOwnerRequest{
+			AccountID:  accountID,
+			FromUserID: ownerID,
+			ToUserID:   adminID,
+		}, now)
+		if err != nil {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tTransfer owner failed.", tests.Failed)
+		}
+
+		owner, err = repo.ReadOwner(ctx, auth.Claims{}, accountID)
+		if err != nil {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tRead owner failed.", tests.Failed)
+		} else if diff := cmp.Diff(owner.Roles, UserAccountRoles{UserAccountRole_Owner, UserAccountRole_Admin}); owner.UserID != adminID || diff != "" {
+			t.Logf("\t\tGot : %+v", owner)
+			t.Fatalf("\t%s\tExpected new owner. Diff:\n%s", tests.Failed, diff)
+		}
+
+		prev, err := repo.Read(ctx, auth.Claims{}, UserAccountReadRequest{UserID: ownerID, AccountID: accountID})
+		if err != nil {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tRead previous owner failed.", tests.Failed)
+		} else if diff := cmp.Diff(prev.Roles, UserAccountRoles{UserAccountRole_Admin}); diff != "" {
+			t.Fatalf("\t%s\tExpected previous owner to be an admin. Diff:\n%s", tests.Failed, diff)
+		}
+
+		err = repo.TransferOwner(ctx, auth.Claims{}, UserAccountTransferOwnerRequest{
+			AccountID:  accountID,
+			FromUserID: ownerID,
+			ToUserID:   adminID,
+		}, now)
+		if errors.Cause(err) != ErrOwnerRole {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrOwnerRole)
+			t.Fatalf("\t%s\tTransfer from previous owner failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tTransfer owner ok.", tests.Success)
+	}
+}
+
+// TestConcurrentAdminRemoval validates that concurrent changes can't remove the last active admin of an account.
+func TestConcurrentAdminRemoval(t *testing.T) {
+
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Given the need to remove admins of an account concurrently.")
+	{
+		ctx := tests.Context()
+
+		accountID := uuid.NewRandom().String()
+		err := mockAccount(accountID, now)
+		if err != nil {
+			t.Logf("\t\tGot : %+v", err)
+			t.Fatalf("\t%s\tMock account failed.", tests.Failed)
+		}
+
+		var adminIDs []string
+		for i := 0; i < 2; i++ {
+			userID := uuid.NewRandom().String()
+			if err := mockUser(userID, now); err != nil {
+				t.Logf("\t\tGot : %+v", err)
+				t.Fatalf("\t%s\tMock user failed.", tests.Failed)
+			}
+
+			_, err = repo.Create(ctx, auth.Claims{}, UserAccountCreateRequest{
+				UserID:    userID,
+				AccountID: accountID,
+				Roles:     []UserAccountRole{UserAccountRole_Admin},
+			}, now)
+			if err != nil {
+				t.Logf("\t\tGot : %+v", err)
+				t.Fatalf("\t%s\tCreate admin failed.", tests.Failed)
+			}
+			adminIDs = append(adminIDs, userID)
+		}
+
+		// Both admins are removed at the same time, only one of them can succeed.
+		errs := make(chan error, len(adminIDs))
+		for _, userID := range adminIDs {
+			go func(userID string) {
+				errs <- repo.Archive(ctx, auth.Claims{}, UserAccountArchiveRequest{UserID: userID, AccountID: accountID}, now)
+			}(userID)
+		}
+
+		var removed, lastAdmin int
+		for range adminIDs {
+			err := <-errs
+			if err == nil {
+				removed++
+			} else if errors.Cause(err) == ErrLastAdmin {
+				lastAdmin++
+			} else {
+				t.Logf("\t\tGot : %+v", err)
+				t.Fatalf("\t%s\tArchive admin failed.", tests.Failed)
+			}
+		}
+
+		if removed != 1 || lastAdmin != 1 {
+			t.Logf("\t\tGot : %d removed, %d last admin", removed, lastAdmin)
+			t.Fatalf("\t%s\tExpected only one admin to be removed.", tests.Failed)
+		}
+		t.Logf("\t%s\tConcurrent admin removal ok.", tests.Success)
+	}
+}
+
+func mockAdmin(accountId string, now time.Time) error {
+	userId := uuid.NewRandom().String()
+	err := mockUser(userId, now)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.Create(tests.Context(), auth.Claims{}, UserAccountCreateRequest{
+		UserID:    userId,
+		AccountID: accountId,
+		Roles:     []UserAccountRole{UserAccountRole_Admin},
+	}, now)
+	return err
+}
+
 func mockAccount(accountId string, now time.Time) error {
 
 	// Build the insert SQL statement.
@@ -7,13 +7,15 @@ import (
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_role"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/bruteforce"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user"
-	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
 
 	"github.com/huandu/go-sqlbuilder"
 	"github.com/lib/pq"
+	"github.com/pborman/uuid"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
@@ -26,6 +28,14 @@ var (
 
 	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
 	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrEmailNotVerified occurs when a user has authenticated with their password but
+	// one of their accounts requires the email address to be verified before logging in.
+	ErrEmailNotVerified = errors.New("Email address not verified")
+
+	// ErrSsoRequired occurs when a user attempts to authenticate with a password but their email address belongs to
+	// an account that enforces single sign-on.
+	ErrSsoRequired = errors.New("Single sign-on required")
 )
 
 const (
@@ -35,6 +45,8 @@ const (
 	accountTableName = "accounts"
 	// The database table for User Account
 	userAccountTableName = "users_accounts"
+	// The database table for AccountPreference
+	accountPreferenceTableName = "account_preferences"
 )
 
 // Authenticate finds a user by their email and verifies their password. On success
@@ -51,9 +63,32 @@ func (repo *Repository) Authenticate(ctx context.Context, req AuthenticateReques
 		return Token{}, err
 	}
 
+	// Block repeated failed attempts from the same IP address or for the same email address from the same IP address.
+	// The email address is not locked out for other clients so anyone that knows it can't lock out the user, failed
+	// attempts from any IP address only slow down further attempts for the email address.
+	ipKey, clientKey := bruteforce.ClientKeys(ctx, "login", req.Email)
+	emailKey := bruteforce.Key("login", "email", req.Email)
+	if err := repo.Limiter.Allow(ctx, ipKey, clientKey, emailKey); err != nil {
+		return Token{}, errors.WithMessagef(err, "login for %s blocked", req.Email)
+	}
+
+	// Passwords are not accepted for users managed by the identity provider of their account.
+	if repo.Sso != nil {
+		required, err := repo.Sso.SsoRequired(ctx, req.Email)
+		if err != nil {
+			return Token{}, err
+		} else if required {
+			return Token{}, errors.WithMessagef(ErrSsoRequired, "login for %s", req.Email)
+		}
+	}
+
 	u, err := repo.User.ReadByEmail(ctx, auth.Claims{}, req.Email, false)
 	if err != nil {
 		if errors.Cause(err) == user.ErrNotFound {
+			if err := repo.recordLoginFailure(ctx, nil, ipKey, clientKey, emailKey); err != nil {
+				return Token{}, err
+			}
+
 			err = errors.WithStack(ErrAuthenticationFailure)
 			return Token{}, err
 		} else {
@@ -68,12 +103,103 @@ func (repo *Repository) Authenticate(ctx context.Context, req AuthenticateReques
 	// function so it is cryptographically secure. Return authentication error for
 	// invalid password.
 	if err := bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(saltedPassword)); err != nil {
+		if err := repo.recordLoginFailure(ctx, u, ipKey, clientKey, emailKey); err != nil {
+			return Token{}, err
+		}
+
 		err = errors.WithStack(ErrAuthenticationFailure)
 		return Token{}, err
 	}
 
+	// The password is valid, clear the failed attempts for the email address. Failed attempts for the IP address are
+	// kept so a valid login can't be used to reset the limit while guessing the passwords of other users.
+	if err := repo.Limiter.Reset(ctx, clientKey); err != nil {
+		return Token{}, err
+	} else if err := repo.Limiter.Reset(ctx, emailKey); err != nil {
+		return Token{}, err
+	}
+
 	// The user is successfully authenticated with the supplied email and password.
-	return repo.generateToken(ctx, auth.Claims{}, u.ID, req.AccountID, expires, now, scopes...)
+	return repo.authenticateUser(ctx, u, req.AccountID, expires, now, scopes...)
+}
+
+// AuthenticateIdentity generates a token for a user that has already proven their identity some other way, ie by
+// logging in with an external identity provider. The same checks as Authenticate are applied after the password.
+func (repo *Repository) AuthenticateIdentity(ctx context.Context, userID, accountID string, expires time.Duration, now time.Time, scopes ...string) (Token, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.AuthenticateIdentity")
+	defer span.Finish()
+
+	u, err := repo.User.ReadByID(ctx, auth.Claims{}, userID)
+	if err != nil {
+		if errors.Cause(err) == user.ErrNotFound {
+			err = errors.WithStack(ErrAuthenticationFailure)
+			return Token{}, err
+		}
+		return Token{}, err
+	}
+
+	return repo.authenticateUser(ctx, u, accountID, expires, now, scopes...)
+}
+
+// authenticateUser applies the checks required by the accounts of the user once the user has been identified and then
+// generates the token.
+func (repo *Repository) authenticateUser(ctx context.Context, u *user.User, accountID string, expires time.Duration, now time.Time, scopes ...string) (Token, error) {
+	// Accounts can require users to verify their email address before they are able to login.
+	if !u.EmailVerified() {
+		required, err := repo.accountPreferenceEnabled(ctx, account_preference.AccountPreference_Email_Verification_Required, u.ID, accountID)
+		if err != nil {
+			return Token{}, err
+		} else if required {
+			err = errors.WithMessagef(ErrEmailNotVerified, "email %s has not been verified", u.Email)
+			return Token{}, err
+		}
+	}
+
+	// When two-factor authentication is enabled for the user or required by one of their
+	// accounts, identifying the user is not enough to authenticate.
+	if tkn, err := repo.mfaChallenge(ctx, u, accountID, now, scopes...); err != nil {
+		return tkn, err
+	}
+
+	return repo.generateToken(ctx, auth.Claims{}, u.ID, accountID, expires, now, scopes...)
+}
+
+// recordLoginFailure records a failed login attempt for the IP address, the email address from the IP address and the
+// email address from any IP address, which is only throttled. When the email address gets locked out for the IP
+// address, the user is notified by email.
+func (repo *Repository) recordLoginFailure(ctx context.Context, u *user.User, ipKey, clientKey, emailKey string) error {
+	if _, err := repo.Limiter.Fail(ctx, ipKey); err != nil {
+		return err
+	}
+
+	if err := repo.Limiter.Throttle(ctx, emailKey); err != nil {
+		return err
+	}
+
+	locked, err := repo.Limiter.Fail(ctx, clientKey)
+	if err != nil {
+		return err
+	} else if !locked || u == nil || repo.User.Notify == nil {
+		return nil
+	}
+
+	var requestIp string
+	if vals, _ := webcontext.ContextValues(ctx); vals != nil {
+		requestIp = vals.RequestIP
+	}
+
+	data := map[string]interface{}{
+		"Name":      u.FirstName,
+		"Email":     u.Email,
+		"RequestIP": requestIp,
+		"Minutes":   int(repo.Limiter.LockoutDuration().Minutes()),
+	}
+
+	// The lockout is already in place, a failure to deliver the notification should not change the response of the
+	// login attempt and disclose the email address belongs to a user.
+	_ = repo.User.Notify.Send(ctx, u.Email, "Your account has been temporarily locked", "user_lockout", data)
+
+	return nil
 }
 
 // SwitchAccount allows users to switch between multiple accounts, this changes the claim audience.
@@ -119,17 +245,31 @@ func (repo *Repository) VirtualLogin(ctx context.Context, claims auth.Claims, re
 		return Token{}, err
 	}
 
-	// The user must have the role of admin to login any other user.
-	var hasAccountAdminRole bool
+	// The user must have the user:impersonate permission to login any other user.
+	var hasImpersonatePermission bool
 	for _, usrAcc := range usrAccs {
-		if usrAcc.HasRole(user_account.UserAccountRole_Admin) {
-			if usrAcc.AccountID == req.AccountID {
-				hasAccountAdminRole = true
+		if usrAcc.AccountID != req.AccountID {
+			continue
+		}
+
+		var roles []string
+		for _, r := range usrAcc.Roles {
+			roles = append(roles, r.String())
+		}
+
+		perms, err := account_role.ResolvePermissions(ctx, repo.DbConn, usrAcc.AccountID, roles)
+		if err != nil {
+			return Token{}, err
+		}
+
+		for _, p := range perms {
+			if p == auth.PermissionUserImpersonate {
+				hasImpersonatePermission = true
 				break
 			}
 		}
 	}
-	if !hasAccountAdminRole {
+	if !hasImpersonatePermission {
 		return Token{}, errors.WithMessagef(ErrForbidden, "User %s does not have correct access to account %s ", claims.Subject, req.AccountID)
 	}
 
@@ -140,6 +280,10 @@ func (repo *Repository) VirtualLogin(ctx context.Context, claims auth.Claims, re
 		claims.RootUserID = claims.Subject
 	}
 
+	// The virtual login is tracked as its own session so it can be revoked
+	// independently of the session for the root user.
+	claims.SessionID = ""
+
 	// Generate a token for the user ID in supplied in claims as the Subject. Pass
 	// in the supplied claims as well to enforce ACLs when finding the current
 	// list of accounts for the user.
@@ -151,12 +295,54 @@ func (repo *Repository) VirtualLogout(ctx context.Context, claims auth.Claims, e
 	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.VirtualLogout")
 	defer span.Finish()
 
+	// Revoke the virtual session, a new session is started for the root user.
+	if claims.SessionID != "" {
+		err := repo.revokeSession(ctx, claims.SessionID, now)
+		if err != nil {
+			return Token{}, err
+		}
+		claims.SessionID = ""
+	}
+
 	// Generate a token for the user ID in supplied in claims as the Subject. Pass
 	// in the supplied claims as well to enforce ACLs when finding the current
 	// list of accounts for the user.
 	return repo.generateToken(ctx, claims, claims.RootUserID, claims.RootAccountID, expires, now, scopes...)
 }
 
+// accountPreferenceEnabled determines if any of the active accounts for the user
+// has the boolean preference set to true. When an account ID is provided, only
+// that account is checked.
+func (repo *Repository) accountPreferenceEnabled(ctx context.Context, name account_preference.AccountPreferenceName, userID, accountID string) (bool, error) {
+	query := sqlbuilder.NewSelectBuilder().Select("count(ap.account_id)").
+		From(accountPreferenceTableName+" ap").
+		Join(userAccountTableName+" ua", "ua.account_id = ap.account_id").
+		Join(accountTableName+" a", "a.id = ap.account_id")
+	query.Where(query.And(
+		query.Equal("ua.user_id", userID),
+		query.IsNull("ua.archived_at"),
+		query.IsNull("a.archived_at"),
+		query.IsNull("ap.archived_at"),
+		query.Equal("ap.name", name),
+		query.Equal("ap.value", "true"),
+	))
+	if accountID != "" {
+		query.Where(query.Equal("ap.account_id", accountID))
+	}
+
+	queryStr, queryArgs := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var total int
+	err := repo.DbConn.QueryRowContext(ctx, queryStr, queryArgs...).Scan(&total)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		return false, err
+	}
+
+	return total > 0, nil
+}
+
 // generateToken generates claims for the supplied user ID and account ID and then
 // returns the token for the generated claims used for authentication.
 func (repo *Repository) generateToken(ctx context.Context, claims auth.Claims, userID, accountID string, expires time.Duration, now time.Time, scopes ...string) (Token, error) {
@@ -327,6 +513,13 @@ func (repo *Repository) generateToken(ctx context.Context, claims auth.Claims, u
 		}
 	}
 
+	// Resolve the permissions granted by the roles so they can be checked without
+	// looking up the custom roles defined for the account on every request.
+	perms, err := account_role.ResolvePermissions(ctx, repo.DbConn, accountID, roles)
+	if err != nil {
+		return Token{}, err
+	}
+
 	var claimPref auth.ClaimPreferences
 	{
 		// Set the timezone if one is specifically set on the user.
@@ -388,11 +581,26 @@ func (repo *Repository) generateToken(ctx context.Context, claims auth.Claims, u
 	// 	Audience: The ID of the account the user is accessing. A list of account IDs
 	// 			  will also be included to support the user switching between them.
 	newClaims := auth.NewClaims(userID, accountID, accountIds, roles, claimPref, now, expires)
+	newClaims.Permissions = perms
 
 	// Copy the original root account/user ID.
 	newClaims.RootAccountID = claims.RootAccountID
 	newClaims.RootUserID = claims.RootUserID
 
+	// Tokens issued for an existing session, ie switching accounts or refreshing, retain the
+	// session ID so the session can be revoked as a whole.
+	newClaims.SessionID = claims.SessionID
+	if newClaims.SessionID == "" {
+		newClaims.SessionID = uuid.NewRandom().String()
+
+		// Persist the new session so it can be listed and revoked by the user.
+		if err := repo.createSession(ctx, newClaims, now); err != nil {
+			return Token{}, err
+		}
+	} else if err := repo.touchSession(ctx, newClaims.SessionID, newClaims.Audience, now); err != nil {
+		return Token{}, err
+	}
+
 	// Generate a token for the user with the defined claims.
 	tknStr, err := repo.TknGen.GenerateToken(newClaims)
 	if err != nil {
@@ -412,5 +620,12 @@ func (repo *Repository) generateToken(ctx context.Context, claims auth.Claims, u
 		tkn.TTL = expires
 	}
 
+	// Issue a refresh token for the session so the client can obtain a new access
+	// token once this one expires.
+	tkn.refreshTokenID, tkn.RefreshToken, err = repo.createRefreshToken(ctx, newClaims, scopes, now)
+	if err != nil {
+		return Token{}, err
+	}
+
 	return tkn, nil
 }
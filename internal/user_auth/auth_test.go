@@ -11,6 +11,8 @@ import (
 	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/totp"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
 	"github.com/google/go-cmp/cmp"
@@ -998,6 +1000,568 @@ func TestVirtualLogin(t *testing.T) {
 	}
 }
 
+// TestRefresh validates the behavior around exchanging refresh tokens and revoking sessions.
+func TestRefresh(t *testing.T) {
+	defer tests.Recover(t)
+
+	t.Log("Given the need to refresh access tokens.")
+	{
+		ctx := tests.Context()
+
+		now := time.Now().Add(time.Hour * -1)
+
+		// Create a new user for testing.
+		usrAcc, err := user_account.MockUserAccount(ctx, test.MasterDB, now, user_account.UserAccountRole_User)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate user account failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate user account ok.", tests.Success)
+
+		tkn1, err := repo.Authenticate(ctx,
+			AuthenticateRequest{
+				Email:    usrAcc.User.Email,
+				Password: usrAcc.User.Password,
+			}, time.Hour, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tAuthenticate failed.", tests.Failed)
+		} else if tkn1.RefreshToken == "" {
+			t.Fatalf("\t%s\tAuthenticate failed to return a refresh token.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticate ok.", tests.Success)
+
+		// Exchange the refresh token for a new token.
+		tkn2, err := repo.Refresh(ctx, RefreshRequest{RefreshToken: tkn1.RefreshToken}, time.Hour, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRefresh failed.", tests.Failed)
+		} else if tkn2.RefreshToken == "" || tkn2.RefreshToken == tkn1.RefreshToken {
+			t.Fatalf("\t%s\tRefresh failed to rotate the refresh token.", tests.Failed)
+		} else if tkn2.claims.SessionID != tkn1.claims.SessionID {
+			t.Logf("\t\tGot : %+v", tkn2.claims.SessionID)
+			t.Logf("\t\tWant: %+v", tkn1.claims.SessionID)
+			t.Fatalf("\t%s\tRefresh failed to retain the session.", tests.Failed)
+		} else if tkn2.UserID != usrAcc.UserID || tkn2.AccountID != usrAcc.AccountID {
+			t.Fatalf("\t%s\tRefresh returned a token for the wrong user.", tests.Failed)
+		}
+		t.Logf("\t%s\tRefresh ok.", tests.Success)
+
+		if err := repo.ValidateSession(ctx, tkn2.claims); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tValidateSession failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tValidateSession ok.", tests.Success)
+
+		// Reusing the first refresh token should fail and revoke the session.
+		_, err = repo.Refresh(ctx, RefreshRequest{RefreshToken: tkn1.RefreshToken}, time.Hour, now)
+		if errors.Cause(err) != ErrRefreshTokenReused {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrRefreshTokenReused)
+			t.Fatalf("\t%s\tRefresh with reused token failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRefresh with reused token ok.", tests.Success)
+
+		if err := repo.ValidateSession(ctx, tkn2.claims); errors.Cause(err) != ErrSessionRevoked {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrSessionRevoked)
+			t.Fatalf("\t%s\tValidateSession after reuse failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tValidateSession after reuse ok.", tests.Success)
+
+		// The second refresh token belongs to the revoked session and should no longer work.
+		_, err = repo.Refresh(ctx, RefreshRequest{RefreshToken: tkn2.RefreshToken}, time.Hour, now)
+		if errors.Cause(err) != ErrAuthenticationFailure {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrAuthenticationFailure)
+			t.Fatalf("\t%s\tRefresh with revoked session failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRefresh with revoked session ok.", tests.Success)
+	}
+
+	t.Log("Given the need to revoke sessions.")
+	{
+		ctx := tests.Context()
+
+		now := time.Now().Add(time.Hour * -1)
+
+		// Create a new user for testing.
+		usrAcc, err := user_account.MockUserAccount(ctx, test.MasterDB, now, user_account.UserAccountRole_User)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate user account failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate user account ok.", tests.Success)
+
+		tkn1, err := repo.Authenticate(ctx,
+			AuthenticateRequest{
+				Email:    usrAcc.User.Email,
+				Password: usrAcc.User.Password,
+			}, time.Hour, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tAuthenticate failed.", tests.Failed)
+		}
+
+		// Revoke the session using the refresh token.
+		err = repo.Revoke(ctx, RevokeRequest{Token: tkn1.RefreshToken}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRevoke failed.", tests.Failed)
+		} else if err := repo.ValidateSession(ctx, tkn1.claims); errors.Cause(err) != ErrSessionRevoked {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrSessionRevoked)
+			t.Fatalf("\t%s\tValidateSession after revoke failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRevoke ok.", tests.Success)
+
+		tkn2, err := repo.Authenticate(ctx,
+			AuthenticateRequest{
+				Email:    usrAcc.User.Email,
+				Password: usrAcc.User.Password,
+			}, time.Hour, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tAuthenticate failed.", tests.Failed)
+		}
+
+		// Changing the password should revoke all the sessions for the user.
+		newPass := uuid.NewRandom().String()
+		err = repo.User.UpdatePassword(ctx, auth.Claims{}, user.UserUpdatePasswordRequest{
+			ID:              usrAcc.UserID,
+			Password:        newPass,
+			PasswordConfirm: newPass,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tUpdate password failed.", tests.Failed)
+		} else if err := repo.ValidateSession(ctx, tkn2.claims); errors.Cause(err) != ErrSessionRevoked {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrSessionRevoked)
+			t.Fatalf("\t%s\tValidateSession after password change failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRevoke sessions on password change ok.", tests.Success)
+	}
+}
+
+// TestSessions validates the behavior around listing and revoking the sessions of a user.
+func TestSessions(t *testing.T) {
+	defer tests.Recover(t)
+
+	t.Log("Given the need to manage the active sessions of a user.")
+	{
+		ctx := tests.Context()
+
+		// Sessions record the user agent of the request that started them.
+		userAgent := "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/76.0.3809.100 Safari/537.36"
+		if vals, err := webcontext.ContextValues(ctx); err == nil {
+			vals.UserAgent = userAgent
+		}
+
+		now := time.Now().Add(time.Hour * -1)
+
+		// Create a new user for testing.
+		usrAcc, err := user_account.MockUserAccount(ctx, test.MasterDB, now, user_account.UserAccountRole_User)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate user account failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate user account ok.", tests.Success)
+
+		// Login three times to start three sessions.
+		var tkns []Token
+		for i := 0; i < 3; i++ {
+			tkn, err := repo.Authenticate(ctx,
+				AuthenticateRequest{
+					Email:    usrAcc.User.Email,
+					Password: usrAcc.User.Password,
+				}, time.Hour, now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tAuthenticate failed.", tests.Failed)
+			}
+			tkns = append(tkns, tkn)
+		}
+		t.Logf("\t%s\tAuthenticate ok.", tests.Success)
+
+		// Other users are not able to list or revoke the sessions.
+		otherAcc, err := user_account.MockUserAccount(ctx, test.MasterDB, now, user_account.UserAccountRole_User)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate user account failed.", tests.Failed)
+		}
+		otherClaims := auth.Claims{}
+		otherClaims.Subject = otherAcc.UserID
+		otherClaims.Audience = otherAcc.AccountID
+
+		err = repo.SessionRevoke(ctx, otherClaims, SessionRevokeRequest{ID: tkns[0].claims.SessionID}, now)
+		if errors.Cause(err) != ErrSessionNotFound {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrSessionNotFound)
+			t.Fatalf("\t%s\tSessionRevoke by another user failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tSessionRevoke by another user ok.", tests.Success)
+
+		sessions, err := repo.SessionFind(ctx, tkns[2].claims, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSessionFind failed.", tests.Failed)
+		} else if len(sessions) != len(tkns) {
+			t.Logf("\t\tGot : %d", len(sessions))
+			t.Logf("\t\tWant: %d", len(tkns))
+			t.Fatalf("\t%s\tSessionFind failed to return all the sessions.", tests.Failed)
+		}
+		for _, sess := range sessions {
+			if sess.UserID != usrAcc.UserID || sess.AccountID != usrAcc.AccountID {
+				t.Fatalf("\t%s\tSessionFind returned a session for the wrong user.", tests.Failed)
+			} else if sess.IPAddress != "68.69.35.104" || sess.UserAgent != userAgent || sess.Device != "Chrome on macOS" {
+				t.Logf("\t\tGot : %s | %s | %s", sess.IPAddress, sess.UserAgent, sess.Device)
+				t.Fatalf("\t%s\tSessionFind failed to return the request details.", tests.Failed)
+			}
+		}
+		t.Logf("\t%s\tSessionFind ok.", tests.Success)
+
+		// Revoke the first session.
+		err = repo.SessionRevoke(ctx, tkns[2].claims, SessionRevokeRequest{ID: tkns[0].claims.SessionID}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSessionRevoke failed.", tests.Failed)
+		} else if err := repo.ValidateSession(ctx, tkns[0].claims); errors.Cause(err) != ErrSessionRevoked {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrSessionRevoked)
+			t.Fatalf("\t%s\tValidateSession after revoke failed.", tests.Failed)
+		} else if _, err := repo.Refresh(ctx, RefreshRequest{RefreshToken: tkns[0].RefreshToken}, time.Hour, now); errors.Cause(err) != ErrAuthenticationFailure {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrAuthenticationFailure)
+			t.Fatalf("\t%s\tRefresh after revoke failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tSessionRevoke ok.", tests.Success)
+
+		// Revoke all the other sessions, the current session should remain active.
+		err = repo.SessionRevokeAll(ctx, tkns[2].claims, SessionRevokeAllRequest{ExceptCurrent: true}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSessionRevokeAll except current failed.", tests.Failed)
+		} else if err := repo.ValidateSession(ctx, tkns[1].claims); errors.Cause(err) != ErrSessionRevoked {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrSessionRevoked)
+			t.Fatalf("\t%s\tValidateSession for other session failed.", tests.Failed)
+		} else if err := repo.ValidateSession(ctx, tkns[2].claims); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tValidateSession for current session failed.", tests.Failed)
+		}
+
+		sessions, err = repo.SessionFind(ctx, tkns[2].claims, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSessionFind failed.", tests.Failed)
+		} else if len(sessions) != 1 || sessions[0].ID != tkns[2].claims.SessionID {
+			t.Logf("\t\tGot : %d", len(sessions))
+			t.Fatalf("\t%s\tSessionFind failed to exclude revoked sessions.", tests.Failed)
+		}
+		t.Logf("\t%s\tSessionRevokeAll except current ok.", tests.Success)
+
+		// Log out everywhere.
+		err = repo.SessionRevokeAll(ctx, tkns[2].claims, SessionRevokeAllRequest{}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSessionRevokeAll failed.", tests.Failed)
+		} else if err := repo.ValidateSession(ctx, tkns[2].claims); errors.Cause(err) != ErrSessionRevoked {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrSessionRevoked)
+			t.Fatalf("\t%s\tValidateSession after revoke all failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tSessionRevokeAll ok.", tests.Success)
+
+		// Access tokens issued before sessions were tracked are for unknown sessions, they remain valid until they
+		// expire and the session is created.
+		legacyClaims := auth.NewClaims(otherAcc.UserID, otherAcc.AccountID, []string{otherAcc.AccountID}, []string{auth.RoleUser}, auth.ClaimPreferences{}, now, time.Hour)
+		legacyClaims.SessionID = uuid.NewRandom().String()
+		if err := repo.ValidateSession(ctx, legacyClaims); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tValidateSession for untracked session failed.", tests.Failed)
+		} else if _, err := repo.readSession(ctx, legacyClaims.SessionID); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tValidateSession failed to backfill the session.", tests.Failed)
+		}
+
+		// The sessions of the user were all revoked after the token was issued.
+		legacyClaims = tkns[2].claims
+		legacyClaims.SessionID = uuid.NewRandom().String()
+		if err := repo.ValidateSession(ctx, legacyClaims); errors.Cause(err) != ErrSessionRevoked {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrSessionRevoked)
+			t.Fatalf("\t%s\tValidateSession for untracked session after revoke all failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tValidateSession for untracked session ok.", tests.Success)
+	}
+}
+
+// TestSessionDevice validates the device description generated from user agents.
+func TestSessionDevice(t *testing.T) {
+	var userAgentTests = []struct {
+		userAgent string
+		expected  string
+	}{
+		{"", ""},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/76.0.3809.100 Safari/537.36", "Chrome on macOS"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.1.2 Safari/605.1.15", "Safari on macOS"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/76.0.3809.100 Safari/537.36 Edg/76.0.182.44", "Edge on Windows"},
+		{"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:68.0) Gecko/20100101 Firefox/68.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 12_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.1.2 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"Mozilla/5.0 (Linux; Android 9; Pixel 3) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/76.0.3809.111 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/7.54.0", "curl"},
+		{"Go-http-client/1.1", ""},
+	}
+
+	t.Log("Given the need to describe the device of a session.")
+	{
+		for i, tt := range userAgentTests {
+			t.Logf("\tTest: %d\tWhen using user agent %q", i, tt.userAgent)
+			{
+				res := SessionDevice(tt.userAgent)
+				if res != tt.expected {
+					t.Logf("\t\tGot : %s", res)
+					t.Logf("\t\tWant: %s", tt.expected)
+					t.Fatalf("\t%s\tSessionDevice failed.", tests.Failed)
+				}
+				t.Logf("\t%s\tSessionDevice ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestAuthenticateMfa validates the behavior around two-factor authentication when authenticating users.
+func TestAuthenticateMfa(t *testing.T) {
+	defer tests.Recover(t)
+
+	t.Log("Given the need to authenticate users with two-factor authentication")
+	{
+		ctx := tests.Context()
+
+		now := time.Now()
+
+		// Create a new user for testing.
+		usrAcc, err := user_account.MockUserAccount(ctx, test.MasterDB, now, user_account.UserAccountRole_Admin)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate user account failed.", tests.Failed)
+		}
+
+		// Require two-factor authentication for the account.
+		err = repo.AccountPreference.Set(ctx, auth.Claims{}, account_preference.AccountPreferenceSetRequest{
+			AccountID: usrAcc.AccountID,
+			Name:      account_preference.AccountPreference_Mfa_Required,
+			Value:     "true",
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSet account preference failed.", tests.Failed)
+		}
+
+		// Ensure the user must enroll before being authenticated.
+		authReq := AuthenticateRequest{
+			Email:    usrAcc.User.Email,
+			Password: usrAcc.User.Password,
+		}
+		tkn, err := repo.Authenticate(ctx, authReq, time.Hour, now)
+		if errors.Cause(err) != ErrMfaEnrollmentRequired {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrMfaEnrollmentRequired)
+			t.Fatalf("\t%s\tAuthenticate enrollment required failed.", tests.Failed)
+		} else if tkn.MfaToken == "" || tkn.AccessToken != "" {
+			t.Logf("\t\tGot : %+v", tkn)
+			t.Fatalf("\t%s\tAuthenticate enrollment required failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticate enrollment required ok.", tests.Success)
+
+		enrollment, err := repo.MfaEnroll(ctx, MfaEnrollRequest{MfaToken: tkn.MfaToken}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tMfaEnroll failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tMfaEnroll ok.", tests.Success)
+
+		// Ensure an invalid code is rejected.
+		_, err = repo.AuthenticateMfa(ctx, MfaAuthenticateRequest{MfaToken: tkn.MfaToken, Code: "abc"}, time.Hour, now)
+		if errors.Cause(err) != ErrAuthenticationFailure {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrAuthenticationFailure)
+			t.Fatalf("\t%s\tAuthenticateMfa invalid code failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticateMfa invalid code ok.", tests.Success)
+
+		// Ensure an invalid token is rejected.
+		_, err = repo.AuthenticateMfa(ctx, MfaAuthenticateRequest{MfaToken: "invalid", Code: "123456"}, time.Hour, now)
+		if errors.Cause(err) != ErrAuthenticationFailure {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrAuthenticationFailure)
+			t.Fatalf("\t%s\tAuthenticateMfa invalid token failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticateMfa invalid token ok.", tests.Success)
+
+		// Complete enrollment and authenticate with a code from the authenticator app.
+		code, err := totp.GenerateCode(enrollment.Secret, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGenerateCode failed.", tests.Failed)
+		}
+		tkn1, err := repo.AuthenticateMfa(ctx, MfaAuthenticateRequest{MfaToken: tkn.MfaToken, Code: code}, time.Hour, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tAuthenticateMfa failed.", tests.Failed)
+		}
+
+		claims1, err := repo.TknGen.ParseClaims(tkn1.AccessToken)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tParse claims from token failed.", tests.Failed)
+		} else if claims1.Subject != usrAcc.UserID || claims1.Audience != usrAcc.AccountID {
+			t.Logf("\t\tGot : %+v", claims1)
+			t.Fatalf("\t%s\tAuthenticateMfa claims failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticateMfa ok.", tests.Success)
+
+		// Now that two-factor authentication is enabled, a code is required.
+		tkn, err = repo.Authenticate(ctx, authReq, time.Hour, now)
+		if errors.Cause(err) != ErrMfaRequired {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrMfaRequired)
+			t.Fatalf("\t%s\tAuthenticate mfa required failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticate mfa required ok.", tests.Success)
+
+		// Ensure the same code can't be used again.
+		_, err = repo.AuthenticateMfa(ctx, MfaAuthenticateRequest{MfaToken: tkn.MfaToken, Code: code}, time.Hour, now)
+		if errors.Cause(err) != ErrAuthenticationFailure {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrAuthenticationFailure)
+			t.Fatalf("\t%s\tAuthenticateMfa replay failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticateMfa replay ok.", tests.Success)
+
+		// Authenticate with a recovery code.
+		_, err = repo.AuthenticateMfa(ctx, MfaAuthenticateRequest{MfaToken: tkn.MfaToken, Code: enrollment.RecoveryCodes[0]}, time.Hour, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tAuthenticateMfa recovery code failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticateMfa recovery code ok.", tests.Success)
+
+		// Ensure the challenge is no longer valid once it has been completed.
+		_, err = repo.AuthenticateMfa(ctx, MfaAuthenticateRequest{MfaToken: tkn.MfaToken, Code: enrollment.RecoveryCodes[1]}, time.Hour, now)
+		if errors.Cause(err) != ErrAuthenticationFailure {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrAuthenticationFailure)
+			t.Fatalf("\t%s\tAuthenticateMfa completed challenge failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticateMfa completed challenge ok.", tests.Success)
+
+		// Ensure the challenge is no longer valid after the max number of attempts, even with a valid code.
+		tkn, err = repo.Authenticate(ctx, authReq, time.Hour, now)
+		if errors.Cause(err) != ErrMfaRequired {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrMfaRequired)
+			t.Fatalf("\t%s\tAuthenticate mfa required failed.", tests.Failed)
+		}
+		for i := 0; i < user.MfaChallengeMaxAttempts; i++ {
+			_, err = repo.AuthenticateMfa(ctx, MfaAuthenticateRequest{MfaToken: tkn.MfaToken, Code: "000000"}, time.Hour, now)
+			if errors.Cause(err) != ErrAuthenticationFailure {
+				t.Logf("\t\tGot : %+v", err)
+				t.Logf("\t\tWant: %+v", ErrAuthenticationFailure)
+				t.Fatalf("\t%s\tAuthenticateMfa invalid code failed.", tests.Failed)
+			}
+		}
+
+		later := now.Add(time.Minute)
+		code, err = totp.GenerateCode(enrollment.Secret, later)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tGenerateCode failed.", tests.Failed)
+		}
+		_, err = repo.AuthenticateMfa(ctx, MfaAuthenticateRequest{MfaToken: tkn.MfaToken, Code: code}, time.Hour, later)
+		if errors.Cause(err) != ErrAuthenticationFailure {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrAuthenticationFailure)
+			t.Fatalf("\t%s\tAuthenticateMfa max attempts failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticateMfa max attempts ok.", tests.Success)
+	}
+}
+
+// TestAuthenticateEmailVerification validates users are unable to authenticate until their email address has been
+// verified when required by the account.
+func TestAuthenticateEmailVerification(t *testing.T) {
+	defer tests.Recover(t)
+
+	t.Log("Given the need to require users to verify their email address before authenticating")
+	{
+		ctx := tests.Context()
+
+		now := time.Now()
+
+		// Create a new user for testing.
+		usrAcc, err := user_account.MockUserAccount(ctx, test.MasterDB, now, user_account.UserAccountRole_Admin)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate user account failed.", tests.Failed)
+		}
+
+		authReq := AuthenticateRequest{
+			Email:    usrAcc.User.Email,
+			Password: usrAcc.User.Password,
+		}
+
+		// Ensure an unverified user can authenticate when the account does not require verification.
+		_, err = repo.Authenticate(ctx, authReq, time.Hour, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tAuthenticate without verification required failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticate without verification required ok.", tests.Success)
+
+		// Require email verification for the account.
+		err = repo.AccountPreference.Set(ctx, auth.Claims{}, account_preference.AccountPreferenceSetRequest{
+			AccountID: usrAcc.AccountID,
+			Name:      account_preference.AccountPreference_Email_Verification_Required,
+			Value:     "true",
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tSet account preference failed.", tests.Failed)
+		}
+
+		// Ensure the unverified user is rejected.
+		_, err = repo.Authenticate(ctx, authReq, time.Hour, now)
+		if errors.Cause(err) != ErrEmailNotVerified {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrEmailNotVerified)
+			t.Fatalf("\t%s\tAuthenticate email not verified failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticate email not verified ok.", tests.Success)
+
+		// Verify the email address for the user.
+		verifyHash, err := repo.User.VerifyEmail(ctx, user.UserVerifyEmailRequest{Email: usrAcc.User.Email}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tVerifyEmail failed.", tests.Failed)
+		}
+		_, err = repo.User.VerifyEmailConfirm(ctx, user.UserVerifyEmailConfirmRequest{VerifyHash: verifyHash}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tVerifyEmailConfirm failed.", tests.Failed)
+		}
+
+		// Ensure the verified user can now authenticate.
+		_, err = repo.Authenticate(ctx, authReq, time.Hour, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tAuthenticate email verified failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tAuthenticate email verified ok.", tests.Success)
+	}
+}
+
 // rolesStringSlice converts a list of roles to a string slice.
 func rolesStringSlice(roles []user_account.UserAccountRole) []string {
 	var l []string
@@ -0,0 +1,188 @@
+package user_auth
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/bruteforce"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"geeks-accelerator/oss/saas-starter-kit/internal/user"
+
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+var (
+	// ErrMfaRequired occurs when a user has authenticated with their password but
+	// still needs to complete the two-factor authentication challenge.
+	ErrMfaRequired = errors.New("Two-factor authentication required")
+
+	// ErrMfaEnrollmentRequired occurs when a user has authenticated with their password
+	// but one of their accounts requires two-factor authentication and the user has not
+	// enrolled yet.
+	ErrMfaEnrollmentRequired = errors.New("Two-factor authentication enrollment required")
+)
+
+const (
+	// MfaChallengeExpiration is the amount of time a user has to complete the
+	// two-factor authentication challenge after entering their password.
+	MfaChallengeExpiration = time.Minute * 10
+)
+
+// AuthenticateMfa completes the two-factor authentication challenge issued by
+// Authenticate. On success it returns a Token that can be used to authenticate
+// access to the application in the future.
+func (repo *Repository) AuthenticateMfa(ctx context.Context, req MfaAuthenticateRequest, expires time.Duration, now time.Time) (Token, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.AuthenticateMfa")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return Token{}, err
+	}
+
+	hash, err := repo.parseMfaToken(ctx, req.MfaToken, now)
+	if err != nil {
+		return Token{}, err
+	}
+
+	// Block repeated invalid codes from the same IP address or for the same user from the same IP address, so new
+	// challenges can't be used to keep guessing codes.
+	ipKey, clientKey := bruteforce.ClientKeys(ctx, "mfa", hash.UserID)
+	if err := repo.Limiter.Allow(ctx, ipKey, clientKey); err != nil {
+		return Token{}, errors.WithMessagef(err, "mfa for user %s blocked", hash.UserID)
+	}
+
+	// Each challenge only allows a limited number of codes to be tried so the code can't be brute-forced.
+	err = repo.User.MfaChallengeAttempt(ctx, hash)
+	if err != nil {
+		if errors.Cause(err) == user.ErrMfaChallengeInvalid {
+			err = errors.WithMessage(ErrAuthenticationFailure, err.Error())
+		}
+		return Token{}, err
+	}
+
+	// Verifying the first code after enrollment enables two-factor authentication
+	// for the user which allows an enrollment required by an account to be
+	// completed with the same challenge.
+	err = repo.User.MfaVerify(ctx, auth.Claims{}, user.UserMfaVerifyRequest{
+		ID:   hash.UserID,
+		Code: req.Code,
+	}, now)
+	if err != nil {
+		switch errors.Cause(err) {
+		case user.ErrMfaInvalidCode, user.ErrMfaNotEnrolled, user.ErrNotFound:
+			for _, k := range []string{ipKey, clientKey} {
+				if _, err := repo.Limiter.Fail(ctx, k); err != nil {
+					return Token{}, err
+				}
+			}
+			err = errors.WithMessage(ErrAuthenticationFailure, err.Error())
+		}
+		return Token{}, err
+	}
+
+	// The code is valid, clear the failed attempts for the user from the IP address.
+	if err := repo.Limiter.Reset(ctx, clientKey); err != nil {
+		return Token{}, err
+	}
+
+	// The challenge can only be completed once.
+	err = repo.User.MfaChallengeComplete(ctx, hash)
+	if err != nil {
+		if errors.Cause(err) == user.ErrMfaChallengeInvalid {
+			err = errors.WithMessage(ErrAuthenticationFailure, err.Error())
+		}
+		return Token{}, err
+	}
+
+	var scopes []string
+	if hash.Scope != "" {
+		scopes = strings.Split(hash.Scope, ",")
+	}
+
+	// The user is successfully authenticated with the supplied password and code.
+	return repo.generateToken(ctx, auth.Claims{}, hash.UserID, hash.AccountID, expires, now, scopes...)
+}
+
+// MfaEnroll starts two-factor authentication enrollment for a user that has
+// authenticated with their password but is required to enroll by one of their
+// accounts. The enrollment is completed with AuthenticateMfa.
+func (repo *Repository) MfaEnroll(ctx context.Context, req MfaEnrollRequest, now time.Time) (*user.UserMfaEnrollment, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.MfaEnroll")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := repo.parseMfaToken(ctx, req.MfaToken, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.User.MfaEnroll(ctx, auth.Claims{}, user.UserMfaEnrollRequest{ID: hash.UserID}, now)
+}
+
+// mfaChallenge returns a Token with only the MfaToken set and an error when the
+// user must complete two-factor authentication before a token can be issued.
+func (repo *Repository) mfaChallenge(ctx context.Context, u *user.User, accountID string, now time.Time, scopes ...string) (Token, error) {
+	var challengeErr error
+	if u.MfaEnabled() {
+		challengeErr = ErrMfaRequired
+	} else {
+		required, err := repo.mfaRequired(ctx, u.ID, accountID)
+		if err != nil {
+			return Token{}, err
+		} else if !required {
+			return Token{}, nil
+		}
+		challengeErr = ErrMfaEnrollmentRequired
+	}
+
+	var requestIp string
+	if vals, _ := webcontext.ContextValues(ctx); vals != nil {
+		requestIp = vals.RequestIP
+	}
+
+	mfaToken, err := repo.User.NewMfaHash(ctx, u.ID, accountID, strings.Join(scopes, ","), requestIp, MfaChallengeExpiration, now)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return Token{
+		MfaToken:  mfaToken,
+		UserID:    u.ID,
+		AccountID: accountID,
+	}, errors.WithStack(challengeErr)
+}
+
+// mfaRequired determines if any of the active accounts for the user requires
+// two-factor authentication. When an account ID is provided, only that account
+// is checked.
+func (repo *Repository) mfaRequired(ctx context.Context, userID, accountID string) (bool, error) {
+	return repo.accountPreferenceEnabled(ctx, account_preference.AccountPreference_Mfa_Required, userID, accountID)
+}
+
+// parseMfaToken decrypts the token issued with the two-factor authentication
+// challenge. Only expired tokens are reported, any other failure is returned as
+// an authentication failure.
+func (repo *Repository) parseMfaToken(ctx context.Context, mfaToken string, now time.Time) (*user.MfaHash, error) {
+	hash, err := repo.User.ParseMfaHash(ctx, mfaToken, now)
+	if err != nil {
+		if errors.Cause(err) == user.ErrMfaExpired {
+			return nil, err
+		}
+		return nil, errors.WithStack(ErrAuthenticationFailure)
+	}
+
+	return hash, nil
+}
@@ -1,13 +1,18 @@
 package user_auth
 
 import (
+	"context"
+	"database/sql"
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/account/account_preference"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/bruteforce"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user"
 	"geeks-accelerator/oss/saas-starter-kit/internal/user_account"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 // Repository defines the required dependencies for User Auth.
@@ -17,6 +22,13 @@ type Repository struct {
 	User              *user.Repository
 	UserAccount       *user_account.Repository
 	AccountPreference *account_preference.Repository
+	Limiter           *bruteforce.Limiter
+	Sso               SsoEnforcer
+}
+
+// SsoEnforcer reports when a user must login through the single sign-on of their account instead of a password.
+type SsoEnforcer interface {
+	SsoRequired(ctx context.Context, email string) (bool, error)
 }
 
 // NewRepository creates a new Repository that defines dependencies for User Auth.
@@ -42,8 +54,154 @@ type OAuth2PasswordRequest struct {
 	Username  string   `json:"username" schema:"username" validate:"required,email" example:"gabi.may@geeksinthewoods.com"`
 	Password  string   `json:"password" schema:"password" validate:"required" example:"NeverTellSecret"`
 	AccountID string   `json:"account_id" schema:"account_id" validate:"omitempty,uuid" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
-	Scope     []string `json:"scope" schema:"scope" validate:"omitempty,dive,oneof=admin user" enums:"admin,user" swaggertype:"array,string" example:"admin"`
-	// GrantType string `json:"grant_type" validate:"omitempty" example:"password"`
+	Scope     []string `json:"scope" schema:"scope" validate:"omitempty,dive,role" swaggertype:"array,string" example:"admin"`
+	GrantType string   `json:"grant_type" schema:"grant_type" validate:"omitempty,eq=password" example:"password"`
+}
+
+// OAuth2RefreshTokenRequest defines what information is required to exchange a refresh token for a new access token.
+type OAuth2RefreshTokenRequest struct {
+	GrantType    string   `json:"grant_type" schema:"grant_type" validate:"required,eq=refresh_token" example:"refresh_token"`
+	RefreshToken string   `json:"refresh_token" schema:"refresh_token" validate:"required" example:"bm90IGEgcmVhbCB0b2tlbg"`
+	Scope        []string `json:"scope" schema:"scope" validate:"omitempty,dive,role" swaggertype:"array,string" example:"admin"`
+}
+
+// OAuth2RevokeRequest defines what information is required to revoke a token.
+type OAuth2RevokeRequest struct {
+	Token         string `json:"token" schema:"token" validate:"required" example:"bm90IGEgcmVhbCB0b2tlbg"`
+	TokenTypeHint string `json:"token_type_hint" schema:"token_type_hint" validate:"omitempty,oneof=access_token refresh_token" enums:"access_token,refresh_token" example:"refresh_token"`
+}
+
+// OAuth2MfaRequest defines what information is required to complete a two-factor authentication challenge.
+type OAuth2MfaRequest struct {
+	GrantType string `json:"grant_type" schema:"grant_type" validate:"required,eq=mfa_otp" example:"mfa_otp"`
+	MfaToken  string `json:"mfa_token" schema:"mfa_token" validate:"required" example:"c2VjcmV0IG1mYSB0b2tlbg"`
+	Otp       string `json:"otp" schema:"otp" validate:"required" example:"287082"`
+}
+
+// MfaAuthenticateRequest defines what information is required to complete a two-factor authentication challenge.
+type MfaAuthenticateRequest struct {
+	MfaToken string `json:"mfa_token" validate:"required" example:"c2VjcmV0IG1mYSB0b2tlbg"`
+	Code     string `json:"code" validate:"required" example:"287082"`
+}
+
+// MfaEnrollRequest defines what information is required to enroll in two-factor authentication when
+// an account requires it before the user is able to authenticate.
+type MfaEnrollRequest struct {
+	MfaToken string `json:"mfa_token" validate:"required" example:"c2VjcmV0IG1mYSB0b2tlbg"`
+}
+
+// MfaChallenge is the payload we deliver to users when they authenticate with their password but still need to
+// complete two-factor authentication. The MfaToken is exchanged for a Token with the grant type mfa_otp.
+type MfaChallenge struct {
+	Error            string `json:"error" example:"mfa_required"`
+	ErrorDescription string `json:"error_description" example:"Two-factor authentication required"`
+	MfaToken         string `json:"mfa_token" example:"c2VjcmV0IG1mYSB0b2tlbg"`
+}
+
+// RefreshRequest defines what information is required to refresh an access token.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required" example:"bm90IGEgcmVhbCB0b2tlbg"`
+}
+
+// RevokeRequest defines what information is required to revoke a session by
+// either a refresh token or an access token.
+type RevokeRequest struct {
+	Token         string `json:"token" validate:"required" example:"bm90IGEgcmVhbCB0b2tlbg"`
+	TokenTypeHint string `json:"token_type_hint" validate:"omitempty,oneof=access_token refresh_token" example:"refresh_token"`
+}
+
+// RefreshToken is a persisted refresh token. Only the hash of the token is
+// stored. Every refresh token belongs to a session which is shared by all the
+// tokens issued by rotation from the original authentication.
+type RefreshToken struct {
+	ID            string         `json:"id"`
+	SessionID     string         `json:"session_id"`
+	ReplacedByID  sql.NullString `json:"replaced_by_id"`
+	UserID        string         `json:"user_id"`
+	AccountID     string         `json:"account_id"`
+	RootUserID    sql.NullString `json:"root_user_id"`
+	RootAccountID sql.NullString `json:"root_account_id"`
+	TokenHash     string         `json:"-"`
+	Scopes        pq.StringArray `json:"scopes"`
+	CreatedAt     time.Time      `json:"created_at"`
+	ExpiresAt     time.Time      `json:"expires_at"`
+	UsedAt        pq.NullTime    `json:"used_at"`
+	RevokedAt     pq.NullTime    `json:"revoked_at"`
+}
+
+// Session is a persisted session of a user. A session is started each time the user authenticates and is shared by
+// all the tokens issued for it, ie when refreshing or switching accounts. The request IP and user agent are updated
+// as the session is used.
+type Session struct {
+	ID         string         `json:"id"`
+	UserID     string         `json:"user_id"`
+	AccountID  string         `json:"account_id"`
+	RootUserID sql.NullString `json:"root_user_id"`
+	IPAddress  string         `json:"ip_address"`
+	UserAgent  string         `json:"user_agent"`
+	Device     string         `json:"device"`
+	CreatedAt  time.Time      `json:"created_at"`
+	LastSeenAt time.Time      `json:"last_seen_at"`
+	RevokedAt  *pq.NullTime   `json:"revoked_at,omitempty"`
+}
+
+// SessionResponse represents a session that is returned for display.
+type SessionResponse struct {
+	ID         string           `json:"id" example:"b6a4ba4c-2ab6-4c4a-9e06-6a2b2d5b93a2"`
+	AccountID  string           `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	IPAddress  string           `json:"ip_address" example:"68.69.35.104"`
+	UserAgent  string           `json:"user_agent" example:"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/76.0.3809.100 Safari/537.36"`
+	Device     string           `json:"device" example:"Chrome on macOS"`
+	Current    bool             `json:"current" example:"true"`  // Current is true for the session of the request.
+	Virtual    bool             `json:"virtual" example:"false"` // Virtual is true when the session was started by another user with virtual login.
+	CreatedAt  web.TimeResponse `json:"created_at"`              // CreatedAt contains multiple format options for display.
+	LastSeenAt web.TimeResponse `json:"last_seen_at"`            // LastSeenAt contains multiple format options for display.
+}
+
+// Response transforms Session and SessionResponse that is used for display. The current session ID is the session
+// of the request.
+func (m *Session) Response(ctx context.Context, currentSessionID string) *SessionResponse {
+	if m == nil {
+		return nil
+	}
+
+	return &SessionResponse{
+		ID:         m.ID,
+		AccountID:  m.AccountID,
+		IPAddress:  m.IPAddress,
+		UserAgent:  m.UserAgent,
+		Device:     m.Device,
+		Current:    m.ID == currentSessionID,
+		Virtual:    m.RootUserID.Valid && m.RootUserID.String != "" && m.RootUserID.String != m.UserID,
+		CreatedAt:  web.NewTimeResponse(ctx, m.CreatedAt),
+		LastSeenAt: web.NewTimeResponse(ctx, m.LastSeenAt),
+	}
+}
+
+// Sessions a list of Sessions.
+type Sessions []*Session
+
+// Response transforms a list of Sessions to a list of SessionResponses.
+func (m *Sessions) Response(ctx context.Context, currentSessionID string) []*SessionResponse {
+	var l []*SessionResponse
+	if m != nil && len(*m) > 0 {
+		for _, n := range *m {
+			l = append(l, n.Response(ctx, currentSessionID))
+		}
+	}
+
+	return l
+}
+
+// SessionRevokeRequest defines the information needed to revoke a session of the current user.
+type SessionRevokeRequest struct {
+	ID string `json:"id" validate:"required,uuid" example:"b6a4ba4c-2ab6-4c4a-9e06-6a2b2d5b93a2"`
+}
+
+// SessionRevokeAllRequest defines the information needed to revoke all the sessions of the current user. When
+// ExceptCurrent is set the session of the request remains active.
+type SessionRevokeAllRequest struct {
+	ExceptCurrent bool `json:"except_current" example:"true"`
 }
 
 // Token is the payload we deliver to users when they authenticate.
@@ -61,8 +219,15 @@ type Token struct {
 	// mechanisms for that TokenSource will not be used.
 	Expiry time.Time     `json:"expiry,omitempty"`
 	TTL    time.Duration `json:"ttl,omitempty"`
+	// RefreshToken is used to obtain a new access token once the current one
+	// expires. Each refresh token can only be used once.
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// MfaToken is returned instead of an access token when the user must
+	// complete a two-factor authentication challenge before being authenticated.
+	MfaToken string `json:"mfa_token,omitempty"`
 	// contains filtered or unexported fields
-	claims auth.Claims `json:"-"`
+	claims         auth.Claims `json:"-"`
+	refreshTokenID string      `json:"-"`
 	// UserId is the ID of the user authenticated.
 	UserID string `json:"user_id" example:"d69bdef7-173f-4d29-b52c-3edc60baf6a2"`
 	// AccountID is the ID of the account for the user authenticated.
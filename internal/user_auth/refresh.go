@@ -0,0 +1,372 @@
+package user_auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/lib/pq"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+var (
+	// ErrSessionRevoked occurs when a token is used for a session that has been revoked.
+	ErrSessionRevoked = errors.New("Session has been revoked")
+
+	// ErrRefreshTokenReused occurs when a refresh token that has already been exchanged
+	// is presented again. The entire session is revoked when this happens.
+	ErrRefreshTokenReused = errors.New("Refresh token has already been used")
+)
+
+// RefreshTokenExpiration defines how long a refresh token can be used before the
+// user is required to authenticate again.
+var RefreshTokenExpiration = time.Hour * 24 * 30
+
+const (
+	// The database table for Refresh Token
+	refreshTokenTableName = "refresh_tokens"
+)
+
+// refreshTokenMapColumns is the list of columns needed for mapRowsToRefreshToken
+var refreshTokenMapColumns = "id,session_id,replaced_by_id,user_id,account_id,root_user_id,root_account_id,token_hash,scopes,created_at,expires_at,used_at,revoked_at"
+
+// mapRowsToRefreshToken takes the SQL rows and maps it to the RefreshToken struct
+// with the columns defined by refreshTokenMapColumns
+func mapRowsToRefreshToken(rows *sql.Rows) (*RefreshToken, error) {
+	var (
+		m   RefreshToken
+		err error
+	)
+	err = rows.Scan(&m.ID, &m.SessionID, &m.ReplacedByID, &m.UserID, &m.AccountID, &m.RootUserID, &m.RootAccountID,
+		&m.TokenHash, &m.Scopes, &m.CreatedAt, &m.ExpiresAt, &m.UsedAt, &m.RevokedAt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &m, nil
+}
+
+// Refresh exchanges a refresh token for a new access token. Refresh tokens are rotated, the supplied token is
+// marked as used and a new refresh token is returned. When a refresh token is presented a second time the
+// session is assumed to be compromised and all the tokens issued for the session are revoked.
+func (repo *Repository) Refresh(ctx context.Context, req RefreshRequest, expires time.Duration, now time.Time, scopes ...string) (Token, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.Refresh")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return Token{}, err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	rt, err := repo.readRefreshTokenByHash(ctx, hashRefreshToken(req.RefreshToken))
+	if err != nil {
+		return Token{}, err
+	}
+
+	if rt.RevokedAt.Valid && !rt.RevokedAt.Time.IsZero() {
+		return Token{}, errors.WithMessage(ErrAuthenticationFailure, ErrSessionRevoked.Error())
+	} else if rt.UsedAt.Valid && !rt.UsedAt.Time.IsZero() {
+		// The token has already been exchanged, revoke the entire session.
+		if err := repo.revokeSession(ctx, rt.SessionID, now); err != nil {
+			return Token{}, err
+		}
+		return Token{}, errors.WithStack(ErrRefreshTokenReused)
+	} else if rt.ExpiresAt.Before(now) {
+		return Token{}, errors.WithMessage(ErrAuthenticationFailure, "refresh token has expired")
+	}
+
+	// Default the scopes to the ones requested when the session was created.
+	if len(scopes) == 0 || scopes[0] == "" {
+		scopes = rt.Scopes
+	}
+
+	claims := auth.Claims{
+		RootUserID:    rt.RootUserID.String,
+		RootAccountID: rt.RootAccountID.String,
+		SessionID:     rt.SessionID,
+	}
+
+	// Generate a new token for the session. Roles are loaded again from the database, so any changes
+	// to the user account since the session was created are reflected.
+	tkn, err := repo.generateToken(ctx, claims, rt.UserID, rt.AccountID, expires, now, scopes...)
+	if err != nil {
+		return Token{}, err
+	}
+
+	// Mark the supplied refresh token as used. The update is conditional so when two requests try to
+	// exchange the same token concurrently only one of them succeeds.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(refreshTokenTableName)
+	query.Set(
+		query.Assign("used_at", now),
+		query.Assign("replaced_by_id", tkn.refreshTokenID),
+	)
+	query.Where(query.And(
+		query.Equal("id", rt.ID),
+		query.IsNull("used_at"),
+		query.IsNull("revoked_at"),
+	))
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	res, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "mark refresh token %s used failed", rt.ID)
+		return Token{}, err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		if err := repo.revokeSession(ctx, rt.SessionID, now); err != nil {
+			return Token{}, err
+		}
+		return Token{}, errors.WithStack(ErrRefreshTokenReused)
+	}
+
+	return tkn, nil
+}
+
+// Revoke revokes the session associated with either a refresh token or an access token. Following RFC 7009
+// no error is returned when the token is invalid or the session was already revoked.
+func (repo *Repository) Revoke(ctx context.Context, req RevokeRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.Revoke")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	var sessionID string
+	if req.TokenTypeHint != "access_token" {
+		rt, err := repo.readRefreshTokenByHash(ctx, hashRefreshToken(req.Token))
+		if err == nil {
+			sessionID = rt.SessionID
+		} else if errors.Cause(err) != ErrAuthenticationFailure {
+			return err
+		}
+	}
+
+	if sessionID == "" {
+		claims, err := repo.TknGen.ParseClaims(req.Token)
+		if err != nil {
+			// The token is invalid, there is nothing to revoke.
+			return nil
+		}
+		sessionID = claims.SessionID
+	}
+
+	if sessionID == "" {
+		return nil
+	}
+
+	return repo.revokeSession(ctx, sessionID, now)
+}
+
+// RevokeSession revokes the session for the supplied claims, used when the user logs out.
+func (repo *Repository) RevokeSession(ctx context.Context, claims auth.Claims, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.RevokeSession")
+	defer span.Finish()
+
+	if claims.SessionID == "" {
+		return nil
+	}
+
+	return repo.revokeSession(ctx, claims.SessionID, now)
+}
+
+// ValidateSession implements the auth.SessionValidator interface. It returns an error when the session
+// for the claims has been revoked. The last seen timestamp of the session is updated as it is used.
+func (repo *Repository) ValidateSession(ctx context.Context, claims auth.Claims) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.ValidateSession")
+	defer span.Finish()
+
+	if claims.SessionID == "" {
+		return nil
+	}
+
+	sess, err := repo.readSession(ctx, claims.SessionID)
+	if err != nil {
+		if errors.Cause(err) != ErrSessionNotFound {
+			return err
+		}
+
+		// Sessions are persisted before any token is issued for them, so an unknown session is for an access token
+		// issued before sessions were tracked. The session is backfilled so the token stays valid until it expires.
+		sess, err = repo.backfillSession(ctx, claims)
+		if err != nil {
+			return err
+		}
+	}
+
+	if sess.RevokedAt != nil && sess.RevokedAt.Valid && !sess.RevokedAt.Time.IsZero() {
+		return errors.WithStack(ErrSessionRevoked)
+	}
+
+	// Track when the session was last used, limited to once per interval to avoid a write for every request.
+	now := time.Now().UTC()
+	if now.Sub(sess.LastSeenAt) >= lastSeenInterval {
+		if err := repo.touchSession(ctx, sess.ID, "", now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createRefreshToken persists a new refresh token for the session defined by the claims and returns the ID of the
+// entry with the raw token. Only a hash of the token is stored.
+func (repo *Repository) createRefreshToken(ctx context.Context, claims auth.Claims, scopes []string, now time.Time) (string, string, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.createRefreshToken")
+	defer span.Finish()
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", errors.WithStack(err)
+	}
+	tknStr := base64.RawURLEncoding.EncodeToString(b)
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	rt := RefreshToken{
+		ID:        uuid.NewRandom().String(),
+		SessionID: claims.SessionID,
+		UserID:    claims.Subject,
+		AccountID: claims.Audience,
+		TokenHash: hashRefreshToken(tknStr),
+		Scopes:    pq.StringArray(scopes),
+		CreatedAt: now,
+		ExpiresAt: now.Add(RefreshTokenExpiration),
+	}
+	if claims.RootUserID != "" {
+		rt.RootUserID = sql.NullString{String: claims.RootUserID, Valid: true}
+	}
+	if claims.RootAccountID != "" {
+		rt.RootAccountID = sql.NullString{String: claims.RootAccountID, Valid: true}
+	}
+
+	// Build the insert SQL statement.
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(refreshTokenTableName)
+	query.Cols("id", "session_id", "user_id", "account_id", "root_user_id", "root_account_id", "token_hash", "scopes",
+		"created_at", "expires_at")
+	query.Values(rt.ID, rt.SessionID, rt.UserID, rt.AccountID, rt.RootUserID, rt.RootAccountID, rt.TokenHash, rt.Scopes,
+		rt.CreatedAt, rt.ExpiresAt)
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "create refresh token failed")
+		return "", "", err
+	}
+
+	return rt.ID, tknStr, nil
+}
+
+// readRefreshTokenByHash loads the refresh token for the supplied hash.
+func (repo *Repository) readRefreshTokenByHash(ctx context.Context, tokenHash string) (*RefreshToken, error) {
+	query := sqlbuilder.NewSelectBuilder().Select(refreshTokenMapColumns).From(refreshTokenTableName)
+	query.Where(query.Equal("token_hash", tokenHash))
+
+	queryStr, queryArgs := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+	rows, err := repo.DbConn.QueryContext(ctx, queryStr, queryArgs...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+		return nil, errors.WithMessage(ErrAuthenticationFailure, "refresh token not found")
+	}
+
+	return mapRowsToRefreshToken(rows)
+}
+
+// revokeSession revokes the session and all the refresh tokens issued for it.
+func (repo *Repository) revokeSession(ctx context.Context, sessionID string, now time.Time) error {
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Revoke the session and all the refresh tokens issued for it.
+	for _, q := range []struct {
+		table string
+		col   string
+	}{
+		{sessionTableName, "id"},
+		{refreshTokenTableName, "session_id"},
+	} {
+		query := sqlbuilder.NewUpdateBuilder()
+		query.Update(q.table)
+		query.Set(query.Assign("revoked_at", now))
+		query.Where(query.And(
+			query.Equal(q.col, sessionID),
+			query.IsNull("revoked_at"),
+		))
+
+		sql, args := query.Build()
+		sql = repo.DbConn.Rebind(sql)
+		_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "revoke session %s failed", sessionID)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hashRefreshToken returns the hex encoded SHA-256 hash of a refresh token.
+func hashRefreshToken(tknStr string) string {
+	h := sha256.Sum256([]byte(tknStr))
+	return hex.EncodeToString(h[:])
+}
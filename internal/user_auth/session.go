@@ -0,0 +1,399 @@
+package user_auth
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+var (
+	// ErrSessionNotFound occurs when a session does not exist or belongs to another user.
+	ErrSessionNotFound = errors.New("Session not found")
+)
+
+const (
+	// The database table for Session
+	sessionTableName = "user_sessions"
+
+	// lastSeenInterval limits how often the last seen timestamp is updated for a session.
+	lastSeenInterval = time.Minute
+)
+
+// sessionMapColumns is the list of columns needed for mapRowsToSession
+var sessionMapColumns = "id,user_id,account_id,root_user_id,ip_address,user_agent,device,created_at,last_seen_at,revoked_at"
+
+// mapRowsToSession takes the SQL rows and maps it to the Session struct
+// with the columns defined by sessionMapColumns
+func mapRowsToSession(rows *sql.Rows) (*Session, error) {
+	var (
+		m   Session
+		err error
+	)
+	err = rows.Scan(&m.ID, &m.UserID, &m.AccountID, &m.RootUserID, &m.IPAddress, &m.UserAgent, &m.Device,
+		&m.CreatedAt, &m.LastSeenAt, &m.RevokedAt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &m, nil
+}
+
+// SessionFind returns the active sessions of the current user ordered by the most recently used. Sessions that have
+// not been used since the last refresh token issued for them expired are excluded.
+func (repo *Repository) SessionFind(ctx context.Context, claims auth.Claims, now time.Time) (Sessions, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.SessionFind")
+	defer span.Finish()
+
+	if claims.Subject == "" {
+		return nil, errors.WithStack(ErrForbidden)
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	query := sqlbuilder.NewSelectBuilder().Select(sessionMapColumns).From(sessionTableName)
+	query.Where(query.And(
+		query.Equal("user_id", claims.Subject),
+		query.IsNull("revoked_at"),
+		query.GreaterEqualThan("last_seen_at", now.UTC().Add(RefreshTokenExpiration*-1)),
+	))
+	query.OrderBy("last_seen_at desc")
+
+	queryStr, queryArgs := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+	rows, err := repo.DbConn.QueryContext(ctx, queryStr, queryArgs...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find sessions failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over each row.
+	resp := Sessions{}
+	for rows.Next() {
+		m, err := mapRowsToSession(rows)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+		resp = append(resp, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find sessions failed")
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// SessionRevoke revokes a session of the current user. All the tokens issued for the session are rejected afterwards.
+func (repo *Repository) SessionRevoke(ctx context.Context, claims auth.Claims, req SessionRevokeRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.SessionRevoke")
+	defer span.Finish()
+
+	if claims.Subject == "" {
+		return errors.WithStack(ErrForbidden)
+	}
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	// Users can only revoke their own sessions.
+	sess, err := repo.readSession(ctx, req.ID)
+	if err != nil {
+		return err
+	} else if sess.UserID != claims.Subject {
+		return errors.WithMessagef(ErrSessionNotFound, "session %s", req.ID)
+	}
+
+	return repo.revokeSession(ctx, sess.ID, now)
+}
+
+// SessionRevokeAll revokes all the sessions of the current user, logging them out everywhere. The session of the
+// request is kept active when ExceptCurrent is set.
+func (repo *Repository) SessionRevokeAll(ctx context.Context, claims auth.Claims, req SessionRevokeAllRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.SessionRevokeAll")
+	defer span.Finish()
+
+	if claims.Subject == "" {
+		return errors.WithStack(ErrForbidden)
+	}
+
+	query := sqlbuilder.NewSelectBuilder().Select("id").From(sessionTableName)
+	query.Where(query.And(
+		query.Equal("user_id", claims.Subject),
+		query.IsNull("revoked_at"),
+	))
+	if req.ExceptCurrent && claims.SessionID != "" {
+		query.Where(query.NotEqual("id", claims.SessionID))
+	}
+
+	queryStr, queryArgs := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var sessionIDs []string
+	err := repo.DbConn.SelectContext(ctx, &sessionIDs, queryStr, queryArgs...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "find sessions for user %s failed", claims.Subject)
+		return err
+	}
+
+	for _, sessionID := range sessionIDs {
+		if err := repo.revokeSession(ctx, sessionID, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createSession persists a new session for the claims with the details of the request that started it.
+func (repo *Repository) createSession(ctx context.Context, claims auth.Claims, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.createSession")
+	defer span.Finish()
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	m := Session{
+		ID:         claims.SessionID,
+		UserID:     claims.Subject,
+		AccountID:  claims.Audience,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	if claims.RootUserID != "" {
+		m.RootUserID = sql.NullString{String: claims.RootUserID, Valid: true}
+	}
+	if vals, _ := webcontext.ContextValues(ctx); vals != nil {
+		m.IPAddress = truncate(vals.RequestIP, 45)
+		m.UserAgent = truncate(vals.UserAgent, 512)
+		m.Device = SessionDevice(vals.UserAgent)
+	}
+
+	// Build the insert SQL statement.
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(sessionTableName)
+	query.Cols("id", "user_id", "account_id", "root_user_id", "ip_address", "user_agent", "device", "created_at", "last_seen_at")
+	query.Values(m.ID, m.UserID, m.AccountID, m.RootUserID, m.IPAddress, m.UserAgent, m.Device, m.CreatedAt, m.LastSeenAt)
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "create session failed")
+		return err
+	}
+
+	return nil
+}
+
+// backfillSession creates the session for an access token issued before sessions were tracked so it can be listed
+// and revoked. The token is rejected when the user has been archived or any session of the user has been revoked since
+// the token was issued, ie by logging out everywhere, since the revocation could not include the untracked session.
+func (repo *Repository) backfillSession(ctx context.Context, claims auth.Claims) (*Session, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.backfillSession")
+	defer span.Finish()
+
+	if claims.IssuedAt == 0 || claims.Subject == "" || claims.Audience == "" {
+		return nil, errors.WithMessagef(ErrSessionRevoked, "session %s not found", claims.SessionID)
+	}
+	issuedAt := time.Unix(claims.IssuedAt, 0).UTC()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	var rootUserID sql.NullString
+	if claims.RootUserID != "" {
+		rootUserID = sql.NullString{String: claims.RootUserID, Valid: true}
+	}
+
+	// The checks and the insert are a single statement so a concurrent revocation can't be missed.
+	query := `INSERT INTO ` + sessionTableName + ` (id, user_id, account_id, root_user_id, created_at, last_seen_at)
+		SELECT $1, $2, $3, $4, $5, $6
+		WHERE EXISTS (SELECT 1 FROM users WHERE id = $2 AND archived_at IS NULL)
+			AND EXISTS (SELECT 1 FROM accounts WHERE id = $3 AND archived_at IS NULL)
+			AND NOT EXISTS (SELECT 1 FROM ` + sessionTableName + ` WHERE revoked_at >= $5
+				AND (user_id IN ($2, $4) OR root_user_id IN ($2, $4)))
+		ON CONFLICT (id) DO NOTHING`
+	_, err := repo.DbConn.ExecContext(ctx, query, claims.SessionID, claims.Subject, claims.Audience, rootUserID, issuedAt, now)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query)
+		err = errors.WithMessagef(err, "backfill session %s failed", claims.SessionID)
+		return nil, err
+	}
+
+	sess, err := repo.readSession(ctx, claims.SessionID)
+	if err != nil {
+		if errors.Cause(err) == ErrSessionNotFound {
+			return nil, errors.WithMessage(ErrSessionRevoked, err.Error())
+		}
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+// touchSession updates the last seen timestamp and IP address of the session. The account is also updated when a new
+// token is issued for the session, ie when refreshing or switching accounts.
+func (repo *Repository) touchSession(ctx context.Context, sessionID, accountID string, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.user_auth.touchSession")
+	defer span.Finish()
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(sessionTableName)
+	fields := []string{
+		query.Assign("last_seen_at", now),
+	}
+	if accountID != "" {
+		fields = append(fields, query.Assign("account_id", accountID))
+	}
+	if vals, _ := webcontext.ContextValues(ctx); vals != nil && vals.RequestIP != "" {
+		fields = append(fields, query.Assign("ip_address", truncate(vals.RequestIP, 45)))
+	}
+	query.Set(fields...)
+	query.Where(query.Equal("id", sessionID))
+
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "update session %s failed", sessionID)
+		return err
+	}
+
+	return nil
+}
+
+// readSession loads the session for the supplied ID.
+func (repo *Repository) readSession(ctx context.Context, id string) (*Session, error) {
+	query := sqlbuilder.NewSelectBuilder().Select(sessionMapColumns).From(sessionTableName)
+	query.Where(query.Equal("id", id))
+
+	queryStr, queryArgs := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+	rows, err := repo.DbConn.QueryContext(ctx, queryStr, queryArgs...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+		return nil, errors.WithMessagef(ErrSessionNotFound, "session %s", id)
+	}
+
+	return mapRowsToSession(rows)
+}
+
+// SessionDevice returns a short description of the browser and operating system for a user agent,
+// ie Chrome on macOS. An empty string is returned when the user agent is not recognized.
+func SessionDevice(userAgent string) string {
+	if userAgent == "" {
+		return ""
+	}
+	ua := strings.ToLower(userAgent)
+
+	// The order matters, most browsers include the tokens of the browsers they are based on.
+	var browser string
+	switch {
+	case strings.Contains(ua, "edg/") || strings.Contains(ua, "edge/"):
+		browser = "Edge"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "firefox/") || strings.Contains(ua, "fxios/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "crios/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "msie ") || strings.Contains(ua, "trident/"):
+		browser = "Internet Explorer"
+	case strings.HasPrefix(ua, "curl/"):
+		browser = "curl"
+	}
+
+	var os string
+	switch {
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "ipod"):
+		os = "iOS"
+	case strings.Contains(ua, "android"):
+		os = "Android"
+	case strings.Contains(ua, "windows"):
+		os = "Windows"
+	case strings.Contains(ua, "mac os x") || strings.Contains(ua, "macintosh"):
+		os = "macOS"
+	case strings.Contains(ua, "cros"):
+		os = "Chrome OS"
+	case strings.Contains(ua, "linux"):
+		os = "Linux"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	}
+
+	return ""
+}
+
+// truncate limits the string to the max number of bytes supported by the column without splitting a character.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
@@ -0,0 +1,527 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// DefaultMaxAttempts is the number of times a delivery is attempted before it's marked as failed.
+	DefaultMaxAttempts = 8
+
+	// DefaultRetryBackoff is the delay before the first retry.
+	DefaultRetryBackoff = time.Minute
+
+	// deliveryBatchSize is the max number of deliveries sent by each call to DeliverPending.
+	deliveryBatchSize = 50
+
+	// deliveryLease is how long a delivery is reserved while being sent so it's not picked up by another instance.
+	deliveryLease = 5 * time.Minute
+
+	// maxResponseBody is the max number of bytes of the response body read before the connection is closed. The body
+	// is discarded, only the status is recorded.
+	maxResponseBody = 1024
+)
+
+// Headers included with each delivery.
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+var (
+	// ErrInvalidSignature occurs when the signature of a delivery does not match the payload.
+	ErrInvalidSignature = errors.New("Invalid signature")
+
+	// ErrAddressNotAllowed occurs when the endpoint of a webhook resolves to a loopback, private or link-local address.
+	ErrAddressNotAllowed = errors.New("Address not allowed")
+
+	// ErrRedirect occurs when the endpoint of a webhook responds with a redirect.
+	ErrRedirect = errors.New("Redirects are not followed")
+)
+
+// blockedNetworks are the networks deliveries are never sent to. The webhook URLs are provided by the accounts and
+// could otherwise be used to reach the internal services and the cloud metadata endpoints.
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",      // This network
+	"10.0.0.0/8",     // Private
+	"100.64.0.0/10",  // Carrier-grade NAT
+	"127.0.0.0/8",    // Loopback
+	"169.254.0.0/16", // Link-local, includes the metadata endpoint 169.254.169.254
+	"172.16.0.0/12",  // Private
+	"192.0.0.0/24",   // IETF protocol assignments
+	"192.168.0.0/16", // Private
+	"198.18.0.0/15",  // Benchmarking
+	"224.0.0.0/4",    // Multicast
+	"240.0.0.0/4",    // Reserved and broadcast
+	"::/128",         // Unspecified
+	"::1/128",        // Loopback
+	"64:ff9b::/96",   // NAT64, embeds IPv4 addresses
+	"fc00::/7",       // Unique local, includes the metadata endpoint fd00:ec2::254
+	"fe80::/10",      // Link-local
+	"ff00::/8",       // Multicast
+)
+
+// mustParseCIDRs parses the list of networks and panics when one is invalid.
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var resp []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		resp = append(resp, n)
+	}
+	return resp
+}
+
+// NewDeliveryClient returns the client used to send the deliveries. The address is checked when connecting, after the
+// host has been resolved, so a host that later resolves to a blocked address is still refused. Redirects are not
+// followed and proxies from the environment are not used since both would bypass the check.
+func NewDeliveryClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialControl,
+	}
+
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return ErrRedirect
+		},
+	}
+}
+
+// dialControl refuses connections to the blocked networks.
+func dialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !IsAllowedIP(ip) {
+		return errors.WithMessagef(ErrAddressNotAllowed, "address %s", host)
+	}
+
+	return nil
+}
+
+// IsAllowedIP determines if deliveries can be sent to the IP address.
+func IsAllowedIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookDeliveryMapColumns is the list of columns needed for find.
+var webhookDeliveryMapColumns = "id,webhook_id,account_id,event_type,payload,status,attempts,response_status,error,next_attempt_at,created_at,updated_at"
+
+// Sign returns the value of the signature header for the payload. The signature is the hex encoded HMAC-SHA256
+// of the timestamp and the payload joined by a period using the secret of the webhook.
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// VerifySignature checks the signature header against the payload. The header can contain multiple v1 signatures,
+// such as when the secret is being rolled, and is valid when any of them match. Signatures older than the tolerance
+// are rejected to prevent replay attacks, a zero tolerance disables the check.
+func VerifySignature(secret, header string, payload []byte, tolerance time.Duration, now time.Time) error {
+	var (
+		ts   int64
+		sigs []string
+	)
+	for _, p := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "v1":
+			sigs = append(sigs, kv[1])
+		}
+	}
+
+	if ts == 0 || len(sigs) == 0 {
+		return errors.WithStack(ErrInvalidSignature)
+	}
+
+	timestamp := time.Unix(ts, 0)
+	if tolerance > 0 && now.Sub(timestamp) > tolerance {
+		return errors.WithMessage(ErrInvalidSignature, "signature expired")
+	}
+
+	expected := Sign(secret, timestamp, payload)
+	for _, sig := range sigs {
+		if hmac.Equal([]byte(expected), []byte(fmt.Sprintf("t=%d,v1=%s", ts, sig))) {
+			return nil
+		}
+	}
+
+	return errors.WithStack(ErrInvalidSignature)
+}
+
+// deliveryFindRequestQuery generates the select query for the given find request.
+func deliveryFindRequestQuery(req WebhookDeliveryFindRequest) (*sqlbuilder.SelectBuilder, error) {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Select(webhookDeliveryMapColumns)
+	query.From(webhookDeliveryTableName)
+
+	if len(req.Filter) > 0 {
+		err := req.Filter.Apply(query, WebhookDeliveryFindFields)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if req.Cursor != "" {
+		err := WebhookDeliveryFindFields.ApplyCursor(query, req.Order, req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	query.Where(query.Equal("webhook_id", req.WebhookID))
+
+	if len(req.Order) > 0 {
+		query.OrderBy(req.Order...)
+	}
+
+	if req.Limit != nil {
+		query.Limit(int(*req.Limit))
+	}
+
+	if req.Offset != nil {
+		query.Offset(int(*req.Offset))
+	}
+
+	return query, nil
+}
+
+// FindDeliveries gets the delivery log for the webhook from the database based on the request params.
+func (repo *Repository) FindDeliveries(ctx context.Context, claims auth.Claims, req WebhookDeliveryFindRequest) (WebhookDeliveries, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.FindDeliveries")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the claims have access to the webhook.
+	_, err = repo.Read(ctx, claims, WebhookReadRequest{ID: req.WebhookID, IncludeArchived: true})
+	if err != nil {
+		return nil, err
+	}
+
+	// Default to the most recent deliveries first.
+	if len(req.Order) == 0 {
+		req.Order = []string{"created_at desc"}
+	}
+
+	query, err := deliveryFindRequestQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	// Fetch all entries from the db.
+	rows, err := repo.DbConn.QueryContext(ctx, queryStr, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find webhook deliveries failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over each row.
+	resp := []*WebhookDelivery{}
+	for rows.Next() {
+		var (
+			m         WebhookDelivery
+			eventType string
+			payload   []byte
+		)
+		err = rows.Scan(&m.ID, &m.WebhookID, &m.AccountID, &eventType, &payload, &m.Status, &m.Attempts, &m.ResponseStatus, &m.Error, &m.NextAttemptAt, &m.CreatedAt, &m.UpdatedAt)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+		m.EventType = EventType(eventType)
+		m.Payload = payload
+
+		resp = append(resp, &m)
+	}
+
+	return resp, nil
+}
+
+// CountDeliveries gets the total number of deliveries for the webhook that match the request params. The order,
+// limit, offset and cursor of the request are ignored.
+func (repo *Repository) CountDeliveries(ctx context.Context, claims auth.Claims, req WebhookDeliveryFindRequest) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.CountDeliveries")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return 0, err
+	}
+
+	// Ensure the claims have access to the webhook.
+	_, err = repo.Read(ctx, claims, WebhookReadRequest{ID: req.WebhookID, IncludeArchived: true})
+	if err != nil {
+		return 0, err
+	}
+
+	req.Order, req.Limit, req.Offset, req.Cursor = nil, nil, nil, ""
+
+	query, err := deliveryFindRequestQuery(req)
+	if err != nil {
+		return 0, err
+	}
+	query.Select("count(*)")
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var total int
+	err = repo.DbConn.QueryRowContext(ctx, queryStr, args...).Scan(&total)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "count webhook deliveries failed")
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// DeliverPending sends the deliveries that are due and returns the number of deliveries attempted. Each delivery is
+// reserved before it's sent so multiple instances can call DeliverPending concurrently. Failed deliveries are retried
+// with an exponential backoff until the max attempts is reached.
+func (repo *Repository) DeliverPending(ctx context.Context, now time.Time) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.DeliverPending")
+	defer span.Finish()
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// Reserve the deliveries that are due by moving the next attempt forward. Rows locked by another instance are
+	// skipped.
+	queryStr := repo.DbConn.Rebind(`UPDATE ` + webhookDeliveryTableName + ` SET next_attempt_at = ?, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM ` + webhookDeliveryTableName + ` WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at LIMIT ? FOR UPDATE SKIP LOCKED)
+		RETURNING id, webhook_id, event_type, payload, attempts`)
+
+	rows, err := repo.DbConn.QueryContext(ctx, queryStr, now.Add(deliveryLease), now, WebhookDeliveryStatus_Pending, now, deliveryBatchSize)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", queryStr)
+		err = errors.WithMessage(err, "reserve webhook deliveries failed")
+		return 0, err
+	}
+
+	var (
+		deliveries []*WebhookDelivery
+		hookIDs    []interface{}
+	)
+	for rows.Next() {
+		var (
+			m         WebhookDelivery
+			eventType string
+			payload   []byte
+		)
+		err = rows.Scan(&m.ID, &m.WebhookID, &eventType, &payload, &m.Attempts)
+		if err != nil {
+			rows.Close()
+			err = errors.Wrapf(err, "query - %s", queryStr)
+			return 0, err
+		}
+		m.EventType = EventType(eventType)
+		m.Payload = payload
+
+		deliveries = append(deliveries, &m)
+		hookIDs = append(hookIDs, m.WebhookID)
+	}
+	rows.Close()
+
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+
+	// Load the webhooks for the deliveries, including archived ones so the delivery can be marked as failed.
+	hooks := make(map[string]*Webhook)
+	{
+		query := sqlbuilder.NewSelectBuilder()
+		query.Where(query.In("id", hookIDs...))
+
+		res, err := find(ctx, auth.Claims{}, repo.DbConn, query, []interface{}{}, true)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, h := range res {
+			hooks[h.ID] = h
+		}
+	}
+
+	for _, d := range deliveries {
+		h, ok := hooks[d.WebhookID]
+		if !ok || h.Status != WebhookStatus_Active || (h.ArchivedAt != nil && h.ArchivedAt.Valid) {
+			d.Status = WebhookDeliveryStatus_Failed
+			d.NextAttemptAt = nil
+			d.Error = "webhook is no longer active"
+		} else {
+			repo.deliver(ctx, h, d, now)
+		}
+
+		err = repo.saveDelivery(ctx, d, now)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+// deliver sends the delivery to the webhook endpoint and updates the delivery with the result.
+func (repo *Repository) deliver(ctx context.Context, h *Webhook, d *WebhookDelivery, now time.Time) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.deliver")
+	defer span.Finish()
+
+	d.Attempts++
+	d.ResponseStatus = nil
+	d.Error = ""
+
+	err := func() error {
+		req, err := http.NewRequest(http.MethodPost, h.Url, bytes.NewReader(d.Payload))
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(HeaderEvent, string(d.EventType))
+		req.Header.Set(HeaderDelivery, d.ID)
+		req.Header.Set(HeaderSignature, Sign(h.Secret, now, d.Payload))
+
+		res, err := repo.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		// The body is not stored so the endpoint can't be used to read the responses of other services.
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, maxResponseBody))
+
+		status := res.StatusCode
+		d.ResponseStatus = &status
+
+		if status < 200 || status >= 300 {
+			return errors.Errorf("Endpoint responded with status %d", status)
+		}
+
+		return nil
+	}()
+
+	if err == nil {
+		d.Status = WebhookDeliveryStatus_Succeeded
+		d.NextAttemptAt = nil
+		return
+	}
+
+	d.Error = err.Error()
+
+	if d.Attempts >= repo.MaxAttempts {
+		d.Status = WebhookDeliveryStatus_Failed
+		d.NextAttemptAt = nil
+		return
+	}
+
+	// Double the delay for each additional attempt.
+	d.Status = WebhookDeliveryStatus_Pending
+	d.NextAttemptAt = &pq.NullTime{
+		Time:  now.Add(repo.RetryBackoff * time.Duration(1<<uint(d.Attempts-1))),
+		Valid: true,
+	}
+}
+
+// saveDelivery updates the delivery log with the result of the latest attempt.
+func (repo *Repository) saveDelivery(ctx context.Context, d *WebhookDelivery, now time.Time) error {
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(webhookDeliveryTableName)
+	query.Set(
+		query.Assign("status", d.Status),
+		query.Assign("attempts", d.Attempts),
+		query.Assign("response_status", d.ResponseStatus),
+		query.Assign("error", d.Error),
+		query.Assign("next_attempt_at", d.NextAttemptAt),
+		query.Assign("updated_at", now),
+	)
+	query.Where(query.Equal("id", d.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err := repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "update webhook delivery %s failed", d.ID)
+		return err
+	}
+
+	return nil
+}
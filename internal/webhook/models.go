@@ -0,0 +1,436 @@
+package webhook
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"gopkg.in/go-playground/validator.v9"
+)
+
+// Repository defines the required dependencies for Webhook.
+type Repository struct {
+	DbConn *sqlx.DB
+
+	// Client is used to send the deliveries to the webhook endpoints. The default client refuses to connect to
+	// internal addresses, see NewDeliveryClient.
+	Client *http.Client
+
+	// MaxAttempts is the number of times a delivery is attempted before it's marked as failed.
+	MaxAttempts int
+
+	// RetryBackoff is the delay before the first retry, the delay doubles for each additional attempt.
+	RetryBackoff time.Duration
+}
+
+// NewRepository creates a new Repository that defines dependencies for Webhook.
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		DbConn:       db,
+		Client:       NewDeliveryClient(),
+		MaxAttempts:  DefaultMaxAttempts,
+		RetryBackoff: DefaultRetryBackoff,
+	}
+}
+
+// Webhook represents an endpoint registered by an account to receive events.
+type Webhook struct {
+	ID          string         `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	AccountID   string         `json:"account_id" validate:"required,uuid" truss:"api-create"`
+	Url         string         `json:"url" validate:"required,url,startswith=https://" example:"https://example.com/hooks"`
+	Description string         `json:"description" example:"Sync projects with CRM."`
+	Secret      string         `json:"secret" validate:"required" truss:"api-read"`
+	EventTypes  pq.StringArray `json:"event_types" validate:"required,min=1" swaggertype:"array,string" example:"project.created"`
+	Status      WebhookStatus  `json:"status" validate:"omitempty,oneof=active disabled" enums:"active,disabled" swaggertype:"string" example:"active"`
+	CreatedAt   time.Time      `json:"created_at" truss:"api-read"`
+	UpdatedAt   time.Time      `json:"updated_at" truss:"api-read"`
+	ArchivedAt  *pq.NullTime   `json:"archived_at,omitempty" truss:"api-hide"`
+}
+
+// WebhookResponse represents a webhook that is returned for display.
+type WebhookResponse struct {
+	ID          string            `json:"id" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	AccountID   string            `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	Url         string            `json:"url" example:"https://example.com/hooks"`
+	Description string            `json:"description" example:"Sync projects with CRM."`
+	Secret      string            `json:"secret" example:"8d3f9bf1a7e0c6a54d3b2e1f0a9c8b7d"` // Secret is used to sign the deliveries.
+	EventTypes  []string          `json:"event_types" example:"project.created"`
+	Status      web.EnumResponse  `json:"status"`                // Status is enum with values [active, disabled].
+	CreatedAt   web.TimeResponse  `json:"created_at"`            // CreatedAt contains multiple format options for display.
+	UpdatedAt   web.TimeResponse  `json:"updated_at"`            // UpdatedAt contains multiple format options for display.
+	ArchivedAt  *web.TimeResponse `json:"archived_at,omitempty"` // ArchivedAt contains multiple format options for display.
+}
+
+// Response transforms Webhook and WebhookResponse that is used for display.
+// Additional filtering by context values or translations could be applied.
+func (m *Webhook) Response(ctx context.Context) *WebhookResponse {
+	if m == nil {
+		return nil
+	}
+
+	r := &WebhookResponse{
+		ID:          m.ID,
+		AccountID:   m.AccountID,
+		Url:         m.Url,
+		Description: m.Description,
+		Secret:      m.Secret,
+		EventTypes:  m.EventTypes,
+		Status:      web.NewEnumResponse(ctx, m.Status, WebhookStatus_ValuesInterface()...),
+		CreatedAt:   web.NewTimeResponse(ctx, m.CreatedAt),
+		UpdatedAt:   web.NewTimeResponse(ctx, m.UpdatedAt),
+	}
+
+	if m.ArchivedAt != nil && !m.ArchivedAt.Time.IsZero() {
+		at := web.NewTimeResponse(ctx, m.ArchivedAt.Time)
+		r.ArchivedAt = &at
+	}
+
+	return r
+}
+
+// Webhooks a list of Webhooks.
+type Webhooks []*Webhook
+
+// Response transforms a list of Webhooks to a list of WebhookResponses.
+func (m *Webhooks) Response(ctx context.Context) []*WebhookResponse {
+	var l []*WebhookResponse
+	if m != nil && len(*m) > 0 {
+		for _, n := range *m {
+			l = append(l, n.Response(ctx))
+		}
+	}
+
+	return l
+}
+
+// WebhookCreateRequest contains information needed to create a new Webhook.
+type WebhookCreateRequest struct {
+	AccountID   string         `json:"account_id" validate:"required,uuid"  example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	Url         string         `json:"url" validate:"required,url,startswith=https://" example:"https://example.com/hooks"`
+	Description string         `json:"description" validate:"omitempty,max=255" example:"Sync projects with CRM."`
+	EventTypes  []string       `json:"event_types" validate:"required,min=1" example:"project.created"`
+	Status      *WebhookStatus `json:"status,omitempty" validate:"omitempty,oneof=active disabled" enums:"active,disabled" swaggertype:"string" example:"active"`
+}
+
+// WebhookReadRequest defines the information needed to read a webhook.
+type WebhookReadRequest struct {
+	ID              string `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	IncludeArchived bool   `json:"include-archived" example:"false"`
+}
+
+// WebhookUpdateRequest defines what information may be provided to modify an existing
+// Webhook. All fields are optional so clients can send just the fields they want
+// changed. It uses pointer fields so we can differentiate between a field that
+// was not provided and a field that was provided as explicitly blank.
+type WebhookUpdateRequest struct {
+	ID          string         `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	Url         *string        `json:"url,omitempty" validate:"omitempty,url,startswith=https://" example:"https://example.com/hooks"`
+	Description *string        `json:"description,omitempty" validate:"omitempty,max=255" example:"Sync projects with CRM."`
+	EventTypes  *[]string      `json:"event_types,omitempty" validate:"omitempty,min=1" example:"project.archived"`
+	Status      *WebhookStatus `json:"status,omitempty" validate:"omitempty,oneof=active disabled" enums:"active,disabled" swaggertype:"string" example:"disabled"`
+}
+
+// WebhookArchiveRequest defines the information needed to archive a webhook. This will archive (soft-delete) the
+// existing database entry.
+type WebhookArchiveRequest struct {
+	ID string `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+}
+
+// WebhookDeleteRequest defines the information needed to delete a webhook.
+type WebhookDeleteRequest struct {
+	ID string `json:"id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+}
+
+// WebhookFindRequest defines the possible options to search for webhooks. By default
+// archived webhooks will be excluded from response.
+type WebhookFindRequest struct {
+	Filter          filter.Filter `json:"filter,omitempty"`
+	Order           []string      `json:"order" example:"created_at desc"`
+	Limit           *uint         `json:"limit" example:"10"`
+	Offset          *uint         `json:"offset" example:"20"`
+	Cursor          string        `json:"cursor,omitempty" example:"eyJvIjpbImlkIGFzYyJdLCJ2IjpbIjk4NWYxNzQ2Il19"`
+	IncludeArchived bool          `json:"include-archived" example:"false"`
+}
+
+// WebhookFindFields defines the fields of webhook that can be used to filter and sort a find request.
+var WebhookFindFields = filter.Fields{
+	{Name: "id", Type: filter.FieldType_UUID, Filterable: true, Sortable: true, Key: true},
+	{Name: "url", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "description", Type: filter.FieldType_String, Filterable: true},
+	{Name: "status", Type: filter.FieldType_Enum, Values: filter.EnumValues(WebhookStatus_ValuesInterface()), Filterable: true, Sortable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "updated_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "archived_at", Type: filter.FieldType_Time, Filterable: true, Nullable: true},
+}
+
+// WebhookStatus represents the status of webhook.
+type WebhookStatus string
+
+// WebhookStatus values define the status field of webhook.
+const (
+	// WebhookStatus_Active defines the status of active for webhook.
+	WebhookStatus_Active WebhookStatus = "active"
+	// WebhookStatus_Disabled defines the status of disabled for webhook.
+	WebhookStatus_Disabled WebhookStatus = "disabled"
+)
+
+// WebhookStatus_Values provides list of valid WebhookStatus values.
+var WebhookStatus_Values = []WebhookStatus{
+	WebhookStatus_Active,
+	WebhookStatus_Disabled,
+}
+
+// WebhookStatus_ValuesInterface returns the WebhookStatus options as a slice interface.
+func WebhookStatus_ValuesInterface() []interface{} {
+	var l []interface{}
+	for _, v := range WebhookStatus_Values {
+		l = append(l, v.String())
+	}
+	return l
+}
+
+// Scan supports reading the WebhookStatus value from the database.
+func (s *WebhookStatus) Scan(value interface{}) error {
+	asBytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("Scan source is not []byte")
+	}
+
+	*s = WebhookStatus(string(asBytes))
+	return nil
+}
+
+// Value converts the WebhookStatus value to be stored in the database.
+func (s WebhookStatus) Value() (driver.Value, error) {
+	v := validator.New()
+	errs := v.Var(s, "required,oneof=active disabled")
+	if errs != nil {
+		return nil, errs
+	}
+
+	return string(s), nil
+}
+
+// String converts the WebhookStatus value to a string.
+func (s WebhookStatus) String() string {
+	return string(s)
+}
+
+// EventType represents a domain event that can be delivered to a webhook.
+type EventType string
+
+// EventType values define the events webhooks can subscribe to.
+const (
+	EventType_AccountCreated      EventType = "account.created"
+	EventType_AccountUpdated      EventType = "account.updated"
+	EventType_ProjectCreated      EventType = "project.created"
+	EventType_ProjectUpdated      EventType = "project.updated"
+	EventType_ProjectArchived     EventType = "project.archived"
+	EventType_ProjectDeleted      EventType = "project.deleted"
+	EventType_UserInvited         EventType = "user.invited"
+	EventType_UserAccountCreated  EventType = "user_account.created"
+	EventType_UserAccountUpdated  EventType = "user_account.updated"
+	EventType_UserAccountArchived EventType = "user_account.archived"
+	EventType_UserAccountDeleted  EventType = "user_account.deleted"
+)
+
+// EventType_All can be used by a webhook to subscribe to all events.
+const EventType_All EventType = "*"
+
+// EventType_Values provides list of valid EventType values.
+var EventType_Values = []EventType{
+	EventType_AccountCreated,
+	EventType_AccountUpdated,
+	EventType_ProjectCreated,
+	EventType_ProjectUpdated,
+	EventType_ProjectArchived,
+	EventType_ProjectDeleted,
+	EventType_UserInvited,
+	EventType_UserAccountCreated,
+	EventType_UserAccountUpdated,
+	EventType_UserAccountArchived,
+	EventType_UserAccountDeleted,
+}
+
+// ValidEventType returns true when the event type is known or subscribes to all events.
+func ValidEventType(v string) bool {
+	if EventType(v) == EventType_All {
+		return true
+	}
+	for _, et := range EventType_Values {
+		if string(et) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the payload sent to a webhook endpoint.
+type Event struct {
+	ID        string          `json:"id" example:"3c4a5b2e-7a55-4f3b-8e4f-0d41c8a6ce2a"`
+	Type      EventType       `json:"type" example:"project.created"`
+	AccountID string          `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data" swaggertype:"object"`
+}
+
+// WebhookDelivery records each event sent to a webhook and the result of the latest attempt.
+type WebhookDelivery struct {
+	ID             string                `json:"id" example:"3c4a5b2e-7a55-4f3b-8e4f-0d41c8a6ce2a"`
+	WebhookID      string                `json:"webhook_id" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	AccountID      string                `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	EventType      EventType             `json:"event_type" example:"project.created"`
+	Payload        json.RawMessage       `json:"payload" swaggertype:"object"`
+	Status         WebhookDeliveryStatus `json:"status" swaggertype:"string" example:"pending"`
+	Attempts       int                   `json:"attempts" example:"1"`
+	ResponseStatus *int                  `json:"response_status,omitempty" example:"200"`
+	Error          string                `json:"error" example:""`
+	NextAttemptAt  *pq.NullTime          `json:"next_attempt_at,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+}
+
+// WebhookDeliveryResponse represents a webhook delivery that is returned for display.
+type WebhookDeliveryResponse struct {
+	ID             string            `json:"id" example:"3c4a5b2e-7a55-4f3b-8e4f-0d41c8a6ce2a"`
+	WebhookID      string            `json:"webhook_id" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	AccountID      string            `json:"account_id" example:"c4653bf9-5978-48b7-89c5-95704aebb7e2"`
+	EventType      string            `json:"event_type" example:"project.created"`
+	Payload        json.RawMessage   `json:"payload" swaggertype:"object"`
+	Status         web.EnumResponse  `json:"status"` // Status is enum with values [pending, succeeded, failed].
+	Attempts       int               `json:"attempts" example:"1"`
+	ResponseStatus *int              `json:"response_status,omitempty" example:"200"`
+	Error          string            `json:"error" example:""`
+	NextAttemptAt  *web.TimeResponse `json:"next_attempt_at,omitempty"` // NextAttemptAt contains multiple format options for display.
+	CreatedAt      web.TimeResponse  `json:"created_at"`                // CreatedAt contains multiple format options for display.
+	UpdatedAt      web.TimeResponse  `json:"updated_at"`                // UpdatedAt contains multiple format options for display.
+}
+
+// Response transforms WebhookDelivery and WebhookDeliveryResponse that is used for display.
+// Additional filtering by context values or translations could be applied.
+func (m *WebhookDelivery) Response(ctx context.Context) *WebhookDeliveryResponse {
+	if m == nil {
+		return nil
+	}
+
+	r := &WebhookDeliveryResponse{
+		ID:             m.ID,
+		WebhookID:      m.WebhookID,
+		AccountID:      m.AccountID,
+		EventType:      string(m.EventType),
+		Payload:        m.Payload,
+		Status:         web.NewEnumResponse(ctx, m.Status, WebhookDeliveryStatus_ValuesInterface()...),
+		Attempts:       m.Attempts,
+		ResponseStatus: m.ResponseStatus,
+		Error:          m.Error,
+		CreatedAt:      web.NewTimeResponse(ctx, m.CreatedAt),
+		UpdatedAt:      web.NewTimeResponse(ctx, m.UpdatedAt),
+	}
+
+	if m.NextAttemptAt != nil && m.NextAttemptAt.Valid && !m.NextAttemptAt.Time.IsZero() {
+		at := web.NewTimeResponse(ctx, m.NextAttemptAt.Time)
+		r.NextAttemptAt = &at
+	}
+
+	return r
+}
+
+// WebhookDeliveries a list of WebhookDeliveries.
+type WebhookDeliveries []*WebhookDelivery
+
+// Response transforms a list of WebhookDeliveries to a list of WebhookDeliveryResponses.
+func (m *WebhookDeliveries) Response(ctx context.Context) []*WebhookDeliveryResponse {
+	var l []*WebhookDeliveryResponse
+	if m != nil && len(*m) > 0 {
+		for _, n := range *m {
+			l = append(l, n.Response(ctx))
+		}
+	}
+
+	return l
+}
+
+// WebhookDeliveryFindRequest defines the possible options to search for the deliveries of a webhook.
+type WebhookDeliveryFindRequest struct {
+	WebhookID string        `json:"webhook_id" validate:"required,uuid" example:"985f1746-1d9f-459f-a2d9-fc53ece5ae86"`
+	Filter    filter.Filter `json:"filter,omitempty"`
+	Order     []string      `json:"order" example:"created_at desc"`
+	Limit     *uint         `json:"limit" example:"10"`
+	Offset    *uint         `json:"offset" example:"20"`
+	Cursor    string        `json:"cursor,omitempty" example:"eyJvIjpbImlkIGFzYyJdLCJ2IjpbIjk4NWYxNzQ2Il19"`
+}
+
+// WebhookDeliveryFindFields defines the fields of webhook delivery that can be used to filter and sort a find request.
+var WebhookDeliveryFindFields = filter.Fields{
+	{Name: "id", Type: filter.FieldType_UUID, Filterable: true, Key: true},
+	{Name: "event_type", Type: filter.FieldType_String, Filterable: true, Sortable: true},
+	{Name: "status", Type: filter.FieldType_Enum, Values: filter.EnumValues(WebhookDeliveryStatus_ValuesInterface()), Filterable: true, Sortable: true},
+	{Name: "attempts", Type: filter.FieldType_Int, Filterable: true, Sortable: true},
+	{Name: "response_status", Type: filter.FieldType_Int, Filterable: true, Nullable: true},
+	{Name: "next_attempt_at", Type: filter.FieldType_Time, Filterable: true, Nullable: true},
+	{Name: "created_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+	{Name: "updated_at", Type: filter.FieldType_Time, Filterable: true, Sortable: true},
+}
+
+// WebhookDeliveryStatus represents the status of webhook delivery.
+type WebhookDeliveryStatus string
+
+// WebhookDeliveryStatus values define the status field of webhook delivery.
+const (
+	// WebhookDeliveryStatus_Pending defines the status of pending for webhook delivery.
+	WebhookDeliveryStatus_Pending WebhookDeliveryStatus = "pending"
+	// WebhookDeliveryStatus_Succeeded defines the status of succeeded for webhook delivery.
+	WebhookDeliveryStatus_Succeeded WebhookDeliveryStatus = "succeeded"
+	// WebhookDeliveryStatus_Failed defines the status of failed for webhook delivery.
+	WebhookDeliveryStatus_Failed WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDeliveryStatus_Values provides list of valid WebhookDeliveryStatus values.
+var WebhookDeliveryStatus_Values = []WebhookDeliveryStatus{
+	WebhookDeliveryStatus_Pending,
+	WebhookDeliveryStatus_Succeeded,
+	WebhookDeliveryStatus_Failed,
+}
+
+// WebhookDeliveryStatus_ValuesInterface returns the WebhookDeliveryStatus options as a slice interface.
+func WebhookDeliveryStatus_ValuesInterface() []interface{} {
+	var l []interface{}
+	for _, v := range WebhookDeliveryStatus_Values {
+		l = append(l, v.String())
+	}
+	return l
+}
+
+// Scan supports reading the WebhookDeliveryStatus value from the database.
+func (s *WebhookDeliveryStatus) Scan(value interface{}) error {
+	asBytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("Scan source is not []byte")
+	}
+
+	*s = WebhookDeliveryStatus(string(asBytes))
+	return nil
+}
+
+// Value converts the WebhookDeliveryStatus value to be stored in the database.
+func (s WebhookDeliveryStatus) Value() (driver.Value, error) {
+	v := validator.New()
+	errs := v.Var(s, "required,oneof=pending succeeded failed")
+	if errs != nil {
+		return nil, errs
+	}
+
+	return string(s), nil
+}
+
+// String converts the WebhookDeliveryStatus value to a string.
+func (s WebhookDeliveryStatus) String() string {
+	return string(s)
+}
@@ -0,0 +1,619 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+const (
+	// The database table for Webhook
+	webhookTableName = "webhooks"
+	// The database table for WebhookDelivery
+	webhookDeliveryTableName = "webhook_deliveries"
+)
+
+var (
+	// ErrNotFound abstracts the postgres not found error.
+	ErrNotFound = errors.New("Entity not found")
+
+	// ErrForbidden occurs when a user tries to do something that is forbidden to them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrInvalidEventType occurs when a webhook subscribes to an event type that is not defined.
+	ErrInvalidEventType = errors.New("Invalid event type")
+)
+
+// webhookMapColumns is the list of columns needed for find.
+var webhookMapColumns = "id,account_id,url,description,secret,event_types,status,created_at,updated_at,archived_at"
+
+// CanModifyWebhooks determines if claims has the authority to manage the webhooks for the specified account ID.
+func CanModifyWebhooks(ctx context.Context, claims auth.Claims, accountID string) error {
+	// Claims are empty, request is internal.
+	if claims.Audience == "" && claims.Subject == "" {
+		return nil
+	}
+
+	// Webhooks can only be managed for the account the claims were issued for.
+	if claims.Audience != accountID || !claims.HasPermission(auth.PermissionWebhookWrite) {
+		return errors.WithStack(ErrForbidden)
+	}
+
+	return nil
+}
+
+// applyClaimsSelect applies a sub-query to the provided query to enforce ACL based on the claims provided.
+// 	1. No claims, request is internal, no ACL applied
+// 	2. Users with the webhook:write permission can access the webhooks for their account ID
+func applyClaimsSelect(ctx context.Context, claims auth.Claims, query *sqlbuilder.SelectBuilder) error {
+	// Claims are empty, don't apply any ACL
+	if claims.Audience == "" && claims.Subject == "" {
+		return nil
+	}
+
+	// The webhook secret is included in the response so access is limited to users that can manage them.
+	if !claims.HasPermission(auth.PermissionWebhookWrite) {
+		return errors.WithStack(ErrForbidden)
+	}
+
+	query.Where(query.Equal("account_id", claims.Audience))
+	return nil
+}
+
+// selectQuery constructs a base select query for Webhook.
+func selectQuery() *sqlbuilder.SelectBuilder {
+	query := sqlbuilder.NewSelectBuilder()
+	query.Select(webhookMapColumns)
+	query.From(webhookTableName)
+	return query
+}
+
+// findRequestQuery generates the select query for the given find request.
+func findRequestQuery(req WebhookFindRequest) (*sqlbuilder.SelectBuilder, error) {
+	query := selectQuery()
+
+	if len(req.Filter) > 0 {
+		if err := req.Filter.Apply(query, WebhookFindFields); err != nil {
+			return nil, err
+		}
+	}
+	if req.Cursor != "" {
+		if err := WebhookFindFields.ApplyCursor(query, req.Order, req.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(req.Order) > 0 {
+		query.OrderBy(req.Order...)
+	}
+
+	if req.Limit != nil {
+		query.Limit(int(*req.Limit))
+	}
+
+	if req.Offset != nil {
+		query.Offset(int(*req.Offset))
+	}
+
+	return query, nil
+}
+
+// Find gets all the webhooks from the database based on the request params.
+func (repo *Repository) Find(ctx context.Context, claims auth.Claims, req WebhookFindRequest) (Webhooks, error) {
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
+}
+
+// Count gets the total number of webhooks from the database that match the request params. The order, limit,
+// offset and cursor of the request are ignored.
+func (repo *Repository) Count(ctx context.Context, claims auth.Claims, req WebhookFindRequest) (int, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.Count")
+	defer span.Finish()
+
+	req.Order, req.Limit, req.Offset, req.Cursor = nil, nil, nil, ""
+
+	query, err := findRequestQuery(req)
+	if err != nil {
+		return 0, err
+	}
+	query.Select("count(*)")
+
+	if !req.IncludeArchived {
+		query.Where(query.IsNull("archived_at"))
+	}
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err = applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return 0, err
+	}
+
+	queryStr, args := query.Build()
+	queryStr = repo.DbConn.Rebind(queryStr)
+
+	var total int
+	err = repo.DbConn.QueryRowContext(ctx, queryStr, args...).Scan(&total)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "count webhooks failed")
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// find internal method for getting all the webhooks from the database using a select query.
+func find(ctx context.Context, claims auth.Claims, dbConn sqlx.ExtContext, query *sqlbuilder.SelectBuilder, args []interface{}, includedArchived bool) (Webhooks, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.Find")
+	defer span.Finish()
+
+	query.Select(webhookMapColumns)
+	query.From(webhookTableName)
+	if !includedArchived {
+		query.Where(query.IsNull("archived_at"))
+	}
+
+	// Check to see if a sub query needs to be applied for the claims.
+	err := applyClaimsSelect(ctx, claims, query)
+	if err != nil {
+		return nil, err
+	}
+
+	queryStr, queryArgs := query.Build()
+	queryStr = dbConn.Rebind(queryStr)
+	args = append(args, queryArgs...)
+
+	// Fetch all entries from the db.
+	rows, err := dbConn.QueryContext(ctx, queryStr, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "find webhooks failed")
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate over each row.
+	resp := []*Webhook{}
+	for rows.Next() {
+		var m Webhook
+		err = rows.Scan(&m.ID, &m.AccountID, &m.Url, &m.Description, &m.Secret, &m.EventTypes, &m.Status, &m.CreatedAt, &m.UpdatedAt, &m.ArchivedAt)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			return nil, err
+		}
+
+		resp = append(resp, &m)
+	}
+
+	return resp, nil
+}
+
+// ReadByID gets the specified webhook by ID from the database.
+func (repo *Repository) ReadByID(ctx context.Context, claims auth.Claims, id string) (*Webhook, error) {
+	return repo.Read(ctx, claims, WebhookReadRequest{
+		ID:              id,
+		IncludeArchived: false,
+	})
+}
+
+// Read gets the specified webhook from the database.
+func (repo *Repository) Read(ctx context.Context, claims auth.Claims, req WebhookReadRequest) (*Webhook, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.Read")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter base select query by id
+	query := sqlbuilder.NewSelectBuilder()
+	query.Where(query.Equal("id", req.ID))
+
+	res, err := find(ctx, claims, repo.DbConn, query, []interface{}{}, req.IncludeArchived)
+	if err != nil {
+		return nil, err
+	} else if res == nil || len(res) == 0 {
+		err = errors.WithMessagef(ErrNotFound, "webhook %s not found", req.ID)
+		return nil, err
+	}
+
+	u := res[0]
+	return u, nil
+}
+
+// Create inserts a new webhook into the database.
+func (repo *Repository) Create(ctx context.Context, claims auth.Claims, req WebhookCreateRequest, now time.Time) (*Webhook, error) {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.Create")
+	defer span.Finish()
+
+	if claims.Audience != "" && req.AccountID == "" {
+		// Set the accountId from claims.
+		req.AccountID = claims.Audience
+	}
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the claims can manage the webhooks for the account specified in the request.
+	err = CanModifyWebhooks(ctx, claims, req.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateEventTypes(req.EventTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate the secret used to sign deliveries.
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	m := Webhook{
+		ID:          uuid.NewRandom().String(),
+		AccountID:   req.AccountID,
+		Url:         req.Url,
+		Description: req.Description,
+		Secret:      secret,
+		EventTypes:  pq.StringArray(req.EventTypes),
+		Status:      WebhookStatus_Active,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if req.Status != nil {
+		m.Status = *req.Status
+	}
+
+	// Build the insert SQL statement.
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto(webhookTableName)
+	query.Cols(
+		"id",
+		"account_id",
+		"url",
+		"description",
+		"secret",
+		"event_types",
+		"status",
+		"created_at",
+		"updated_at",
+		"archived_at",
+	)
+
+	query.Values(
+		m.ID,
+		m.AccountID,
+		m.Url,
+		m.Description,
+		m.Secret,
+		m.EventTypes,
+		m.Status,
+		m.CreatedAt,
+		m.UpdatedAt,
+		m.ArchivedAt,
+	)
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessage(err, "create webhook failed")
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Update replaces a webhook in the database.
+func (repo *Repository) Update(ctx context.Context, claims auth.Claims, req WebhookUpdateRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.Update")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	hook, err := repo.ReadByID(ctx, claims, req.ID)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can manage the webhooks for the account.
+	err = CanModifyWebhooks(ctx, claims, hook.AccountID)
+	if err != nil {
+		return err
+	}
+
+	if req.EventTypes != nil {
+		err = validateEventTypes(*req.EventTypes)
+		if err != nil {
+			return err
+		}
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(webhookTableName)
+
+	var fields []string
+	if req.Url != nil {
+		fields = append(fields, query.Assign("url", *req.Url))
+	}
+	if req.Description != nil {
+		fields = append(fields, query.Assign("description", *req.Description))
+	}
+	if req.EventTypes != nil {
+		fields = append(fields, query.Assign("event_types", pq.StringArray(*req.EventTypes)))
+	}
+	if req.Status != nil {
+		fields = append(fields, query.Assign("status", req.Status))
+	}
+
+	// If there's nothing to update we can quit early.
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// Append the updated_at field
+	fields = append(fields, query.Assign("updated_at", now))
+
+	query.Set(fields...)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "update webhook %s failed", req.ID)
+		return err
+	}
+
+	return nil
+}
+
+// Archive soft deleted the webhook from the database.
+func (repo *Repository) Archive(ctx context.Context, claims auth.Claims, req WebhookArchiveRequest, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.Archive")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	hook, err := repo.ReadByID(ctx, claims, req.ID)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can manage the webhooks for the account.
+	err = CanModifyWebhooks(ctx, claims, hook.AccountID)
+	if err != nil {
+		return err
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	// Build the update SQL statement.
+	query := sqlbuilder.NewUpdateBuilder()
+	query.Update(webhookTableName)
+	query.Set(
+		query.Assign("archived_at", now),
+	)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "archive webhook %s failed", req.ID)
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes a webhook and its delivery log from the database.
+func (repo *Repository) Delete(ctx context.Context, claims auth.Claims, req WebhookDeleteRequest) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.Delete")
+	defer span.Finish()
+
+	// Validate the request.
+	v := webcontext.Validator()
+	err := v.Struct(req)
+	if err != nil {
+		return err
+	}
+
+	hook, err := repo.Read(ctx, claims, WebhookReadRequest{ID: req.ID, IncludeArchived: true})
+	if err != nil {
+		return err
+	}
+
+	// Ensure the claims can manage the webhooks for the account.
+	err = CanModifyWebhooks(ctx, claims, hook.AccountID)
+	if err != nil {
+		return err
+	}
+
+	// Build the delete SQL statement.
+	query := sqlbuilder.NewDeleteBuilder()
+	query.DeleteFrom(webhookTableName)
+	query.Where(query.Equal("id", req.ID))
+
+	// Execute the query with the provided context.
+	sql, args := query.Build()
+	sql = repo.DbConn.Rebind(sql)
+	_, err = repo.DbConn.ExecContext(ctx, sql, args...)
+	if err != nil {
+		err = errors.Wrapf(err, "query - %s", query.String())
+		err = errors.WithMessagef(err, "delete webhook %s failed", req.ID)
+		return err
+	}
+
+	return nil
+}
+
+// Publish queues a delivery of the event for each of the active webhooks of the account subscribed to the
+// event type. Deliveries are sent by DeliverPending so publishing does not block on the webhook endpoints.
+func Publish(ctx context.Context, dbConn sqlx.ExtContext, accountID string, eventType EventType, data interface{}, now time.Time) error {
+	span, ctx := tracer.StartSpanFromContext(ctx, "internal.webhook.Publish")
+	defer span.Finish()
+
+	if accountID == "" {
+		return nil
+	}
+
+	// Find the webhooks subscribed to the event type.
+	query := selectQuery()
+	query.Where(query.And(
+		query.Equal("account_id", accountID),
+		query.Equal("status", WebhookStatus_Active),
+		query.IsNull("archived_at"),
+		"("+query.Var(string(eventType))+" = ANY (event_types) OR "+query.Var(string(EventType_All))+" = ANY (event_types))",
+	))
+
+	hooks, err := find(ctx, auth.Claims{}, dbConn, query, []interface{}{}, false)
+	if err != nil {
+		return err
+	} else if len(hooks) == 0 {
+		return nil
+	}
+
+	// If now empty set it to the current time.
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Always store the time as UTC.
+	now = now.UTC()
+
+	// Postgres truncates times to milliseconds when storing. We and do the same
+	// here so the value we return is consistent with what we store.
+	now = now.Truncate(time.Millisecond)
+
+	dat, err := json.Marshal(data)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	payload, err := json.Marshal(Event{
+		ID:        uuid.NewRandom().String(),
+		Type:      eventType,
+		AccountID: accountID,
+		CreatedAt: now,
+		Data:      dat,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, h := range hooks {
+		// Build the insert SQL statement.
+		query := sqlbuilder.NewInsertBuilder()
+		query.InsertInto(webhookDeliveryTableName)
+		query.Cols("id", "webhook_id", "account_id", "event_type", "payload", "status", "next_attempt_at", "created_at", "updated_at")
+		query.Values(uuid.NewRandom().String(), h.ID, accountID, string(eventType), string(payload), WebhookDeliveryStatus_Pending, now, now, now)
+
+		// Execute the query with the provided context.
+		sql, args := query.Build()
+		sql = dbConn.Rebind(sql)
+		_, err = dbConn.ExecContext(ctx, sql, args...)
+		if err != nil {
+			err = errors.Wrapf(err, "query - %s", query.String())
+			err = errors.WithMessagef(err, "queue %s delivery for webhook %s failed", eventType, h.ID)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateEventTypes ensures each of the event types is defined.
+func validateEventTypes(eventTypes []string) error {
+	for _, et := range eventTypes {
+		if !ValidEventType(et) {
+			return errors.WithMessagef(ErrInvalidEventType, "event type %s is not defined", et)
+		}
+	}
+	return nil
+}
+
+// newSecret returns a random hex encoded secret used to sign deliveries.
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,444 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/auth"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/filter"
+	"geeks-accelerator/oss/saas-starter-kit/internal/platform/tests"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/huandu/go-sqlbuilder"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+)
+
+var (
+	test *tests.Test
+	repo *Repository
+)
+
+// TestMain is the entry point for testing.
+func TestMain(m *testing.M) {
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	test = tests.New()
+	defer test.TearDown()
+
+	repo = NewRepository(test.MasterDB)
+
+	return m.Run()
+}
+
+// mockAccountID inserts a new account directly into the database. The account package can't be used here as it
+// publishes webhook events.
+func mockAccountID(t *testing.T, now time.Time) string {
+	accountID := uuid.NewRandom().String()
+
+	query := sqlbuilder.NewInsertBuilder()
+	query.InsertInto("accounts")
+	query.Cols("id", "name", "created_at", "updated_at")
+	query.Values(accountID, accountID, now, now)
+
+	sql, args := query.Build()
+	sql = test.MasterDB.Rebind(sql)
+	if _, err := test.MasterDB.Exec(sql, args...); err != nil {
+		t.Log("\t\tGot :", err)
+		t.Fatalf("\t%s\tCreate account failed.", tests.Failed)
+	}
+
+	return accountID
+}
+
+// mockClaims returns the claims for a user of the account with the specified role.
+func mockClaims(accountID, role string) auth.Claims {
+	return auth.Claims{
+		Roles: []string{role},
+		StandardClaims: jwt.StandardClaims{
+			Subject:  uuid.NewRandom().String(),
+			Audience: accountID,
+		},
+	}
+}
+
+// TestCrud validates the full set of CRUD operations for webhooks and ensures ACLs are correctly applied by claims.
+func TestCrud(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	ctx := tests.Context()
+
+	accountID := mockAccountID(t, now)
+
+	adminClaims := mockClaims(accountID, auth.RoleAdmin)
+	userClaims := mockClaims(accountID, auth.RoleUser)
+	otherClaims := mockClaims(uuid.NewRandom().String(), auth.RoleAdmin)
+
+	t.Log("Given the need to manage webhooks for an account.")
+	{
+		req := WebhookCreateRequest{
+			AccountID:  accountID,
+			Url:        "https://example.com/hooks",
+			EventTypes: []string{string(EventType_ProjectCreated)},
+		}
+
+		// Users without the webhook:write permission and users of another account can't create webhooks.
+		for _, claims := range []auth.Claims{userClaims, otherClaims} {
+			_, err := repo.Create(ctx, claims, req, now)
+			if errors.Cause(err) != ErrForbidden {
+				t.Logf("\t\tGot : %+v", err)
+				t.Logf("\t\tWant: %+v", ErrForbidden)
+				t.Fatalf("\t%s\tCreate with role %s failed.", tests.Failed, claims.Roles[0])
+			}
+		}
+		t.Logf("\t%s\tCreate forbidden ok.", tests.Success)
+
+		// Event types must be defined.
+		invalidReq := req
+		invalidReq.EventTypes = []string{"project.launched"}
+		_, err := repo.Create(ctx, adminClaims, invalidReq, now)
+		if errors.Cause(err) != ErrInvalidEventType {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrInvalidEventType)
+			t.Fatalf("\t%s\tCreate with invalid event type failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate with invalid event type ok.", tests.Success)
+
+		// Deliveries are only sent over https.
+		invalidReq = req
+		invalidReq.Url = "http://example.com/hooks"
+		_, err = repo.Create(ctx, adminClaims, invalidReq, now)
+		if err == nil {
+			t.Fatalf("\t%s\tCreate with http url failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate with http url ok.", tests.Success)
+
+		hook, err := repo.Create(ctx, adminClaims, req, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+		} else if hook.Secret == "" || hook.Status != WebhookStatus_Active {
+			t.Fatalf("\t%s\tExpected webhook to be active with a secret.", tests.Failed)
+		}
+		t.Logf("\t%s\tCreate ok.", tests.Success)
+
+		// Only users of the account can read the webhook.
+		_, err = repo.ReadByID(ctx, otherClaims, hook.ID)
+		if errors.Cause(err) != ErrNotFound {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrNotFound)
+			t.Fatalf("\t%s\tRead with different account failed.", tests.Failed)
+		}
+		_, err = repo.ReadByID(ctx, userClaims, hook.ID)
+		if errors.Cause(err) != ErrForbidden {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrForbidden)
+			t.Fatalf("\t%s\tRead with role user failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tRead forbidden ok.", tests.Success)
+
+		desc := "Sync projects with CRM."
+		eventTypes := []string{string(EventType_ProjectCreated), string(EventType_ProjectArchived)}
+		err = repo.Update(ctx, adminClaims, WebhookUpdateRequest{
+			ID:          hook.ID,
+			Description: &desc,
+			EventTypes:  &eventTypes,
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tUpdate failed.", tests.Failed)
+		}
+
+		updated, err := repo.ReadByID(ctx, adminClaims, hook.ID)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tRead failed.", tests.Failed)
+		} else if updated.Description != desc || len(updated.EventTypes) != len(eventTypes) {
+			t.Fatalf("\t%s\tExpected webhook to be updated.", tests.Failed)
+		}
+		t.Logf("\t%s\tUpdate ok.", tests.Success)
+
+		err = repo.Archive(ctx, adminClaims, WebhookArchiveRequest{ID: hook.ID}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tArchive failed.", tests.Failed)
+		}
+
+		res, err := repo.Find(ctx, adminClaims, WebhookFindRequest{
+			Filter: filter.Filter{
+				{Field: "id", Operator: filter.Op_Eq, Values: []string{hook.ID}},
+			},
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tFind failed.", tests.Failed)
+		} else if len(res) != 0 {
+			t.Fatalf("\t%s\tExpected archived webhook to be excluded.", tests.Failed)
+		}
+		t.Logf("\t%s\tArchive ok.", tests.Success)
+
+		err = repo.Delete(ctx, adminClaims, WebhookDeleteRequest{ID: hook.ID})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tDelete failed.", tests.Failed)
+		}
+
+		_, err = repo.Read(ctx, adminClaims, WebhookReadRequest{ID: hook.ID, IncludeArchived: true})
+		if errors.Cause(err) != ErrNotFound {
+			t.Logf("\t\tGot : %+v", err)
+			t.Logf("\t\tWant: %+v", ErrNotFound)
+			t.Fatalf("\t%s\tRead after delete failed.", tests.Failed)
+		}
+		t.Logf("\t%s\tDelete ok.", tests.Success)
+	}
+}
+
+// TestSignature validates signatures are only accepted for the secret and payload they were created with.
+func TestSignature(t *testing.T) {
+	now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	secret := "6368616e676520746869732070613434"
+	payload := []byte(`{"type":"project.created"}`)
+
+	header := Sign(secret, now, payload)
+
+	// Headers with multiple signatures are sent while the secret is rolled.
+	other := strings.TrimPrefix(Sign("invalid", now, payload), fmt.Sprintf("t=%d,", now.Unix()))
+	multiple := header + "," + other
+	reversed := fmt.Sprintf("t=%d,%s,%s", now.Unix(), other, strings.TrimPrefix(header, fmt.Sprintf("t=%d,", now.Unix())))
+
+	var sigTests = []struct {
+		name    string
+		header  string
+		secret  string
+		payload []byte
+		now     time.Time
+		err     error
+	}{
+		{"Valid", header, secret, payload, now.Add(time.Minute), nil},
+		{"Secret", header, "invalid", payload, now, ErrInvalidSignature},
+		{"Payload", header, secret, []byte(`{"type":"project.deleted"}`), now, ErrInvalidSignature},
+		{"Expired", header, secret, payload, now.Add(time.Hour), ErrInvalidSignature},
+		{"MultipleFirst", multiple, secret, payload, now, nil},
+		{"MultipleLast", reversed, secret, payload, now, nil},
+		{"MultipleInvalid", multiple, "unknown", payload, now, ErrInvalidSignature},
+	}
+
+	t.Log("Given the need to verify the signature of a webhook delivery.")
+	{
+		for i, tt := range sigTests {
+			t.Logf("\tTest: %d\tWhen running test: %s", i, tt.name)
+			{
+				err := VerifySignature(tt.secret, tt.header, tt.payload, 5*time.Minute, tt.now)
+				if errors.Cause(err) != tt.err {
+					t.Logf("\t\tGot : %+v", err)
+					t.Logf("\t\tWant: %+v", tt.err)
+					t.Fatalf("\t%s\tVerifySignature failed.", tests.Failed)
+				}
+				t.Logf("\t%s\tVerifySignature ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestDeliverPending validates published events are signed and delivered to the subscribed webhooks and failed
+// deliveries are scheduled to be retried.
+func TestDeliverPending(t *testing.T) {
+	defer tests.Recover(t)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	ctx := tests.Context()
+
+	accountID := mockAccountID(t, now)
+
+	// Endpoint that verifies the signature and fails when requested.
+	var fail bool
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		hook, err := repo.ReadByID(ctx, auth.Claims{}, r.URL.Query().Get("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		err = VerifySignature(hook.Secret, r.Header.Get(HeaderSignature), body, time.Minute, time.Now())
+		if err != nil || r.Header.Get(HeaderEvent) != string(EventType_ProjectCreated) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	// The test server listens on loopback which the default client refuses to connect to.
+	defaultClient := repo.Client
+	defer func() { repo.Client = defaultClient }()
+
+	t.Log("Given the need to deliver events to webhooks.")
+	{
+		hook, err := repo.Create(ctx, auth.Claims{}, WebhookCreateRequest{
+			AccountID:  accountID,
+			Url:        srv.URL,
+			EventTypes: []string{string(EventType_All)},
+		}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tCreate failed.", tests.Failed)
+		}
+
+		// Include the webhook ID in the URL so the endpoint can verify the signature.
+		url := srv.URL + "?id=" + hook.ID
+		err = repo.Update(ctx, auth.Claims{}, WebhookUpdateRequest{ID: hook.ID, Url: &url}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tUpdate failed.", tests.Failed)
+		}
+
+		// Deliveries to internal addresses are refused.
+		err = Publish(ctx, test.MasterDB, accountID, EventType_ProjectCreated, map[string]interface{}{}, now)
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tPublish failed.", tests.Failed)
+		}
+		if _, err := repo.DeliverPending(ctx, now); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tDeliverPending failed.", tests.Failed)
+		}
+		res, err := repo.FindDeliveries(ctx, mockClaims(accountID, auth.RoleAdmin), WebhookDeliveryFindRequest{
+			WebhookID: hook.ID,
+		})
+		if err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tFindDeliveries failed.", tests.Failed)
+		} else if len(res) != 1 || res[0].ResponseStatus != nil || !strings.Contains(res[0].Error, ErrAddressNotAllowed.Error()) {
+			t.Fatalf("\t%s\tExpected delivery to loopback to be refused.", tests.Failed)
+		}
+		t.Logf("\t%s\tDeliverPending to loopback refused ok.", tests.Success)
+
+		// Remove the refused delivery so it's not counted with the ones below.
+		if _, err := test.MasterDB.Exec("DELETE FROM webhook_deliveries WHERE id = $1", res[0].ID); err != nil {
+			t.Log("\t\tGot :", err)
+			t.Fatalf("\t%s\tDelete delivery failed.", tests.Failed)
+		}
+
+		repo.Client = srv.Client()
+
+		data := map[string]interface{}{"id": uuid.NewRandom().String(), "name": "Moon Launch"}
+
+		for _, f := range []bool{false, true} {
+			fail = f
+
+			err = Publish(ctx, test.MasterDB, accountID, EventType_ProjectCreated, data, now)
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tPublish failed.", tests.Failed)
+			}
+
+			if _, err := repo.DeliverPending(ctx, now); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tDeliverPending failed.", tests.Failed)
+			}
+
+			// Find the delivery for the event that was just published.
+			status := WebhookDeliveryStatus_Succeeded
+			if f {
+				status = WebhookDeliveryStatus_Pending
+			}
+			res, err := repo.FindDeliveries(ctx, mockClaims(accountID, auth.RoleAdmin), WebhookDeliveryFindRequest{
+				WebhookID: hook.ID,
+				Filter: filter.Filter{
+					{Field: "status", Operator: filter.Op_Eq, Values: []string{status.String()}},
+				},
+			})
+			if err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tFindDeliveries failed.", tests.Failed)
+			} else if len(res) != 1 {
+				t.Fatalf("\t%s\tExpected 1 %s delivery, got %d.", tests.Failed, status, len(res))
+			}
+			d := res[0]
+
+			var ev Event
+			if err := json.Unmarshal(d.Payload, &ev); err != nil {
+				t.Log("\t\tGot :", err)
+				t.Fatalf("\t%s\tDecode payload failed.", tests.Failed)
+			} else if ev.Type != EventType_ProjectCreated || ev.AccountID != accountID {
+				t.Fatalf("\t%s\tExpected payload to contain the event.", tests.Failed)
+			}
+
+			if !f {
+				if d.Attempts != 1 || d.ResponseStatus == nil || *d.ResponseStatus != http.StatusOK {
+					t.Fatalf("\t%s\tExpected delivery to succeed, got %s : %s.", tests.Failed, d.Status, d.Error)
+				}
+				t.Logf("\t%s\tDeliverPending succeeded ok.", tests.Success)
+			} else {
+				if d.Attempts != 1 || d.NextAttemptAt == nil || !d.NextAttemptAt.Time.After(now) {
+					t.Fatalf("\t%s\tExpected delivery to be scheduled for retry, got %s : %s.", tests.Failed, d.Status, d.Error)
+				}
+				t.Logf("\t%s\tDeliverPending retry ok.", tests.Success)
+			}
+		}
+	}
+}
+
+// TestIsAllowedIP validates deliveries can't be sent to loopback, private and link-local addresses.
+func TestIsAllowedIP(t *testing.T) {
+	var ipTests = []struct {
+		ip      string
+		allowed bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.17.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"fd00:ec2::254", false},
+		{"fe80::1", false},
+	}
+
+	t.Log("Given the need to only deliver to public addresses.")
+	{
+		for i, tt := range ipTests {
+			t.Logf("\tTest: %d\tWhen checking %s", i, tt.ip)
+			{
+				if got := IsAllowedIP(net.ParseIP(tt.ip)); got != tt.allowed {
+					t.Fatalf("\t%s\tExpected allowed to be %v, got %v.", tests.Failed, tt.allowed, got)
+				}
+
+				err := dialControl("tcp", net.JoinHostPort(tt.ip, "443"), nil)
+				if tt.allowed && err != nil {
+					t.Log("\t\tGot :", err)
+					t.Fatalf("\t%s\tExpected dial to be allowed.", tests.Failed)
+				} else if !tt.allowed && errors.Cause(err) != ErrAddressNotAllowed {
+					t.Logf("\t\tGot : %+v", err)
+					t.Logf("\t\tWant: %+v", ErrAddressNotAllowed)
+					t.Fatalf("\t%s\tExpected dial to be refused.", tests.Failed)
+				}
+				t.Logf("\t%s\tIsAllowedIP ok.", tests.Success)
+			}
+		}
+	}
+}
@@ -199,7 +199,20 @@ func Migrate(log *log.Logger, ctx context.Context, req *migrateRequest) error {
 		}
 		defer masterDb.Close()
 
-		// Start Migrations
+		// List the pending migrations, both SQL files and Go code are executed in order of their ID.
+		res, err := schema.Status(ctx, masterDb, log, false)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, m := range res {
+			if m.Modified {
+				return errors.Errorf("Migration %s has been modified after it was applied", m.ID)
+			} else if !m.Applied {
+				log.Printf("\t\tPending %s migration %s.", m.Kind, m.ID)
+			}
+		}
+
+		// Start Migrations. The migrations hold an advisory lock so concurrent deploys wait for each other.
 		log.Printf("\t\tStart migrations.")
 		if err = schema.Migrate(ctx, masterDb, log, false); err != nil {
 			return errors.WithStack(err)
@@ -1531,7 +1531,7 @@ func ServiceDeploy(log *log.Logger, ctx context.Context, req *serviceDeployReque
 			}
 			defer masterDb.Close()
 
-			// Start the database migrations.
+			// Start the database migrations. The migrations hold an advisory lock so concurrent deploys wait for each other.
 			log.Printf("\t\tStart migrations.")
 			if err = schema.Migrate(ctx, masterDb, log, false); err != nil {
 				return errors.WithStack(err)
@@ -2475,6 +2475,7 @@ func ServiceDeploy(log *log.Logger, ctx context.Context, req *serviceDeployReque
 			"{HTTP_HOST}":             "0.0.0.0:80",
 			"{HTTPS_HOST}":            "", // Not enabled by default
 			"{HTTPS_ENABLED}":         "false",
+			"{TRUSTED_PROXY_HOPS}":    "0",
 
 			"{APP_PROJECT}":  req.ProjectName,
 			"{APP_BASE_URL}": "", // Not set by default, requires a hostname to be defined.
@@ -2516,6 +2517,11 @@ func ServiceDeploy(log *log.Logger, ctx context.Context, req *serviceDeployReque
 			placeholders["{DATADOG_ESSENTIAL}"] = "false"
 		}
 
+		// The Elastic Load Balancer appends the IP address of the client to the X-Forwarded-For header.
+		if req.EnableEcsElb {
+			placeholders["{TRUSTED_PROXY_HOPS}"] = "1"
+		}
+
 		// For HTTPS support.
 		if req.EnableHTTPS {
 			placeholders["{HTTPS_ENABLED}"] = "true"
@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"expvar"
+	"fmt"
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/web/webcontext"
 	"log"
 	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"geeks-accelerator/oss/saas-starter-kit/internal/platform/flag"
@@ -15,6 +17,7 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	"github.com/lib/pq"
 	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
 	sqltrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql"
 	sqlxtrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/jmoiron/sqlx"
 )
@@ -37,12 +40,76 @@ type DB struct {
 	DisableTLS bool
 }
 
+// Commands supported by the program, up is executed when no command is provided.
+const (
+	cmdUp     = "up"
+	cmdDown   = "down"
+	cmdStatus = "status"
+)
+
+// command defines the command to execute with its options.
+type command struct {
+	Name   string
+	To     string
+	DryRun bool
+}
+
+// parseCommand removes the command and its options from the args. The remaining args are processed as config flags.
+func parseCommand(args []string) (command, []string, error) {
+	cmd := command{
+		Name: cmdUp,
+	}
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd.Name = args[0]
+		args = args[1:]
+	}
+
+	switch cmd.Name {
+	case cmdUp, cmdDown, cmdStatus:
+	default:
+		return cmd, nil, errors.Errorf("invalid command %q, expected %s, %s or %s", cmd.Name, cmdUp, cmdDown, cmdStatus)
+	}
+
+	var remaining []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--to":
+			if i+1 >= len(args) {
+				return cmd, nil, errors.New("--to requires a migration ID")
+			}
+			i++
+			cmd.To = args[i]
+		case "--dry-run":
+			cmd.DryRun = true
+		default:
+			remaining = append(remaining, args[i])
+		}
+	}
+
+	// Rolling back all the migrations by accident would drop all the data.
+	if cmd.Name == cmdDown && cmd.To == "" {
+		return cmd, nil, errors.New("--to is required to rollback migrations")
+	}
+
+	return cmd, remaining, nil
+}
+
 func main() {
 	// =========================================================================
 	// Logging
 
 	log := log.New(os.Stdout, service+" : ", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
 
+	// =========================================================================
+	// Command
+
+	cmd, args, err := parseCommand(os.Args[1:])
+	if err != nil {
+		log.Fatalf("main : Parsing Command : %v", err)
+	}
+	os.Args = append(os.Args[:1], args...)
+
 	// =========================================================================
 	// Configuration
 	var cfg struct {
@@ -67,6 +134,10 @@ func main() {
 		if err != flag.ErrHelp {
 			log.Fatalf("main : Parsing Command Line : %v", err)
 		}
+		fmt.Printf("\nCommands\n")
+		fmt.Printf("up [--to ID] [--dry-run]  : Execute the pending migrations, up to and including the migration ID.\n")
+		fmt.Printf("down --to ID [--dry-run]  : Rollback the migrations after the migration ID.\n")
+		fmt.Printf("status                    : List the SQL and Go migrations with if they have been applied.\n")
 		return // We displayed help.
 	}
 
@@ -136,9 +207,49 @@ func main() {
 	}
 	ctx := context.WithValue(context.Background(), webcontext.KeyValues, &v)
 
-	// Execute the migrations
-	if err = schema.Migrate(ctx, masterDb, log, false); err != nil {
-		log.Fatalf("main : Migrate : %v", err)
+	switch cmd.Name {
+	case cmdStatus:
+		res, err := schema.Status(ctx, masterDb, log, false)
+		if err != nil {
+			log.Fatalf("main : Status : %+v", err)
+		}
+
+		for _, m := range res {
+			status := "pending"
+			if m.Modified {
+				status = "modified"
+			} else if m.Applied {
+				status = "applied"
+			}
+			fmt.Printf("%-16s %-4s %s\n", m.ID, m.Kind, status)
+		}
+
+	case cmdDown:
+		if cmd.DryRun {
+			if err = schema.DryRunRollbackTo(ctx, masterDb, log, false, cmd.To, os.Stdout); err != nil {
+				log.Fatalf("main : Rollback : %+v", err)
+			}
+			return
+		}
+
+		// Rollback the migrations after the provided ID.
+		if err = schema.RollbackTo(ctx, masterDb, log, false, cmd.To); err != nil {
+			log.Fatalf("main : Rollback : %+v", err)
+		}
+		log.Printf("main : Rollback : Completed")
+
+	default:
+		if cmd.DryRun {
+			if err = schema.DryRunMigrateTo(ctx, masterDb, log, false, cmd.To, os.Stdout); err != nil {
+				log.Fatalf("main : Migrate : %+v", err)
+			}
+			return
+		}
+
+		// Execute the migrations
+		if err = schema.MigrateTo(ctx, masterDb, log, false, cmd.To); err != nil {
+			log.Fatalf("main : Migrate : %v", err)
+		}
+		log.Printf("main : Migrate : Completed")
 	}
-	log.Printf("main : Migrate : Completed")
 }